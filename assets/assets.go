@@ -8,7 +8,7 @@ import (
 //go:embed templates/*
 var templateFS embed.FS
 
-//go:embed emails/*.tmpl
+//go:embed emails/*.tmpl emails/partials/*.tmpl
 var emailFS embed.FS
 
 //go:embed dist/*
@@ -1 +1,2 @@
 import './main.css';
+import './passkeys.js';
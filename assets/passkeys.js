@@ -0,0 +1,72 @@
+// Passkey forms are submitted with the response of the authenticator. The form
+// has a data-passkey attribute with the ceremony ("register" or "login") and a
+// data-options attribute with the URL to get the options for the ceremony from.
+
+function decode(value) {
+  const base64 = value.replace(/-/g, '+').replace(/_/g, '/');
+  return Uint8Array.from(atob(base64), (c) => c.charCodeAt(0));
+}
+
+function encode(buffer) {
+  if (!buffer) {
+    return '';
+  }
+
+  const bytes = String.fromCharCode(...new Uint8Array(buffer));
+  return btoa(bytes).replace(/\+/g, '-').replace(/\//g, '_').replace(/=+$/, '');
+}
+
+async function register(options) {
+  options.challenge = decode(options.challenge);
+  options.user.id = decode(options.user.id);
+  options.excludeCredentials = options.excludeCredentials.map((c) => ({ ...c, id: decode(c.id) }));
+
+  const credential = await navigator.credentials.create({ publicKey: options });
+  return {
+    clientDataJSON: encode(credential.response.clientDataJSON),
+    attestationObject: encode(credential.response.attestationObject),
+  };
+}
+
+async function login(options) {
+  options.challenge = decode(options.challenge);
+
+  const credential = await navigator.credentials.get({ publicKey: options });
+  return {
+    credentialID: encode(credential.rawId),
+    clientDataJSON: encode(credential.response.clientDataJSON),
+    authenticatorData: encode(credential.response.authenticatorData),
+    signature: encode(credential.response.signature),
+    userHandle: encode(credential.response.userHandle),
+  };
+}
+
+const ceremonies = { register, login };
+
+document.querySelectorAll('form[data-passkey]').forEach((form) => {
+  if (!window.PublicKeyCredential) {
+    form.hidden = true;
+    return;
+  }
+
+  form.addEventListener('submit', async (event) => {
+    event.preventDefault();
+
+    try {
+      const res = await fetch(form.dataset.options, { credentials: 'same-origin' });
+      if (!res.ok) {
+        throw new Error(`failed to get passkey options: ${res.status}`);
+      }
+
+      const values = await ceremonies[form.dataset.passkey](await res.json());
+      Object.entries(values).forEach(([name, value]) => {
+        form.elements[name].value = value;
+      });
+
+      form.submit();
+    } catch (err) {
+      // The user cancelled or the authenticator failed, the form can be submitted again.
+      console.error(err);
+    }
+  });
+});
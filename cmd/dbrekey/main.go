@@ -0,0 +1,197 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"slices"
+	"strings"
+	"syscall"
+
+	"github.com/willemschots/househunt/internal/db"
+	"github.com/willemschots/househunt/internal/db/rekey"
+	"github.com/willemschots/househunt/internal/krypto"
+)
+
+const helpText = `Usage: dbrekey [-batch-size n] [-check index] [-check-salt index] [sqlite_file]
+
+Re-encrypts the values in all encrypted columns with the latest key in the
+DB_ENCRYPTION_KEYS environment variable and rebuilds all blind indexes with the
+latest salt in the DB_BLIND_INDEX_SALT environment variable. It reports the number
+of values per key and salt.
+
+Blind indexes without an encrypted source, like those of attempts, can't be rebuilt.
+Rows with such an index are left in place until the cleaner deletes them, unless their
+salt was retired, then they are deleted. Only rebuild blind indexes after the server
+uses the new salt, otherwise it keeps creating indexes with the old salt.
+
+With -check or -check-salt nothing is changed, instead the command fails if any value
+still uses the key or salt at the provided index. Once it succeeds, the key or salt can
+be retired by replacing it with "retired" in DB_ENCRYPTION_KEYS or DB_BLIND_INDEX_SALT.`
+
+func main() {
+	flags := flag.NewFlagSet("dbrekey", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprintln(os.Stderr, helpText)
+	}
+
+	batchSize := flags.Int("batch-size", 100, "number of rows updated per transaction")
+	check := flags.Int("check", -1, "index of the key to check")
+	checkSalt := flags.Int("check-salt", -1, "index of the salt to check")
+
+	_ = flags.Parse(os.Args[1:])
+	if flags.NArg() != 1 {
+		flags.Usage()
+		os.Exit(1)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	os.Exit(run(ctx, flags.Arg(0), *batchSize, *check, *checkSalt))
+}
+
+func run(ctx context.Context, dbFile string, batchSize, check, checkSalt int) int {
+	keys, err := keysFromEnv("DB_ENCRYPTION_KEYS")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "invalid env variable DB_ENCRYPTION_KEYS: %v\n", err)
+		return 1
+	}
+
+	encryptor, err := krypto.NewEncryptor(keys)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to create encryptor: %v\n", err)
+		return 1
+	}
+
+	salts, err := keysFromEnv("DB_BLIND_INDEX_SALT")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "invalid env variable DB_BLIND_INDEX_SALT: %v\n", err)
+		return 1
+	}
+
+	indexer, err := krypto.NewBlindIndexer(salts)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to create blind indexer: %v\n", err)
+		return 1
+	}
+
+	sqlDB, err := db.OpenSQLite(dbFile, true)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to open database: %v\n", err)
+		return 1
+	}
+
+	defer sqlDB.Close()
+
+	rekeyer, err := rekey.New(sqlDB, encryptor, indexer, batchSize)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to create rekeyer: %v\n", err)
+		return 1
+	}
+
+	// Only change data when nothing is being checked.
+	modify := check < 0 && checkSalt < 0
+
+	inUse := 0
+	for _, col := range rekey.Columns {
+		if modify {
+			p, err := rekeyer.Rekey(ctx, col, func(p rekey.Progress) {
+				fmt.Printf("%s: %d scanned, %d rekeyed\n", p.Column, p.Scanned, p.Rekeyed)
+			})
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "failed to rekey %s after %d rows: %v\n", col, p.Scanned, err)
+				return 1
+			}
+		}
+
+		usage, err := rekeyer.KeyUsage(ctx, col)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to get key usage of %s: %v\n", col, err)
+			return 1
+		}
+
+		printUsage(col, usage, "key")
+		inUse += usage[check]
+	}
+
+	saltInUse := 0
+	for _, idx := range rekey.BlindIndexes {
+		if modify {
+			p, err := rekeyer.RebuildBlindIndex(ctx, idx, func(p rekey.Progress) {
+				fmt.Printf("%s: %d scanned, %d rebuilt, %d deleted\n", p.Column, p.Scanned, p.Rekeyed, p.Deleted)
+			})
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "failed to rebuild %s after %d rows: %v\n", idx, p.Scanned, err)
+				return 1
+			}
+		}
+
+		usage, err := rekeyer.BlindIndexKeyUsage(ctx, idx)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to get salt usage of %s: %v\n", idx, err)
+			return 1
+		}
+
+		printUsage(idx.Column, usage, "salt")
+		saltInUse += usage[checkSalt]
+	}
+
+	code := 0
+	if check >= 0 {
+		code = max(code, reportCheck("key", check, inUse))
+	}
+
+	if checkSalt >= 0 {
+		code = max(code, reportCheck("salt", checkSalt, saltInUse))
+	}
+
+	return code
+}
+
+// printUsage prints the number of values per key index in col, what describes the key.
+func printUsage(col rekey.Column, usage map[int]int, what string) {
+	indexes := make([]int, 0, len(usage))
+	for index := range usage {
+		indexes = append(indexes, index)
+	}
+	slices.Sort(indexes)
+
+	for _, index := range indexes {
+		fmt.Printf("%s: %d values use %s %d\n", col, usage[index], what, index)
+	}
+}
+
+// reportCheck reports whether the key or salt at index can be retired and returns the exit code.
+func reportCheck(what string, index, inUse int) int {
+	if inUse > 0 {
+		fmt.Fprintf(os.Stderr, "%s %d is still used by %d values, it can't be retired\n", what, index, inUse)
+		return 1
+	}
+
+	fmt.Printf("%s %d is no longer used, it can be retired\n", what, index)
+	return 0
+}
+
+// keysFromEnv parses the keys in env variable name in the same format as the server does.
+func keysFromEnv(name string) ([]krypto.Key, error) {
+	val, ok := os.LookupEnv(name)
+	if !ok {
+		return nil, errors.New("missing required env variable")
+	}
+
+	var keys []krypto.Key
+	for i, raw := range strings.Split(val, ",") {
+		key, err := krypto.ParseEncryptionKey(raw)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse element %d: %w", i, err)
+		}
+
+		keys = append(keys, key)
+	}
+
+	return keys, nil
+}
@@ -6,15 +6,20 @@ import (
 	"math"
 	"net/url"
 	"os"
+	"slices"
 	"strconv"
 	"strings"
 	"time"
 
 	"github.com/willemschots/househunt/internal/auth"
 	"github.com/willemschots/househunt/internal/email"
+	"github.com/willemschots/househunt/internal/email/outbox"
 	"github.com/willemschots/househunt/internal/email/postmark"
+	"github.com/willemschots/househunt/internal/email/retry"
+	"github.com/willemschots/househunt/internal/email/smtp"
 	"github.com/willemschots/househunt/internal/krypto"
 	"github.com/willemschots/househunt/internal/web"
+	"github.com/willemschots/househunt/internal/web/ratelimit"
 )
 
 // httpConfig is the configuration for the HTTP server.
@@ -37,21 +42,42 @@ type dbConfig struct {
 	file           string
 	migrate        bool
 	encryptionKeys []krypto.Key
-	blindIndexSalt krypto.Key
+	// blindIndexSalts are the keys used for blind indexes, the last one is used for new indexes.
+	blindIndexSalts []krypto.Key
 }
 
 type emailConfig struct {
-	driver   string
-	service  email.ServiceConfig
+	driver  string
+	service email.ServiceConfig
+	outbox  outbox.DispatcherConfig
+	// retry configures the retries and circuit breaker around every email driver.
+	retry    retry.Config
 	postmark postmark.Settings
+	smtp     smtp.Settings
+	// fileDir is the directory the file driver writes emails to.
+	fileDir string
+}
+
+// emailDrivers are the supported values of EMAIL_DRIVER.
+var emailDrivers = []string{"log", "postmark", "smtp", "file"}
+
+// passwordsConfig configures which passwords are rejected, in addition to passwords
+// containing the email address of the user.
+type passwordsConfig struct {
+	// commonFile is a list of common passwords, one per line. Optional.
+	commonFile string
+	// breachedFile is a list of SHA-1 prefixes of breached passwords, one per line. Optional.
+	breachedFile string
 }
 
 // config is the configuration for the server command.
 type config struct {
-	http  httpConfig
-	db    dbConfig
-	auth  auth.ServiceConfig
-	email emailConfig
+	http      httpConfig
+	db        dbConfig
+	auth      auth.ServiceConfig
+	passwords passwordsConfig
+	cleaner   auth.CleanerConfig
+	email     emailConfig
 }
 
 // defaultConfig returns a config with sane default values.
@@ -67,6 +93,14 @@ func defaultConfig() config {
 			shutdownTimeout: time.Second * 15,
 			server: web.ServerConfig{
 				SecureCookie: true,
+				RateLimit: ratelimit.Config{
+					MaxAttempts: 20,
+					Window:      time.Minute * 10,
+					Lockout:     time.Minute * 15,
+				},
+				PostmarkWebhook: postmark.WebhookSettings{
+					Username: "postmark",
+				},
 			},
 			viewDir: "",
 		},
@@ -75,18 +109,55 @@ func defaultConfig() config {
 			migrate: true,
 		},
 		auth: auth.ServiceConfig{
-			WorkerTimeout: time.Second * 30,
-			TokenExpiry:   time.Minute * 30,
+			WorkerTimeout:        time.Second * 30,
+			TokenExpiry:          time.Minute * 30,
+			MaxOutstandingTokens: 3,
+			TokenCooldown:        time.Minute,
+			MaxLoginFailures:     5,
+			LoginLockout:         time.Minute * 15,
+			MaxPasswordResets:    3,
+			PasswordResetWindow:  time.Hour,
+			PasswordHashing:      krypto.DefaultArgon2Params(),
+			TOTPIssuer:           "Househunt",
+		},
+		cleaner: auth.CleanerConfig{
+			Interval:              time.Hour,
+			TokenRetention:        time.Hour * 24 * 7,
+			InactiveUserRetention: time.Hour * 24 * 30,
+			AttemptRetention:      time.Hour * 24,
 		},
 		email: emailConfig{
 			driver: "log",
 			service: email.ServiceConfig{
 				BaseURL: baseURL,
 			},
+			outbox: outbox.DispatcherConfig{
+				Interval:    time.Second * 5,
+				BatchSize:   50,
+				MaxAttempts: 8,
+				MinBackoff:  time.Second * 30,
+				MaxBackoff:  time.Hour,
+				Retention:   time.Hour * 24 * 7,
+			},
+			retry: retry.Config{
+				MaxAttempts:      3,
+				MinBackoff:       time.Millisecond * 500,
+				MaxBackoff:       time.Second * 5,
+				FailureThreshold: 5,
+				Cooldown:         time.Minute,
+			},
 			postmark: postmark.Settings{
 				APIURL:        must(url.Parse("https://api.postmarkapp.com/email")),
 				MessageStream: "outbound",
 			},
+			smtp: smtp.Settings{
+				Port:           587,
+				TLS:            smtp.TLSModeStartTLS,
+				Auth:           smtp.AuthPlain,
+				ConnectTimeout: time.Second * 10,
+				Timeout:        time.Second * 30,
+			},
+			fileDir: "mailbox",
 		},
 	}
 }
@@ -148,6 +219,21 @@ var envMap = map[string]envVariable{
 			return confCryptoKey(v, &c.http.server.CSRFKey)
 		},
 	},
+	"HTTP_RATE_LIMIT_MAX_ATTEMPTS": {
+		mapFunc: func(v string, c *config) error {
+			return confInt(v, &c.http.server.RateLimit.MaxAttempts, 0, math.MaxInt)
+		},
+	},
+	"HTTP_RATE_LIMIT_WINDOW": {
+		mapFunc: func(v string, c *config) error {
+			return confDuration(v, &c.http.server.RateLimit.Window, 0, math.MaxInt64)
+		},
+	},
+	"HTTP_RATE_LIMIT_LOCKOUT": {
+		mapFunc: func(v string, c *config) error {
+			return confDuration(v, &c.http.server.RateLimit.Lockout, 0, math.MaxInt64)
+		},
+	},
 	"HTTP_VIEW_DIR": {
 		mapFunc: func(v string, c *config) error {
 			return confString(v, &c.http.viewDir, 0, math.MaxInt64)
@@ -166,13 +252,13 @@ var envMap = map[string]envVariable{
 	"DB_BLIND_INDEX_SALT": {
 		required: true,
 		mapFunc: func(v string, c *config) error {
-			return confCryptoKey(v, &c.db.blindIndexSalt)
+			return confSliceOf(v, &c.db.blindIndexSalts, krypto.ParseEncryptionKey, 2, math.MaxInt64)
 		},
 	},
 	"DB_ENCRYPTION_KEYS": {
 		required: true,
 		mapFunc: func(v string, c *config) error {
-			return confSliceOf(v, &c.db.encryptionKeys, krypto.ParseKey, 2, math.MaxInt64)
+			return confSliceOf(v, &c.db.encryptionKeys, krypto.ParseEncryptionKey, 2, math.MaxInt64)
 		},
 	},
 	"AUTH_WORKER_TIMEOUT": {
@@ -185,18 +271,158 @@ var envMap = map[string]envVariable{
 			return confDuration(v, &c.auth.TokenExpiry, 0, math.MaxInt64)
 		},
 	},
-	"EMAIL_DRIVER": {
+	"AUTH_MAX_OUTSTANDING_TOKENS": {
+		mapFunc: func(v string, c *config) error {
+			return confInt(v, &c.auth.MaxOutstandingTokens, 0, math.MaxInt)
+		},
+	},
+	"AUTH_TOKEN_COOLDOWN": {
+		mapFunc: func(v string, c *config) error {
+			return confDuration(v, &c.auth.TokenCooldown, 0, math.MaxInt64)
+		},
+	},
+	"AUTH_MAX_LOGIN_FAILURES": {
+		mapFunc: func(v string, c *config) error {
+			return confInt(v, &c.auth.MaxLoginFailures, 0, math.MaxInt)
+		},
+	},
+	"AUTH_LOGIN_LOCKOUT": {
+		mapFunc: func(v string, c *config) error {
+			return confDuration(v, &c.auth.LoginLockout, 0, math.MaxInt64)
+		},
+	},
+	"AUTH_MAX_PASSWORD_RESETS": {
+		mapFunc: func(v string, c *config) error {
+			return confInt(v, &c.auth.MaxPasswordResets, 0, math.MaxInt)
+		},
+	},
+	"AUTH_PASSWORD_RESET_WINDOW": {
+		mapFunc: func(v string, c *config) error {
+			return confDuration(v, &c.auth.PasswordResetWindow, 0, math.MaxInt64)
+		},
+	},
+	"AUTH_PASSWORD_MEMORY_KIB": {
+		mapFunc: func(v string, c *config) error {
+			return confUint(v, &c.auth.PasswordHashing.MemoryKiB, 8, math.MaxUint32)
+		},
+	},
+	"AUTH_PASSWORD_ITERATIONS": {
+		mapFunc: func(v string, c *config) error {
+			return confUint(v, &c.auth.PasswordHashing.Iterations, 1, math.MaxUint32)
+		},
+	},
+	"AUTH_PASSWORD_PARALLELISM": {
 		mapFunc: func(v string, c *config) error {
-			c.email.driver = v // validated later on.
+			return confUint(v, &c.auth.PasswordHashing.Parallelism, 1, math.MaxUint8)
+		},
+	},
+	"AUTH_COMMON_PASSWORDS_FILE": {
+		mapFunc: func(v string, c *config) error {
+			return confString(v, &c.passwords.commonFile, 1, math.MaxInt64)
+		},
+	},
+	"AUTH_BREACHED_PASSWORDS_FILE": {
+		mapFunc: func(v string, c *config) error {
+			return confString(v, &c.passwords.breachedFile, 1, math.MaxInt64)
+		},
+	},
+	"AUTH_TOTP_ISSUER": {
+		mapFunc: func(v string, c *config) error {
+			c.auth.TOTPIssuer = v
 			return nil
 		},
 	},
+	"AUTH_CLEANUP_INTERVAL": {
+		mapFunc: func(v string, c *config) error {
+			return confDuration(v, &c.cleaner.Interval, time.Millisecond, math.MaxInt64)
+		},
+	},
+	"AUTH_TOKEN_RETENTION": {
+		mapFunc: func(v string, c *config) error {
+			return confDuration(v, &c.cleaner.TokenRetention, 0, math.MaxInt64)
+		},
+	},
+	"AUTH_INACTIVE_USER_RETENTION": {
+		mapFunc: func(v string, c *config) error {
+			return confDuration(v, &c.cleaner.InactiveUserRetention, 0, math.MaxInt64)
+		},
+	},
+	"AUTH_ATTEMPT_RETENTION": {
+		mapFunc: func(v string, c *config) error {
+			return confDuration(v, &c.cleaner.AttemptRetention, 0, math.MaxInt64)
+		},
+	},
+	"EMAIL_DRIVER": {
+		mapFunc: func(v string, c *config) error {
+			return confOneOf(v, &c.email.driver, emailDrivers...)
+		},
+	},
+	"EMAIL_FILE_DIR": {
+		mapFunc: func(v string, c *config) error {
+			return confString(v, &c.email.fileDir, 1, math.MaxInt64)
+		},
+	},
 	"EMAIL_FROM": {
 		required: true,
 		mapFunc: func(v string, c *config) error {
 			return confEmailAddress(v, &c.email.service.From)
 		},
 	},
+	"EMAIL_OUTBOX_INTERVAL": {
+		mapFunc: func(v string, c *config) error {
+			return confDuration(v, &c.email.outbox.Interval, time.Millisecond, math.MaxInt64)
+		},
+	},
+	"EMAIL_OUTBOX_BATCH_SIZE": {
+		mapFunc: func(v string, c *config) error {
+			return confInt(v, &c.email.outbox.BatchSize, 1, math.MaxInt)
+		},
+	},
+	"EMAIL_OUTBOX_MAX_ATTEMPTS": {
+		mapFunc: func(v string, c *config) error {
+			return confInt(v, &c.email.outbox.MaxAttempts, 1, math.MaxInt)
+		},
+	},
+	"EMAIL_OUTBOX_MIN_BACKOFF": {
+		mapFunc: func(v string, c *config) error {
+			return confDuration(v, &c.email.outbox.MinBackoff, 0, math.MaxInt64)
+		},
+	},
+	"EMAIL_OUTBOX_MAX_BACKOFF": {
+		mapFunc: func(v string, c *config) error {
+			return confDuration(v, &c.email.outbox.MaxBackoff, 0, math.MaxInt64)
+		},
+	},
+	"EMAIL_OUTBOX_RETENTION": {
+		mapFunc: func(v string, c *config) error {
+			return confDuration(v, &c.email.outbox.Retention, 0, math.MaxInt64)
+		},
+	},
+	"EMAIL_RETRY_MAX_ATTEMPTS": {
+		mapFunc: func(v string, c *config) error {
+			return confInt(v, &c.email.retry.MaxAttempts, 1, math.MaxInt)
+		},
+	},
+	"EMAIL_RETRY_MIN_BACKOFF": {
+		mapFunc: func(v string, c *config) error {
+			return confDuration(v, &c.email.retry.MinBackoff, 0, math.MaxInt64)
+		},
+	},
+	"EMAIL_RETRY_MAX_BACKOFF": {
+		mapFunc: func(v string, c *config) error {
+			return confDuration(v, &c.email.retry.MaxBackoff, 0, math.MaxInt64)
+		},
+	},
+	"EMAIL_CIRCUIT_FAILURE_THRESHOLD": {
+		mapFunc: func(v string, c *config) error {
+			return confInt(v, &c.email.retry.FailureThreshold, 1, math.MaxInt)
+		},
+	},
+	"EMAIL_CIRCUIT_COOLDOWN": {
+		mapFunc: func(v string, c *config) error {
+			return confDuration(v, &c.email.retry.Cooldown, 0, math.MaxInt64)
+		},
+	},
 	"POSTMARK_API_URL": {
 		mapFunc: func(v string, c *config) error {
 			return confURL(v, c.email.postmark.APIURL)
@@ -213,6 +439,64 @@ var envMap = map[string]envVariable{
 			return confSecret(v, &c.email.postmark.ServerToken)
 		},
 	},
+	"POSTMARK_WEBHOOK_USERNAME": {
+		mapFunc: func(v string, c *config) error {
+			return confString(v, &c.http.server.PostmarkWebhook.Username, 1, 255)
+		},
+	},
+	// The Postmark webhook is only enabled if a password is set.
+	"POSTMARK_WEBHOOK_PASSWORD": {
+		mapFunc: func(v string, c *config) error {
+			return confSecret(v, &c.http.server.PostmarkWebhook.Password)
+		},
+	},
+	"SMTP_HOST": {
+		mapFunc: func(v string, c *config) error {
+			return confString(v, &c.email.smtp.Host, 1, 255)
+		},
+	},
+	"SMTP_PORT": {
+		mapFunc: func(v string, c *config) error {
+			return confInt(v, &c.email.smtp.Port, 1, 65535)
+		},
+	},
+	"SMTP_TLS": {
+		mapFunc: func(v string, c *config) error {
+			return confOneOf(v, &c.email.smtp.TLS, smtp.TLSModes()...)
+		},
+	},
+	"SMTP_USERNAME": {
+		mapFunc: func(v string, c *config) error {
+			c.email.smtp.Username = v
+			return nil
+		},
+	},
+	"SMTP_PASSWORD": {
+		mapFunc: func(v string, c *config) error {
+			return confSecret(v, &c.email.smtp.Password)
+		},
+	},
+	"SMTP_AUTH": {
+		mapFunc: func(v string, c *config) error {
+			return confOneOf(v, &c.email.smtp.Auth, smtp.AuthMechanisms()...)
+		},
+	},
+	"SMTP_LOCAL_NAME": {
+		mapFunc: func(v string, c *config) error {
+			c.email.smtp.LocalName = v
+			return nil
+		},
+	},
+	"SMTP_CONNECT_TIMEOUT": {
+		mapFunc: func(v string, c *config) error {
+			return confDuration(v, &c.email.smtp.ConnectTimeout, time.Millisecond, math.MaxInt64)
+		},
+	},
+	"SMTP_TIMEOUT": {
+		mapFunc: func(v string, c *config) error {
+			return confDuration(v, &c.email.smtp.Timeout, time.Millisecond, math.MaxInt64)
+		},
+	},
 }
 
 // configFromEnv returns a config with values from the environment. It falls
@@ -275,6 +559,39 @@ func confDuration(v string, tgt *time.Duration, min, max time.Duration) error {
 	return nil
 }
 
+// confInt attempts to parse v into tgt and checks if the result is in
+// the provided range (inclusive).
+func confInt(v string, tgt *int, min, max int) error {
+	i, err := strconv.Atoi(v)
+	if err != nil {
+		return err
+	}
+
+	if i < min || i > max {
+		return fmt.Errorf("int %d not in range [%d, %d] (inclusive)", i, min, max)
+	}
+
+	*tgt = i
+
+	return nil
+}
+
+// confUint attempts to parse v into tgt as an unsigned integer between min and max (inclusive).
+func confUint[T uint8 | uint32](v string, tgt *T, min, max T) error {
+	i, err := strconv.ParseUint(v, 10, 64)
+	if err != nil {
+		return err
+	}
+
+	if i < uint64(min) || i > uint64(max) {
+		return fmt.Errorf("value %d not in range [%d, %d] (inclusive)", i, min, max)
+	}
+
+	*tgt = T(i)
+
+	return nil
+}
+
 func confString(v string, tgt *string, minLen, maxLen int) error {
 	if len(v) < minLen || len(v) > maxLen {
 		return fmt.Errorf("string length %d not in range [%d, %d] (inclusive)", len(v), minLen, maxLen)
@@ -285,6 +602,17 @@ func confString(v string, tgt *string, minLen, maxLen int) error {
 	return nil
 }
 
+// confOneOf checks if v is one of the provided options before setting it on tgt.
+func confOneOf[T ~string](v string, tgt *T, options ...T) error {
+	if !slices.Contains(options, T(v)) {
+		return fmt.Errorf("%q is not one of %v", v, options)
+	}
+
+	*tgt = T(v)
+
+	return nil
+}
+
 // confDuration attempts to parse v into tgt as a bool.
 func confBool(v string, tgt *bool) error {
 	b, err := strconv.ParseBool(v)
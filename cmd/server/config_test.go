@@ -10,6 +10,7 @@ import (
 	"time"
 
 	"github.com/willemschots/househunt/internal/email"
+	"github.com/willemschots/househunt/internal/email/smtp"
 	"github.com/willemschots/househunt/internal/krypto"
 )
 
@@ -30,7 +31,9 @@ func newConfig(mf func(*config)) config {
 		must(krypto.ParseKey("d503685b5e0848dcd1026711a5d92e8a087dfaffa489fb563e0de73db2f2476c")),
 	}
 	c.http.server.CSRFKey = must(krypto.ParseKey("dfab77e26917c6e37a173690443a0016808ef7b24e32424d45cd83454198a6ec"))
-	c.db.blindIndexSalt = must(krypto.ParseKey("b61115eeb1bdf0847f1d7ea978c7da71e3b31361f7450bc8aa12566a16b7b03f"))
+	c.db.blindIndexSalts = []krypto.Key{
+		must(krypto.ParseKey("b61115eeb1bdf0847f1d7ea978c7da71e3b31361f7450bc8aa12566a16b7b03f")),
+	}
 	c.db.encryptionKeys = []krypto.Key{
 		must(krypto.ParseKey("2b671594b775f371eab4050b4d58326682df6b1a6cc2e886717b1a26b4d6c45d")),
 	}
@@ -111,6 +114,15 @@ func TestConfigFromEnv(t *testing.T) {
 				c.http.server.CSRFKey = must(krypto.ParseKey("218dbd640d2ae9bd7a81e45f1ad963ecea3027fea21b9c3b93ca3ad69915f733"))
 			},
 		},
+		"ok, non-default HTTP_RATE_LIMIT_MAX_ATTEMPTS": {
+			key: "HTTP_RATE_LIMIT_MAX_ATTEMPTS", val: "5", mf: func(c *config) { c.http.server.RateLimit.MaxAttempts = 5 },
+		},
+		"ok, non-default HTTP_RATE_LIMIT_WINDOW": {
+			key: "HTTP_RATE_LIMIT_WINDOW", val: "1m", mf: func(c *config) { c.http.server.RateLimit.Window = time.Minute },
+		},
+		"ok, non-default HTTP_RATE_LIMIT_LOCKOUT": {
+			key: "HTTP_RATE_LIMIT_LOCKOUT", val: "1h", mf: func(c *config) { c.http.server.RateLimit.Lockout = time.Hour },
+		},
 		"ok, non-default HTTP_VIEW_DIR": {
 			key: "HTTP_VIEW_DIR", val: "./test", mf: func(c *config) { c.http.viewDir = "./test" },
 		},
@@ -124,7 +136,20 @@ func TestConfigFromEnv(t *testing.T) {
 			key: "DB_BLIND_INDEX_SALT",
 			val: "d1d92ba246dc05e7c1e935dd52d02272a218c7ea2ed514d1f68e7baa5f861ddd",
 			mf: func(c *config) {
-				c.db.blindIndexSalt = must(krypto.ParseKey("d1d92ba246dc05e7c1e935dd52d02272a218c7ea2ed514d1f68e7baa5f861ddd"))
+				c.db.blindIndexSalts = []krypto.Key{
+					must(krypto.ParseKey("d1d92ba246dc05e7c1e935dd52d02272a218c7ea2ed514d1f68e7baa5f861ddd")),
+				}
+			},
+		},
+		"ok, multiple DB_BLIND_INDEX_SALT": {
+			key: "DB_BLIND_INDEX_SALT",
+			val: "retired,b61115eeb1bdf0847f1d7ea978c7da71e3b31361f7450bc8aa12566a16b7b03f,d1d92ba246dc05e7c1e935dd52d02272a218c7ea2ed514d1f68e7baa5f861ddd",
+			mf: func(c *config) {
+				c.db.blindIndexSalts = []krypto.Key{
+					must(krypto.ParseEncryptionKey(krypto.RetiredKey)),
+					must(krypto.ParseKey("b61115eeb1bdf0847f1d7ea978c7da71e3b31361f7450bc8aa12566a16b7b03f")),
+					must(krypto.ParseKey("d1d92ba246dc05e7c1e935dd52d02272a218c7ea2ed514d1f68e7baa5f861ddd")),
+				}
 			},
 		},
 		"ok, multiple DB_ENCRYPTION_KEYS": {
@@ -137,12 +162,70 @@ func TestConfigFromEnv(t *testing.T) {
 				}
 			},
 		},
+		"ok, retired DB_ENCRYPTION_KEYS": {
+			key: "DB_ENCRYPTION_KEYS",
+			val: "retired,cf55b868d8c7a640265365910093113edce9b6c9226f3bd7c87987d23062d421",
+			mf: func(c *config) {
+				c.db.encryptionKeys = []krypto.Key{
+					must(krypto.ParseEncryptionKey(krypto.RetiredKey)),
+					must(krypto.ParseKey("cf55b868d8c7a640265365910093113edce9b6c9226f3bd7c87987d23062d421")),
+				}
+			},
+		},
 		"ok, non-default AUTH_WORKER_TIMEOUT": {
 			key: "AUTH_WORKER_TIMEOUT", val: "42s", mf: func(c *config) { c.auth.WorkerTimeout = 42 * time.Second },
 		},
 		"ok, non-default AUTH_TOKEN_EXPIRY": {
 			key: "AUTH_TOKEN_EXPIRY", val: "51m", mf: func(c *config) { c.auth.TokenExpiry = 51 * time.Minute },
 		},
+		"ok, non-default AUTH_MAX_OUTSTANDING_TOKENS": {
+			key: "AUTH_MAX_OUTSTANDING_TOKENS", val: "0", mf: func(c *config) { c.auth.MaxOutstandingTokens = 0 },
+		},
+		"ok, non-default AUTH_TOKEN_COOLDOWN": {
+			key: "AUTH_TOKEN_COOLDOWN", val: "5m", mf: func(c *config) { c.auth.TokenCooldown = 5 * time.Minute },
+		},
+		"ok, non-default AUTH_MAX_LOGIN_FAILURES": {
+			key: "AUTH_MAX_LOGIN_FAILURES", val: "0", mf: func(c *config) { c.auth.MaxLoginFailures = 0 },
+		},
+		"ok, non-default AUTH_LOGIN_LOCKOUT": {
+			key: "AUTH_LOGIN_LOCKOUT", val: "1h", mf: func(c *config) { c.auth.LoginLockout = time.Hour },
+		},
+		"ok, non-default AUTH_MAX_PASSWORD_RESETS": {
+			key: "AUTH_MAX_PASSWORD_RESETS", val: "10", mf: func(c *config) { c.auth.MaxPasswordResets = 10 },
+		},
+		"ok, non-default AUTH_PASSWORD_RESET_WINDOW": {
+			key: "AUTH_PASSWORD_RESET_WINDOW", val: "24h", mf: func(c *config) { c.auth.PasswordResetWindow = 24 * time.Hour },
+		},
+		"ok, non-default AUTH_PASSWORD_MEMORY_KIB": {
+			key: "AUTH_PASSWORD_MEMORY_KIB", val: "65536", mf: func(c *config) { c.auth.PasswordHashing.MemoryKiB = 65536 },
+		},
+		"ok, non-default AUTH_PASSWORD_ITERATIONS": {
+			key: "AUTH_PASSWORD_ITERATIONS", val: "3", mf: func(c *config) { c.auth.PasswordHashing.Iterations = 3 },
+		},
+		"ok, non-default AUTH_PASSWORD_PARALLELISM": {
+			key: "AUTH_PASSWORD_PARALLELISM", val: "4", mf: func(c *config) { c.auth.PasswordHashing.Parallelism = 4 },
+		},
+		"ok, non-default AUTH_COMMON_PASSWORDS_FILE": {
+			key: "AUTH_COMMON_PASSWORDS_FILE", val: "common.txt", mf: func(c *config) { c.passwords.commonFile = "common.txt" },
+		},
+		"ok, non-default AUTH_BREACHED_PASSWORDS_FILE": {
+			key: "AUTH_BREACHED_PASSWORDS_FILE", val: "breached.txt", mf: func(c *config) { c.passwords.breachedFile = "breached.txt" },
+		},
+		"ok, non-default AUTH_TOTP_ISSUER": {
+			key: "AUTH_TOTP_ISSUER", val: "Example", mf: func(c *config) { c.auth.TOTPIssuer = "Example" },
+		},
+		"ok, non-default AUTH_CLEANUP_INTERVAL": {
+			key: "AUTH_CLEANUP_INTERVAL", val: "5m", mf: func(c *config) { c.cleaner.Interval = 5 * time.Minute },
+		},
+		"ok, non-default AUTH_TOKEN_RETENTION": {
+			key: "AUTH_TOKEN_RETENTION", val: "48h", mf: func(c *config) { c.cleaner.TokenRetention = 48 * time.Hour },
+		},
+		"ok, non-default AUTH_INACTIVE_USER_RETENTION": {
+			key: "AUTH_INACTIVE_USER_RETENTION", val: "0s", mf: func(c *config) { c.cleaner.InactiveUserRetention = 0 },
+		},
+		"ok, non-default AUTH_ATTEMPT_RETENTION": {
+			key: "AUTH_ATTEMPT_RETENTION", val: "48h", mf: func(c *config) { c.cleaner.AttemptRetention = 48 * time.Hour },
+		},
 		"ok, non-default EMAIL_DRIVER": {
 			key: "EMAIL_DRIVER",
 			val: "postmark",
@@ -150,6 +233,9 @@ func TestConfigFromEnv(t *testing.T) {
 				c.email.driver = "postmark"
 			},
 		},
+		"ok, other EMAIL_FILE_DIR": {
+			key: "EMAIL_FILE_DIR", val: "/tmp/mailbox", mf: func(c *config) { c.email.fileDir = "/tmp/mailbox" },
+		},
 		"ok, other EMAIL_FROM": {
 			key: "EMAIL_FROM",
 			val: "test@example.com",
@@ -157,6 +243,39 @@ func TestConfigFromEnv(t *testing.T) {
 				c.email.service.From = must(email.ParseAddress("test@example.com"))
 			},
 		},
+		"ok, non-default EMAIL_OUTBOX_INTERVAL": {
+			key: "EMAIL_OUTBOX_INTERVAL", val: "2s", mf: func(c *config) { c.email.outbox.Interval = 2 * time.Second },
+		},
+		"ok, non-default EMAIL_OUTBOX_BATCH_SIZE": {
+			key: "EMAIL_OUTBOX_BATCH_SIZE", val: "10", mf: func(c *config) { c.email.outbox.BatchSize = 10 },
+		},
+		"ok, non-default EMAIL_OUTBOX_MAX_ATTEMPTS": {
+			key: "EMAIL_OUTBOX_MAX_ATTEMPTS", val: "3", mf: func(c *config) { c.email.outbox.MaxAttempts = 3 },
+		},
+		"ok, non-default EMAIL_OUTBOX_MIN_BACKOFF": {
+			key: "EMAIL_OUTBOX_MIN_BACKOFF", val: "5s", mf: func(c *config) { c.email.outbox.MinBackoff = 5 * time.Second },
+		},
+		"ok, non-default EMAIL_OUTBOX_MAX_BACKOFF": {
+			key: "EMAIL_OUTBOX_MAX_BACKOFF", val: "10m", mf: func(c *config) { c.email.outbox.MaxBackoff = 10 * time.Minute },
+		},
+		"ok, non-default EMAIL_OUTBOX_RETENTION": {
+			key: "EMAIL_OUTBOX_RETENTION", val: "0s", mf: func(c *config) { c.email.outbox.Retention = 0 },
+		},
+		"ok, non-default EMAIL_RETRY_MAX_ATTEMPTS": {
+			key: "EMAIL_RETRY_MAX_ATTEMPTS", val: "1", mf: func(c *config) { c.email.retry.MaxAttempts = 1 },
+		},
+		"ok, non-default EMAIL_RETRY_MIN_BACKOFF": {
+			key: "EMAIL_RETRY_MIN_BACKOFF", val: "1s", mf: func(c *config) { c.email.retry.MinBackoff = time.Second },
+		},
+		"ok, non-default EMAIL_RETRY_MAX_BACKOFF": {
+			key: "EMAIL_RETRY_MAX_BACKOFF", val: "30s", mf: func(c *config) { c.email.retry.MaxBackoff = 30 * time.Second },
+		},
+		"ok, non-default EMAIL_CIRCUIT_FAILURE_THRESHOLD": {
+			key: "EMAIL_CIRCUIT_FAILURE_THRESHOLD", val: "10", mf: func(c *config) { c.email.retry.FailureThreshold = 10 },
+		},
+		"ok, non-default EMAIL_CIRCUIT_COOLDOWN": {
+			key: "EMAIL_CIRCUIT_COOLDOWN", val: "5m", mf: func(c *config) { c.email.retry.Cooldown = 5 * time.Minute },
+		},
 		"ok, non-default POSTMARK_API_URL": {
 			key: "POSTMARK_API_URL",
 			val: "https://example.com",
@@ -178,6 +297,47 @@ func TestConfigFromEnv(t *testing.T) {
 				c.email.postmark.ServerToken = krypto.NewSecret("testToken")
 			},
 		},
+		"ok, other POSTMARK_WEBHOOK_USERNAME": {
+			key: "POSTMARK_WEBHOOK_USERNAME",
+			val: "hooks",
+			mf: func(c *config) {
+				c.http.server.PostmarkWebhook.Username = "hooks"
+			},
+		},
+		"ok, other POSTMARK_WEBHOOK_PASSWORD": {
+			key: "POSTMARK_WEBHOOK_PASSWORD",
+			val: "testPassword",
+			mf: func(c *config) {
+				c.http.server.PostmarkWebhook.Password = krypto.NewSecret("testPassword")
+			},
+		},
+		"ok, other SMTP_HOST": {
+			key: "SMTP_HOST", val: "smtp.example.com", mf: func(c *config) { c.email.smtp.Host = "smtp.example.com" },
+		},
+		"ok, non-default SMTP_PORT": {
+			key: "SMTP_PORT", val: "465", mf: func(c *config) { c.email.smtp.Port = 465 },
+		},
+		"ok, non-default SMTP_TLS": {
+			key: "SMTP_TLS", val: "tls", mf: func(c *config) { c.email.smtp.TLS = smtp.TLSModeImplicit },
+		},
+		"ok, other SMTP_USERNAME": {
+			key: "SMTP_USERNAME", val: "househunt", mf: func(c *config) { c.email.smtp.Username = "househunt" },
+		},
+		"ok, other SMTP_PASSWORD": {
+			key: "SMTP_PASSWORD", val: "testPassword", mf: func(c *config) { c.email.smtp.Password = krypto.NewSecret("testPassword") },
+		},
+		"ok, non-default SMTP_AUTH": {
+			key: "SMTP_AUTH", val: "login", mf: func(c *config) { c.email.smtp.Auth = smtp.AuthLogin },
+		},
+		"ok, other SMTP_LOCAL_NAME": {
+			key: "SMTP_LOCAL_NAME", val: "mail.example.com", mf: func(c *config) { c.email.smtp.LocalName = "mail.example.com" },
+		},
+		"ok, non-default SMTP_CONNECT_TIMEOUT": {
+			key: "SMTP_CONNECT_TIMEOUT", val: "5s", mf: func(c *config) { c.email.smtp.ConnectTimeout = 5 * time.Second },
+		},
+		"ok, non-default SMTP_TIMEOUT": {
+			key: "SMTP_TIMEOUT", val: "1m", mf: func(c *config) { c.email.smtp.Timeout = time.Minute },
+		},
 	}
 
 	for name, tc := range valid {
@@ -206,23 +366,61 @@ func TestConfigFromEnv(t *testing.T) {
 		key string
 		val string
 	}{
-		"fail, no host in BASE_URL":            {"BASE_URL", "/just-a-path"},
-		"fail, negative HTTP_READ_TIMEOUT":     {"HTTP_READ_TIMEOUT", "-1ms"},
-		"fail, negative HTTP_WRITE_TIMEOUT":    {"HTTP_WRITE_TIMEOUT", "-1ms"},
-		"fail, negative HTTP_IDLE_TIMEOUT":     {"HTTP_IDLE_TIMEOUT", "-1ms"},
-		"fail, negative HTTP_SHUTDOWN_TIMEOUT": {"HTTP_SHUTDOWN_TIMEOUT", "-1ms"},
-		"fail, invalid HTTP_COOKIE_KEYS":       {"HTTP_COOKIE_KEYS", "abc"},
-		"fail, invalid HTTP_SECURE_COOKIE":     {"HTTP_SECURE_COOKIE", "abc"},
-		"fail, invalid HTTP_CSRF_KEY":          {"HTTP_CSRF_KEY", "abc"},
-		"fail, empty DB_FILENAME":              {"DB_FILENAME", ""},
-		"fail, invalid DB_MIGRATE":             {"DB_MIGRATE", "no!"},
-		"fail, invalid DB_BLIND_INDEX_SALT":    {"DB_BLIND_INDEX_SALT", "abc"},
-		"fail, empty DB_ENCRYPTION_KEYS":       {"DB_ENCRYPTION_KEYS", ""},
-		"fail, invalid DB_ENCRYPTION_KEYS":     {"DB_ENCRYPTION_KEYS", "abc"},
-		"fail, negative AUTH_WORKER_TIMEOUT":   {"AUTH_WORKER_TIMEOUT", "-1ms"},
-		"fail, negative AUTH_TOKEN_EXPIRY":     {"AUTH_TOKEN_EXPIRY", "-1ms"},
-		"fail, invalid EMAIL_FROM":             {"EMAIL_FROM", "@@"},
-		"fail, invalid POSTMARK_API_URL":       {"POSTMARK_API_URL", "not-a-url"},
+		"fail, no host in BASE_URL":                   {"BASE_URL", "/just-a-path"},
+		"fail, negative HTTP_READ_TIMEOUT":            {"HTTP_READ_TIMEOUT", "-1ms"},
+		"fail, negative HTTP_WRITE_TIMEOUT":           {"HTTP_WRITE_TIMEOUT", "-1ms"},
+		"fail, negative HTTP_IDLE_TIMEOUT":            {"HTTP_IDLE_TIMEOUT", "-1ms"},
+		"fail, negative HTTP_SHUTDOWN_TIMEOUT":        {"HTTP_SHUTDOWN_TIMEOUT", "-1ms"},
+		"fail, invalid HTTP_COOKIE_KEYS":              {"HTTP_COOKIE_KEYS", "abc"},
+		"fail, invalid HTTP_SECURE_COOKIE":            {"HTTP_SECURE_COOKIE", "abc"},
+		"fail, negative HTTP_RATE_LIMIT_MAX_ATTEMPTS": {"HTTP_RATE_LIMIT_MAX_ATTEMPTS", "-1"},
+		"fail, negative HTTP_RATE_LIMIT_WINDOW":       {"HTTP_RATE_LIMIT_WINDOW", "-1ms"},
+		"fail, negative HTTP_RATE_LIMIT_LOCKOUT":      {"HTTP_RATE_LIMIT_LOCKOUT", "-1ms"},
+		"fail, invalid HTTP_CSRF_KEY":                 {"HTTP_CSRF_KEY", "abc"},
+		"fail, empty DB_FILENAME":                     {"DB_FILENAME", ""},
+		"fail, invalid DB_MIGRATE":                    {"DB_MIGRATE", "no!"},
+		"fail, invalid DB_BLIND_INDEX_SALT":           {"DB_BLIND_INDEX_SALT", "abc"},
+		"fail, empty DB_ENCRYPTION_KEYS":              {"DB_ENCRYPTION_KEYS", ""},
+		"fail, invalid DB_ENCRYPTION_KEYS":            {"DB_ENCRYPTION_KEYS", "abc"},
+		"fail, negative AUTH_WORKER_TIMEOUT":          {"AUTH_WORKER_TIMEOUT", "-1ms"},
+		"fail, negative AUTH_TOKEN_EXPIRY":            {"AUTH_TOKEN_EXPIRY", "-1ms"},
+		"fail, negative AUTH_MAX_OUTSTANDING_TOKENS":  {"AUTH_MAX_OUTSTANDING_TOKENS", "-1"},
+		"fail, negative AUTH_TOKEN_COOLDOWN":          {"AUTH_TOKEN_COOLDOWN", "-1ms"},
+		"fail, negative AUTH_MAX_LOGIN_FAILURES":      {"AUTH_MAX_LOGIN_FAILURES", "-1"},
+		"fail, negative AUTH_LOGIN_LOCKOUT":           {"AUTH_LOGIN_LOCKOUT", "-1ms"},
+		"fail, negative AUTH_MAX_PASSWORD_RESETS":     {"AUTH_MAX_PASSWORD_RESETS", "-1"},
+		"fail, negative AUTH_PASSWORD_RESET_WINDOW":   {"AUTH_PASSWORD_RESET_WINDOW", "-1ms"},
+		"fail, too little AUTH_PASSWORD_MEMORY_KIB":   {"AUTH_PASSWORD_MEMORY_KIB", "7"},
+		"fail, zero AUTH_PASSWORD_ITERATIONS":         {"AUTH_PASSWORD_ITERATIONS", "0"},
+		"fail, zero AUTH_PASSWORD_PARALLELISM":        {"AUTH_PASSWORD_PARALLELISM", "0"},
+		"fail, too large AUTH_PASSWORD_PARALLELISM":   {"AUTH_PASSWORD_PARALLELISM", "256"},
+		"fail, negative AUTH_PASSWORD_ITERATIONS":     {"AUTH_PASSWORD_ITERATIONS", "-1"},
+		"fail, zero AUTH_CLEANUP_INTERVAL":            {"AUTH_CLEANUP_INTERVAL", "0s"},
+		"fail, negative AUTH_TOKEN_RETENTION":         {"AUTH_TOKEN_RETENTION", "-1ms"},
+		"fail, negative AUTH_INACTIVE_USER_RETENTION": {"AUTH_INACTIVE_USER_RETENTION", "-1ms"},
+		"fail, invalid EMAIL_FROM":                    {"EMAIL_FROM", "@@"},
+		"fail, zero EMAIL_OUTBOX_INTERVAL":            {"EMAIL_OUTBOX_INTERVAL", "0s"},
+		"fail, zero EMAIL_OUTBOX_BATCH_SIZE":          {"EMAIL_OUTBOX_BATCH_SIZE", "0"},
+		"fail, invalid EMAIL_OUTBOX_BATCH_SIZE":       {"EMAIL_OUTBOX_BATCH_SIZE", "abc"},
+		"fail, zero EMAIL_OUTBOX_MAX_ATTEMPTS":        {"EMAIL_OUTBOX_MAX_ATTEMPTS", "0"},
+		"fail, negative EMAIL_OUTBOX_MIN_BACKOFF":     {"EMAIL_OUTBOX_MIN_BACKOFF", "-1ms"},
+		"fail, negative EMAIL_OUTBOX_MAX_BACKOFF":     {"EMAIL_OUTBOX_MAX_BACKOFF", "-1ms"},
+		"fail, zero EMAIL_RETRY_MAX_ATTEMPTS":         {"EMAIL_RETRY_MAX_ATTEMPTS", "0"},
+		"fail, negative EMAIL_RETRY_MIN_BACKOFF":      {"EMAIL_RETRY_MIN_BACKOFF", "-1ms"},
+		"fail, negative EMAIL_RETRY_MAX_BACKOFF":      {"EMAIL_RETRY_MAX_BACKOFF", "-1ms"},
+		"fail, zero EMAIL_CIRCUIT_FAILURE_THRESHOLD":  {"EMAIL_CIRCUIT_FAILURE_THRESHOLD", "0"},
+		"fail, negative EMAIL_CIRCUIT_COOLDOWN":       {"EMAIL_CIRCUIT_COOLDOWN", "-1ms"},
+		"fail, invalid POSTMARK_API_URL":              {"POSTMARK_API_URL", "not-a-url"},
+		"fail, empty POSTMARK_WEBHOOK_USERNAME":       {"POSTMARK_WEBHOOK_USERNAME", ""},
+		"fail, unknown EMAIL_DRIVER":                  {"EMAIL_DRIVER", "sendmail"},
+		"fail, empty EMAIL_FILE_DIR":                  {"EMAIL_FILE_DIR", ""},
+		"fail, empty SMTP_HOST":                       {"SMTP_HOST", ""},
+		"fail, zero SMTP_PORT":                        {"SMTP_PORT", "0"},
+		"fail, too large SMTP_PORT":                   {"SMTP_PORT", "65536"},
+		"fail, unknown SMTP_TLS":                      {"SMTP_TLS", "ssl"},
+		"fail, unknown SMTP_AUTH":                     {"SMTP_AUTH", "cram-md5"},
+		"fail, zero SMTP_CONNECT_TIMEOUT":             {"SMTP_CONNECT_TIMEOUT", "0s"},
+		"fail, zero SMTP_TIMEOUT":                     {"SMTP_TIMEOUT", "0s"},
 	}
 
 	for name, tc := range invalid {
@@ -23,12 +23,23 @@ import (
 	"github.com/willemschots/househunt/internal/db"
 	"github.com/willemschots/househunt/internal/db/migrate"
 	"github.com/willemschots/househunt/internal/email"
+	"github.com/willemschots/househunt/internal/email/mailbox"
+	"github.com/willemschots/househunt/internal/email/outbox"
+	outboxdb "github.com/willemschots/househunt/internal/email/outbox/db"
 	"github.com/willemschots/househunt/internal/email/postmark"
+	"github.com/willemschots/househunt/internal/email/retry"
+	"github.com/willemschots/househunt/internal/email/smtp"
 	emailview "github.com/willemschots/househunt/internal/email/view"
 	"github.com/willemschots/househunt/internal/krypto"
+	"github.com/willemschots/househunt/internal/listing"
+	listingdb "github.com/willemschots/househunt/internal/listing/db"
+	"github.com/willemschots/househunt/internal/response"
+	responsedb "github.com/willemschots/househunt/internal/response/db"
 	"github.com/willemschots/househunt/internal/web"
 	"github.com/willemschots/househunt/internal/web/sessions"
+	sessionsdb "github.com/willemschots/househunt/internal/web/sessions/db"
 	"github.com/willemschots/househunt/internal/web/view"
+	"github.com/willemschots/househunt/internal/webauthn"
 	"github.com/willemschots/househunt/migrations"
 	"golang.org/x/sync/errgroup"
 )
@@ -98,6 +109,13 @@ func run(ctx context.Context, w io.Writer) int {
 		return 1
 	}
 
+	// Create blind indexer for columns that need to be searchable without decryption.
+	blindIndexer, err := krypto.NewBlindIndexer(cfg.db.blindIndexSalts)
+	if err != nil {
+		logger.Error("failed to create blind indexer", "error", err)
+		return 1
+	}
+
 	// Create emailer.
 	emailRenderer, err := emailview.NewMemRenderer(assets.EmailFS)
 	if err != nil {
@@ -105,7 +123,11 @@ func run(ctx context.Context, w io.Writer) int {
 		return 1
 	}
 
-	var sender email.Sender
+	var (
+		sender email.Sender
+		// devMailbox is only set for the file driver, it allows browsing the emails in the web app.
+		devMailbox *mailbox.Mailbox
+	)
 	switch cfg.email.driver {
 	case "log":
 		sender = email.NewLogSender(logger)
@@ -114,33 +136,93 @@ func run(ctx context.Context, w io.Writer) int {
 			Timeout: 10 * time.Second,
 		}
 		sender = postmark.NewSender(httpClient, cfg.email.postmark)
+	case "smtp":
+		sender, err = smtp.NewSender(cfg.email.smtp)
+		if err != nil {
+			logger.Error("failed to create smtp sender", "error", err)
+			return 1
+		}
+	case "file":
+		sender, err = mailbox.NewSender(cfg.email.fileDir)
+		if err != nil {
+			logger.Error("failed to create file sender", "error", err)
+			return 1
+		}
+		devMailbox = mailbox.New(cfg.email.fileDir)
+		logger.Warn("file email driver enabled, captured emails can be read by anyone at /_dev/mailbox", "dir", cfg.email.fileDir)
 	default:
 		logger.Error("unknown email driver", "driver", cfg.email.driver)
+		return 1
 	}
+
+	// Retry sends that fail temporarily, and stop sending for a while if the driver keeps failing.
+	retrySender := retry.NewSender(sender, cfg.email.retry)
+	retrySender.OnStateChange = func(from, to retry.State) {
+		logger.Warn("email sender circuit changed state", "from", from, "to", to)
+	}
+	sender = retrySender
+
 	emailer := email.NewService(emailRenderer, sender, cfg.email.service)
 
 	// Create authentication store and service.
-	authStore := authdb.New(dbh.write, dbh.read, encryptor, cfg.db.blindIndexSalt)
+	authStore := authdb.New(dbh.write, dbh.read, encryptor, blindIndexer)
 
 	authErrHandler := func(err error) {
 		logger.Error("authentication service error", "error", err)
 	}
 
+	cfg.auth.PasswordPolicy, err = passwordPolicy(cfg.passwords)
+	if err != nil {
+		logger.Error("failed to load password policy", "error", err)
+		return 1
+	}
+
+	// Passkeys are bound to the domain and origin of the base URL.
+	cfg.auth.WebAuthn = webauthn.ConfigFromURL(cfg.auth.TOTPIssuer, cfg.email.service.BaseURL)
+
 	authSvc, err := auth.NewService(authStore, emailer, authErrHandler, cfg.auth)
 	if err != nil {
 		logger.Error("failed to create auth service", "error", err)
 		return 1
 	}
 
-	// Create cookie store to store sessions.
+	// Create outbox store and dispatcher, the dispatcher sends queued emails in the background.
+	// Recipients whose earlier emails bounced are skipped, which the auth service keeps track of.
+	outboxStore := outboxdb.New(dbh.write, dbh.read, encryptor)
+
+	dispatcherErrHandler := func(err error) {
+		logger.Error("email dispatcher error", "error", err)
+	}
+
+	dispatcher := outbox.NewDispatcher(outboxStore, sender, authSvc, dispatcherErrHandler, cfg.email.outbox)
+
+	// Create the cleaner, it deletes old email tokens and attempts, expired sessions and inactive users in the background.
+	cleanerErrHandler := func(err error) {
+		logger.Error("authentication cleaner error", "error", err)
+	}
+
+	cleaner := auth.NewCleaner(authStore, cleanerErrHandler, cfg.cleaner)
+
+	// Create listing store and service.
+	listingStore := listingdb.New(dbh.write, dbh.read)
+	listingSvc := listing.NewService(listingStore)
+
+	// Create response store and service.
+	responseStore := responsedb.New(dbh.write, dbh.read, encryptor)
+	responseSvc := response.NewService(responseStore, listingSvc, authSvc, emailer)
+
+	// Create session store, sessions are kept in the database and the
+	// cookie only contains the session ID.
 	keysAsBytes := make([][]byte, len(cfg.http.cookieKeys))
 	for i, key := range cfg.http.cookieKeys {
 		keysAsBytes[i] = key.SecretValue()
 	}
-	sessionStore := gorillaSess.NewCookieStore(keysAsBytes...)
-	sessionStore.Options.Secure = cfg.http.server.SecureCookie
-	sessionStore.Options.HttpOnly = true
-	sessionStore.MaxAge(7 * 24 * 60 * 60) // 1 week
+	sessionStore := sessions.NewDBStore(sessionsdb.New(dbh.write, dbh.read), gorillaSess.Options{
+		Path:     "/",
+		MaxAge:   7 * 24 * 60 * 60, // 1 week
+		Secure:   cfg.http.server.SecureCookie,
+		HttpOnly: true,
+	}, keysAsBytes...)
 
 	// Register UUID type for inclusion in the session values.
 	gob.Register(uuid.UUID{})
@@ -159,11 +241,14 @@ func run(ctx context.Context, w io.Writer) int {
 	}
 
 	serverDeps := &web.ServerDeps{
-		Logger:       logger,
-		ViewRenderer: viewRenderer,
-		AuthService:  authSvc,
-		SessionStore: sessions.NewStore(sessionStore),
-		DistFS:       http.FS(assets.DistFS),
+		Logger:          logger,
+		ViewRenderer:    viewRenderer,
+		AuthService:     authSvc,
+		ListingService:  listingSvc,
+		ResponseService: responseSvc,
+		SessionStore:    sessions.NewStore(sessionStore),
+		DistFS:          http.FS(assets.DistFS),
+		Mailbox:         devMailbox,
 	}
 
 	srv := &http.Server{
@@ -174,9 +259,11 @@ func run(ctx context.Context, w io.Writer) int {
 		Handler:      web.NewServer(serverDeps, cfg.http.server),
 	}
 
-	// We need to run two tasks concurrently:
+	// We need to run four tasks concurrently:
 	// - Listen and serving of the HTTP server.
 	// - Waiting for a signal to stop the server.
+	// - Dispatching emails from the outbox.
+	// - Cleaning up old email tokens and inactive users.
 
 	g, gCtx := errgroup.WithContext(ctx)
 
@@ -198,7 +285,22 @@ func run(ctx context.Context, w io.Writer) int {
 		return srv.Shutdown(shutCtx)
 	})
 
+	g.Go(func() error {
+		logger.Info("starting email dispatcher")
+		return dispatcher.Run(gCtx)
+	})
+
+	g.Go(func() error {
+		logger.Info("starting authentication cleaner")
+		return cleaner.Run(gCtx)
+	})
+
 	err = g.Wait()
+
+	m := retrySender.Metrics()
+	logger.Info("email sender metrics", "state", m.State, "sent", m.Sent, "failed", m.Failed,
+		"retries", m.Retries, "rejected", m.Rejected, "opened", m.Opened)
+
 	if err != nil && err != http.ErrServerClosed {
 		logger.Error("http server stopped with error", "error", err)
 		return 1
@@ -209,6 +311,44 @@ func run(ctx context.Context, w io.Writer) int {
 	return 0
 }
 
+// passwordPolicy creates the policy new passwords are checked against.
+func passwordPolicy(cfg passwordsConfig) (auth.PasswordPolicy, error) {
+	policies := auth.PasswordPolicies{auth.EmailPasswordPolicy{}}
+
+	if cfg.commonFile != "" {
+		common, err := loadFile(cfg.commonFile, auth.LoadCommonPasswords)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load common passwords: %w", err)
+		}
+
+		policies = append(policies, common)
+	}
+
+	if cfg.breachedFile != "" {
+		breached, err := loadFile(cfg.breachedFile, auth.LoadBreachedPasswords)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load breached passwords: %w", err)
+		}
+
+		policies = append(policies, breached)
+	}
+
+	return policies, nil
+}
+
+// loadFile opens the file at path and passes it to load.
+func loadFile[T any](path string, load func(io.Reader) (T, error)) (T, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		var zero T
+		return zero, err
+	}
+
+	defer f.Close()
+
+	return load(f)
+}
+
 type dbHandles struct {
 	write *sql.DB
 	read  *sql.DB
@@ -228,6 +228,9 @@ func testEnv(testFunc func(t *testing.T)) func(t *testing.T) {
 	// https://github.com/golang/go/issues/60997
 	env["HTTP_SECURE_COOKIE"] = "false"
 
+	// Dispatch emails quickly, the tests wait for them to appear in the logs.
+	env["EMAIL_OUTBOX_INTERVAL"] = "50ms"
+
 	return func(t *testing.T) {
 		t.Helper()
 
@@ -1,7 +1,11 @@
 package main
 
 import (
+	"archive/zip"
+	"bytes"
 	"context"
+	"encoding/base64"
+	"encoding/json"
 	"fmt"
 	"io"
 	"net/http"
@@ -12,6 +16,9 @@ import (
 	"testing"
 	"time"
 
+	"github.com/willemschots/househunt/internal/krypto"
+	"github.com/willemschots/househunt/internal/webauthn"
+	"github.com/willemschots/househunt/internal/webauthn/webauthntest"
 	"golang.org/x/net/html"
 	"golang.org/x/net/publicsuffix"
 )
@@ -34,6 +41,18 @@ func Test_UserStories(t *testing.T) {
 			// then submit it with invalid values.
 			form.values.Set("email", "") // empty email
 			form.values.Set("password", "reallyStrongPassword1")
+			form.values.Set("role", "agent")
+
+			c.mustSubmitForm(t, form, assertStatusCode(t, http.StatusBadRequest))
+		})
+
+		t.Run("verify I can't register as an admin", func(t *testing.T) {
+			body := c.mustGetBody(t, "/register", assertStatusCode(t, http.StatusOK))
+
+			form := parseHTMLFormWithID(t, strings.NewReader(body), "register-user")
+			form.values.Set("email", "agent@example.com")
+			form.values.Set("password", "reallyStrongPassword1")
+			form.values.Set("role", "admin")
 
 			c.mustSubmitForm(t, form, assertStatusCode(t, http.StatusBadRequest))
 		})
@@ -44,13 +63,14 @@ func Test_UserStories(t *testing.T) {
 
 			form := parseHTMLFormWithID(t, strings.NewReader(body), "register-user")
 
-			if !form.values.Has("email") || !form.values.Has("password") {
-				t.Fatalf("expected form to have email and password fields, got %v", form.values)
+			if !form.values.Has("email") || !form.values.Has("password") || !form.values.Has("role") {
+				t.Fatalf("expected form to have email, password and role fields, got %v", form.values)
 			}
 
 			// then submit it.
 			form.values.Set("email", "agent@example.com")
 			form.values.Set("password", "reallyStrongPassword1")
+			form.values.Set("role", "agent")
 
 			// TODO: This should redirect to a success page.
 			c.mustSubmitForm(t, form, assertRedirectsTo(t, "/register", http.StatusFound))
@@ -117,106 +137,964 @@ func Test_UserStories(t *testing.T) {
 				})
 				assertRedirectsTo(t, "/dashboard", http.StatusFound)(res)
 			})
-		})
+		})
+
+		t.Run("verify I can now access the dashboard", func(t *testing.T) {
+			c.mustGetBody(t, "/dashboard", assertStatusCode(t, http.StatusOK))
+		})
+
+		t.Run("prevent mistakes when creating a listing", func(t *testing.T) {
+			// first view the form.
+			body := c.mustGetBody(t, "/listings/new", assertStatusCode(t, http.StatusOK))
+
+			form := parseHTMLFormWithID(t, strings.NewReader(body), "create-listing")
+
+			// then submit it with invalid values.
+			form.values.Set("title", "") // empty title.
+			form.values.Set("address", "Prinsengracht 1, Amsterdam")
+			form.values.Set("price", "425000")
+
+			c.mustSubmitForm(t, form, assertStatusCode(t, http.StatusBadRequest))
+		})
+
+		var listingURL string
+
+		t.Run("create a listing", func(t *testing.T) {
+			// first view the form.
+			body := c.mustGetBody(t, "/listings/new", assertStatusCode(t, http.StatusOK))
+
+			form := parseHTMLFormWithID(t, strings.NewReader(body), "create-listing")
+
+			if !form.values.Has("title") || !form.values.Has("address") || !form.values.Has("price") {
+				t.Fatalf("expected form to have title, address and price fields, got %v", form.values)
+			}
+
+			// then submit it.
+			form.values.Set("title", "Cosy family home")
+			form.values.Set("address", "Prinsengracht 1, Amsterdam")
+			form.values.Set("price", "425000")
+			form.values.Set("description", "A cosy family home with a garden.")
+
+			c.mustSubmitForm(t, form, func(res *http.Response) {
+				assertStatusCode(t, http.StatusFound)(res)
+
+				listingURL = res.Header.Get("Location")
+				if !strings.HasPrefix(listingURL, "/listings/edit?id=") {
+					t.Fatalf("expected redirect to listing edit page, got %q", listingURL)
+				}
+			})
+		})
+
+		t.Run("edit my listing", func(t *testing.T) {
+			body := c.mustGetBody(t, listingURL, assertStatusCode(t, http.StatusOK))
+
+			form := parseHTMLFormWithID(t, strings.NewReader(body), "edit-listing")
+
+			if form.values.Get("title") != "Cosy family home" {
+				t.Fatalf("expected form to contain current title, got %v", form.values)
+			}
+
+			form.values.Set("title", "Very cosy family home")
+			form.values.Set("description", "A very cosy family home with a garden.")
+
+			c.mustSubmitForm(t, form, assertRedirectsTo(t, listingURL, http.StatusFound))
+		})
+
+		t.Run("publish my listing", func(t *testing.T) {
+			body := c.mustGetBody(t, listingURL, assertStatusCode(t, http.StatusOK))
+
+			form := parseHTMLFormWithID(t, strings.NewReader(body), "publish-listing")
+
+			c.mustSubmitForm(t, form, assertRedirectsTo(t, listingURL, http.StatusFound))
+		})
+
+		t.Run("unpublish my listing", func(t *testing.T) {
+			body := c.mustGetBody(t, listingURL, assertStatusCode(t, http.StatusOK))
+
+			form := parseHTMLFormWithID(t, strings.NewReader(body), "unpublish-listing")
+
+			c.mustSubmitForm(t, form, assertRedirectsTo(t, listingURL, http.StatusFound))
+		})
+
+		t.Run("delete my listing", func(t *testing.T) {
+			body := c.mustGetBody(t, listingURL, assertStatusCode(t, http.StatusOK))
+
+			form := parseHTMLFormWithID(t, strings.NewReader(body), "delete-listing")
+
+			c.mustSubmitForm(t, form, assertRedirectsTo(t, "/dashboard", http.StatusFound))
+		})
+
+		t.Run("verify my listing is gone", func(t *testing.T) {
+			c.mustGetBody(t, listingURL, assertStatusCode(t, http.StatusNotFound))
+		})
+
+		// A copy of the session cookie, as if it was stolen.
+		var stolenCookies []*http.Cookie
+
+		t.Run("get the dashboard and log out", func(t *testing.T) {
+			body := c.mustGetBody(t, "/dashboard", assertStatusCode(t, http.StatusOK))
+
+			stolenCookies = c.cookies(t)
+
+			form := parseHTMLFormWithID(t, strings.NewReader(body), "logout-user")
+
+			c.mustSubmitForm(t, form, func(res *http.Response) {
+				assertRedirectsTo(t, "/", http.StatusFound)(res)
+			})
+		})
+
+		t.Run("verify I can't access the dashboard", func(t *testing.T) {
+			c.mustGetBody(t, "/dashboard", assertStatusCode(t, http.StatusNotFound))
+		})
+
+		t.Run("verify my old session can't be used to access the dashboard", func(t *testing.T) {
+			thief := newClient(t)
+			thief.setCookies(t, stolenCookies)
+
+			thief.mustGetBody(t, "/dashboard", assertStatusCode(t, http.StatusNotFound))
+		})
+
+		t.Run("prevent mistakes when requesting a new password", func(t *testing.T) {
+			// first view the form.
+			body := c.mustGetBody(t, "/forgot-password", assertStatusCode(t, http.StatusOK))
+
+			form := parseHTMLFormWithID(t, strings.NewReader(body), "forgot-password")
+
+			// then submit it with invalid values.
+			form.values.Set("email", "") // empty email.
+
+			c.mustSubmitForm(t, form, assertStatusCode(t, http.StatusBadRequest))
+		})
+
+		t.Run("request a new password because I forgot my old one", func(t *testing.T) {
+			// first view the form.
+			body := c.mustGetBody(t, "/forgot-password", assertStatusCode(t, http.StatusOK))
+
+			form := parseHTMLFormWithID(t, strings.NewReader(body), "forgot-password")
+
+			if !form.values.Has("email") {
+				t.Fatalf("expected form to have email field, got %v", form.values)
+			}
+
+			// then submit it.
+			form.values.Set("email", "agent@example.com")
+
+			// TODO: This should redirect to a success page.
+			c.mustSubmitForm(t, form, assertRedirectsTo(t, "/forgot-password", http.StatusFound))
+		})
+
+		var passwordResetURL *url.URL
+
+		t.Run("wait for the password reset email", func(t *testing.T) {
+			// wait for the password reset email to be logged.
+			passwordResetURL = waitAndCaptureURL(t, logs, "agent@example.com", "/password-resets")
+		})
+
+		t.Run("prevent mistakes when resetting my password", func(t *testing.T) {
+			// first view the form
+			body := c.mustGetBody(t, passwordResetURL.String(), assertStatusCode(t, http.StatusOK))
+
+			form := parseHTMLFormWithID(t, strings.NewReader(body), "reset-password")
+
+			// then submit it with invalid values.
+			form.values.Set("password", "") // empty password.
+
+			c.mustSubmitForm(t, form, assertStatusCode(t, http.StatusBadRequest))
+		})
+
+		t.Run("reset my password", func(t *testing.T) {
+			// first view the form
+			body := c.mustGetBody(t, passwordResetURL.String(), assertStatusCode(t, http.StatusOK))
+
+			form := parseHTMLFormWithID(t, strings.NewReader(body), "reset-password")
+
+			if !form.values.Has("rawtoken.id") || !form.values.Has("rawtoken.token") || !form.values.Has("password") {
+				t.Fatalf("expected form to have id, token, and password fields, got %v", form.values)
+			}
+
+			// set new password
+			form.values.Set("password", "newReallyStrongPassword1")
+
+			// then submit it.
+			c.mustSubmitForm(t, form, assertRedirectsTo(t, "/login", http.StatusFound))
+		})
+
+		t.Run("login with the new password", func(t *testing.T) {
+			// first view the login form.
+			body := c.mustGetBody(t, "/login", assertStatusCode(t, http.StatusOK))
+
+			form := parseHTMLFormWithID(t, strings.NewReader(body), "login-user")
+
+			// then submit it.
+			form.values.Set("email", "agent@example.com")
+			form.values.Set("password", "newReallyStrongPassword1")
+
+			c.mustSubmitForm(t, form, assertRedirectsTo(t, "/dashboard", http.StatusFound))
+		})
+
+		t.Run("verify I can access the dashboard", func(t *testing.T) {
+			c.mustGetBody(t, "/dashboard", assertStatusCode(t, http.StatusOK))
+		})
+	}))
+
+	t.Run("as a house hunter, I want to", testEnv(func(t *testing.T) {
+		logs := runAppForTest(t)
+
+		c := newClient(t)
+
+		// An agent is needed to publish a listing that can be responded to.
+		agent := newClient(t)
+		agent.mustRegisterAndLogin(t, logs, "agent@example.com", "agent")
+
+		var listingID string
+
+		t.Run("(agent) publish a listing", func(t *testing.T) {
+			body := agent.mustGetBody(t, "/listings/new", assertStatusCode(t, http.StatusOK))
+
+			form := parseHTMLFormWithID(t, strings.NewReader(body), "create-listing")
+			form.values.Set("title", "Cosy family home")
+			form.values.Set("address", "Prinsengracht 1, Amsterdam")
+			form.values.Set("price", "425000")
+			form.values.Set("description", "A cosy family home with a garden.")
+
+			var editURL string
+			agent.mustSubmitForm(t, form, func(res *http.Response) {
+				assertStatusCode(t, http.StatusFound)(res)
+				editURL = res.Header.Get("Location")
+			})
+
+			body = agent.mustGetBody(t, editURL, assertStatusCode(t, http.StatusOK))
+
+			form = parseHTMLFormWithID(t, strings.NewReader(body), "publish-listing")
+			listingID = form.values.Get("id")
+
+			agent.mustSubmitForm(t, form, assertRedirectsTo(t, editURL, http.StatusFound))
+		})
+
+		t.Run("register and login to my account", func(t *testing.T) {
+			c.mustRegisterAndLogin(t, logs, "hunter@example.com", "hunter")
+		})
+
+		t.Run("verify I can access the dashboard", func(t *testing.T) {
+			c.mustGetBody(t, "/dashboard", assertStatusCode(t, http.StatusOK))
+		})
+
+		t.Run("verify I can't manage listings", func(t *testing.T) {
+			c.mustGetBody(t, "/listings/new", assertStatusCode(t, http.StatusNotFound))
+		})
+
+		t.Run("find the published listing", func(t *testing.T) {
+			body := c.mustGetBody(t, "/listings", assertStatusCode(t, http.StatusOK))
+
+			if !strings.Contains(body, "/listings/view?id="+listingID) {
+				t.Fatalf("expected listing %s to be linked, got body:\n%s", listingID, body)
+			}
+		})
+
+		t.Run("prevent mistakes when responding to the listing", func(t *testing.T) {
+			body := c.mustGetBody(t, "/listings/view?id="+listingID, assertStatusCode(t, http.StatusOK))
+
+			form := parseHTMLFormWithID(t, strings.NewReader(body), "respond-to-listing")
+			form.values.Set("message", "") // empty message.
+
+			c.mustSubmitForm(t, form, assertStatusCode(t, http.StatusBadRequest))
+		})
+
+		t.Run("respond to the listing", func(t *testing.T) {
+			viewURL := "/listings/view?id=" + listingID
+			body := c.mustGetBody(t, viewURL, assertStatusCode(t, http.StatusOK))
+
+			form := parseHTMLFormWithID(t, strings.NewReader(body), "respond-to-listing")
+			if form.values.Get("listingID") != listingID {
+				t.Fatalf("expected form to refer to listing %s, got %v", listingID, form.values)
+			}
+
+			form.values.Set("message", "When can I view the house?")
+
+			c.mustSubmitForm(t, form, assertRedirectsTo(t, viewURL, http.StatusFound))
+		})
+
+		t.Run("(agent) shortlist my response", func(t *testing.T) {
+			body := agent.mustGetBody(t, "/dashboard", assertStatusCode(t, http.StatusOK))
+
+			inboxURL := "/listings/responses?id=" + listingID
+			if !strings.Contains(body, inboxURL) {
+				t.Fatalf("expected dashboard to link to inbox %s, got body:\n%s", inboxURL, body)
+			}
+
+			body = agent.mustGetBody(t, inboxURL, assertStatusCode(t, http.StatusOK))
+			if !strings.Contains(body, "When can I view the house?") {
+				t.Fatalf("expected inbox to contain response, got body:\n%s", body)
+			}
+
+			responseID := regexp.MustCompile(`id="response-status-([0-9a-f-]+)"`).FindStringSubmatch(body)
+			if responseID == nil {
+				t.Fatalf("expected inbox to contain a status form, got body:\n%s", body)
+			}
+
+			form := parseHTMLFormWithID(t, strings.NewReader(body), "response-status-"+responseID[1])
+			form.values.Set("status", "shortlisted")
+
+			agent.mustSubmitForm(t, form, assertRedirectsTo(t, inboxURL, http.StatusFound))
+		})
+
+		t.Run("get notified of the status of my response", func(t *testing.T) {
+			u := waitAndCaptureURL(t, logs, "hunter@example.com", "/listings/view")
+			if u.Query().Get("id") != listingID {
+				t.Fatalf("expected email to link to listing %s, got %s", listingID, u)
+			}
+		})
+	}))
+
+	t.Run("as a user, I want to", testEnv(func(t *testing.T) {
+		logs := runAppForTest(t)
+
+		c := newClient(t)
+		c.mustRegisterAndLogin(t, logs, "user@example.com", "hunter")
+
+		// The same account is used on another device.
+		other := newClient(t)
+		other.mustLogin(t, "user@example.com")
+
+		var otherSessionForm htmlForm
+
+		t.Run("see my active sessions", func(t *testing.T) {
+			body := c.mustGetBody(t, "/sessions", assertStatusCode(t, http.StatusOK))
+
+			ids := regexp.MustCompile(`id="(revoke-session-[^"]+)"`).FindAllStringSubmatch(body, -1)
+			if len(ids) != 2 {
+				t.Fatalf("expected 2 sessions, got %d", len(ids))
+			}
+
+			// Sessions are ordered by creation, the second is the other device.
+			otherSessionForm = parseHTMLFormWithID(t, strings.NewReader(body), ids[1][1])
+		})
+
+		t.Run("sign out the other device", func(t *testing.T) {
+			c.mustSubmitForm(t, otherSessionForm, assertRedirectsTo(t, "/sessions", http.StatusFound))
+		})
+
+		t.Run("verify the other device can't access the dashboard", func(t *testing.T) {
+			other.mustGetBody(t, "/dashboard", assertStatusCode(t, http.StatusNotFound))
+		})
+
+		t.Run("verify I can still access the dashboard", func(t *testing.T) {
+			c.mustGetBody(t, "/dashboard", assertStatusCode(t, http.StatusOK))
+		})
+	}))
+
+	t.Run("as a cautious user, I want to", testEnv(func(t *testing.T) {
+		logs := runAppForTest(t)
+
+		c := newClient(t)
+		c.mustRegisterAndLogin(t, logs, "cautious@example.com", "hunter")
+
+		// Enabling two-factor authentication hashes all recovery codes,
+		// which takes longer than the other requests.
+		c.http.Timeout = 4 * httpClientTimeout
+
+		var (
+			secret        krypto.TOTPSecret
+			recoveryCodes []string
+		)
+
+		t.Run("enable two-factor authentication", func(t *testing.T) {
+			body := c.mustGetBody(t, "/two-factor/enroll", assertStatusCode(t, http.StatusOK))
+
+			match := regexp.MustCompile(`id="totp-secret">([A-Z2-7]+)<`).FindStringSubmatch(body)
+			if match == nil {
+				t.Fatalf("expected the totp secret on the page")
+			}
+
+			var err error
+			secret, err = krypto.ParseTOTPSecret(match[1])
+			if err != nil {
+				t.Fatalf("failed to parse totp secret: %v", err)
+			}
+
+			form := parseHTMLFormWithID(t, strings.NewReader(body), "confirm-two-factor")
+			form.values.Set("code", secret.Code(time.Now()))
+
+			var codesBody string
+			c.mustSubmitForm(t, form, func(res *http.Response) {
+				assertStatusCode(t, http.StatusOK)(res)
+
+				b, err := io.ReadAll(res.Body)
+				if err != nil {
+					t.Fatalf("failed to read body: %v", err)
+				}
+				codesBody = string(b)
+			})
+
+			for _, m := range regexp.MustCompile(`<li>([a-z2-7-]{19})</li>`).FindAllStringSubmatch(codesBody, -1) {
+				recoveryCodes = append(recoveryCodes, m[1])
+			}
+
+			if len(recoveryCodes) != 10 {
+				t.Fatalf("expected 10 recovery codes, got %d", len(recoveryCodes))
+			}
+		})
+
+		login := func(t *testing.T) {
+			c.mustLogout(t)
+
+			body := c.mustGetBody(t, "/login", assertStatusCode(t, http.StatusOK))
+
+			form := parseHTMLFormWithID(t, strings.NewReader(body), "login-user")
+			form.values.Set("email", "cautious@example.com")
+			form.values.Set("password", "reallyStrongPassword1")
+
+			c.mustSubmitForm(t, form, assertRedirectsTo(t, "/login/verify", http.StatusFound))
+
+			// Not logged in until the second factor is provided.
+			c.mustGetBody(t, "/dashboard", assertStatusCode(t, http.StatusNotFound))
+		}
+
+		verify := func(t *testing.T, code string, responseFunc func(*http.Response)) {
+			body := c.mustGetBody(t, "/login/verify", assertStatusCode(t, http.StatusOK))
+
+			form := parseHTMLFormWithID(t, strings.NewReader(body), "verify-login")
+			form.values.Set("code", code)
+
+			c.mustSubmitForm(t, form, responseFunc)
+		}
+
+		t.Run("login with a code from my authenticator app", func(t *testing.T) {
+			login(t)
+
+			verify(t, "000000", assertStatusCode(t, http.StatusBadRequest))
+
+			// The code used to enable two-factor authentication was already used,
+			// so use the code of the next time step.
+			verify(t, secret.Code(time.Now().Add(30*time.Second)), assertRedirectsTo(t, "/dashboard", http.StatusFound))
+
+			c.mustGetBody(t, "/dashboard", assertStatusCode(t, http.StatusOK))
+		})
+
+		t.Run("login with a recovery code", func(t *testing.T) {
+			login(t)
+
+			verify(t, recoveryCodes[0], assertRedirectsTo(t, "/dashboard", http.StatusFound))
+
+			c.mustGetBody(t, "/dashboard", assertStatusCode(t, http.StatusOK))
+		})
+	}))
+
+	t.Run("as a user with a passkey, I want to", testEnv(func(t *testing.T) {
+		logs := runAppForTest(t)
+
+		c := newClient(t)
+		c.mustRegisterAndLogin(t, logs, "passkey@example.com", "hunter")
+
+		authenticator, err := webauthntest.New(baseURL)
+		if err != nil {
+			t.Fatalf("failed to create authenticator: %v", err)
+		}
+
+		t.Run("add a passkey", func(t *testing.T) {
+			body := c.mustGetBody(t, "/passkeys", assertStatusCode(t, http.StatusOK))
+			form := parseHTMLFormWithID(t, strings.NewReader(body), "register-passkey")
+
+			var opts webauthn.CreationOptions
+			c.mustGetJSON(t, "/passkeys/options", &opts)
+
+			resp, err := authenticator.Register(opts)
+			if err != nil {
+				t.Fatalf("failed to create credential: %v", err)
+			}
+
+			form.values.Set("clientDataJSON", encodeBytes(resp.ClientDataJSON))
+			form.values.Set("attestationObject", encodeBytes(resp.AttestationObject))
+
+			c.mustSubmitForm(t, form, assertRedirectsTo(t, "/passkeys", http.StatusFound))
+
+			body = c.mustGetBody(t, "/passkeys", assertStatusCode(t, http.StatusOK))
+			if !strings.Contains(body, "Never used") {
+				t.Fatalf("expected the passkey to be listed")
+			}
+		})
+
+		t.Run("login with my passkey", func(t *testing.T) {
+			c.mustLogout(t)
+
+			body := c.mustGetBody(t, "/login", assertStatusCode(t, http.StatusOK))
+			form := parseHTMLFormWithID(t, strings.NewReader(body), "login-passkey")
+
+			var opts webauthn.RequestOptions
+			c.mustGetJSON(t, "/login/passkey/options", &opts)
+
+			resp, err := authenticator.Login(opts)
+			if err != nil {
+				t.Fatalf("failed to sign challenge: %v", err)
+			}
+
+			form.values.Set("credentialID", encodeBytes(resp.CredentialID))
+			form.values.Set("clientDataJSON", encodeBytes(resp.ClientDataJSON))
+			form.values.Set("authenticatorData", encodeBytes(resp.AuthenticatorData))
+			form.values.Set("signature", encodeBytes(resp.Signature))
+			form.values.Set("userHandle", encodeBytes(resp.UserHandle))
+
+			c.mustSubmitForm(t, form, assertRedirectsTo(t, "/dashboard", http.StatusFound))
+
+			c.mustGetBody(t, "/dashboard", assertStatusCode(t, http.StatusOK))
+
+			// The challenge can only be used once.
+			c.mustLogout(t)
+			c.mustSubmitForm(t, form, assertStatusCode(t, http.StatusBadRequest))
+		})
+	}))
+
+	t.Run("as a user who moved to a new email address, I want to", testEnv(func(t *testing.T) {
+		logs := runAppForTest(t)
+
+		c := newClient(t)
+		c.mustRegisterAndLogin(t, logs, "old@example.com", "hunter")
+
+		t.Run("change my email address", func(t *testing.T) {
+			body := c.mustGetBody(t, "/email-change", assertStatusCode(t, http.StatusOK))
+
+			form := parseHTMLFormWithID(t, strings.NewReader(body), "change-email")
+			form.values.Set("email", "moved@example.com")
+
+			c.mustSubmitForm(t, form, assertRedirectsTo(t, "/email-change", http.StatusFound))
+
+			confirmURL := waitAndCaptureURL(t, logs, "moved@example.com", "/email-changes")
+
+			body = c.mustGetBody(t, confirmURL.String(), assertStatusCode(t, http.StatusOK))
+
+			form = parseHTMLFormWithID(t, strings.NewReader(body), "confirm-email-change")
+			c.mustSubmitForm(t, form, assertRedirectsTo(t, "/email-change", http.StatusFound))
+
+			body = c.mustGetBody(t, "/email-change", assertStatusCode(t, http.StatusOK))
+			if !strings.Contains(body, `<span id="current-email">moved@example.com</span>`) {
+				t.Fatalf("expected the new email address on the page")
+			}
+		})
+
+		t.Run("login with my new email address", func(t *testing.T) {
+			c.mustLogout(t)
+			c.mustLogin(t, "moved@example.com")
+		})
+	}))
+
+	t.Run("as a user with a leaked password, I want to", testEnv(func(t *testing.T) {
+		logs := runAppForTest(t)
+
+		c := newClient(t)
+		c.mustRegisterAndLogin(t, logs, "leaked@example.com", "hunter")
+
+		// Someone else used the leaked password to login on another device.
+		other := newClient(t)
+		other.mustLogin(t, "leaked@example.com")
+
+		changePassword := func(current string, responseFunc func(*http.Response)) {
+			body := c.mustGetBody(t, "/password-change", assertStatusCode(t, http.StatusOK))
+
+			form := parseHTMLFormWithID(t, strings.NewReader(body), "change-password")
+			form.values.Set("current", current)
+			form.values.Set("new", "newReallyStrongPassword1")
+
+			c.mustSubmitForm(t, form, responseFunc)
+		}
+
+		t.Run("prevent mistakes when changing my password", func(t *testing.T) {
+			changePassword("wrongPassword1", assertStatusCode(t, http.StatusBadRequest))
+		})
+
+		t.Run("change my password", func(t *testing.T) {
+			changePassword("reallyStrongPassword1", assertRedirectsTo(t, "/password-change", http.StatusFound))
+		})
+
+		t.Run("verify the other device can't access the dashboard", func(t *testing.T) {
+			other.mustGetBody(t, "/dashboard", assertStatusCode(t, http.StatusNotFound))
+		})
+
+		t.Run("verify I can still access the dashboard", func(t *testing.T) {
+			c.mustGetBody(t, "/dashboard", assertStatusCode(t, http.StatusOK))
+		})
+
+		t.Run("login with the new password", func(t *testing.T) {
+			c.mustLogout(t)
+
+			body := c.mustGetBody(t, "/login", assertStatusCode(t, http.StatusOK))
+
+			form := parseHTMLFormWithID(t, strings.NewReader(body), "login-user")
+			form.values.Set("email", "leaked@example.com")
+			form.values.Set("password", "newReallyStrongPassword1")
+
+			c.mustSubmitForm(t, form, assertRedirectsTo(t, "/dashboard", http.StatusFound))
+		})
+	}))
+
+	t.Run("as a user who is done house hunting, I want to", testEnv(func(t *testing.T) {
+		logs := runAppForTest(t)
+
+		c := newClient(t)
+		c.mustRegisterAndLogin(t, logs, "done@example.com", "hunter")
+
+		t.Run("download my data", func(t *testing.T) {
+			body := c.mustGetBody(t, "/account/export", func(res *http.Response) {
+				assertStatusCode(t, http.StatusOK)(res)
+
+				if ct := res.Header.Get("Content-Type"); ct != "application/zip" {
+					t.Fatalf("expected a zip archive, got content type %q", ct)
+				}
+			})
+
+			zr, err := zip.NewReader(strings.NewReader(body), int64(len(body)))
+			if err != nil {
+				t.Fatalf("failed to read zip archive: %v", err)
+			}
+
+			f, err := zr.Open("account.json")
+			if err != nil {
+				t.Fatalf("failed to open account.json: %v", err)
+			}
+			defer f.Close()
+
+			var account struct {
+				User struct {
+					Email string
+				}
+			}
+			err = json.NewDecoder(f).Decode(&account)
+			if err != nil {
+				t.Fatalf("failed to decode account.json: %v", err)
+			}
+
+			if account.User.Email != "done@example.com" {
+				t.Fatalf("expected my email address in the export, got %q", account.User.Email)
+			}
+		})
+
+		t.Run("delete my account", func(t *testing.T) {
+			body := c.mustGetBody(t, "/account", assertStatusCode(t, http.StatusOK))
+
+			form := parseHTMLFormWithID(t, strings.NewReader(body), "delete-account")
+			c.mustSubmitForm(t, form, assertRedirectsTo(t, "/account", http.StatusFound))
+
+			confirmURL := waitAndCaptureURL(t, logs, "done@example.com", "/account-deletions")
+
+			body = c.mustGetBody(t, confirmURL.String(), assertStatusCode(t, http.StatusOK))
+
+			form = parseHTMLFormWithID(t, strings.NewReader(body), "confirm-account-deletion")
+			c.mustSubmitForm(t, form, assertRedirectsTo(t, "/", http.StatusFound))
+		})
+
+		t.Run("verify I was logged out", func(t *testing.T) {
+			c.mustGetBody(t, "/dashboard", assertStatusCode(t, http.StatusNotFound))
+		})
+
+		t.Run("verify I can't login anymore", func(t *testing.T) {
+			body := c.mustGetBody(t, "/login", assertStatusCode(t, http.StatusOK))
+
+			form := parseHTMLFormWithID(t, strings.NewReader(body), "login-user")
+			form.values.Set("email", "done@example.com")
+			form.values.Set("password", "reallyStrongPassword1")
+
+			c.mustSubmitForm(t, form, assertStatusCode(t, http.StatusBadRequest))
+		})
+	}))
+
+	t.Run("as an API client, I want to", testEnv(func(t *testing.T) {
+		logs := runAppForTest(t)
+
+		agent := newClient(t)
+		agent.mustRegisterAndLogin(t, logs, "agent@example.com", "agent")
+		agentToken := agent.mustCSRFToken(t)
+
+		hunter := newClient(t)
+		hunter.mustRegisterAndLogin(t, logs, "hunter@example.com", "hunter")
+		hunterToken := hunter.mustCSRFToken(t)
+
+		var listingID string
+
+		t.Run("(agent) prevent mistakes when creating a listing", func(t *testing.T) {
+			body := agent.mustDoJSON(t, http.MethodPost, "/api/v1/agent/listings", agentToken, map[string]any{
+				"title":   "",
+				"address": "Prinsengracht 1, Amsterdam",
+				"price":   425000,
+			}, assertProblem(t, http.StatusBadRequest))
+
+			var problem struct {
+				Errors []struct {
+					Key string
+				}
+			}
+			mustDecodeJSON(t, body, &problem)
+
+			if len(problem.Errors) != 1 || problem.Errors[0].Key != "title" {
+				t.Fatalf("expected an error for the title, got body:\n%s", body)
+			}
+		})
+
+		t.Run("(agent) create and publish a listing", func(t *testing.T) {
+			body := agent.mustDoJSON(t, http.MethodPost, "/api/v1/agent/listings", agentToken, map[string]any{
+				"title":   "Cosy family home",
+				"address": "Prinsengracht 1, Amsterdam",
+				"price":   425000,
+			}, assertStatusCode(t, http.StatusCreated))
+
+			var created struct {
+				ID string
+			}
+			mustDecodeJSON(t, body, &created)
+			listingID = created.ID
+
+			agent.mustDoJSON(t, http.MethodPost, "/api/v1/agent/listings/"+listingID+"/publish", agentToken, nil, assertStatusCode(t, http.StatusOK))
+		})
+
+		t.Run("verify I can't manage listings", func(t *testing.T) {
+			hunter.mustDoJSON(t, http.MethodGet, "/api/v1/agent/listings", "", nil, assertProblem(t, http.StatusNotFound))
+		})
+
+		t.Run("find the published listing", func(t *testing.T) {
+			var listings []struct {
+				ID    string
+				Title string
+			}
+			hunter.mustGetJSON(t, "/api/v1/listings", &listings)
+
+			if len(listings) != 1 || listings[0].ID != listingID {
+				t.Fatalf("expected listing %s, got %+v", listingID, listings)
+			}
+		})
+
+		t.Run("respond to the listing", func(t *testing.T) {
+			hunter.mustDoJSON(t, http.MethodPost, "/api/v1/responses", hunterToken, map[string]any{
+				"listingID": listingID,
+				"message":   "When can I view the house?",
+			}, assertStatusCode(t, http.StatusCreated))
+		})
+
+		t.Run("(agent) shortlist the response", func(t *testing.T) {
+			var inbox struct {
+				Responses []struct {
+					ID      string
+					Message string
+				}
+			}
+			agent.mustGetJSON(t, "/api/v1/agent/listings/"+listingID+"/responses", &inbox)
+
+			if len(inbox.Responses) != 1 || inbox.Responses[0].Message != "When can I view the house?" {
+				t.Fatalf("expected inbox to contain response, got %+v", inbox)
+			}
+
+			url := "/api/v1/agent/responses/" + inbox.Responses[0].ID + "/status"
+			agent.mustDoJSON(t, http.MethodPut, url, agentToken, map[string]any{
+				"status": "shortlisted",
+			}, assertStatusCode(t, http.StatusOK))
+		})
+
+		t.Run("(agent) delete the listing", func(t *testing.T) {
+			url := "/api/v1/agent/listings/" + listingID
+			agent.mustDoJSON(t, http.MethodDelete, url, agentToken, nil, assertStatusCode(t, http.StatusNoContent))
+			agent.mustDoJSON(t, http.MethodGet, url, "", nil, assertProblem(t, http.StatusNotFound))
+		})
+
+		// script uses an API token instead of the session cookie.
+		script := newClient(t)
+
+		t.Run("(agent) create an API token", func(t *testing.T) {
+			body := agent.mustGetBody(t, "/api-tokens", assertStatusCode(t, http.StatusOK))
+
+			form := parseHTMLFormWithID(t, strings.NewReader(body), "create-api-token")
+			form.values.Set("name", "CRM import")
+			form.values["scopes"] = []string{"listings:read", "listings:write"}
+			form.values.Set("expiresInDays", "30")
+
+			var createdBody string
+			agent.mustSubmitForm(t, form, func(res *http.Response) {
+				assertStatusCode(t, http.StatusOK)(res)
+
+				b, err := io.ReadAll(res.Body)
+				if err != nil {
+					t.Fatalf("failed to read body: %v", err)
+				}
+				createdBody = string(b)
+			})
 
-		t.Run("verify I can now access the dashboard", func(t *testing.T) {
-			c.mustGetBody(t, "/dashboard", assertStatusCode(t, http.StatusOK))
+			match := regexp.MustCompile(`id="api-token">(hh_[0-9a-f_-]+)<`).FindStringSubmatch(createdBody)
+			if len(match) != 2 {
+				t.Fatalf("expected API token in body, got:\n%s", createdBody)
+			}
+			script.bearerToken = match[1]
+
+			body = agent.mustGetBody(t, "/api-tokens", assertStatusCode(t, http.StatusOK))
+			if !strings.Contains(body, "CRM import") {
+				t.Fatalf("expected API token to be listed, got body:\n%s", body)
+			}
 		})
 
-		t.Run("get the dashboard and log out", func(t *testing.T) {
-			body := c.mustGetBody(t, "/dashboard", assertStatusCode(t, http.StatusOK))
+		t.Run("(script) create a listing without a CSRF token", func(t *testing.T) {
+			script.mustDoJSON(t, http.MethodPost, "/api/v1/agent/listings", "", map[string]any{
+				"title":   "Canal house",
+				"address": "Herengracht 2, Amsterdam",
+				"price":   950000,
+			}, assertStatusCode(t, http.StatusCreated))
 
-			form := parseHTMLFormWithID(t, strings.NewReader(body), "logout-user")
+			var listings []struct {
+				Title string
+			}
+			mustDecodeJSON(t, script.mustDoJSON(t, http.MethodGet, "/api/v1/agent/listings", "", nil, assertStatusCode(t, http.StatusOK)), &listings)
 
-			c.mustSubmitForm(t, form, func(res *http.Response) {
-				assertRedirectsTo(t, "/", http.StatusFound)(res)
-			})
+			if len(listings) != 1 || listings[0].Title != "Canal house" {
+				t.Fatalf("expected the created listing, got %+v", listings)
+			}
 		})
 
-		t.Run("verify I can't access the dashboard", func(t *testing.T) {
-			c.mustGetBody(t, "/dashboard", assertStatusCode(t, http.StatusNotFound))
+		t.Run("(script) verify I can only use the scopes of the token", func(t *testing.T) {
+			script.mustDoJSON(t, http.MethodGet, "/api/v1/agent/listings/"+listingID+"/responses", "", nil, assertProblem(t, http.StatusForbidden))
 		})
 
-		t.Run("prevent mistakes when requesting a new password", func(t *testing.T) {
-			// first view the form.
-			body := c.mustGetBody(t, "/forgot-password", assertStatusCode(t, http.StatusOK))
+		t.Run("(script) verify invalid tokens are rejected", func(t *testing.T) {
+			// Change the last character, so that the ID is right but the token is not.
+			last := "0"
+			if strings.HasSuffix(script.bearerToken, last) {
+				last = "1"
+			}
 
-			form := parseHTMLFormWithID(t, strings.NewReader(body), "forgot-password")
+			invalid := newClient(t)
+			invalid.bearerToken = script.bearerToken[:len(script.bearerToken)-1] + last
 
-			// then submit it with invalid values.
-			form.values.Set("email", "") // empty email.
+			invalid.mustDoJSON(t, http.MethodGet, "/api/v1/agent/listings", "", nil, func(res *http.Response) {
+				assertProblem(t, http.StatusUnauthorized)(res)
 
-			c.mustSubmitForm(t, form, assertStatusCode(t, http.StatusBadRequest))
+				if !strings.HasPrefix(res.Header.Get("WWW-Authenticate"), "Bearer") {
+					t.Fatalf("expected WWW-Authenticate header, got %q", res.Header.Get("WWW-Authenticate"))
+				}
+			})
 		})
 
-		t.Run("request a new password because I forgot my old one", func(t *testing.T) {
-			// first view the form.
-			body := c.mustGetBody(t, "/forgot-password", assertStatusCode(t, http.StatusOK))
+		t.Run("(agent) delete the API token", func(t *testing.T) {
+			body := agent.mustGetBody(t, "/api-tokens", assertStatusCode(t, http.StatusOK))
 
-			form := parseHTMLFormWithID(t, strings.NewReader(body), "forgot-password")
+			id := regexp.MustCompile(`id="(delete-api-token-[^"]+)"`).FindStringSubmatch(body)
+			if len(id) != 2 {
+				t.Fatalf("expected a delete form, got body:\n%s", body)
+			}
 
-			if !form.values.Has("email") {
-				t.Fatalf("expected form to have email field, got %v", form.values)
+			form := parseHTMLFormWithID(t, strings.NewReader(body), id[1])
+			agent.mustSubmitForm(t, form, assertRedirectsTo(t, "/api-tokens", http.StatusFound))
+
+			script.mustDoJSON(t, http.MethodGet, "/api/v1/agent/listings", "", nil, assertProblem(t, http.StatusUnauthorized))
+		})
+	}))
+
+	t.Run("as a user whose emails bounce, I want to", testEnv(func(t *testing.T) {
+		envForTest(t, "POSTMARK_WEBHOOK_USERNAME", "postmark")
+		envForTest(t, "POSTMARK_WEBHOOK_PASSWORD", "webhookPassword")
+
+		logs := runAppForTest(t)
+
+		c := newClient(t)
+		c.mustRegisterAndLogin(t, logs, "bounced@example.com", "hunter")
+		c.mustLogout(t)
+
+		postBounce := func(username, password string, responseFunc func(*http.Response)) {
+			payload := `{"RecordType":"Bounce","Type":"HardBounce","TypeCode":1,"Email":"bounced@example.com","BouncedAt":"2024-06-01T12:00:00Z","Description":"The server was unable to deliver your message."}`
+			req, err := http.NewRequest(http.MethodPost, baseURL+"/webhooks/postmark", strings.NewReader(payload))
+			if err != nil {
+				t.Fatalf("unexpected error creating request: %v", err)
 			}
 
-			// then submit it.
-			form.values.Set("email", "agent@example.com")
+			req.Header.Set("Content-Type", "application/json")
+			req.SetBasicAuth(username, password)
 
-			// TODO: This should redirect to a success page.
-			c.mustSubmitForm(t, form, assertRedirectsTo(t, "/forgot-password", http.StatusFound))
+			res, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatalf("unexpected error during request: %v", err)
+			}
+
+			defer res.Body.Close()
+			responseFunc(res)
+		}
+
+		t.Run("not have bounces recorded by others", func(t *testing.T) {
+			postBounce("postmark", "wrongPassword", assertStatusCode(t, http.StatusUnauthorized))
 		})
 
-		var passwordResetURL *url.URL
+		t.Run("be told to change my address after emails to it bounced", func(t *testing.T) {
+			postBounce("postmark", "webhookPassword", assertStatusCode(t, http.StatusOK))
 
-		t.Run("wait for the password reset email", func(t *testing.T) {
-			// wait for the password reset email to be logged.
-			passwordResetURL = waitAndCaptureURL(t, logs, "agent@example.com", "/password-resets")
+			c.mustLogin(t, "bounced@example.com")
+
+			body := c.mustGetBody(t, "/dashboard", assertStatusCode(t, http.StatusOK))
+			if !strings.Contains(body, "Emails to your address bounced") {
+				t.Fatalf("expected bounce notice on dashboard:\n%s", body)
+			}
 		})
+	}))
 
-		t.Run("prevent mistakes when resetting my password", func(t *testing.T) {
-			// first view the form
-			body := c.mustGetBody(t, passwordResetURL.String(), assertStatusCode(t, http.StatusOK))
+	t.Run("as a developer, I want to", testEnv(func(t *testing.T) {
+		envForTest(t, "EMAIL_DRIVER", "file")
+		envForTest(t, "EMAIL_FILE_DIR", t.TempDir())
 
-			form := parseHTMLFormWithID(t, strings.NewReader(body), "reset-password")
+		runAppForTest(t)
 
-			// then submit it with invalid values.
-			form.values.Set("password", "") // empty password.
+		c := newClient(t)
 
-			c.mustSubmitForm(t, form, assertStatusCode(t, http.StatusBadRequest))
-		})
+		t.Run("follow the activation link from the mailbox", func(t *testing.T) {
+			body := c.mustGetBody(t, "/register", assertStatusCode(t, http.StatusOK))
 
-		t.Run("reset my password", func(t *testing.T) {
-			// first view the form
-			body := c.mustGetBody(t, passwordResetURL.String(), assertStatusCode(t, http.StatusOK))
+			form := parseHTMLFormWithID(t, strings.NewReader(body), "register-user")
+			form.values.Set("email", "developer@example.com")
+			form.values.Set("password", "reallyStrongPassword1")
+			form.values.Set("role", "hunter")
 
-			form := parseHTMLFormWithID(t, strings.NewReader(body), "reset-password")
+			c.mustSubmitForm(t, form, assertRedirectsTo(t, "/register", http.StatusFound))
 
-			if !form.values.Has("rawtoken.id") || !form.values.Has("rawtoken.token") || !form.values.Has("password") {
-				t.Fatalf("expected form to have id, token, and password fields, got %v", form.values)
+			emails := c.waitForMailbox(t)
+
+			body = c.mustGetBody(t, emails[0], assertStatusCode(t, http.StatusOK))
+
+			var activationURL string
+			for _, link := range parseHTMLLinksWithID(t, strings.NewReader(body), "email-links") {
+				if strings.HasPrefix(link, baseURL+"/user-activations") {
+					activationURL = link
+				}
 			}
 
-			// set new password
-			form.values.Set("password", "newReallyStrongPassword1")
+			if activationURL == "" {
+				t.Fatalf("activation link not found in email:\n%s", body)
+			}
 
-			// then submit it.
+			body = c.mustGetBody(t, activationURL, assertStatusCode(t, http.StatusOK))
+
+			form = parseHTMLFormWithID(t, strings.NewReader(body), "activate-user")
 			c.mustSubmitForm(t, form, assertRedirectsTo(t, "/login", http.StatusFound))
+
+			c.mustLogin(t, "developer@example.com")
 		})
+	}))
 
-		t.Run("login with the new password", func(t *testing.T) {
-			// first view the login form.
-			body := c.mustGetBody(t, "/login", assertStatusCode(t, http.StatusOK))
+	t.Run("as a visitor, I want to", testEnv(func(t *testing.T) {
+		runAppForTest(t)
 
-			form := parseHTMLFormWithID(t, strings.NewReader(body), "login-user")
+		c := newClient(t)
 
-			// then submit it.
-			form.values.Set("email", "agent@example.com")
-			form.values.Set("password", "newReallyStrongPassword1")
+		t.Run("be locked out after too many failed logins", func(t *testing.T) {
+			login := func(responseFunc func(*http.Response)) {
+				body := c.mustGetBody(t, "/login", assertStatusCode(t, http.StatusOK))
 
-			c.mustSubmitForm(t, form, assertRedirectsTo(t, "/dashboard", http.StatusFound))
+				form := parseHTMLFormWithID(t, strings.NewReader(body), "login-user")
+				form.values.Set("email", "visitor@example.com")
+				form.values.Set("password", "reallyStrongPassword1")
+
+				c.mustSubmitForm(t, form, responseFunc)
+			}
+
+			// AUTH_MAX_LOGIN_FAILURES defaults to 5.
+			for i := 0; i < 5; i++ {
+				login(assertStatusCode(t, http.StatusBadRequest))
+			}
+
+			login(assertStatusCode(t, http.StatusTooManyRequests))
 		})
 
-		t.Run("verify I can access the dashboard", func(t *testing.T) {
-			c.mustGetBody(t, "/dashboard", assertStatusCode(t, http.StatusOK))
+		t.Run("not read other people's emails", func(t *testing.T) {
+			// The mailbox is only available with the file email driver.
+			c.mustGetBody(t, "/_dev/mailbox", assertStatusCode(t, http.StatusNotFound))
 		})
 	}))
 }
@@ -267,8 +1145,56 @@ func runAppForTest(t *testing.T) *safeBuffer {
 	return buf
 }
 
+// mustRegisterAndLogin registers, activates and logs in a new user with the provided role.
+func (c *client) mustRegisterAndLogin(t *testing.T, logs *safeBuffer, addr, role string) {
+	t.Helper()
+
+	body := c.mustGetBody(t, "/register", assertStatusCode(t, http.StatusOK))
+
+	form := parseHTMLFormWithID(t, strings.NewReader(body), "register-user")
+	form.values.Set("email", addr)
+	form.values.Set("password", "reallyStrongPassword1")
+	form.values.Set("role", role)
+
+	c.mustSubmitForm(t, form, assertRedirectsTo(t, "/register", http.StatusFound))
+
+	activationURL := waitAndCaptureURL(t, logs, addr, "/user-activations")
+
+	body = c.mustGetBody(t, activationURL.String(), assertStatusCode(t, http.StatusOK))
+
+	form = parseHTMLFormWithID(t, strings.NewReader(body), "activate-user")
+	c.mustSubmitForm(t, form, assertRedirectsTo(t, "/login", http.StatusFound))
+
+	c.mustLogin(t, addr)
+}
+
+// mustLogin logs in an existing user.
+func (c *client) mustLogin(t *testing.T, addr string) {
+	t.Helper()
+
+	body := c.mustGetBody(t, "/login", assertStatusCode(t, http.StatusOK))
+
+	form := parseHTMLFormWithID(t, strings.NewReader(body), "login-user")
+	form.values.Set("email", addr)
+	form.values.Set("password", "reallyStrongPassword1")
+
+	c.mustSubmitForm(t, form, assertRedirectsTo(t, "/dashboard", http.StatusFound))
+}
+
+// mustLogout logs out the logged in user.
+func (c *client) mustLogout(t *testing.T) {
+	t.Helper()
+
+	body := c.mustGetBody(t, "/dashboard", assertStatusCode(t, http.StatusOK))
+
+	form := parseHTMLFormWithID(t, strings.NewReader(body), "logout-user")
+	c.mustSubmitForm(t, form, assertRedirectsTo(t, "/", http.StatusFound))
+}
+
 type client struct {
 	http *http.Client
+	// bearerToken is sent in the Authorization header of JSON requests if set.
+	bearerToken string
 }
 
 func newClient(t *testing.T) *client {
@@ -289,6 +1215,28 @@ func newClient(t *testing.T) *client {
 	}
 }
 
+func (c *client) cookies(t *testing.T) []*http.Cookie {
+	t.Helper()
+
+	u, err := url.Parse(baseURL)
+	if err != nil {
+		t.Fatalf("failed to parse url: %v", err)
+	}
+
+	return c.http.Jar.Cookies(u)
+}
+
+func (c *client) setCookies(t *testing.T, cookies []*http.Cookie) {
+	t.Helper()
+
+	u, err := url.Parse(baseURL)
+	if err != nil {
+		t.Fatalf("failed to parse url: %v", err)
+	}
+
+	c.http.Jar.SetCookies(u, cookies)
+}
+
 func (c *client) mustGetBody(t *testing.T, url string, responseFunc func(*http.Response)) string {
 	t.Helper()
 
@@ -320,6 +1268,92 @@ func (c *client) mustGetBody(t *testing.T, url string, responseFunc func(*http.R
 	return string(data)
 }
 
+func (c *client) mustGetJSON(t *testing.T, url string, v any) {
+	t.Helper()
+
+	body := c.mustGetBody(t, url, assertStatusCode(t, http.StatusOK))
+
+	err := json.Unmarshal([]byte(body), v)
+	if err != nil {
+		t.Fatalf("failed to decode json: %v", err)
+	}
+}
+
+// mustCSRFToken returns the CSRF token of the logged in user, which API
+// clients need to provide in the X-CSRF-Token header.
+func (c *client) mustCSRFToken(t *testing.T) string {
+	t.Helper()
+
+	body := c.mustGetBody(t, "/dashboard", assertStatusCode(t, http.StatusOK))
+
+	form := parseHTMLFormWithID(t, strings.NewReader(body), "logout-user")
+	return form.values.Get("csrfToken")
+}
+
+// mustDoJSON does an API request with v encoded as JSON body, a nil v results in
+// an empty body. It returns the response body.
+func (c *client) mustDoJSON(t *testing.T, method, url, csrfToken string, v any, responseFunc func(*http.Response)) string {
+	t.Helper()
+
+	if strings.HasPrefix(url, "/") {
+		url = baseURL + url
+	}
+
+	var body io.Reader
+	if v != nil {
+		data, err := json.Marshal(v)
+		if err != nil {
+			t.Fatalf("failed to encode json: %v", err)
+		}
+		body = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequest(method, url, body)
+	if err != nil {
+		t.Fatalf("unexpected error creating request: %v", err)
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	if csrfToken != "" {
+		req.Header.Set("X-CSRF-Token", csrfToken)
+	}
+	if c.bearerToken != "" {
+		req.Header.Set("Authorization", "Bearer "+c.bearerToken)
+	}
+
+	res, err := c.http.Do(req)
+	if err != nil {
+		t.Fatalf("unexpected error during request: %v", err)
+	}
+
+	defer func() {
+		err := res.Body.Close()
+		if err != nil {
+			t.Fatalf("unexpected error closing response body: %v", err)
+		}
+	}()
+
+	if responseFunc != nil {
+		responseFunc(res)
+	}
+
+	data, err := io.ReadAll(res.Body)
+	if err != nil {
+		t.Fatalf("unexpected error reading response body: %v", err)
+	}
+
+	return string(data)
+}
+
+func mustDecodeJSON(t *testing.T, body string, v any) {
+	t.Helper()
+
+	err := json.Unmarshal([]byte(body), v)
+	if err != nil {
+		t.Fatalf("failed to decode json: %v", err)
+	}
+}
+
 func (c *client) mustSubmitForm(t *testing.T, form htmlForm, responseFunc func(*http.Response)) {
 	t.Helper()
 
@@ -356,6 +1390,11 @@ func (c *client) mustSubmitForm(t *testing.T, form htmlForm, responseFunc func(*
 	}
 }
 
+// encodeBytes encodes b like the browser does before submitting a passkey form.
+func encodeBytes(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
 func assertStatusCode(t *testing.T, status int) func(*http.Response) {
 	return func(res *http.Response) {
 		t.Helper()
@@ -366,6 +1405,18 @@ func assertStatusCode(t *testing.T, status int) func(*http.Response) {
 	}
 }
 
+func assertProblem(t *testing.T, status int) func(*http.Response) {
+	return func(res *http.Response) {
+		t.Helper()
+
+		assertStatusCode(t, status)(res)
+
+		if ct := res.Header.Get("Content-Type"); ct != "application/problem+json" {
+			t.Fatalf("expected problem details, got content type %q", ct)
+		}
+	}
+}
+
 func assertRedirectsTo(t *testing.T, location string, status int) func(*http.Response) {
 	return func(res *http.Response) {
 		if res.StatusCode != status {
@@ -475,6 +1526,82 @@ func addFormValues(t *testing.T, n *html.Node, vals *url.Values) {
 	}
 }
 
+// waitForMailbox waits for emails to appear in the development mailbox and returns
+// the URLs of the pages that show them.
+func (c *client) waitForMailbox(t *testing.T) []string {
+	t.Helper()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 1*time.Second)
+	defer cancel()
+
+	ticker := time.NewTicker(100 * time.Millisecond)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			body := c.mustGetBody(t, "/_dev/mailbox", assertStatusCode(t, http.StatusOK))
+			if links := parseHTMLLinksWithID(t, strings.NewReader(body), "mailbox"); len(links) > 0 {
+				return links
+			}
+		case <-ctx.Done():
+			t.Fatalf("timed out waiting for emails in the mailbox")
+		}
+	}
+}
+
+// parseHTMLLinksWithID returns the href of all links inside the element with the given id.
+func parseHTMLLinksWithID(t *testing.T, reader io.Reader, id string) []string {
+	t.Helper()
+
+	doc, err := html.Parse(reader)
+	if err != nil {
+		t.Fatalf("failed to parse html: %v", err)
+	}
+
+	node := findNodeWithID(doc, id)
+	if node == nil {
+		return nil
+	}
+
+	var links []string
+	var addLinks func(n *html.Node)
+	addLinks = func(n *html.Node) {
+		if n.Type == html.ElementNode && n.Data == "a" {
+			for _, a := range n.Attr {
+				if a.Key == "href" {
+					links = append(links, a.Val)
+				}
+			}
+		}
+
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			addLinks(c)
+		}
+	}
+	addLinks(node)
+
+	return links
+}
+
+func findNodeWithID(n *html.Node, id string) *html.Node {
+	if n.Type == html.ElementNode {
+		for _, a := range n.Attr {
+			if a.Key == "id" && a.Val == id {
+				return n
+			}
+		}
+	}
+
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if node := findNodeWithID(c, id); node != nil {
+			return node
+		}
+	}
+
+	return nil
+}
+
 func waitAndCaptureURL(t *testing.T, logs *safeBuffer, addr, urlPath string) *url.URL {
 	t.Helper()
 
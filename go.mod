@@ -6,6 +6,7 @@ require (
 	github.com/google/uuid v1.6.0
 	github.com/gorilla/csrf v1.7.2
 	github.com/gorilla/schema v1.4.1
+	github.com/gorilla/securecookie v1.1.2
 	github.com/gorilla/sessions v1.2.2
 	github.com/mattn/go-sqlite3 v1.14.22
 	golang.org/x/crypto v0.22.0
@@ -14,6 +15,5 @@ require (
 )
 
 require (
-	github.com/gorilla/securecookie v1.1.2 // indirect
 	golang.org/x/sys v0.19.0 // indirect
 )
@@ -0,0 +1,172 @@
+package auth
+
+import (
+	"errors"
+	"log/slog"
+	"slices"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/google/uuid"
+	"github.com/willemschots/househunt/internal/errorz"
+	"github.com/willemschots/househunt/internal/krypto"
+)
+
+const (
+	// apiTokenPrefix makes API tokens recognizable, for users and for secret scanners.
+	apiTokenPrefix = "hh_"
+
+	maxAPITokenNameRunes = 100
+	// maxAPITokenDays is the longest lifetime an API token can have.
+	maxAPITokenDays = 365
+	// maxAPITokens is the number of API tokens a user can have.
+	maxAPITokens = 20
+)
+
+var (
+	ErrInvalidAPIToken     = errors.New("invalid API token")
+	ErrInvalidScope        = errors.New("invalid scope")
+	ErrMissingScope        = errors.New("the API token is missing the required scope")
+	ErrInvalidAPITokenName = errors.New("name must be between 1 and 100 characters")
+	ErrNoScopes            = errors.New("select at least one scope")
+	ErrInvalidExpiry       = errors.New("expiry must be between 1 and 365 days")
+	ErrTooManyAPITokens    = errors.New("you have too many API tokens, delete one first")
+)
+
+// Scope limits what an API token can be used for. Requests authenticated by a session
+// are not limited by scopes.
+type Scope string
+
+const (
+	ScopeListingsRead   Scope = "listings:read"
+	ScopeListingsWrite  Scope = "listings:write"
+	ScopeResponsesRead  Scope = "responses:read"
+	ScopeResponsesWrite Scope = "responses:write"
+)
+
+// Scopes returns all scopes.
+func Scopes() []Scope {
+	return []Scope{ScopeListingsRead, ScopeListingsWrite, ScopeResponsesRead, ScopeResponsesWrite}
+}
+
+// ParseScope parses a scope from a string.
+func ParseScope(raw string) (Scope, error) {
+	s := Scope(raw)
+	if !slices.Contains(Scopes(), s) {
+		return "", ErrInvalidScope
+	}
+
+	return s, nil
+}
+
+func (s *Scope) UnmarshalText(text []byte) error {
+	scope, err := ParseScope(string(text))
+	if err != nil {
+		return err
+	}
+
+	*s = scope
+
+	return nil
+}
+
+// APIToken is a token a user created to access the JSON API from scripts and other
+// applications.
+type APIToken struct {
+	ID     uuid.UUID
+	UserID uuid.UUID
+	// Name is picked by the user to recognize the token.
+	Name   string
+	Scopes []Scope
+	// TokenHash is the hash of the token, the token itself is only shown to the user once.
+	TokenHash  krypto.Argon2Hash
+	ExpiresAt  time.Time
+	LastUsedAt *time.Time
+	CreatedAt  time.Time
+}
+
+// HasScope reports whether the token can be used for the scope.
+func (t APIToken) HasScope(s Scope) bool {
+	return slices.Contains(t.Scopes, s)
+}
+
+// IsExpired reports whether the token is expired at the provided time.
+func (t APIToken) IsExpired(now time.Time) bool {
+	return !now.Before(t.ExpiresAt)
+}
+
+// NewAPIToken is the input of a user creating an API token.
+type NewAPIToken struct {
+	Name   string
+	Scopes []Scope
+	// ExpiresInDays is the number of days the token can be used.
+	ExpiresInDays int
+}
+
+// Validate checks if the input is valid. If it's not, an errorz.InvalidInput
+// is returned containing an errorz.Keyed error for every invalid field.
+func (n NewAPIToken) Validate() error {
+	var invalid errorz.InvalidInput
+
+	if l := utf8.RuneCountInString(n.Name); l < 1 || l > maxAPITokenNameRunes {
+		invalid = append(invalid, errorz.Keyed{Key: "name", Err: ErrInvalidAPITokenName})
+	}
+
+	if len(n.Scopes) == 0 {
+		invalid = append(invalid, errorz.Keyed{Key: "scopes", Err: ErrNoScopes})
+	}
+
+	if n.ExpiresInDays < 1 || n.ExpiresInDays > maxAPITokenDays {
+		invalid = append(invalid, errorz.Keyed{Key: "expiresInDays", Err: ErrInvalidExpiry})
+	}
+
+	if len(invalid) > 0 {
+		return invalid
+	}
+
+	return nil
+}
+
+// APITokenRaw is the token as it is shown to the user once, and provided as
+// bearer token afterwards. It contains the ID, so that the token can be found
+// without comparing it to every hash.
+type APITokenRaw struct {
+	ID    uuid.UUID
+	Token krypto.Token
+}
+
+// ParseAPITokenRaw parses a raw API token from its string representation.
+func ParseAPITokenRaw(raw string) (APITokenRaw, error) {
+	rest, ok := strings.CutPrefix(raw, apiTokenPrefix)
+	if !ok {
+		return APITokenRaw{}, ErrInvalidAPIToken
+	}
+
+	rawID, rawToken, ok := strings.Cut(rest, "_")
+	if !ok {
+		return APITokenRaw{}, ErrInvalidAPIToken
+	}
+
+	id, err := uuid.Parse(rawID)
+	if err != nil {
+		return APITokenRaw{}, ErrInvalidAPIToken
+	}
+
+	token, err := krypto.ParseToken(rawToken)
+	if err != nil {
+		return APITokenRaw{}, ErrInvalidAPIToken
+	}
+
+	return APITokenRaw{ID: id, Token: token}, nil
+}
+
+// String returns the token as it should be shown to the user.
+func (r APITokenRaw) String() string {
+	return apiTokenPrefix + r.ID.String() + "_" + r.Token.String()
+}
+
+// LogValue implements the slog.Valuer interface.
+func (r APITokenRaw) LogValue() slog.Value {
+	return slog.StringValue(krypto.SecretMarker)
+}
@@ -0,0 +1,64 @@
+package auth_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/willemschots/househunt/internal/auth"
+	"github.com/willemschots/househunt/internal/krypto"
+)
+
+func Test_APITokenRaw_ParseString(t *testing.T) {
+	raw := auth.APITokenRaw{
+		ID:    must(uuid.Parse("597228ee-afde-4991-b13c-0161325e3930")),
+		Token: must(krypto.ParseToken("8c1e3b1dc3ef4a7a9b8c2d1e0f9a8b7c6d5e4f3a2b1c0d9e8f7a6b5c4d3e2f1a")),
+	}
+
+	t.Run("ok, round trip", func(t *testing.T) {
+		got, err := auth.ParseAPITokenRaw(raw.String())
+		if err != nil {
+			t.Fatalf("failed to parse API token: %v", err)
+		}
+
+		if got != raw {
+			t.Errorf("got %#v, want %#v", got, raw)
+		}
+	})
+
+	tests := map[string]string{
+		"fail, empty":          "",
+		"fail, no prefix":      "597228ee-afde-4991-b13c-0161325e3930_8c1e3b1dc3ef4a7a9b8c2d1e0f9a8b7c6d5e4f3a2b1c0d9e8f7a6b5c4d3e2f1a",
+		"fail, no token":       "hh_597228ee-afde-4991-b13c-0161325e3930",
+		"fail, invalid id":     "hh_597228ee_8c1e3b1dc3ef4a7a9b8c2d1e0f9a8b7c6d5e4f3a2b1c0d9e8f7a6b5c4d3e2f1a",
+		"fail, invalid token":  "hh_597228ee-afde-4991-b13c-0161325e3930_8c1e3b",
+		"fail, trailing parts": raw.String() + "_x",
+	}
+
+	for name, txt := range tests {
+		t.Run(name, func(t *testing.T) {
+			_, err := auth.ParseAPITokenRaw(txt)
+			if !errors.Is(err, auth.ErrInvalidAPIToken) {
+				t.Fatalf("expected error %v, got %v (via errors.Is)", auth.ErrInvalidAPIToken, err)
+			}
+		})
+	}
+}
+
+func Test_ParseScope(t *testing.T) {
+	for _, s := range auth.Scopes() {
+		t.Run("ok, "+string(s), func(t *testing.T) {
+			got, err := auth.ParseScope(string(s))
+			if err != nil || got != s {
+				t.Fatalf("expected %v, got %v (error %v)", s, got, err)
+			}
+		})
+	}
+
+	t.Run("fail, unknown scope", func(t *testing.T) {
+		_, err := auth.ParseScope("listings:delete")
+		if !errors.Is(err, auth.ErrInvalidScope) {
+			t.Fatalf("expected error %v, got %v (via errors.Is)", auth.ErrInvalidScope, err)
+		}
+	})
+}
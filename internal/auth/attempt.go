@@ -0,0 +1,30 @@
+package auth
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/willemschots/househunt/internal/email"
+)
+
+// Attempt is a recorded use of an email address for an action that is rate limited.
+// Attempts are only ever counted, the email address is stored as a blind index.
+type Attempt struct {
+	ID        uuid.UUID
+	Email     email.Address
+	Purpose   AttemptPurpose
+	CreatedAt time.Time
+}
+
+// AttemptPurpose is the action that was attempted.
+type AttemptPurpose string
+
+const (
+	// AttemptPurposeLogin indicates a failed login.
+	AttemptPurposeLogin AttemptPurpose = "login"
+	// AttemptPurposeSecondFactor indicates an invalid second factor code. These are
+	// kept apart from failed logins, so that a correct password doesn't reset them.
+	AttemptPurposeSecondFactor AttemptPurpose = "second_factor"
+	// AttemptPurposePasswordReset indicates a password reset request.
+	AttemptPurposePasswordReset AttemptPurpose = "password_reset"
+)
@@ -0,0 +1,30 @@
+package auth
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/willemschots/househunt/internal/email"
+)
+
+// EmailBounce is a recorded bounce or spam complaint for the email address of a user.
+// No more emails are sent to an address that bounced, until the user changes it.
+type EmailBounce struct {
+	ID     uuid.UUID
+	UserID uuid.UUID
+	// Email is the address that bounced, which was the address of the user at the time.
+	Email     email.Address
+	Kind      BounceKind
+	BouncedAt time.Time
+	CreatedAt time.Time
+}
+
+// BounceKind is the reason emails to an address bounced.
+type BounceKind string
+
+const (
+	// BounceKindHard indicates the address doesn't exist or permanently rejects emails.
+	BounceKindHard BounceKind = "hard_bounce"
+	// BounceKindSpamComplaint indicates the recipient marked an email as spam.
+	BounceKindSpamComplaint BounceKind = "spam_complaint"
+)
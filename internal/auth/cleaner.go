@@ -0,0 +1,148 @@
+package auth
+
+import (
+	"context"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// CleanerConfig is the configuration for the Cleaner.
+type CleanerConfig struct {
+	// Interval is the duration between cleanups.
+	Interval time.Duration
+	// TokenRetention is how long email tokens are kept after they were created, whether
+	// they were consumed or not. It should be longer than the token expiry, otherwise
+	// tokens are deleted while they are still valid. Zero disables the deletion of tokens.
+	TokenRetention time.Duration
+	// InactiveUserRetention is how long users that never activated their account are kept
+	// after they last requested an activation link. Zero disables the deletion of users.
+	InactiveUserRetention time.Duration
+	// AttemptRetention is how long failed logins and password reset requests are kept.
+	// It should be longer than the login lockout and the password reset window, otherwise
+	// attempts are deleted while they still count. Zero disables the deletion of attempts.
+	AttemptRetention time.Duration
+}
+
+// Cleaner periodically deletes data that is no longer needed: old email tokens and
+// attempts, expired sessions and users that never activated their account.
+type Cleaner struct {
+	store      Store
+	errHandler ErrFunc
+	cfg        CleanerConfig
+
+	// NowFunc is used to get the current time.
+	// Exposed for testing purposes.
+	NowFunc func() time.Time
+}
+
+// NewCleaner creates a new Cleaner.
+func NewCleaner(s Store, errHandler ErrFunc, cfg CleanerConfig) *Cleaner {
+	return &Cleaner{
+		store:      s,
+		errHandler: errHandler,
+		cfg:        cfg,
+		NowFunc:    time.Now,
+	}
+}
+
+// Run cleans up every interval until ctx is cancelled.
+// Errors are reported to the error handler, Run itself only returns
+// when ctx is done.
+func (c *Cleaner) Run(ctx context.Context) error {
+	ticker := time.NewTicker(c.cfg.Interval)
+	defer ticker.Stop()
+
+	for {
+		err := c.Clean(ctx)
+		if err != nil && ctx.Err() == nil {
+			c.errHandler(err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// Clean deletes the expired sessions, and the email tokens, attempts and inactive
+// users that are past their retention.
+func (c *Cleaner) Clean(ctx context.Context) error {
+	now := c.NowFunc()
+
+	return inTx(ctx, c.store, func(tx Tx) error {
+		// Expired sessions can't be used anymore, there is no reason to keep them.
+		txErr := tx.DeleteExpiredSessions(now)
+		if txErr != nil {
+			return txErr
+		}
+
+		if c.cfg.TokenRetention > 0 {
+			txErr = tx.DeleteEmailTokens(EmailTokenFilter{
+				CreatedBefore: ptr(now.Add(-c.cfg.TokenRetention)),
+			})
+			if txErr != nil {
+				return txErr
+			}
+		}
+
+		if c.cfg.AttemptRetention > 0 {
+			txErr = tx.DeleteAttempts(AttemptFilter{
+				CreatedBefore: ptr(now.Add(-c.cfg.AttemptRetention)),
+			})
+			if txErr != nil {
+				return txErr
+			}
+		}
+
+		if c.cfg.InactiveUserRetention > 0 {
+			return deleteInactiveUsers(tx, now.Add(-c.cfg.InactiveUserRetention))
+		}
+
+		return nil
+	})
+}
+
+// deleteInactiveUsers deletes the users that never activated their account, were
+// created before the provided time and didn't request an activation link after it.
+func deleteInactiveUsers(tx Tx, before time.Time) error {
+	users, err := tx.FindUsers(UserFilter{
+		IsActive:      ptr(false),
+		CreatedBefore: &before,
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, user := range users {
+		// Registering again reuses the inactive user, don't delete it
+		// while the new activation link can still be used.
+		tokens, err := tx.FindEmailTokens(EmailTokenFilter{
+			UserIDs: []uuid.UUID{user.ID},
+		})
+		if err != nil {
+			return err
+		}
+
+		recent := false
+		for _, tok := range tokens {
+			if !tok.CreatedAt.Before(before) {
+				recent = true
+				break
+			}
+		}
+
+		if recent {
+			continue
+		}
+
+		err = tx.DeleteUser(user.ID)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,295 @@
+package auth_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/willemschots/househunt/internal/auth"
+	"github.com/willemschots/househunt/internal/email"
+	"github.com/willemschots/househunt/internal/errorz/testerr"
+	"github.com/willemschots/househunt/internal/web/sessions"
+)
+
+func Test_Cleaner_Clean(t *testing.T) {
+	cfg := auth.CleanerConfig{
+		Interval:              time.Second,
+		TokenRetention:        24 * time.Hour,
+		InactiveUserRetention: 7 * 24 * time.Hour,
+		AttemptRetention:      24 * time.Hour,
+	}
+
+	t.Run("ok, delete old tokens", func(t *testing.T) {
+		st := newServiceTest(t)
+		st.svc.NowFunc = func() time.Time { return testNow }
+
+		credentials, activation := st.registerUser()
+		st.activateUser(activation)
+
+		st.svc.NowFunc = func() time.Time { return testNow.Add(2 * time.Hour) }
+		reset := st.requestPasswordReset(credentials.Email)
+
+		cleaner := auth.NewCleaner(st.store, st.errList.AppendErr, cfg)
+		cleaner.NowFunc = func() time.Time { return testNow.Add(25 * time.Hour) }
+
+		err := cleaner.Clean(context.Background())
+		if err != nil {
+			t.Fatalf("failed to clean: %v", err)
+		}
+
+		tokens := st.findEmailTokens()
+		if len(tokens) != 1 || tokens[0].ID != reset.ID {
+			t.Fatalf("expected only the password reset token to be kept, got %+v", tokens)
+		}
+
+		// Active users are never deleted.
+		st.findUser(credentials.Email)
+	})
+
+	t.Run("ok, delete inactive users", func(t *testing.T) {
+		st := newServiceTest(t)
+		st.svc.NowFunc = func() time.Time { return testNow }
+
+		credentials, _ := st.registerUser()
+		other := must(email.ParseAddress("jacob@example.com"))
+		st.registerOtherUser(other)
+		st.activateUser(st.emailer.lastEmail(t).data.(auth.EmailTokenRaw))
+
+		cleaner := auth.NewCleaner(st.store, st.errList.AppendErr, cfg)
+		cleaner.NowFunc = func() time.Time { return testNow.Add(cfg.InactiveUserRetention + time.Second) }
+
+		err := cleaner.Clean(context.Background())
+		if err != nil {
+			t.Fatalf("failed to clean: %v", err)
+		}
+
+		st.assertNoUser(credentials.Email)
+		st.findUser(other)
+	})
+
+	t.Run("ok, keep inactive users that registered again", func(t *testing.T) {
+		st := newServiceTest(t)
+		st.svc.NowFunc = func() time.Time { return testNow }
+		credentials, _ := st.registerUser()
+
+		st.svc.NowFunc = func() time.Time { return testNow.Add(cfg.InactiveUserRetention) }
+		_, activation := st.registerUser()
+
+		cleaner := auth.NewCleaner(st.store, st.errList.AppendErr, cfg)
+		cleaner.NowFunc = func() time.Time { return testNow.Add(cfg.InactiveUserRetention + time.Hour) }
+
+		err := cleaner.Clean(context.Background())
+		if err != nil {
+			t.Fatalf("failed to clean: %v", err)
+		}
+
+		st.findUser(credentials.Email)
+
+		// The new activation link can still be used.
+		st.svc.NowFunc = func() time.Time { return testNow.Add(cfg.InactiveUserRetention + time.Minute) }
+		st.activateUser(activation)
+	})
+
+	t.Run("ok, delete expired sessions", func(t *testing.T) {
+		st := newServiceTest(t)
+		st.svc.NowFunc = func() time.Time { return testNow }
+		credentials, activation := st.registerUser()
+		st.activateUser(activation)
+		user := st.findUser(credentials.Email)
+
+		expired := sessions.Record{
+			ID:        must(uuid.NewRandom()),
+			UserID:    &user.ID,
+			Data:      []byte{},
+			CreatedAt: testNow,
+			UpdatedAt: testNow,
+			ExpiresAt: testNow.Add(time.Hour),
+		}
+
+		valid := expired
+		valid.ID = must(uuid.NewRandom())
+		valid.ExpiresAt = testNow.Add(2 * time.Hour)
+
+		for _, r := range []sessions.Record{expired, valid} {
+			err := st.sessions.CreateRecord(context.Background(), r)
+			if err != nil {
+				t.Fatalf("failed to create session: %v", err)
+			}
+		}
+
+		cleaner := auth.NewCleaner(st.store, st.errList.AppendErr, auth.CleanerConfig{
+			Interval: time.Second,
+		})
+		cleaner.NowFunc = func() time.Time { return testNow.Add(time.Hour) }
+
+		err := cleaner.Clean(context.Background())
+		if err != nil {
+			t.Fatalf("failed to clean: %v", err)
+		}
+
+		records, err := st.sessions.FindRecords(context.Background(), sessions.RecordFilter{})
+		if err != nil {
+			t.Fatalf("failed to find sessions: %v", err)
+		}
+
+		if len(records) != 1 || records[0].ID != valid.ID {
+			t.Fatalf("expected only the valid session to be kept, got %+v", records)
+		}
+	})
+
+	t.Run("ok, delete old attempts", func(t *testing.T) {
+		st := newServiceTest(t)
+		st.svc.NowFunc = func() time.Time { return testNow }
+		credentials, activation := st.registerUser()
+		st.activateUser(activation)
+
+		// Failed logins are recorded as attempts.
+		wrong := auth.Credentials{Email: credentials.Email, Password: must(auth.ParsePassword("wrongPassword"))}
+		st.authenticate(wrong)
+
+		st.svc.NowFunc = func() time.Time { return testNow.Add(2 * time.Hour) }
+		st.authenticate(wrong)
+
+		cleaner := auth.NewCleaner(st.store, st.errList.AppendErr, cfg)
+		cleaner.NowFunc = func() time.Time { return testNow.Add(cfg.AttemptRetention + time.Hour) }
+
+		err := cleaner.Clean(context.Background())
+		if err != nil {
+			t.Fatalf("failed to clean: %v", err)
+		}
+
+		attempts, err := st.store.store.CountAttempts(context.Background(), auth.AttemptFilter{
+			Emails: []email.Address{credentials.Email},
+		})
+		if err != nil {
+			t.Fatalf("failed to count attempts: %v", err)
+		}
+
+		if attempts != 1 {
+			t.Fatalf("expected only the recent attempt to be kept, got %d attempts", attempts)
+		}
+	})
+
+	t.Run("ok, zero retention disables cleanup", func(t *testing.T) {
+		st := newServiceTest(t)
+		st.svc.NowFunc = func() time.Time { return testNow }
+		credentials, _ := st.registerUser()
+		st.authenticate(auth.Credentials{Email: credentials.Email, Password: must(auth.ParsePassword("wrongPassword"))})
+
+		cleaner := auth.NewCleaner(st.store, st.errList.AppendErr, auth.CleanerConfig{
+			Interval: time.Second,
+		})
+		cleaner.NowFunc = func() time.Time { return testNow.Add(365 * 24 * time.Hour) }
+
+		err := cleaner.Clean(context.Background())
+		if err != nil {
+			t.Fatalf("failed to clean: %v", err)
+		}
+
+		st.findUser(credentials.Email)
+		if tokens := st.findEmailTokens(); len(tokens) != 1 {
+			t.Fatalf("expected 1 email token, got %d", len(tokens))
+		}
+
+		attempts, err := st.store.store.CountAttempts(context.Background(), auth.AttemptFilter{})
+		if err != nil || attempts != 1 {
+			t.Fatalf("expected 1 attempt, got %d and error %v", attempts, err)
+		}
+	})
+
+	// BeginTx, DeleteExpiredSessions, DeleteEmailTokens, DeleteAttempts, FindUsers,
+	// FindEmailTokens, DeleteUser and Commit.
+	for _, tracker := range testerr.NewFailingDeps(testerr.Err, 8) {
+		t.Run("fail, store fails", func(t *testing.T) {
+			st := newServiceTest(t)
+			st.svc.NowFunc = func() time.Time { return testNow }
+			credentials, _ := st.registerUser()
+
+			cleaner := auth.NewCleaner(st.store, st.errList.AppendErr, cfg)
+			cleaner.NowFunc = func() time.Time { return testNow.Add(cfg.InactiveUserRetention + time.Second) }
+
+			st.store.tracker = &tracker
+
+			err := cleaner.Clean(context.Background())
+			if !errors.Is(err, testerr.Err) {
+				t.Fatalf("expected error %v, got %v (via errors.Is)", testerr.Err, err)
+			}
+
+			st.store.tracker = &testerr.Calltracker{}
+			st.findUser(credentials.Email)
+		})
+	}
+}
+
+func Test_Cleaner_Run(t *testing.T) {
+	st := newServiceTest(t)
+	st.svc.NowFunc = func() time.Time { return testNow }
+	credentials, _ := st.registerUser()
+
+	cleaner := auth.NewCleaner(st.store, st.errList.AppendErr, auth.CleanerConfig{
+		Interval:              time.Millisecond,
+		TokenRetention:        time.Hour,
+		InactiveUserRetention: time.Hour,
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- cleaner.Run(ctx)
+	}()
+
+	deadline := time.After(5 * time.Second)
+	for st.countUsers(credentials.Email) != 0 {
+		select {
+		case <-deadline:
+			t.Fatalf("user was not deleted in time")
+		case <-time.After(time.Millisecond):
+		}
+	}
+
+	cancel()
+
+	err := <-done
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	st.errList.assertNoError(t)
+}
+
+func (st *svcTest) findEmailTokens() []auth.EmailToken {
+	tx, err := st.store.store.BeginTx(context.Background())
+	if err != nil {
+		st.t.Fatalf("failed to begin tx: %v", err)
+	}
+
+	defer func() {
+		_ = tx.Rollback()
+	}()
+
+	tokens, err := tx.FindEmailTokens(auth.EmailTokenFilter{})
+	if err != nil {
+		st.t.Fatalf("failed to find email tokens: %v", err)
+	}
+
+	return tokens
+}
+
+func (st *svcTest) countUsers(addr email.Address) int {
+	users, err := st.store.store.FindUsers(context.Background(), auth.UserFilter{
+		Emails: []email.Address{addr},
+	})
+	if err != nil {
+		st.t.Fatalf("failed to find users: %v", err)
+	}
+
+	return len(users)
+}
+
+func (st *svcTest) assertNoUser(addr email.Address) {
+	if n := st.countUsers(addr); n != 0 {
+		st.t.Fatalf("expected no user, got %d", n)
+	}
+}
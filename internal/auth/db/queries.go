@@ -3,12 +3,14 @@ package db
 import (
 	"database/sql"
 	"fmt"
+	"strings"
 
 	"github.com/google/uuid"
 	"github.com/willemschots/househunt/internal/auth"
 	"github.com/willemschots/househunt/internal/db"
 	"github.com/willemschots/househunt/internal/email"
 	"github.com/willemschots/househunt/internal/errorz"
+	"github.com/willemschots/househunt/internal/krypto"
 )
 
 type execFunc func(query string, params ...any) (sql.Result, error)
@@ -19,14 +21,14 @@ func insertUser(q db.Query, ef execFunc, u auth.User) error {
 		return fmt.Errorf("zero uuid provided: %w", errorz.ErrConstraintViolated)
 	}
 
-	q.Unsafe(`INSERT INTO users (id, email_encrypted, email_blind_index, password_hash, is_active, created_at, updated_at) VALUES (`)
+	q.Unsafe(`INSERT INTO users (id, email_encrypted, email_blind_index, password_hash, role, is_active, created_at, updated_at) VALUES (`)
 	q.Param(u.ID)
 	q.Unsafe(`, `)
 	q.ParamEncrypted([]byte(u.Email))
 	q.Unsafe(`, `)
 	q.ParamBlindIndex([]byte(u.Email))
 	q.Unsafe(`, `)
-	q.Params(u.PasswordHash.String(), u.IsActive, u.CreatedAt, u.UpdatedAt)
+	q.Params(u.PasswordHash.String(), u.Role, u.IsActive, u.CreatedAt.UTC(), u.UpdatedAt)
 	q.Unsafe(`)`)
 
 	s, params, err := q.Get()
@@ -54,11 +56,14 @@ func updateUser(q db.Query, ef execFunc, u auth.User) error {
 	q.Unsafe(`, password_hash = `)
 	q.Param(u.PasswordHash.String())
 
+	q.Unsafe(`, role = `)
+	q.Param(u.Role)
+
 	q.Unsafe(`, is_active = `)
 	q.Param(u.IsActive)
 
 	q.Unsafe(`, created_at = `)
-	q.Param(u.CreatedAt)
+	q.Param(u.CreatedAt.UTC())
 
 	q.Unsafe(`, updated_at = `)
 	q.Param(u.UpdatedAt)
@@ -88,8 +93,34 @@ func updateUser(q db.Query, ef execFunc, u auth.User) error {
 	return nil
 }
 
+func deleteUser(q db.Query, ef execFunc, id uuid.UUID) error {
+	q.Unsafe(`DELETE FROM users WHERE id = `)
+	q.Param(id)
+
+	s, params, err := q.Get()
+	if err != nil {
+		return err
+	}
+
+	result, err := ef(s, params...)
+	if err != nil {
+		return errorz.MapDBErr(err)
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return errorz.MapDBErr(err)
+	}
+
+	if rows == 0 {
+		return fmt.Errorf("user not found: %w", errorz.ErrNotFound)
+	}
+
+	return nil
+}
+
 func selectUsers(q db.Query, qf queryFunc, f auth.UserFilter) ([]auth.User, error) {
-	q.Unsafe(`SELECT id, email_encrypted, password_hash, is_active, created_at, updated_at FROM users WHERE 1=1 `)
+	q.Unsafe(`SELECT id, email_encrypted, password_hash, role, is_active, created_at, updated_at FROM users WHERE 1=1 `)
 
 	if len(f.IDs) > 0 {
 		q.Unsafe(`AND id IN (`)
@@ -103,16 +134,28 @@ func selectUsers(q db.Query, qf queryFunc, f auth.UserFilter) ([]auth.User, erro
 			if i > 0 {
 				q.Unsafe(`, `)
 			}
-			q.ParamBlindIndex([]byte(email))
+			q.ParamBlindIndexes([]byte(email))
 		}
 		q.Unsafe(`)`)
 	}
 
+	if len(f.Roles) > 0 {
+		q.Unsafe(`AND role IN (`)
+		q.Params(anySlice(f.Roles)...)
+		q.Unsafe(`)`)
+	}
+
 	if f.IsActive != nil {
 		q.Unsafe("AND is_active = ")
 		q.Param(f.IsActive)
 	}
 
+	if f.CreatedBefore != nil {
+		// created_at is always stored in UTC, so that it can be compared as text.
+		q.Unsafe(` AND created_at < `)
+		q.Param(f.CreatedBefore.UTC())
+	}
+
 	q.Unsafe(` ORDER BY id ASC`)
 
 	s, params, err := q.Get()
@@ -131,7 +174,7 @@ func selectUsers(q db.Query, qf queryFunc, f auth.UserFilter) ([]auth.User, erro
 	for rows.Next() {
 		var u auth.User
 		emailBytes := q.DecryptionTarget()
-		err := rows.Scan(&u.ID, emailBytes, &u.PasswordHash, &u.IsActive, &u.CreatedAt, &u.UpdatedAt)
+		err := rows.Scan(&u.ID, emailBytes, &u.PasswordHash, &u.Role, &u.IsActive, &u.CreatedAt, &u.UpdatedAt)
 		if err != nil {
 			return nil, errorz.MapDBErr(err)
 		}
@@ -161,7 +204,7 @@ func insertEmailToken(q db.Query, ef execFunc, tok auth.EmailToken) error {
 	q.Unsafe(`, `)
 	q.ParamEncrypted([]byte(tok.Email))
 	q.Unsafe(`, `)
-	q.Params(tok.Purpose, tok.CreatedAt, tok.ConsumedAt)
+	q.Params(tok.Purpose, tok.CreatedAt.UTC(), tok.ConsumedAt)
 	q.Unsafe(`)`)
 
 	s, params, err := q.Get()
@@ -193,7 +236,7 @@ func updateEmailToken(q db.Query, ef execFunc, tok auth.EmailToken) error {
 	q.Param(tok.Purpose)
 
 	q.Unsafe(`, created_at = `)
-	q.Param(tok.CreatedAt)
+	q.Param(tok.CreatedAt.UTC())
 
 	q.Unsafe(`, consumed_at = `)
 	q.Param(tok.ConsumedAt)
@@ -225,7 +268,93 @@ func updateEmailToken(q db.Query, ef execFunc, tok auth.EmailToken) error {
 
 func selectEmailTokens(q db.Query, qf queryFunc, f auth.EmailTokenFilter) ([]auth.EmailToken, error) {
 	q.Unsafe(`SELECT id, token_hash, user_id, email_encrypted, purpose, created_at, consumed_at FROM email_tokens WHERE 1=1 `)
+	whereEmailTokens(&q, f)
+
+	s, params, err := q.Get()
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := qf(s, params...)
+	if err != nil {
+		return nil, errorz.MapDBErr(err)
+	}
+
+	defer rows.Close()
+
+	out := make([]auth.EmailToken, 0)
+	for rows.Next() {
+		var token auth.EmailToken
+		emailBytes := q.DecryptionTarget()
+		err := rows.Scan(&token.ID, &token.TokenHash, &token.UserID, emailBytes, &token.Purpose, &token.CreatedAt, &token.ConsumedAt)
+		if err != nil {
+			return nil, errorz.MapDBErr(err)
+		}
+
+		token.Email, err = email.ParseAddress(string(emailBytes.Data))
+		if err != nil {
+			return nil, err
+		}
+
+		out = append(out, token)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, errorz.MapDBErr(err)
+	}
+
+	return out, nil
+}
+
+func countEmailTokens(q db.Query, qf queryFunc, f auth.EmailTokenFilter) (int, error) {
+	q.Unsafe(`SELECT COUNT(*) FROM email_tokens WHERE 1=1 `)
+	whereEmailTokens(&q, f)
+
+	s, params, err := q.Get()
+	if err != nil {
+		return 0, err
+	}
+
+	rows, err := qf(s, params...)
+	if err != nil {
+		return 0, errorz.MapDBErr(err)
+	}
+
+	defer rows.Close()
+
+	count := 0
+	if rows.Next() {
+		err := rows.Scan(&count)
+		if err != nil {
+			return 0, errorz.MapDBErr(err)
+		}
+	}
+
+	if err := rows.Err(); err != nil {
+		return 0, errorz.MapDBErr(err)
+	}
+
+	return count, nil
+}
+
+func deleteEmailTokens(q db.Query, ef execFunc, f auth.EmailTokenFilter) error {
+	q.Unsafe(`DELETE FROM email_tokens WHERE 1=1 `)
+	whereEmailTokens(&q, f)
+
+	s, params, err := q.Get()
+	if err != nil {
+		return err
+	}
+
+	_, err = ef(s, params...)
+	if err != nil {
+		return errorz.MapDBErr(err)
+	}
 
+	return nil
+}
+
+func whereEmailTokens(q *db.Query, f auth.EmailTokenFilter) {
 	if len(f.IDs) > 0 {
 		q.Unsafe(`AND id IN (`)
 		q.Params(anySlice(f.IDs)...)
@@ -252,6 +381,218 @@ func selectEmailTokens(q db.Query, qf queryFunc, f auth.EmailTokenFilter) ([]aut
 		q.Unsafe("NULL ")
 	}
 
+	if f.CreatedAfter != nil {
+		// created_at is always stored in UTC, so that it can be compared as text.
+		q.Unsafe(`AND created_at > `)
+		q.Param(f.CreatedAfter.UTC())
+		q.Unsafe(` `)
+	}
+
+	if f.CreatedBefore != nil {
+		q.Unsafe(`AND created_at < `)
+		q.Param(f.CreatedBefore.UTC())
+		q.Unsafe(` `)
+	}
+}
+
+func insertAttempt(q db.Query, ef execFunc, a auth.Attempt) error {
+	if a.ID == uuid.Nil {
+		return fmt.Errorf("zero uuid provided: %w", errorz.ErrConstraintViolated)
+	}
+
+	q.Unsafe(`INSERT INTO attempts (id, email_blind_index, purpose, created_at) VALUES (`)
+	q.Param(a.ID)
+	q.Unsafe(`, `)
+	q.ParamBlindIndex([]byte(a.Email))
+	q.Unsafe(`, `)
+	q.Params(a.Purpose, a.CreatedAt.UTC())
+	q.Unsafe(`)`)
+
+	s, params, err := q.Get()
+	if err != nil {
+		return err
+	}
+
+	_, err = ef(s, params...)
+	if err != nil {
+		return errorz.MapDBErr(err)
+	}
+
+	return nil
+}
+
+func countAttempts(q db.Query, qf queryFunc, f auth.AttemptFilter) (int, error) {
+	q.Unsafe(`SELECT COUNT(*) FROM attempts WHERE 1=1 `)
+	whereAttempts(&q, f)
+
+	s, params, err := q.Get()
+	if err != nil {
+		return 0, err
+	}
+
+	rows, err := qf(s, params...)
+	if err != nil {
+		return 0, errorz.MapDBErr(err)
+	}
+
+	defer rows.Close()
+
+	count := 0
+	if rows.Next() {
+		err := rows.Scan(&count)
+		if err != nil {
+			return 0, errorz.MapDBErr(err)
+		}
+	}
+
+	if err := rows.Err(); err != nil {
+		return 0, errorz.MapDBErr(err)
+	}
+
+	return count, nil
+}
+
+func deleteAttempts(q db.Query, ef execFunc, f auth.AttemptFilter) error {
+	q.Unsafe(`DELETE FROM attempts WHERE 1=1 `)
+	whereAttempts(&q, f)
+
+	s, params, err := q.Get()
+	if err != nil {
+		return err
+	}
+
+	_, err = ef(s, params...)
+	if err != nil {
+		return errorz.MapDBErr(err)
+	}
+
+	return nil
+}
+
+func whereAttempts(q *db.Query, f auth.AttemptFilter) {
+	if len(f.Emails) > 0 {
+		q.Unsafe(`AND email_blind_index IN (`)
+		for i, email := range f.Emails {
+			if i > 0 {
+				q.Unsafe(`, `)
+			}
+			q.ParamBlindIndexes([]byte(email))
+		}
+		q.Unsafe(`) `)
+	}
+
+	if len(f.Purposes) > 0 {
+		q.Unsafe(`AND purpose IN (`)
+		q.Params(anySlice(f.Purposes)...)
+		q.Unsafe(`) `)
+	}
+
+	if f.CreatedAfter != nil {
+		// created_at is always stored in UTC, so that it can be compared as text.
+		q.Unsafe(`AND created_at > `)
+		q.Param(f.CreatedAfter.UTC())
+		q.Unsafe(` `)
+	}
+
+	if f.CreatedBefore != nil {
+		// created_at is always stored in UTC, so that it can be compared as text.
+		q.Unsafe(`AND created_at < `)
+		q.Param(f.CreatedBefore.UTC())
+		q.Unsafe(` `)
+	}
+}
+
+func insertTOTP(q db.Query, ef execFunc, c auth.TOTP) error {
+	if c.UserID == uuid.Nil {
+		return fmt.Errorf("zero uuid provided: %w", errorz.ErrConstraintViolated)
+	}
+
+	q.Unsafe(`INSERT INTO totp_credentials (user_id, secret_encrypted, last_used_step, confirmed_at, created_at, updated_at) VALUES (`)
+	q.Param(c.UserID)
+	q.Unsafe(`, `)
+	q.ParamEncrypted(c.Secret[:])
+	q.Unsafe(`, `)
+	q.Params(c.LastUsedStep, c.ConfirmedAt, c.CreatedAt, c.UpdatedAt)
+	q.Unsafe(`)`)
+
+	s, params, err := q.Get()
+	if err != nil {
+		return err
+	}
+
+	_, err = ef(s, params...)
+	if err != nil {
+		return errorz.MapDBErr(err)
+	}
+
+	return nil
+}
+
+func updateTOTP(q db.Query, ef execFunc, c auth.TOTP) error {
+	q.Unsafe(`UPDATE totp_credentials SET `)
+
+	q.Unsafe(`secret_encrypted = `)
+	q.ParamEncrypted(c.Secret[:])
+
+	q.Unsafe(`, last_used_step = `)
+	q.Param(c.LastUsedStep)
+
+	q.Unsafe(`, confirmed_at = `)
+	q.Param(c.ConfirmedAt)
+
+	q.Unsafe(`, created_at = `)
+	q.Param(c.CreatedAt)
+
+	q.Unsafe(`, updated_at = `)
+	q.Param(c.UpdatedAt)
+
+	q.Unsafe(` WHERE user_id = `)
+	q.Param(c.UserID)
+
+	s, params, err := q.Get()
+	if err != nil {
+		return err
+	}
+
+	result, err := ef(s, params...)
+	if err != nil {
+		return errorz.MapDBErr(err)
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return errorz.MapDBErr(err)
+	}
+
+	if rows == 0 {
+		return fmt.Errorf("totp credential not found: %w", errorz.ErrNotFound)
+	}
+
+	return nil
+}
+
+func deleteTOTPs(q db.Query, ef execFunc, f auth.TOTPFilter) error {
+	q.Unsafe(`DELETE FROM totp_credentials WHERE 1=1 `)
+	whereTOTPs(&q, f)
+
+	s, params, err := q.Get()
+	if err != nil {
+		return err
+	}
+
+	_, err = ef(s, params...)
+	if err != nil {
+		return errorz.MapDBErr(err)
+	}
+
+	return nil
+}
+
+func selectTOTPs(q db.Query, qf queryFunc, f auth.TOTPFilter) ([]auth.TOTP, error) {
+	q.Unsafe(`SELECT user_id, secret_encrypted, last_used_step, confirmed_at, created_at, updated_at FROM totp_credentials WHERE 1=1 `)
+	whereTOTPs(&q, f)
+	q.Unsafe(`ORDER BY user_id ASC`)
+
 	s, params, err := q.Get()
 	if err != nil {
 		return nil, err
@@ -264,21 +605,21 @@ func selectEmailTokens(q db.Query, qf queryFunc, f auth.EmailTokenFilter) ([]aut
 
 	defer rows.Close()
 
-	out := make([]auth.EmailToken, 0)
+	out := make([]auth.TOTP, 0)
 	for rows.Next() {
-		var token auth.EmailToken
-		emailBytes := q.DecryptionTarget()
-		err := rows.Scan(&token.ID, &token.TokenHash, &token.UserID, emailBytes, &token.Purpose, &token.CreatedAt, &token.ConsumedAt)
+		var c auth.TOTP
+		secretBytes := q.DecryptionTarget()
+		err := rows.Scan(&c.UserID, secretBytes, &c.LastUsedStep, &c.ConfirmedAt, &c.CreatedAt, &c.UpdatedAt)
 		if err != nil {
 			return nil, errorz.MapDBErr(err)
 		}
 
-		token.Email, err = email.ParseAddress(string(emailBytes.Data))
-		if err != nil {
-			return nil, err
+		if len(secretBytes.Data) != len(c.Secret) {
+			return nil, krypto.ErrInvalidTOTPSecret
 		}
+		copy(c.Secret[:], secretBytes.Data)
 
-		out = append(out, token)
+		out = append(out, c)
 	}
 
 	if err := rows.Err(); err != nil {
@@ -288,6 +629,583 @@ func selectEmailTokens(q db.Query, qf queryFunc, f auth.EmailTokenFilter) ([]aut
 	return out, nil
 }
 
+func whereTOTPs(q *db.Query, f auth.TOTPFilter) {
+	if len(f.UserIDs) > 0 {
+		q.Unsafe(`AND user_id IN (`)
+		q.Params(anySlice(f.UserIDs)...)
+		q.Unsafe(`) `)
+	}
+
+	if f.IsConfirmed != nil {
+		q.Unsafe(`AND confirmed_at IS `)
+		if *f.IsConfirmed {
+			q.Unsafe(`NOT `)
+		}
+		q.Unsafe(`NULL `)
+	}
+}
+
+func insertRecoveryCode(q db.Query, ef execFunc, c auth.RecoveryCode) error {
+	if c.ID == uuid.Nil {
+		return fmt.Errorf("zero uuid provided: %w", errorz.ErrConstraintViolated)
+	}
+
+	q.Unsafe(`INSERT INTO recovery_codes (id, user_id, code_hash, created_at, used_at) VALUES (`)
+	q.Params(c.ID, c.UserID, c.CodeHash.String(), c.CreatedAt, c.UsedAt)
+	q.Unsafe(`)`)
+
+	s, params, err := q.Get()
+	if err != nil {
+		return err
+	}
+
+	_, err = ef(s, params...)
+	if err != nil {
+		return errorz.MapDBErr(err)
+	}
+
+	return nil
+}
+
+func updateRecoveryCode(q db.Query, ef execFunc, c auth.RecoveryCode) error {
+	q.Unsafe(`UPDATE recovery_codes SET `)
+
+	q.Unsafe(`user_id = `)
+	q.Param(c.UserID)
+
+	q.Unsafe(`, code_hash = `)
+	q.Param(c.CodeHash.String())
+
+	q.Unsafe(`, created_at = `)
+	q.Param(c.CreatedAt)
+
+	q.Unsafe(`, used_at = `)
+	q.Param(c.UsedAt)
+
+	q.Unsafe(` WHERE id = `)
+	q.Param(c.ID)
+
+	s, params, err := q.Get()
+	if err != nil {
+		return err
+	}
+
+	result, err := ef(s, params...)
+	if err != nil {
+		return errorz.MapDBErr(err)
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return errorz.MapDBErr(err)
+	}
+
+	if rows == 0 {
+		return fmt.Errorf("recovery code not found: %w", errorz.ErrNotFound)
+	}
+
+	return nil
+}
+
+func deleteRecoveryCodes(q db.Query, ef execFunc, f auth.RecoveryCodeFilter) error {
+	q.Unsafe(`DELETE FROM recovery_codes WHERE 1=1 `)
+	whereRecoveryCodes(&q, f)
+
+	s, params, err := q.Get()
+	if err != nil {
+		return err
+	}
+
+	_, err = ef(s, params...)
+	if err != nil {
+		return errorz.MapDBErr(err)
+	}
+
+	return nil
+}
+
+func selectRecoveryCodes(q db.Query, qf queryFunc, f auth.RecoveryCodeFilter) ([]auth.RecoveryCode, error) {
+	q.Unsafe(`SELECT id, user_id, code_hash, created_at, used_at FROM recovery_codes WHERE 1=1 `)
+	whereRecoveryCodes(&q, f)
+	q.Unsafe(`ORDER BY created_at ASC, id ASC`)
+
+	s, params, err := q.Get()
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := qf(s, params...)
+	if err != nil {
+		return nil, errorz.MapDBErr(err)
+	}
+
+	defer rows.Close()
+
+	out := make([]auth.RecoveryCode, 0)
+	for rows.Next() {
+		var c auth.RecoveryCode
+		err := rows.Scan(&c.ID, &c.UserID, &c.CodeHash, &c.CreatedAt, &c.UsedAt)
+		if err != nil {
+			return nil, errorz.MapDBErr(err)
+		}
+
+		out = append(out, c)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, errorz.MapDBErr(err)
+	}
+
+	return out, nil
+}
+
+func whereRecoveryCodes(q *db.Query, f auth.RecoveryCodeFilter) {
+	if len(f.IDs) > 0 {
+		q.Unsafe(`AND id IN (`)
+		q.Params(anySlice(f.IDs)...)
+		q.Unsafe(`) `)
+	}
+
+	if len(f.UserIDs) > 0 {
+		q.Unsafe(`AND user_id IN (`)
+		q.Params(anySlice(f.UserIDs)...)
+		q.Unsafe(`) `)
+	}
+
+	if f.IsUsed != nil {
+		q.Unsafe(`AND used_at IS `)
+		if *f.IsUsed {
+			q.Unsafe(`NOT `)
+		}
+		q.Unsafe(`NULL `)
+	}
+}
+
+func insertPasskey(q db.Query, ef execFunc, p auth.Passkey) error {
+	if p.ID == uuid.Nil {
+		return fmt.Errorf("zero uuid provided: %w", errorz.ErrConstraintViolated)
+	}
+
+	q.Unsafe(`INSERT INTO passkeys (id, user_id, credential_id, public_key, sign_count, created_at, last_used_at) VALUES (`)
+	q.Params(p.ID, p.UserID, p.CredentialID, p.PublicKey, p.SignCount, p.CreatedAt, p.LastUsedAt)
+	q.Unsafe(`)`)
+
+	s, params, err := q.Get()
+	if err != nil {
+		return err
+	}
+
+	_, err = ef(s, params...)
+	if err != nil {
+		return errorz.MapDBErr(err)
+	}
+
+	return nil
+}
+
+func updatePasskey(q db.Query, ef execFunc, p auth.Passkey) error {
+	q.Unsafe(`UPDATE passkeys SET `)
+
+	q.Unsafe(`user_id = `)
+	q.Param(p.UserID)
+
+	q.Unsafe(`, credential_id = `)
+	q.Param(p.CredentialID)
+
+	q.Unsafe(`, public_key = `)
+	q.Param(p.PublicKey)
+
+	q.Unsafe(`, sign_count = `)
+	q.Param(p.SignCount)
+
+	q.Unsafe(`, created_at = `)
+	q.Param(p.CreatedAt)
+
+	q.Unsafe(`, last_used_at = `)
+	q.Param(p.LastUsedAt)
+
+	q.Unsafe(` WHERE id = `)
+	q.Param(p.ID)
+
+	s, params, err := q.Get()
+	if err != nil {
+		return err
+	}
+
+	result, err := ef(s, params...)
+	if err != nil {
+		return errorz.MapDBErr(err)
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return errorz.MapDBErr(err)
+	}
+
+	if rows == 0 {
+		return fmt.Errorf("passkey not found: %w", errorz.ErrNotFound)
+	}
+
+	return nil
+}
+
+func deletePasskeys(q db.Query, ef execFunc, f auth.PasskeyFilter) error {
+	q.Unsafe(`DELETE FROM passkeys WHERE 1=1 `)
+	wherePasskeys(&q, f)
+
+	s, params, err := q.Get()
+	if err != nil {
+		return err
+	}
+
+	_, err = ef(s, params...)
+	if err != nil {
+		return errorz.MapDBErr(err)
+	}
+
+	return nil
+}
+
+func selectPasskeys(q db.Query, qf queryFunc, f auth.PasskeyFilter) ([]auth.Passkey, error) {
+	q.Unsafe(`SELECT id, user_id, credential_id, public_key, sign_count, created_at, last_used_at FROM passkeys WHERE 1=1 `)
+	wherePasskeys(&q, f)
+	q.Unsafe(`ORDER BY created_at ASC, id ASC`)
+
+	s, params, err := q.Get()
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := qf(s, params...)
+	if err != nil {
+		return nil, errorz.MapDBErr(err)
+	}
+
+	defer rows.Close()
+
+	out := make([]auth.Passkey, 0)
+	for rows.Next() {
+		var p auth.Passkey
+		err := rows.Scan(&p.ID, &p.UserID, &p.CredentialID, &p.PublicKey, &p.SignCount, &p.CreatedAt, &p.LastUsedAt)
+		if err != nil {
+			return nil, errorz.MapDBErr(err)
+		}
+
+		out = append(out, p)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, errorz.MapDBErr(err)
+	}
+
+	return out, nil
+}
+
+func wherePasskeys(q *db.Query, f auth.PasskeyFilter) {
+	if len(f.IDs) > 0 {
+		q.Unsafe(`AND id IN (`)
+		q.Params(anySlice(f.IDs)...)
+		q.Unsafe(`) `)
+	}
+
+	if len(f.UserIDs) > 0 {
+		q.Unsafe(`AND user_id IN (`)
+		q.Params(anySlice(f.UserIDs)...)
+		q.Unsafe(`) `)
+	}
+
+	if len(f.CredentialIDs) > 0 {
+		q.Unsafe(`AND credential_id IN (`)
+		q.Params(anySlice(f.CredentialIDs)...)
+		q.Unsafe(`) `)
+	}
+}
+
+func insertAPIToken(q db.Query, ef execFunc, t auth.APIToken) error {
+	if t.ID == uuid.Nil {
+		return fmt.Errorf("zero uuid provided: %w", errorz.ErrConstraintViolated)
+	}
+
+	q.Unsafe(`INSERT INTO api_tokens (id, user_id, name, scopes, token_hash, expires_at, last_used_at, created_at) VALUES (`)
+	q.Params(t.ID, t.UserID, t.Name, joinScopes(t.Scopes), t.TokenHash.String(), t.ExpiresAt, t.LastUsedAt, t.CreatedAt)
+	q.Unsafe(`)`)
+
+	s, params, err := q.Get()
+	if err != nil {
+		return err
+	}
+
+	_, err = ef(s, params...)
+	if err != nil {
+		return errorz.MapDBErr(err)
+	}
+
+	return nil
+}
+
+func updateAPIToken(q db.Query, ef execFunc, t auth.APIToken) error {
+	q.Unsafe(`UPDATE api_tokens SET `)
+
+	q.Unsafe(`user_id = `)
+	q.Param(t.UserID)
+
+	q.Unsafe(`, name = `)
+	q.Param(t.Name)
+
+	q.Unsafe(`, scopes = `)
+	q.Param(joinScopes(t.Scopes))
+
+	q.Unsafe(`, token_hash = `)
+	q.Param(t.TokenHash.String())
+
+	q.Unsafe(`, expires_at = `)
+	q.Param(t.ExpiresAt)
+
+	q.Unsafe(`, last_used_at = `)
+	q.Param(t.LastUsedAt)
+
+	q.Unsafe(`, created_at = `)
+	q.Param(t.CreatedAt)
+
+	q.Unsafe(` WHERE id = `)
+	q.Param(t.ID)
+
+	s, params, err := q.Get()
+	if err != nil {
+		return err
+	}
+
+	result, err := ef(s, params...)
+	if err != nil {
+		return errorz.MapDBErr(err)
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return errorz.MapDBErr(err)
+	}
+
+	if rows == 0 {
+		return fmt.Errorf("api token not found: %w", errorz.ErrNotFound)
+	}
+
+	return nil
+}
+
+func deleteAPITokens(q db.Query, ef execFunc, f auth.APITokenFilter) error {
+	q.Unsafe(`DELETE FROM api_tokens WHERE 1=1 `)
+	whereAPITokens(&q, f)
+
+	s, params, err := q.Get()
+	if err != nil {
+		return err
+	}
+
+	_, err = ef(s, params...)
+	if err != nil {
+		return errorz.MapDBErr(err)
+	}
+
+	return nil
+}
+
+func selectAPITokens(q db.Query, qf queryFunc, f auth.APITokenFilter) ([]auth.APIToken, error) {
+	q.Unsafe(`SELECT id, user_id, name, scopes, token_hash, expires_at, last_used_at, created_at FROM api_tokens WHERE 1=1 `)
+	whereAPITokens(&q, f)
+	q.Unsafe(`ORDER BY created_at ASC, id ASC`)
+
+	s, params, err := q.Get()
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := qf(s, params...)
+	if err != nil {
+		return nil, errorz.MapDBErr(err)
+	}
+
+	defer rows.Close()
+
+	out := make([]auth.APIToken, 0)
+	for rows.Next() {
+		var (
+			t      auth.APIToken
+			scopes string
+		)
+		err := rows.Scan(&t.ID, &t.UserID, &t.Name, &scopes, &t.TokenHash, &t.ExpiresAt, &t.LastUsedAt, &t.CreatedAt)
+		if err != nil {
+			return nil, errorz.MapDBErr(err)
+		}
+
+		t.Scopes, err = splitScopes(scopes)
+		if err != nil {
+			return nil, err
+		}
+
+		out = append(out, t)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, errorz.MapDBErr(err)
+	}
+
+	return out, nil
+}
+
+func whereAPITokens(q *db.Query, f auth.APITokenFilter) {
+	if len(f.IDs) > 0 {
+		q.Unsafe(`AND id IN (`)
+		q.Params(anySlice(f.IDs)...)
+		q.Unsafe(`) `)
+	}
+
+	if len(f.UserIDs) > 0 {
+		q.Unsafe(`AND user_id IN (`)
+		q.Params(anySlice(f.UserIDs)...)
+		q.Unsafe(`) `)
+	}
+}
+
+func insertEmailBounce(q db.Query, ef execFunc, b auth.EmailBounce) error {
+	if b.ID == uuid.Nil || b.UserID == uuid.Nil {
+		return fmt.Errorf("zero uuid provided: %w", errorz.ErrConstraintViolated)
+	}
+
+	q.Unsafe(`INSERT INTO email_bounces (id, user_id, email_encrypted, email_blind_index, kind, bounced_at, created_at) VALUES (`)
+	q.Params(b.ID, b.UserID)
+	q.Unsafe(`, `)
+	q.ParamEncrypted([]byte(b.Email))
+	q.Unsafe(`, `)
+	q.ParamBlindIndex([]byte(b.Email))
+	q.Unsafe(`, `)
+	q.Params(b.Kind, b.BouncedAt.UTC(), b.CreatedAt.UTC())
+	q.Unsafe(`)`)
+
+	s, params, err := q.Get()
+	if err != nil {
+		return err
+	}
+
+	_, err = ef(s, params...)
+	if err != nil {
+		return errorz.MapDBErr(err)
+	}
+
+	return nil
+}
+
+func selectEmailBounces(q db.Query, qf queryFunc, f auth.EmailBounceFilter) ([]auth.EmailBounce, error) {
+	q.Unsafe(`SELECT id, user_id, email_encrypted, kind, bounced_at, created_at FROM email_bounces WHERE 1=1 `)
+	whereEmailBounces(&q, f)
+	q.Unsafe(`ORDER BY bounced_at ASC, id ASC`)
+
+	s, params, err := q.Get()
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := qf(s, params...)
+	if err != nil {
+		return nil, errorz.MapDBErr(err)
+	}
+
+	defer rows.Close()
+
+	out := make([]auth.EmailBounce, 0)
+	for rows.Next() {
+		var b auth.EmailBounce
+		emailBytes := q.DecryptionTarget()
+		err := rows.Scan(&b.ID, &b.UserID, emailBytes, &b.Kind, &b.BouncedAt, &b.CreatedAt)
+		if err != nil {
+			return nil, errorz.MapDBErr(err)
+		}
+
+		b.Email, err = email.ParseAddress(string(emailBytes.Data))
+		if err != nil {
+			return nil, err
+		}
+
+		out = append(out, b)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, errorz.MapDBErr(err)
+	}
+
+	return out, nil
+}
+
+func countEmailBounces(q db.Query, qf queryFunc, f auth.EmailBounceFilter) (int, error) {
+	q.Unsafe(`SELECT COUNT(*) FROM email_bounces WHERE 1=1 `)
+	whereEmailBounces(&q, f)
+
+	s, params, err := q.Get()
+	if err != nil {
+		return 0, err
+	}
+
+	rows, err := qf(s, params...)
+	if err != nil {
+		return 0, errorz.MapDBErr(err)
+	}
+
+	defer rows.Close()
+
+	count := 0
+	if rows.Next() {
+		err := rows.Scan(&count)
+		if err != nil {
+			return 0, errorz.MapDBErr(err)
+		}
+	}
+
+	if err := rows.Err(); err != nil {
+		return 0, errorz.MapDBErr(err)
+	}
+
+	return count, nil
+}
+
+func whereEmailBounces(q *db.Query, f auth.EmailBounceFilter) {
+	if len(f.UserIDs) > 0 {
+		q.Unsafe(`AND user_id IN (`)
+		q.Params(anySlice(f.UserIDs)...)
+		q.Unsafe(`) `)
+	}
+
+	if len(f.Emails) > 0 {
+		q.Unsafe(`AND email_blind_index IN (`)
+		for i, email := range f.Emails {
+			if i > 0 {
+				q.Unsafe(`, `)
+			}
+			q.ParamBlindIndexes([]byte(email))
+		}
+		q.Unsafe(`) `)
+	}
+}
+
+// joinScopes stores scopes as a space separated list, like OAuth does.
+func joinScopes(scopes []auth.Scope) string {
+	raw := make([]string, 0, len(scopes))
+	for _, s := range scopes {
+		raw = append(raw, string(s))
+	}
+	return strings.Join(raw, " ")
+}
+
+func splitScopes(raw string) ([]auth.Scope, error) {
+	out := make([]auth.Scope, 0)
+	for _, r := range strings.Fields(raw) {
+		s, err := auth.ParseScope(r)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, s)
+	}
+	return out, nil
+}
+
 func anySlice[T any](s []T) []any {
 	out := make([]any, 0, len(s))
 	for _, v := range s {
@@ -15,26 +15,26 @@ type NowFunc func() time.Time
 
 // Store is responsible for interacting with a database.
 type Store struct {
-	writeDB       *sql.DB
-	readDB        *sql.DB
-	encryptor     *krypto.Encryptor
-	blindIndexKey krypto.Key
+	writeDB      *sql.DB
+	readDB       *sql.DB
+	encryptor    *krypto.Encryptor
+	blindIndexer *krypto.BlindIndexer
 }
 
 // New creates a new Store.
-func New(writeDB, readDB *sql.DB, encryptor *krypto.Encryptor, blindIndexKey krypto.Key) *Store {
+func New(writeDB, readDB *sql.DB, encryptor *krypto.Encryptor, blindIndexer *krypto.BlindIndexer) *Store {
 	return &Store{
-		writeDB:       writeDB,
-		readDB:        readDB,
-		encryptor:     encryptor,
-		blindIndexKey: blindIndexKey,
+		writeDB:      writeDB,
+		readDB:       readDB,
+		encryptor:    encryptor,
+		blindIndexer: blindIndexer,
 	}
 }
 
 func (s *Store) newQuery() db.Query {
 	return db.Query{
-		Encryptor:     s.encryptor,
-		BlindIndexKey: s.blindIndexKey,
+		Encryptor:    s.encryptor,
+		BlindIndexer: s.blindIndexer,
 	}
 }
 
@@ -55,3 +55,33 @@ func (s *Store) FindUsers(ctx context.Context, filter auth.UserFilter) ([]auth.U
 		return s.readDB.QueryContext(ctx, query, params...)
 	}, filter)
 }
+
+func (s *Store) CountAttempts(ctx context.Context, filter auth.AttemptFilter) (int, error) {
+	return countAttempts(s.newQuery(), func(query string, params ...any) (*sql.Rows, error) {
+		return s.readDB.QueryContext(ctx, query, params...)
+	}, filter)
+}
+
+func (s *Store) FindTOTPs(ctx context.Context, filter auth.TOTPFilter) ([]auth.TOTP, error) {
+	return selectTOTPs(s.newQuery(), func(query string, params ...any) (*sql.Rows, error) {
+		return s.readDB.QueryContext(ctx, query, params...)
+	}, filter)
+}
+
+func (s *Store) FindPasskeys(ctx context.Context, filter auth.PasskeyFilter) ([]auth.Passkey, error) {
+	return selectPasskeys(s.newQuery(), func(query string, params ...any) (*sql.Rows, error) {
+		return s.readDB.QueryContext(ctx, query, params...)
+	}, filter)
+}
+
+func (s *Store) FindAPITokens(ctx context.Context, filter auth.APITokenFilter) ([]auth.APIToken, error) {
+	return selectAPITokens(s.newQuery(), func(query string, params ...any) (*sql.Rows, error) {
+		return s.readDB.QueryContext(ctx, query, params...)
+	}, filter)
+}
+
+func (s *Store) CountEmailBounces(ctx context.Context, filter auth.EmailBounceFilter) (int, error) {
+	return countEmailBounces(s.newQuery(), func(query string, params ...any) (*sql.Rows, error) {
+		return s.readDB.QueryContext(ctx, query, params...)
+	}, filter)
+}
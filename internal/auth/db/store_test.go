@@ -2,6 +2,7 @@ package db_test
 
 import (
 	"context"
+	"database/sql"
 	"errors"
 	"fmt"
 	"reflect"
@@ -74,6 +75,7 @@ func Test_Tx_UpdateUser(t *testing.T) {
 		// Update all fields that can be modified.
 		user.Email = must(email.ParseAddress("jacob@example.com"))
 		user.PasswordHash = must(krypto.ParseArgon2Hash("$argon2id$v=19$m=47104,t=1,p=1$CkX5zzYLJMWm0y/17eScyw$Qfah+NewdsdeF0+iV72mShZhRO93Qwzdj17TUZCH6ZU"))
+		user.Role = auth.RoleAdmin
 		user.IsActive = true
 		user.CreatedAt = now(t, 1)
 		user.UpdatedAt = now(t, 2)
@@ -121,6 +123,94 @@ func Test_Tx_UpdateUser(t *testing.T) {
 	}))
 }
 
+func Test_Tx_DeleteUser(t *testing.T) {
+	t.Run("ok, delete user and their data", func(t *testing.T) {
+		store, testDB := storeAndDBForTest(t)
+
+		user := newUser(t, nil)
+		other := newUser(t, func(u *auth.User) {
+			u.ID = must(uuid.Parse("597228ee-afde-4991-b13c-0161325e3930"))
+			u.Email = must(email.ParseAddress("jacob@example.com"))
+		})
+
+		tx := must(store.BeginTx(context.Background()))
+		for _, u := range []auth.User{user, other} {
+			err := tx.CreateUser(u)
+			if err != nil {
+				t.Fatalf("failed to save user: %v", err)
+			}
+		}
+
+		for _, err := range []error{
+			tx.CreateEmailToken(newEmailToken(t, nil)),
+			tx.CreateTOTP(newTOTP(t, nil)),
+			tx.CreateRecoveryCode(newRecoveryCode(t, nil)),
+			tx.CreatePasskey(newPasskey(t, nil)),
+			tx.CreateAPIToken(newAPIToken(t, nil)),
+		} {
+			if err != nil {
+				t.Fatalf("failed to save user data: %v", err)
+			}
+		}
+
+		err := tx.Commit()
+		if err != nil {
+			t.Fatalf("failed to commit tx: %v", err)
+		}
+
+		// Both users own a listing and responded to the listing of the other.
+		userListing := must(uuid.Parse("8a0f7c0e-2e0a-4c1e-9d0f-3a1f6a2b9c01"))
+		otherListing := must(uuid.Parse("8a0f7c0e-2e0a-4c1e-9d0f-3a1f6a2b9c02"))
+		insertListing(t, testDB, userListing, user.ID)
+		insertListing(t, testDB, otherListing, other.ID)
+		insertResponse(t, testDB, must(uuid.Parse("5c1d2e3f-4a5b-4c6d-8e7f-9a0b1c2d3e01")), otherListing, user.ID)
+		insertResponse(t, testDB, must(uuid.Parse("5c1d2e3f-4a5b-4c6d-8e7f-9a0b1c2d3e02")), userListing, other.ID)
+
+		tx = must(store.BeginTx(context.Background()))
+		err = tx.DeleteUser(user.ID)
+		if err != nil {
+			t.Fatalf("failed to delete user: %v", err)
+		}
+
+		err = tx.Commit()
+		if err != nil {
+			t.Fatalf("failed to commit tx: %v", err)
+		}
+
+		users := must(store.FindUsers(context.Background(), auth.UserFilter{}))
+		if len(users) != 1 || users[0].ID != other.ID {
+			t.Fatalf("expected only the other user to remain, got %v", users)
+		}
+
+		for table, want := range map[string]int{
+			"email_tokens":     0,
+			"totp_credentials": 0,
+			"recovery_codes":   0,
+			"passkeys":         0,
+			"api_tokens":       0,
+			"listings":         1,
+			"responses":        0,
+		} {
+			var got int
+			err := testDB.QueryRow(`SELECT COUNT(*) FROM ` + table).Scan(&got)
+			if err != nil {
+				t.Fatalf("failed to count %s: %v", table, err)
+			}
+
+			if got != want {
+				t.Errorf("expected %d rows in %s, got %d", want, table, got)
+			}
+		}
+	})
+
+	t.Run("fail, not found", inTx(func(t *testing.T, tx auth.Tx) {
+		err := tx.DeleteUser(must(uuid.Parse("597228ee-afde-4991-b13c-0161325e3930")))
+		if !errors.Is(err, errorz.ErrNotFound) {
+			t.Fatalf("expected errors to be %v got %v (via errors.Is)", errorz.ErrNotFound, err)
+		}
+	}))
+}
+
 func Test_Tx_FindUser(t *testing.T) {
 	setupUsers := func(t *testing.T, tx auth.Tx) []auth.User {
 		users := []auth.User{
@@ -133,6 +223,8 @@ func Test_Tx_FindUser(t *testing.T) {
 			newUser(t, func(u *auth.User) {
 				u.ID = must(uuid.Parse("d622d0b0-465c-4c4d-b084-028c9787e1de"))
 				u.Email = must(email.ParseAddress("eva@example.com"))
+				u.Role = auth.RoleHunter
+				u.CreatedAt = now(t, 2)
 			}),
 		}
 
@@ -154,6 +246,7 @@ func Test_Tx_FindUser(t *testing.T) {
 			filter: auth.UserFilter{
 				IDs:      []uuid.UUID{},
 				Emails:   []email.Address{},
+				Roles:    []auth.Role{},
 				IsActive: nil,
 			},
 			wantFunc: func(users []auth.User) []auth.User {
@@ -168,6 +261,14 @@ func Test_Tx_FindUser(t *testing.T) {
 				return users[1:2]
 			},
 		},
+		"ok, created before": {
+			filter: auth.UserFilter{
+				CreatedBefore: ptr(now(t, 2).In(time.FixedZone("UTC-2", -2*60*60))),
+			},
+			wantFunc: func(users []auth.User) []auth.User {
+				return users[0:2]
+			},
+		},
 		"ok, one by id": {
 			filter: auth.UserFilter{
 				IDs: []uuid.UUID{must(uuid.Parse("597228ee-afde-4991-b13c-0161325e3930"))},
@@ -212,6 +313,22 @@ func Test_Tx_FindUser(t *testing.T) {
 				}
 			},
 		},
+		"ok, one by role": {
+			filter: auth.UserFilter{
+				Roles: []auth.Role{auth.RoleHunter},
+			},
+			wantFunc: func(users []auth.User) []auth.User {
+				return []auth.User{users[2]}
+			},
+		},
+		"ok, several by role": {
+			filter: auth.UserFilter{
+				Roles: []auth.Role{auth.RoleAgent, auth.RoleHunter},
+			},
+			wantFunc: func(users []auth.User) []auth.User {
+				return users
+			},
+		},
 		"ok, combine filters": {
 			filter: auth.UserFilter{
 				IDs: []uuid.UUID{
@@ -221,6 +338,7 @@ func Test_Tx_FindUser(t *testing.T) {
 				Emails: []email.Address{
 					must(email.ParseAddress("alice@example.com")),
 				},
+				Roles:    []auth.Role{auth.RoleAgent},
 				IsActive: ptr(false),
 			},
 			wantFunc: func(users []auth.User) []auth.User {
@@ -397,6 +515,7 @@ func Test_Tx_FinderEmailTokens(t *testing.T) {
 				tok.ID = must(uuid.Parse("b7d2b72f-e20b-4f6d-abae-ec90ff36553a"))
 				tok.UserID = users[1].ID
 				tok.Purpose = auth.TokenPurposePasswordReset
+				tok.CreatedAt = now(t, 2)
 				now := now(t, 9)
 				tok.ConsumedAt = &now
 			}),
@@ -443,6 +562,22 @@ func Test_Tx_FinderEmailTokens(t *testing.T) {
 				return tokens[2:3]
 			},
 		},
+		"ok, created before": {
+			filter: auth.EmailTokenFilter{
+				CreatedBefore: ptr(now(t, 2)),
+			},
+			wantFunc: func(tokens []auth.EmailToken) []auth.EmailToken {
+				return tokens[0:2]
+			},
+		},
+		"ok, created after": {
+			filter: auth.EmailTokenFilter{
+				CreatedAfter: ptr(now(t, 1)),
+			},
+			wantFunc: func(tokens []auth.EmailToken) []auth.EmailToken {
+				return tokens[2:3]
+			},
+		},
 		"ok, one by id": {
 			filter: auth.EmailTokenFilter{
 				IDs: []uuid.UUID{must(uuid.Parse("4516a1c0-efc3-4561-9e97-e749e008aa3f"))},
@@ -545,91 +680,1246 @@ func Test_Tx_FinderEmailTokens(t *testing.T) {
 			if !reflect.DeepEqual(got, want) {
 				t.Errorf("got\n%#v\nwant\n%#v\n", got, want)
 			}
+
+			count, err := tx.CountEmailTokens(tc.filter)
+			if err != nil {
+				t.Fatalf("failed to count email tokens: %v", err)
+			}
+
+			if count != len(want) {
+				t.Errorf("got count %d, want %d", count, len(want))
+			}
 		})
 	}
 }
 
-func inTx(f func(*testing.T, auth.Tx)) func(*testing.T) {
-	return func(t *testing.T) {
-		store := storeForTest(t)
-
-		tx, err := store.BeginTx(context.Background())
+func Test_Tx_DeleteEmailTokens(t *testing.T) {
+	t.Run("ok, delete matching tokens", inTx(func(t *testing.T, tx auth.Tx) {
+		err := tx.CreateUser(newUser(t, nil))
 		if err != nil {
-			t.Fatalf("failed to begin tx: %v", err)
+			t.Fatalf("failed to create user: %v", err)
 		}
 
-		f(t, tx)
+		old := newEmailToken(t, nil)
+		recent := newEmailToken(t, func(tok *auth.EmailToken) {
+			tok.ID = must(uuid.Parse("4516a1c0-efc3-4561-9e97-e749e008aa3f"))
+			tok.CreatedAt = now(t, 5)
+		})
 
-		err = tx.Commit()
+		for _, tok := range []auth.EmailToken{old, recent} {
+			err = tx.CreateEmailToken(tok)
+			if err != nil {
+				t.Fatalf("failed to create email token: %v", err)
+			}
+		}
+
+		err = tx.DeleteEmailTokens(auth.EmailTokenFilter{
+			CreatedBefore: ptr(now(t, 5)),
+		})
 		if err != nil {
-			t.Fatalf("failed to commit tx: %v", err)
+			t.Fatalf("failed to delete email tokens: %v", err)
 		}
-	}
+
+		got, err := tx.FindEmailTokens(auth.EmailTokenFilter{})
+		if err != nil {
+			t.Fatalf("failed to find email tokens: %v", err)
+		}
+
+		want := []auth.EmailToken{recent}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("got\n%#v\nwant\n%#v\n", got, want)
+		}
+	}))
 }
 
-func now(t *testing.T, i int) time.Time {
-	t.Helper()
+func Test_Tx_Attempts(t *testing.T) {
+	setupAttempts := func(t *testing.T, tx auth.Tx) {
+		attempts := []auth.Attempt{
+			newAttempt(t, nil),
+			newAttempt(t, func(a *auth.Attempt) {
+				a.ID = must(uuid.Parse("b6b0a3a4-5b1e-4ea4-9b4e-0f3f3b1f7d2e"))
+				a.CreatedAt = now(t, 2)
+			}),
+			newAttempt(t, func(a *auth.Attempt) {
+				a.ID = must(uuid.Parse("d2a1b7c9-3f5e-4c8a-9e1d-6b7a8c9d0e1f"))
+				a.Purpose = auth.AttemptPurposePasswordReset
+				// Times in other timezones are compared correctly.
+				a.CreatedAt = now(t, 3).In(time.FixedZone("UTC+2", 2*60*60))
+			}),
+			newAttempt(t, func(a *auth.Attempt) {
+				a.ID = must(uuid.Parse("f1e2d3c4-b5a6-4978-8a9b-0c1d2e3f4a5b"))
+				a.Email = must(email.ParseAddress("bob@example.com"))
+			}),
+		}
 
-	if i > 9 {
-		t.Fatalf("invalid time index: %d", i)
+		for _, a := range attempts {
+			err := tx.CreateAttempt(a)
+			if err != nil {
+				t.Fatalf("failed to save attempt: %v", err)
+			}
+		}
 	}
 
-	ts, err := time.Parse(time.RFC3339, fmt.Sprintf("2021-01-01T00:00:0%dZ", i))
-	if err != nil {
-		t.Fatalf("failed to parse time: %v", err)
+	tests := map[string]struct {
+		filter auth.AttemptFilter
+		want   int
+	}{
+		"ok, all attempts, empty slices": {
+			filter: auth.AttemptFilter{
+				Emails:   []email.Address{},
+				Purposes: []auth.AttemptPurpose{},
+			},
+			want: 4,
+		},
+		"ok, by email": {
+			filter: auth.AttemptFilter{
+				Emails: []email.Address{"alice@example.com"},
+			},
+			want: 3,
+		},
+		"ok, by purpose": {
+			filter: auth.AttemptFilter{
+				Purposes: []auth.AttemptPurpose{auth.AttemptPurposePasswordReset},
+			},
+			want: 1,
+		},
+		"ok, created after": {
+			filter: auth.AttemptFilter{
+				CreatedAfter: ptr(now(t, 1).In(time.FixedZone("UTC-2", -2*60*60))),
+			},
+			want: 2,
+		},
+		"ok, created before": {
+			filter: auth.AttemptFilter{
+				CreatedBefore: ptr(now(t, 2).In(time.FixedZone("UTC+2", 2*60*60))),
+			},
+			want: 2,
+		},
+		"ok, combine filters": {
+			filter: auth.AttemptFilter{
+				Emails:       []email.Address{"alice@example.com"},
+				Purposes:     []auth.AttemptPurpose{auth.AttemptPurposeLogin},
+				CreatedAfter: ptr(now(t, 1)),
+			},
+			want: 1,
+		},
+		"ok, no results": {
+			filter: auth.AttemptFilter{
+				Emails: []email.Address{"carol@example.com"},
+			},
+			want: 0,
+		},
 	}
 
-	return ts
-}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			store := storeForTest(t)
 
-func storeForTest(t *testing.T) *db.Store {
-	t.Helper()
+			tx, err := store.BeginTx(context.Background())
+			if err != nil {
+				t.Fatalf("failed to begin tx: %v", err)
+			}
 
-	encryptor := must(krypto.NewEncryptor([]krypto.Key{
-		must(krypto.ParseKey("2b671594b775f371eab4050b4d58326682df6b1a6cc2e886717b1a26b4d6c45d")),
-	}))
-	indexKey := must(krypto.ParseKey("90303dfed7994260ea4817a5ca8a392915cd401115b2f97495dadfcbcd14adbf"))
+			setupAttempts(t, tx)
 
-	testDB := testdb.RunWhile(t, true)
-	return db.New(testDB, testDB, encryptor, indexKey)
-}
+			// first check if CountAttempts works on the tx
+			got, err := tx.CountAttempts(tc.filter)
+			if err != nil {
+				t.Fatalf("failed to count attempts: %v", err)
+			}
 
-func newUser(t *testing.T, modFunc func(*auth.User)) auth.User {
-	t.Helper()
+			if got != tc.want {
+				t.Errorf("got %d attempts, want %d", got, tc.want)
+			}
 
-	u := auth.User{
-		ID:           must(uuid.Parse("0e61a06e-bbf6-4b87-aaaa-75fee0f38cca")),
-		Email:        must(email.ParseAddress("alice@example.com")),
-		PasswordHash: must(krypto.ParseArgon2Hash("$argon2id$v=19$m=47104,t=1,p=1$vP9U4C5jsOzFQLj0gvUkYw$YLrSb2dGfcVohlm8syynqHs6/NHxXS9rt/t6TjL7pi0")),
-		CreatedAt:    now(t, 0),
-		UpdatedAt:    now(t, 0),
-	}
+			err = tx.Commit()
+			if err != nil {
+				t.Fatalf("failed to commit tx: %v", err)
+			}
 
-	if modFunc != nil {
-		modFunc(&u)
+			// then, check if CountAttempts works on the store itself.
+			got, err = store.CountAttempts(context.Background(), tc.filter)
+			if err != nil {
+				t.Fatalf("failed to count attempts: %v", err)
+			}
+
+			if got != tc.want {
+				t.Errorf("got %d attempts, want %d", got, tc.want)
+			}
+
+			// finally, check if DeleteAttempts deletes the same attempts.
+			tx, err = store.BeginTx(context.Background())
+			if err != nil {
+				t.Fatalf("failed to begin tx: %v", err)
+			}
+
+			err = tx.DeleteAttempts(tc.filter)
+			if err != nil {
+				t.Fatalf("failed to delete attempts: %v", err)
+			}
+
+			remaining, err := tx.CountAttempts(auth.AttemptFilter{})
+			if err != nil {
+				t.Fatalf("failed to count attempts: %v", err)
+			}
+
+			if remaining != 4-tc.want {
+				t.Errorf("got %d remaining attempts, want %d", remaining, 4-tc.want)
+			}
+
+			err = tx.Commit()
+			if err != nil {
+				t.Fatalf("failed to commit tx: %v", err)
+			}
+		})
 	}
 
-	return u
+	t.Run("fail, duplicate ID", inTx(func(t *testing.T, tx auth.Tx) {
+		a := newAttempt(t, nil)
+
+		err := tx.CreateAttempt(a)
+		if err != nil {
+			t.Fatalf("failed to save attempt: %v", err)
+		}
+
+		err = tx.CreateAttempt(a)
+		if !errors.Is(err, errorz.ErrConstraintViolated) {
+			t.Fatalf("expected errors to be %v got %v (via errors.Is)", errorz.ErrConstraintViolated, err)
+		}
+	}))
+
+	t.Run("fail, zero ID", inTx(func(t *testing.T, tx auth.Tx) {
+		a := newAttempt(t, func(a *auth.Attempt) {
+			a.ID = uuid.Nil
+		})
+
+		err := tx.CreateAttempt(a)
+		if !errors.Is(err, errorz.ErrConstraintViolated) {
+			t.Fatalf("expected errors to be %v got %v (via errors.Is)", errorz.ErrConstraintViolated, err)
+		}
+	}))
 }
 
-func newEmailToken(t *testing.T, modFunc func(*auth.EmailToken)) auth.EmailToken {
-	t.Helper()
+func Test_Tx_TOTPs(t *testing.T) {
+	setup := func(t *testing.T, tx auth.Tx) auth.User {
+		user := newUser(t, nil)
+		err := tx.CreateUser(user)
+		if err != nil {
+			t.Fatalf("failed to save user: %v", err)
+		}
 
-	tok := auth.EmailToken{
-		ID:         must(uuid.Parse("42bf8943-2ffc-43d9-8682-ca8fc4d7cb8e")),
-		TokenHash:  must(krypto.ParseArgon2Hash("$argon2id$v=19$m=47104,t=1,p=1$CkX5zzYLJMWm0y/17eScyw$Qfah+NewdsdeF0+iV72mShZhRO93Qwzdj17TUZCH6ZU")),
-		UserID:     must(uuid.Parse("0e61a06e-bbf6-4b87-aaaa-75fee0f38cca")),
-		Email:      must(email.ParseAddress("alice@example.com")),
-		Purpose:    auth.TokenPurposeActivate,
-		CreatedAt:  now(t, 1),
-		ConsumedAt: nil,
+		return user
 	}
 
-	if modFunc != nil {
-		modFunc(&tok)
-	}
+	t.Run("ok, create, update and delete", inTx(func(t *testing.T, tx auth.Tx) {
+		setup(t, tx)
 
-	return tok
+		c := newTOTP(t, nil)
+		err := tx.CreateTOTP(c)
+		if err != nil {
+			t.Fatalf("failed to save totp: %v", err)
+		}
+
+		assertFindTOTP(t, tx, c)
+
+		c.LastUsedStep = 42
+		c.ConfirmedAt = ptr(now(t, 2))
+		c.UpdatedAt = now(t, 2)
+
+		err = tx.UpdateTOTP(c)
+		if err != nil {
+			t.Fatalf("failed to update totp: %v", err)
+		}
+
+		assertFindTOTP(t, tx, c)
+
+		err = tx.DeleteTOTPs(auth.TOTPFilter{UserIDs: []uuid.UUID{c.UserID}})
+		if err != nil {
+			t.Fatalf("failed to delete totp: %v", err)
+		}
+
+		got, err := tx.FindTOTPs(auth.TOTPFilter{})
+		if err != nil {
+			t.Fatalf("failed to find totps: %v", err)
+		}
+
+		if len(got) != 0 {
+			t.Fatalf("expected no totps, got %d", len(got))
+		}
+	}))
+
+	t.Run("ok, find by confirmation", func(t *testing.T) {
+		store := storeForTest(t)
+
+		tx, err := store.BeginTx(context.Background())
+		if err != nil {
+			t.Fatalf("failed to begin tx: %v", err)
+		}
+
+		setup(t, tx)
+
+		c := newTOTP(t, nil)
+		err = tx.CreateTOTP(c)
+		if err != nil {
+			t.Fatalf("failed to save totp: %v", err)
+		}
+
+		err = tx.Commit()
+		if err != nil {
+			t.Fatalf("failed to commit tx: %v", err)
+		}
+
+		for _, confirmed := range []bool{true, false} {
+			got, err := store.FindTOTPs(context.Background(), auth.TOTPFilter{IsConfirmed: &confirmed})
+			if err != nil {
+				t.Fatalf("failed to find totps: %v", err)
+			}
+
+			want := []auth.TOTP{}
+			if !confirmed {
+				want = []auth.TOTP{c}
+			}
+
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("got\n%#v\nwant\n%#v\n", got, want)
+			}
+		}
+	})
+
+	t.Run("fail, one credential per user", inTx(func(t *testing.T, tx auth.Tx) {
+		setup(t, tx)
+
+		err := tx.CreateTOTP(newTOTP(t, nil))
+		if err != nil {
+			t.Fatalf("failed to save totp: %v", err)
+		}
+
+		err = tx.CreateTOTP(newTOTP(t, nil))
+		if !errors.Is(err, errorz.ErrConstraintViolated) {
+			t.Fatalf("expected errors to be %v got %v (via errors.Is)", errorz.ErrConstraintViolated, err)
+		}
+	}))
+
+	t.Run("fail, user foreign key does not exist", inTx(func(t *testing.T, tx auth.Tx) {
+		err := tx.CreateTOTP(newTOTP(t, nil))
+		if !errors.Is(err, errorz.ErrConstraintViolated) {
+			t.Fatalf("expected errors to be %v got %v (via errors.Is)", errorz.ErrConstraintViolated, err)
+		}
+	}))
+
+	t.Run("fail, update not found", inTx(func(t *testing.T, tx auth.Tx) {
+		setup(t, tx)
+
+		err := tx.UpdateTOTP(newTOTP(t, nil))
+		if !errors.Is(err, errorz.ErrNotFound) {
+			t.Fatalf("expected errors to be %v got %v (via errors.Is)", errorz.ErrNotFound, err)
+		}
+	}))
+}
+
+func Test_Tx_RecoveryCodes(t *testing.T) {
+	setup := func(t *testing.T, tx auth.Tx) []auth.RecoveryCode {
+		for _, u := range []auth.User{
+			newUser(t, nil),
+			newUser(t, func(u *auth.User) {
+				u.ID = must(uuid.Parse("c0a3cfa1-9f2b-4d8e-8a8a-2f4d5e6f7a8b"))
+				u.Email = must(email.ParseAddress("bob@example.com"))
+			}),
+		} {
+			err := tx.CreateUser(u)
+			if err != nil {
+				t.Fatalf("failed to save user: %v", err)
+			}
+		}
+
+		codes := []auth.RecoveryCode{
+			newRecoveryCode(t, nil),
+			newRecoveryCode(t, func(c *auth.RecoveryCode) {
+				c.ID = must(uuid.Parse("3d1f5b7e-2c4a-4e6b-9d8f-1a2b3c4d5e6f"))
+				c.CreatedAt = now(t, 2)
+				c.UsedAt = ptr(now(t, 3))
+			}),
+			newRecoveryCode(t, func(c *auth.RecoveryCode) {
+				c.ID = must(uuid.Parse("8e7d6c5b-4a39-4281-9f0e-d1c2b3a49586"))
+				c.UserID = must(uuid.Parse("c0a3cfa1-9f2b-4d8e-8a8a-2f4d5e6f7a8b"))
+				c.CreatedAt = now(t, 3)
+			}),
+		}
+
+		for _, c := range codes {
+			err := tx.CreateRecoveryCode(c)
+			if err != nil {
+				t.Fatalf("failed to save recovery code: %v", err)
+			}
+		}
+
+		return codes
+	}
+
+	tests := map[string]struct {
+		filter  auth.RecoveryCodeFilter
+		wantIdx []int
+	}{
+		"ok, all codes": {
+			filter:  auth.RecoveryCodeFilter{},
+			wantIdx: []int{0, 1, 2},
+		},
+		"ok, by id": {
+			filter: auth.RecoveryCodeFilter{
+				IDs: []uuid.UUID{must(uuid.Parse("3d1f5b7e-2c4a-4e6b-9d8f-1a2b3c4d5e6f"))},
+			},
+			wantIdx: []int{1},
+		},
+		"ok, by user id": {
+			filter: auth.RecoveryCodeFilter{
+				UserIDs: []uuid.UUID{must(uuid.Parse("0e61a06e-bbf6-4b87-aaaa-75fee0f38cca"))},
+			},
+			wantIdx: []int{0, 1},
+		},
+		"ok, unused": {
+			filter: auth.RecoveryCodeFilter{
+				IsUsed: ptr(false),
+			},
+			wantIdx: []int{0, 2},
+		},
+		"ok, used": {
+			filter: auth.RecoveryCodeFilter{
+				IsUsed: ptr(true),
+			},
+			wantIdx: []int{1},
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, inTx(func(t *testing.T, tx auth.Tx) {
+			codes := setup(t, tx)
+
+			got, err := tx.FindRecoveryCodes(tc.filter)
+			if err != nil {
+				t.Fatalf("failed to find recovery codes: %v", err)
+			}
+
+			want := make([]auth.RecoveryCode, 0, len(tc.wantIdx))
+			for _, i := range tc.wantIdx {
+				want = append(want, codes[i])
+			}
+
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("got\n%#v\nwant\n%#v\n", got, want)
+			}
+
+			// DeleteRecoveryCodes deletes the same codes.
+			err = tx.DeleteRecoveryCodes(tc.filter)
+			if err != nil {
+				t.Fatalf("failed to delete recovery codes: %v", err)
+			}
+
+			remaining, err := tx.FindRecoveryCodes(auth.RecoveryCodeFilter{})
+			if err != nil {
+				t.Fatalf("failed to find recovery codes: %v", err)
+			}
+
+			if len(remaining) != len(codes)-len(want) {
+				t.Errorf("got %d remaining recovery codes, want %d", len(remaining), len(codes)-len(want))
+			}
+		}))
+	}
+
+	t.Run("ok, update", inTx(func(t *testing.T, tx auth.Tx) {
+		codes := setup(t, tx)
+
+		c := codes[0]
+		c.UsedAt = ptr(now(t, 9))
+
+		err := tx.UpdateRecoveryCode(c)
+		if err != nil {
+			t.Fatalf("failed to update recovery code: %v", err)
+		}
+
+		got, err := tx.FindRecoveryCodes(auth.RecoveryCodeFilter{IDs: []uuid.UUID{c.ID}})
+		if err != nil {
+			t.Fatalf("failed to find recovery codes: %v", err)
+		}
+
+		if !reflect.DeepEqual(got, []auth.RecoveryCode{c}) {
+			t.Errorf("got\n%#v\nwant\n%#v\n", got, []auth.RecoveryCode{c})
+		}
+	}))
+
+	t.Run("fail, update not found", inTx(func(t *testing.T, tx auth.Tx) {
+		setup(t, tx)
+
+		c := newRecoveryCode(t, func(c *auth.RecoveryCode) {
+			c.ID = must(uuid.Parse("597228ee-afde-4991-b13c-0161325e3930"))
+		})
+
+		err := tx.UpdateRecoveryCode(c)
+		if !errors.Is(err, errorz.ErrNotFound) {
+			t.Fatalf("expected errors to be %v got %v (via errors.Is)", errorz.ErrNotFound, err)
+		}
+	}))
+
+	t.Run("fail, zero ID", inTx(func(t *testing.T, tx auth.Tx) {
+		setup(t, tx)
+
+		c := newRecoveryCode(t, func(c *auth.RecoveryCode) {
+			c.ID = uuid.Nil
+		})
+
+		err := tx.CreateRecoveryCode(c)
+		if !errors.Is(err, errorz.ErrConstraintViolated) {
+			t.Fatalf("expected errors to be %v got %v (via errors.Is)", errorz.ErrConstraintViolated, err)
+		}
+	}))
+}
+
+func Test_Tx_Passkeys(t *testing.T) {
+	setup := func(t *testing.T, tx auth.Tx) []auth.Passkey {
+		for _, u := range []auth.User{
+			newUser(t, nil),
+			newUser(t, func(u *auth.User) {
+				u.ID = must(uuid.Parse("c0a3cfa1-9f2b-4d8e-8a8a-2f4d5e6f7a8b"))
+				u.Email = must(email.ParseAddress("bob@example.com"))
+			}),
+		} {
+			err := tx.CreateUser(u)
+			if err != nil {
+				t.Fatalf("failed to save user: %v", err)
+			}
+		}
+
+		passkeys := []auth.Passkey{
+			newPasskey(t, nil),
+			newPasskey(t, func(p *auth.Passkey) {
+				p.ID = must(uuid.Parse("3d1f5b7e-2c4a-4e6b-9d8f-1a2b3c4d5e6f"))
+				p.CredentialID = []byte{0x02}
+				p.SignCount = 7
+				p.CreatedAt = now(t, 2)
+				p.LastUsedAt = ptr(now(t, 3))
+			}),
+			newPasskey(t, func(p *auth.Passkey) {
+				p.ID = must(uuid.Parse("8e7d6c5b-4a39-4281-9f0e-d1c2b3a49586"))
+				p.UserID = must(uuid.Parse("c0a3cfa1-9f2b-4d8e-8a8a-2f4d5e6f7a8b"))
+				p.CredentialID = []byte{0x03}
+				p.CreatedAt = now(t, 3)
+			}),
+		}
+
+		for _, p := range passkeys {
+			err := tx.CreatePasskey(p)
+			if err != nil {
+				t.Fatalf("failed to save passkey: %v", err)
+			}
+		}
+
+		return passkeys
+	}
+
+	tests := map[string]struct {
+		filter  auth.PasskeyFilter
+		wantIdx []int
+	}{
+		"ok, all passkeys": {
+			filter:  auth.PasskeyFilter{},
+			wantIdx: []int{0, 1, 2},
+		},
+		"ok, by id": {
+			filter: auth.PasskeyFilter{
+				IDs: []uuid.UUID{must(uuid.Parse("3d1f5b7e-2c4a-4e6b-9d8f-1a2b3c4d5e6f"))},
+			},
+			wantIdx: []int{1},
+		},
+		"ok, by user id": {
+			filter: auth.PasskeyFilter{
+				UserIDs: []uuid.UUID{must(uuid.Parse("0e61a06e-bbf6-4b87-aaaa-75fee0f38cca"))},
+			},
+			wantIdx: []int{0, 1},
+		},
+		"ok, by credential id": {
+			filter: auth.PasskeyFilter{
+				CredentialIDs: [][]byte{{0x01}, {0x03}},
+			},
+			wantIdx: []int{0, 2},
+		},
+		"ok, no match": {
+			filter: auth.PasskeyFilter{
+				CredentialIDs: [][]byte{{0x04}},
+			},
+			wantIdx: []int{},
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, inTx(func(t *testing.T, tx auth.Tx) {
+			passkeys := setup(t, tx)
+
+			got, err := tx.FindPasskeys(tc.filter)
+			if err != nil {
+				t.Fatalf("failed to find passkeys: %v", err)
+			}
+
+			want := make([]auth.Passkey, 0, len(tc.wantIdx))
+			for _, i := range tc.wantIdx {
+				want = append(want, passkeys[i])
+			}
+
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("got\n%#v\nwant\n%#v\n", got, want)
+			}
+
+			// DeletePasskeys deletes the same passkeys.
+			err = tx.DeletePasskeys(tc.filter)
+			if err != nil {
+				t.Fatalf("failed to delete passkeys: %v", err)
+			}
+
+			remaining, err := tx.FindPasskeys(auth.PasskeyFilter{})
+			if err != nil {
+				t.Fatalf("failed to find passkeys: %v", err)
+			}
+
+			if len(remaining) != len(passkeys)-len(want) {
+				t.Errorf("got %d remaining passkeys, want %d", len(remaining), len(passkeys)-len(want))
+			}
+		}))
+	}
+
+	t.Run("ok, update", inTx(func(t *testing.T, tx auth.Tx) {
+		passkeys := setup(t, tx)
+
+		p := passkeys[0]
+		p.SignCount = 12
+		p.LastUsedAt = ptr(now(t, 9))
+
+		err := tx.UpdatePasskey(p)
+		if err != nil {
+			t.Fatalf("failed to update passkey: %v", err)
+		}
+
+		got, err := tx.FindPasskeys(auth.PasskeyFilter{IDs: []uuid.UUID{p.ID}})
+		if err != nil {
+			t.Fatalf("failed to find passkeys: %v", err)
+		}
+
+		if !reflect.DeepEqual(got, []auth.Passkey{p}) {
+			t.Errorf("got\n%#v\nwant\n%#v\n", got, []auth.Passkey{p})
+		}
+	}))
+
+	t.Run("fail, update not found", inTx(func(t *testing.T, tx auth.Tx) {
+		setup(t, tx)
+
+		p := newPasskey(t, func(p *auth.Passkey) {
+			p.ID = must(uuid.Parse("597228ee-afde-4991-b13c-0161325e3930"))
+		})
+
+		err := tx.UpdatePasskey(p)
+		if !errors.Is(err, errorz.ErrNotFound) {
+			t.Fatalf("expected errors to be %v got %v (via errors.Is)", errorz.ErrNotFound, err)
+		}
+	}))
+
+	t.Run("fail, duplicate credential id", inTx(func(t *testing.T, tx auth.Tx) {
+		setup(t, tx)
+
+		p := newPasskey(t, func(p *auth.Passkey) {
+			p.ID = must(uuid.Parse("597228ee-afde-4991-b13c-0161325e3930"))
+		})
+
+		err := tx.CreatePasskey(p)
+		if !errors.Is(err, errorz.ErrConstraintViolated) {
+			t.Fatalf("expected errors to be %v got %v (via errors.Is)", errorz.ErrConstraintViolated, err)
+		}
+	}))
+
+	t.Run("fail, zero ID", inTx(func(t *testing.T, tx auth.Tx) {
+		setup(t, tx)
+
+		p := newPasskey(t, func(p *auth.Passkey) {
+			p.ID = uuid.Nil
+		})
+
+		err := tx.CreatePasskey(p)
+		if !errors.Is(err, errorz.ErrConstraintViolated) {
+			t.Fatalf("expected errors to be %v got %v (via errors.Is)", errorz.ErrConstraintViolated, err)
+		}
+	}))
+}
+
+func Test_Tx_APITokens(t *testing.T) {
+	setup := func(t *testing.T, tx auth.Tx) []auth.APIToken {
+		for _, u := range []auth.User{
+			newUser(t, nil),
+			newUser(t, func(u *auth.User) {
+				u.ID = must(uuid.Parse("c0a3cfa1-9f2b-4d8e-8a8a-2f4d5e6f7a8b"))
+				u.Email = must(email.ParseAddress("bob@example.com"))
+			}),
+		} {
+			err := tx.CreateUser(u)
+			if err != nil {
+				t.Fatalf("failed to save user: %v", err)
+			}
+		}
+
+		tokens := []auth.APIToken{
+			newAPIToken(t, nil),
+			newAPIToken(t, func(a *auth.APIToken) {
+				a.ID = must(uuid.Parse("3d1f5b7e-2c4a-4e6b-9d8f-1a2b3c4d5e6f"))
+				a.Scopes = []auth.Scope{auth.ScopeResponsesRead}
+				a.CreatedAt = now(t, 2)
+				a.LastUsedAt = ptr(now(t, 3))
+			}),
+			newAPIToken(t, func(a *auth.APIToken) {
+				a.ID = must(uuid.Parse("8e7d6c5b-4a39-4281-9f0e-d1c2b3a49586"))
+				a.UserID = must(uuid.Parse("c0a3cfa1-9f2b-4d8e-8a8a-2f4d5e6f7a8b"))
+				a.CreatedAt = now(t, 3)
+			}),
+		}
+
+		for _, a := range tokens {
+			err := tx.CreateAPIToken(a)
+			if err != nil {
+				t.Fatalf("failed to save API token: %v", err)
+			}
+		}
+
+		return tokens
+	}
+
+	tests := map[string]struct {
+		filter  auth.APITokenFilter
+		wantIdx []int
+	}{
+		"ok, all API tokens": {
+			filter:  auth.APITokenFilter{},
+			wantIdx: []int{0, 1, 2},
+		},
+		"ok, by id": {
+			filter: auth.APITokenFilter{
+				IDs: []uuid.UUID{must(uuid.Parse("3d1f5b7e-2c4a-4e6b-9d8f-1a2b3c4d5e6f"))},
+			},
+			wantIdx: []int{1},
+		},
+		"ok, by user id": {
+			filter: auth.APITokenFilter{
+				UserIDs: []uuid.UUID{must(uuid.Parse("0e61a06e-bbf6-4b87-aaaa-75fee0f38cca"))},
+			},
+			wantIdx: []int{0, 1},
+		},
+		"ok, no match": {
+			filter: auth.APITokenFilter{
+				IDs: []uuid.UUID{must(uuid.Parse("597228ee-afde-4991-b13c-0161325e3930"))},
+			},
+			wantIdx: []int{},
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, inTx(func(t *testing.T, tx auth.Tx) {
+			tokens := setup(t, tx)
+
+			got, err := tx.FindAPITokens(tc.filter)
+			if err != nil {
+				t.Fatalf("failed to find API tokens: %v", err)
+			}
+
+			want := make([]auth.APIToken, 0, len(tc.wantIdx))
+			for _, i := range tc.wantIdx {
+				want = append(want, tokens[i])
+			}
+
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("got\n%#v\nwant\n%#v\n", got, want)
+			}
+
+			// DeleteAPITokens deletes the same API tokens.
+			err = tx.DeleteAPITokens(tc.filter)
+			if err != nil {
+				t.Fatalf("failed to delete API tokens: %v", err)
+			}
+
+			remaining, err := tx.FindAPITokens(auth.APITokenFilter{})
+			if err != nil {
+				t.Fatalf("failed to find API tokens: %v", err)
+			}
+
+			if len(remaining) != len(tokens)-len(want) {
+				t.Errorf("got %d remaining API tokens, want %d", len(remaining), len(tokens)-len(want))
+			}
+		}))
+	}
+
+	t.Run("ok, update", inTx(func(t *testing.T, tx auth.Tx) {
+		tokens := setup(t, tx)
+
+		a := tokens[0]
+		a.Name = "Renamed"
+		a.Scopes = []auth.Scope{auth.ScopeResponsesRead, auth.ScopeResponsesWrite}
+		a.LastUsedAt = ptr(now(t, 9))
+
+		err := tx.UpdateAPIToken(a)
+		if err != nil {
+			t.Fatalf("failed to update API token: %v", err)
+		}
+
+		got, err := tx.FindAPITokens(auth.APITokenFilter{IDs: []uuid.UUID{a.ID}})
+		if err != nil {
+			t.Fatalf("failed to find API tokens: %v", err)
+		}
+
+		if !reflect.DeepEqual(got, []auth.APIToken{a}) {
+			t.Errorf("got\n%#v\nwant\n%#v\n", got, []auth.APIToken{a})
+		}
+	}))
+
+	t.Run("fail, update not found", inTx(func(t *testing.T, tx auth.Tx) {
+		setup(t, tx)
+
+		a := newAPIToken(t, func(a *auth.APIToken) {
+			a.ID = must(uuid.Parse("597228ee-afde-4991-b13c-0161325e3930"))
+		})
+
+		err := tx.UpdateAPIToken(a)
+		if !errors.Is(err, errorz.ErrNotFound) {
+			t.Fatalf("expected errors to be %v got %v (via errors.Is)", errorz.ErrNotFound, err)
+		}
+	}))
+
+	t.Run("fail, zero ID", inTx(func(t *testing.T, tx auth.Tx) {
+		setup(t, tx)
+
+		a := newAPIToken(t, func(a *auth.APIToken) {
+			a.ID = uuid.Nil
+		})
+
+		err := tx.CreateAPIToken(a)
+		if !errors.Is(err, errorz.ErrConstraintViolated) {
+			t.Fatalf("expected errors to be %v got %v (via errors.Is)", errorz.ErrConstraintViolated, err)
+		}
+	}))
+}
+
+func Test_Tx_EmailBounces(t *testing.T) {
+	setup := func(t *testing.T, tx auth.Tx) {
+		for _, u := range []auth.User{
+			newUser(t, nil),
+			newUser(t, func(u *auth.User) {
+				u.ID = must(uuid.Parse("c0a3cfa1-9f2b-4d8e-8a8a-2f4d5e6f7a8b"))
+				u.Email = must(email.ParseAddress("bob@example.com"))
+			}),
+		} {
+			err := tx.CreateUser(u)
+			if err != nil {
+				t.Fatalf("failed to save user: %v", err)
+			}
+		}
+
+		bounces := []auth.EmailBounce{
+			newEmailBounce(t, nil),
+			newEmailBounce(t, func(b *auth.EmailBounce) {
+				b.ID = must(uuid.Parse("3d1f5b7e-2c4a-4e6b-9d8f-1a2b3c4d5e6f"))
+				b.Kind = auth.BounceKindSpamComplaint
+			}),
+			newEmailBounce(t, func(b *auth.EmailBounce) {
+				// An earlier address of the user.
+				b.ID = must(uuid.Parse("8e7d6c5b-4a39-4281-9f0e-d1c2b3a49586"))
+				b.Email = must(email.ParseAddress("old@example.com"))
+			}),
+			newEmailBounce(t, func(b *auth.EmailBounce) {
+				b.ID = must(uuid.Parse("f1e2d3c4-b5a6-4978-8a9b-0c1d2e3f4a5b"))
+				b.UserID = must(uuid.Parse("c0a3cfa1-9f2b-4d8e-8a8a-2f4d5e6f7a8b"))
+				b.Email = must(email.ParseAddress("bob@example.com"))
+			}),
+		}
+
+		for _, b := range bounces {
+			err := tx.CreateEmailBounce(b)
+			if err != nil {
+				t.Fatalf("failed to save email bounce: %v", err)
+			}
+		}
+	}
+
+	tests := map[string]struct {
+		filter auth.EmailBounceFilter
+		want   int
+	}{
+		"ok, all bounces, empty slices": {
+			filter: auth.EmailBounceFilter{
+				UserIDs: []uuid.UUID{},
+				Emails:  []email.Address{},
+			},
+			want: 4,
+		},
+		"ok, by user id": {
+			filter: auth.EmailBounceFilter{
+				UserIDs: []uuid.UUID{must(uuid.Parse("0e61a06e-bbf6-4b87-aaaa-75fee0f38cca"))},
+			},
+			want: 3,
+		},
+		"ok, by email": {
+			filter: auth.EmailBounceFilter{
+				Emails: []email.Address{"alice@example.com", "bob@example.com"},
+			},
+			want: 3,
+		},
+		"ok, combine filters": {
+			filter: auth.EmailBounceFilter{
+				UserIDs: []uuid.UUID{must(uuid.Parse("0e61a06e-bbf6-4b87-aaaa-75fee0f38cca"))},
+				Emails:  []email.Address{"old@example.com"},
+			},
+			want: 1,
+		},
+		"ok, no results": {
+			filter: auth.EmailBounceFilter{
+				Emails: []email.Address{"carol@example.com"},
+			},
+			want: 0,
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			store := storeForTest(t)
+
+			tx, err := store.BeginTx(context.Background())
+			if err != nil {
+				t.Fatalf("failed to begin tx: %v", err)
+			}
+
+			setup(t, tx)
+
+			err = tx.Commit()
+			if err != nil {
+				t.Fatalf("failed to commit tx: %v", err)
+			}
+
+			got, err := store.CountEmailBounces(context.Background(), tc.filter)
+			if err != nil {
+				t.Fatalf("failed to count email bounces: %v", err)
+			}
+
+			if got != tc.want {
+				t.Errorf("got %d email bounces, want %d", got, tc.want)
+			}
+		})
+	}
+
+	t.Run("ok, find by user id", inTx(func(t *testing.T, tx auth.Tx) {
+		setup(t, tx)
+
+		got, err := tx.FindEmailBounces(auth.EmailBounceFilter{
+			UserIDs: []uuid.UUID{must(uuid.Parse("0e61a06e-bbf6-4b87-aaaa-75fee0f38cca"))},
+		})
+		if err != nil {
+			t.Fatalf("failed to find email bounces: %v", err)
+		}
+
+		want := []auth.EmailBounce{
+			newEmailBounce(t, func(b *auth.EmailBounce) {
+				b.ID = must(uuid.Parse("3d1f5b7e-2c4a-4e6b-9d8f-1a2b3c4d5e6f"))
+				b.Kind = auth.BounceKindSpamComplaint
+			}),
+			newEmailBounce(t, nil),
+			newEmailBounce(t, func(b *auth.EmailBounce) {
+				b.ID = must(uuid.Parse("8e7d6c5b-4a39-4281-9f0e-d1c2b3a49586"))
+				b.Email = must(email.ParseAddress("old@example.com"))
+			}),
+		}
+
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("got\n%#v\nwant\n%#v\n", got, want)
+		}
+	}))
+
+	t.Run("fail, zero uuid", inTx(func(t *testing.T, tx auth.Tx) {
+		setup(t, tx)
+
+		err := tx.CreateEmailBounce(newEmailBounce(t, func(b *auth.EmailBounce) {
+			b.ID = uuid.Nil
+		}))
+		if !errors.Is(err, errorz.ErrConstraintViolated) {
+			t.Fatalf("expected error %v, got %v (via errors.Is)", errorz.ErrConstraintViolated, err)
+		}
+	}))
+
+	t.Run("fail, unknown user", inTx(func(t *testing.T, tx auth.Tx) {
+		err := tx.CreateEmailBounce(newEmailBounce(t, nil))
+		if !errors.Is(err, errorz.ErrConstraintViolated) {
+			t.Fatalf("expected error %v, got %v (via errors.Is)", errorz.ErrConstraintViolated, err)
+		}
+	}))
+
+	t.Run("ok, bounces are deleted with the user", func(t *testing.T) {
+		store := storeForTest(t)
+
+		tx, err := store.BeginTx(context.Background())
+		if err != nil {
+			t.Fatalf("failed to begin tx: %v", err)
+		}
+
+		setup(t, tx)
+
+		err = tx.DeleteUser(must(uuid.Parse("0e61a06e-bbf6-4b87-aaaa-75fee0f38cca")))
+		if err != nil {
+			t.Fatalf("failed to delete user: %v", err)
+		}
+
+		err = tx.Commit()
+		if err != nil {
+			t.Fatalf("failed to commit tx: %v", err)
+		}
+
+		got, err := store.CountEmailBounces(context.Background(), auth.EmailBounceFilter{})
+		if err != nil {
+			t.Fatalf("failed to count email bounces: %v", err)
+		}
+
+		if got != 1 {
+			t.Errorf("got %d email bounces, want %d", got, 1)
+		}
+	})
+}
+
+func inTx(f func(*testing.T, auth.Tx)) func(*testing.T) {
+	return func(t *testing.T) {
+		store := storeForTest(t)
+
+		tx, err := store.BeginTx(context.Background())
+		if err != nil {
+			t.Fatalf("failed to begin tx: %v", err)
+		}
+
+		f(t, tx)
+
+		err = tx.Commit()
+		if err != nil {
+			t.Fatalf("failed to commit tx: %v", err)
+		}
+	}
+}
+
+func now(t *testing.T, i int) time.Time {
+	t.Helper()
+
+	if i > 9 {
+		t.Fatalf("invalid time index: %d", i)
+	}
+
+	ts, err := time.Parse(time.RFC3339, fmt.Sprintf("2021-01-01T00:00:0%dZ", i))
+	if err != nil {
+		t.Fatalf("failed to parse time: %v", err)
+	}
+
+	return ts
+}
+
+func storeForTest(t *testing.T) *db.Store {
+	t.Helper()
+
+	store, _ := storeAndDBForTest(t)
+	return store
+}
+
+// storeAndDBForTest also returns the database, for tables that are not managed by the store.
+func storeAndDBForTest(t *testing.T) (*db.Store, *sql.DB) {
+	t.Helper()
+
+	encryptor := must(krypto.NewEncryptor([]krypto.Key{
+		must(krypto.ParseKey("2b671594b775f371eab4050b4d58326682df6b1a6cc2e886717b1a26b4d6c45d")),
+	}))
+	blindIndexer := must(krypto.NewBlindIndexer([]krypto.Key{
+		must(krypto.ParseKey("90303dfed7994260ea4817a5ca8a392915cd401115b2f97495dadfcbcd14adbf")),
+	}))
+
+	testDB := testdb.RunWhile(t, true)
+	return db.New(testDB, testDB, encryptor, blindIndexer), testDB
+}
+
+func newUser(t *testing.T, modFunc func(*auth.User)) auth.User {
+	t.Helper()
+
+	u := auth.User{
+		ID:           must(uuid.Parse("0e61a06e-bbf6-4b87-aaaa-75fee0f38cca")),
+		Email:        must(email.ParseAddress("alice@example.com")),
+		PasswordHash: must(krypto.ParseArgon2Hash("$argon2id$v=19$m=47104,t=1,p=1$vP9U4C5jsOzFQLj0gvUkYw$YLrSb2dGfcVohlm8syynqHs6/NHxXS9rt/t6TjL7pi0")),
+		Role:         auth.RoleAgent,
+		CreatedAt:    now(t, 0),
+		UpdatedAt:    now(t, 0),
+	}
+
+	if modFunc != nil {
+		modFunc(&u)
+	}
+
+	return u
+}
+
+func newEmailToken(t *testing.T, modFunc func(*auth.EmailToken)) auth.EmailToken {
+	t.Helper()
+
+	tok := auth.EmailToken{
+		ID:         must(uuid.Parse("42bf8943-2ffc-43d9-8682-ca8fc4d7cb8e")),
+		TokenHash:  must(krypto.ParseArgon2Hash("$argon2id$v=19$m=47104,t=1,p=1$CkX5zzYLJMWm0y/17eScyw$Qfah+NewdsdeF0+iV72mShZhRO93Qwzdj17TUZCH6ZU")),
+		UserID:     must(uuid.Parse("0e61a06e-bbf6-4b87-aaaa-75fee0f38cca")),
+		Email:      must(email.ParseAddress("alice@example.com")),
+		Purpose:    auth.TokenPurposeActivate,
+		CreatedAt:  now(t, 1),
+		ConsumedAt: nil,
+	}
+
+	if modFunc != nil {
+		modFunc(&tok)
+	}
+
+	return tok
+}
+
+func newAttempt(t *testing.T, modFunc func(*auth.Attempt)) auth.Attempt {
+	t.Helper()
+
+	a := auth.Attempt{
+		ID:        must(uuid.Parse("9c8b7a6d-5e4f-4a3b-8c2d-1e0f9a8b7c6d")),
+		Email:     must(email.ParseAddress("alice@example.com")),
+		Purpose:   auth.AttemptPurposeLogin,
+		CreatedAt: now(t, 1),
+	}
+
+	if modFunc != nil {
+		modFunc(&a)
+	}
+
+	return a
+}
+
+func newTOTP(t *testing.T, modFunc func(*auth.TOTP)) auth.TOTP {
+	t.Helper()
+
+	c := auth.TOTP{
+		UserID:    must(uuid.Parse("0e61a06e-bbf6-4b87-aaaa-75fee0f38cca")),
+		Secret:    krypto.TOTPSecret([]byte("12345678901234567890")),
+		CreatedAt: now(t, 1),
+		UpdatedAt: now(t, 1),
+	}
+
+	if modFunc != nil {
+		modFunc(&c)
+	}
+
+	return c
+}
+
+func newRecoveryCode(t *testing.T, modFunc func(*auth.RecoveryCode)) auth.RecoveryCode {
+	t.Helper()
+
+	c := auth.RecoveryCode{
+		ID:        must(uuid.Parse("6a5b4c3d-2e1f-4a0b-8c7d-6e5f4a3b2c1d")),
+		UserID:    must(uuid.Parse("0e61a06e-bbf6-4b87-aaaa-75fee0f38cca")),
+		CodeHash:  must(krypto.ParseArgon2Hash("$argon2id$v=19$m=47104,t=1,p=1$CkX5zzYLJMWm0y/17eScyw$Qfah+NewdsdeF0+iV72mShZhRO93Qwzdj17TUZCH6ZU")),
+		CreatedAt: now(t, 1),
+	}
+
+	if modFunc != nil {
+		modFunc(&c)
+	}
+
+	return c
+}
+
+func newPasskey(t *testing.T, modFunc func(*auth.Passkey)) auth.Passkey {
+	t.Helper()
+
+	p := auth.Passkey{
+		ID:           must(uuid.Parse("6a5b4c3d-2e1f-4a0b-8c7d-6e5f4a3b2c1d")),
+		UserID:       must(uuid.Parse("0e61a06e-bbf6-4b87-aaaa-75fee0f38cca")),
+		CredentialID: []byte{0x01},
+		PublicKey:    []byte{0xa5, 0x01, 0x02},
+		CreatedAt:    now(t, 1),
+	}
+
+	if modFunc != nil {
+		modFunc(&p)
+	}
+
+	return p
+}
+
+func newAPIToken(t *testing.T, modFunc func(*auth.APIToken)) auth.APIToken {
+	t.Helper()
+
+	a := auth.APIToken{
+		ID:        must(uuid.Parse("6a5b4c3d-2e1f-4a0b-8c7d-6e5f4a3b2c1d")),
+		UserID:    must(uuid.Parse("0e61a06e-bbf6-4b87-aaaa-75fee0f38cca")),
+		Name:      "CRM import",
+		Scopes:    []auth.Scope{auth.ScopeListingsRead, auth.ScopeListingsWrite},
+		TokenHash: must(krypto.ParseArgon2Hash("$argon2id$v=19$m=47104,t=1,p=1$CkX5zzYLJMWm0y/17eScyw$Qfah+NewdsdeF0+iV72mShZhRO93Qwzdj17TUZCH6ZU")),
+		ExpiresAt: now(t, 9),
+		CreatedAt: now(t, 1),
+	}
+
+	if modFunc != nil {
+		modFunc(&a)
+	}
+
+	return a
+}
+
+func newEmailBounce(t *testing.T, modFunc func(*auth.EmailBounce)) auth.EmailBounce {
+	t.Helper()
+
+	b := auth.EmailBounce{
+		ID:        must(uuid.Parse("6a5b4c3d-2e1f-4a0b-8c7d-6e5f4a3b2c1d")),
+		UserID:    must(uuid.Parse("0e61a06e-bbf6-4b87-aaaa-75fee0f38cca")),
+		Email:     must(email.ParseAddress("alice@example.com")),
+		Kind:      auth.BounceKindHard,
+		BouncedAt: now(t, 1),
+		CreatedAt: now(t, 2),
+	}
+
+	if modFunc != nil {
+		modFunc(&b)
+	}
+
+	return b
+}
+
+func insertListing(t *testing.T, testDB *sql.DB, id, agentID uuid.UUID) {
+	t.Helper()
+
+	_, err := testDB.Exec(`INSERT INTO listings (id, agent_id, title, address, description, price, published_at, created_at, updated_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		id, agentID, "title", "address", "description", 1, now(t, 0), now(t, 0), now(t, 0),
+	)
+	if err != nil {
+		t.Fatalf("failed to insert listing: %v", err)
+	}
+}
+
+func insertResponse(t *testing.T, testDB *sql.DB, id, listingID, hunterID uuid.UUID) {
+	t.Helper()
+
+	_, err := testDB.Exec(`INSERT INTO responses (id, listing_id, hunter_id, message, status, created_at, updated_at) VALUES (?, ?, ?, ?, ?, ?, ?)`,
+		id, listingID, hunterID, "message", "new", now(t, 0), now(t, 0),
+	)
+	if err != nil {
+		t.Fatalf("failed to insert response: %v", err)
+	}
+}
+
+func assertFindTOTP(t *testing.T, tx auth.Tx, want auth.TOTP) {
+	t.Helper()
+
+	got, err := tx.FindTOTPs(auth.TOTPFilter{UserIDs: []uuid.UUID{want.UserID}})
+	if err != nil {
+		t.Fatalf("failed to find totp: %v", err)
+	}
+
+	if len(got) != 1 {
+		t.Fatalf("expected 1 totp, got %d", len(got))
+	}
+
+	if !reflect.DeepEqual(got[0], want) {
+		t.Errorf("got\n%#v\nwant\n%#v\n", got[0], want)
+	}
 }
 
 func assertFindUser(t *testing.T, tx auth.Tx, want auth.User) {
@@ -2,8 +2,14 @@ package db
 
 import (
 	"database/sql"
+	"time"
 
+	"github.com/google/uuid"
 	"github.com/willemschots/househunt/internal/auth"
+	"github.com/willemschots/househunt/internal/email"
+	"github.com/willemschots/househunt/internal/email/outbox"
+	outboxdb "github.com/willemschots/househunt/internal/email/outbox/db"
+	sessionsdb "github.com/willemschots/househunt/internal/web/sessions/db"
 )
 
 type Tx struct {
@@ -38,6 +44,20 @@ func (t *Tx) FindUsers(filter auth.UserFilter) ([]auth.User, error) {
 	return selectUsers(t.store.newQuery(), t.tx.Query, filter)
 }
 
+// DeleteUser deletes a user and all data that belongs to it: email tokens are deleted
+// here, the other tables cascade the deletion.
+// It returns errorz.ErrNotFound if no user is found.
+func (t *Tx) DeleteUser(id uuid.UUID) error {
+	err := deleteEmailTokens(t.store.newQuery(), t.tx.Exec, auth.EmailTokenFilter{
+		UserIDs: []uuid.UUID{id},
+	})
+	if err != nil {
+		return err
+	}
+
+	return deleteUser(t.store.newQuery(), t.tx.Exec, id)
+}
+
 // CreateEmailToken creates an email token in the database.
 // It updates the token ID and CreatedAt when successful.
 func (t *Tx) CreateEmailToken(tok auth.EmailToken) error {
@@ -52,7 +72,148 @@ func (t *Tx) UpdateEmailToken(tok auth.EmailToken) error {
 	return updateEmailToken(t.store.newQuery(), t.tx.Exec, tok)
 }
 
+// DeleteEmailTokens deletes all email tokens that match the provided filter.
+func (t *Tx) DeleteEmailTokens(filter auth.EmailTokenFilter) error {
+	return deleteEmailTokens(t.store.newQuery(), t.tx.Exec, filter)
+}
+
 // FindEmailTokens queries for email tokens based on the provided filter.
 func (t *Tx) FindEmailTokens(filter auth.EmailTokenFilter) ([]auth.EmailToken, error) {
 	return selectEmailTokens(t.store.newQuery(), t.tx.Query, filter)
 }
+
+// CountEmailTokens counts the email tokens that match the provided filter.
+func (t *Tx) CountEmailTokens(filter auth.EmailTokenFilter) (int, error) {
+	return countEmailTokens(t.store.newQuery(), t.tx.Query, filter)
+}
+
+// CreateAttempt records an attempt in the database.
+func (t *Tx) CreateAttempt(a auth.Attempt) error {
+	return insertAttempt(t.store.newQuery(), t.tx.Exec, a)
+}
+
+// CountAttempts counts the attempts that match the provided filter.
+func (t *Tx) CountAttempts(filter auth.AttemptFilter) (int, error) {
+	return countAttempts(t.store.newQuery(), t.tx.Query, filter)
+}
+
+// DeleteAttempts deletes all attempts that match the provided filter.
+func (t *Tx) DeleteAttempts(filter auth.AttemptFilter) error {
+	return deleteAttempts(t.store.newQuery(), t.tx.Exec, filter)
+}
+
+// CreateTOTP creates a TOTP credential in the database. A user can have at most one
+// credential, creating a second one returns errorz.ErrConstraintViolated.
+func (t *Tx) CreateTOTP(c auth.TOTP) error {
+	return insertTOTP(t.store.newQuery(), t.tx.Exec, c)
+}
+
+// UpdateTOTP updates the TOTP credential of a user.
+// It returns errorz.ErrNotFound if the user has no credential.
+func (t *Tx) UpdateTOTP(c auth.TOTP) error {
+	return updateTOTP(t.store.newQuery(), t.tx.Exec, c)
+}
+
+// DeleteTOTPs deletes all TOTP credentials that match the provided filter.
+func (t *Tx) DeleteTOTPs(filter auth.TOTPFilter) error {
+	return deleteTOTPs(t.store.newQuery(), t.tx.Exec, filter)
+}
+
+// FindTOTPs queries for TOTP credentials based on the provided filter.
+func (t *Tx) FindTOTPs(filter auth.TOTPFilter) ([]auth.TOTP, error) {
+	return selectTOTPs(t.store.newQuery(), t.tx.Query, filter)
+}
+
+// CreateRecoveryCode creates a recovery code in the database.
+func (t *Tx) CreateRecoveryCode(c auth.RecoveryCode) error {
+	return insertRecoveryCode(t.store.newQuery(), t.tx.Exec, c)
+}
+
+// UpdateRecoveryCode updates a recovery code in the database.
+// It returns errorz.ErrNotFound if no recovery code is found.
+func (t *Tx) UpdateRecoveryCode(c auth.RecoveryCode) error {
+	return updateRecoveryCode(t.store.newQuery(), t.tx.Exec, c)
+}
+
+// DeleteRecoveryCodes deletes all recovery codes that match the provided filter.
+func (t *Tx) DeleteRecoveryCodes(filter auth.RecoveryCodeFilter) error {
+	return deleteRecoveryCodes(t.store.newQuery(), t.tx.Exec, filter)
+}
+
+// FindRecoveryCodes queries for recovery codes based on the provided filter.
+func (t *Tx) FindRecoveryCodes(filter auth.RecoveryCodeFilter) ([]auth.RecoveryCode, error) {
+	return selectRecoveryCodes(t.store.newQuery(), t.tx.Query, filter)
+}
+
+// CreatePasskey creates a passkey in the database. Credential IDs are unique,
+// registering the same credential twice results in errorz.ErrConstraintViolated.
+func (t *Tx) CreatePasskey(p auth.Passkey) error {
+	return insertPasskey(t.store.newQuery(), t.tx.Exec, p)
+}
+
+// UpdatePasskey updates a passkey in the database.
+// It returns errorz.ErrNotFound if the passkey doesn't exist.
+func (t *Tx) UpdatePasskey(p auth.Passkey) error {
+	return updatePasskey(t.store.newQuery(), t.tx.Exec, p)
+}
+
+// DeletePasskeys deletes all passkeys that match the provided filter.
+func (t *Tx) DeletePasskeys(filter auth.PasskeyFilter) error {
+	return deletePasskeys(t.store.newQuery(), t.tx.Exec, filter)
+}
+
+// FindPasskeys queries for passkeys based on the provided filter.
+func (t *Tx) FindPasskeys(filter auth.PasskeyFilter) ([]auth.Passkey, error) {
+	return selectPasskeys(t.store.newQuery(), t.tx.Query, filter)
+}
+
+// CreateAPIToken creates an API token in the database.
+func (t *Tx) CreateAPIToken(a auth.APIToken) error {
+	return insertAPIToken(t.store.newQuery(), t.tx.Exec, a)
+}
+
+// UpdateAPIToken updates an API token in the database.
+// It returns errorz.ErrNotFound if the API token doesn't exist.
+func (t *Tx) UpdateAPIToken(a auth.APIToken) error {
+	return updateAPIToken(t.store.newQuery(), t.tx.Exec, a)
+}
+
+// DeleteAPITokens deletes all API tokens that match the provided filter.
+func (t *Tx) DeleteAPITokens(filter auth.APITokenFilter) error {
+	return deleteAPITokens(t.store.newQuery(), t.tx.Exec, filter)
+}
+
+// FindAPITokens queries for API tokens based on the provided filter.
+func (t *Tx) FindAPITokens(filter auth.APITokenFilter) ([]auth.APIToken, error) {
+	return selectAPITokens(t.store.newQuery(), t.tx.Query, filter)
+}
+
+// CreateEmailBounce records a bounce in the database.
+func (t *Tx) CreateEmailBounce(b auth.EmailBounce) error {
+	return insertEmailBounce(t.store.newQuery(), t.tx.Exec, b)
+}
+
+// FindEmailBounces queries for email bounces based on the provided filter.
+func (t *Tx) FindEmailBounces(filter auth.EmailBounceFilter) ([]auth.EmailBounce, error) {
+	return selectEmailBounces(t.store.newQuery(), t.tx.Query, filter)
+}
+
+// RevokeSessions deletes all sessions of a user.
+func (t *Tx) RevokeSessions(userID uuid.UUID) error {
+	return sessionsdb.DeleteUserRecords(t.tx, userID)
+}
+
+// DeleteExpiredSessions deletes all sessions that expired at or before now.
+func (t *Tx) DeleteExpiredSessions(now time.Time) error {
+	return sessionsdb.DeleteExpiredRecords(t.tx, now)
+}
+
+// CreateOutboxMessage queues an email message in the outbox.
+func (t *Tx) CreateOutboxMessage(m outbox.Message) error {
+	return outboxdb.CreateMessage(t.tx, t.store.encryptor, m)
+}
+
+// DeleteOutboxMessages deletes all email messages to the recipient from the outbox.
+func (t *Tx) DeleteOutboxMessages(recipient email.Address) error {
+	return outboxdb.DeleteRecipientMessages(t.tx, t.store.encryptor, recipient)
+}
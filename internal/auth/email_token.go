@@ -30,6 +30,11 @@ const (
 	TokenPurposeActivate TokenPurpose = "activate"
 	// TokenPurposePasswordReset indicates a token should be used to reset a password.
 	TokenPurposePasswordReset TokenPurpose = "password_reset"
+	// TokenPurposeEmailChange indicates a token should be used to change the email address
+	// of a user. The token is sent to, and bound to, the new address.
+	TokenPurposeEmailChange TokenPurpose = "email_change"
+	// TokenPurposeAccountDeletion indicates a token should be used to delete a user.
+	TokenPurposeAccountDeletion TokenPurpose = "account_deletion"
 )
 
 // EmailTokenRaw is the raw data that will be send to the user via email.
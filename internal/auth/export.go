@@ -0,0 +1,74 @@
+package auth
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/willemschots/househunt/internal/email"
+)
+
+// Export contains the personal data that is kept about a user, see Service.Export.
+//
+// Secrets like the password hash, token hashes and the TOTP secret are left out.
+// They are of no use to the user and would only be a risk if the export leaks.
+type Export struct {
+	User          UserExport
+	EmailTokens   []EmailTokenExport
+	TOTP          *TOTPExport
+	RecoveryCodes []RecoveryCodeExport
+	Passkeys      []PasskeyExport
+	APITokens     []APITokenExport
+	EmailBounces  []EmailBounceExport
+}
+
+type UserExport struct {
+	ID        uuid.UUID
+	Email     email.Address
+	Role      Role
+	IsActive  bool
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+type EmailTokenExport struct {
+	ID         uuid.UUID
+	Email      email.Address
+	Purpose    TokenPurpose
+	CreatedAt  time.Time
+	ConsumedAt *time.Time
+}
+
+type TOTPExport struct {
+	ConfirmedAt *time.Time
+	CreatedAt   time.Time
+	UpdatedAt   time.Time
+}
+
+type RecoveryCodeExport struct {
+	CreatedAt time.Time
+	UsedAt    *time.Time
+}
+
+type PasskeyExport struct {
+	ID         uuid.UUID
+	SignCount  uint32
+	CreatedAt  time.Time
+	LastUsedAt *time.Time
+}
+
+type APITokenExport struct {
+	ID         uuid.UUID
+	Name       string
+	Scopes     []Scope
+	ExpiresAt  time.Time
+	LastUsedAt *time.Time
+	CreatedAt  time.Time
+}
+
+type EmailBounceExport struct {
+	ID        uuid.UUID
+	Email     email.Address
+	Kind      BounceKind
+	BouncedAt time.Time
+	CreatedAt time.Time
+}
@@ -0,0 +1,30 @@
+package auth
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/willemschots/househunt/internal/webauthn"
+)
+
+// Passkey is a WebAuthn credential a user can login with instead of a password.
+type Passkey struct {
+	ID     uuid.UUID
+	UserID uuid.UUID
+	// CredentialID is the ID the authenticator assigned to the credential.
+	CredentialID []byte
+	// PublicKey is the COSE encoded public key of the credential.
+	PublicKey []byte
+	// SignCount is the signature counter reported on the last use, see webauthn.ErrSignCount.
+	SignCount  uint32
+	CreatedAt  time.Time
+	LastUsedAt *time.Time
+}
+
+func (p Passkey) credential() webauthn.Credential {
+	return webauthn.Credential{
+		ID:        p.CredentialID,
+		PublicKey: p.PublicKey,
+		SignCount: p.SignCount,
+	}
+}
@@ -50,10 +50,10 @@ func (p Password) Match(h krypto.Argon2Hash) bool {
 	return h.MatchBytes(p.plain)
 }
 
-// Hash hashes the plaintext password using the argon2id algorithm.
-func (p Password) Hash() (krypto.Argon2Hash, error) {
+// Hash hashes the plaintext password using the argon2id algorithm with the provided parameters.
+func (p Password) Hash(params krypto.Argon2Params) (krypto.Argon2Hash, error) {
 	// Need to invert the call because we don't want to expose p.plain.
-	return krypto.HashArgon2(p.plain)
+	return krypto.HashArgon2WithParams(p.plain, params)
 }
 
 func (p Password) Format(f fmt.State, verb rune) {
@@ -0,0 +1,168 @@
+package auth
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/willemschots/househunt/internal/email"
+)
+
+var (
+	ErrBreachedPassword      = errors.New("this password appeared in a data breach, choose another one")
+	ErrCommonPassword        = errors.New("this password is too common, choose another one")
+	ErrPasswordContainsEmail = errors.New("the password should not contain your email address")
+)
+
+// minLocalPartLen is the minimum length of the local part of an email address
+// before it is checked for, shorter local parts would reject too many passwords.
+const minLocalPartLen = 3
+
+// PasswordPolicy decides whether a password may be used for an account. Policies are
+// checked when a password is set, existing passwords keep working.
+type PasswordPolicy interface {
+	// CheckPassword returns an error describing why the password can't be used by
+	// the user with the provided email address, or nil if it can be used.
+	CheckPassword(pwd Password, addr email.Address) error
+}
+
+// PasswordPolicies combines several policies, the error of the first policy
+// that rejects the password is returned.
+type PasswordPolicies []PasswordPolicy
+
+func (p PasswordPolicies) CheckPassword(pwd Password, addr email.Address) error {
+	for _, policy := range p {
+		err := policy.CheckPassword(pwd, addr)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// EmailPasswordPolicy rejects passwords that contain the local part of the email
+// address of the user, ignoring case.
+type EmailPasswordPolicy struct{}
+
+func (EmailPasswordPolicy) CheckPassword(pwd Password, addr email.Address) error {
+	local, _, _ := strings.Cut(string(addr), "@")
+	if len(local) < minLocalPartLen {
+		return nil
+	}
+
+	if bytes.Contains(bytes.ToLower(pwd.plain), []byte(strings.ToLower(local))) {
+		return ErrPasswordContainsEmail
+	}
+
+	return nil
+}
+
+// CommonPasswords rejects passwords that are in a list of common passwords, ignoring case.
+type CommonPasswords struct {
+	passwords map[string]struct{}
+}
+
+// LoadCommonPasswords reads a list of common passwords with one password per line.
+// Empty lines are skipped.
+func LoadCommonPasswords(r io.Reader) (*CommonPasswords, error) {
+	c := &CommonPasswords{
+		passwords: make(map[string]struct{}),
+	}
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+
+		c.passwords[strings.ToLower(line)] = struct{}{}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return c, nil
+}
+
+func (c *CommonPasswords) CheckPassword(pwd Password, _ email.Address) error {
+	_, ok := c.passwords[strings.ToLower(string(pwd.plain))]
+	if ok {
+		return ErrCommonPassword
+	}
+
+	return nil
+}
+
+// BreachedPasswords rejects passwords that appeared in data breaches. The corpus only
+// contains prefixes of the SHA-1 hashes of breached passwords, the same k-anonymity
+// approach the Pwned Passwords API uses. The full hashes are never stored, at the cost
+// of some false positives.
+type BreachedPasswords struct {
+	prefixLen int
+	prefixes  map[string]struct{}
+}
+
+// LoadBreachedPasswords reads a corpus of hex encoded SHA-1 prefixes with one prefix per
+// line. All prefixes should be of the same length, anything after a colon is ignored so
+// that lines like "HASH:COUNT" can be used. Empty lines are skipped. Longer prefixes
+// result in fewer false positives, but reveal more about the breached passwords.
+func LoadBreachedPasswords(r io.Reader) (*BreachedPasswords, error) {
+	b := &BreachedPasswords{
+		prefixes: make(map[string]struct{}),
+	}
+
+	scanner := bufio.NewScanner(r)
+	for nr := 1; scanner.Scan(); nr++ {
+		prefix, _, _ := strings.Cut(scanner.Text(), ":")
+		prefix = strings.ToLower(strings.TrimSpace(prefix))
+		if prefix == "" {
+			continue
+		}
+
+		if b.prefixLen == 0 {
+			b.prefixLen = len(prefix)
+		}
+
+		if !isHex(prefix) || len(prefix) != b.prefixLen || len(prefix) > sha1.Size*2 {
+			return nil, fmt.Errorf("invalid prefix on line %d", nr)
+		}
+
+		b.prefixes[prefix] = struct{}{}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return b, nil
+}
+
+func (b *BreachedPasswords) CheckPassword(pwd Password, _ email.Address) error {
+	sum := sha1.Sum(pwd.plain)
+	hash := hex.EncodeToString(sum[:])
+
+	_, ok := b.prefixes[hash[:b.prefixLen]]
+	if ok {
+		return ErrBreachedPassword
+	}
+
+	return nil
+}
+
+func isHex(s string) bool {
+	for _, r := range s {
+		if (r < '0' || r > '9') && (r < 'a' || r > 'f') {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,79 @@
+package auth_test
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/willemschots/househunt/internal/auth"
+	"github.com/willemschots/househunt/internal/email"
+)
+
+// breachCorpus contains the SHA-1 prefix of "correcthorsebatterystaple".
+const breachCorpus = `
+0123456789:12
+BFD3617727:3
+ffffffffff
+`
+
+func Test_PasswordPolicies(t *testing.T) {
+	breached := must(auth.LoadBreachedPasswords(strings.NewReader(breachCorpus)))
+	common := must(auth.LoadCommonPasswords(strings.NewReader("password123\n\nqwertyuiop\n")))
+
+	policy := auth.PasswordPolicies{
+		auth.EmailPasswordPolicy{},
+		common,
+		breached,
+	}
+
+	addr := must(email.ParseAddress("jacob@example.com"))
+
+	tests := map[string]struct {
+		pwd     string
+		addr    email.Address
+		wantErr error
+	}{
+		"ok, strong password":                 {"reallyStrongPassword1", addr, nil},
+		"ok, short local part is ignored":     {"reallyStrongPassword1", must(email.ParseAddress("al@example.com")), nil},
+		"ok, domain is ignored":               {"example.com-is-my-domain", addr, nil},
+		"fail, breached password":             {"correcthorsebatterystaple", addr, auth.ErrBreachedPassword},
+		"fail, common password":               {"password123", addr, auth.ErrCommonPassword},
+		"fail, common password ignoring case": {"QwertyUIOP", addr, auth.ErrCommonPassword},
+		"fail, contains email local part":     {"myNameIsJACOB!", addr, auth.ErrPasswordContainsEmail},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			err := policy.CheckPassword(must(auth.ParsePassword(tc.pwd)), tc.addr)
+			if !errors.Is(err, tc.wantErr) {
+				t.Fatalf("expected error %v, got %v (via errors.Is)", tc.wantErr, err)
+			}
+		})
+	}
+}
+
+func Test_LoadBreachedPasswords(t *testing.T) {
+	tests := map[string]string{
+		"fail, not hex":            "0123456789\nxyz0123456\n",
+		"fail, different lengths":  "0123456789\n01234567\n",
+		"fail, longer than SHA-1s": strings.Repeat("a", 41),
+	}
+
+	for name, corpus := range tests {
+		t.Run(name, func(t *testing.T) {
+			_, err := auth.LoadBreachedPasswords(strings.NewReader(corpus))
+			if err == nil {
+				t.Fatalf("wanted error, got <nil>")
+			}
+		})
+	}
+
+	t.Run("ok, empty corpus", func(t *testing.T) {
+		breached := must(auth.LoadBreachedPasswords(strings.NewReader("")))
+
+		err := breached.CheckPassword(must(auth.ParsePassword("correcthorsebatterystaple")), "")
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	})
+}
@@ -51,7 +51,7 @@ func Test_Password_ParseHashMatch(t *testing.T) {
 				t.Fatalf("failed to parse password: %v", err)
 			}
 
-			hash, err := pwd.Hash()
+			hash, err := pwd.Hash(krypto.DefaultArgon2Params())
 			if err != nil {
 				t.Fatalf("failed to hash password: %v", err)
 			}
@@ -74,7 +74,7 @@ func Test_Password_ParseHashMatch(t *testing.T) {
 	t.Run("ok, password does not match hash", func(t *testing.T) {
 		pwd := must(auth.ParsePassword("reallyStrongPassword1"))
 
-		hash, err := pwd.Hash()
+		hash, err := pwd.Hash(krypto.DefaultArgon2Params())
 		if err != nil {
 			t.Fatalf("failed to hash password: %v", err)
 		}
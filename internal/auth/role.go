@@ -0,0 +1,44 @@
+package auth
+
+import "errors"
+
+var ErrInvalidRole = errors.New("invalid role")
+
+// Role determines what a user is allowed to do in the application.
+type Role string
+
+const (
+	// RoleAgent is a real estate agent that posts listings.
+	RoleAgent Role = "agent"
+	// RoleHunter is a house hunter that responds to listings.
+	RoleHunter Role = "hunter"
+	// RoleAdmin is an administrator of the application. Administrators
+	// can't be registered, they need to be appointed.
+	RoleAdmin Role = "admin"
+)
+
+// ParseRole parses a role from a string.
+func ParseRole(raw string) (Role, error) {
+	switch r := Role(raw); r {
+	case RoleAgent, RoleHunter, RoleAdmin:
+		return r, nil
+	}
+
+	return "", ErrInvalidRole
+}
+
+// IsRegistrable reports whether users can pick this role when registering.
+func (r Role) IsRegistrable() bool {
+	return r == RoleAgent || r == RoleHunter
+}
+
+func (r *Role) UnmarshalText(text []byte) error {
+	role, err := ParseRole(string(text))
+	if err != nil {
+		return err
+	}
+
+	*r = role
+
+	return nil
+}
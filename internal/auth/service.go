@@ -1,25 +1,43 @@
 package auth
 
 import (
+	"bytes"
 	"context"
 	"errors"
+	"fmt"
+	"slices"
 	"sync"
 	"time"
 
 	"github.com/google/uuid"
 	"github.com/willemschots/househunt/internal/email"
+	"github.com/willemschots/househunt/internal/email/outbox"
 	"github.com/willemschots/househunt/internal/errorz"
 	"github.com/willemschots/househunt/internal/krypto"
+	"github.com/willemschots/househunt/internal/webauthn"
 )
 
 var (
 	ErrDuplicateUser      = errors.New("duplicate user")
 	ErrInvalidCredentials = errors.New("invalid credentials")
+	ErrInvalidCode        = errors.New("invalid code")
+	ErrTOTPEnabled        = errors.New("two-factor authentication is already enabled")
+	ErrInvalidPasskey     = errors.New("invalid passkey")
+	ErrEmailUnchanged     = errors.New("this is already your email address")
 )
 
-// Emailer is used to send templated emails.
-type Emailer interface {
-	Send(ctx context.Context, template string, to email.Address, data interface{}) error
+const (
+	// recoveryCodeCount is the number of recovery codes a user gets when enabling TOTP.
+	recoveryCodeCount = 10
+
+	// apiTokenLastUsedPrecision is how precise the last use of API tokens is recorded.
+	apiTokenLastUsedPrecision = time.Minute
+)
+
+// EmailRenderer is used to render templated emails. Rendered emails
+// are queued in the outbox as part of the transaction that caused them.
+type EmailRenderer interface {
+	Render(template string, to email.Address, data any) (email.Message, error)
 }
 
 // ErrFunc is a function that handles errors.
@@ -34,16 +52,42 @@ type ServiceConfig struct {
 	WorkerTimeout time.Duration
 	// TokenExpirty is the duration a token is valid.
 	TokenExpiry time.Duration
+	// MaxOutstandingTokens is the number of unconsumed and unexpired email tokens a user
+	// can have per purpose, further tokens are silently dropped. Zero disables the limit.
+	MaxOutstandingTokens int
+	// TokenCooldown is the minimum duration between two email tokens for the same user
+	// and purpose, tokens requested sooner are silently dropped. Zero disables the cooldown.
+	TokenCooldown time.Duration
+	// MaxLoginFailures is the number of failed logins for an email address after which
+	// further logins for that address are refused until LoginLockout has passed.
+	// Zero disables the lockout.
+	MaxLoginFailures int
+	LoginLockout     time.Duration
+	// MaxPasswordResets is the number of password resets that can be requested for an
+	// email address per PasswordResetWindow. Zero disables the limit.
+	MaxPasswordResets   int
+	PasswordResetWindow time.Duration
+	// PasswordHashing are the argon2 parameters new password hashes are created with.
+	// Existing hashes with other parameters are upgraded on login. The zero value uses
+	// krypto.DefaultArgon2Params.
+	PasswordHashing krypto.Argon2Params
+	// PasswordPolicy is checked whenever a password is set. Nil only enforces the
+	// length limits of ParsePassword.
+	PasswordPolicy PasswordPolicy
+	// TOTPIssuer is the name shown for househunt in authenticator apps.
+	TOTPIssuer string
+	// WebAuthn identifies househunt to the authenticators passkeys are stored in.
+	WebAuthn webauthn.Config
 }
 
 // Service is the type that provides the main rules for
 // authentication.
 type Service struct {
-	store      Store
-	emailer    Emailer
-	wg         *sync.WaitGroup
-	errHandler ErrFunc
-	cfg        ServiceConfig
+	store         Store
+	emailRenderer EmailRenderer
+	wg            *sync.WaitGroup
+	errHandler    ErrFunc
+	cfg           ServiceConfig
 
 	// comparisonHash is used to compare passwords when no user was found.
 	comparisonHash krypto.Argon2Hash
@@ -54,20 +98,26 @@ type Service struct {
 }
 
 // NewService creates a new Service.
-func NewService(s Store, emailer Emailer, errHandler ErrFunc, cfg ServiceConfig) (*Service, error) {
+func NewService(s Store, emailRenderer EmailRenderer, errHandler ErrFunc, cfg ServiceConfig) (*Service, error) {
+	if cfg.PasswordHashing == (krypto.Argon2Params{}) {
+		cfg.PasswordHashing = krypto.DefaultArgon2Params()
+	}
+
 	tok, err := krypto.GenerateToken()
 	if err != nil {
 		return nil, err
 	}
 
-	hash, err := krypto.HashArgon2(tok[:])
+	// The comparison hash uses the same parameters as password hashes, so that
+	// comparing against it takes as long.
+	hash, err := krypto.HashArgon2WithParams(tok[:], cfg.PasswordHashing)
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("invalid password hashing parameters: %w", err)
 	}
 
 	svc := &Service{
 		store:          s,
-		emailer:        emailer,
+		emailRenderer:  emailRenderer,
 		wg:             &sync.WaitGroup{},
 		errHandler:     errHandler,
 		cfg:            cfg,
@@ -83,13 +133,25 @@ func (s *Service) Wait() {
 	s.wg.Wait()
 }
 
-// RegisterUser registers a new user with the provided credentials.
+// RegisterUser registers a new user with the provided credentials and role.
 // The main work of this method is done in a separate goroutine. The returned
 // error does not indicate whether a user was actually registered or not. This
-// is by design to prevent information leakage.
-func (s *Service) RegisterUser(_ context.Context, c Credentials) error {
+// is by design to prevent information leakage. Registering again before activating
+// sends a new activation email, but keeps the role and password of the first
+// registration.
+func (s *Service) RegisterUser(_ context.Context, r Registration) error {
+	// Only some roles can be picked by users themselves.
+	if !r.Role.IsRegistrable() {
+		return errorz.InvalidInput{errorz.Keyed{Key: "role", Err: ErrInvalidRole}}
+	}
+
+	err := s.checkPassword("password", r.Password, r.Email)
+	if err != nil {
+		return err
+	}
+
 	// Hash the password.
-	pwdHash, err := c.Password.Hash()
+	pwdHash, err := r.Password.Hash(s.cfg.PasswordHashing)
 	if err != nil {
 		return err
 	}
@@ -105,7 +167,7 @@ func (s *Service) RegisterUser(_ context.Context, c Credentials) error {
 		wCtx, cancel := context.WithTimeout(context.Background(), s.cfg.WorkerTimeout)
 		defer cancel()
 
-		err := s.startActivation(wCtx, c.Email, pwdHash)
+		err := s.startActivation(wCtx, r.Email, r.Role, pwdHash)
 		if err != nil {
 			s.errHandler(err)
 			return
@@ -120,10 +182,10 @@ func (s *Service) RegisterUser(_ context.Context, c Credentials) error {
 // startActivation begins the activation process for a new user:
 // - Create a new auth.User if necessary.
 // - Create a new email token.
-// - Send an email to the email address with an activation link.
+// - Queue an email to the email address with an activation link.
 //
 // If an active user with the same email address exists, ErrDuplicateUser is returned.
-func (s *Service) startActivation(ctx context.Context, addr email.Address, pwdHash krypto.Argon2Hash) error {
+func (s *Service) startActivation(ctx context.Context, addr email.Address, role Role, pwdHash krypto.Argon2Hash) error {
 	now := s.NowFunc()
 
 	token, err := krypto.GenerateToken()
@@ -152,8 +214,6 @@ func (s *Service) startActivation(ctx context.Context, addr email.Address, pwdHa
 	}
 
 	err = s.inTx(ctx, func(tx Tx) error {
-		// TODO: Limit nr of tokens per user.
-
 		// Find user user with the same email.
 		users, txErr := tx.FindUsers(UserFilter{
 			Emails: []email.Address{addr},
@@ -172,6 +232,7 @@ func (s *Service) startActivation(ctx context.Context, addr email.Address, pwdHa
 				ID:           userID,
 				Email:        addr,
 				PasswordHash: pwdHash,
+				Role:         role,
 				IsActive:     false,
 				CreatedAt:    now,
 				UpdatedAt:    now,
@@ -188,7 +249,14 @@ func (s *Service) startActivation(ctx context.Context, addr email.Address, pwdHa
 				return ErrDuplicateUser
 			}
 
-			// Re-use the existing user for this email token.
+			limited, txErr := s.tokenLimitReached(tx, users[0].ID, TokenPurposeActivate, now)
+			if txErr != nil || limited {
+				return txErr
+			}
+
+			// Re-use the existing user for this email token. The role and password of
+			// the first registration stick, otherwise anyone could change them for an
+			// account that is about to be activated by its owner.
 			emailToken.UserID = users[0].ID
 		}
 
@@ -198,26 +266,17 @@ func (s *Service) startActivation(ctx context.Context, addr email.Address, pwdHa
 			return txErr
 		}
 
-		return nil
+		// Queue the activation email, it will only be sent if the token is stored.
+		return s.queueEmail(tx, "user-activation", addr, EmailTokenRaw{
+			ID:    emailToken.ID,
+			Token: token,
+		}, now)
 	})
 
 	if err != nil {
 		return err
 	}
 
-	// Send the email.
-	// This could fail independently of the transaction. This is an acceptable
-	// risk for now. If the user has not received the email, they can always try to register again.
-	//
-	// If at some point this becomes unacceptable, we need to consider some kind of outbox pattern.
-	err = s.emailer.Send(ctx, "user-activation", addr, EmailTokenRaw{
-		ID:    emailToken.ID,
-		Token: token,
-	})
-	if err != nil {
-		return err
-	}
-
 	return nil
 }
 
@@ -250,12 +309,21 @@ func (s *Service) ActivateUser(ctx context.Context, req EmailTokenRaw) error {
 		}
 
 		// Consume all unconsumed activation tokens for this user.
-		return consumeAllTokensForUserID(tx, token.UserID, TokenPurposeActivate, now)
+		return consumeAllTokensForUserID(tx, token.UserID, []TokenPurpose{TokenPurposeActivate}, now)
 	})
 }
 
 // Authenticate checks if the provided credentials are valid.
+// After too many failed attempts for an email address, errorz.ErrRateLimited
+// is returned until the lockout has passed.
 func (s *Service) Authenticate(ctx context.Context, c Credentials) (User, error) {
+	now := s.NowFunc()
+
+	failures, err := s.checkLoginFailures(ctx, c.Email, AttemptPurposeLogin, now)
+	if err != nil {
+		return User{}, err
+	}
+
 	users, err := s.store.FindUsers(ctx, UserFilter{
 		Emails:   []email.Address{c.Email},
 		IsActive: ptr(true),
@@ -268,227 +336,1584 @@ func (s *Service) Authenticate(ctx context.Context, c Credentials) (User, error)
 		// Even if no user is found we compare to a hash to prevent timing differences
 		// that could result in user enumeration attacks.
 		_ = c.Password.Match(s.comparisonHash)
-		return User{}, errorz.InvalidInput{ErrInvalidCredentials}
+		return User{}, s.loginFailed(ctx, c.Email, now)
 	}
 
 	match := c.Password.Match(users[0].PasswordHash)
 	if !match {
-		return User{}, errorz.InvalidInput{ErrInvalidCredentials}
+		return User{}, s.loginFailed(ctx, c.Email, now)
 	}
 
-	return users[0], nil
-}
-
-// RequestPasswordReset requests a password reset for the user with the provided email address.
-// Similary to RegisterUser, the main work is done in a separate goroutine and no output is
-// returned to indicate if the request was successful.
-func (s *Service) RequestPasswordReset(ctx context.Context, addr email.Address) {
-	// The actual work is done in a separate goroutine to prevent:
-	// - Waiting for the email to be send might slow down sending a response.
-	// - Information leakage. Timing difference between existing/non-existing
-	//   user could lead to user enumeration attacks.
-	s.wg.Add(1)
-	go func() {
-		defer s.wg.Done()
-
-		wCtx, cancel := context.WithTimeout(context.Background(), s.cfg.WorkerTimeout)
-		defer cancel()
+	err = s.resetLoginFailures(ctx, c.Email, AttemptPurposeLogin, failures)
+	if err != nil {
+		return User{}, err
+	}
 
-		err := s.startPasswordReset(wCtx, addr)
-		if err != nil {
-			s.errHandler(err)
-			return
-		}
-	}()
+	return s.upgradePasswordHash(ctx, users[0], c.Password, now)
 }
 
-func (s *Service) startPasswordReset(ctx context.Context, addr email.Address) error {
-	now := s.NowFunc()
-
-	token, err := krypto.GenerateToken()
-	if err != nil {
-		return err
+// checkPassword checks the password against the password policy, key is the input
+// the password was provided in.
+func (s *Service) checkPassword(key string, pwd Password, addr email.Address) error {
+	if s.cfg.PasswordPolicy == nil {
+		return nil
 	}
 
-	tokenHash, err := krypto.HashArgon2(token[:])
+	err := s.cfg.PasswordPolicy.CheckPassword(pwd, addr)
 	if err != nil {
-		return err
+		return errorz.InvalidInput{errorz.Keyed{Key: key, Err: err}}
 	}
 
-	tokenID, err := uuid.NewRandom()
-	if err != nil {
-		return err
+	return nil
+}
+
+// upgradePasswordHash rehashes the password of the user if their hash was created with
+// other parameters than those currently configured. This is the only moment the plaintext
+// password is available, so outdated hashes are upgraded as users log in.
+func (s *Service) upgradePasswordHash(ctx context.Context, user User, pwd Password, now time.Time) (User, error) {
+	if user.PasswordHash.Params() == s.cfg.PasswordHashing {
+		return user, nil
 	}
 
-	emailToken := EmailToken{
-		ID:         tokenID,
-		TokenHash:  tokenHash,
-		UserID:     uuid.Nil, // set after the user is found.
-		Email:      addr,
-		Purpose:    TokenPurposePasswordReset,
-		CreatedAt:  now,
-		ConsumedAt: nil,
+	hash, err := pwd.Hash(s.cfg.PasswordHashing)
+	if err != nil {
+		return User{}, err
 	}
 
+	old := user.PasswordHash.String()
 	err = s.inTx(ctx, func(tx Tx) error {
-		// Find the user with the provided email address.
-		user, txErr := findUser(tx, UserFilter{
-			Emails:   []email.Address{addr},
-			IsActive: ptr(true),
+		var txErr error
+		user, txErr = findUser(tx, UserFilter{
+			IDs: []uuid.UUID{user.ID},
 		})
 		if txErr != nil {
 			return txErr
 		}
 
-		// Create the new password reset token.
-		emailToken.UserID = user.ID
-
-		txErr = tx.CreateEmailToken(emailToken)
-		if txErr != nil {
-			return txErr
+		// The password was changed in the meantime, there is nothing to upgrade.
+		if user.PasswordHash.String() != old {
+			return nil
 		}
 
-		return nil
+		user.PasswordHash = hash
+		user.UpdatedAt = now
+
+		return tx.UpdateUser(user)
 	})
+	if err != nil {
+		return User{}, err
+	}
+
+	return user, nil
+}
+
+// checkLoginFailures counts the recent failures with the purpose for an email address.
+// It returns errorz.ErrRateLimited if the address is locked out.
+func (s *Service) checkLoginFailures(ctx context.Context, addr email.Address, purpose AttemptPurpose, now time.Time) (int, error) {
+	if s.cfg.MaxLoginFailures <= 0 {
+		return 0, nil
+	}
 
+	failures, err := s.store.CountAttempts(ctx, AttemptFilter{
+		Emails:       []email.Address{addr},
+		Purposes:     []AttemptPurpose{purpose},
+		CreatedAfter: ptr(now.Add(-s.cfg.LoginLockout)),
+	})
 	if err != nil {
-		return err
+		return 0, err
+	}
+
+	if failures >= s.cfg.MaxLoginFailures {
+		return 0, fmt.Errorf("too many failed logins: %w", errorz.ErrRateLimited)
+	}
+
+	return failures, nil
+}
+
+// resetLoginFailures deletes the failures with the purpose for an email address, a
+// successful login resets the lockout.
+func (s *Service) resetLoginFailures(ctx context.Context, addr email.Address, purpose AttemptPurpose, failures int) error {
+	if failures == 0 {
+		return nil
 	}
 
-	// Send the email.
-	// This could fail independently of the transaction. This is an acceptable
-	// risk for now. If the user has not received the email, they can always try to request a new password again.
-	//
-	// If at some point this becomes unacceptable, we need to consider some kind of outbox pattern.
-	err = s.emailer.Send(ctx, "password-reset-request", addr, EmailTokenRaw{
-		ID:    emailToken.ID,
-		Token: token,
+	return s.inTx(ctx, func(tx Tx) error {
+		return tx.DeleteAttempts(AttemptFilter{
+			Emails:   []email.Address{addr},
+			Purposes: []AttemptPurpose{purpose},
+		})
 	})
+}
+
+// loginFailed records a failed login and returns the error for invalid credentials.
+// Failures are recorded for unknown email addresses as well, so that a lockout doesn't
+// reveal whether an account exists.
+func (s *Service) loginFailed(ctx context.Context, addr email.Address, now time.Time) error {
+	err := s.recordLoginFailure(ctx, addr, AttemptPurposeLogin, now)
 	if err != nil {
 		return err
 	}
 
-	return nil
+	return errorz.InvalidInput{ErrInvalidCredentials}
 }
 
-// NewPassword contains the data required to reset a password.
-type NewPassword struct {
-	RawToken EmailTokenRaw
-	Password Password
-}
+func (s *Service) recordLoginFailure(ctx context.Context, addr email.Address, purpose AttemptPurpose, now time.Time) error {
+	if s.cfg.MaxLoginFailures <= 0 {
+		return nil
+	}
 
-func (s *Service) ResetPassword(ctx context.Context, np NewPassword) error {
-	now := s.NowFunc()
+	return s.inTx(ctx, func(tx Tx) error {
+		return createAttempt(tx, addr, purpose, now)
+	})
+}
 
-	// Hash the password.
-	pwdHash, err := np.Password.Hash()
+// TOTPEnabled reports whether the user has confirmed a TOTP credential. If so,
+// logging in requires a second factor, see VerifySecondFactor.
+func (s *Service) TOTPEnabled(ctx context.Context, userID uuid.UUID) (bool, error) {
+	creds, err := s.store.FindTOTPs(ctx, TOTPFilter{
+		UserIDs:     []uuid.UUID{userID},
+		IsConfirmed: ptr(true),
+	})
 	if err != nil {
-		return err
+		return false, err
 	}
 
-	var recipient email.Address
+	return len(creds) > 0, nil
+}
 
-	// finish the password reset:
-	// - Find the token.
-	// - Check if the token is still valid.
-	// - Replace the password on the user.
-	// - Consume all unconsumed activation tokens for the user.
-	err = s.inTx(ctx, func(tx Tx) error {
-		token, txErr := findConsumableEmailToken(tx, np.RawToken, TokenPurposePasswordReset, now, s.cfg.TokenExpiry)
+// EnrollTOTP starts enabling TOTP for a user. It generates a secret, or returns the
+// secret of an earlier enrollment that wasn't confirmed yet. TOTP is only enabled
+// after the user proves they added the secret to their app, see ConfirmTOTP.
+//
+// ErrTOTPEnabled is returned if the user already enabled TOTP.
+func (s *Service) EnrollTOTP(ctx context.Context, userID uuid.UUID) (TOTPEnrollment, error) {
+	now := s.NowFunc()
+
+	var enrollment TOTPEnrollment
+	err := s.inTx(ctx, func(tx Tx) error {
+		user, txErr := findUser(tx, UserFilter{
+			IDs:      []uuid.UUID{userID},
+			IsActive: ptr(true),
+		})
 		if txErr != nil {
 			return txErr
 		}
 
-		user, txErr := findUser(tx, UserFilter{
-			IDs:      []uuid.UUID{token.UserID},
-			IsActive: ptr(true),
+		creds, txErr := tx.FindTOTPs(TOTPFilter{
+			UserIDs: []uuid.UUID{userID},
 		})
 		if txErr != nil {
 			return txErr
 		}
 
-		// Update the user with the new password.
-		user.PasswordHash = pwdHash
-		user.UpdatedAt = now
+		var cred TOTP
+		if len(creds) > 0 {
+			cred = creds[0]
+			if cred.ConfirmedAt != nil {
+				return ErrTOTPEnabled
+			}
+		} else {
+			secret, txErr := krypto.GenerateTOTPSecret()
+			if txErr != nil {
+				return txErr
+			}
 
-		// We will send a confirmation email to the user after the transaction is done.
-		recipient = user.Email
+			cred = TOTP{
+				UserID:    userID,
+				Secret:    secret,
+				CreatedAt: now,
+				UpdatedAt: now,
+			}
 
-		txErr = tx.UpdateUser(user)
-		if txErr != nil {
-			return txErr
+			txErr = tx.CreateTOTP(cred)
+			if txErr != nil {
+				return txErr
+			}
 		}
 
-		// Consume all unconsumed password reset tokens for this user.
-		return consumeAllTokensForUserID(tx, token.UserID, TokenPurposePasswordReset, now)
+		enrollment = TOTPEnrollment{
+			Secret: cred.Secret,
+			URI:    cred.Secret.URI(s.cfg.TOTPIssuer, string(user.Email)),
+		}
+		return nil
 	})
 	if err != nil {
-		return err
+		return TOTPEnrollment{}, err
 	}
 
-	// Send the confirmation email asynchronously.
-	s.wg.Add(1)
-	go func() {
-		defer s.wg.Done()
+	return enrollment, nil
+}
 
-		emailCtx, cancel := context.WithTimeout(context.Background(), s.cfg.WorkerTimeout)
-		defer cancel()
-		err = s.emailer.Send(emailCtx, "password-reset-success", recipient, nil)
+// ConfirmTOTP enables TOTP for a user that started enrolling, if the code is valid.
+// It returns the recovery codes of the user, they should be shown to the user once
+// and can't be retrieved later.
+func (s *Service) ConfirmTOTP(ctx context.Context, userID uuid.UUID, code string) ([]krypto.RecoveryCode, error) {
+	now := s.NowFunc()
+
+	// Generate and hash the recovery codes before starting the transaction,
+	// hashing takes a while.
+	rawCodes := make([]krypto.RecoveryCode, 0, recoveryCodeCount)
+	codes := make([]RecoveryCode, 0, recoveryCodeCount)
+	for i := 0; i < recoveryCodeCount; i++ {
+		raw, err := krypto.GenerateRecoveryCode()
 		if err != nil {
-			s.errHandler(err)
-			return
+			return nil, err
 		}
-	}()
 
-	return nil
-}
+		hash, err := krypto.HashArgon2(raw[:])
+		if err != nil {
+			return nil, err
+		}
 
-func findConsumableEmailToken(tx Tx, raw EmailTokenRaw, purpose TokenPurpose, now time.Time, maxAge time.Duration) (EmailToken, error) {
-	tokens, err := tx.FindEmailTokens(EmailTokenFilter{
-		IDs:        []uuid.UUID{raw.ID},
-		Purposes:   []TokenPurpose{purpose},
-		IsConsumed: ptr(false),
+		id, err := uuid.NewRandom()
+		if err != nil {
+			return nil, err
+		}
+
+		rawCodes = append(rawCodes, raw)
+		codes = append(codes, RecoveryCode{
+			ID:        id,
+			UserID:    userID,
+			CodeHash:  hash,
+			CreatedAt: now,
+		})
+	}
+
+	err := s.inTx(ctx, func(tx Tx) error {
+		creds, txErr := tx.FindTOTPs(TOTPFilter{
+			UserIDs:     []uuid.UUID{userID},
+			IsConfirmed: ptr(false),
+		})
+		if txErr != nil {
+			return txErr
+		}
+
+		if len(creds) != 1 {
+			return errorz.ErrNotFound
+		}
+
+		cred := creds[0]
+		step, ok := cred.Secret.MatchCode(code, now)
+		if !ok {
+			return errorz.InvalidInput{errorz.Keyed{Key: "code", Err: ErrInvalidCode}}
+		}
+
+		cred.LastUsedStep = step
+		cred.ConfirmedAt = ptr(now)
+		cred.UpdatedAt = now
+
+		txErr = tx.UpdateTOTP(cred)
+		if txErr != nil {
+			return txErr
+		}
+
+		// Replace any leftover codes of a previous enrollment.
+		txErr = tx.DeleteRecoveryCodes(RecoveryCodeFilter{
+			UserIDs: []uuid.UUID{userID},
+		})
+		if txErr != nil {
+			return txErr
+		}
+
+		for _, c := range codes {
+			txErr = tx.CreateRecoveryCode(c)
+			if txErr != nil {
+				return txErr
+			}
+		}
+
+		return nil
 	})
 	if err != nil {
-		return EmailToken{}, err
+		return nil, err
 	}
 
-	if len(tokens) != 1 {
-		return EmailToken{}, errorz.ErrNotFound
-	}
+	return rawCodes, nil
+}
 
-	token := tokens[0]
+// DisableTOTP disables TOTP for a user. The user needs to provide a valid TOTP
+// or recovery code to do so.
+func (s *Service) DisableTOTP(ctx context.Context, userID uuid.UUID, code string) error {
+	now := s.NowFunc()
 
-	if now.Sub(token.CreatedAt) > maxAge {
-		return EmailToken{}, errorz.ErrNotFound
-	}
+	return s.inTx(ctx, func(tx Tx) error {
+		ok, txErr := verifySecondFactor(tx, userID, code, now)
+		if txErr != nil {
+			return txErr
+		}
 
-	// Check if the provided token matches the stored hash.
-	if !token.TokenHash.MatchBytes(raw.Token[:]) {
-		return EmailToken{}, errorz.ErrNotFound
-	}
+		if !ok {
+			return errorz.InvalidInput{errorz.Keyed{Key: "code", Err: ErrInvalidCode}}
+		}
 
-	return token, nil
+		txErr = tx.DeleteTOTPs(TOTPFilter{
+			UserIDs: []uuid.UUID{userID},
+		})
+		if txErr != nil {
+			return txErr
+		}
+
+		return tx.DeleteRecoveryCodes(RecoveryCodeFilter{
+			UserIDs: []uuid.UUID{userID},
+		})
+	})
 }
 
-func findUser(tx Tx, filter UserFilter) (User, error) {
-	users, err := tx.FindUsers(filter)
+// VerifySecondFactor completes the login of a user that was authenticated with
+// their credentials and has TOTP enabled. The code is either a TOTP code or an unused
+// recovery code, each code is accepted only once. Invalid codes lead to a lockout like
+// failed logins do in Authenticate, but they are counted separately so that logging in
+// with the password again doesn't reset them.
+func (s *Service) VerifySecondFactor(ctx context.Context, userID uuid.UUID, code string) (User, error) {
+	now := s.NowFunc()
+
+	user, err := s.ActiveUser(ctx, userID)
 	if err != nil {
 		return User{}, err
 	}
 
-	if len(users) != 1 {
-		return User{}, errorz.ErrNotFound
+	failures, err := s.checkLoginFailures(ctx, user.Email, AttemptPurposeSecondFactor, now)
+	if err != nil {
+		return User{}, err
 	}
 
-	return users[0], nil
-}
-
-func consumeAllTokensForUserID(tx Tx, userID uuid.UUID, purpose TokenPurpose, consumedAt time.Time) error {
-	tokens, err := tx.FindEmailTokens(EmailTokenFilter{
-		UserIDs:  []uuid.UUID{userID},
-		Purposes: []TokenPurpose{purpose},
-		//IsConsumed: ptr(false),
+	var ok bool
+	err = s.inTx(ctx, func(tx Tx) error {
+		var txErr error
+		ok, txErr = verifySecondFactor(tx, userID, code, now)
+		return txErr
+	})
+	if err != nil {
+		return User{}, err
+	}
+
+	if !ok {
+		err = s.recordLoginFailure(ctx, user.Email, AttemptPurposeSecondFactor, now)
+		if err != nil {
+			return User{}, err
+		}
+
+		return User{}, errorz.InvalidInput{errorz.Keyed{Key: "code", Err: ErrInvalidCode}}
+	}
+
+	err = s.resetLoginFailures(ctx, user.Email, AttemptPurposeSecondFactor, failures)
+	if err != nil {
+		return User{}, err
+	}
+
+	return user, nil
+}
+
+// PasskeyCreationOptions returns the options for the browser to register a passkey
+// for a user. The challenge should be stored and passed to RegisterPasskey.
+func (s *Service) PasskeyCreationOptions(ctx context.Context, userID uuid.UUID, challenge []byte) (webauthn.CreationOptions, error) {
+	user, err := s.ActiveUser(ctx, userID)
+	if err != nil {
+		return webauthn.CreationOptions{}, err
+	}
+
+	passkeys, err := s.Passkeys(ctx, userID)
+	if err != nil {
+		return webauthn.CreationOptions{}, err
+	}
+
+	exclude := make([][]byte, 0, len(passkeys))
+	for _, p := range passkeys {
+		exclude = append(exclude, p.CredentialID)
+	}
+
+	return s.cfg.WebAuthn.CreationOptions(challenge, user.ID[:], string(user.Email), exclude), nil
+}
+
+// RegisterPasskey verifies the response of the authenticator to the creation options
+// with the same challenge and stores the passkey for the user.
+func (s *Service) RegisterPasskey(ctx context.Context, userID uuid.UUID, challenge []byte, resp webauthn.RegistrationResponse) (Passkey, error) {
+	now := s.NowFunc()
+
+	cred, err := s.cfg.WebAuthn.VerifyRegistration(challenge, resp)
+	if err != nil {
+		return Passkey{}, errorz.InvalidInput{errorz.Keyed{Key: "passkey", Err: ErrInvalidPasskey}}
+	}
+
+	id, err := uuid.NewRandom()
+	if err != nil {
+		return Passkey{}, err
+	}
+
+	passkey := Passkey{
+		ID:           id,
+		UserID:       userID,
+		CredentialID: cred.ID,
+		PublicKey:    cred.PublicKey,
+		SignCount:    cred.SignCount,
+		CreatedAt:    now,
+	}
+
+	err = s.inTx(ctx, func(tx Tx) error {
+		_, txErr := findUser(tx, UserFilter{
+			IDs:      []uuid.UUID{userID},
+			IsActive: ptr(true),
+		})
+		if txErr != nil {
+			return txErr
+		}
+
+		txErr = tx.CreatePasskey(passkey)
+		if errors.Is(txErr, errorz.ErrConstraintViolated) {
+			// The credential was registered before.
+			return errorz.InvalidInput{errorz.Keyed{Key: "passkey", Err: ErrInvalidPasskey}}
+		}
+
+		return txErr
+	})
+	if err != nil {
+		return Passkey{}, err
+	}
+
+	return passkey, nil
+}
+
+// PasskeyRequestOptions returns the options for the browser to login with a passkey.
+// The challenge should be stored and passed to AuthenticatePasskey.
+func (s *Service) PasskeyRequestOptions(challenge []byte) webauthn.RequestOptions {
+	return s.cfg.WebAuthn.RequestOptions(challenge)
+}
+
+// AuthenticatePasskey verifies the response of the authenticator to the request options
+// with the same challenge, and returns the user the passkey belongs to. Passkeys require
+// user verification by the authenticator, so no second factor is required.
+func (s *Service) AuthenticatePasskey(ctx context.Context, challenge []byte, resp webauthn.AssertionResponse) (User, error) {
+	now := s.NowFunc()
+	invalidErr := errorz.InvalidInput{errorz.Keyed{Key: "passkey", Err: ErrInvalidPasskey}}
+
+	var user User
+	err := s.inTx(ctx, func(tx Tx) error {
+		passkeys, txErr := tx.FindPasskeys(PasskeyFilter{
+			CredentialIDs: [][]byte{resp.CredentialID},
+		})
+		if txErr != nil {
+			return txErr
+		}
+
+		if len(passkeys) != 1 {
+			return invalidErr
+		}
+
+		passkey := passkeys[0]
+		if len(resp.UserHandle) > 0 && !bytes.Equal(resp.UserHandle, passkey.UserID[:]) {
+			return invalidErr
+		}
+
+		user, txErr = findUser(tx, UserFilter{
+			IDs:      []uuid.UUID{passkey.UserID},
+			IsActive: ptr(true),
+		})
+		if errors.Is(txErr, errorz.ErrNotFound) {
+			return invalidErr
+		}
+		if txErr != nil {
+			return txErr
+		}
+
+		signCount, txErr := s.cfg.WebAuthn.VerifyAssertion(challenge, passkey.credential(), resp)
+		if errors.Is(txErr, webauthn.ErrSignCount) {
+			// Might be a cloned authenticator, report it.
+			s.errHandler(fmt.Errorf("passkey %s of user %s: %w", passkey.ID, passkey.UserID, txErr))
+			return invalidErr
+		}
+		if txErr != nil {
+			return invalidErr
+		}
+
+		passkey.SignCount = signCount
+		passkey.LastUsedAt = ptr(now)
+		return tx.UpdatePasskey(passkey)
+	})
+	if err != nil {
+		return User{}, err
+	}
+
+	return user, nil
+}
+
+// Passkeys returns the passkeys of a user.
+func (s *Service) Passkeys(ctx context.Context, userID uuid.UUID) ([]Passkey, error) {
+	return s.store.FindPasskeys(ctx, PasskeyFilter{
+		UserIDs: []uuid.UUID{userID},
+	})
+}
+
+// DeletePasskey deletes a passkey of a user.
+// It returns errorz.ErrNotFound if the user has no such passkey.
+func (s *Service) DeletePasskey(ctx context.Context, userID, id uuid.UUID) error {
+	filter := PasskeyFilter{
+		IDs:     []uuid.UUID{id},
+		UserIDs: []uuid.UUID{userID},
+	}
+
+	return s.inTx(ctx, func(tx Tx) error {
+		passkeys, txErr := tx.FindPasskeys(filter)
+		if txErr != nil {
+			return txErr
+		}
+
+		if len(passkeys) != 1 {
+			return errorz.ErrNotFound
+		}
+
+		return tx.DeletePasskeys(filter)
+	})
+}
+
+// CreateAPIToken creates an API token for a user. The raw token is only returned
+// here, so that it can be shown to the user once.
+func (s *Service) CreateAPIToken(ctx context.Context, userID uuid.UUID, n NewAPIToken) (APIToken, APITokenRaw, error) {
+	now := s.NowFunc()
+
+	err := n.Validate()
+	if err != nil {
+		return APIToken{}, APITokenRaw{}, err
+	}
+
+	id, err := uuid.NewRandom()
+	if err != nil {
+		return APIToken{}, APITokenRaw{}, err
+	}
+
+	token, err := krypto.GenerateToken()
+	if err != nil {
+		return APIToken{}, APITokenRaw{}, err
+	}
+
+	tokenHash, err := krypto.HashArgon2(token[:])
+	if err != nil {
+		return APIToken{}, APITokenRaw{}, err
+	}
+
+	scopes := slices.Clone(n.Scopes)
+	slices.Sort(scopes)
+
+	apiToken := APIToken{
+		ID:        id,
+		UserID:    userID,
+		Name:      n.Name,
+		Scopes:    slices.Compact(scopes),
+		TokenHash: tokenHash,
+		ExpiresAt: now.AddDate(0, 0, n.ExpiresInDays),
+		CreatedAt: now,
+	}
+
+	err = s.inTx(ctx, func(tx Tx) error {
+		_, txErr := findUser(tx, UserFilter{
+			IDs:      []uuid.UUID{userID},
+			IsActive: ptr(true),
+		})
+		if txErr != nil {
+			return txErr
+		}
+
+		tokens, txErr := tx.FindAPITokens(APITokenFilter{
+			UserIDs: []uuid.UUID{userID},
+		})
+		if txErr != nil {
+			return txErr
+		}
+
+		if len(tokens) >= maxAPITokens {
+			return errorz.InvalidInput{ErrTooManyAPITokens}
+		}
+
+		return tx.CreateAPIToken(apiToken)
+	})
+	if err != nil {
+		return APIToken{}, APITokenRaw{}, err
+	}
+
+	return apiToken, APITokenRaw{ID: id, Token: token}, nil
+}
+
+// AuthenticateAPIToken returns the active user the API token belongs to, together
+// with the token itself so that its scopes can be checked. ErrInvalidAPIToken is
+// returned for unknown, expired or mismatching tokens.
+func (s *Service) AuthenticateAPIToken(ctx context.Context, raw APITokenRaw) (User, APIToken, error) {
+	now := s.NowFunc()
+
+	tokens, err := s.store.FindAPITokens(ctx, APITokenFilter{
+		IDs: []uuid.UUID{raw.ID},
+	})
+	if err != nil {
+		return User{}, APIToken{}, err
+	}
+
+	if len(tokens) != 1 {
+		return User{}, APIToken{}, ErrInvalidAPIToken
+	}
+
+	token := tokens[0]
+	if token.IsExpired(now) || !token.TokenHash.MatchBytes(raw.Token[:]) {
+		return User{}, APIToken{}, ErrInvalidAPIToken
+	}
+
+	users, err := s.store.FindUsers(ctx, UserFilter{
+		IDs:      []uuid.UUID{token.UserID},
+		IsActive: ptr(true),
+	})
+	if err != nil {
+		return User{}, APIToken{}, err
+	}
+
+	if len(users) != 1 {
+		return User{}, APIToken{}, ErrInvalidAPIToken
+	}
+
+	// Tokens are usually used for many requests in a row, the last use is
+	// only recorded once per apiTokenLastUsedPrecision to limit the writes.
+	if token.LastUsedAt == nil || now.Sub(*token.LastUsedAt) >= apiTokenLastUsedPrecision {
+		token.LastUsedAt = ptr(now)
+		err = s.inTx(ctx, func(tx Tx) error {
+			return tx.UpdateAPIToken(token)
+		})
+		if errors.Is(err, errorz.ErrNotFound) {
+			// The token was deleted in the meantime.
+			return User{}, APIToken{}, ErrInvalidAPIToken
+		}
+		if err != nil {
+			return User{}, APIToken{}, err
+		}
+	}
+
+	return users[0], token, nil
+}
+
+// APITokens returns the API tokens of a user.
+func (s *Service) APITokens(ctx context.Context, userID uuid.UUID) ([]APIToken, error) {
+	return s.store.FindAPITokens(ctx, APITokenFilter{
+		UserIDs: []uuid.UUID{userID},
+	})
+}
+
+// DeleteAPIToken deletes an API token of a user, after which it can no longer be used.
+// It returns errorz.ErrNotFound if the user has no such API token.
+func (s *Service) DeleteAPIToken(ctx context.Context, userID, id uuid.UUID) error {
+	filter := APITokenFilter{
+		IDs:     []uuid.UUID{id},
+		UserIDs: []uuid.UUID{userID},
+	}
+
+	return s.inTx(ctx, func(tx Tx) error {
+		tokens, txErr := tx.FindAPITokens(filter)
+		if txErr != nil {
+			return txErr
+		}
+
+		if len(tokens) != 1 {
+			return errorz.ErrNotFound
+		}
+
+		return tx.DeleteAPITokens(filter)
+	})
+}
+
+// ActiveUser finds an active user by their ID.
+func (s *Service) ActiveUser(ctx context.Context, userID uuid.UUID) (User, error) {
+	users, err := s.store.FindUsers(ctx, UserFilter{
+		IDs:      []uuid.UUID{userID},
+		IsActive: ptr(true),
+	})
+	if err != nil {
+		return User{}, err
+	}
+
+	if len(users) != 1 {
+		return User{}, errorz.ErrNotFound
+	}
+
+	return users[0], nil
+}
+
+// RecordEmailBounce records that an email to addr bounced, or that the recipient marked
+// it as spam. Bounces of addresses that don't belong to a user are ignored, for example
+// those of users that changed their address or deleted their account.
+func (s *Service) RecordEmailBounce(ctx context.Context, addr email.Address, kind BounceKind, bouncedAt time.Time) error {
+	return s.inTx(ctx, func(tx Tx) error {
+		users, txErr := tx.FindUsers(UserFilter{
+			Emails: []email.Address{addr},
+		})
+		if txErr != nil {
+			return txErr
+		}
+
+		if len(users) != 1 {
+			return nil
+		}
+
+		id, txErr := uuid.NewRandom()
+		if txErr != nil {
+			return txErr
+		}
+
+		return tx.CreateEmailBounce(EmailBounce{
+			ID:        id,
+			UserID:    users[0].ID,
+			Email:     addr,
+			Kind:      kind,
+			BouncedAt: bouncedAt,
+			CreatedAt: s.NowFunc(),
+		})
+	})
+}
+
+// EmailSuppressed reports whether emails to addr should no longer be sent, because
+// an earlier email bounced or was marked as spam.
+func (s *Service) EmailSuppressed(ctx context.Context, addr email.Address) (bool, error) {
+	count, err := s.store.CountEmailBounces(ctx, EmailBounceFilter{
+		Emails: []email.Address{addr},
+	})
+	if err != nil {
+		return false, err
+	}
+
+	return count > 0, nil
+}
+
+// EmailBounced reports whether emails to the current address of an active user are
+// suppressed. The user will have to change their address to receive emails again.
+func (s *Service) EmailBounced(ctx context.Context, userID uuid.UUID) (bool, error) {
+	user, err := s.ActiveUser(ctx, userID)
+	if err != nil {
+		return false, err
+	}
+
+	return s.EmailSuppressed(ctx, user.Email)
+}
+
+// RequestPasswordReset requests a password reset for the user with the provided email address.
+// Similary to RegisterUser, the main work is done in a separate goroutine and no output is
+// returned to indicate if the request was successful.
+func (s *Service) RequestPasswordReset(ctx context.Context, addr email.Address) {
+	// The actual work is done in a separate goroutine to prevent:
+	// - Waiting for the email to be send might slow down sending a response.
+	// - Information leakage. Timing difference between existing/non-existing
+	//   user could lead to user enumeration attacks.
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+
+		wCtx, cancel := context.WithTimeout(context.Background(), s.cfg.WorkerTimeout)
+		defer cancel()
+
+		err := s.startPasswordReset(wCtx, addr)
+		if err != nil {
+			s.errHandler(err)
+			return
+		}
+	}()
+}
+
+func (s *Service) startPasswordReset(ctx context.Context, addr email.Address) error {
+	now := s.NowFunc()
+
+	token, err := krypto.GenerateToken()
+	if err != nil {
+		return err
+	}
+
+	tokenHash, err := krypto.HashArgon2(token[:])
+	if err != nil {
+		return err
+	}
+
+	tokenID, err := uuid.NewRandom()
+	if err != nil {
+		return err
+	}
+
+	emailToken := EmailToken{
+		ID:         tokenID,
+		TokenHash:  tokenHash,
+		UserID:     uuid.Nil, // set after the user is found.
+		Email:      addr,
+		Purpose:    TokenPurposePasswordReset,
+		CreatedAt:  now,
+		ConsumedAt: nil,
+	}
+
+	err = s.inTx(ctx, func(tx Tx) error {
+		if s.cfg.MaxPasswordResets > 0 {
+			// Limit the number of reset emails that can be sent to a single address.
+			count, txErr := tx.CountAttempts(AttemptFilter{
+				Emails:       []email.Address{addr},
+				Purposes:     []AttemptPurpose{AttemptPurposePasswordReset},
+				CreatedAfter: ptr(now.Add(-s.cfg.PasswordResetWindow)),
+			})
+			if txErr != nil {
+				return txErr
+			}
+
+			if count >= s.cfg.MaxPasswordResets {
+				return fmt.Errorf("too many password reset requests: %w", errorz.ErrRateLimited)
+			}
+
+			txErr = createAttempt(tx, addr, AttemptPurposePasswordReset, now)
+			if txErr != nil {
+				return txErr
+			}
+		}
+
+		// Find the user with the provided email address.
+		user, txErr := findUser(tx, UserFilter{
+			Emails:   []email.Address{addr},
+			IsActive: ptr(true),
+		})
+		if txErr != nil {
+			return txErr
+		}
+
+		limited, txErr := s.tokenLimitReached(tx, user.ID, TokenPurposePasswordReset, now)
+		if txErr != nil || limited {
+			return txErr
+		}
+
+		// Create the new password reset token.
+		emailToken.UserID = user.ID
+
+		txErr = tx.CreateEmailToken(emailToken)
+		if txErr != nil {
+			return txErr
+		}
+
+		// Queue the password reset email, it will only be sent if the token is stored.
+		return s.queueEmail(tx, "password-reset-request", addr, EmailTokenRaw{
+			ID:    emailToken.ID,
+			Token: token,
+		}, now)
+	})
+
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// NewPassword contains the data required to reset a password.
+type NewPassword struct {
+	RawToken EmailTokenRaw
+	Password Password
+}
+
+func (s *Service) ResetPassword(ctx context.Context, np NewPassword) error {
+	now := s.NowFunc()
+
+	// Hash the password.
+	pwdHash, err := np.Password.Hash(s.cfg.PasswordHashing)
+	if err != nil {
+		return err
+	}
+
+	// finish the password reset:
+	// - Find the token.
+	// - Check if the token is still valid.
+	// - Replace the password on the user.
+	// - Consume all unconsumed activation tokens for the user.
+	// - Revoke all sessions of the user, so that other devices are logged out.
+	// - Queue a confirmation email.
+	return s.inTx(ctx, func(tx Tx) error {
+		token, txErr := findConsumableEmailToken(tx, np.RawToken, TokenPurposePasswordReset, now, s.cfg.TokenExpiry)
+		if txErr != nil {
+			return txErr
+		}
+
+		user, txErr := findUser(tx, UserFilter{
+			IDs:      []uuid.UUID{token.UserID},
+			IsActive: ptr(true),
+		})
+		if txErr != nil {
+			return txErr
+		}
+
+		txErr = s.checkPassword("password", np.Password, user.Email)
+		if txErr != nil {
+			return txErr
+		}
+
+		// Update the user with the new password.
+		user.PasswordHash = pwdHash
+		user.UpdatedAt = now
+
+		txErr = tx.UpdateUser(user)
+		if txErr != nil {
+			return txErr
+		}
+
+		// Consume all unconsumed password reset tokens for this user.
+		txErr = consumeAllTokensForUserID(tx, token.UserID, []TokenPurpose{TokenPurposePasswordReset}, now)
+		if txErr != nil {
+			return txErr
+		}
+
+		txErr = tx.RevokeSessions(user.ID)
+		if txErr != nil {
+			return txErr
+		}
+
+		return s.queueEmail(tx, "password-reset-success", user.Email, nil, now)
+	})
+}
+
+// ChangePassword changes the password of a logged in user. The current password must be
+// provided as well, so that an unattended session can't be used to take over the account.
+// Wrong passwords count as failed logins, see Authenticate.
+//
+// All sessions of the user are revoked, callers that want to keep the current session
+// should renew it.
+func (s *Service) ChangePassword(ctx context.Context, userID uuid.UUID, current, newPassword Password) error {
+	now := s.NowFunc()
+
+	user, err := s.ActiveUser(ctx, userID)
+	if err != nil {
+		return err
+	}
+
+	failures, err := s.checkLoginFailures(ctx, user.Email, AttemptPurposeLogin, now)
+	if err != nil {
+		return err
+	}
+
+	if !current.Match(user.PasswordHash) {
+		err = s.recordLoginFailure(ctx, user.Email, AttemptPurposeLogin, now)
+		if err != nil {
+			return err
+		}
+
+		return errorz.InvalidInput{errorz.Keyed{Key: "current", Err: ErrInvalidCredentials}}
+	}
+
+	err = s.resetLoginFailures(ctx, user.Email, AttemptPurposeLogin, failures)
+	if err != nil {
+		return err
+	}
+
+	err = s.checkPassword("new", newPassword, user.Email)
+	if err != nil {
+		return err
+	}
+
+	// Hash the new password.
+	pwdHash, err := newPassword.Hash(s.cfg.PasswordHashing)
+	if err != nil {
+		return err
+	}
+
+	// change the password:
+	// - Replace the password on the user.
+	// - Consume all unconsumed password reset tokens, they're no longer needed.
+	// - Revoke all sessions of the user, so that other devices are logged out.
+	// - Queue a confirmation email.
+	return s.inTx(ctx, func(tx Tx) error {
+		user, txErr := findUser(tx, UserFilter{
+			IDs:      []uuid.UUID{userID},
+			IsActive: ptr(true),
+		})
+		if txErr != nil {
+			return txErr
+		}
+
+		user.PasswordHash = pwdHash
+		user.UpdatedAt = now
+
+		txErr = tx.UpdateUser(user)
+		if txErr != nil {
+			return txErr
+		}
+
+		txErr = consumeAllTokensForUserID(tx, user.ID, []TokenPurpose{TokenPurposePasswordReset}, now)
+		if txErr != nil {
+			return txErr
+		}
+
+		txErr = tx.RevokeSessions(user.ID)
+		if txErr != nil {
+			return txErr
+		}
+
+		return s.queueEmail(tx, "password-change-success", user.Email, nil, now)
+	})
+}
+
+// RequestEmailChange requests changing the email address of a user to addr. Similarly
+// to RegisterUser, the main work is done in a separate goroutine and the returned error
+// does not indicate whether the address is available. A confirmation link is sent to
+// the new address, the change is made once it's confirmed with ConfirmEmailChange.
+func (s *Service) RequestEmailChange(ctx context.Context, userID uuid.UUID, addr email.Address) error {
+	user, err := s.ActiveUser(ctx, userID)
+	if err != nil {
+		return err
+	}
+
+	if user.Email == addr {
+		return errorz.InvalidInput{errorz.Keyed{Key: "email", Err: ErrEmailUnchanged}}
+	}
+
+	// The actual work is done in a separate goroutine to prevent:
+	// - Waiting for the email to be send might slow down sending a response.
+	// - Information leakage. Timing difference between used and unused email
+	//   addresses could lead to user enumeration attacks.
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+
+		wCtx, cancel := context.WithTimeout(context.Background(), s.cfg.WorkerTimeout)
+		defer cancel()
+
+		err := s.startEmailChange(wCtx, userID, addr)
+		if err != nil {
+			s.errHandler(err)
+			return
+		}
+	}()
+
+	return nil
+}
+
+// startEmailChange creates an email change token and queues an email with a
+// confirmation link to the new address.
+//
+// If a user with the new address exists, ErrDuplicateUser is returned.
+func (s *Service) startEmailChange(ctx context.Context, userID uuid.UUID, addr email.Address) error {
+	now := s.NowFunc()
+
+	token, err := krypto.GenerateToken()
+	if err != nil {
+		return err
+	}
+
+	tokenHash, err := krypto.HashArgon2(token[:])
+	if err != nil {
+		return err
+	}
+
+	tokenID, err := uuid.NewRandom()
+	if err != nil {
+		return err
+	}
+
+	emailToken := EmailToken{
+		ID:         tokenID,
+		TokenHash:  tokenHash,
+		UserID:     userID,
+		Email:      addr,
+		Purpose:    TokenPurposeEmailChange,
+		CreatedAt:  now,
+		ConsumedAt: nil,
+	}
+
+	return s.inTx(ctx, func(tx Tx) error {
+		_, txErr := findUser(tx, UserFilter{
+			IDs:      []uuid.UUID{userID},
+			IsActive: ptr(true),
+		})
+		if txErr != nil {
+			return txErr
+		}
+
+		txErr = ensureEmailAvailable(tx, addr)
+		if txErr != nil {
+			return txErr
+		}
+
+		limited, txErr := s.tokenLimitReached(tx, userID, TokenPurposeEmailChange, now)
+		if txErr != nil || limited {
+			return txErr
+		}
+
+		txErr = tx.CreateEmailToken(emailToken)
+		if txErr != nil {
+			return txErr
+		}
+
+		// Queue the confirmation email, it will only be sent if the token is stored.
+		return s.queueEmail(tx, "email-change-request", addr, EmailTokenRaw{
+			ID:    emailToken.ID,
+			Token: token,
+		}, now)
+	})
+}
+
+// ConfirmEmailChange changes the email address of a user to the address the token
+// was sent to. The previous address is notified of the change.
+func (s *Service) ConfirmEmailChange(ctx context.Context, raw EmailTokenRaw) error {
+	now := s.NowFunc()
+
+	// finish the email change:
+	// - Find the token.
+	// - Check if the token is still valid.
+	// - Check the new address wasn't taken in the meantime.
+	// - Replace the email address (and its blind index) on the user.
+	// - Consume all unconsumed tokens for the user, tokens that were sent to the previous
+	//   address should not be usable by whoever controls it.
+	// - Queue a notification to the previous address.
+	return s.inTx(ctx, func(tx Tx) error {
+		token, txErr := findConsumableEmailToken(tx, raw, TokenPurposeEmailChange, now, s.cfg.TokenExpiry)
+		if txErr != nil {
+			return txErr
+		}
+
+		user, txErr := findUser(tx, UserFilter{
+			IDs:      []uuid.UUID{token.UserID},
+			IsActive: ptr(true),
+		})
+		if txErr != nil {
+			return txErr
+		}
+
+		txErr = ensureEmailAvailable(tx, token.Email)
+		if errors.Is(txErr, ErrDuplicateUser) {
+			return errorz.InvalidInput{errorz.Keyed{Key: "email", Err: ErrDuplicateUser}}
+		}
+		if txErr != nil {
+			return txErr
+		}
+
+		oldAddr := user.Email
+		user.Email = token.Email
+		user.UpdatedAt = now
+
+		txErr = tx.UpdateUser(user)
+		if txErr != nil {
+			return txErr
+		}
+
+		txErr = consumeAllTokensForUserID(tx, user.ID, []TokenPurpose{
+			TokenPurposePasswordReset,
+			TokenPurposeEmailChange,
+			TokenPurposeAccountDeletion,
+		}, now)
+		if txErr != nil {
+			return txErr
+		}
+
+		return s.queueEmail(tx, "email-change-notice", oldAddr, nil, now)
+	})
+}
+
+// Export returns the personal data that is kept about a user.
+func (s *Service) Export(ctx context.Context, userID uuid.UUID) (Export, error) {
+	var exp Export
+	err := s.inTx(ctx, func(tx Tx) error {
+		user, txErr := findUser(tx, UserFilter{
+			IDs:      []uuid.UUID{userID},
+			IsActive: ptr(true),
+		})
+		if txErr != nil {
+			return txErr
+		}
+
+		exp.User = UserExport{
+			ID:        user.ID,
+			Email:     user.Email,
+			Role:      user.Role,
+			IsActive:  user.IsActive,
+			CreatedAt: user.CreatedAt,
+			UpdatedAt: user.UpdatedAt,
+		}
+
+		tokens, txErr := tx.FindEmailTokens(EmailTokenFilter{
+			UserIDs: []uuid.UUID{userID},
+		})
+		if txErr != nil {
+			return txErr
+		}
+
+		exp.EmailTokens = make([]EmailTokenExport, 0, len(tokens))
+		for _, t := range tokens {
+			exp.EmailTokens = append(exp.EmailTokens, EmailTokenExport{
+				ID:         t.ID,
+				Email:      t.Email,
+				Purpose:    t.Purpose,
+				CreatedAt:  t.CreatedAt,
+				ConsumedAt: t.ConsumedAt,
+			})
+		}
+
+		creds, txErr := tx.FindTOTPs(TOTPFilter{
+			UserIDs: []uuid.UUID{userID},
+		})
+		if txErr != nil {
+			return txErr
+		}
+
+		if len(creds) == 1 {
+			exp.TOTP = &TOTPExport{
+				ConfirmedAt: creds[0].ConfirmedAt,
+				CreatedAt:   creds[0].CreatedAt,
+				UpdatedAt:   creds[0].UpdatedAt,
+			}
+		}
+
+		codes, txErr := tx.FindRecoveryCodes(RecoveryCodeFilter{
+			UserIDs: []uuid.UUID{userID},
+		})
+		if txErr != nil {
+			return txErr
+		}
+
+		exp.RecoveryCodes = make([]RecoveryCodeExport, 0, len(codes))
+		for _, c := range codes {
+			exp.RecoveryCodes = append(exp.RecoveryCodes, RecoveryCodeExport{
+				CreatedAt: c.CreatedAt,
+				UsedAt:    c.UsedAt,
+			})
+		}
+
+		passkeys, txErr := tx.FindPasskeys(PasskeyFilter{
+			UserIDs: []uuid.UUID{userID},
+		})
+		if txErr != nil {
+			return txErr
+		}
+
+		exp.Passkeys = make([]PasskeyExport, 0, len(passkeys))
+		for _, p := range passkeys {
+			exp.Passkeys = append(exp.Passkeys, PasskeyExport{
+				ID:         p.ID,
+				SignCount:  p.SignCount,
+				CreatedAt:  p.CreatedAt,
+				LastUsedAt: p.LastUsedAt,
+			})
+		}
+
+		apiTokens, txErr := tx.FindAPITokens(APITokenFilter{
+			UserIDs: []uuid.UUID{userID},
+		})
+		if txErr != nil {
+			return txErr
+		}
+
+		exp.APITokens = make([]APITokenExport, 0, len(apiTokens))
+		for _, t := range apiTokens {
+			exp.APITokens = append(exp.APITokens, APITokenExport{
+				ID:         t.ID,
+				Name:       t.Name,
+				Scopes:     t.Scopes,
+				ExpiresAt:  t.ExpiresAt,
+				LastUsedAt: t.LastUsedAt,
+				CreatedAt:  t.CreatedAt,
+			})
+		}
+
+		bounces, txErr := tx.FindEmailBounces(EmailBounceFilter{
+			UserIDs: []uuid.UUID{userID},
+		})
+		if txErr != nil {
+			return txErr
+		}
+
+		exp.EmailBounces = make([]EmailBounceExport, 0, len(bounces))
+		for _, b := range bounces {
+			exp.EmailBounces = append(exp.EmailBounces, EmailBounceExport{
+				ID:        b.ID,
+				Email:     b.Email,
+				Kind:      b.Kind,
+				BouncedAt: b.BouncedAt,
+				CreatedAt: b.CreatedAt,
+			})
+		}
+
+		return nil
+	})
+	if err != nil {
+		return Export{}, err
+	}
+
+	return exp, nil
+}
+
+// RequestAccountDeletion sends a link to the user to confirm they want their account
+// to be deleted, the deletion happens once it's confirmed with DeleteAccount. No link
+// is sent if the user reached the limits on email tokens, see ServiceConfig.
+func (s *Service) RequestAccountDeletion(ctx context.Context, userID uuid.UUID) error {
+	now := s.NowFunc()
+
+	token, err := krypto.GenerateToken()
+	if err != nil {
+		return err
+	}
+
+	tokenHash, err := krypto.HashArgon2(token[:])
+	if err != nil {
+		return err
+	}
+
+	tokenID, err := uuid.NewRandom()
+	if err != nil {
+		return err
+	}
+
+	return s.inTx(ctx, func(tx Tx) error {
+		user, txErr := findUser(tx, UserFilter{
+			IDs:      []uuid.UUID{userID},
+			IsActive: ptr(true),
+		})
+		if txErr != nil {
+			return txErr
+		}
+
+		limited, txErr := s.tokenLimitReached(tx, user.ID, TokenPurposeAccountDeletion, now)
+		if txErr != nil || limited {
+			return txErr
+		}
+
+		emailToken := EmailToken{
+			ID:         tokenID,
+			TokenHash:  tokenHash,
+			UserID:     user.ID,
+			Email:      user.Email,
+			Purpose:    TokenPurposeAccountDeletion,
+			CreatedAt:  now,
+			ConsumedAt: nil,
+		}
+
+		txErr = tx.CreateEmailToken(emailToken)
+		if txErr != nil {
+			return txErr
+		}
+
+		// Queue the confirmation email, it will only be sent if the token is stored.
+		return s.queueEmail(tx, "account-deletion-request", user.Email, EmailTokenRaw{
+			ID:    emailToken.ID,
+			Token: token,
+		}, now)
+	})
+}
+
+// DeleteAccount deletes the user the token was sent to, together with all data that
+// belongs to the user. A last email confirms the deletion.
+func (s *Service) DeleteAccount(ctx context.Context, raw EmailTokenRaw) error {
+	now := s.NowFunc()
+
+	// finish the account deletion:
+	// - Find the token.
+	// - Check if the token is still valid.
+	// - Delete the user and everything that belongs to it, including the token.
+	// - Delete the attempts that were recorded for the email address.
+	// - Delete the emails to the address from the outbox, they contain links with tokens.
+	// - Queue a confirmation email.
+	return s.inTx(ctx, func(tx Tx) error {
+		token, txErr := findConsumableEmailToken(tx, raw, TokenPurposeAccountDeletion, now, s.cfg.TokenExpiry)
+		if txErr != nil {
+			return txErr
+		}
+
+		user, txErr := findUser(tx, UserFilter{
+			IDs:      []uuid.UUID{token.UserID},
+			IsActive: ptr(true),
+		})
+		if txErr != nil {
+			return txErr
+		}
+
+		txErr = tx.DeleteUser(user.ID)
+		if txErr != nil {
+			return txErr
+		}
+
+		txErr = tx.DeleteAttempts(AttemptFilter{
+			Emails: []email.Address{user.Email},
+		})
+		if txErr != nil {
+			return txErr
+		}
+
+		txErr = tx.DeleteOutboxMessages(user.Email)
+		if txErr != nil {
+			return txErr
+		}
+
+		return s.queueEmail(tx, "account-deletion-success", user.Email, nil, now)
+	})
+}
+
+// tokenLimitReached reports whether a new email token for the user and purpose should be
+// dropped, because one was created during the cooldown or because the user already has the
+// maximum number of outstanding tokens. Callers drop the token without returning an error,
+// so that repeated requests neither flood the inbox of the user nor leak information.
+func (s *Service) tokenLimitReached(tx Tx, userID uuid.UUID, purpose TokenPurpose, now time.Time) (bool, error) {
+	if s.cfg.TokenCooldown > 0 {
+		count, err := tx.CountEmailTokens(EmailTokenFilter{
+			UserIDs:      []uuid.UUID{userID},
+			Purposes:     []TokenPurpose{purpose},
+			CreatedAfter: ptr(now.Add(-s.cfg.TokenCooldown)),
+		})
+		if err != nil {
+			return false, err
+		}
+
+		if count > 0 {
+			return true, nil
+		}
+	}
+
+	if s.cfg.MaxOutstandingTokens > 0 {
+		count, err := tx.CountEmailTokens(EmailTokenFilter{
+			UserIDs:      []uuid.UUID{userID},
+			Purposes:     []TokenPurpose{purpose},
+			IsConsumed:   ptr(false),
+			CreatedAfter: ptr(now.Add(-s.cfg.TokenExpiry)),
+		})
+		if err != nil {
+			return false, err
+		}
+
+		if count >= s.cfg.MaxOutstandingTokens {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// queueEmail renders an email and adds it to the outbox as part of tx.
+func (s *Service) queueEmail(tx Tx, template string, to email.Address, data any, now time.Time) error {
+	msg, err := s.emailRenderer.Render(template, to, data)
+	if err != nil {
+		return err
+	}
+
+	m, err := outbox.NewMessage(msg, now)
+	if err != nil {
+		return err
+	}
+
+	return tx.CreateOutboxMessage(m)
+}
+
+func findConsumableEmailToken(tx Tx, raw EmailTokenRaw, purpose TokenPurpose, now time.Time, maxAge time.Duration) (EmailToken, error) {
+	tokens, err := tx.FindEmailTokens(EmailTokenFilter{
+		IDs:        []uuid.UUID{raw.ID},
+		Purposes:   []TokenPurpose{purpose},
+		IsConsumed: ptr(false),
+	})
+	if err != nil {
+		return EmailToken{}, err
+	}
+
+	if len(tokens) != 1 {
+		return EmailToken{}, errorz.ErrNotFound
+	}
+
+	token := tokens[0]
+
+	if now.Sub(token.CreatedAt) > maxAge {
+		return EmailToken{}, errorz.ErrNotFound
+	}
+
+	// Check if the provided token matches the stored hash.
+	if !token.TokenHash.MatchBytes(raw.Token[:]) {
+		return EmailToken{}, errorz.ErrNotFound
+	}
+
+	return token, nil
+}
+
+// verifySecondFactor checks code against the confirmed TOTP credential and the unused
+// recovery codes of a user. The used code is recorded, so that it can't be used again.
+func verifySecondFactor(tx Tx, userID uuid.UUID, code string, now time.Time) (bool, error) {
+	creds, err := tx.FindTOTPs(TOTPFilter{
+		UserIDs:     []uuid.UUID{userID},
+		IsConfirmed: ptr(true),
+	})
+	if err != nil {
+		return false, err
+	}
+
+	if len(creds) != 1 {
+		return false, errorz.ErrNotFound
+	}
+
+	cred := creds[0]
+	step, ok := cred.Secret.MatchCode(code, now)
+	if ok {
+		if step <= cred.LastUsedStep {
+			return false, nil
+		}
+
+		cred.LastUsedStep = step
+		cred.UpdatedAt = now
+		return true, tx.UpdateTOTP(cred)
+	}
+
+	rawCode, err := krypto.ParseRecoveryCode(code)
+	if err != nil {
+		return false, nil
+	}
+
+	codes, err := tx.FindRecoveryCodes(RecoveryCodeFilter{
+		UserIDs: []uuid.UUID{userID},
+		IsUsed:  ptr(false),
+	})
+	if err != nil {
+		return false, err
+	}
+
+	for _, c := range codes {
+		if c.CodeHash.MatchBytes(rawCode[:]) {
+			c.UsedAt = ptr(now)
+			return true, tx.UpdateRecoveryCode(c)
+		}
+	}
+
+	return false, nil
+}
+
+func createAttempt(tx Tx, addr email.Address, purpose AttemptPurpose, now time.Time) error {
+	id, err := uuid.NewRandom()
+	if err != nil {
+		return err
+	}
+
+	return tx.CreateAttempt(Attempt{
+		ID:        id,
+		Email:     addr,
+		Purpose:   purpose,
+		CreatedAt: now,
+	})
+}
+
+// ensureEmailAvailable returns ErrDuplicateUser if any user, active or not, has the email address.
+func ensureEmailAvailable(tx Tx, addr email.Address) error {
+	users, err := tx.FindUsers(UserFilter{
+		Emails: []email.Address{addr},
+	})
+	if err != nil {
+		return err
+	}
+
+	if len(users) > 0 {
+		return ErrDuplicateUser
+	}
+
+	return nil
+}
+
+func findUser(tx Tx, filter UserFilter) (User, error) {
+	users, err := tx.FindUsers(filter)
+	if err != nil {
+		return User{}, err
+	}
+
+	if len(users) != 1 {
+		return User{}, errorz.ErrNotFound
+	}
+
+	return users[0], nil
+}
+
+func consumeAllTokensForUserID(tx Tx, userID uuid.UUID, purposes []TokenPurpose, consumedAt time.Time) error {
+	tokens, err := tx.FindEmailTokens(EmailTokenFilter{
+		UserIDs:    []uuid.UUID{userID},
+		Purposes:   purposes,
+		IsConsumed: ptr(false),
 	})
 	if err != nil {
 		return err
@@ -506,7 +1931,12 @@ func consumeAllTokensForUserID(tx Tx, userID uuid.UUID, purpose TokenPurpose, co
 }
 
 func (s *Service) inTx(ctx context.Context, f func(tx Tx) error) error {
-	tx, err := s.store.BeginTx(ctx)
+	return inTx(ctx, s.store, f)
+}
+
+// inTx runs f in a transaction of store, the transaction is rolled back if f returns an error.
+func inTx(ctx context.Context, store Store, f func(tx Tx) error) error {
+	tx, err := store.BeginTx(ctx)
 	if err != nil {
 		return err
 	}
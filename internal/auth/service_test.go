@@ -3,6 +3,10 @@ package auth_test
 import (
 	"context"
 	"errors"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
 	"sync"
 	"testing"
 	"time"
@@ -12,21 +16,30 @@ import (
 	"github.com/willemschots/househunt/internal/auth/db"
 	"github.com/willemschots/househunt/internal/db/testdb"
 	"github.com/willemschots/househunt/internal/email"
+	"github.com/willemschots/househunt/internal/email/outbox"
+	outboxdb "github.com/willemschots/househunt/internal/email/outbox/db"
 	"github.com/willemschots/househunt/internal/errorz"
 	"github.com/willemschots/househunt/internal/errorz/testerr"
 	"github.com/willemschots/househunt/internal/krypto"
+	"github.com/willemschots/househunt/internal/web/sessions"
+	sessionsdb "github.com/willemschots/househunt/internal/web/sessions/db"
+	"github.com/willemschots/househunt/internal/webauthn"
+	"github.com/willemschots/househunt/internal/webauthn/webauthntest"
 )
 
 func Test_Service_RegisterUser(t *testing.T) {
 	t.Run("ok, register user", func(t *testing.T) {
 		st := newServiceTest(t)
 
-		credentials := auth.Credentials{
-			Email:    must(email.ParseAddress("info@example.com")),
-			Password: must(auth.ParsePassword("reallyStrongPassword1")),
+		registration := auth.Registration{
+			Credentials: auth.Credentials{
+				Email:    must(email.ParseAddress("info@example.com")),
+				Password: must(auth.ParsePassword("reallyStrongPassword1")),
+			},
+			Role: auth.RoleAgent,
 		}
 
-		err := st.svc.RegisterUser(context.Background(), credentials)
+		err := st.svc.RegisterUser(context.Background(), registration)
 		if err != nil {
 			t.Fatalf("failed to register user: %v", err)
 		}
@@ -38,7 +51,7 @@ func Test_Service_RegisterUser(t *testing.T) {
 		st.errList.assertNoError(t)
 
 		// Assert that an email was send to the email address.
-		st.emailer.assertLastEmail(t, "user-activation", credentials.Email, func(t *testing.T, data any) {
+		st.emailer.assertLastEmail(t, "user-activation", registration.Email, func(t *testing.T, data any) {
 			req, ok := data.(auth.EmailTokenRaw)
 			if !ok {
 				t.Fatalf("unexpected data type: %T", data)
@@ -58,7 +71,10 @@ func Test_Service_RegisterUser(t *testing.T) {
 		st.emailer.clearEmails()
 
 		// Register again.
-		err := st.svc.RegisterUser(context.Background(), credentials)
+		err := st.svc.RegisterUser(context.Background(), auth.Registration{
+			Credentials: credentials,
+			Role:        auth.RoleAgent,
+		})
 		if err != nil {
 			t.Fatalf("failed to register user: %v", err)
 		}
@@ -84,6 +100,27 @@ func Test_Service_RegisterUser(t *testing.T) {
 		})
 	})
 
+	t.Run("ok, re-register keeps the role of the first registration", func(t *testing.T) {
+		st := newServiceTest(t)
+		credentials, _ := st.registerUser()
+
+		err := st.svc.RegisterUser(context.Background(), auth.Registration{
+			Credentials: credentials,
+			Role:        auth.RoleHunter,
+		})
+		if err != nil {
+			t.Fatalf("failed to register user: %v", err)
+		}
+
+		st.svc.Wait()
+		st.errList.assertNoError(t)
+
+		user := st.findUser(credentials.Email)
+		if user.Role != auth.RoleAgent {
+			t.Errorf("got role %s, want %s", user.Role, auth.RoleAgent)
+		}
+	})
+
 	t.Run("fail async, re-register active user", func(t *testing.T) {
 		st := newServiceTest(t)
 		credentials, activationTok := st.registerUser()
@@ -91,7 +128,10 @@ func Test_Service_RegisterUser(t *testing.T) {
 		st.emailer.clearEmails()
 
 		// Register again.
-		err := st.svc.RegisterUser(context.Background(), credentials)
+		err := st.svc.RegisterUser(context.Background(), auth.Registration{
+			Credentials: credentials,
+			Role:        auth.RoleAgent,
+		})
 		if err != nil {
 			t.Fatalf("unexpected error: %v", err)
 		}
@@ -103,19 +143,88 @@ func Test_Service_RegisterUser(t *testing.T) {
 		st.emailer.assertNoEmails(t)
 	})
 
-	// TODO: add case "fail async, too many registration requests"
+	t.Run("ok async, too many registration requests", func(t *testing.T) {
+		st := newServiceTest(t)
 
-	for _, tracker := range testerr.NewFailingDeps(testerr.Err, 5) {
+		// MaxOutstandingTokens is set to 3.
+		for i := 0; i < 3; i++ {
+			st.registerUser()
+		}
+		st.emailer.clearEmails()
+
+		err := st.svc.RegisterUser(context.Background(), auth.Registration{
+			Credentials: auth.Credentials{
+				Email:    must(email.ParseAddress("info@example.com")),
+				Password: must(auth.ParsePassword("reallyStrongPassword1")),
+			},
+			Role: auth.RoleAgent,
+		})
+		if err != nil {
+			t.Fatalf("failed to register user: %v", err)
+		}
+
+		// The request is silently dropped.
+		st.svc.Wait()
+		st.errList.assertNoError(t)
+		st.emailer.assertNoEmails(t)
+	})
+
+	for _, role := range []auth.Role{"", auth.RoleAdmin} {
+		t.Run("fail sync, role can't be registered", func(t *testing.T) {
+			st := newServiceTest(t)
+
+			registration := auth.Registration{
+				Credentials: auth.Credentials{
+					Email:    must(email.ParseAddress("info@example.com")),
+					Password: must(auth.ParsePassword("reallyStrongPassword1")),
+				},
+				Role: role,
+			}
+
+			err := st.svc.RegisterUser(context.Background(), registration)
+			if !errors.Is(err, auth.ErrInvalidRole) {
+				t.Fatalf("expected error %v, got %v (via errors.Is)", auth.ErrInvalidRole, err)
+			}
+
+			st.svc.Wait()
+			st.errList.assertNoError(t)
+			st.emailer.assertNoEmails(t)
+		})
+	}
+
+	t.Run("fail sync, password rejected by policy", func(t *testing.T) {
+		st := newServiceTest(t, withPasswordPolicy)
+
+		registration := auth.Registration{
+			Credentials: auth.Credentials{
+				Email:    must(email.ParseAddress("info@example.com")),
+				Password: must(auth.ParsePassword("myInfoPassword")),
+			},
+			Role: auth.RoleAgent,
+		}
+
+		err := st.svc.RegisterUser(context.Background(), registration)
+		assertPasswordRejected(t, err, "password", auth.ErrPasswordContainsEmail)
+
+		st.svc.Wait()
+		st.errList.assertNoError(t)
+		st.emailer.assertNoEmails(t)
+	})
+
+	for _, tracker := range testerr.NewFailingDeps(testerr.Err, 6) {
 		t.Run("fail async, store fails", func(t *testing.T) {
 			st := newServiceTest(t)
 			st.store.tracker = &tracker
 
-			credentials := auth.Credentials{
-				Email:    must(email.ParseAddress("info@example.com")),
-				Password: must(auth.ParsePassword("reallyStrongPassword1")),
+			registration := auth.Registration{
+				Credentials: auth.Credentials{
+					Email:    must(email.ParseAddress("info@example.com")),
+					Password: must(auth.ParsePassword("reallyStrongPassword1")),
+				},
+				Role: auth.RoleAgent,
 			}
 
-			err := st.svc.RegisterUser(context.Background(), credentials)
+			err := st.svc.RegisterUser(context.Background(), registration)
 			if err != nil {
 				t.Fatalf("failed to register user: %v", err)
 			}
@@ -131,12 +240,15 @@ func Test_Service_RegisterUser(t *testing.T) {
 		st := newServiceTest(t)
 		st.emailer.testErr = testerr.Err
 
-		credentials := auth.Credentials{
-			Email:    must(email.ParseAddress("info@example.com")),
-			Password: must(auth.ParsePassword("reallyStrongPassword1")),
+		registration := auth.Registration{
+			Credentials: auth.Credentials{
+				Email:    must(email.ParseAddress("info@example.com")),
+				Password: must(auth.ParsePassword("reallyStrongPassword1")),
+			},
+			Role: auth.RoleAgent,
 		}
 
-		err := st.svc.RegisterUser(context.Background(), credentials)
+		err := st.svc.RegisterUser(context.Background(), registration)
 		if err != nil {
 			t.Fatalf("failed to register user: %v", err)
 		}
@@ -144,6 +256,7 @@ func Test_Service_RegisterUser(t *testing.T) {
 		// Wait for service goroutine to finish registering.
 		st.svc.Wait()
 		st.errList.assertErrorIs(t, testerr.Err)
+		st.emailer.assertNoEmails(t)
 	})
 }
 
@@ -375,23 +488,238 @@ func Test_Service_Authenticate(t *testing.T) {
 		st.errList.assertNoError(t)
 	})
 
-	t.Run("fail, store fails", func(t *testing.T) {
+	t.Run("fail, locked out after too many failed logins", func(t *testing.T) {
 		st := newServiceTest(t)
 		credentials, tok := st.registerUser()
 		st.activateUser(tok)
 
-		failingDeps := testerr.NewFailingDeps(testerr.Err, 1)
-		st.store.tracker = &failingDeps[0]
+		wrong := credentials
+		wrong.Password = must(auth.ParsePassword("wrongPassword"))
+
+		// MaxLoginFailures is set to 3.
+		for i := 0; i < 3; i++ {
+			if st.authenticate(wrong) {
+				t.Fatalf("expected authentication to fail")
+			}
+		}
 
+		// Even the right credentials are refused now.
 		_, err := st.svc.Authenticate(context.Background(), credentials)
-		if !errors.Is(err, testerr.Err) {
-			t.Fatalf("expected error %v, got %v (via errors.Is)", testerr.Err, err)
+		if !errors.Is(err, errorz.ErrRateLimited) {
+			t.Fatalf("expected error %v, got %v (via errors.Is)", errorz.ErrRateLimited, err)
+		}
+
+		// LoginLockout is set to 1 hour.
+		// Simulate the current time being an hour ahead.
+		st.svc.NowFunc = func() time.Time {
+			return time.Now().Add(time.Hour + time.Second)
+		}
+
+		if !st.authenticate(credentials) {
+			t.Fatalf("expected authentication to succeed after the lockout")
 		}
 
 		// assert no async errors were reported.
 		st.svc.Wait()
 		st.errList.assertNoError(t)
 	})
+
+	t.Run("fail, locked out non-existant user", func(t *testing.T) {
+		st := newServiceTest(t)
+
+		credentials := auth.Credentials{
+			Email:    must(email.ParseAddress("jacob@example.com")),
+			Password: must(auth.ParsePassword("reallyStrongPassword1")),
+		}
+
+		for i := 0; i < 3; i++ {
+			if st.authenticate(credentials) {
+				t.Fatalf("expected authentication to fail")
+			}
+		}
+
+		// Unknown users are locked out the same way, to prevent user enumeration.
+		_, err := st.svc.Authenticate(context.Background(), credentials)
+		if !errors.Is(err, errorz.ErrRateLimited) {
+			t.Fatalf("expected error %v, got %v (via errors.Is)", errorz.ErrRateLimited, err)
+		}
+	})
+
+	t.Run("ok, successful login resets failed logins", func(t *testing.T) {
+		st := newServiceTest(t)
+		credentials, tok := st.registerUser()
+		st.activateUser(tok)
+
+		wrong := credentials
+		wrong.Password = must(auth.ParsePassword("wrongPassword"))
+
+		for i := 0; i < 2; i++ {
+			for j := 0; j < 2; j++ {
+				if st.authenticate(wrong) {
+					t.Fatalf("expected authentication to fail")
+				}
+			}
+
+			if !st.authenticate(credentials) {
+				t.Fatalf("expected authentication to succeed")
+			}
+		}
+	})
+
+	t.Run("ok, outdated password hash is upgraded", func(t *testing.T) {
+		st := newServiceTest(t, func(cfg *auth.ServiceConfig) {
+			cfg.PasswordHashing = oldPasswordHashing
+		})
+		credentials, tok := st.registerUser()
+		st.activateUser(tok)
+
+		st.useDefaultPasswordHashing()
+
+		user, err := st.svc.Authenticate(context.Background(), credentials)
+		if err != nil {
+			t.Fatalf("failed to authenticate: %v", err)
+		}
+
+		if user.PasswordHash.Params() != krypto.DefaultArgon2Params() {
+			t.Fatalf("expected password hash with default parameters, got %+v", user.PasswordHash.Params())
+		}
+
+		stored := st.findUser(credentials.Email)
+		if stored.PasswordHash.String() != user.PasswordHash.String() {
+			t.Fatalf("expected upgraded hash to be stored, got %s", stored.PasswordHash)
+		}
+
+		// The password still works with the upgraded hash.
+		if !st.authenticate(credentials) {
+			t.Fatalf("expected authentication to succeed")
+		}
+	})
+
+	// CountAttempts, FindUsers, BeginTx, FindUsers, UpdateUser and Commit.
+	for _, tracker := range testerr.NewFailingDeps(testerr.Err, 6) {
+		t.Run("fail, store fails upgrading password hash", func(t *testing.T) {
+			st := newServiceTest(t, func(cfg *auth.ServiceConfig) {
+				cfg.PasswordHashing = oldPasswordHashing
+			})
+			credentials, tok := st.registerUser()
+			st.activateUser(tok)
+
+			st.useDefaultPasswordHashing()
+			st.store.tracker = &tracker
+
+			_, err := st.svc.Authenticate(context.Background(), credentials)
+			if !errors.Is(err, testerr.Err) {
+				t.Fatalf("expected error %v, got %v (via errors.Is)", testerr.Err, err)
+			}
+
+			// The old hash is kept.
+			st.store.tracker = &testerr.Calltracker{}
+			stored := st.findUser(credentials.Email)
+			if stored.PasswordHash.Params() != oldPasswordHashing {
+				t.Fatalf("expected old password hash to be kept, got %+v", stored.PasswordHash.Params())
+			}
+		})
+	}
+
+	for _, tracker := range testerr.NewFailingDeps(testerr.Err, 2) {
+		t.Run("fail, store fails", func(t *testing.T) {
+			st := newServiceTest(t)
+			credentials, tok := st.registerUser()
+			st.activateUser(tok)
+
+			st.store.tracker = &tracker
+
+			_, err := st.svc.Authenticate(context.Background(), credentials)
+			if !errors.Is(err, testerr.Err) {
+				t.Fatalf("expected error %v, got %v (via errors.Is)", testerr.Err, err)
+			}
+
+			// assert no async errors were reported.
+			st.svc.Wait()
+			st.errList.assertNoError(t)
+		})
+	}
+
+	for _, tracker := range testerr.NewFailingDeps(testerr.Err, 5) {
+		t.Run("fail, store fails recording failed login", func(t *testing.T) {
+			st := newServiceTest(t)
+			credentials, tok := st.registerUser()
+			st.activateUser(tok)
+
+			credentials.Password = must(auth.ParsePassword("wrongPassword"))
+
+			st.store.tracker = &tracker
+
+			_, err := st.svc.Authenticate(context.Background(), credentials)
+			if !errors.Is(err, testerr.Err) {
+				t.Fatalf("expected error %v, got %v (via errors.Is)", testerr.Err, err)
+			}
+		})
+	}
+
+	for _, tracker := range testerr.NewFailingDeps(testerr.Err, 5) {
+		t.Run("fail, store fails resetting failed logins", func(t *testing.T) {
+			st := newServiceTest(t)
+			credentials, tok := st.registerUser()
+			st.activateUser(tok)
+
+			wrong := credentials
+			wrong.Password = must(auth.ParsePassword("wrongPassword"))
+			if st.authenticate(wrong) {
+				t.Fatalf("expected authentication to fail")
+			}
+
+			st.store.tracker = &tracker
+
+			_, err := st.svc.Authenticate(context.Background(), credentials)
+			if !errors.Is(err, testerr.Err) {
+				t.Fatalf("expected error %v, got %v (via errors.Is)", testerr.Err, err)
+			}
+		})
+	}
+}
+
+func Test_Service_ActiveUser(t *testing.T) {
+	t.Run("ok, active user", func(t *testing.T) {
+		st := newServiceTest(t)
+		credentials, tok := st.registerUser()
+		st.activateUser(tok)
+
+		authenticated, err := st.svc.Authenticate(context.Background(), credentials)
+		if err != nil {
+			t.Fatalf("failed to authenticate: %v", err)
+		}
+
+		user, err := st.svc.ActiveUser(context.Background(), authenticated.ID)
+		if err != nil {
+			t.Fatalf("failed to find user: %v", err)
+		}
+
+		if user.ID != authenticated.ID || user.Email != credentials.Email || user.Role != auth.RoleAgent {
+			t.Fatalf("unexpected user: %v", user)
+		}
+	})
+
+	t.Run("fail, non-existant user", func(t *testing.T) {
+		st := newServiceTest(t)
+
+		_, err := st.svc.ActiveUser(context.Background(), must(uuid.Parse("597228ee-afde-4991-b13c-0161325e3930")))
+		if !errors.Is(err, errorz.ErrNotFound) {
+			t.Fatalf("expected error %v, got %v (via errors.Is)", errorz.ErrNotFound, err)
+		}
+	})
+
+	t.Run("fail, store fails", func(t *testing.T) {
+		st := newServiceTest(t)
+
+		failingDeps := testerr.NewFailingDeps(testerr.Err, 1)
+		st.store.tracker = &failingDeps[0]
+
+		_, err := st.svc.ActiveUser(context.Background(), must(uuid.Parse("597228ee-afde-4991-b13c-0161325e3930")))
+		if !errors.Is(err, testerr.Err) {
+			t.Fatalf("expected error %v, got %v (via errors.Is)", testerr.Err, err)
+		}
+	})
 }
 
 func Test_Service_RequestPasswordReset(t *testing.T) {
@@ -451,9 +779,27 @@ func Test_Service_RequestPasswordReset(t *testing.T) {
 		st.emailer.assertNoEmails(t)
 	})
 
-	// TODO: add case "fail async, too many reset password requests"
+	t.Run("fail async, too many reset password requests", func(t *testing.T) {
+		st := newServiceTest(t)
+		credentials, aTok := st.registerUser()
+		st.activateUser(aTok)
 
-	for _, tracker := range testerr.NewFailingDeps(testerr.Err, 4) {
+		// MaxPasswordResets is set to 2.
+		st.requestPasswordReset(credentials.Email)
+		st.requestPasswordReset(credentials.Email)
+		st.emailer.clearEmails()
+
+		st.svc.RequestPasswordReset(context.Background(), credentials.Email)
+
+		// Wait for service goroutine to finish.
+		st.svc.Wait()
+		st.errList.assertErrorIs(t, errorz.ErrRateLimited)
+		st.emailer.assertNoEmails(t)
+	})
+
+	// BeginTx, CountAttempts, CreateAttempt, FindUsers, CountEmailTokens,
+	// CreateEmailToken, CreateOutboxMessage and Commit.
+	for _, tracker := range testerr.NewFailingDeps(testerr.Err, 8) {
 		t.Run("fail async, store fails", func(t *testing.T) {
 			st := newServiceTest(t)
 			credentials, aTok := st.registerUser()
@@ -483,6 +829,7 @@ func Test_Service_RequestPasswordReset(t *testing.T) {
 		// Wait for service goroutine to finish registering.
 		st.svc.Wait()
 		st.errList.assertErrorIs(t, testerr.Err)
+		st.emailer.assertNoEmails(t)
 	})
 }
 
@@ -494,6 +841,9 @@ func Test_Service_ResetPassword(t *testing.T) {
 		resetTok := st.requestPasswordReset(oldCreds.Email)
 		st.emailer.clearEmails()
 
+		// Log in on another device.
+		st.createSession(oldCreds.Email)
+
 		// Reset the password by providing the reset token and a new password.
 		newPass := auth.NewPassword{
 			Password: must(auth.ParsePassword("otherPassword")),
@@ -509,6 +859,9 @@ func Test_Service_ResetPassword(t *testing.T) {
 		st.errList.assertNoError(t)
 		st.emailer.assertLastEmail(t, "password-reset-success", oldCreds.Email, nil)
 
+		// Check that the other device was logged out.
+		st.assertNoSessions(oldCreds.Email)
+
 		// Check that the old password no longer works.
 		if st.authenticate(oldCreds) {
 			t.Fatalf("expected authentication to fail")
@@ -524,6 +877,33 @@ func Test_Service_ResetPassword(t *testing.T) {
 		}
 	})
 
+	t.Run("fail, password rejected by policy", func(t *testing.T) {
+		st := newServiceTest(t, withPasswordPolicy)
+		oldCreds, aTok := st.registerUser()
+		st.activateUser(aTok)
+		resetTok := st.requestPasswordReset(oldCreds.Email)
+		st.emailer.clearEmails()
+
+		err := st.svc.ResetPassword(context.Background(), auth.NewPassword{
+			Password: must(auth.ParsePassword("password123")),
+			RawToken: resetTok,
+		})
+		assertPasswordRejected(t, err, "password", auth.ErrCommonPassword)
+
+		st.svc.Wait()
+		st.errList.assertNoError(t)
+		st.emailer.assertNoEmails(t)
+
+		// The token can still be used with another password.
+		err = st.svc.ResetPassword(context.Background(), auth.NewPassword{
+			Password: must(auth.ParsePassword("otherPassword")),
+			RawToken: resetTok,
+		})
+		if err != nil {
+			t.Fatalf("failed to reset password: %v", err)
+		}
+	})
+
 	t.Run("fail, non-matching token", func(t *testing.T) {
 		st := newServiceTest(t)
 		oldCreds, aTok := st.registerUser()
@@ -687,7 +1067,7 @@ func Test_Service_ResetPassword(t *testing.T) {
 		st.emailer.assertNoEmails(t)
 	})
 
-	for _, tracker := range testerr.NewFailingDeps(testerr.Err, 7) {
+	for _, tracker := range testerr.NewFailingDeps(testerr.Err, 9) {
 		t.Run("fail, store fails", func(t *testing.T) {
 			st := newServiceTest(t)
 			oldCreds, aTok := st.registerUser()
@@ -729,125 +1109,2144 @@ func Test_Service_ResetPassword(t *testing.T) {
 			RawToken: resetTok,
 		}
 		err := st.svc.ResetPassword(context.Background(), newPass)
-		if err != nil {
-			t.Fatalf("failed to reset password: %v", err)
+		if !errors.Is(err, testerr.Err) {
+			t.Fatalf("expected error %v, got %v (via errors.Is)", testerr.Err, err)
 		}
 
-		// Wait for service goroutine to finish.
 		st.svc.Wait()
-		st.errList.assertErrorIs(t, testerr.Err)
-	})
-}
-
+		st.errList.assertNoError(t)
+		st.emailer.assertNoEmails(t)
+
+		// The password should not have been changed.
+		if !st.authenticate(oldCreds) {
+			t.Fatalf("expected authentication to succeed")
+		}
+	})
+}
+
+func Test_Service_ChangePassword(t *testing.T) {
+	newPassword := must(auth.ParsePassword("otherPassword"))
+
+	t.Run("ok, change password", func(t *testing.T) {
+		st := newServiceTest(t)
+		oldCreds, aTok := st.registerUser()
+		st.activateUser(aTok)
+		resetTok := st.requestPasswordReset(oldCreds.Email)
+		user := st.findUser(oldCreds.Email)
+		st.emailer.clearEmails()
+
+		// Log in on another device.
+		st.createSession(oldCreds.Email)
+
+		err := st.svc.ChangePassword(context.Background(), user.ID, oldCreds.Password, newPassword)
+		if err != nil {
+			t.Fatalf("failed to change password: %v", err)
+		}
+
+		st.svc.Wait()
+		st.errList.assertNoError(t)
+		st.emailer.assertLastEmail(t, "password-change-success", oldCreds.Email, nil)
+
+		// Check that the other device was logged out.
+		st.assertNoSessions(oldCreds.Email)
+
+		// Check that the old password no longer works.
+		if st.authenticate(oldCreds) {
+			t.Fatalf("expected authentication to fail")
+		}
+
+		// Check that the new password works.
+		newCreds := auth.Credentials{
+			Email:    oldCreds.Email,
+			Password: newPassword,
+		}
+		if !st.authenticate(newCreds) {
+			t.Fatalf("expected authentication to succeed")
+		}
+
+		// Check that the outstanding reset token can no longer be used.
+		err = st.svc.ResetPassword(context.Background(), auth.NewPassword{
+			Password: must(auth.ParsePassword("yetAnotherPassword")),
+			RawToken: resetTok,
+		})
+		if !errors.Is(err, errorz.ErrNotFound) {
+			t.Fatalf("expected error %v, got %v (via errors.Is)", errorz.ErrNotFound, err)
+		}
+	})
+
+	t.Run("fail, wrong current password", func(t *testing.T) {
+		st := newServiceTest(t)
+		oldCreds, aTok := st.registerUser()
+		st.activateUser(aTok)
+		user := st.findUser(oldCreds.Email)
+		st.emailer.clearEmails()
+
+		wrong := must(auth.ParsePassword("wrongPassword"))
+		err := st.svc.ChangePassword(context.Background(), user.ID, wrong, newPassword)
+
+		var invalidInput errorz.InvalidInput
+		if !errors.As(err, &invalidInput) || !errors.Is(invalidInput, auth.ErrInvalidCredentials) {
+			t.Fatalf("expected invalid input with %v, got %v", auth.ErrInvalidCredentials, err)
+		}
+
+		st.svc.Wait()
+		st.errList.assertNoError(t)
+		st.emailer.assertNoEmails(t)
+
+		// The password should not have been changed.
+		if !st.authenticate(oldCreds) {
+			t.Fatalf("expected authentication to succeed")
+		}
+	})
+
+	t.Run("fail, password rejected by policy", func(t *testing.T) {
+		st := newServiceTest(t, withPasswordPolicy)
+		oldCreds, aTok := st.registerUser()
+		st.activateUser(aTok)
+		user := st.findUser(oldCreds.Email)
+		st.emailer.clearEmails()
+
+		err := st.svc.ChangePassword(context.Background(), user.ID, oldCreds.Password, must(auth.ParsePassword("password123")))
+		assertPasswordRejected(t, err, "new", auth.ErrCommonPassword)
+
+		st.svc.Wait()
+		st.errList.assertNoError(t)
+		st.emailer.assertNoEmails(t)
+
+		// The password should not have been changed.
+		if !st.authenticate(oldCreds) {
+			t.Fatalf("expected authentication to succeed")
+		}
+	})
+
+	t.Run("fail, locked out after too many wrong passwords", func(t *testing.T) {
+		st := newServiceTest(t)
+		oldCreds, aTok := st.registerUser()
+		st.activateUser(aTok)
+		user := st.findUser(oldCreds.Email)
+
+		wrong := must(auth.ParsePassword("wrongPassword"))
+
+		// MaxLoginFailures is set to 3.
+		for i := 0; i < 3; i++ {
+			err := st.svc.ChangePassword(context.Background(), user.ID, wrong, newPassword)
+			if !errors.Is(err, auth.ErrInvalidCredentials) {
+				t.Fatalf("expected error %v, got %v (via errors.Is)", auth.ErrInvalidCredentials, err)
+			}
+		}
+
+		// Even the right password is refused now, for changing the password and for logging in.
+		err := st.svc.ChangePassword(context.Background(), user.ID, oldCreds.Password, newPassword)
+		if !errors.Is(err, errorz.ErrRateLimited) {
+			t.Fatalf("expected error %v, got %v (via errors.Is)", errorz.ErrRateLimited, err)
+		}
+
+		_, err = st.svc.Authenticate(context.Background(), oldCreds)
+		if !errors.Is(err, errorz.ErrRateLimited) {
+			t.Fatalf("expected error %v, got %v (via errors.Is)", errorz.ErrRateLimited, err)
+		}
+	})
+
+	t.Run("fail, user not found", func(t *testing.T) {
+		st := newServiceTest(t)
+
+		err := st.svc.ChangePassword(context.Background(), must(uuid.Parse("597228ee-afde-4991-b13c-0161325e3930")), newPassword, newPassword)
+		if !errors.Is(err, errorz.ErrNotFound) {
+			t.Fatalf("expected error %v, got %v (via errors.Is)", errorz.ErrNotFound, err)
+		}
+	})
+
+	// FindUsers, CountAttempts, BeginTx, FindUsers, UpdateUser, FindEmailTokens,
+	// RevokeSessions, CreateOutboxMessage and Commit.
+	for _, tracker := range testerr.NewFailingDeps(testerr.Err, 9) {
+		t.Run("fail, store fails", func(t *testing.T) {
+			st := newServiceTest(t)
+			oldCreds, aTok := st.registerUser()
+			st.activateUser(aTok)
+			user := st.findUser(oldCreds.Email)
+			st.emailer.clearEmails()
+
+			st.store.tracker = &tracker
+
+			err := st.svc.ChangePassword(context.Background(), user.ID, oldCreds.Password, newPassword)
+			if !errors.Is(err, testerr.Err) {
+				t.Fatalf("expected error %v, got %v (via errors.Is)", testerr.Err, err)
+			}
+
+			st.svc.Wait()
+			st.errList.assertNoError(t)
+			st.emailer.assertNoEmails(t)
+		})
+	}
+
+	t.Run("fail, emailer fails", func(t *testing.T) {
+		st := newServiceTest(t)
+		oldCreds, aTok := st.registerUser()
+		st.activateUser(aTok)
+		user := st.findUser(oldCreds.Email)
+		st.emailer.clearEmails()
+
+		st.emailer.testErr = testerr.Err
+
+		err := st.svc.ChangePassword(context.Background(), user.ID, oldCreds.Password, newPassword)
+		if !errors.Is(err, testerr.Err) {
+			t.Fatalf("expected error %v, got %v (via errors.Is)", testerr.Err, err)
+		}
+
+		st.svc.Wait()
+		st.errList.assertNoError(t)
+		st.emailer.assertNoEmails(t)
+
+		// The password should not have been changed.
+		if !st.authenticate(oldCreds) {
+			t.Fatalf("expected authentication to succeed")
+		}
+	})
+}
+
+func Test_Service_RequestEmailChange(t *testing.T) {
+	newAddr := must(email.ParseAddress("new@example.com"))
+
+	t.Run("ok, request change", func(t *testing.T) {
+		st := newServiceTest(t)
+		user := st.registerAndActivateUser()
+		st.emailer.clearEmails()
+
+		err := st.svc.RequestEmailChange(context.Background(), user.ID, newAddr)
+		if err != nil {
+			t.Fatalf("failed to request email change: %v", err)
+		}
+
+		// Wait for service goroutine to finish.
+		st.svc.Wait()
+		st.errList.assertNoError(t)
+
+		st.emailer.assertLastEmail(t, "email-change-request", newAddr, func(t *testing.T, data any) {
+			tok, ok := data.(auth.EmailTokenRaw)
+			if !ok {
+				t.Fatalf("unexpected data type: %T", data)
+			}
+			if tok.ID == uuid.Nil {
+				t.Fatalf("expected ID to be set")
+			}
+		})
+
+		// The address is only changed after confirmation.
+		st.findUser(user.Email)
+	})
+
+	t.Run("fail, unchanged address", func(t *testing.T) {
+		st := newServiceTest(t)
+		user := st.registerAndActivateUser()
+		st.emailer.clearEmails()
+
+		err := st.svc.RequestEmailChange(context.Background(), user.ID, user.Email)
+
+		var invalidInput errorz.InvalidInput
+		if !errors.As(err, &invalidInput) || !errors.Is(invalidInput, auth.ErrEmailUnchanged) {
+			t.Fatalf("expected invalid input with %v, got %v", auth.ErrEmailUnchanged, err)
+		}
+
+		st.svc.Wait()
+		st.errList.assertNoError(t)
+		st.emailer.assertNoEmails(t)
+	})
+
+	t.Run("fail, user not found", func(t *testing.T) {
+		st := newServiceTest(t)
+
+		err := st.svc.RequestEmailChange(context.Background(), must(uuid.Parse("597228ee-afde-4991-b13c-0161325e3930")), newAddr)
+		if !errors.Is(err, errorz.ErrNotFound) {
+			t.Fatalf("expected error %v, got %v (via errors.Is)", errorz.ErrNotFound, err)
+		}
+	})
+
+	t.Run("fail async, address in use", func(t *testing.T) {
+		st := newServiceTest(t)
+		user := st.registerAndActivateUser()
+		st.registerOtherUser(newAddr)
+		st.emailer.clearEmails()
+
+		err := st.svc.RequestEmailChange(context.Background(), user.ID, newAddr)
+		if err != nil {
+			t.Fatalf("failed to request email change: %v", err)
+		}
+
+		// Wait for service goroutine to finish.
+		st.svc.Wait()
+		st.errList.assertErrorIs(t, auth.ErrDuplicateUser)
+		st.emailer.assertNoEmails(t)
+	})
+
+	// FindUsers, then async: BeginTx, FindUsers, FindUsers, CountEmailTokens, CreateEmailToken,
+	// CreateOutboxMessage and Commit.
+	for _, tracker := range testerr.NewFailingDeps(testerr.Err, 8) {
+		t.Run("fail, store fails", func(t *testing.T) {
+			st := newServiceTest(t)
+			user := st.registerAndActivateUser()
+			st.emailer.clearEmails()
+
+			st.store.tracker = &tracker
+
+			err := st.svc.RequestEmailChange(context.Background(), user.ID, newAddr)
+			st.svc.Wait()
+
+			if err != nil {
+				if !errors.Is(err, testerr.Err) {
+					t.Fatalf("expected error %v, got %v (via errors.Is)", testerr.Err, err)
+				}
+				st.errList.assertNoError(t)
+			} else {
+				st.errList.assertErrorIs(t, testerr.Err)
+			}
+
+			st.emailer.assertNoEmails(t)
+		})
+	}
+
+	t.Run("fail async, emailer fails", func(t *testing.T) {
+		st := newServiceTest(t)
+		user := st.registerAndActivateUser()
+		st.emailer.clearEmails()
+		st.emailer.testErr = testerr.Err
+
+		err := st.svc.RequestEmailChange(context.Background(), user.ID, newAddr)
+		if err != nil {
+			t.Fatalf("failed to request email change: %v", err)
+		}
+
+		st.svc.Wait()
+		st.errList.assertErrorIs(t, testerr.Err)
+		st.emailer.assertNoEmails(t)
+	})
+}
+
+func Test_Service_ConfirmEmailChange(t *testing.T) {
+	newAddr := must(email.ParseAddress("new@example.com"))
+
+	t.Run("ok, change email", func(t *testing.T) {
+		st := newServiceTest(t)
+		user := st.registerAndActivateUser()
+		tok := st.requestEmailChange(user.ID, newAddr)
+		st.emailer.clearEmails()
+
+		err := st.svc.ConfirmEmailChange(context.Background(), tok)
+		if err != nil {
+			t.Fatalf("failed to confirm email change: %v", err)
+		}
+
+		st.svc.Wait()
+		st.errList.assertNoError(t)
+
+		// The previous address is notified.
+		st.emailer.assertLastEmail(t, "email-change-notice", user.Email, nil)
+
+		// The user can login with the new address, and not with the old one.
+		got := st.findUser(newAddr)
+		if got.ID != user.ID {
+			t.Fatalf("expected user %s, got %s", user.ID, got.ID)
+		}
+
+		password := must(auth.ParsePassword("reallyStrongPassword1"))
+		if !st.authenticate(auth.Credentials{Email: newAddr, Password: password}) {
+			t.Fatalf("expected authentication with the new address to succeed")
+		}
+
+		if st.authenticate(auth.Credentials{Email: user.Email, Password: password}) {
+			t.Fatalf("expected authentication with the old address to fail")
+		}
+
+		// The token can't be used again.
+		err = st.svc.ConfirmEmailChange(context.Background(), tok)
+		if !errors.Is(err, errorz.ErrNotFound) {
+			t.Fatalf("expected error %v, got %v (via errors.Is)", errorz.ErrNotFound, err)
+		}
+	})
+
+	t.Run("ok, tokens sent to the previous address are consumed", func(t *testing.T) {
+		st := newServiceTest(t)
+		user := st.registerAndActivateUser()
+		resetTok := st.requestPasswordReset(user.Email)
+		deletionTok := st.requestAccountDeletion(user.ID)
+		tok := st.requestEmailChange(user.ID, newAddr)
+
+		err := st.svc.ConfirmEmailChange(context.Background(), tok)
+		if err != nil {
+			t.Fatalf("failed to confirm email change: %v", err)
+		}
+
+		st.svc.Wait()
+		st.errList.assertNoError(t)
+
+		err = st.svc.ResetPassword(context.Background(), auth.NewPassword{
+			Password: must(auth.ParsePassword("otherPassword1")),
+			RawToken: resetTok,
+		})
+		if !errors.Is(err, errorz.ErrNotFound) {
+			t.Fatalf("expected error %v, got %v (via errors.Is)", errorz.ErrNotFound, err)
+		}
+
+		err = st.svc.DeleteAccount(context.Background(), deletionTok)
+		if !errors.Is(err, errorz.ErrNotFound) {
+			t.Fatalf("expected error %v, got %v (via errors.Is)", errorz.ErrNotFound, err)
+		}
+
+		// The user was not deleted.
+		st.findUser(newAddr)
+	})
+
+	t.Run("fail, non-matching token", func(t *testing.T) {
+		st := newServiceTest(t)
+		user := st.registerAndActivateUser()
+		tok := st.requestEmailChange(user.ID, newAddr)
+		st.emailer.clearEmails()
+
+		tok.Token = must(krypto.ParseToken("0102030405060708091011121314151617181920212223242526272829303132"))
+
+		err := st.svc.ConfirmEmailChange(context.Background(), tok)
+		if !errors.Is(err, errorz.ErrNotFound) {
+			t.Fatalf("expected error %v, got %v (via errors.Is)", errorz.ErrNotFound, err)
+		}
+
+		st.emailer.assertNoEmails(t)
+		st.findUser(user.Email)
+	})
+
+	t.Run("fail, token for different purpose", func(t *testing.T) {
+		st := newServiceTest(t)
+		user := st.registerAndActivateUser()
+		tok := st.requestPasswordReset(user.Email)
+		st.emailer.clearEmails()
+
+		err := st.svc.ConfirmEmailChange(context.Background(), tok)
+		if !errors.Is(err, errorz.ErrNotFound) {
+			t.Fatalf("expected error %v, got %v (via errors.Is)", errorz.ErrNotFound, err)
+		}
+
+		st.emailer.assertNoEmails(t)
+	})
+
+	t.Run("fail, address taken in the meantime", func(t *testing.T) {
+		st := newServiceTest(t)
+		user := st.registerAndActivateUser()
+		tok := st.requestEmailChange(user.ID, newAddr)
+		st.registerOtherUser(newAddr)
+		st.emailer.clearEmails()
+
+		err := st.svc.ConfirmEmailChange(context.Background(), tok)
+
+		var invalidInput errorz.InvalidInput
+		if !errors.As(err, &invalidInput) || !errors.Is(invalidInput, auth.ErrDuplicateUser) {
+			t.Fatalf("expected invalid input with %v, got %v", auth.ErrDuplicateUser, err)
+		}
+
+		st.emailer.assertNoEmails(t)
+		st.findUser(user.Email)
+	})
+
+	// BeginTx, FindEmailTokens, FindUsers, FindUsers, UpdateUser, FindEmailTokens,
+	// UpdateEmailToken, CreateOutboxMessage and Commit.
+	for _, tracker := range testerr.NewFailingDeps(testerr.Err, 9) {
+		t.Run("fail, store fails", func(t *testing.T) {
+			st := newServiceTest(t)
+			user := st.registerAndActivateUser()
+			tok := st.requestEmailChange(user.ID, newAddr)
+			st.emailer.clearEmails()
+
+			st.store.tracker = &tracker
+
+			err := st.svc.ConfirmEmailChange(context.Background(), tok)
+			if !errors.Is(err, testerr.Err) {
+				t.Fatalf("expected error %v, got %v (via errors.Is)", testerr.Err, err)
+			}
+
+			st.store.tracker = &testerr.Calltracker{}
+			st.emailer.assertNoEmails(t)
+			st.findUser(user.Email)
+		})
+	}
+}
+
+func Test_Service_Export(t *testing.T) {
+	t.Run("ok, export", func(t *testing.T) {
+		st := newServiceTest(t)
+		user := st.registerAndActivateUser()
+		_, codes := st.enableTOTP(user.ID)
+		_, passkey := st.registerPasskey(user.ID)
+		apiToken, _ := st.createAPIToken(user.ID)
+		st.requestPasswordReset(user.Email)
+
+		err := st.svc.RecordEmailBounce(context.Background(), user.Email, auth.BounceKindSpamComplaint, testNow)
+		if err != nil {
+			t.Fatalf("failed to record email bounce: %v", err)
+		}
+
+		exp, err := st.svc.Export(context.Background(), user.ID)
+		if err != nil {
+			t.Fatalf("failed to export: %v", err)
+		}
+
+		wantUser := auth.UserExport{
+			ID:        user.ID,
+			Email:     user.Email,
+			Role:      user.Role,
+			IsActive:  true,
+			CreatedAt: user.CreatedAt,
+			UpdatedAt: user.UpdatedAt,
+		}
+		if exp.User != wantUser {
+			t.Errorf("expected user %+v, got %+v", wantUser, exp.User)
+		}
+
+		// The activation and password reset tokens.
+		if len(exp.EmailTokens) != 2 {
+			t.Errorf("expected 2 email tokens, got %d", len(exp.EmailTokens))
+		}
+
+		if exp.TOTP == nil || exp.TOTP.ConfirmedAt == nil {
+			t.Errorf("expected a confirmed TOTP credential, got %+v", exp.TOTP)
+		}
+
+		if len(exp.RecoveryCodes) != len(codes) {
+			t.Errorf("expected %d recovery codes, got %d", len(codes), len(exp.RecoveryCodes))
+		}
+
+		if len(exp.Passkeys) != 1 || exp.Passkeys[0].ID != passkey.ID {
+			t.Errorf("expected passkey %s, got %+v", passkey.ID, exp.Passkeys)
+		}
+
+		if len(exp.APITokens) != 1 || exp.APITokens[0].ID != apiToken.ID {
+			t.Errorf("expected API token %s, got %+v", apiToken.ID, exp.APITokens)
+		}
+
+		if len(exp.EmailBounces) != 1 {
+			t.Fatalf("expected 1 email bounce, got %+v", exp.EmailBounces)
+		}
+
+		bounce := exp.EmailBounces[0]
+		if bounce.Email != user.Email || bounce.Kind != auth.BounceKindSpamComplaint || !bounce.BouncedAt.Equal(testNow) {
+			t.Errorf("expected a spam complaint for %s at %s, got %+v", user.Email, testNow, bounce)
+		}
+	})
+
+	t.Run("ok, export without second factors", func(t *testing.T) {
+		st := newServiceTest(t)
+		user := st.registerAndActivateUser()
+
+		exp, err := st.svc.Export(context.Background(), user.ID)
+		if err != nil {
+			t.Fatalf("failed to export: %v", err)
+		}
+
+		if exp.TOTP != nil || len(exp.RecoveryCodes) != 0 || len(exp.Passkeys) != 0 {
+			t.Errorf("expected no second factors, got %+v", exp)
+		}
+	})
+
+	t.Run("fail, user not found", func(t *testing.T) {
+		st := newServiceTest(t)
+
+		_, err := st.svc.Export(context.Background(), must(uuid.Parse("597228ee-afde-4991-b13c-0161325e3930")))
+		if !errors.Is(err, errorz.ErrNotFound) {
+			t.Fatalf("expected error %v, got %v (via errors.Is)", errorz.ErrNotFound, err)
+		}
+	})
+
+	// BeginTx, FindUsers, FindEmailTokens, FindTOTPs, FindRecoveryCodes, FindPasskeys,
+	// FindAPITokens, FindEmailBounces and Commit.
+	for _, tracker := range testerr.NewFailingDeps(testerr.Err, 9) {
+		t.Run("fail, store fails", func(t *testing.T) {
+			st := newServiceTest(t)
+			user := st.registerAndActivateUser()
+
+			st.store.tracker = &tracker
+
+			_, err := st.svc.Export(context.Background(), user.ID)
+			if !errors.Is(err, testerr.Err) {
+				t.Fatalf("expected error %v, got %v (via errors.Is)", testerr.Err, err)
+			}
+		})
+	}
+}
+
+func Test_Service_RequestAccountDeletion(t *testing.T) {
+	t.Run("ok, request deletion", func(t *testing.T) {
+		st := newServiceTest(t)
+		user := st.registerAndActivateUser()
+		st.emailer.clearEmails()
+
+		err := st.svc.RequestAccountDeletion(context.Background(), user.ID)
+		if err != nil {
+			t.Fatalf("failed to request account deletion: %v", err)
+		}
+
+		st.emailer.assertLastEmail(t, "account-deletion-request", user.Email, func(t *testing.T, data any) {
+			tok, ok := data.(auth.EmailTokenRaw)
+			if !ok {
+				t.Fatalf("unexpected data type: %T", data)
+			}
+			if tok.ID == uuid.Nil {
+				t.Fatalf("expected ID to be set")
+			}
+		})
+
+		// The user is only deleted after confirmation.
+		st.findUser(user.Email)
+	})
+
+	t.Run("ok, no new link during cooldown", func(t *testing.T) {
+		st := newServiceTest(t, func(cfg *auth.ServiceConfig) {
+			cfg.TokenCooldown = time.Minute
+		})
+		user := st.registerAndActivateUser()
+		st.requestAccountDeletion(user.ID)
+		st.emailer.clearEmails()
+
+		st.svc.NowFunc = func() time.Time { return testNow.Add(59 * time.Second) }
+		err := st.svc.RequestAccountDeletion(context.Background(), user.ID)
+		if err != nil {
+			t.Fatalf("failed to request account deletion: %v", err)
+		}
+
+		st.emailer.assertNoEmails(t)
+
+		// A new link can be requested once the cooldown has passed.
+		st.svc.NowFunc = func() time.Time { return testNow.Add(time.Minute) }
+		st.requestAccountDeletion(user.ID)
+	})
+
+	t.Run("fail, user not found", func(t *testing.T) {
+		st := newServiceTest(t)
+
+		err := st.svc.RequestAccountDeletion(context.Background(), must(uuid.Parse("597228ee-afde-4991-b13c-0161325e3930")))
+		if !errors.Is(err, errorz.ErrNotFound) {
+			t.Fatalf("expected error %v, got %v (via errors.Is)", errorz.ErrNotFound, err)
+		}
+
+		st.emailer.assertNoEmails(t)
+	})
+
+	// BeginTx, FindUsers, CountEmailTokens, CreateEmailToken, CreateOutboxMessage
+	// and Commit.
+	for _, tracker := range testerr.NewFailingDeps(testerr.Err, 6) {
+		t.Run("fail, store fails", func(t *testing.T) {
+			st := newServiceTest(t)
+			user := st.registerAndActivateUser()
+			st.emailer.clearEmails()
+
+			st.store.tracker = &tracker
+
+			err := st.svc.RequestAccountDeletion(context.Background(), user.ID)
+			if !errors.Is(err, testerr.Err) {
+				t.Fatalf("expected error %v, got %v (via errors.Is)", testerr.Err, err)
+			}
+
+			st.store.tracker = &testerr.Calltracker{}
+			st.emailer.assertNoEmails(t)
+		})
+	}
+}
+
+func Test_Service_DeleteAccount(t *testing.T) {
+	t.Run("ok, delete account", func(t *testing.T) {
+		st := newServiceTest(t)
+		user := st.registerAndActivateUser()
+		st.enableTOTP(user.ID)
+		st.registerPasskey(user.ID)
+		st.createSession(user.Email)
+
+		// A failed login is recorded as an attempt.
+		password := must(auth.ParsePassword("reallyStrongPassword1"))
+		if st.authenticate(auth.Credentials{Email: user.Email, Password: must(auth.ParsePassword("wrongPassword"))}) {
+			t.Fatalf("expected authentication to fail")
+		}
+
+		tok := st.requestAccountDeletion(user.ID)
+		st.emailer.clearEmails()
+
+		err := st.svc.DeleteAccount(context.Background(), tok)
+		if err != nil {
+			t.Fatalf("failed to delete account: %v", err)
+		}
+
+		st.emailer.assertLastEmail(t, "account-deletion-success", user.Email, nil)
+
+		// Earlier emails, like the activation and deletion links, are removed from the outbox.
+		msgs, err := st.emailer.outbox.FindMessages(context.Background(), outbox.MessageFilter{})
+		if err != nil {
+			t.Fatalf("failed to find outbox messages: %v", err)
+		}
+
+		if len(msgs) != 1 || msgs[0].Subject != "account-deletion-success" {
+			t.Fatalf("expected only the account deletion confirmation in the outbox, got %+v", msgs)
+		}
+
+		users, err := st.store.store.FindUsers(context.Background(), auth.UserFilter{
+			IDs: []uuid.UUID{user.ID},
+		})
+		if err != nil || len(users) != 0 {
+			t.Fatalf("expected user to be deleted, got %v and error %v", users, err)
+		}
+
+		records, err := st.sessions.FindRecords(context.Background(), sessions.RecordFilter{
+			UserIDs: []uuid.UUID{user.ID},
+		})
+		if err != nil || len(records) != 0 {
+			t.Fatalf("expected sessions to be deleted, got %v and error %v", records, err)
+		}
+
+		attempts, err := st.store.store.CountAttempts(context.Background(), auth.AttemptFilter{
+			Emails: []email.Address{user.Email},
+		})
+		if err != nil || attempts != 0 {
+			t.Fatalf("expected attempts to be deleted, got %d and error %v", attempts, err)
+		}
+
+		if st.authenticate(auth.Credentials{Email: user.Email, Password: password}) {
+			t.Fatalf("expected authentication to fail")
+		}
+
+		// The token can't be used again.
+		err = st.svc.DeleteAccount(context.Background(), tok)
+		if !errors.Is(err, errorz.ErrNotFound) {
+			t.Fatalf("expected error %v, got %v (via errors.Is)", errorz.ErrNotFound, err)
+		}
+	})
+
+	t.Run("fail, non-matching token", func(t *testing.T) {
+		st := newServiceTest(t)
+		user := st.registerAndActivateUser()
+		tok := st.requestAccountDeletion(user.ID)
+		st.emailer.clearEmails()
+
+		tok.Token = must(krypto.ParseToken("0102030405060708091011121314151617181920212223242526272829303132"))
+
+		err := st.svc.DeleteAccount(context.Background(), tok)
+		if !errors.Is(err, errorz.ErrNotFound) {
+			t.Fatalf("expected error %v, got %v (via errors.Is)", errorz.ErrNotFound, err)
+		}
+
+		st.emailer.assertNoEmails(t)
+		st.findUser(user.Email)
+	})
+
+	t.Run("fail, token for different purpose", func(t *testing.T) {
+		st := newServiceTest(t)
+		user := st.registerAndActivateUser()
+		tok := st.requestPasswordReset(user.Email)
+		st.emailer.clearEmails()
+
+		err := st.svc.DeleteAccount(context.Background(), tok)
+		if !errors.Is(err, errorz.ErrNotFound) {
+			t.Fatalf("expected error %v, got %v (via errors.Is)", errorz.ErrNotFound, err)
+		}
+
+		st.emailer.assertNoEmails(t)
+		st.findUser(user.Email)
+	})
+
+	// BeginTx, FindEmailTokens, FindUsers, DeleteUser, DeleteAttempts, DeleteOutboxMessages,
+	// CreateOutboxMessage and Commit.
+	for _, tracker := range testerr.NewFailingDeps(testerr.Err, 8) {
+		t.Run("fail, store fails", func(t *testing.T) {
+			st := newServiceTest(t)
+			user := st.registerAndActivateUser()
+			tok := st.requestAccountDeletion(user.ID)
+			st.emailer.clearEmails()
+
+			st.store.tracker = &tracker
+
+			err := st.svc.DeleteAccount(context.Background(), tok)
+			if !errors.Is(err, testerr.Err) {
+				t.Fatalf("expected error %v, got %v (via errors.Is)", testerr.Err, err)
+			}
+
+			st.store.tracker = &testerr.Calltracker{}
+			st.emailer.assertNoEmails(t)
+			st.findUser(user.Email)
+		})
+	}
+}
+
+func Test_Service_EnrollTOTP(t *testing.T) {
+	t.Run("ok, enroll", func(t *testing.T) {
+		st := newServiceTest(t)
+		user := st.registerAndActivateUser()
+
+		enrollment, err := st.svc.EnrollTOTP(context.Background(), user.ID)
+		if err != nil {
+			t.Fatalf("failed to enroll: %v", err)
+		}
+
+		if enrollment.Secret == (krypto.TOTPSecret{}) {
+			t.Fatalf("expected a secret")
+		}
+
+		if enrollment.URI != enrollment.Secret.URI("Househunt", string(user.Email)) {
+			t.Fatalf("unexpected uri: %s", enrollment.URI)
+		}
+
+		// Enrolling again returns the same secret until it is confirmed.
+		again, err := st.svc.EnrollTOTP(context.Background(), user.ID)
+		if err != nil {
+			t.Fatalf("failed to enroll: %v", err)
+		}
+
+		if again != enrollment {
+			t.Fatalf("expected the same enrollment, got\n%v\nwant\n%v", again, enrollment)
+		}
+
+		st.assertTOTPEnabled(user.ID, false)
+	})
+
+	t.Run("fail, already enabled", func(t *testing.T) {
+		st := newServiceTest(t)
+		user := st.registerAndActivateUser()
+		st.enableTOTP(user.ID)
+
+		_, err := st.svc.EnrollTOTP(context.Background(), user.ID)
+		if !errors.Is(err, auth.ErrTOTPEnabled) {
+			t.Fatalf("expected error %v, got %v (via errors.Is)", auth.ErrTOTPEnabled, err)
+		}
+	})
+
+	t.Run("fail, non-existant user", func(t *testing.T) {
+		st := newServiceTest(t)
+
+		_, err := st.svc.EnrollTOTP(context.Background(), must(uuid.Parse("597228ee-afde-4991-b13c-0161325e3930")))
+		if !errors.Is(err, errorz.ErrNotFound) {
+			t.Fatalf("expected error %v, got %v (via errors.Is)", errorz.ErrNotFound, err)
+		}
+	})
+
+	for _, tracker := range testerr.NewFailingDeps(testerr.Err, 5) {
+		t.Run("fail, store fails", func(t *testing.T) {
+			st := newServiceTest(t)
+			user := st.registerAndActivateUser()
+
+			st.store.tracker = &tracker
+
+			_, err := st.svc.EnrollTOTP(context.Background(), user.ID)
+			if !errors.Is(err, testerr.Err) {
+				t.Fatalf("expected error %v, got %v (via errors.Is)", testerr.Err, err)
+			}
+
+			st.store.tracker = &testerr.Calltracker{}
+			st.assertTOTPEnabled(user.ID, false)
+		})
+	}
+}
+
+func Test_Service_ConfirmTOTP(t *testing.T) {
+	t.Run("ok, confirm", func(t *testing.T) {
+		st := newServiceTest(t)
+		user := st.registerAndActivateUser()
+
+		_, codes := st.enableTOTP(user.ID)
+
+		if len(codes) != 10 {
+			t.Fatalf("expected 10 recovery codes, got %d", len(codes))
+		}
+
+		st.assertTOTPEnabled(user.ID, true)
+	})
+
+	t.Run("fail, invalid code", func(t *testing.T) {
+		st := newServiceTest(t)
+		user := st.registerAndActivateUser()
+
+		enrollment, err := st.svc.EnrollTOTP(context.Background(), user.ID)
+		if err != nil {
+			t.Fatalf("failed to enroll: %v", err)
+		}
+
+		code := enrollment.Secret.Code(st.svc.NowFunc().Add(time.Hour))
+		_, err = st.svc.ConfirmTOTP(context.Background(), user.ID, code)
+		assertInvalidCode(t, err)
+
+		st.assertTOTPEnabled(user.ID, false)
+	})
+
+	t.Run("fail, not enrolled", func(t *testing.T) {
+		st := newServiceTest(t)
+		user := st.registerAndActivateUser()
+
+		_, err := st.svc.ConfirmTOTP(context.Background(), user.ID, "123456")
+		if !errors.Is(err, errorz.ErrNotFound) {
+			t.Fatalf("expected error %v, got %v (via errors.Is)", errorz.ErrNotFound, err)
+		}
+	})
+
+	// BeginTx, FindTOTPs, UpdateTOTP, DeleteRecoveryCodes, 10x CreateRecoveryCode and Commit.
+	for _, tracker := range testerr.NewFailingDeps(testerr.Err, 15) {
+		t.Run("fail, store fails", func(t *testing.T) {
+			st := newServiceTest(t)
+			user := st.registerAndActivateUser()
+
+			enrollment, err := st.svc.EnrollTOTP(context.Background(), user.ID)
+			if err != nil {
+				t.Fatalf("failed to enroll: %v", err)
+			}
+
+			st.store.tracker = &tracker
+
+			_, err = st.svc.ConfirmTOTP(context.Background(), user.ID, enrollment.Secret.Code(st.svc.NowFunc()))
+			if !errors.Is(err, testerr.Err) {
+				t.Fatalf("expected error %v, got %v (via errors.Is)", testerr.Err, err)
+			}
+
+			st.store.tracker = &testerr.Calltracker{}
+			st.assertTOTPEnabled(user.ID, false)
+		})
+	}
+}
+
+func Test_Service_VerifySecondFactor(t *testing.T) {
+	t.Run("ok, totp code", func(t *testing.T) {
+		st := newServiceTest(t)
+		user := st.registerAndActivateUser()
+		secret, _ := st.enableTOTP(user.ID)
+
+		// The code used to confirm can't be used to login.
+		st.svc.NowFunc = func() time.Time { return testNow.Add(30 * time.Second) }
+
+		got, err := st.svc.VerifySecondFactor(context.Background(), user.ID, secret.Code(st.svc.NowFunc()))
+		if err != nil {
+			t.Fatalf("failed to verify: %v", err)
+		}
+
+		if got.ID != user.ID {
+			t.Fatalf("unexpected user: %v", got)
+		}
+
+		// Codes can only be used once.
+		_, err = st.svc.VerifySecondFactor(context.Background(), user.ID, secret.Code(st.svc.NowFunc()))
+		assertInvalidCode(t, err)
+	})
+
+	t.Run("ok, recovery code", func(t *testing.T) {
+		st := newServiceTest(t)
+		user := st.registerAndActivateUser()
+		_, codes := st.enableTOTP(user.ID)
+
+		// Recovery codes are accepted in any case and with or without dashes.
+		_, err := st.svc.VerifySecondFactor(context.Background(), user.ID, strings.ToUpper(codes[3].String()))
+		if err != nil {
+			t.Fatalf("failed to verify: %v", err)
+		}
+
+		// Recovery codes can only be used once.
+		_, err = st.svc.VerifySecondFactor(context.Background(), user.ID, codes[3].String())
+		assertInvalidCode(t, err)
+
+		// Other recovery codes are still valid.
+		_, err = st.svc.VerifySecondFactor(context.Background(), user.ID, codes[4].String())
+		if err != nil {
+			t.Fatalf("failed to verify: %v", err)
+		}
+	})
+
+	t.Run("fail, code used to confirm", func(t *testing.T) {
+		st := newServiceTest(t)
+		user := st.registerAndActivateUser()
+		secret, _ := st.enableTOTP(user.ID)
+
+		_, err := st.svc.VerifySecondFactor(context.Background(), user.ID, secret.Code(st.svc.NowFunc()))
+		assertInvalidCode(t, err)
+	})
+
+	t.Run("fail, locked out after too many invalid codes", func(t *testing.T) {
+		st := newServiceTest(t)
+		user := st.registerAndActivateUser()
+		secret, _ := st.enableTOTP(user.ID)
+
+		// MaxLoginFailures is set to 3.
+		for i := 0; i < 3; i++ {
+			_, err := st.svc.VerifySecondFactor(context.Background(), user.ID, "000000")
+			assertInvalidCode(t, err)
+		}
+
+		st.svc.NowFunc = func() time.Time { return testNow.Add(30 * time.Second) }
+
+		// Even a valid code is refused now.
+		_, err := st.svc.VerifySecondFactor(context.Background(), user.ID, secret.Code(st.svc.NowFunc()))
+		if !errors.Is(err, errorz.ErrRateLimited) {
+			t.Fatalf("expected error %v, got %v (via errors.Is)", errorz.ErrRateLimited, err)
+		}
+	})
+
+	t.Run("fail, invalid codes are not reset by logging in again", func(t *testing.T) {
+		st := newServiceTest(t)
+		credentials, tok := st.registerUser()
+		st.activateUser(tok)
+		st.svc.NowFunc = func() time.Time { return testNow }
+		user := st.findUser(credentials.Email)
+		secret, _ := st.enableTOTP(user.ID)
+
+		// MaxLoginFailures is set to 3.
+		for i := 0; i < 3; i++ {
+			_, err := st.svc.VerifySecondFactor(context.Background(), user.ID, "000000")
+			assertInvalidCode(t, err)
+
+			if !st.authenticate(credentials) {
+				t.Fatalf("expected authentication to succeed")
+			}
+		}
+
+		st.svc.NowFunc = func() time.Time { return testNow.Add(30 * time.Second) }
+
+		_, err := st.svc.VerifySecondFactor(context.Background(), user.ID, secret.Code(st.svc.NowFunc()))
+		if !errors.Is(err, errorz.ErrRateLimited) {
+			t.Fatalf("expected error %v, got %v (via errors.Is)", errorz.ErrRateLimited, err)
+		}
+	})
+
+	t.Run("fail, totp not enabled", func(t *testing.T) {
+		st := newServiceTest(t)
+		user := st.registerAndActivateUser()
+
+		_, err := st.svc.VerifySecondFactor(context.Background(), user.ID, "000000")
+		if !errors.Is(err, errorz.ErrNotFound) {
+			t.Fatalf("expected error %v, got %v (via errors.Is)", errorz.ErrNotFound, err)
+		}
+	})
+
+	// FindUsers, CountAttempts, BeginTx, FindTOTPs, UpdateTOTP and Commit.
+	for _, tracker := range testerr.NewFailingDeps(testerr.Err, 6) {
+		t.Run("fail, store fails", func(t *testing.T) {
+			st := newServiceTest(t)
+			user := st.registerAndActivateUser()
+			secret, _ := st.enableTOTP(user.ID)
+
+			st.svc.NowFunc = func() time.Time { return testNow.Add(30 * time.Second) }
+			st.store.tracker = &tracker
+
+			_, err := st.svc.VerifySecondFactor(context.Background(), user.ID, secret.Code(st.svc.NowFunc()))
+			if !errors.Is(err, testerr.Err) {
+				t.Fatalf("expected error %v, got %v (via errors.Is)", testerr.Err, err)
+			}
+		})
+	}
+}
+
+func Test_Service_DisableTOTP(t *testing.T) {
+	t.Run("ok, disable", func(t *testing.T) {
+		st := newServiceTest(t)
+		user := st.registerAndActivateUser()
+		_, codes := st.enableTOTP(user.ID)
+
+		err := st.svc.DisableTOTP(context.Background(), user.ID, codes[0].String())
+		if err != nil {
+			t.Fatalf("failed to disable: %v", err)
+		}
+
+		st.assertTOTPEnabled(user.ID, false)
+
+		// TOTP can be enabled again.
+		st.enableTOTP(user.ID)
+	})
+
+	t.Run("fail, invalid code", func(t *testing.T) {
+		st := newServiceTest(t)
+		user := st.registerAndActivateUser()
+		st.enableTOTP(user.ID)
+
+		err := st.svc.DisableTOTP(context.Background(), user.ID, "000000")
+		assertInvalidCode(t, err)
+
+		st.assertTOTPEnabled(user.ID, true)
+	})
+
+	// BeginTx, FindTOTPs, UpdateTOTP, DeleteTOTPs, DeleteRecoveryCodes and Commit.
+	for _, tracker := range testerr.NewFailingDeps(testerr.Err, 6) {
+		t.Run("fail, store fails", func(t *testing.T) {
+			st := newServiceTest(t)
+			user := st.registerAndActivateUser()
+			secret, _ := st.enableTOTP(user.ID)
+
+			st.svc.NowFunc = func() time.Time { return testNow.Add(30 * time.Second) }
+			st.store.tracker = &tracker
+
+			err := st.svc.DisableTOTP(context.Background(), user.ID, secret.Code(st.svc.NowFunc()))
+			if !errors.Is(err, testerr.Err) {
+				t.Fatalf("expected error %v, got %v (via errors.Is)", testerr.Err, err)
+			}
+
+			st.store.tracker = &testerr.Calltracker{}
+			st.assertTOTPEnabled(user.ID, true)
+		})
+	}
+}
+
+func Test_Service_RegisterPasskey(t *testing.T) {
+	t.Run("ok, register", func(t *testing.T) {
+		st := newServiceTest(t)
+		user := st.registerAndActivateUser()
+
+		a, passkey := st.registerPasskey(user.ID)
+
+		if passkey.UserID != user.ID || !slices.Equal(passkey.CredentialID, a.CredentialID()) || !passkey.CreatedAt.Equal(testNow) {
+			t.Errorf("unexpected passkey: %+v", passkey)
+		}
+
+		st.assertPasskeys(user.ID, passkey)
+
+		// Registered passkeys are excluded from new registrations.
+		opts, err := st.svc.PasskeyCreationOptions(context.Background(), user.ID, must(webauthn.GenerateChallenge()))
+		if err != nil {
+			t.Fatalf("failed to get options: %v", err)
+		}
+
+		if len(opts.ExcludeCredentials) != 1 || !slices.Equal(opts.ExcludeCredentials[0].ID, a.CredentialID()) {
+			t.Errorf("expected credential to be excluded, got %+v", opts.ExcludeCredentials)
+		}
+	})
+
+	t.Run("fail, other challenge", func(t *testing.T) {
+		st := newServiceTest(t)
+		user := st.registerAndActivateUser()
+
+		a := must(webauthntest.New(testWebAuthn.Origin))
+		opts := must(st.svc.PasskeyCreationOptions(context.Background(), user.ID, must(webauthn.GenerateChallenge())))
+		resp := must(a.Register(opts))
+
+		_, err := st.svc.RegisterPasskey(context.Background(), user.ID, must(webauthn.GenerateChallenge()), resp)
+		assertInvalidPasskey(t, err)
+
+		st.assertPasskeys(user.ID)
+	})
+
+	t.Run("fail, registered twice", func(t *testing.T) {
+		st := newServiceTest(t)
+		user := st.registerAndActivateUser()
+
+		a, passkey := st.registerPasskey(user.ID)
+
+		challenge := must(webauthn.GenerateChallenge())
+		opts := must(st.svc.PasskeyCreationOptions(context.Background(), user.ID, challenge))
+		resp := must(a.Register(opts))
+
+		_, err := st.svc.RegisterPasskey(context.Background(), user.ID, challenge, resp)
+		assertInvalidPasskey(t, err)
+
+		st.assertPasskeys(user.ID, passkey)
+	})
+
+	t.Run("fail, user not found", func(t *testing.T) {
+		st := newServiceTest(t)
+
+		_, err := st.svc.PasskeyCreationOptions(context.Background(), must(uuid.Parse("597228ee-afde-4991-b13c-0161325e3930")), must(webauthn.GenerateChallenge()))
+		if !errors.Is(err, errorz.ErrNotFound) {
+			t.Fatalf("expected error %v, got %v (via errors.Is)", errorz.ErrNotFound, err)
+		}
+	})
+
+	// BeginTx, FindUsers, CreatePasskey and Commit.
+	for _, tracker := range testerr.NewFailingDeps(testerr.Err, 4) {
+		t.Run("fail, store fails", func(t *testing.T) {
+			st := newServiceTest(t)
+			user := st.registerAndActivateUser()
+
+			a := must(webauthntest.New(testWebAuthn.Origin))
+			challenge := must(webauthn.GenerateChallenge())
+			opts := must(st.svc.PasskeyCreationOptions(context.Background(), user.ID, challenge))
+			resp := must(a.Register(opts))
+
+			st.store.tracker = &tracker
+
+			_, err := st.svc.RegisterPasskey(context.Background(), user.ID, challenge, resp)
+			if !errors.Is(err, testerr.Err) {
+				t.Fatalf("expected error %v, got %v (via errors.Is)", testerr.Err, err)
+			}
+
+			st.store.tracker = &testerr.Calltracker{}
+			st.assertPasskeys(user.ID)
+		})
+	}
+}
+
+func Test_Service_AuthenticatePasskey(t *testing.T) {
+	t.Run("ok, authenticate", func(t *testing.T) {
+		st := newServiceTest(t)
+		user := st.registerAndActivateUser()
+		a, passkey := st.registerPasskey(user.ID)
+
+		st.svc.NowFunc = func() time.Time { return testNow.Add(time.Minute) }
+
+		challenge := must(webauthn.GenerateChallenge())
+		resp := must(a.Login(st.svc.PasskeyRequestOptions(challenge)))
+
+		got, err := st.svc.AuthenticatePasskey(context.Background(), challenge, resp)
+		if err != nil {
+			t.Fatalf("failed to authenticate: %v", err)
+		}
+
+		if got.ID != user.ID {
+			t.Errorf("expected user %s, got %s", user.ID, got.ID)
+		}
+
+		lastUsedAt := testNow.Add(time.Minute)
+		passkey.SignCount = 1
+		passkey.LastUsedAt = &lastUsedAt
+		st.assertPasskeys(user.ID, passkey)
+	})
+
+	t.Run("fail, other challenge", func(t *testing.T) {
+		st := newServiceTest(t)
+		user := st.registerAndActivateUser()
+		a, passkey := st.registerPasskey(user.ID)
+
+		resp := must(a.Login(st.svc.PasskeyRequestOptions(must(webauthn.GenerateChallenge()))))
+
+		_, err := st.svc.AuthenticatePasskey(context.Background(), must(webauthn.GenerateChallenge()), resp)
+		assertInvalidPasskey(t, err)
+
+		st.assertPasskeys(user.ID, passkey)
+	})
+
+	t.Run("fail, unknown passkey", func(t *testing.T) {
+		st := newServiceTest(t)
+
+		a := must(webauthntest.New(testWebAuthn.Origin))
+		challenge := must(webauthn.GenerateChallenge())
+		resp := must(a.Login(st.svc.PasskeyRequestOptions(challenge)))
+
+		_, err := st.svc.AuthenticatePasskey(context.Background(), challenge, resp)
+		assertInvalidPasskey(t, err)
+	})
+
+	t.Run("fail, other user handle", func(t *testing.T) {
+		st := newServiceTest(t)
+		user := st.registerAndActivateUser()
+		a, _ := st.registerPasskey(user.ID)
+
+		challenge := must(webauthn.GenerateChallenge())
+		resp := must(a.Login(st.svc.PasskeyRequestOptions(challenge)))
+		resp.UserHandle = []byte("someone else")
+
+		_, err := st.svc.AuthenticatePasskey(context.Background(), challenge, resp)
+		assertInvalidPasskey(t, err)
+	})
+
+	t.Run("fail, signature counter did not increase", func(t *testing.T) {
+		st := newServiceTest(t)
+		user := st.registerAndActivateUser()
+		a, _ := st.registerPasskey(user.ID)
+
+		challenge := must(webauthn.GenerateChallenge())
+		resp := must(a.Login(st.svc.PasskeyRequestOptions(challenge)))
+		_ = must(st.svc.AuthenticatePasskey(context.Background(), challenge, resp))
+
+		// A clone of the authenticator reuses the same counter.
+		a.SignCount = 0
+
+		challenge = must(webauthn.GenerateChallenge())
+		resp = must(a.Login(st.svc.PasskeyRequestOptions(challenge)))
+
+		_, err := st.svc.AuthenticatePasskey(context.Background(), challenge, resp)
+		assertInvalidPasskey(t, err)
+
+		st.errList.assertErrorIs(t, webauthn.ErrSignCount)
+	})
+
+	// BeginTx, FindPasskeys, FindUsers, UpdatePasskey and Commit.
+	for _, tracker := range testerr.NewFailingDeps(testerr.Err, 5) {
+		t.Run("fail, store fails", func(t *testing.T) {
+			st := newServiceTest(t)
+			user := st.registerAndActivateUser()
+			a, passkey := st.registerPasskey(user.ID)
+
+			challenge := must(webauthn.GenerateChallenge())
+			resp := must(a.Login(st.svc.PasskeyRequestOptions(challenge)))
+
+			st.store.tracker = &tracker
+
+			_, err := st.svc.AuthenticatePasskey(context.Background(), challenge, resp)
+			if !errors.Is(err, testerr.Err) {
+				t.Fatalf("expected error %v, got %v (via errors.Is)", testerr.Err, err)
+			}
+
+			st.store.tracker = &testerr.Calltracker{}
+			st.assertPasskeys(user.ID, passkey)
+		})
+	}
+}
+
+func Test_Service_DeletePasskey(t *testing.T) {
+	t.Run("ok, delete", func(t *testing.T) {
+		st := newServiceTest(t)
+		user := st.registerAndActivateUser()
+		a, passkey := st.registerPasskey(user.ID)
+
+		err := st.svc.DeletePasskey(context.Background(), user.ID, passkey.ID)
+		if err != nil {
+			t.Fatalf("failed to delete: %v", err)
+		}
+
+		st.assertPasskeys(user.ID)
+
+		// The passkey can no longer be used.
+		challenge := must(webauthn.GenerateChallenge())
+		resp := must(a.Login(st.svc.PasskeyRequestOptions(challenge)))
+
+		_, err = st.svc.AuthenticatePasskey(context.Background(), challenge, resp)
+		assertInvalidPasskey(t, err)
+	})
+
+	t.Run("fail, passkey of other user", func(t *testing.T) {
+		st := newServiceTest(t)
+		user := st.registerAndActivateUser()
+		_, passkey := st.registerPasskey(user.ID)
+
+		err := st.svc.DeletePasskey(context.Background(), must(uuid.Parse("597228ee-afde-4991-b13c-0161325e3930")), passkey.ID)
+		if !errors.Is(err, errorz.ErrNotFound) {
+			t.Fatalf("expected error %v, got %v (via errors.Is)", errorz.ErrNotFound, err)
+		}
+
+		st.assertPasskeys(user.ID, passkey)
+	})
+
+	// BeginTx, FindPasskeys, DeletePasskeys and Commit.
+	for _, tracker := range testerr.NewFailingDeps(testerr.Err, 4) {
+		t.Run("fail, store fails", func(t *testing.T) {
+			st := newServiceTest(t)
+			user := st.registerAndActivateUser()
+			_, passkey := st.registerPasskey(user.ID)
+
+			st.store.tracker = &tracker
+
+			err := st.svc.DeletePasskey(context.Background(), user.ID, passkey.ID)
+			if !errors.Is(err, testerr.Err) {
+				t.Fatalf("expected error %v, got %v (via errors.Is)", testerr.Err, err)
+			}
+
+			st.store.tracker = &testerr.Calltracker{}
+			st.assertPasskeys(user.ID, passkey)
+		})
+	}
+}
+
+func Test_Service_CreateAPIToken(t *testing.T) {
+	t.Run("ok, create", func(t *testing.T) {
+		st := newServiceTest(t)
+		user := st.registerAndActivateUser()
+
+		apiToken, raw := st.createAPIToken(user.ID)
+
+		if apiToken.ID != raw.ID || apiToken.UserID != user.ID || apiToken.Name != "CRM import" {
+			t.Errorf("unexpected API token: %+v", apiToken)
+		}
+
+		// Scopes are sorted and deduplicated.
+		wantScopes := []auth.Scope{auth.ScopeListingsRead, auth.ScopeListingsWrite}
+		if !slices.Equal(apiToken.Scopes, wantScopes) {
+			t.Errorf("expected scopes %v, got %v", wantScopes, apiToken.Scopes)
+		}
+
+		if !apiToken.ExpiresAt.Equal(apiToken.CreatedAt.AddDate(0, 0, 30)) {
+			t.Errorf("expected token to expire after 30 days, got %v", apiToken.ExpiresAt)
+		}
+
+		if !apiToken.TokenHash.MatchBytes(raw.Token[:]) {
+			t.Errorf("expected token hash to match the raw token")
+		}
+
+		st.assertAPITokens(user.ID, apiToken)
+	})
+
+	t.Run("fail, invalid input", func(t *testing.T) {
+		st := newServiceTest(t)
+		user := st.registerAndActivateUser()
+
+		_, _, err := st.svc.CreateAPIToken(context.Background(), user.ID, auth.NewAPIToken{
+			Name:          "",
+			ExpiresInDays: 366,
+		})
+
+		var invalidInput errorz.InvalidInput
+		if !errors.As(err, &invalidInput) || len(invalidInput) != 3 {
+			t.Fatalf("expected 3 invalid input errors, got %v", err)
+		}
+
+		st.assertAPITokens(user.ID)
+	})
+
+	t.Run("fail, too many tokens", func(t *testing.T) {
+		st := newServiceTest(t)
+		user := st.registerAndActivateUser()
+
+		for i := 0; i < 20; i++ {
+			st.createAPIToken(user.ID)
+		}
+
+		_, _, err := st.svc.CreateAPIToken(context.Background(), user.ID, newAPIToken())
+		if !errors.Is(err, auth.ErrTooManyAPITokens) {
+			t.Fatalf("expected error %v, got %v (via errors.Is)", auth.ErrTooManyAPITokens, err)
+		}
+
+		tokens := must(st.svc.APITokens(context.Background(), user.ID))
+		if len(tokens) != 20 {
+			t.Errorf("expected 20 API tokens, got %d", len(tokens))
+		}
+	})
+
+	t.Run("fail, user not found", func(t *testing.T) {
+		st := newServiceTest(t)
+
+		_, _, err := st.svc.CreateAPIToken(context.Background(), must(uuid.Parse("597228ee-afde-4991-b13c-0161325e3930")), newAPIToken())
+		if !errors.Is(err, errorz.ErrNotFound) {
+			t.Fatalf("expected error %v, got %v (via errors.Is)", errorz.ErrNotFound, err)
+		}
+	})
+
+	// BeginTx, FindUsers, FindAPITokens, CreateAPIToken and Commit.
+	for _, tracker := range testerr.NewFailingDeps(testerr.Err, 5) {
+		t.Run("fail, store fails", func(t *testing.T) {
+			st := newServiceTest(t)
+			user := st.registerAndActivateUser()
+
+			st.store.tracker = &tracker
+
+			_, _, err := st.svc.CreateAPIToken(context.Background(), user.ID, newAPIToken())
+			if !errors.Is(err, testerr.Err) {
+				t.Fatalf("expected error %v, got %v (via errors.Is)", testerr.Err, err)
+			}
+
+			st.store.tracker = &testerr.Calltracker{}
+			st.assertAPITokens(user.ID)
+		})
+	}
+}
+
+func Test_Service_AuthenticateAPIToken(t *testing.T) {
+	t.Run("ok, authenticate", func(t *testing.T) {
+		st := newServiceTest(t)
+		user := st.registerAndActivateUser()
+		apiToken, raw := st.createAPIToken(user.ID)
+
+		got, gotToken, err := st.svc.AuthenticateAPIToken(context.Background(), raw)
+		if err != nil {
+			t.Fatalf("failed to authenticate: %v", err)
+		}
+
+		if got.ID != user.ID || gotToken.ID != apiToken.ID {
+			t.Errorf("expected user %s and token %s, got %s and %s", user.ID, apiToken.ID, got.ID, gotToken.ID)
+		}
+
+		if gotToken.LastUsedAt == nil {
+			t.Errorf("expected last use to be recorded")
+		}
+
+		st.assertAPITokens(user.ID, gotToken)
+	})
+
+	t.Run("ok, last use is recorded once per minute", func(t *testing.T) {
+		st := newServiceTest(t)
+		user := st.registerAndActivateUser()
+		_, raw := st.createAPIToken(user.ID)
+
+		st.svc.NowFunc = func() time.Time { return testNow }
+		_, first, err := st.svc.AuthenticateAPIToken(context.Background(), raw)
+		if err != nil {
+			t.Fatalf("failed to authenticate: %v", err)
+		}
+
+		st.svc.NowFunc = func() time.Time { return testNow.Add(59 * time.Second) }
+		_, _, err = st.svc.AuthenticateAPIToken(context.Background(), raw)
+		if err != nil {
+			t.Fatalf("failed to authenticate: %v", err)
+		}
+
+		st.assertAPITokens(user.ID, first)
+	})
+
+	t.Run("fail, expired", func(t *testing.T) {
+		st := newServiceTest(t)
+		user := st.registerAndActivateUser()
+		apiToken, raw := st.createAPIToken(user.ID)
+
+		st.svc.NowFunc = func() time.Time { return apiToken.ExpiresAt }
+
+		_, _, err := st.svc.AuthenticateAPIToken(context.Background(), raw)
+		if !errors.Is(err, auth.ErrInvalidAPIToken) {
+			t.Fatalf("expected error %v, got %v (via errors.Is)", auth.ErrInvalidAPIToken, err)
+		}
+
+		st.assertAPITokens(user.ID, apiToken)
+	})
+
+	t.Run("fail, wrong token", func(t *testing.T) {
+		st := newServiceTest(t)
+		user := st.registerAndActivateUser()
+		_, raw := st.createAPIToken(user.ID)
+
+		raw.Token = must(krypto.GenerateToken())
+
+		_, _, err := st.svc.AuthenticateAPIToken(context.Background(), raw)
+		if !errors.Is(err, auth.ErrInvalidAPIToken) {
+			t.Fatalf("expected error %v, got %v (via errors.Is)", auth.ErrInvalidAPIToken, err)
+		}
+	})
+
+	t.Run("fail, unknown token", func(t *testing.T) {
+		st := newServiceTest(t)
+
+		_, _, err := st.svc.AuthenticateAPIToken(context.Background(), auth.APITokenRaw{
+			ID:    must(uuid.Parse("597228ee-afde-4991-b13c-0161325e3930")),
+			Token: must(krypto.GenerateToken()),
+		})
+		if !errors.Is(err, auth.ErrInvalidAPIToken) {
+			t.Fatalf("expected error %v, got %v (via errors.Is)", auth.ErrInvalidAPIToken, err)
+		}
+	})
+
+	// FindAPITokens, FindUsers, BeginTx, UpdateAPIToken and Commit.
+	for _, tracker := range testerr.NewFailingDeps(testerr.Err, 5) {
+		t.Run("fail, store fails", func(t *testing.T) {
+			st := newServiceTest(t)
+			user := st.registerAndActivateUser()
+			apiToken, raw := st.createAPIToken(user.ID)
+
+			st.store.tracker = &tracker
+
+			_, _, err := st.svc.AuthenticateAPIToken(context.Background(), raw)
+			if !errors.Is(err, testerr.Err) {
+				t.Fatalf("expected error %v, got %v (via errors.Is)", testerr.Err, err)
+			}
+
+			st.store.tracker = &testerr.Calltracker{}
+			st.assertAPITokens(user.ID, apiToken)
+		})
+	}
+}
+
+func Test_Service_DeleteAPIToken(t *testing.T) {
+	t.Run("ok, delete", func(t *testing.T) {
+		st := newServiceTest(t)
+		user := st.registerAndActivateUser()
+		apiToken, raw := st.createAPIToken(user.ID)
+
+		err := st.svc.DeleteAPIToken(context.Background(), user.ID, apiToken.ID)
+		if err != nil {
+			t.Fatalf("failed to delete: %v", err)
+		}
+
+		st.assertAPITokens(user.ID)
+
+		// The token can no longer be used.
+		_, _, err = st.svc.AuthenticateAPIToken(context.Background(), raw)
+		if !errors.Is(err, auth.ErrInvalidAPIToken) {
+			t.Fatalf("expected error %v, got %v (via errors.Is)", auth.ErrInvalidAPIToken, err)
+		}
+	})
+
+	t.Run("fail, token of other user", func(t *testing.T) {
+		st := newServiceTest(t)
+		user := st.registerAndActivateUser()
+		apiToken, _ := st.createAPIToken(user.ID)
+
+		err := st.svc.DeleteAPIToken(context.Background(), must(uuid.Parse("597228ee-afde-4991-b13c-0161325e3930")), apiToken.ID)
+		if !errors.Is(err, errorz.ErrNotFound) {
+			t.Fatalf("expected error %v, got %v (via errors.Is)", errorz.ErrNotFound, err)
+		}
+
+		st.assertAPITokens(user.ID, apiToken)
+	})
+
+	// BeginTx, FindAPITokens, DeleteAPITokens and Commit.
+	for _, tracker := range testerr.NewFailingDeps(testerr.Err, 4) {
+		t.Run("fail, store fails", func(t *testing.T) {
+			st := newServiceTest(t)
+			user := st.registerAndActivateUser()
+			apiToken, _ := st.createAPIToken(user.ID)
+
+			st.store.tracker = &tracker
+
+			err := st.svc.DeleteAPIToken(context.Background(), user.ID, apiToken.ID)
+			if !errors.Is(err, testerr.Err) {
+				t.Fatalf("expected error %v, got %v (via errors.Is)", testerr.Err, err)
+			}
+
+			st.store.tracker = &testerr.Calltracker{}
+			st.assertAPITokens(user.ID, apiToken)
+		})
+	}
+}
+
+func Test_Service_RecordEmailBounce(t *testing.T) {
+	kinds := []auth.BounceKind{auth.BounceKindHard, auth.BounceKindSpamComplaint}
+	for _, kind := range kinds {
+		t.Run("ok, record "+string(kind), func(t *testing.T) {
+			st := newServiceTest(t)
+			user := st.registerAndActivateUser()
+
+			err := st.svc.RecordEmailBounce(context.Background(), user.Email, kind, testNow)
+			if err != nil {
+				t.Fatalf("failed to record bounce: %v", err)
+			}
+
+			st.assertEmailSuppressed(user.Email, true)
+			st.assertEmailBounced(user.ID, true)
+		})
+	}
+
+	t.Run("ok, other addresses are not suppressed", func(t *testing.T) {
+		st := newServiceTest(t)
+		user := st.registerAndActivateUser()
+		otherAddr := must(email.ParseAddress("other@example.com"))
+		st.registerOtherUser(otherAddr)
+
+		err := st.svc.RecordEmailBounce(context.Background(), otherAddr, auth.BounceKindHard, testNow)
+		if err != nil {
+			t.Fatalf("failed to record bounce: %v", err)
+		}
+
+		st.assertEmailSuppressed(otherAddr, true)
+		st.assertEmailSuppressed(user.Email, false)
+		st.assertEmailBounced(user.ID, false)
+	})
+
+	t.Run("ok, addresses without user are ignored", func(t *testing.T) {
+		st := newServiceTest(t)
+		addr := must(email.ParseAddress("unknown@example.com"))
+
+		err := st.svc.RecordEmailBounce(context.Background(), addr, auth.BounceKindHard, testNow)
+		if err != nil {
+			t.Fatalf("failed to record bounce: %v", err)
+		}
+
+		st.assertEmailSuppressed(addr, false)
+	})
+
+	t.Run("ok, changing the address lifts the suppression", func(t *testing.T) {
+		st := newServiceTest(t)
+		user := st.registerAndActivateUser()
+
+		err := st.svc.RecordEmailBounce(context.Background(), user.Email, auth.BounceKindHard, testNow)
+		if err != nil {
+			t.Fatalf("failed to record bounce: %v", err)
+		}
+
+		newAddr := must(email.ParseAddress("new@example.com"))
+		tok := st.requestEmailChange(user.ID, newAddr)
+
+		err = st.svc.ConfirmEmailChange(context.Background(), tok)
+		if err != nil {
+			t.Fatalf("failed to confirm email change: %v", err)
+		}
+
+		st.svc.Wait()
+		st.errList.assertNoError(t)
+
+		st.assertEmailSuppressed(user.Email, true)
+		st.assertEmailSuppressed(newAddr, false)
+		st.assertEmailBounced(user.ID, false)
+	})
+
+	// BeginTx, FindUsers, CreateEmailBounce and Commit.
+	for _, tracker := range testerr.NewFailingDeps(testerr.Err, 4) {
+		t.Run("fail, store fails", func(t *testing.T) {
+			st := newServiceTest(t)
+			user := st.registerAndActivateUser()
+
+			st.store.tracker = &tracker
+
+			err := st.svc.RecordEmailBounce(context.Background(), user.Email, auth.BounceKindHard, testNow)
+			if !errors.Is(err, testerr.Err) {
+				t.Fatalf("expected error %v, got %v (via errors.Is)", testerr.Err, err)
+			}
+
+			st.store.tracker = &testerr.Calltracker{}
+			st.assertEmailSuppressed(user.Email, false)
+		})
+	}
+}
+
+func Test_Service_EmailBounced(t *testing.T) {
+	t.Run("fail, user not found", func(t *testing.T) {
+		st := newServiceTest(t)
+
+		_, err := st.svc.EmailBounced(context.Background(), must(uuid.Parse("597228ee-afde-4991-b13c-0161325e3930")))
+		if !errors.Is(err, errorz.ErrNotFound) {
+			t.Fatalf("expected error %v, got %v (via errors.Is)", errorz.ErrNotFound, err)
+		}
+	})
+
+	// FindUsers and CountEmailBounces.
+	for _, tracker := range testerr.NewFailingDeps(testerr.Err, 2) {
+		t.Run("fail, store fails", func(t *testing.T) {
+			st := newServiceTest(t)
+			user := st.registerAndActivateUser()
+
+			st.store.tracker = &tracker
+
+			_, err := st.svc.EmailBounced(context.Background(), user.ID)
+			if !errors.Is(err, testerr.Err) {
+				t.Fatalf("expected error %v, got %v (via errors.Is)", testerr.Err, err)
+			}
+		})
+	}
+}
+
+// testNow is a fixed time, TOTP codes depend on it.
+var testNow = time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+
+func assertInvalidCode(t *testing.T, err error) {
+	t.Helper()
+
+	var invalidInput errorz.InvalidInput
+	if !errors.As(err, &invalidInput) {
+		t.Fatalf("expected error to be of type %T, got %T (via errors.As)", invalidInput, err)
+	}
+
+	if !errors.Is(invalidInput, auth.ErrInvalidCode) {
+		t.Fatalf("expected error %v, got %v (via errors.Is)", auth.ErrInvalidCode, invalidInput)
+	}
+}
+
+func assertInvalidPasskey(t *testing.T, err error) {
+	t.Helper()
+
+	var invalidInput errorz.InvalidInput
+	if !errors.As(err, &invalidInput) {
+		t.Fatalf("expected error to be of type %T, got %T (via errors.As)", invalidInput, err)
+	}
+
+	if !errors.Is(invalidInput, auth.ErrInvalidPasskey) {
+		t.Fatalf("expected error %v, got %v (via errors.Is)", auth.ErrInvalidPasskey, invalidInput)
+	}
+}
+
+// testWebAuthn is the relying party used in the tests.
+var testWebAuthn = webauthn.Config{
+	RPID:   "localhost",
+	RPName: "Househunt",
+	Origin: "http://localhost:8888",
+}
+
 type svcTest struct {
-	t       *testing.T
-	svc     *auth.Service
-	store   *testStore
-	emailer *testEmailer
-	errList *errList
-	nowFunc func() time.Time
+	t        *testing.T
+	svc      *auth.Service
+	store    *testStore
+	sessions *sessionsdb.Store
+	emailer  *testEmailer
+	errList  *errList
+	nowFunc  func() time.Time
+	cfg      auth.ServiceConfig
+}
+
+// newServiceTest creates a service backed by a test database, modFuncs can be used
+// to change the configuration of the service.
+func newServiceTest(t *testing.T, modFuncs ...func(*auth.ServiceConfig)) *svcTest {
+	encryptor := must(krypto.NewEncryptor([]krypto.Key{
+		must(krypto.ParseKey("2b671594b775f371eab4050b4d58326682df6b1a6cc2e886717b1a26b4d6c45d")),
+	}))
+
+	blindIndexer := must(krypto.NewBlindIndexer([]krypto.Key{
+		must(krypto.ParseKey("90303dfed7994260ea4817a5ca8a392915cd401115b2f97495dadfcbcd14adbf")),
+	}))
+
+	testDB := testdb.RunWhile(t, true)
+	outboxStore := outboxdb.New(testDB, testDB, encryptor)
+	test := &svcTest{
+		t: t,
+		store: &testStore{
+			store:   db.New(testDB, testDB, encryptor, blindIndexer),
+			tracker: &testerr.Calltracker{}, // empty call trackers never fail.
+		},
+		sessions: sessionsdb.New(testDB, testDB),
+		errList: &errList{
+			mutex: &sync.Mutex{},
+			errs:  make([]error, 0),
+		},
+		emailer: &testEmailer{
+			outbox: outboxStore,
+		},
+		nowFunc: func() time.Time {
+			return time.Now().Round(0)
+		},
+	}
+
+	cfg := auth.ServiceConfig{
+		WorkerTimeout:        time.Second,
+		TokenExpiry:          time.Hour,
+		MaxOutstandingTokens: 3,
+		MaxLoginFailures:     3,
+		LoginLockout:         time.Hour,
+		MaxPasswordResets:    2,
+		PasswordResetWindow:  time.Hour,
+		TOTPIssuer:           "Househunt",
+		WebAuthn:             testWebAuthn,
+	}
+
+	for _, modFunc := range modFuncs {
+		modFunc(&cfg)
+	}
+
+	svc, err := auth.NewService(test.store, test.emailer, test.errList.AppendErr, cfg)
+	if err != nil {
+		t.Fatalf("failed to create service: %v", err)
+	}
+
+	test.svc = svc
+	test.cfg = cfg
+
+	return test
+}
+
+// withPasswordPolicy configures a password policy that rejects common passwords
+// and passwords containing the email address.
+func withPasswordPolicy(cfg *auth.ServiceConfig) {
+	cfg.PasswordPolicy = auth.PasswordPolicies{
+		auth.EmailPasswordPolicy{},
+		must(auth.LoadCommonPasswords(strings.NewReader("password123\n"))),
+	}
+}
+
+// assertPasswordRejected asserts that err is invalid input for key because of want.
+func assertPasswordRejected(t *testing.T, err error, key string, want error) {
+	t.Helper()
+
+	var invalidInput errorz.InvalidInput
+	if !errors.As(err, &invalidInput) {
+		t.Fatalf("expected error to be of type %T, got %T (via errors.As)", invalidInput, err)
+	}
+
+	var keyed errorz.Keyed
+	if !errors.As(invalidInput, &keyed) || keyed.Key != key || !errors.Is(keyed, want) {
+		t.Fatalf("expected error %v for key %q, got %v", want, key, invalidInput)
+	}
+}
+
+// oldPasswordHashing are cheap argon2 parameters, used to create password hashes
+// that need to be upgraded.
+var oldPasswordHashing = krypto.Argon2Params{MemoryKiB: 64, Iterations: 1, Parallelism: 1}
+
+// useDefaultPasswordHashing replaces the service by one that hashes passwords with
+// the default parameters.
+func (st *svcTest) useDefaultPasswordHashing() {
+	st.cfg.PasswordHashing = krypto.DefaultArgon2Params()
+
+	svc, err := auth.NewService(st.store, st.emailer, st.errList.AppendErr, st.cfg)
+	if err != nil {
+		st.t.Fatalf("failed to create service: %v", err)
+	}
+
+	svc.NowFunc = st.svc.NowFunc
+	st.svc = svc
+}
+
+func (st *svcTest) registerUser() (auth.Credentials, auth.EmailTokenRaw) {
+	credentials := auth.Credentials{
+		Email:    must(email.ParseAddress("info@example.com")),
+		Password: must(auth.ParsePassword("reallyStrongPassword1")),
+	}
+	err := st.svc.RegisterUser(context.Background(), auth.Registration{
+		Credentials: credentials,
+		Role:        auth.RoleAgent,
+	})
+	if err != nil {
+		st.t.Fatalf("failed to register user: %v", err)
+	}
+
+	// wait for the service goroutine to finish registering.
+	st.svc.Wait()
+	st.errList.assertNoError(st.t)
+
+	// Get the raw email token.
+	last := st.emailer.lastEmail(st.t)
+	raw, ok := last.data.(auth.EmailTokenRaw)
+	if !ok {
+		st.t.Fatalf("unexpected data type: %T", last.data)
+	}
+
+	return credentials, raw
+}
+
+func (st *svcTest) activateUser(req auth.EmailTokenRaw) {
+	err := st.svc.ActivateUser(context.Background(), req)
+	if err != nil {
+		st.t.Fatalf("failed to activate user: %v", err)
+	}
+
+	// wait for the service goroutine to finish activating.
+	st.svc.Wait()
+	st.errList.assertNoError(st.t)
+}
+
+func (st *svcTest) requestPasswordReset(email email.Address) auth.EmailTokenRaw {
+	st.svc.RequestPasswordReset(context.Background(), email)
+
+	// wait for the service goroutine to finish requesting.
+	st.svc.Wait()
+	st.errList.assertNoError(st.t)
+
+	// Get the raw email token
+	last := st.emailer.lastEmail(st.t)
+	raw, ok := last.data.(auth.EmailTokenRaw)
+	if !ok {
+		st.t.Fatalf("unexpected data type: %T", last.data)
+	}
+
+	return raw
+}
+
+func (st *svcTest) resetPassword(np auth.NewPassword) {
+	err := st.svc.ResetPassword(context.Background(), np)
+	if err != nil {
+		st.t.Fatalf("failed to reset password: %v", err)
+	}
+
+	// wait for the service goroutine to finish resetting.
+	st.svc.Wait()
+	st.errList.assertNoError(st.t)
+}
+
+func (st *svcTest) requestEmailChange(userID uuid.UUID, addr email.Address) auth.EmailTokenRaw {
+	err := st.svc.RequestEmailChange(context.Background(), userID, addr)
+	if err != nil {
+		st.t.Fatalf("failed to request email change: %v", err)
+	}
+
+	// wait for the service goroutine to finish requesting.
+	st.svc.Wait()
+	st.errList.assertNoError(st.t)
+
+	// Get the raw email token
+	last := st.emailer.lastEmail(st.t)
+	raw, ok := last.data.(auth.EmailTokenRaw)
+	if !ok {
+		st.t.Fatalf("unexpected data type: %T", last.data)
+	}
+
+	return raw
+}
+
+func (st *svcTest) requestAccountDeletion(userID uuid.UUID) auth.EmailTokenRaw {
+	err := st.svc.RequestAccountDeletion(context.Background(), userID)
+	if err != nil {
+		st.t.Fatalf("failed to request account deletion: %v", err)
+	}
+
+	// Get the raw email token
+	last := st.emailer.lastEmail(st.t)
+	raw, ok := last.data.(auth.EmailTokenRaw)
+	if !ok {
+		st.t.Fatalf("unexpected data type: %T", last.data)
+	}
+
+	return raw
+}
+
+// registerOtherUser registers a user with the provided email address, without activating it.
+func (st *svcTest) registerOtherUser(addr email.Address) {
+	err := st.svc.RegisterUser(context.Background(), auth.Registration{
+		Credentials: auth.Credentials{
+			Email:    addr,
+			Password: must(auth.ParsePassword("reallyStrongPassword1")),
+		},
+		Role: auth.RoleHunter,
+	})
+	if err != nil {
+		st.t.Fatalf("failed to register user: %v", err)
+	}
+
+	// wait for the service goroutine to finish registering.
+	st.svc.Wait()
+	st.errList.assertNoError(st.t)
+}
+
+// createSession creates a session for the user with the provided email address.
+func (st *svcTest) createSession(addr email.Address) {
+	user := st.findUser(addr)
+
+	err := st.sessions.CreateRecord(context.Background(), sessions.Record{
+		ID:        must(uuid.NewRandom()),
+		UserID:    &user.ID,
+		Data:      []byte{},
+		CreatedAt: time.Now(),
+		UpdatedAt: time.Now(),
+		ExpiresAt: time.Now().Add(time.Hour),
+	})
+	if err != nil {
+		st.t.Fatalf("failed to create session: %v", err)
+	}
 }
 
-func newServiceTest(t *testing.T) *svcTest {
-	encryptor := must(krypto.NewEncryptor([]krypto.Key{
-		must(krypto.ParseKey("2b671594b775f371eab4050b4d58326682df6b1a6cc2e886717b1a26b4d6c45d")),
-	}))
+func (st *svcTest) assertNoSessions(addr email.Address) {
+	user := st.findUser(addr)
 
-	indexKey := must(krypto.ParseKey("90303dfed7994260ea4817a5ca8a392915cd401115b2f97495dadfcbcd14adbf"))
+	records, err := st.sessions.FindRecords(context.Background(), sessions.RecordFilter{
+		UserIDs: []uuid.UUID{user.ID},
+	})
+	if err != nil {
+		st.t.Fatalf("failed to find sessions: %v", err)
+	}
 
-	testDB := testdb.RunWhile(t, true)
-	test := &svcTest{
-		t: t,
-		store: &testStore{
-			store:   db.New(testDB, testDB, encryptor, indexKey),
-			tracker: &testerr.Calltracker{}, // empty call trackers never fail.
-		},
-		errList: &errList{
-			mutex: &sync.Mutex{},
-			errs:  make([]error, 0),
-		},
-		emailer: &testEmailer{},
-		nowFunc: func() time.Time {
-			return time.Now().Round(0)
-		},
+	if len(records) != 0 {
+		st.t.Fatalf("expected no sessions, got %d", len(records))
+	}
+}
+
+func (st *svcTest) findUser(addr email.Address) auth.User {
+	users, err := st.store.store.FindUsers(context.Background(), auth.UserFilter{
+		Emails: []email.Address{addr},
+	})
+	if err != nil {
+		st.t.Fatalf("failed to find user: %v", err)
 	}
 
-	cfg := auth.ServiceConfig{
-		WorkerTimeout: time.Second,
-		TokenExpiry:   time.Hour,
+	if len(users) != 1 {
+		st.t.Fatalf("expected 1 user, got %d", len(users))
 	}
 
-	svc, err := auth.NewService(test.store, test.emailer, test.errList.AppendErr, cfg)
+	return users[0]
+}
+
+// registerAndActivateUser registers and activates a user, and returns it.
+// TOTP tests use a fixed time from here on.
+func (st *svcTest) registerAndActivateUser() auth.User {
+	credentials, tok := st.registerUser()
+	st.activateUser(tok)
+
+	st.svc.NowFunc = func() time.Time { return testNow }
+
+	return st.findUser(credentials.Email)
+}
+
+// enableTOTP enrolls and confirms TOTP for a user at testNow.
+func (st *svcTest) enableTOTP(userID uuid.UUID) (krypto.TOTPSecret, []krypto.RecoveryCode) {
+	enrollment, err := st.svc.EnrollTOTP(context.Background(), userID)
 	if err != nil {
-		t.Fatalf("failed to create service: %v", err)
+		st.t.Fatalf("failed to enroll: %v", err)
 	}
 
-	test.svc = svc
+	codes, err := st.svc.ConfirmTOTP(context.Background(), userID, enrollment.Secret.Code(testNow))
+	if err != nil {
+		st.t.Fatalf("failed to confirm: %v", err)
+	}
 
-	return test
+	return enrollment.Secret, codes
 }
 
-func (st *svcTest) registerUser() (auth.Credentials, auth.EmailTokenRaw) {
-	credentials := auth.Credentials{
-		Email:    must(email.ParseAddress("info@example.com")),
-		Password: must(auth.ParsePassword("reallyStrongPassword1")),
+func (st *svcTest) assertTOTPEnabled(userID uuid.UUID, want bool) {
+	st.t.Helper()
+
+	got, err := st.svc.TOTPEnabled(context.Background(), userID)
+	if err != nil {
+		st.t.Fatalf("failed to check totp: %v", err)
+	}
+
+	if got != want {
+		st.t.Fatalf("expected totp enabled to be %v, got %v", want, got)
 	}
-	err := st.svc.RegisterUser(context.Background(), credentials)
+}
+
+// registerPasskey registers a passkey for a user with a new software authenticator.
+func (st *svcTest) registerPasskey(userID uuid.UUID) (*webauthntest.Authenticator, auth.Passkey) {
+	st.t.Helper()
+
+	a, err := webauthntest.New(testWebAuthn.Origin)
 	if err != nil {
-		st.t.Fatalf("failed to register user: %v", err)
+		st.t.Fatalf("failed to create authenticator: %v", err)
 	}
 
-	// wait for the service goroutine to finish registering.
-	st.svc.Wait()
-	st.errList.assertNoError(st.t)
+	challenge := must(webauthn.GenerateChallenge())
+	opts, err := st.svc.PasskeyCreationOptions(context.Background(), userID, challenge)
+	if err != nil {
+		st.t.Fatalf("failed to get options: %v", err)
+	}
 
-	// Get the raw email token.
-	index := len(st.emailer.emails) - 1
-	raw, ok := st.emailer.emails[index].data.(auth.EmailTokenRaw)
-	if !ok {
-		st.t.Fatalf("unexpected data type: %T", st.emailer.emails[index].data)
+	resp, err := a.Register(opts)
+	if err != nil {
+		st.t.Fatalf("failed to create credential: %v", err)
 	}
 
-	return credentials, raw
+	passkey, err := st.svc.RegisterPasskey(context.Background(), userID, challenge, resp)
+	if err != nil {
+		st.t.Fatalf("failed to register passkey: %v", err)
+	}
+
+	return a, passkey
 }
 
-func (st *svcTest) activateUser(req auth.EmailTokenRaw) {
-	err := st.svc.ActivateUser(context.Background(), req)
+func (st *svcTest) assertPasskeys(userID uuid.UUID, want ...auth.Passkey) {
+	st.t.Helper()
+
+	got, err := st.svc.Passkeys(context.Background(), userID)
 	if err != nil {
-		st.t.Fatalf("failed to activate user: %v", err)
+		st.t.Fatalf("failed to find passkeys: %v", err)
 	}
 
-	// wait for the service goroutine to finish activating.
-	st.svc.Wait()
-	st.errList.assertNoError(st.t)
+	if want == nil {
+		want = []auth.Passkey{}
+	}
+
+	if !reflect.DeepEqual(got, want) {
+		st.t.Fatalf("got\n%#v\nwant\n%#v\n", got, want)
+	}
 }
 
-func (st *svcTest) requestPasswordReset(email email.Address) auth.EmailTokenRaw {
-	st.svc.RequestPasswordReset(context.Background(), email)
+func newAPIToken() auth.NewAPIToken {
+	return auth.NewAPIToken{
+		Name:          "CRM import",
+		Scopes:        []auth.Scope{auth.ScopeListingsWrite, auth.ScopeListingsRead, auth.ScopeListingsWrite},
+		ExpiresInDays: 30,
+	}
+}
 
-	// wait for the service goroutine to finish requesting.
-	st.svc.Wait()
-	st.errList.assertNoError(st.t)
+func (st *svcTest) createAPIToken(userID uuid.UUID) (auth.APIToken, auth.APITokenRaw) {
+	st.t.Helper()
 
-	// Get the raw email token
-	index := len(st.emailer.emails) - 1
-	raw, ok := st.emailer.emails[index].data.(auth.EmailTokenRaw)
-	if !ok {
-		st.t.Fatalf("unexpected data type: %T", st.emailer.emails[index].data)
+	apiToken, raw, err := st.svc.CreateAPIToken(context.Background(), userID, newAPIToken())
+	if err != nil {
+		st.t.Fatalf("failed to create API token: %v", err)
 	}
 
-	return raw
+	return apiToken, raw
 }
 
-func (st *svcTest) resetPassword(np auth.NewPassword) {
-	err := st.svc.ResetPassword(context.Background(), np)
+func (st *svcTest) assertAPITokens(userID uuid.UUID, want ...auth.APIToken) {
+	st.t.Helper()
+
+	got, err := st.svc.APITokens(context.Background(), userID)
 	if err != nil {
-		st.t.Fatalf("failed to reset password: %v", err)
+		st.t.Fatalf("failed to find API tokens: %v", err)
 	}
 
-	// wait for the service goroutine to finish resetting.
-	st.svc.Wait()
-	st.errList.assertNoError(st.t)
+	if want == nil {
+		want = []auth.APIToken{}
+	}
+
+	if !reflect.DeepEqual(got, want) {
+		st.t.Fatalf("got\n%#v\nwant\n%#v\n", got, want)
+	}
+}
+
+func (st *svcTest) assertEmailSuppressed(addr email.Address, want bool) {
+	st.t.Helper()
+
+	got, err := st.svc.EmailSuppressed(context.Background(), addr)
+	if err != nil {
+		st.t.Fatalf("failed to check suppression: %v", err)
+	}
+
+	if got != want {
+		st.t.Fatalf("expected suppressed to be %v for %s, got %v", want, addr, got)
+	}
+}
+
+func (st *svcTest) assertEmailBounced(userID uuid.UUID, want bool) {
+	st.t.Helper()
+
+	got, err := st.svc.EmailBounced(context.Background(), userID)
+	if err != nil {
+		st.t.Fatalf("failed to check bounce: %v", err)
+	}
+
+	if got != want {
+		st.t.Fatalf("expected bounced to be %v, got %v", want, got)
+	}
 }
 
 func (st *svcTest) authenticate(credentials auth.Credentials) bool {
@@ -922,21 +3321,61 @@ func (f *testStore) FindUsers(ctx context.Context, filter auth.UserFilter) ([]au
 	})
 }
 
+func (f *testStore) CountAttempts(ctx context.Context, filter auth.AttemptFilter) (int, error) {
+	return testerr.MaybeFail(f.tracker, func() (int, error) {
+		return f.store.CountAttempts(ctx, filter)
+	})
+}
+
+func (f *testStore) FindTOTPs(ctx context.Context, filter auth.TOTPFilter) ([]auth.TOTP, error) {
+	return testerr.MaybeFail(f.tracker, func() ([]auth.TOTP, error) {
+		return f.store.FindTOTPs(ctx, filter)
+	})
+}
+
+func (f *testStore) FindPasskeys(ctx context.Context, filter auth.PasskeyFilter) ([]auth.Passkey, error) {
+	return testerr.MaybeFail(f.tracker, func() ([]auth.Passkey, error) {
+		return f.store.FindPasskeys(ctx, filter)
+	})
+}
+
+func (f *testStore) FindAPITokens(ctx context.Context, filter auth.APITokenFilter) ([]auth.APIToken, error) {
+	return testerr.MaybeFail(f.tracker, func() ([]auth.APIToken, error) {
+		return f.store.FindAPITokens(ctx, filter)
+	})
+}
+
+func (f *testStore) CountEmailBounces(ctx context.Context, filter auth.EmailBounceFilter) (int, error) {
+	return testerr.MaybeFail(f.tracker, func() (int, error) {
+		return f.store.CountEmailBounces(ctx, filter)
+	})
+}
+
 type testTx struct {
 	store *testStore
 	tx    auth.Tx
 }
 
+// Commit and Rollback end the real transaction even if a failure is simulated,
+// otherwise the test database can't be queried for queued emails afterwards.
 func (tx *testTx) Commit() error {
-	return testerr.MaybeFailErrFunc(tx.store.tracker, func() error {
+	err := testerr.MaybeFailErrFunc(tx.store.tracker, func() error {
 		return tx.tx.Commit()
 	})
+	if err != nil {
+		_ = tx.tx.Rollback()
+	}
+	return err
 }
 
 func (tx *testTx) Rollback() error {
-	return testerr.MaybeFailErrFunc(tx.store.tracker, func() error {
+	err := testerr.MaybeFailErrFunc(tx.store.tracker, func() error {
 		return tx.tx.Rollback()
 	})
+	if err != nil {
+		_ = tx.tx.Rollback()
+	}
+	return err
 }
 
 func (tx *testTx) CreateUser(u auth.User) error {
@@ -951,6 +3390,12 @@ func (tx *testTx) UpdateUser(u auth.User) error {
 	})
 }
 
+func (tx *testTx) DeleteUser(id uuid.UUID) error {
+	return testerr.MaybeFailErrFunc(tx.store.tracker, func() error {
+		return tx.tx.DeleteUser(id)
+	})
+}
+
 func (tx *testTx) FindUsers(filter auth.UserFilter) ([]auth.User, error) {
 	return testerr.MaybeFail(tx.store.tracker, func() ([]auth.User, error) {
 		return tx.tx.FindUsers(filter)
@@ -969,45 +3414,254 @@ func (tx *testTx) UpdateEmailToken(t auth.EmailToken) error {
 	})
 }
 
+func (tx *testTx) DeleteEmailTokens(filter auth.EmailTokenFilter) error {
+	return testerr.MaybeFailErrFunc(tx.store.tracker, func() error {
+		return tx.tx.DeleteEmailTokens(filter)
+	})
+}
+
 func (tx *testTx) FindEmailTokens(filter auth.EmailTokenFilter) ([]auth.EmailToken, error) {
 	return testerr.MaybeFail(tx.store.tracker, func() ([]auth.EmailToken, error) {
 		return tx.tx.FindEmailTokens(filter)
 	})
 }
 
+func (tx *testTx) CountEmailTokens(filter auth.EmailTokenFilter) (int, error) {
+	return testerr.MaybeFail(tx.store.tracker, func() (int, error) {
+		return tx.tx.CountEmailTokens(filter)
+	})
+}
+
+func (tx *testTx) CreateAttempt(a auth.Attempt) error {
+	return testerr.MaybeFailErrFunc(tx.store.tracker, func() error {
+		return tx.tx.CreateAttempt(a)
+	})
+}
+
+func (tx *testTx) CountAttempts(filter auth.AttemptFilter) (int, error) {
+	return testerr.MaybeFail(tx.store.tracker, func() (int, error) {
+		return tx.tx.CountAttempts(filter)
+	})
+}
+
+func (tx *testTx) DeleteAttempts(filter auth.AttemptFilter) error {
+	return testerr.MaybeFailErrFunc(tx.store.tracker, func() error {
+		return tx.tx.DeleteAttempts(filter)
+	})
+}
+
+func (tx *testTx) CreateTOTP(c auth.TOTP) error {
+	return testerr.MaybeFailErrFunc(tx.store.tracker, func() error {
+		return tx.tx.CreateTOTP(c)
+	})
+}
+
+func (tx *testTx) UpdateTOTP(c auth.TOTP) error {
+	return testerr.MaybeFailErrFunc(tx.store.tracker, func() error {
+		return tx.tx.UpdateTOTP(c)
+	})
+}
+
+func (tx *testTx) DeleteTOTPs(filter auth.TOTPFilter) error {
+	return testerr.MaybeFailErrFunc(tx.store.tracker, func() error {
+		return tx.tx.DeleteTOTPs(filter)
+	})
+}
+
+func (tx *testTx) FindTOTPs(filter auth.TOTPFilter) ([]auth.TOTP, error) {
+	return testerr.MaybeFail(tx.store.tracker, func() ([]auth.TOTP, error) {
+		return tx.tx.FindTOTPs(filter)
+	})
+}
+
+func (tx *testTx) CreateRecoveryCode(c auth.RecoveryCode) error {
+	return testerr.MaybeFailErrFunc(tx.store.tracker, func() error {
+		return tx.tx.CreateRecoveryCode(c)
+	})
+}
+
+func (tx *testTx) UpdateRecoveryCode(c auth.RecoveryCode) error {
+	return testerr.MaybeFailErrFunc(tx.store.tracker, func() error {
+		return tx.tx.UpdateRecoveryCode(c)
+	})
+}
+
+func (tx *testTx) DeleteRecoveryCodes(filter auth.RecoveryCodeFilter) error {
+	return testerr.MaybeFailErrFunc(tx.store.tracker, func() error {
+		return tx.tx.DeleteRecoveryCodes(filter)
+	})
+}
+
+func (tx *testTx) FindRecoveryCodes(filter auth.RecoveryCodeFilter) ([]auth.RecoveryCode, error) {
+	return testerr.MaybeFail(tx.store.tracker, func() ([]auth.RecoveryCode, error) {
+		return tx.tx.FindRecoveryCodes(filter)
+	})
+}
+
+func (tx *testTx) CreatePasskey(p auth.Passkey) error {
+	return testerr.MaybeFailErrFunc(tx.store.tracker, func() error {
+		return tx.tx.CreatePasskey(p)
+	})
+}
+
+func (tx *testTx) UpdatePasskey(p auth.Passkey) error {
+	return testerr.MaybeFailErrFunc(tx.store.tracker, func() error {
+		return tx.tx.UpdatePasskey(p)
+	})
+}
+
+func (tx *testTx) DeletePasskeys(filter auth.PasskeyFilter) error {
+	return testerr.MaybeFailErrFunc(tx.store.tracker, func() error {
+		return tx.tx.DeletePasskeys(filter)
+	})
+}
+
+func (tx *testTx) FindPasskeys(filter auth.PasskeyFilter) ([]auth.Passkey, error) {
+	return testerr.MaybeFail(tx.store.tracker, func() ([]auth.Passkey, error) {
+		return tx.tx.FindPasskeys(filter)
+	})
+}
+
+func (tx *testTx) CreateAPIToken(t auth.APIToken) error {
+	return testerr.MaybeFailErrFunc(tx.store.tracker, func() error {
+		return tx.tx.CreateAPIToken(t)
+	})
+}
+
+func (tx *testTx) UpdateAPIToken(t auth.APIToken) error {
+	return testerr.MaybeFailErrFunc(tx.store.tracker, func() error {
+		return tx.tx.UpdateAPIToken(t)
+	})
+}
+
+func (tx *testTx) DeleteAPITokens(filter auth.APITokenFilter) error {
+	return testerr.MaybeFailErrFunc(tx.store.tracker, func() error {
+		return tx.tx.DeleteAPITokens(filter)
+	})
+}
+
+func (tx *testTx) FindAPITokens(filter auth.APITokenFilter) ([]auth.APIToken, error) {
+	return testerr.MaybeFail(tx.store.tracker, func() ([]auth.APIToken, error) {
+		return tx.tx.FindAPITokens(filter)
+	})
+}
+
+func (tx *testTx) FindEmailBounces(filter auth.EmailBounceFilter) ([]auth.EmailBounce, error) {
+	return testerr.MaybeFail(tx.store.tracker, func() ([]auth.EmailBounce, error) {
+		return tx.tx.FindEmailBounces(filter)
+	})
+}
+
+func (tx *testTx) CreateEmailBounce(b auth.EmailBounce) error {
+	return testerr.MaybeFailErrFunc(tx.store.tracker, func() error {
+		return tx.tx.CreateEmailBounce(b)
+	})
+}
+
+func (tx *testTx) RevokeSessions(userID uuid.UUID) error {
+	return testerr.MaybeFailErrFunc(tx.store.tracker, func() error {
+		return tx.tx.RevokeSessions(userID)
+	})
+}
+
+func (tx *testTx) DeleteExpiredSessions(now time.Time) error {
+	return testerr.MaybeFailErrFunc(tx.store.tracker, func() error {
+		return tx.tx.DeleteExpiredSessions(now)
+	})
+}
+
+func (tx *testTx) CreateOutboxMessage(m outbox.Message) error {
+	return testerr.MaybeFailErrFunc(tx.store.tracker, func() error {
+		return tx.tx.CreateOutboxMessage(m)
+	})
+}
+
+func (tx *testTx) DeleteOutboxMessages(recipient email.Address) error {
+	return testerr.MaybeFailErrFunc(tx.store.tracker, func() error {
+		return tx.tx.DeleteOutboxMessages(recipient)
+	})
+}
+
 type sendEmail struct {
 	template  string
 	recipient email.Address
 	data      interface{}
 }
 
+// testEmailer keeps track of the rendered emails, the rendered messages
+// only contain a reference to this list. This way the tests can check which emails
+// ended up in the outbox, and with which data.
 type testEmailer struct {
-	emails  []sendEmail
-	testErr error
+	outbox   outbox.Store
+	rendered []sendEmail
+	cleared  int
+	testErr  error
 }
 
 func (e *testEmailer) clearEmails() {
-	e.emails = nil
+	e.cleared = len(e.rendered)
 }
 
-func (e *testEmailer) Send(_ context.Context, template string, to email.Address, data interface{}) error {
-	e.emails = append(e.emails, sendEmail{
+func (e *testEmailer) Render(template string, to email.Address, data any) (email.Message, error) {
+	e.rendered = append(e.rendered, sendEmail{
 		template:  template,
 		recipient: to,
 		data:      data,
 	})
 
-	return e.testErr
+	return email.Message{
+		From:      "noreply@example.com",
+		Recipient: to,
+		Subject:   template,
+		Body:      strconv.Itoa(len(e.rendered) - 1),
+	}, e.testErr
 }
 
-func (e *testEmailer) assertLastEmail(t *testing.T, template string, recipient email.Address, dataFunc func(t *testing.T, data any)) {
+// queuedEmails returns the emails in the outbox that were queued since the last clear.
+func (e *testEmailer) queuedEmails(t *testing.T) []sendEmail {
+	t.Helper()
+
+	msgs, err := e.outbox.FindMessages(context.Background(), outbox.MessageFilter{})
+	if err != nil {
+		t.Fatalf("failed to find outbox messages: %v", err)
+	}
+
+	indices := make([]int, 0, len(msgs))
+	for _, m := range msgs {
+		i, err := strconv.Atoi(m.Body)
+		if err != nil || i >= len(e.rendered) {
+			t.Fatalf("unexpected message body in outbox: %q", m.Body)
+		}
+
+		if i >= e.cleared {
+			indices = append(indices, i)
+		}
+	}
+	slices.Sort(indices)
+
+	out := make([]sendEmail, 0, len(indices))
+	for _, i := range indices {
+		out = append(out, e.rendered[i])
+	}
+
+	return out
+}
+
+func (e *testEmailer) lastEmail(t *testing.T) sendEmail {
 	t.Helper()
 
-	if len(e.emails) == 0 {
+	emails := e.queuedEmails(t)
+	if len(emails) == 0 {
 		t.Fatalf("expected an email, got none")
 	}
 
-	last := e.emails[len(e.emails)-1]
+	return emails[len(emails)-1]
+}
+
+func (e *testEmailer) assertLastEmail(t *testing.T, template string, recipient email.Address, dataFunc func(t *testing.T, data any)) {
+	t.Helper()
+
+	last := e.lastEmail(t)
 	if last.template != template {
 		t.Fatalf("wanted last email to use template %s, got %s", template, last.template)
 	}
@@ -1024,7 +3678,8 @@ func (e *testEmailer) assertLastEmail(t *testing.T, template string, recipient e
 func (e *testEmailer) assertNoEmails(t *testing.T) {
 	t.Helper()
 
-	if len(e.emails) != 0 {
-		t.Fatalf("expected no emails, got %d", len(e.emails))
+	emails := e.queuedEmails(t)
+	if len(emails) != 0 {
+		t.Fatalf("expected no emails, got %d", len(emails))
 	}
 }
@@ -2,9 +2,11 @@ package auth
 
 import (
 	"context"
+	"time"
 
 	"github.com/google/uuid"
 	"github.com/willemschots/househunt/internal/email"
+	"github.com/willemschots/househunt/internal/email/outbox"
 )
 
 // UserFilter is used filter users.
@@ -13,7 +15,10 @@ import (
 type UserFilter struct {
 	IDs      []uuid.UUID
 	Emails   []email.Address
+	Roles    []Role
 	IsActive *bool
+	// CreatedBefore only matches users created before this time.
+	CreatedBefore *time.Time
 }
 
 // EmailTokenFilter is used to filter email tokens.
@@ -24,6 +29,64 @@ type EmailTokenFilter struct {
 	UserIDs    []uuid.UUID
 	Purposes   []TokenPurpose
 	IsConsumed *bool
+	// CreatedAfter only matches tokens created after this time.
+	CreatedAfter *time.Time
+	// CreatedBefore only matches tokens created before this time.
+	CreatedBefore *time.Time
+}
+
+// AttemptFilter is used to filter attempts.
+// Counted attempts must match all the provided fields.
+// If a field is empty or nil, it's ignored.
+type AttemptFilter struct {
+	Emails   []email.Address
+	Purposes []AttemptPurpose
+	// CreatedAfter only matches attempts created after this time.
+	CreatedAfter *time.Time
+	// CreatedBefore only matches attempts created before this time.
+	CreatedBefore *time.Time
+}
+
+// TOTPFilter is used to filter TOTP credentials.
+// Returned credentials must match all the provided fields.
+// If a field is empty or nil, it's ignored.
+type TOTPFilter struct {
+	UserIDs     []uuid.UUID
+	IsConfirmed *bool
+}
+
+// RecoveryCodeFilter is used to filter recovery codes.
+// Returned codes must match all the provided fields.
+// If a field is empty or nil, it's ignored.
+type RecoveryCodeFilter struct {
+	IDs     []uuid.UUID
+	UserIDs []uuid.UUID
+	IsUsed  *bool
+}
+
+// PasskeyFilter is used to filter passkeys.
+// Returned passkeys must match all the provided fields.
+// If a field is empty or nil, it's ignored.
+type PasskeyFilter struct {
+	IDs           []uuid.UUID
+	UserIDs       []uuid.UUID
+	CredentialIDs [][]byte
+}
+
+// APITokenFilter is used to filter API tokens.
+// Returned tokens must match all the provided fields.
+// If a field is empty or nil, it's ignored.
+type APITokenFilter struct {
+	IDs     []uuid.UUID
+	UserIDs []uuid.UUID
+}
+
+// EmailBounceFilter is used to filter email bounces.
+// Counted bounces must match all the provided fields.
+// If a field is empty or nil, it's ignored.
+type EmailBounceFilter struct {
+	UserIDs []uuid.UUID
+	Emails  []email.Address
 }
 
 // Store provides access to the user store.
@@ -31,6 +94,11 @@ type Store interface {
 	BeginTx(ctx context.Context) (Tx, error)
 
 	FindUsers(ctx context.Context, filter UserFilter) ([]User, error)
+	CountAttempts(ctx context.Context, filter AttemptFilter) (int, error)
+	FindTOTPs(ctx context.Context, filter TOTPFilter) ([]TOTP, error)
+	FindPasskeys(ctx context.Context, filter PasskeyFilter) ([]Passkey, error)
+	FindAPITokens(ctx context.Context, filter APITokenFilter) ([]APIToken, error)
+	CountEmailBounces(ctx context.Context, filter EmailBounceFilter) (int, error)
 }
 
 // Tx is a transaction. If an error occurs on any of the Create/Update/Find methods,
@@ -42,9 +110,49 @@ type Tx interface {
 
 	CreateUser(u User) error
 	UpdateUser(u User) error
+	// DeleteUser deletes a user and all data that belongs to it.
+	DeleteUser(id uuid.UUID) error
 	FindUsers(filter UserFilter) ([]User, error)
 
 	CreateEmailToken(t EmailToken) error
 	UpdateEmailToken(t EmailToken) error
+	DeleteEmailTokens(filter EmailTokenFilter) error
 	FindEmailTokens(filter EmailTokenFilter) ([]EmailToken, error)
+	CountEmailTokens(filter EmailTokenFilter) (int, error)
+
+	CreateAttempt(a Attempt) error
+	CountAttempts(filter AttemptFilter) (int, error)
+	DeleteAttempts(filter AttemptFilter) error
+
+	CreateTOTP(t TOTP) error
+	UpdateTOTP(t TOTP) error
+	DeleteTOTPs(filter TOTPFilter) error
+	FindTOTPs(filter TOTPFilter) ([]TOTP, error)
+
+	CreateRecoveryCode(c RecoveryCode) error
+	UpdateRecoveryCode(c RecoveryCode) error
+	DeleteRecoveryCodes(filter RecoveryCodeFilter) error
+	FindRecoveryCodes(filter RecoveryCodeFilter) ([]RecoveryCode, error)
+
+	CreatePasskey(p Passkey) error
+	UpdatePasskey(p Passkey) error
+	DeletePasskeys(filter PasskeyFilter) error
+	FindPasskeys(filter PasskeyFilter) ([]Passkey, error)
+
+	CreateAPIToken(t APIToken) error
+	UpdateAPIToken(t APIToken) error
+	DeleteAPITokens(filter APITokenFilter) error
+	FindAPITokens(filter APITokenFilter) ([]APIToken, error)
+
+	CreateEmailBounce(b EmailBounce) error
+	FindEmailBounces(filter EmailBounceFilter) ([]EmailBounce, error)
+
+	// RevokeSessions revokes all sessions of a user, logging them out on every device.
+	RevokeSessions(userID uuid.UUID) error
+	// DeleteExpiredSessions deletes all sessions that expired at or before now.
+	DeleteExpiredSessions(now time.Time) error
+
+	CreateOutboxMessage(m outbox.Message) error
+	// DeleteOutboxMessages deletes all queued and sent emails to the recipient.
+	DeleteOutboxMessages(recipient email.Address) error
 }
@@ -0,0 +1,39 @@
+package auth
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/willemschots/househunt/internal/krypto"
+)
+
+// TOTP is a time-based one-time password credential, used as a second factor
+// during login. A credential only becomes active after it is confirmed with a
+// valid code, so that users can't lock themselves out with a misconfigured app.
+type TOTP struct {
+	UserID uuid.UUID
+	Secret krypto.TOTPSecret
+	// LastUsedStep is the time step of the last accepted code. Codes for this or
+	// earlier steps are rejected, so that a code can't be used twice.
+	LastUsedStep int64
+	ConfirmedAt  *time.Time
+	CreatedAt    time.Time
+	UpdatedAt    time.Time
+}
+
+// TOTPEnrollment contains the data required to add a TOTP secret to an authenticator app.
+type TOTPEnrollment struct {
+	Secret krypto.TOTPSecret
+	// URI is the otpauth URI, usually presented as a QR code.
+	URI string
+}
+
+// RecoveryCode can be used once instead of a TOTP code.
+type RecoveryCode struct {
+	ID     uuid.UUID
+	UserID uuid.UUID
+	// CodeHash is the hash of the code, the code itself is only shown to the user once.
+	CodeHash  krypto.Argon2Hash
+	CreatedAt time.Time
+	UsedAt    *time.Time
+}
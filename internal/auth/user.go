@@ -14,6 +14,7 @@ type User struct {
 	ID           uuid.UUID
 	Email        email.Address
 	PasswordHash krypto.Argon2Hash
+	Role         Role
 	IsActive     bool
 	CreatedAt    time.Time
 	UpdatedAt    time.Time
@@ -24,3 +25,9 @@ type Credentials struct {
 	Password Password
 	Email    email.Address
 }
+
+// Registration contains the data required to register a new user.
+type Registration struct {
+	Credentials
+	Role Role
+}
@@ -13,11 +13,11 @@ import (
 //
 // The zero value is ready to use.
 type Query struct {
-	Encryptor     *krypto.Encryptor
-	BlindIndexKey krypto.Key
-	b             strings.Builder
-	params        []any
-	err           error
+	Encryptor    *krypto.Encryptor
+	BlindIndexer *krypto.BlindIndexer
+	b            strings.Builder
+	params       []any
+	err          error
 }
 
 // Unsafe writes a non-parameterized part of a query.
@@ -48,17 +48,43 @@ func (q *Query) ParamEncrypted(d []byte) {
 }
 
 // ParamBlindIndex writes a parameterized part of a query and adds a blind index of the value to the query.
-// Important Note: The blind indexes will need to be rebuild if the key or argon2 parameters change.
+// The blind index is created with the latest key, use ParamBlindIndexes when looking up values.
 func (q *Query) ParamBlindIndex(d []byte) {
-	hash, err := krypto.HashArgon2WithKey(d, q.BlindIndexKey)
+	if q.BlindIndexer == nil {
+		q.err = errors.New("no blind indexer set")
+		return
+	}
+
+	index, err := q.BlindIndexer.Index(d)
 	if err != nil {
 		q.err = errors.Join(q.err, err)
 		return
 	}
 
-	// overwrite the salt because we don't want to store it.
-	hash.Salt = nil
-	q.Param(hash.String())
+	q.Param(index)
+}
+
+// ParamBlindIndexes writes the blind indexes of the value for every key of the blind indexer
+// as parameters seperated by commas. This allows values to be found while the blind indexes
+// are being rebuilt with a new key.
+func (q *Query) ParamBlindIndexes(d []byte) {
+	if q.BlindIndexer == nil {
+		q.err = errors.New("no blind indexer set")
+		return
+	}
+
+	indexes, err := q.BlindIndexer.Indexes(d)
+	if err != nil {
+		q.err = errors.Join(q.err, err)
+		return
+	}
+
+	for i, index := range indexes {
+		if i > 0 {
+			q.b.WriteString(", ")
+		}
+		q.Param(index)
+	}
 }
 
 // Params writes multiple parameterized parts of a query seperated by commas.
@@ -89,7 +115,13 @@ type Decryptable struct {
 	Data      []byte
 }
 
+// Scan decrypts src, NULL values are not encrypted and result in nil Data.
 func (d *Decryptable) Scan(src any) error {
+	if src == nil {
+		d.Data = nil
+		return nil
+	}
+
 	b, ok := src.([]byte)
 	if !ok {
 		return errors.New("invalid type")
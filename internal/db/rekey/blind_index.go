@@ -0,0 +1,154 @@
+package rekey
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/willemschots/househunt/internal/db"
+	"github.com/willemschots/househunt/internal/krypto"
+)
+
+// BlindIndex is a blind index column. The index is rebuilt from the decrypted value
+// of the Source column. Rows of blind indexes without a Source can't be rebuilt, they
+// are left in place while lookups still use their key and deleted once it's retired.
+type BlindIndex struct {
+	Column
+	Source string
+}
+
+// BlindIndexes contains all blind index columns in the database.
+// New blind index columns should be added here, otherwise they won't be rebuilt.
+var BlindIndexes = []BlindIndex{
+	{Column: Column{Table: "users", KeyColumn: "id", Name: "email_blind_index"}, Source: "email_encrypted"},
+	// Attempts are only counted for a limited time, the cleaner deletes those of an older
+	// key long before it's retired.
+	{Column: Column{Table: "attempts", KeyColumn: "id", Name: "email_blind_index"}},
+	{Column: Column{Table: "email_bounces", KeyColumn: "id", Name: "email_blind_index"}, Source: "email_encrypted"},
+}
+
+// RebuildBlindIndex rebuilds the values in idx that were not created with the latest key
+// of the blind indexer. Like Rekey, every batch is updated in its own transaction. The app
+// can keep running, because lookups use the indexes of all keys that are not retired.
+func (r *Rekeyer) RebuildBlindIndex(ctx context.Context, idx BlindIndex, progress func(Progress)) (Progress, error) {
+	if r.indexer == nil {
+		return Progress{Column: idx.Column}, errors.New("no blind indexer set")
+	}
+
+	columns := []string{idx.Name}
+	if idx.Source != "" {
+		columns = append(columns, idx.Source)
+	}
+
+	latest := r.indexer.LatestKeyIndex()
+	p := Progress{Column: idx.Column}
+
+	err := r.walk(ctx, idx.Table, idx.KeyColumn, columns, func(tx *sql.Tx, rows []row) error {
+		for _, rw := range rows {
+			index, err := krypto.BlindIndexKeyIndex(string(rw.values[0]))
+			if err != nil {
+				return fmt.Errorf("%s of %s: %w", idx, rw.key, err)
+			}
+
+			p.Scanned++
+			if index == latest {
+				continue
+			}
+
+			if idx.Source == "" {
+				// Deleting rows that are still found would reset the rate limits they count for.
+				if !r.indexer.IsRetired(index) {
+					continue
+				}
+
+				deleted, err := r.deleteRow(tx, idx, rw)
+				if err != nil {
+					return fmt.Errorf("%s of %s: %w", idx, rw.key, err)
+				}
+
+				if deleted {
+					p.Deleted++
+				}
+				continue
+			}
+
+			updated, err := r.reindex(tx, idx, rw)
+			if err != nil {
+				return fmt.Errorf("%s of %s: %w", idx, rw.key, err)
+			}
+
+			if updated {
+				p.Rekeyed++
+			}
+		}
+
+		if progress != nil {
+			progress(p)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return p, err
+	}
+
+	return p, nil
+}
+
+// BlindIndexKeyUsage counts the values in idx per index of the key they were created with.
+func (r *Rekeyer) BlindIndexKeyUsage(ctx context.Context, idx BlindIndex) (map[int]int, error) {
+	usage := make(map[int]int)
+
+	err := r.walk(ctx, idx.Table, idx.KeyColumn, []string{idx.Name}, func(_ *sql.Tx, rows []row) error {
+		for _, rw := range rows {
+			index, err := krypto.BlindIndexKeyIndex(string(rw.values[0]))
+			if err != nil {
+				return fmt.Errorf("%s of %s: %w", idx, rw.key, err)
+			}
+
+			usage[index]++
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return usage, nil
+}
+
+// reindex replaces the blind index of the row with one created with the latest key. The
+// index is only updated if neither it nor its source changed since they were read, it
+// reports whether it was updated.
+func (r *Rekeyer) reindex(tx *sql.Tx, idx BlindIndex, rw row) (bool, error) {
+	data, err := r.encryptor.Decrypt(rw.values[1])
+	if err != nil {
+		return false, err
+	}
+
+	q := db.Query{BlindIndexer: r.indexer}
+	q.Unsafe(`UPDATE ` + idx.Table + ` SET ` + idx.Name + ` = `)
+	q.ParamBlindIndex(data)
+	q.Unsafe(` WHERE ` + idx.KeyColumn + ` = `)
+	q.Param(rw.key)
+	q.Unsafe(` AND ` + idx.Name + ` = `)
+	q.Param(string(rw.values[0]))
+	q.Unsafe(` AND ` + idx.Source + ` = `)
+	q.Param(rw.values[1])
+
+	return execAffected(tx, q)
+}
+
+// deleteRow deletes the row if its blind index didn't change since it was read,
+// it reports whether it was deleted.
+func (r *Rekeyer) deleteRow(tx *sql.Tx, idx BlindIndex, rw row) (bool, error) {
+	q := db.Query{}
+	q.Unsafe(`DELETE FROM ` + idx.Table + ` WHERE ` + idx.KeyColumn + ` = `)
+	q.Param(rw.key)
+	q.Unsafe(` AND ` + idx.Name + ` = `)
+	q.Param(string(rw.values[0]))
+
+	return execAffected(tx, q)
+}
@@ -0,0 +1,270 @@
+package rekey
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/willemschots/househunt/internal/db"
+	"github.com/willemschots/househunt/internal/krypto"
+)
+
+// Column is an encrypted column, its rows are identified by KeyColumn.
+type Column struct {
+	Table     string
+	KeyColumn string
+	Name      string
+}
+
+func (c Column) String() string {
+	return c.Table + "." + c.Name
+}
+
+// Columns contains all encrypted columns in the database.
+// New encrypted columns should be added here, otherwise they won't be rekeyed.
+var Columns = []Column{
+	{Table: "users", KeyColumn: "id", Name: "email_encrypted"},
+	{Table: "email_tokens", KeyColumn: "id", Name: "email_encrypted"},
+	{Table: "email_outbox", KeyColumn: "id", Name: "recipient_encrypted"},
+	{Table: "email_outbox", KeyColumn: "id", Name: "subject_encrypted"},
+	{Table: "email_outbox", KeyColumn: "id", Name: "body_encrypted"},
+	{Table: "email_outbox", KeyColumn: "id", Name: "html_body_encrypted"},
+	{Table: "email_outbox", KeyColumn: "id", Name: "last_error_encrypted"},
+	{Table: "totp_credentials", KeyColumn: "user_id", Name: "secret_encrypted"},
+	{Table: "email_bounces", KeyColumn: "id", Name: "email_encrypted"},
+}
+
+// Progress describes how far the rekeying of a column is.
+type Progress struct {
+	Column Column
+	// Scanned is the number of values that were checked.
+	Scanned int
+	// Rekeyed is the number of values that were re-encrypted or rebuilt with the latest key.
+	Rekeyed int
+	// Deleted is the number of rows that were deleted because their blind index
+	// could not be rebuilt.
+	Deleted int
+}
+
+// Rekeyer re-encrypts the values in encrypted columns with the latest key of an
+// encryptor and rebuilds blind indexes with the latest key of a blind indexer,
+// so that older keys can be retired.
+type Rekeyer struct {
+	db        *sql.DB
+	encryptor *krypto.Encryptor
+	indexer   *krypto.BlindIndexer
+	batchSize int
+}
+
+// New creates a new Rekeyer, db should be a write handle.
+func New(db *sql.DB, encryptor *krypto.Encryptor, indexer *krypto.BlindIndexer, batchSize int) (*Rekeyer, error) {
+	if batchSize < 1 {
+		return nil, errors.New("batch size should be at least 1")
+	}
+
+	return &Rekeyer{
+		db:        db,
+		encryptor: encryptor,
+		indexer:   indexer,
+		batchSize: batchSize,
+	}, nil
+}
+
+// Rekey re-encrypts the values in col that were not encrypted with the latest key.
+// Every batch is updated in its own transaction, so the app can keep running while
+// a column is rekeyed. progress is called after every batch.
+func (r *Rekeyer) Rekey(ctx context.Context, col Column, progress func(Progress)) (Progress, error) {
+	latest := r.encryptor.LatestKeyIndex()
+	p := Progress{Column: col}
+
+	err := r.walk(ctx, col.Table, col.KeyColumn, []string{col.Name}, func(tx *sql.Tx, rows []row) error {
+		for _, rw := range rows {
+			// NULL values are not encrypted, there is nothing to rekey.
+			if rw.values[0] == nil {
+				continue
+			}
+
+			index, err := krypto.KeyIndex(rw.values[0])
+			if err != nil {
+				return fmt.Errorf("%s of %s: %w", col, rw.key, err)
+			}
+
+			p.Scanned++
+			if index == latest {
+				continue
+			}
+
+			updated, err := r.reencrypt(tx, col, rw)
+			if err != nil {
+				return fmt.Errorf("%s of %s: %w", col, rw.key, err)
+			}
+
+			if updated {
+				p.Rekeyed++
+			}
+		}
+
+		if progress != nil {
+			progress(p)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return p, err
+	}
+
+	return p, nil
+}
+
+// KeyUsage counts the values in col per index of the key they were encrypted with,
+// NULL values are not counted.
+func (r *Rekeyer) KeyUsage(ctx context.Context, col Column) (map[int]int, error) {
+	usage := make(map[int]int)
+
+	err := r.walk(ctx, col.Table, col.KeyColumn, []string{col.Name}, func(_ *sql.Tx, rows []row) error {
+		for _, rw := range rows {
+			if rw.values[0] == nil {
+				continue
+			}
+
+			index, err := krypto.KeyIndex(rw.values[0])
+			if err != nil {
+				return fmt.Errorf("%s of %s: %w", col, rw.key, err)
+			}
+
+			usage[index]++
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return usage, nil
+}
+
+type row struct {
+	key    string
+	values [][]byte
+}
+
+// walk calls f for every batch of rows in table, in a transaction per batch.
+// The values of the rows are those of columns, in the same order.
+func (r *Rekeyer) walk(ctx context.Context, table, keyColumn string, columns []string, f func(tx *sql.Tx, rows []row) error) error {
+	after := ""
+	for {
+		tx, err := r.db.BeginTx(ctx, nil)
+		if err != nil {
+			return err
+		}
+
+		rows, err := r.selectBatch(tx, table, keyColumn, columns, after)
+		if err == nil {
+			err = f(tx, rows)
+		}
+
+		if err != nil {
+			rBackErr := tx.Rollback()
+			if rBackErr != nil {
+				err = errors.Join(err, rBackErr)
+			}
+			return err
+		}
+
+		err = tx.Commit()
+		if err != nil {
+			return err
+		}
+
+		if len(rows) < r.batchSize {
+			return nil
+		}
+
+		after = rows[len(rows)-1].key
+	}
+}
+
+func (r *Rekeyer) selectBatch(tx *sql.Tx, table, keyColumn string, columns []string, after string) ([]row, error) {
+	q := db.Query{}
+	q.Unsafe(`SELECT ` + keyColumn + `, ` + strings.Join(columns, `, `) + ` FROM ` + table + ` WHERE ` + keyColumn + ` > `)
+	q.Param(after)
+	q.Unsafe(` ORDER BY ` + keyColumn + ` ASC LIMIT `)
+	q.Param(r.batchSize)
+
+	s, params, err := q.Get()
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := tx.Query(s, params...)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	out := make([]row, 0, r.batchSize)
+	for rows.Next() {
+		rw := row{values: make([][]byte, len(columns))}
+		dst := []any{&rw.key}
+		for i := range rw.values {
+			dst = append(dst, &rw.values[i])
+		}
+
+		err := rows.Scan(dst...)
+		if err != nil {
+			return nil, err
+		}
+
+		out = append(out, rw)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return out, nil
+}
+
+// reencrypt encrypts the value of the row with the latest key. The value is only
+// updated if it wasn't changed since it was read, it reports whether it was updated.
+func (r *Rekeyer) reencrypt(tx *sql.Tx, col Column, rw row) (bool, error) {
+	data, err := r.encryptor.Decrypt(rw.values[0])
+	if err != nil {
+		return false, err
+	}
+
+	q := db.Query{Encryptor: r.encryptor}
+	q.Unsafe(`UPDATE ` + col.Table + ` SET ` + col.Name + ` = `)
+	q.ParamEncrypted(data)
+	q.Unsafe(` WHERE ` + col.KeyColumn + ` = `)
+	q.Param(rw.key)
+	q.Unsafe(` AND ` + col.Name + ` = `)
+	q.Param(rw.values[0])
+
+	return execAffected(tx, q)
+}
+
+// execAffected executes q and reports whether any rows were affected.
+func execAffected(tx *sql.Tx, q db.Query) (bool, error) {
+	s, params, err := q.Get()
+	if err != nil {
+		return false, err
+	}
+
+	result, err := tx.Exec(s, params...)
+	if err != nil {
+		return false, err
+	}
+
+	n, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return n > 0, nil
+}
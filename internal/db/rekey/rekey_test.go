@@ -0,0 +1,404 @@
+package rekey_test
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/willemschots/househunt/internal/auth"
+	authdb "github.com/willemschots/househunt/internal/auth/db"
+	"github.com/willemschots/househunt/internal/db/rekey"
+	"github.com/willemschots/househunt/internal/db/testdb"
+	"github.com/willemschots/househunt/internal/email"
+	"github.com/willemschots/househunt/internal/email/outbox"
+	outboxdb "github.com/willemschots/househunt/internal/email/outbox/db"
+	"github.com/willemschots/househunt/internal/krypto"
+)
+
+var (
+	oldKey     = must(krypto.ParseKey("2b671594b775f371eab4050b4d58326682df6b1a6cc2e886717b1a26b4d6c45d"))
+	newKey     = must(krypto.ParseKey("cf55b868d8c7a640265365910093113edce9b6c9226f3bd7c87987d23062d421"))
+	retiredKey = must(krypto.ParseEncryptionKey(krypto.RetiredKey))
+	oldIndex   = must(krypto.ParseKey("90303dfed7994260ea4817a5ca8a392915cd401115b2f97495dadfcbcd14adbf"))
+	newIndex   = must(krypto.ParseKey("b61115eeb1bdf0847f1d7ea978c7da71e3b31361f7450bc8aa12566a16b7b03f"))
+	indexer    = must(krypto.NewBlindIndexer([]krypto.Key{oldIndex}))
+)
+
+const nrOfUsers = 3
+
+func Test_Rekeyer_Rekey(t *testing.T) {
+	t.Run("ok, rekey all columns", func(t *testing.T) {
+		testDB := setupDB(t)
+		rekeyer := must(rekey.New(testDB, must(krypto.NewEncryptor([]krypto.Key{oldKey, newKey})), indexer, 2))
+
+		for _, col := range rekey.Columns {
+			batches := 0
+			got, err := rekeyer.Rekey(context.Background(), col, func(rekey.Progress) {
+				batches++
+			})
+			if err != nil {
+				t.Fatalf("failed to rekey %s: %v", col, err)
+			}
+
+			want := rekey.Progress{Column: col, Scanned: nrOfUsers, Rekeyed: nrOfUsers}
+			if got != want || batches != 2 {
+				t.Errorf("got %+v in %d batches, want %+v in 2 batches", got, batches, want)
+			}
+
+			usage, err := rekeyer.KeyUsage(context.Background(), col)
+			if err != nil {
+				t.Fatalf("failed to get key usage of %s: %v", col, err)
+			}
+
+			if !reflect.DeepEqual(usage, map[int]int{1: nrOfUsers}) {
+				t.Errorf("expected all values of %s to use key 1, got %v", col, usage)
+			}
+		}
+
+		// The old key is no longer needed to read the data.
+		assertReadable(t, testDB, must(krypto.NewEncryptor([]krypto.Key{retiredKey, newKey})), indexer)
+	})
+
+	t.Run("ok, values with the latest key are skipped", func(t *testing.T) {
+		testDB := setupDB(t)
+		rekeyer := must(rekey.New(testDB, must(krypto.NewEncryptor([]krypto.Key{oldKey, newKey})), indexer, 10))
+		col := rekey.Columns[0]
+
+		_, err := rekeyer.Rekey(context.Background(), col, nil)
+		if err != nil {
+			t.Fatalf("failed to rekey: %v", err)
+		}
+
+		got, err := rekeyer.Rekey(context.Background(), col, nil)
+		if err != nil {
+			t.Fatalf("failed to rekey: %v", err)
+		}
+
+		want := rekey.Progress{Column: col, Scanned: nrOfUsers, Rekeyed: 0}
+		if got != want {
+			t.Errorf("got %+v, want %+v", got, want)
+		}
+	})
+
+	t.Run("ok, NULL values are skipped", func(t *testing.T) {
+		testDB := setupDB(t)
+		rekeyer := must(rekey.New(testDB, must(krypto.NewEncryptor([]krypto.Key{oldKey, newKey})), indexer, 10))
+		col := rekey.Column{Table: "email_outbox", KeyColumn: "id", Name: "html_body_encrypted"}
+
+		// Messages without an HTML body store NULL.
+		_, err := testDB.Exec(`UPDATE email_outbox SET html_body_encrypted = NULL WHERE id = (SELECT MIN(id) FROM email_outbox)`)
+		if err != nil {
+			t.Fatalf("failed to update message: %v", err)
+		}
+
+		got, err := rekeyer.Rekey(context.Background(), col, nil)
+		if err != nil {
+			t.Fatalf("failed to rekey: %v", err)
+		}
+
+		want := rekey.Progress{Column: col, Scanned: nrOfUsers - 1, Rekeyed: nrOfUsers - 1}
+		if got != want {
+			t.Errorf("got %+v, want %+v", got, want)
+		}
+
+		assertReadable(t, testDB, must(krypto.NewEncryptor([]krypto.Key{oldKey, newKey})), indexer)
+	})
+
+	t.Run("fail, key was retired", func(t *testing.T) {
+		testDB := setupDB(t)
+		rekeyer := must(rekey.New(testDB, must(krypto.NewEncryptor([]krypto.Key{retiredKey, newKey})), indexer, 10))
+
+		_, err := rekeyer.Rekey(context.Background(), rekey.Columns[0], nil)
+		if !errors.Is(err, krypto.ErrUnknownKey) {
+			t.Fatalf("expected error %v, got %v (via errors.Is)", krypto.ErrUnknownKey, err)
+		}
+
+		// Nothing was changed.
+		usage, err := rekeyer.KeyUsage(context.Background(), rekey.Columns[0])
+		if err != nil {
+			t.Fatalf("failed to get key usage: %v", err)
+		}
+
+		if !reflect.DeepEqual(usage, map[int]int{0: nrOfUsers}) {
+			t.Errorf("expected all values to use key 0, got %v", usage)
+		}
+	})
+}
+
+func Test_Rekeyer_RebuildBlindIndex(t *testing.T) {
+	t.Run("ok, rebuild all blind indexes", func(t *testing.T) {
+		testDB := setupDB(t)
+		encryptor := must(krypto.NewEncryptor([]krypto.Key{oldKey}))
+		rotated := must(krypto.NewBlindIndexer([]krypto.Key{oldIndex, newIndex}))
+		rekeyer := must(rekey.New(testDB, encryptor, rotated, 2))
+
+		// Before the rebuild, values can be found with the indexes of both keys.
+		assertFindable(t, testDB, encryptor, rotated, 1)
+
+		want := map[string]rekey.Progress{
+			"users.email_blind_index":         {Column: rekey.BlindIndexes[0].Column, Scanned: nrOfUsers, Rekeyed: nrOfUsers},
+			"attempts.email_blind_index":      {Column: rekey.BlindIndexes[1].Column, Scanned: nrOfUsers},
+			"email_bounces.email_blind_index": {Column: rekey.BlindIndexes[2].Column, Scanned: nrOfUsers, Rekeyed: nrOfUsers},
+		}
+
+		for _, idx := range rekey.BlindIndexes {
+			got, err := rekeyer.RebuildBlindIndex(context.Background(), idx, nil)
+			if err != nil {
+				t.Fatalf("failed to rebuild %s: %v", idx, err)
+			}
+
+			if got != want[idx.String()] {
+				t.Errorf("got %+v, want %+v", got, want[idx.String()])
+			}
+		}
+
+		usage, err := rekeyer.BlindIndexKeyUsage(context.Background(), rekey.BlindIndexes[0])
+		if err != nil {
+			t.Fatalf("failed to get key usage: %v", err)
+		}
+
+		if !reflect.DeepEqual(usage, map[int]int{1: nrOfUsers}) {
+			t.Errorf("expected all values to use key 1, got %v", usage)
+		}
+
+		// The attempts are left in place and are still counted with the old key.
+		assertFindable(t, testDB, encryptor, rotated, 1)
+
+		// The old key is no longer needed to find the users.
+		assertFindable(t, testDB, encryptor, must(krypto.NewBlindIndexer([]krypto.Key{retiredKey, newIndex})), 0)
+	})
+
+	t.Run("ok, rows without source are deleted once their key is retired", func(t *testing.T) {
+		testDB := setupDB(t)
+		retired := must(krypto.NewBlindIndexer([]krypto.Key{retiredKey, newIndex}))
+		rekeyer := must(rekey.New(testDB, must(krypto.NewEncryptor([]krypto.Key{oldKey})), retired, 2))
+
+		got, err := rekeyer.RebuildBlindIndex(context.Background(), rekey.BlindIndexes[1], nil)
+		if err != nil {
+			t.Fatalf("failed to rebuild: %v", err)
+		}
+
+		want := rekey.Progress{Column: rekey.BlindIndexes[1].Column, Scanned: nrOfUsers, Deleted: nrOfUsers}
+		if got != want {
+			t.Errorf("got %+v, want %+v", got, want)
+		}
+
+		usage, err := rekeyer.BlindIndexKeyUsage(context.Background(), rekey.BlindIndexes[1])
+		if err != nil {
+			t.Fatalf("failed to get key usage: %v", err)
+		}
+
+		if len(usage) != 0 {
+			t.Errorf("expected no attempts, got %v", usage)
+		}
+	})
+
+	t.Run("ok, values with the latest key are skipped", func(t *testing.T) {
+		testDB := setupDB(t)
+		rekeyer := must(rekey.New(testDB, must(krypto.NewEncryptor([]krypto.Key{oldKey})), indexer, 10))
+
+		for _, idx := range rekey.BlindIndexes {
+			got, err := rekeyer.RebuildBlindIndex(context.Background(), idx, nil)
+			if err != nil {
+				t.Fatalf("failed to rebuild %s: %v", idx, err)
+			}
+
+			want := rekey.Progress{Column: idx.Column, Scanned: nrOfUsers}
+			if got != want {
+				t.Errorf("got %+v, want %+v", got, want)
+			}
+		}
+	})
+
+	t.Run("fail, source can't be decrypted", func(t *testing.T) {
+		testDB := setupDB(t)
+		rotated := must(krypto.NewBlindIndexer([]krypto.Key{oldIndex, newIndex}))
+		rekeyer := must(rekey.New(testDB, must(krypto.NewEncryptor([]krypto.Key{retiredKey, newKey})), rotated, 10))
+
+		_, err := rekeyer.RebuildBlindIndex(context.Background(), rekey.BlindIndexes[0], nil)
+		if !errors.Is(err, krypto.ErrUnknownKey) {
+			t.Fatalf("expected error %v, got %v (via errors.Is)", krypto.ErrUnknownKey, err)
+		}
+	})
+
+	t.Run("fail, no blind indexer", func(t *testing.T) {
+		rekeyer := must(rekey.New(setupDB(t), must(krypto.NewEncryptor([]krypto.Key{oldKey})), nil, 10))
+
+		_, err := rekeyer.RebuildBlindIndex(context.Background(), rekey.BlindIndexes[0], nil)
+		if err == nil {
+			t.Fatalf("wanted error, got <nil>")
+		}
+	})
+}
+
+func Test_New(t *testing.T) {
+	_, err := rekey.New(testdb.RunWhile(t, true), must(krypto.NewEncryptor([]krypto.Key{newKey})), indexer, 0)
+	if err == nil {
+		t.Fatalf("wanted error, got <nil>")
+	}
+}
+
+// setupDB returns a database with a row in every encrypted and blind index column
+// per user, all encrypted and indexed with the old keys.
+func setupDB(t *testing.T) *sql.DB {
+	t.Helper()
+
+	testDB := testdb.RunWhile(t, true)
+	encryptor := must(krypto.NewEncryptor([]krypto.Key{oldKey}))
+	now := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+
+	tx, err := authdb.New(testDB, testDB, encryptor, indexer).BeginTx(context.Background())
+	if err != nil {
+		t.Fatalf("failed to begin tx: %v", err)
+	}
+
+	for i := 0; i < nrOfUsers; i++ {
+		addr := must(email.ParseAddress(fmt.Sprintf("user%d@example.com", i)))
+		user := auth.User{
+			ID:           must(uuid.NewRandom()),
+			Email:        addr,
+			PasswordHash: must(krypto.HashArgon2([]byte("reallyStrongPassword1"))),
+			Role:         auth.RoleAgent,
+			CreatedAt:    now,
+			UpdatedAt:    now,
+		}
+
+		err = tx.CreateUser(user)
+		if err == nil {
+			err = tx.CreateEmailToken(auth.EmailToken{
+				ID:        must(uuid.NewRandom()),
+				TokenHash: must(krypto.HashArgon2([]byte("token"))),
+				UserID:    user.ID,
+				Email:     addr,
+				Purpose:   auth.TokenPurposeActivate,
+				CreatedAt: now,
+			})
+		}
+		if err == nil {
+			err = tx.CreateTOTP(auth.TOTP{
+				UserID:    user.ID,
+				Secret:    must(krypto.GenerateTOTPSecret()),
+				CreatedAt: now,
+				UpdatedAt: now,
+			})
+		}
+		if err == nil {
+			err = tx.CreateAttempt(auth.Attempt{
+				ID:        must(uuid.NewRandom()),
+				Email:     addr,
+				Purpose:   auth.AttemptPurposeLogin,
+				CreatedAt: now,
+			})
+		}
+		if err == nil {
+			err = tx.CreateEmailBounce(auth.EmailBounce{
+				ID:        must(uuid.NewRandom()),
+				UserID:    user.ID,
+				Email:     addr,
+				Kind:      auth.BounceKindHard,
+				BouncedAt: now,
+				CreatedAt: now,
+			})
+		}
+		if err == nil {
+			m := must(outbox.NewMessage(email.Message{
+				From:      "househunt@example.com",
+				Recipient: addr,
+				Subject:   "Hello",
+				Body:      "Hello world",
+				HTMLBody:  "<p>Hello world</p>",
+			}, now))
+			m.LastError = "mailbox unavailable"
+			err = tx.CreateOutboxMessage(m)
+		}
+		if err != nil {
+			t.Fatalf("failed to create data: %v", err)
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		t.Fatalf("failed to commit tx: %v", err)
+	}
+
+	return testDB
+}
+
+func assertReadable(t *testing.T, testDB *sql.DB, encryptor *krypto.Encryptor, indexer *krypto.BlindIndexer) {
+	t.Helper()
+
+	tx, err := authdb.New(testDB, testDB, encryptor, indexer).BeginTx(context.Background())
+	if err != nil {
+		t.Fatalf("failed to begin tx: %v", err)
+	}
+
+	users, err := tx.FindUsers(auth.UserFilter{})
+	if err != nil || len(users) != nrOfUsers {
+		t.Fatalf("expected %d users, got %d and error %v", nrOfUsers, len(users), err)
+	}
+
+	tokens, err := tx.FindEmailTokens(auth.EmailTokenFilter{})
+	if err != nil || len(tokens) != nrOfUsers {
+		t.Fatalf("expected %d email tokens, got %d and error %v", nrOfUsers, len(tokens), err)
+	}
+
+	totps, err := tx.FindTOTPs(auth.TOTPFilter{})
+	if err != nil || len(totps) != nrOfUsers {
+		t.Fatalf("expected %d TOTP credentials, got %d and error %v", nrOfUsers, len(totps), err)
+	}
+
+	// The test database has a single connection, end the transaction before using it again.
+	err = tx.Rollback()
+	if err != nil {
+		t.Fatalf("failed to rollback tx: %v", err)
+	}
+
+	msgs, err := outboxdb.New(testDB, testDB, encryptor).FindMessages(context.Background(), outbox.MessageFilter{})
+	if err != nil || len(msgs) != nrOfUsers {
+		t.Fatalf("expected %d messages, got %d and error %v", nrOfUsers, len(msgs), err)
+	}
+}
+
+// assertFindable asserts that every user and their bounce can be found by email address
+// and that the expected number of attempts are counted for them.
+func assertFindable(t *testing.T, testDB *sql.DB, encryptor *krypto.Encryptor, indexer *krypto.BlindIndexer, attempts int) {
+	t.Helper()
+
+	store := authdb.New(testDB, testDB, encryptor, indexer)
+	for i := 0; i < nrOfUsers; i++ {
+		addr := must(email.ParseAddress(fmt.Sprintf("user%d@example.com", i)))
+
+		users, err := store.FindUsers(context.Background(), auth.UserFilter{
+			Emails: []email.Address{addr},
+		})
+		if err != nil || len(users) != 1 {
+			t.Fatalf("expected to find user %s, got %d and error %v", addr, len(users), err)
+		}
+
+		count, err := store.CountAttempts(context.Background(), auth.AttemptFilter{
+			Emails: []email.Address{addr},
+		})
+		if err != nil || count != attempts {
+			t.Fatalf("expected %d attempts for %s, got %d and error %v", attempts, addr, count, err)
+		}
+
+		count, err = store.CountEmailBounces(context.Background(), auth.EmailBounceFilter{
+			Emails: []email.Address{addr},
+		})
+		if err != nil || count != 1 {
+			t.Fatalf("expected 1 email bounce for %s, got %d and error %v", addr, count, err)
+		}
+	}
+}
+
+func must[T any](v T, err error) T {
+	if err != nil {
+		panic(err)
+	}
+	return v
+}
@@ -20,12 +20,13 @@ func NewLogSender(logger *slog.Logger) *LogSender {
 }
 
 // Send logs the email to the logger.
-func (s *LogSender) Send(_ context.Context, from, recipient Address, subject, body string) error {
+func (s *LogSender) Send(_ context.Context, msg Message) error {
 	s.logger.Info("send email",
-		"from", from,
-		"recipient", recipient,
-		"subject", subject,
-		"body", body,
+		"from", msg.From,
+		"recipient", msg.Recipient,
+		"subject", msg.Subject,
+		"body", msg.Body,
+		"html_body", msg.HTMLBody,
 	)
 	return nil
 }
@@ -0,0 +1,257 @@
+// Package mailbox captures emails as .eml files in a directory instead of sending them,
+// so that they can be browsed during local development.
+package mailbox
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/willemschots/househunt/internal/email"
+	"github.com/willemschots/househunt/internal/email/smtp"
+	"github.com/willemschots/househunt/internal/errorz"
+)
+
+const ext = ".eml"
+
+// Sender is an email sender that writes every email as an .eml file to a directory.
+// Note that this is not meant for production use, the emails are stored unencrypted
+// and never delivered.
+type Sender struct {
+	dir string
+	// NowFunc is used to set the date of emails.
+	NowFunc func() time.Time
+}
+
+// NewSender creates a new sender, the directory is created if it doesn't exist.
+func NewSender(dir string) (*Sender, error) {
+	err := os.MkdirAll(dir, 0o700)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create mailbox directory: %w", err)
+	}
+
+	return &Sender{
+		dir:     dir,
+		NowFunc: time.Now,
+	}, nil
+}
+
+// Send writes the email to a new file in the directory.
+func (s *Sender) Send(_ context.Context, msg email.Message) error {
+	now := s.NowFunc()
+	data, err := smtp.FormatMessage(now, msg)
+	if err != nil {
+		return fmt.Errorf("failed to build message: %w", err)
+	}
+
+	// The email is written to a temporary file first and then renamed,
+	// so that the mailbox never lists partially written emails.
+	f, err := os.CreateTemp(s.dir, ".tmp-*")
+	if err != nil {
+		return fmt.Errorf("failed to create file: %w", err)
+	}
+
+	_, err = f.Write(data)
+	if err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return fmt.Errorf("failed to write file: %w", err)
+	}
+
+	err = f.Close()
+	if err != nil {
+		os.Remove(f.Name())
+		return fmt.Errorf("failed to close file: %w", err)
+	}
+
+	// IDs start with the timestamp so that they sort in the order the emails were sent.
+	id := fmt.Sprintf("%020d-%s", now.UnixNano(), uuid.NewString())
+	err = os.Rename(f.Name(), filepath.Join(s.dir, id+ext))
+	if err != nil {
+		os.Remove(f.Name())
+		return fmt.Errorf("failed to rename file: %w", err)
+	}
+
+	return nil
+}
+
+// Summary describes a captured email.
+type Summary struct {
+	ID        string
+	From      string
+	Recipient string
+	Subject   string
+	Date      time.Time
+}
+
+// Email is a captured email.
+type Email struct {
+	Summary
+	Body string
+	// HTMLBody is empty if the email has no HTML version.
+	HTMLBody string
+}
+
+var linkRe = regexp.MustCompile(`https?://[^\s<>"]+`)
+
+// Links returns the URLs in the plain text body of the email.
+func (e Email) Links() []string {
+	return linkRe.FindAllString(e.Body, -1)
+}
+
+// Mailbox reads the emails captured by a Sender.
+type Mailbox struct {
+	dir string
+}
+
+// New creates a mailbox for the emails in dir.
+func New(dir string) *Mailbox {
+	return &Mailbox{
+		dir: dir,
+	}
+}
+
+// List returns the emails in the mailbox, most recent first.
+func (m *Mailbox) List() ([]Summary, error) {
+	files, err := filepath.Glob(filepath.Join(m.dir, "*"+ext))
+	if err != nil {
+		return nil, fmt.Errorf("failed to glob for emails: %w", err)
+	}
+
+	slices.Sort(files)
+	slices.Reverse(files)
+
+	out := make([]Summary, 0, len(files))
+	for _, file := range files {
+		e, err := m.Get(strings.TrimSuffix(filepath.Base(file), ext))
+		if err != nil {
+			return nil, err
+		}
+
+		out = append(out, e.Summary)
+	}
+
+	return out, nil
+}
+
+// Get returns the email with the given ID, or errorz.ErrNotFound if it doesn't exist.
+func (m *Mailbox) Get(id string) (Email, error) {
+	// IDs end up in file paths, only accept IDs a Sender could have created.
+	if !validID(id) {
+		return Email{}, errorz.ErrNotFound
+	}
+
+	f, err := os.Open(filepath.Join(m.dir, id+ext))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return Email{}, errorz.ErrNotFound
+		}
+		return Email{}, fmt.Errorf("failed to open email: %w", err)
+	}
+
+	defer f.Close()
+
+	e, err := parse(f)
+	if err != nil {
+		return Email{}, fmt.Errorf("failed to parse email %s: %w", id, err)
+	}
+
+	e.ID = id
+	return e, nil
+}
+
+func validID(id string) bool {
+	if id == "" {
+		return false
+	}
+
+	for _, r := range id {
+		if r != '-' && (r < '0' || r > '9') && (r < 'a' || r > 'f') {
+			return false
+		}
+	}
+
+	return true
+}
+
+// parse parses a message created by smtp.FormatMessage.
+func parse(r io.Reader) (Email, error) {
+	msg, err := mail.ReadMessage(r)
+	if err != nil {
+		return Email{}, err
+	}
+
+	dec := &mime.WordDecoder{}
+	subject, err := dec.DecodeHeader(msg.Header.Get("Subject"))
+	if err != nil {
+		return Email{}, fmt.Errorf("failed to decode subject: %w", err)
+	}
+
+	date, err := msg.Header.Date()
+	if err != nil {
+		return Email{}, fmt.Errorf("failed to parse date: %w", err)
+	}
+
+	e := Email{
+		Summary: Summary{
+			From:      msg.Header.Get("From"),
+			Recipient: msg.Header.Get("To"),
+			Subject:   subject,
+			Date:      date,
+		},
+	}
+
+	mediaType, params, err := mime.ParseMediaType(msg.Header.Get("Content-Type"))
+	if err != nil {
+		return Email{}, fmt.Errorf("failed to parse content type: %w", err)
+	}
+
+	if mediaType != "multipart/alternative" {
+		body, err := io.ReadAll(quotedprintable.NewReader(msg.Body))
+		if err != nil {
+			return Email{}, fmt.Errorf("failed to read body: %w", err)
+		}
+
+		// Strip the line break that ends the message.
+		e.Body = strings.TrimSuffix(string(body), "\r\n")
+		return e, nil
+	}
+
+	// The multipart reader decodes quoted-printable parts.
+	mr := multipart.NewReader(msg.Body, params["boundary"])
+	for {
+		p, err := mr.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return Email{}, fmt.Errorf("failed to read part: %w", err)
+		}
+
+		body, err := io.ReadAll(p)
+		if err != nil {
+			return Email{}, fmt.Errorf("failed to read part: %w", err)
+		}
+
+		partType, _, _ := mime.ParseMediaType(p.Header.Get("Content-Type"))
+		switch partType {
+		case "text/plain":
+			e.Body = string(body)
+		case "text/html":
+			e.HTMLBody = string(body)
+		}
+	}
+
+	return e, nil
+}
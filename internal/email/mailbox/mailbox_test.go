@@ -0,0 +1,164 @@
+package mailbox_test
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/willemschots/househunt/internal/email"
+	"github.com/willemschots/househunt/internal/email/mailbox"
+	"github.com/willemschots/househunt/internal/errorz"
+)
+
+func Test_Mailbox(t *testing.T) {
+	t.Run("ok, emails can be read back", func(t *testing.T) {
+		dir := filepath.Join(t.TempDir(), "mailbox")
+		s := newSender(t, dir)
+
+		msgs := []email.Message{
+			{
+				From:      "from@example.com",
+				Recipient: "to@example.com",
+				Subject:   "Hello",
+				Body:      "Welcome!\r\nVisit http://localhost:8888/login?x=1&y=2 to log in.",
+			},
+			{
+				From:      "from@example.com",
+				Recipient: "other@example.com",
+				Subject:   "Grüße",
+				Body:      "Hello again",
+				HTMLBody:  `<p>Hello <a href="http://localhost:8888">again</a></p>`,
+			},
+		}
+
+		for _, msg := range msgs {
+			err := s.Send(context.Background(), msg)
+			if err != nil {
+				t.Fatalf("failed to send: %v", err)
+			}
+		}
+
+		m := mailbox.New(dir)
+		summaries, err := m.List()
+		if err != nil {
+			t.Fatalf("failed to list: %v", err)
+		}
+
+		if len(summaries) != len(msgs) {
+			t.Fatalf("expected %d emails, got %d", len(msgs), len(summaries))
+		}
+
+		// Most recent first.
+		for i, summary := range summaries {
+			msg := msgs[len(msgs)-1-i]
+
+			got, err := m.Get(summary.ID)
+			if err != nil {
+				t.Fatalf("failed to get: %v", err)
+			}
+
+			want := mailbox.Email{
+				Summary: mailbox.Summary{
+					ID:        summary.ID,
+					From:      string(msg.From),
+					Recipient: string(msg.Recipient),
+					Subject:   msg.Subject,
+					Date:      got.Date,
+				},
+				Body:     msg.Body,
+				HTMLBody: msg.HTMLBody,
+			}
+
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("got\n%#v\nwant\n%#v", got, want)
+			}
+
+			if got.Summary != summary {
+				t.Errorf("listed summary %#v does not match %#v", summary, got.Summary)
+			}
+		}
+
+		got, err := m.Get(summaries[1].ID)
+		if err != nil {
+			t.Fatalf("failed to get: %v", err)
+		}
+
+		links := got.Links()
+		want := []string{"http://localhost:8888/login?x=1&y=2"}
+		if !reflect.DeepEqual(links, want) {
+			t.Errorf("got links %v, want %v", links, want)
+		}
+	})
+
+	t.Run("ok, empty mailbox", func(t *testing.T) {
+		summaries, err := mailbox.New(t.TempDir()).List()
+		if err != nil {
+			t.Fatalf("failed to list: %v", err)
+		}
+
+		if len(summaries) != 0 {
+			t.Errorf("expected no emails, got %d", len(summaries))
+		}
+	})
+
+	t.Run("ok, files are not readable by others", func(t *testing.T) {
+		dir := t.TempDir()
+		s := newSender(t, dir)
+
+		err := s.Send(context.Background(), email.Message{From: "a@example.com", Recipient: "b@example.com", Body: "secret"})
+		if err != nil {
+			t.Fatalf("failed to send: %v", err)
+		}
+
+		files, err := filepath.Glob(filepath.Join(dir, "*.eml"))
+		if err != nil || len(files) != 1 {
+			t.Fatalf("expected 1 file, got %v (%v)", files, err)
+		}
+
+		info, err := os.Stat(files[0])
+		if err != nil {
+			t.Fatalf("failed to stat: %v", err)
+		}
+
+		if info.Mode().Perm() != 0o600 {
+			t.Errorf("expected permissions 0600, got %v", info.Mode().Perm())
+		}
+	})
+
+	notFoundTests := map[string]string{
+		"fail, unknown id":          "00000000000000000000-1234",
+		"fail, empty id":            "",
+		"fail, directory traversal": "../mailbox",
+		"fail, other characters":    "ABC",
+	}
+
+	for name, id := range notFoundTests {
+		t.Run(name, func(t *testing.T) {
+			_, err := mailbox.New(t.TempDir()).Get(id)
+			if !errors.Is(err, errorz.ErrNotFound) {
+				t.Errorf("expected %v, got %v", errorz.ErrNotFound, err)
+			}
+		})
+	}
+}
+
+func newSender(t *testing.T, dir string) *mailbox.Sender {
+	t.Helper()
+
+	s, err := mailbox.NewSender(dir)
+	if err != nil {
+		t.Fatalf("failed to create sender: %v", err)
+	}
+
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	s.NowFunc = func() time.Time {
+		now = now.Add(time.Second)
+		return now
+	}
+
+	return s
+}
@@ -3,29 +3,14 @@ package email
 import "context"
 
 type MemorySender struct {
-	Emails []struct {
-		From      Address
-		Recipient Address
-		Subject   string
-		Body      string
-	}
+	Emails []Message
 }
 
 func NewMemorySender() *MemorySender {
 	return &MemorySender{}
 }
 
-func (s *MemorySender) Send(_ context.Context, from, recipient Address, subject, body string) error {
-	s.Emails = append(s.Emails, struct {
-		From      Address
-		Recipient Address
-		Subject   string
-		Body      string
-	}{
-		From:      from,
-		Recipient: recipient,
-		Subject:   subject,
-		Body:      body,
-	})
+func (s *MemorySender) Send(_ context.Context, msg Message) error {
+	s.Emails = append(s.Emails, msg)
 	return nil
 }
@@ -0,0 +1,217 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+
+	"github.com/google/uuid"
+	"github.com/willemschots/househunt/internal/db"
+	"github.com/willemschots/househunt/internal/email"
+	"github.com/willemschots/househunt/internal/email/outbox"
+	"github.com/willemschots/househunt/internal/errorz"
+)
+
+type execFunc func(query string, params ...any) (sql.Result, error)
+type queryFunc func(query string, params ...any) (*sql.Rows, error)
+
+func insertMessage(q db.Query, ef execFunc, m outbox.Message) error {
+	if m.ID == uuid.Nil {
+		return fmt.Errorf("zero uuid provided: %w", errorz.ErrConstraintViolated)
+	}
+
+	q.Unsafe(`INSERT INTO email_outbox (id, from_address, recipient_encrypted, subject_encrypted, body_encrypted, html_body_encrypted, status, attempts, next_attempt_at, last_error_encrypted, created_at, updated_at) VALUES (`)
+	q.Params(m.ID, m.From)
+	q.Unsafe(`, `)
+	q.ParamEncrypted([]byte(m.Recipient))
+	q.Unsafe(`, `)
+	q.ParamEncrypted([]byte(m.Subject))
+	q.Unsafe(`, `)
+	q.ParamEncrypted([]byte(m.Body))
+	q.Unsafe(`, `)
+	// Empty values can't be encrypted, messages without an HTML body store NULL.
+	if m.HTMLBody == "" {
+		q.Param(nil)
+	} else {
+		q.ParamEncrypted([]byte(m.HTMLBody))
+	}
+	q.Unsafe(`, `)
+	q.Params(m.Status, m.Attempts, m.NextAttemptAt.UTC())
+	q.Unsafe(`, `)
+	paramLastError(&q, m.LastError)
+	q.Unsafe(`, `)
+	q.Params(m.CreatedAt.UTC(), m.UpdatedAt.UTC())
+	q.Unsafe(`)`)
+
+	s, params, err := q.Get()
+	if err != nil {
+		return err
+	}
+
+	_, err = ef(s, params...)
+	if err != nil {
+		return errorz.MapDBErr(err)
+	}
+
+	return nil
+}
+
+// updateMessage only updates the delivery state of a message, the email itself can't be modified.
+func updateMessage(q db.Query, ef execFunc, m outbox.Message) error {
+	q.Unsafe(`UPDATE email_outbox SET `)
+
+	q.Unsafe(`status = `)
+	q.Param(m.Status)
+
+	q.Unsafe(`, attempts = `)
+	q.Param(m.Attempts)
+
+	q.Unsafe(`, next_attempt_at = `)
+	q.Param(m.NextAttemptAt.UTC())
+
+	q.Unsafe(`, last_error_encrypted = `)
+	paramLastError(&q, m.LastError)
+
+	q.Unsafe(`, updated_at = `)
+	q.Param(m.UpdatedAt.UTC())
+
+	q.Unsafe(` WHERE id = `)
+	q.Param(m.ID)
+
+	s, params, err := q.Get()
+	if err != nil {
+		return err
+	}
+
+	result, err := ef(s, params...)
+	if err != nil {
+		return errorz.MapDBErr(err)
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return errorz.MapDBErr(err)
+	}
+
+	if rows == 0 {
+		return fmt.Errorf("email message not found: %w", errorz.ErrNotFound)
+	}
+
+	return nil
+}
+
+func selectMessages(q db.Query, qf queryFunc, f outbox.MessageFilter) ([]outbox.Message, error) {
+	q.Unsafe(`SELECT id, from_address, recipient_encrypted, subject_encrypted, body_encrypted, html_body_encrypted, status, attempts, next_attempt_at, last_error_encrypted, created_at, updated_at FROM email_outbox WHERE 1=1 `)
+
+	whereMessages(&q, f)
+
+	q.Unsafe(`ORDER BY next_attempt_at ASC, id ASC`)
+
+	if f.Limit > 0 {
+		q.Unsafe(` LIMIT `)
+		q.Param(f.Limit)
+	}
+
+	s, params, err := q.Get()
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := qf(s, params...)
+	if err != nil {
+		return nil, errorz.MapDBErr(err)
+	}
+
+	defer rows.Close()
+
+	out := make([]outbox.Message, 0)
+	for rows.Next() {
+		var m outbox.Message
+		recipientBytes := q.DecryptionTarget()
+		subjectBytes := q.DecryptionTarget()
+		bodyBytes := q.DecryptionTarget()
+		htmlBodyBytes := q.DecryptionTarget()
+		lastErrorBytes := q.DecryptionTarget()
+		err := rows.Scan(&m.ID, &m.From, recipientBytes, subjectBytes, bodyBytes, htmlBodyBytes, &m.Status, &m.Attempts, &m.NextAttemptAt, lastErrorBytes, &m.CreatedAt, &m.UpdatedAt)
+		if err != nil {
+			return nil, errorz.MapDBErr(err)
+		}
+
+		m.Recipient = email.Address(recipientBytes.Data)
+		m.Subject = string(subjectBytes.Data)
+		m.Body = string(bodyBytes.Data)
+		m.HTMLBody = string(htmlBodyBytes.Data)
+		m.LastError = string(lastErrorBytes.Data)
+
+		out = append(out, m)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, errorz.MapDBErr(err)
+	}
+
+	return out, nil
+}
+
+// paramLastError adds the last error as an encrypted parameter, errors of the sender
+// can quote the recipient. Empty values can't be encrypted, so no error is stored as NULL.
+func paramLastError(q *db.Query, lastError string) {
+	if lastError == "" {
+		q.Param(nil)
+		return
+	}
+
+	q.ParamEncrypted([]byte(lastError))
+}
+
+func deleteMessages(q db.Query, ef execFunc, f outbox.MessageFilter) error {
+	q.Unsafe(`DELETE FROM email_outbox WHERE 1=1 `)
+	whereMessages(&q, f)
+
+	s, params, err := q.Get()
+	if err != nil {
+		return err
+	}
+
+	_, err = ef(s, params...)
+	if err != nil {
+		return errorz.MapDBErr(err)
+	}
+
+	return nil
+}
+
+func whereMessages(q *db.Query, f outbox.MessageFilter) {
+	if len(f.IDs) > 0 {
+		q.Unsafe(`AND id IN (`)
+		q.Params(anySlice(f.IDs)...)
+		q.Unsafe(`) `)
+	}
+
+	if len(f.Statuses) > 0 {
+		q.Unsafe(`AND status IN (`)
+		q.Params(anySlice(f.Statuses)...)
+		q.Unsafe(`) `)
+	}
+
+	if f.DueAt != nil {
+		// next_attempt_at is always stored in UTC, so that it can be compared as text.
+		q.Unsafe(`AND next_attempt_at <= `)
+		q.Param(f.DueAt.UTC())
+		q.Unsafe(` `)
+	}
+
+	if f.UpdatedBefore != nil {
+		// updated_at is always stored in UTC, so that it can be compared as text.
+		q.Unsafe(`AND updated_at < `)
+		q.Param(f.UpdatedBefore.UTC())
+		q.Unsafe(` `)
+	}
+}
+
+func anySlice[T any](s []T) []any {
+	out := make([]any, 0, len(s))
+	for _, v := range s {
+		out = append(out, v)
+	}
+	return out
+}
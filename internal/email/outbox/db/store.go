@@ -0,0 +1,85 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/google/uuid"
+	"github.com/willemschots/househunt/internal/db"
+	"github.com/willemschots/househunt/internal/email"
+	"github.com/willemschots/househunt/internal/email/outbox"
+	"github.com/willemschots/househunt/internal/krypto"
+)
+
+// Store is responsible for interacting with a database.
+type Store struct {
+	writeDB   *sql.DB
+	readDB    *sql.DB
+	encryptor *krypto.Encryptor
+}
+
+// New creates a new Store.
+func New(writeDB, readDB *sql.DB, encryptor *krypto.Encryptor) *Store {
+	return &Store{
+		writeDB:   writeDB,
+		readDB:    readDB,
+		encryptor: encryptor,
+	}
+}
+
+func (s *Store) newQuery() db.Query {
+	return db.Query{
+		Encryptor: s.encryptor,
+	}
+}
+
+// BeginTx starts a new transaction.
+func (s *Store) BeginTx(ctx context.Context) (outbox.Tx, error) {
+	tx, err := s.writeDB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	return &Tx{
+		tx:    tx,
+		store: s,
+	}, nil
+}
+
+func (s *Store) FindMessages(ctx context.Context, filter outbox.MessageFilter) ([]outbox.Message, error) {
+	return selectMessages(s.newQuery(), func(query string, params ...any) (*sql.Rows, error) {
+		return s.readDB.QueryContext(ctx, query, params...)
+	}, filter)
+}
+
+// CreateMessage creates a message in the database as part of an existing transaction.
+// This allows other stores to queue emails in the same transaction as the changes
+// that caused them.
+func CreateMessage(tx *sql.Tx, encryptor *krypto.Encryptor, m outbox.Message) error {
+	return insertMessage(db.Query{Encryptor: encryptor}, tx.Exec, m)
+}
+
+// DeleteRecipientMessages deletes all messages to the recipient as part of an existing
+// transaction. This allows other stores to delete the emails of a user in the same
+// transaction as the user. Recipients are encrypted, so every message is decrypted to
+// find the ones to delete.
+func DeleteRecipientMessages(tx *sql.Tx, encryptor *krypto.Encryptor, recipient email.Address) error {
+	q := db.Query{Encryptor: encryptor}
+
+	msgs, err := selectMessages(q, tx.Query, outbox.MessageFilter{})
+	if err != nil {
+		return err
+	}
+
+	ids := make([]uuid.UUID, 0)
+	for _, m := range msgs {
+		if m.Recipient == recipient {
+			ids = append(ids, m.ID)
+		}
+	}
+
+	if len(ids) == 0 {
+		return nil
+	}
+
+	return deleteMessages(q, tx.Exec, outbox.MessageFilter{IDs: ids})
+}
@@ -0,0 +1,462 @@
+package db_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/willemschots/househunt/internal/db/testdb"
+	"github.com/willemschots/househunt/internal/email"
+	"github.com/willemschots/househunt/internal/email/outbox"
+	"github.com/willemschots/househunt/internal/email/outbox/db"
+	"github.com/willemschots/househunt/internal/errorz"
+	"github.com/willemschots/househunt/internal/krypto"
+)
+
+func Test_Tx_CreateMessage(t *testing.T) {
+	t.Run("ok, create message", inTx(func(t *testing.T, tx outbox.Tx) {
+		m := newMessage(t, nil)
+
+		err := tx.CreateMessage(m)
+		if err != nil {
+			t.Fatalf("failed to save message: %v", err)
+		}
+
+		assertFindMessage(t, tx, m)
+	}))
+
+	t.Run("ok, create message without HTML body", inTx(func(t *testing.T, tx outbox.Tx) {
+		m := newMessage(t, func(m *outbox.Message) {
+			m.HTMLBody = ""
+		})
+
+		err := tx.CreateMessage(m)
+		if err != nil {
+			t.Fatalf("failed to save message: %v", err)
+		}
+
+		assertFindMessage(t, tx, m)
+	}))
+
+	t.Run("fail, duplicate ID", inTx(func(t *testing.T, tx outbox.Tx) {
+		m := newMessage(t, nil)
+
+		err := tx.CreateMessage(m)
+		if err != nil {
+			t.Fatalf("failed to save message: %v", err)
+		}
+
+		err = tx.CreateMessage(m)
+		if !errors.Is(err, errorz.ErrConstraintViolated) {
+			t.Fatalf("expected errors to be %v got %v (via errors.Is)", errorz.ErrConstraintViolated, err)
+		}
+	}))
+
+	t.Run("fail, zero ID", inTx(func(t *testing.T, tx outbox.Tx) {
+		m := newMessage(t, func(m *outbox.Message) {
+			m.ID = uuid.Nil
+		})
+
+		err := tx.CreateMessage(m)
+		if !errors.Is(err, errorz.ErrConstraintViolated) {
+			t.Fatalf("expected errors to be %v got %v (via errors.Is)", errorz.ErrConstraintViolated, err)
+		}
+	}))
+}
+
+func Test_Tx_UpdateMessage(t *testing.T) {
+	setup := func(t *testing.T, tx outbox.Tx) outbox.Message {
+		m := newMessage(t, nil)
+		err := tx.CreateMessage(m)
+		if err != nil {
+			t.Fatalf("failed to save message: %v", err)
+		}
+
+		return m
+	}
+
+	t.Run("ok, update message", inTx(func(t *testing.T, tx outbox.Tx) {
+		m := setup(t, tx)
+
+		// Update all fields that can be modified.
+		m.Status = outbox.StatusDead
+		m.Attempts = 3
+		m.NextAttemptAt = now(t, 2)
+		m.LastError = "mailbox unavailable"
+		m.UpdatedAt = now(t, 1)
+
+		err := tx.UpdateMessage(m)
+		if err != nil {
+			t.Fatalf("failed to save message: %v", err)
+		}
+
+		assertFindMessage(t, tx, m)
+	}))
+
+	t.Run("ok, email itself is not updated", inTx(func(t *testing.T, tx outbox.Tx) {
+		m := setup(t, tx)
+		want := m
+
+		m.Recipient = "other@example.com"
+		m.Body = "Other body"
+
+		err := tx.UpdateMessage(m)
+		if err != nil {
+			t.Fatalf("failed to save message: %v", err)
+		}
+
+		assertFindMessage(t, tx, want)
+	}))
+
+	t.Run("fail, not found", inTx(func(t *testing.T, tx outbox.Tx) {
+		m := setup(t, tx)
+
+		m.ID = must(uuid.Parse("4516a1c0-efc3-4561-9e97-e749e008aa3f"))
+
+		err := tx.UpdateMessage(m)
+		if !errors.Is(err, errorz.ErrNotFound) {
+			t.Fatalf("expected errors to be %v got %v (via errors.Is)", errorz.ErrNotFound, err)
+		}
+	}))
+}
+
+func Test_Tx_FindMessages(t *testing.T) {
+	setupMessages := func(t *testing.T, tx outbox.Tx) []outbox.Message {
+		// Messages are ordered by their next attempt.
+		msgs := []outbox.Message{
+			newMessage(t, func(m *outbox.Message) {
+				m.NextAttemptAt = now(t, 1)
+			}),
+			newMessage(t, func(m *outbox.Message) {
+				m.ID = must(uuid.Parse("4516a1c0-efc3-4561-9e97-e749e008aa3f"))
+				m.Status = outbox.StatusSent
+				m.NextAttemptAt = now(t, 2)
+				m.UpdatedAt = now(t, 2)
+			}),
+			newMessage(t, func(m *outbox.Message) {
+				m.ID = must(uuid.Parse("c4a3d7c4-8e6e-4a3b-bb8a-2f7c3e1b5f6d"))
+				// Times in other timezones are compared correctly.
+				m.NextAttemptAt = now(t, 3).In(time.FixedZone("UTC+2", 2*60*60))
+				m.CreatedAt = now(t, 3).In(time.FixedZone("UTC+2", 2*60*60))
+			}),
+		}
+
+		for i := range msgs {
+			err := tx.CreateMessage(msgs[i])
+			if err != nil {
+				t.Fatalf("failed to save message: %v", err)
+			}
+
+			// Times are returned in UTC.
+			msgs[i].NextAttemptAt = msgs[i].NextAttemptAt.UTC()
+			msgs[i].CreatedAt = msgs[i].CreatedAt.UTC()
+		}
+
+		return msgs
+	}
+
+	tests := map[string]struct {
+		filter   outbox.MessageFilter
+		wantFunc func([]outbox.Message) []outbox.Message
+	}{
+		"ok, all messages, empty slices": {
+			filter: outbox.MessageFilter{
+				IDs:      []uuid.UUID{},
+				Statuses: []outbox.Status{},
+			},
+			wantFunc: func(msgs []outbox.Message) []outbox.Message {
+				return msgs
+			},
+		},
+		"ok, several by id": {
+			filter: outbox.MessageFilter{
+				IDs: []uuid.UUID{
+					must(uuid.Parse("8a1b2c3d-4e5f-4a6b-8c7d-9e0f1a2b3c4d")),
+					must(uuid.Parse("c4a3d7c4-8e6e-4a3b-bb8a-2f7c3e1b5f6d")),
+				},
+			},
+			wantFunc: func(msgs []outbox.Message) []outbox.Message {
+				return []outbox.Message{msgs[0], msgs[2]}
+			},
+		},
+		"ok, by status": {
+			filter: outbox.MessageFilter{
+				Statuses: []outbox.Status{outbox.StatusPending},
+			},
+			wantFunc: func(msgs []outbox.Message) []outbox.Message {
+				return []outbox.Message{msgs[0], msgs[2]}
+			},
+		},
+		"ok, due at": {
+			filter: outbox.MessageFilter{
+				DueAt: ptr(now(t, 2).In(time.FixedZone("UTC-2", -2*60*60))),
+			},
+			wantFunc: func(msgs []outbox.Message) []outbox.Message {
+				return msgs[0:2]
+			},
+		},
+		"ok, updated before": {
+			filter: outbox.MessageFilter{
+				UpdatedBefore: ptr(now(t, 2).In(time.FixedZone("UTC+2", 2*60*60))),
+			},
+			wantFunc: func(msgs []outbox.Message) []outbox.Message {
+				return []outbox.Message{msgs[0], msgs[2]}
+			},
+		},
+		"ok, limit": {
+			filter: outbox.MessageFilter{
+				Limit: 2,
+			},
+			wantFunc: func(msgs []outbox.Message) []outbox.Message {
+				return msgs[0:2]
+			},
+		},
+		"ok, combine filters": {
+			filter: outbox.MessageFilter{
+				Statuses: []outbox.Status{outbox.StatusPending},
+				DueAt:    ptr(now(t, 9)),
+				Limit:    1,
+			},
+			wantFunc: func(msgs []outbox.Message) []outbox.Message {
+				return msgs[0:1]
+			},
+		},
+		"ok, no results": {
+			filter: outbox.MessageFilter{
+				IDs: []uuid.UUID{uuid.Nil},
+			},
+			wantFunc: func(msgs []outbox.Message) []outbox.Message {
+				return []outbox.Message{}
+			},
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			store := storeForTest(t)
+
+			tx, err := store.BeginTx(context.Background())
+			if err != nil {
+				t.Fatalf("failed to begin tx: %v", err)
+			}
+
+			msgs := setupMessages(t, tx)
+			want := tc.wantFunc(msgs)
+
+			// first check if FindMessages works on the tx
+			got, err := tx.FindMessages(tc.filter)
+			if err != nil {
+				t.Fatalf("failed to find messages: %v", err)
+			}
+
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("got\n%#v\nwant\n%#v\n", got, want)
+			}
+
+			err = tx.Commit()
+			if err != nil {
+				t.Fatalf("failed to commit tx: %v", err)
+			}
+
+			// then, check if FindMessages works on the store itself.
+			got, err = store.FindMessages(context.Background(), tc.filter)
+			if err != nil {
+				t.Fatalf("failed to find messages: %v", err)
+			}
+
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("got\n%#v\nwant\n%#v\n", got, want)
+			}
+		})
+	}
+}
+
+func Test_Tx_DeleteMessages(t *testing.T) {
+	t.Run("ok, delete sent messages updated before", inTx(func(t *testing.T, tx outbox.Tx) {
+		pending := newMessage(t, nil)
+		oldSent := newMessage(t, func(m *outbox.Message) {
+			m.ID = must(uuid.Parse("4516a1c0-efc3-4561-9e97-e749e008aa3f"))
+			m.Status = outbox.StatusSent
+			m.UpdatedAt = now(t, 1)
+		})
+		newSent := newMessage(t, func(m *outbox.Message) {
+			m.ID = must(uuid.Parse("c4a3d7c4-8e6e-4a3b-bb8a-2f7c3e1b5f6d"))
+			m.Status = outbox.StatusSent
+			m.UpdatedAt = now(t, 3)
+		})
+
+		for _, m := range []outbox.Message{pending, oldSent, newSent} {
+			err := tx.CreateMessage(m)
+			if err != nil {
+				t.Fatalf("failed to save message: %v", err)
+			}
+		}
+
+		err := tx.DeleteMessages(outbox.MessageFilter{
+			Statuses:      []outbox.Status{outbox.StatusSent},
+			UpdatedBefore: ptr(now(t, 2)),
+		})
+		if err != nil {
+			t.Fatalf("failed to delete messages: %v", err)
+		}
+
+		got, err := tx.FindMessages(outbox.MessageFilter{})
+		if err != nil {
+			t.Fatalf("failed to find messages: %v", err)
+		}
+
+		want := []outbox.Message{pending, newSent}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("got\n%#v\nwant\n%#v\n", got, want)
+		}
+	}))
+}
+
+func Test_DeleteRecipientMessages(t *testing.T) {
+	encryptor := must(krypto.NewEncryptor([]krypto.Key{
+		must(krypto.ParseKey("2b671594b775f371eab4050b4d58326682df6b1a6cc2e886717b1a26b4d6c45d")),
+	}))
+
+	testDB := testdb.RunWhile(t, true)
+	store := db.New(testDB, testDB, encryptor)
+
+	other := newMessage(t, func(m *outbox.Message) {
+		m.ID = must(uuid.Parse("4516a1c0-efc3-4561-9e97-e749e008aa3f"))
+		m.Recipient = "other@example.com"
+	})
+
+	sqlTx, err := testDB.Begin()
+	if err != nil {
+		t.Fatalf("failed to begin tx: %v", err)
+	}
+
+	for _, m := range []outbox.Message{newMessage(t, nil), other} {
+		err = db.CreateMessage(sqlTx, encryptor, m)
+		if err != nil {
+			t.Fatalf("failed to save message: %v", err)
+		}
+	}
+
+	err = db.DeleteRecipientMessages(sqlTx, encryptor, "info@example.com")
+	if err != nil {
+		t.Fatalf("failed to delete messages: %v", err)
+	}
+
+	err = sqlTx.Commit()
+	if err != nil {
+		t.Fatalf("failed to commit tx: %v", err)
+	}
+
+	got, err := store.FindMessages(context.Background(), outbox.MessageFilter{})
+	if err != nil {
+		t.Fatalf("failed to find messages: %v", err)
+	}
+
+	if len(got) != 1 || got[0].ID != other.ID {
+		t.Fatalf("expected only the message to the other recipient, got %+v", got)
+	}
+}
+
+func inTx(f func(*testing.T, outbox.Tx)) func(*testing.T) {
+	return func(t *testing.T) {
+		store := storeForTest(t)
+
+		tx, err := store.BeginTx(context.Background())
+		if err != nil {
+			t.Fatalf("failed to begin tx: %v", err)
+		}
+
+		f(t, tx)
+
+		err = tx.Commit()
+		if err != nil {
+			t.Fatalf("failed to commit tx: %v", err)
+		}
+	}
+}
+
+func now(t *testing.T, i int) time.Time {
+	t.Helper()
+
+	if i > 9 {
+		t.Fatalf("invalid time index: %d", i)
+	}
+
+	ts, err := time.Parse(time.RFC3339, fmt.Sprintf("2021-01-01T00:00:0%dZ", i))
+	if err != nil {
+		t.Fatalf("failed to parse time: %v", err)
+	}
+
+	return ts
+}
+
+func storeForTest(t *testing.T) *db.Store {
+	t.Helper()
+
+	encryptor := must(krypto.NewEncryptor([]krypto.Key{
+		must(krypto.ParseKey("2b671594b775f371eab4050b4d58326682df6b1a6cc2e886717b1a26b4d6c45d")),
+	}))
+
+	testDB := testdb.RunWhile(t, true)
+
+	return db.New(testDB, testDB, encryptor)
+}
+
+func newMessage(t *testing.T, modFunc func(*outbox.Message)) outbox.Message {
+	t.Helper()
+
+	m := outbox.Message{
+		ID: must(uuid.Parse("8a1b2c3d-4e5f-4a6b-8c7d-9e0f1a2b3c4d")),
+		Message: email.Message{
+			From:      "househunt@example.com",
+			Recipient: "info@example.com",
+			Subject:   "Activate your account",
+			Body:      "Visit https://example.com/activate",
+			HTMLBody:  `<p>Visit <a href="https://example.com/activate">example.com</a></p>`,
+		},
+		Status:        outbox.StatusPending,
+		Attempts:      0,
+		NextAttemptAt: now(t, 0),
+		LastError:     "",
+		CreatedAt:     now(t, 0),
+		UpdatedAt:     now(t, 0),
+	}
+
+	if modFunc != nil {
+		modFunc(&m)
+	}
+
+	return m
+}
+
+func assertFindMessage(t *testing.T, tx outbox.Tx, want outbox.Message) {
+	t.Helper()
+
+	got, err := tx.FindMessages(outbox.MessageFilter{IDs: []uuid.UUID{want.ID}})
+	if err != nil {
+		t.Fatalf("failed to find message: %v", err)
+	}
+
+	if len(got) != 1 {
+		t.Fatalf("expected 1 message, got %d", len(got))
+	}
+
+	if !reflect.DeepEqual(got[0], want) {
+		t.Errorf("got\n%#v\nwant\n%#v\n", got[0], want)
+	}
+}
+
+func ptr[T any](v T) *T {
+	return &v
+}
+
+func must[T any](v T, err error) T {
+	if err != nil {
+		panic(err)
+	}
+	return v
+}
@@ -0,0 +1,42 @@
+package db
+
+import (
+	"database/sql"
+
+	"github.com/willemschots/househunt/internal/email/outbox"
+)
+
+type Tx struct {
+	tx    *sql.Tx
+	store *Store
+}
+
+func (t *Tx) Commit() error {
+	return t.tx.Commit()
+}
+
+func (t *Tx) Rollback() error {
+	return t.tx.Rollback()
+}
+
+// CreateMessage creates a message in the database.
+func (t *Tx) CreateMessage(m outbox.Message) error {
+	return insertMessage(t.store.newQuery(), t.tx.Exec, m)
+}
+
+// UpdateMessage updates a message in the database.
+// It returns errorz.ErrNotFound if no message is found.
+func (t *Tx) UpdateMessage(m outbox.Message) error {
+	return updateMessage(t.store.newQuery(), t.tx.Exec, m)
+}
+
+// DeleteMessages deletes all messages that match the provided filter.
+func (t *Tx) DeleteMessages(filter outbox.MessageFilter) error {
+	return deleteMessages(t.store.newQuery(), t.tx.Exec, filter)
+}
+
+// FindMessages queries for messages based on the provided filter.
+// It returns an empty slice if no messages are found.
+func (t *Tx) FindMessages(filter outbox.MessageFilter) ([]outbox.Message, error) {
+	return selectMessages(t.store.newQuery(), t.tx.Query, filter)
+}
@@ -0,0 +1,261 @@
+package outbox
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/willemschots/househunt/internal/email"
+	"github.com/willemschots/househunt/internal/email/retry"
+)
+
+// ErrFunc is a function that handles errors.
+type ErrFunc func(error)
+
+// Suppressions reports whether emails to an address should no longer be sent, for
+// example because earlier emails to it bounced. It's implemented by *auth.Service.
+type Suppressions interface {
+	EmailSuppressed(ctx context.Context, addr email.Address) (bool, error)
+}
+
+// DispatcherConfig is the configuration for the Dispatcher.
+type DispatcherConfig struct {
+	// Interval is the duration between checks for due messages.
+	Interval time.Duration
+	// BatchSize is the maximum number of messages sent per check.
+	BatchSize int
+	// MaxAttempts is the number of failed attempts after which a
+	// message is marked as dead and no longer retried. Only messages
+	// that failed with an email.RetryableError are retried.
+	MaxAttempts int
+	// MinBackoff is the delay after the first failed attempt, it
+	// doubles for every failed attempt after that. If the server asked
+	// to wait for a specific duration, that is used instead.
+	MinBackoff time.Duration
+	// MaxBackoff is the maximum delay between two attempts.
+	MaxBackoff time.Duration
+	// Retention is how long sent, dead and suppressed messages are kept after their last attempt.
+	// They contain the recipient and links with tokens, so they should not be kept
+	// longer than needed to debug delivery. Zero disables the deletion of messages.
+	Retention time.Duration
+}
+
+// Dispatcher sends the pending messages in the outbox using an email.Sender.
+//
+// Messages are delivered at least once: if the Dispatcher is stopped after a message
+// was sent but before its status was updated, the message will be sent again.
+type Dispatcher struct {
+	store        Store
+	sender       email.Sender
+	suppressions Suppressions
+	errHandler   ErrFunc
+	cfg          DispatcherConfig
+
+	// NowFunc is used to get the current time.
+	// Exposed for testing purposes.
+	NowFunc func() time.Time
+}
+
+// NewDispatcher creates a new Dispatcher.
+func NewDispatcher(s Store, sender email.Sender, suppressions Suppressions, errHandler ErrFunc, cfg DispatcherConfig) *Dispatcher {
+	return &Dispatcher{
+		store:        s,
+		sender:       sender,
+		suppressions: suppressions,
+		errHandler:   errHandler,
+		cfg:          cfg,
+		NowFunc:      time.Now,
+	}
+}
+
+// Run dispatches due messages and prunes old messages every interval until ctx
+// is cancelled. Errors are reported to the error handler, Run itself only returns
+// when ctx is done.
+func (d *Dispatcher) Run(ctx context.Context) error {
+	ticker := time.NewTicker(d.cfg.Interval)
+	defer ticker.Stop()
+
+	for {
+		_, err := d.Dispatch(ctx)
+		if err != nil && ctx.Err() == nil {
+			d.errHandler(err)
+		}
+
+		err = d.Prune(ctx)
+		if err != nil && ctx.Err() == nil {
+			d.errHandler(err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// Dispatch attempts to send a single batch of due messages and returns the number
+// of messages that were sent successfully.
+//
+// Failed sends are reported to the error handler and scheduled for a retry, they
+// don't cause Dispatch to return an error.
+func (d *Dispatcher) Dispatch(ctx context.Context) (int, error) {
+	now := d.NowFunc()
+
+	msgs, err := d.store.FindMessages(ctx, MessageFilter{
+		Statuses: []Status{StatusPending},
+		DueAt:    &now,
+		Limit:    d.cfg.BatchSize,
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	sent := 0
+	for _, m := range msgs {
+		if ctx.Err() != nil {
+			return sent, ctx.Err()
+		}
+
+		m, err = d.attempt(ctx, m)
+		if err != nil {
+			return sent, err
+		}
+
+		if m.Status == StatusSent {
+			sent++
+		}
+	}
+
+	return sent, nil
+}
+
+// Prune deletes the sent, dead and suppressed messages that are past their retention.
+func (d *Dispatcher) Prune(ctx context.Context) error {
+	if d.cfg.Retention <= 0 {
+		return nil
+	}
+
+	before := d.NowFunc().Add(-d.cfg.Retention)
+
+	return d.inTx(ctx, func(tx Tx) error {
+		return tx.DeleteMessages(MessageFilter{
+			Statuses:      []Status{StatusSent, StatusDead, StatusSuppressed},
+			UpdatedBefore: &before,
+		})
+	})
+}
+
+// attempt sends a message and records the outcome.
+//
+// The recipient is checked right before sending, so that messages that were queued
+// before an earlier email to the recipient bounced are not sent either. Sending to
+// such addresses harms the reputation of the sender.
+//
+// Messages that fail with a retryable error are retried until MaxAttempts is reached,
+// other errors will keep failing so those messages are marked as dead right away.
+// Messages rejected because the circuit of the sender is open were never handed to
+// the server, so they are rescheduled without counting as an attempt.
+func (d *Dispatcher) attempt(ctx context.Context, m Message) (Message, error) {
+	suppressed, err := d.suppressions.EmailSuppressed(ctx, m.Recipient)
+	if err != nil {
+		return Message{}, err
+	}
+
+	if suppressed {
+		m.Status = StatusSuppressed
+		m.UpdatedAt = d.NowFunc()
+		return d.update(ctx, m)
+	}
+
+	sendErr := d.sender.Send(ctx, m.Message)
+	if sendErr != nil && ctx.Err() != nil {
+		// Don't count attempts that were interrupted by shutting down.
+		return Message{}, ctx.Err()
+	}
+
+	now := d.NowFunc()
+	m.UpdatedAt = now
+
+	var retryable *email.RetryableError
+	if errors.Is(sendErr, retry.ErrCircuitOpen) && errors.As(sendErr, &retryable) {
+		// Not reported to the error handler, the retry sender reports
+		// when the circuit opens and closes.
+		m.NextAttemptAt = now.Add(retryable.RetryAfter)
+		m.LastError = sendErr.Error()
+		return d.update(ctx, m)
+	}
+
+	m.Attempts++
+
+	switch {
+	case sendErr == nil:
+		m.Status = StatusSent
+		m.LastError = ""
+	case !errors.As(sendErr, &retryable):
+		m.Status = StatusDead
+		m.LastError = sendErr.Error()
+		d.errHandler(fmt.Errorf("giving up on email message %s, error is not retryable: %w", m.ID, sendErr))
+	case m.Attempts >= d.cfg.MaxAttempts:
+		m.Status = StatusDead
+		m.LastError = sendErr.Error()
+		d.errHandler(fmt.Errorf("giving up on email message %s after %d attempts: %w", m.ID, m.Attempts, sendErr))
+	default:
+		delay := retryable.RetryAfter
+		if delay == 0 {
+			delay = d.backoff(m.Attempts)
+		}
+		m.NextAttemptAt = now.Add(delay)
+		m.LastError = sendErr.Error()
+		d.errHandler(fmt.Errorf("failed to send email message %s (attempt %d): %w", m.ID, m.Attempts, sendErr))
+	}
+
+	return d.update(ctx, m)
+}
+
+// update stores the outcome of an attempt.
+func (d *Dispatcher) update(ctx context.Context, m Message) (Message, error) {
+	err := d.inTx(ctx, func(tx Tx) error {
+		return tx.UpdateMessage(m)
+	})
+	if err != nil {
+		return Message{}, err
+	}
+
+	return m, nil
+}
+
+// backoff returns the delay before the next attempt, after the
+// provided number of failed attempts.
+func (d *Dispatcher) backoff(attempts int) time.Duration {
+	delay := d.cfg.MinBackoff
+	for i := 1; i < attempts && delay < d.cfg.MaxBackoff; i++ {
+		delay *= 2
+	}
+
+	return min(delay, d.cfg.MaxBackoff)
+}
+
+func (d *Dispatcher) inTx(ctx context.Context, f func(tx Tx) error) error {
+	tx, err := d.store.BeginTx(ctx)
+	if err != nil {
+		return err
+	}
+
+	err = f(tx)
+	if err != nil {
+		rBackErr := tx.Rollback()
+		if rBackErr != nil {
+			err = errors.Join(err, rBackErr)
+		}
+		return err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
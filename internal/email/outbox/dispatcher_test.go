@@ -0,0 +1,537 @@
+package outbox_test
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/willemschots/househunt/internal/db/testdb"
+	"github.com/willemschots/househunt/internal/email"
+	"github.com/willemschots/househunt/internal/email/outbox"
+	"github.com/willemschots/househunt/internal/email/outbox/db"
+	"github.com/willemschots/househunt/internal/email/retry"
+	"github.com/willemschots/househunt/internal/errorz/testerr"
+	"github.com/willemschots/househunt/internal/krypto"
+)
+
+func Test_Dispatcher_Dispatch(t *testing.T) {
+	t.Run("ok, sends due messages", func(t *testing.T) {
+		dt := newDispatcherTest(t)
+		due := dt.queue(t, "due@example.com", dt.now)
+		later := dt.queue(t, "later@example.com", dt.now.Add(time.Minute))
+
+		dt.assertDispatch(t, 1)
+
+		if len(dt.sender.Emails) != 1 || dt.sender.Emails[0].Recipient != "due@example.com" {
+			t.Fatalf("expected a single email to due@example.com, got %+v", dt.sender.Emails)
+		}
+
+		got := dt.find(t, due.ID)
+		if got.Status != outbox.StatusSent || got.Attempts != 1 || !got.UpdatedAt.Equal(dt.now) {
+			t.Errorf("unexpected message after sending: %+v", got)
+		}
+
+		got = dt.find(t, later.ID)
+		if got.Status != outbox.StatusPending || got.Attempts != 0 {
+			t.Errorf("unexpected message that is not due: %+v", got)
+		}
+
+		// Sent messages are not sent again.
+		dt.assertDispatch(t, 0)
+		dt.errs.assertNone(t)
+	})
+
+	t.Run("ok, respects batch size", func(t *testing.T) {
+		dt := newDispatcherTest(t)
+		for i := 0; i < 3; i++ {
+			dt.queue(t, "info@example.com", dt.now)
+		}
+
+		dt.assertDispatch(t, 2)
+		dt.assertDispatch(t, 1)
+		dt.assertDispatch(t, 0)
+	})
+
+	t.Run("ok, retries with backoff", func(t *testing.T) {
+		dt := newDispatcherTest(t)
+		msg := dt.queue(t, "info@example.com", dt.now)
+		start := dt.now
+
+		sender := &failingSender{fails: 3, sender: dt.sender}
+		dt.dispatcher = outbox.NewDispatcher(dt.store, sender, dt.suppressions, dt.errs.append, dt.cfg)
+		dt.dispatcher.NowFunc = func() time.Time { return dt.now }
+
+		// First attempt fails, retry after the min backoff.
+		dt.assertDispatch(t, 0)
+		dt.assertScheduled(t, msg.ID, 1, start.Add(time.Minute))
+
+		// Not due yet.
+		dt.now = start.Add(59 * time.Second)
+		dt.assertDispatch(t, 0)
+
+		// Second attempt fails, backoff doubles.
+		dt.now = start.Add(time.Minute)
+		dt.assertDispatch(t, 0)
+		dt.assertScheduled(t, msg.ID, 2, dt.now.Add(2*time.Minute))
+
+		// Third attempt fails, backoff is capped.
+		dt.now = dt.now.Add(2 * time.Minute)
+		dt.assertDispatch(t, 0)
+		dt.assertScheduled(t, msg.ID, 3, dt.now.Add(3*time.Minute))
+
+		// Fourth attempt succeeds.
+		dt.now = dt.now.Add(3 * time.Minute)
+		dt.assertDispatch(t, 1)
+
+		got := dt.find(t, msg.ID)
+		if got.Status != outbox.StatusSent || got.Attempts != 4 || got.LastError != "" {
+			t.Errorf("unexpected message after sending: %+v", got)
+		}
+
+		if len(dt.errs.errs) != 3 {
+			t.Fatalf("expected 3 reported errors, got %v", dt.errs.errs)
+		}
+	})
+
+	t.Run("ok, dead after max attempts", func(t *testing.T) {
+		dt := newDispatcherTest(t)
+		msg := dt.queue(t, "info@example.com", dt.now)
+
+		sender := &failingSender{fails: 10, sender: dt.sender}
+		dt.dispatcher = outbox.NewDispatcher(dt.store, sender, dt.suppressions, dt.errs.append, dt.cfg)
+		dt.dispatcher.NowFunc = func() time.Time { return dt.now }
+
+		for i := 0; i < dt.cfg.MaxAttempts; i++ {
+			dt.assertDispatch(t, 0)
+			dt.now = dt.now.Add(time.Hour)
+		}
+
+		got := dt.find(t, msg.ID)
+		if got.Status != outbox.StatusDead || got.Attempts != dt.cfg.MaxAttempts {
+			t.Errorf("expected message to be dead, got: %+v", got)
+		}
+
+		// Dead messages are not retried.
+		dt.assertDispatch(t, 0)
+
+		if len(sender.sender.Emails) != 0 {
+			t.Fatalf("expected no emails to be sent, got %d", len(sender.sender.Emails))
+		}
+
+		for _, err := range dt.errs.errs {
+			if !errors.Is(err, testerr.Err) {
+				t.Fatalf("expected error %v, got %v (via errors.Is)", testerr.Err, err)
+			}
+		}
+	})
+	t.Run("ok, retry after of the server is used", func(t *testing.T) {
+		dt := newDispatcherTest(t)
+		msg := dt.queue(t, "info@example.com", dt.now)
+
+		sender := &failingSender{
+			fails:  1,
+			err:    &email.RetryableError{Err: testerr.Err, RetryAfter: 10 * time.Minute},
+			sender: dt.sender,
+		}
+		dt.dispatcher = outbox.NewDispatcher(dt.store, sender, dt.suppressions, dt.errs.append, dt.cfg)
+		dt.dispatcher.NowFunc = func() time.Time { return dt.now }
+
+		dt.assertDispatch(t, 0)
+		dt.assertScheduled(t, msg.ID, 1, dt.now.Add(10*time.Minute))
+	})
+
+	t.Run("ok, dead right away if error is not retryable", func(t *testing.T) {
+		dt := newDispatcherTest(t)
+		msg := dt.queue(t, "info@example.com", dt.now)
+
+		sender := &failingSender{fails: 1, err: testerr.Err, sender: dt.sender}
+		dt.dispatcher = outbox.NewDispatcher(dt.store, sender, dt.suppressions, dt.errs.append, dt.cfg)
+		dt.dispatcher.NowFunc = func() time.Time { return dt.now }
+
+		dt.assertDispatch(t, 0)
+
+		got := dt.find(t, msg.ID)
+		if got.Status != outbox.StatusDead || got.Attempts != 1 || got.LastError == "" {
+			t.Errorf("expected message to be dead, got: %+v", got)
+		}
+
+		// Dead messages are not retried.
+		dt.now = dt.now.Add(time.Hour)
+		dt.assertDispatch(t, 0)
+
+		if len(dt.errs.errs) != 1 || !errors.Is(dt.errs.errs[0], testerr.Err) {
+			t.Fatalf("expected a single error %v, got %v", testerr.Err, dt.errs.errs)
+		}
+	})
+
+	t.Run("ok, open circuit does not count as attempt", func(t *testing.T) {
+		dt := newDispatcherTest(t)
+		msg := dt.queue(t, "info@example.com", dt.now)
+		start := dt.now
+
+		next := &failingSender{fails: 1, sender: dt.sender}
+		sender := retry.NewSender(next, retry.Config{
+			MaxAttempts:      1,
+			MinBackoff:       time.Second,
+			MaxBackoff:       time.Second,
+			FailureThreshold: 1,
+			Cooldown:         5 * time.Minute,
+		})
+		sender.NowFunc = func() time.Time { return dt.now }
+		dt.dispatcher = outbox.NewDispatcher(dt.store, sender, dt.suppressions, dt.errs.append, dt.cfg)
+		dt.dispatcher.NowFunc = func() time.Time { return dt.now }
+
+		// First attempt fails and opens the circuit.
+		dt.assertDispatch(t, 0)
+		dt.assertScheduled(t, msg.ID, 1, start.Add(time.Minute))
+
+		// The circuit is still open, the message is rescheduled after the
+		// cooldown without counting as an attempt.
+		dt.now = start.Add(time.Minute)
+		dt.assertDispatch(t, 0)
+		dt.assertScheduled(t, msg.ID, 1, start.Add(5*time.Minute))
+
+		got := dt.find(t, msg.ID)
+		if got.LastError != retry.ErrCircuitOpen.Error() {
+			t.Fatalf("expected last error %q, got %q", retry.ErrCircuitOpen, got.LastError)
+		}
+
+		// Only the first failure is reported.
+		if len(dt.errs.errs) != 1 {
+			t.Fatalf("expected 1 reported error, got %v", dt.errs.errs)
+		}
+
+		// After the cooldown the message is sent.
+		dt.now = start.Add(5 * time.Minute)
+		dt.assertDispatch(t, 1)
+
+		got = dt.find(t, msg.ID)
+		if got.Status != outbox.StatusSent || got.Attempts != 2 {
+			t.Errorf("unexpected message after sending: %+v", got)
+		}
+	})
+
+	t.Run("ok, suppressed recipients are skipped", func(t *testing.T) {
+		dt := newDispatcherTest(t)
+		suppressed := dt.queue(t, "bounced@example.com", dt.now)
+		other := dt.queue(t, "info@example.com", dt.now)
+
+		dt.suppressions["bounced@example.com"] = true
+
+		dt.assertDispatch(t, 1)
+
+		if len(dt.sender.Emails) != 1 || dt.sender.Emails[0].Recipient != "info@example.com" {
+			t.Fatalf("expected a single email to info@example.com, got %+v", dt.sender.Emails)
+		}
+
+		got := dt.find(t, suppressed.ID)
+		if got.Status != outbox.StatusSuppressed || got.Attempts != 0 || !got.UpdatedAt.Equal(dt.now) {
+			t.Errorf("unexpected suppressed message: %+v", got)
+		}
+
+		got = dt.find(t, other.ID)
+		if got.Status != outbox.StatusSent {
+			t.Errorf("unexpected message after sending: %+v", got)
+		}
+
+		// Suppressed messages are not retried.
+		dt.now = dt.now.Add(time.Hour)
+		dt.assertDispatch(t, 0)
+		dt.errs.assertNone(t)
+	})
+
+	t.Run("fail, suppressions fail", func(t *testing.T) {
+		dt := newDispatcherTest(t)
+		msg := dt.queue(t, "info@example.com", dt.now)
+
+		dt.dispatcher = outbox.NewDispatcher(dt.store, dt.sender, failingSuppressions{}, dt.errs.append, dt.cfg)
+		dt.dispatcher.NowFunc = func() time.Time { return dt.now }
+
+		_, err := dt.dispatcher.Dispatch(context.Background())
+		if !errors.Is(err, testerr.Err) {
+			t.Fatalf("expected error %v, got %v (via errors.Is)", testerr.Err, err)
+		}
+
+		if len(dt.sender.Emails) != 0 {
+			t.Fatalf("expected no emails to be sent, got %d", len(dt.sender.Emails))
+		}
+
+		// The message is left as is, to be attempted again.
+		got := dt.find(t, msg.ID)
+		if got.Status != outbox.StatusPending || got.Attempts != 0 {
+			t.Errorf("unexpected message: %+v", got)
+		}
+	})
+}
+
+func Test_Dispatcher_Prune(t *testing.T) {
+	t.Run("ok, deletes sent, dead and suppressed messages past retention", func(t *testing.T) {
+		dt := newDispatcherTest(t)
+		start := dt.now
+
+		sent := dt.queue(t, "sent@example.com", dt.now)
+		dt.assertDispatch(t, 1)
+
+		suppressed := dt.queue(t, "suppressed@example.com", dt.now)
+		dt.suppressions["suppressed@example.com"] = true
+		dt.assertDispatch(t, 0)
+
+		dead := dt.queue(t, "dead@example.com", dt.now)
+		sender := &failingSender{fails: 1, err: testerr.Err, sender: dt.sender}
+		dt.dispatcher = outbox.NewDispatcher(dt.store, sender, dt.suppressions, dt.errs.append, dt.cfg)
+		dt.dispatcher.NowFunc = func() time.Time { return dt.now }
+		dt.assertDispatch(t, 0)
+
+		pending := dt.queue(t, "pending@example.com", dt.now.Add(48*time.Hour))
+
+		// Not past retention yet.
+		dt.now = start.Add(dt.cfg.Retention)
+		dt.assertPrune(t, sent.ID, suppressed.ID, dead.ID, pending.ID)
+
+		dt.now = start.Add(dt.cfg.Retention + time.Second)
+		dt.assertPrune(t, pending.ID)
+	})
+
+	t.Run("ok, zero retention keeps messages", func(t *testing.T) {
+		dt := newDispatcherTest(t)
+		dt.cfg.Retention = 0
+		dt.dispatcher = outbox.NewDispatcher(dt.store, dt.sender, dt.suppressions, dt.errs.append, dt.cfg)
+		dt.dispatcher.NowFunc = func() time.Time { return dt.now }
+
+		sent := dt.queue(t, "sent@example.com", dt.now)
+		dt.assertDispatch(t, 1)
+
+		dt.now = dt.now.Add(24 * 365 * time.Hour)
+		dt.assertPrune(t, sent.ID)
+	})
+}
+
+func Test_Dispatcher_Run(t *testing.T) {
+	dt := newDispatcherTest(t)
+	dt.cfg.Interval = time.Millisecond
+	dt.dispatcher = outbox.NewDispatcher(dt.store, dt.sender, dt.suppressions, dt.errs.append, dt.cfg)
+
+	msg := dt.queue(t, "info@example.com", time.Now())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- dt.dispatcher.Run(ctx)
+	}()
+
+	deadline := time.After(5 * time.Second)
+	for dt.find(t, msg.ID).Status != outbox.StatusSent {
+		select {
+		case <-deadline:
+			t.Fatalf("message was not sent in time")
+		case <-time.After(time.Millisecond):
+		}
+	}
+
+	cancel()
+
+	err := <-done
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	dt.errs.assertNone(t)
+}
+
+type dispatcherTest struct {
+	now          time.Time
+	cfg          outbox.DispatcherConfig
+	store        *db.Store
+	sender       *email.MemorySender
+	suppressions suppressions
+	errs         *errList
+	dispatcher   *outbox.Dispatcher
+}
+
+func newDispatcherTest(t *testing.T) *dispatcherTest {
+	t.Helper()
+
+	encryptor := must(krypto.NewEncryptor([]krypto.Key{
+		must(krypto.ParseKey("2b671594b775f371eab4050b4d58326682df6b1a6cc2e886717b1a26b4d6c45d")),
+	}))
+
+	testDB := testdb.RunWhile(t, true)
+
+	dt := &dispatcherTest{
+		now: must(time.Parse(time.RFC3339, "2021-01-01T00:00:00Z")),
+		cfg: outbox.DispatcherConfig{
+			Interval:    time.Second,
+			BatchSize:   2,
+			MaxAttempts: 5,
+			MinBackoff:  time.Minute,
+			MaxBackoff:  3 * time.Minute,
+			Retention:   24 * time.Hour,
+		},
+		store:        db.New(testDB, testDB, encryptor),
+		sender:       email.NewMemorySender(),
+		suppressions: suppressions{},
+		errs:         &errList{},
+	}
+
+	dt.dispatcher = outbox.NewDispatcher(dt.store, dt.sender, dt.suppressions, dt.errs.append, dt.cfg)
+	dt.dispatcher.NowFunc = func() time.Time { return dt.now }
+
+	return dt
+}
+
+func (dt *dispatcherTest) queue(t *testing.T, recipient email.Address, at time.Time) outbox.Message {
+	t.Helper()
+
+	m := must(outbox.NewMessage(email.Message{
+		From:      "househunt@example.com",
+		Recipient: recipient,
+		Subject:   "Hello",
+		Body:      "Hello world",
+	}, at))
+
+	tx, err := dt.store.BeginTx(context.Background())
+	if err != nil {
+		t.Fatalf("failed to begin tx: %v", err)
+	}
+
+	err = tx.CreateMessage(m)
+	if err != nil {
+		t.Fatalf("failed to create message: %v", err)
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		t.Fatalf("failed to commit tx: %v", err)
+	}
+
+	return m
+}
+
+func (dt *dispatcherTest) find(t *testing.T, id uuid.UUID) outbox.Message {
+	t.Helper()
+
+	msgs, err := dt.store.FindMessages(context.Background(), outbox.MessageFilter{
+		IDs: []uuid.UUID{id},
+	})
+	if err != nil {
+		t.Fatalf("failed to find message: %v", err)
+	}
+
+	if len(msgs) != 1 {
+		t.Fatalf("expected 1 message, got %d", len(msgs))
+	}
+
+	return msgs[0]
+}
+
+func (dt *dispatcherTest) assertDispatch(t *testing.T, want int) {
+	t.Helper()
+
+	got, err := dt.dispatcher.Dispatch(context.Background())
+	if err != nil {
+		t.Fatalf("failed to dispatch: %v", err)
+	}
+
+	if got != want {
+		t.Fatalf("expected %d sent messages, got %d", want, got)
+	}
+}
+
+// assertPrune prunes the outbox and checks that only the messages with the provided IDs remain.
+func (dt *dispatcherTest) assertPrune(t *testing.T, remaining ...uuid.UUID) {
+	t.Helper()
+
+	err := dt.dispatcher.Prune(context.Background())
+	if err != nil {
+		t.Fatalf("failed to prune: %v", err)
+	}
+
+	msgs, err := dt.store.FindMessages(context.Background(), outbox.MessageFilter{})
+	if err != nil {
+		t.Fatalf("failed to find messages: %v", err)
+	}
+
+	got := make([]string, 0, len(msgs))
+	for _, m := range msgs {
+		got = append(got, m.ID.String())
+	}
+
+	want := make([]string, 0, len(remaining))
+	for _, id := range remaining {
+		want = append(want, id.String())
+	}
+
+	slices.Sort(got)
+	slices.Sort(want)
+	if !slices.Equal(got, want) {
+		t.Fatalf("expected remaining messages %v, got %v", want, got)
+	}
+}
+
+func (dt *dispatcherTest) assertScheduled(t *testing.T, id uuid.UUID, attempts int, next time.Time) {
+	t.Helper()
+
+	got := dt.find(t, id)
+	if got.Status != outbox.StatusPending || got.Attempts != attempts || !got.NextAttemptAt.Equal(next) || got.LastError == "" {
+		t.Fatalf("expected pending message with %d attempts, next attempt at %s and an error, got: %+v", attempts, next, got)
+	}
+}
+
+// failingSender fails the first fails attempts with err, or with a
+// retryable error if err is nil.
+type failingSender struct {
+	fails  int
+	err    error
+	sender *email.MemorySender
+}
+
+func (s *failingSender) Send(ctx context.Context, msg email.Message) error {
+	if s.fails > 0 {
+		s.fails--
+		if s.err != nil {
+			return s.err
+		}
+		return &email.RetryableError{Err: testerr.Err}
+	}
+
+	return s.sender.Send(ctx, msg)
+}
+
+// suppressions suppresses the recipients that are set to true.
+type suppressions map[email.Address]bool
+
+func (s suppressions) EmailSuppressed(_ context.Context, addr email.Address) (bool, error) {
+	return s[addr], nil
+}
+
+type failingSuppressions struct{}
+
+func (failingSuppressions) EmailSuppressed(context.Context, email.Address) (bool, error) {
+	return false, testerr.Err
+}
+
+type errList struct {
+	errs []error
+}
+
+func (e *errList) append(err error) {
+	e.errs = append(e.errs, err)
+}
+
+func (e *errList) assertNone(t *testing.T) {
+	t.Helper()
+
+	if len(e.errs) > 0 {
+		t.Fatalf("unexpected errors: %v", e.errs)
+	}
+}
+
+func must[T any](v T, err error) T {
+	if err != nil {
+		panic(err)
+	}
+	return v
+}
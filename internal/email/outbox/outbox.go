@@ -0,0 +1,55 @@
+package outbox
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/willemschots/househunt/internal/email"
+)
+
+// Status is the delivery status of a message in the outbox.
+type Status string
+
+const (
+	// StatusPending messages are waiting to be (re)sent.
+	StatusPending Status = "pending"
+	// StatusSent messages were handed off to the email sender.
+	StatusSent Status = "sent"
+	// StatusDead messages failed too many times and will not be retried.
+	StatusDead Status = "dead"
+	// StatusSuppressed messages were not sent, because the recipient no longer
+	// receives emails.
+	StatusSuppressed Status = "suppressed"
+)
+
+// Message is a rendered email that is queued for sending.
+type Message struct {
+	ID uuid.UUID
+	email.Message
+	Status        Status
+	Attempts      int
+	NextAttemptAt time.Time
+	LastError     string
+	CreatedAt     time.Time
+	UpdatedAt     time.Time
+}
+
+// NewMessage creates a pending message for the rendered email that can
+// be sent immediately.
+func NewMessage(m email.Message, now time.Time) (Message, error) {
+	id, err := uuid.NewRandom()
+	if err != nil {
+		return Message{}, err
+	}
+
+	return Message{
+		ID:            id,
+		Message:       m,
+		Status:        StatusPending,
+		Attempts:      0,
+		NextAttemptAt: now,
+		LastError:     "",
+		CreatedAt:     now,
+		UpdatedAt:     now,
+	}, nil
+}
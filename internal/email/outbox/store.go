@@ -0,0 +1,43 @@
+package outbox
+
+import (
+	"context"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// MessageFilter is used to filter messages.
+// Returned messages must match all the provided fields.
+// If a field is empty or nil, it's ignored.
+type MessageFilter struct {
+	IDs      []uuid.UUID
+	Statuses []Status
+	// DueAt only matches messages that should be attempted at or before this time.
+	DueAt *time.Time
+	// UpdatedBefore only matches messages that were last updated before this time.
+	UpdatedBefore *time.Time
+	// Limit is the maximum number of messages returned, 0 means no limit.
+	// It's ignored when deleting messages.
+	Limit int
+}
+
+// Store provides access to the outbox.
+type Store interface {
+	BeginTx(ctx context.Context) (Tx, error)
+
+	FindMessages(ctx context.Context, filter MessageFilter) ([]Message, error)
+}
+
+// Tx is a transaction. If an error occurs on any of the Create/Update/Find methods,
+// the transaction is considered to have failed and should be rolled back.
+// Tx is not safe for concurrent use.
+type Tx interface {
+	Commit() error
+	Rollback() error
+
+	CreateMessage(m Message) error
+	UpdateMessage(m Message) error
+	DeleteMessages(filter MessageFilter) error
+	FindMessages(filter MessageFilter) ([]Message, error)
+}
@@ -7,6 +7,8 @@ import (
 	"fmt"
 	"net/http"
 	"net/url"
+	"strconv"
+	"time"
 
 	"github.com/willemschots/househunt/internal/email"
 	"github.com/willemschots/househunt/internal/krypto"
@@ -38,6 +40,7 @@ type emailJSON struct {
 	To            string
 	Subject       string
 	TextBody      string
+	HTMLBody      string `json:"HtmlBody,omitempty"`
 	MessageStream string
 }
 
@@ -48,12 +51,13 @@ type response struct {
 }
 
 // Send sends an email using the Postmark API.
-func (s *Sender) Send(ctx context.Context, from, recipient email.Address, subject, body string) error {
+func (s *Sender) Send(ctx context.Context, msg email.Message) error {
 	data := emailJSON{
-		From:          string(from),
-		To:            string(recipient),
-		Subject:       subject,
-		TextBody:      body,
+		From:          string(msg.From),
+		To:            string(msg.Recipient),
+		Subject:       msg.Subject,
+		TextBody:      msg.Body,
+		HTMLBody:      msg.HTMLBody,
 		MessageStream: s.settings.MessageStream,
 	}
 
@@ -74,24 +78,66 @@ func (s *Sender) Send(ctx context.Context, from, recipient email.Address, subjec
 
 	resp, err := s.client.Do(req)
 	if err != nil {
-		return fmt.Errorf("failed to send request: %w", err)
+		err = fmt.Errorf("failed to send request: %w", err)
+		if ctx.Err() != nil {
+			return err
+		}
+
+		// The API could not be reached, which is usually temporary.
+		return &email.RetryableError{Err: err}
 	}
 
 	defer resp.Body.Close()
 
-	//if resp.StatusCode != http.StatusOK {
-	//	return fmt.Errorf("request did not succeed, status code %d", resp.StatusCode)
-	//}
-
+	// Postmark describes most errors in the response body, but not all
+	// error responses have one.
 	var res response
 	err = json.NewDecoder(resp.Body).Decode(&res)
-	if err != nil {
+	if err != nil && resp.StatusCode == http.StatusOK {
 		return fmt.Errorf("failed to decode response: %w", err)
 	}
 
+	if resp.StatusCode != http.StatusOK {
+		err = fmt.Errorf("request did not succeed, status code %d: error code %d %v", resp.StatusCode, res.ErrorCode, res.Message)
+		if retryableStatus(resp.StatusCode) {
+			return &email.RetryableError{
+				Err:        err,
+				RetryAfter: parseRetryAfter(resp.Header.Get("Retry-After"), time.Now()),
+			}
+		}
+
+		// Other status codes indicate a problem with the request itself, such as an
+		// invalid recipient, sending it again won't help.
+		return err
+	}
+
 	if res.ErrorCode != 0 {
 		return fmt.Errorf("error code in response: %d %v", res.ErrorCode, res.Message)
 	}
 
 	return nil
 }
+
+// retryableStatus reports whether a request that failed with the status code
+// may succeed later.
+func retryableStatus(code int) bool {
+	return code == http.StatusTooManyRequests || code >= http.StatusInternalServerError
+}
+
+// parseRetryAfter parses the value of a Retry-After header, which is either a number
+// of seconds or a date. It returns zero if the value is missing or invalid.
+func parseRetryAfter(v string, now time.Time) time.Duration {
+	if v == "" {
+		return 0
+	}
+
+	if seconds, err := strconv.Atoi(v); err == nil {
+		return max(time.Duration(seconds)*time.Second, 0)
+	}
+
+	if t, err := http.ParseTime(v); err == nil {
+		return max(t.Sub(now), 0)
+	}
+
+	return 0
+}
@@ -0,0 +1,145 @@
+package postmark_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/willemschots/househunt/internal/email"
+	"github.com/willemschots/househunt/internal/email/postmark"
+	"github.com/willemschots/househunt/internal/krypto"
+)
+
+func Test_Sender_Send(t *testing.T) {
+	tests := map[string]struct {
+		status         int
+		header         http.Header
+		body           string
+		wantErr        bool
+		wantRetryable  bool
+		wantRetryAfter time.Duration
+	}{
+		"ok": {
+			status: http.StatusOK,
+			body:   `{"ErrorCode":0,"Message":"OK","MessageID":"b7bc2f4a-e38e-4336-af7d-e6c392c2f817"}`,
+		},
+		"fail, invalid request": {
+			status:  http.StatusUnprocessableEntity,
+			body:    `{"ErrorCode":300,"Message":"Invalid email request"}`,
+			wantErr: true,
+		},
+		"fail, error code in ok response": {
+			status:  http.StatusOK,
+			body:    `{"ErrorCode":406,"Message":"Inactive recipient"}`,
+			wantErr: true,
+		},
+		"fail, invalid ok response": {
+			status:  http.StatusOK,
+			body:    `not json`,
+			wantErr: true,
+		},
+		"fail, rate limited": {
+			status:         http.StatusTooManyRequests,
+			header:         http.Header{"Retry-After": []string{"7"}},
+			wantErr:        true,
+			wantRetryable:  true,
+			wantRetryAfter: 7 * time.Second,
+		},
+		"fail, server error without body": {
+			status:        http.StatusServiceUnavailable,
+			wantErr:       true,
+			wantRetryable: true,
+		},
+		"fail, server error with invalid retry after": {
+			status:        http.StatusInternalServerError,
+			header:        http.Header{"Retry-After": []string{"soon"}},
+			body:          `{"ErrorCode":0,"Message":"Internal server error"}`,
+			wantErr:       true,
+			wantRetryable: true,
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.Header.Get("X-Postmark-Server-Token") != "testToken" {
+					t.Errorf("missing server token")
+				}
+
+				for k, v := range tc.header {
+					w.Header()[k] = v
+				}
+				w.WriteHeader(tc.status)
+				_, _ = w.Write([]byte(tc.body))
+			}))
+			t.Cleanup(srv.Close)
+
+			err := newSender(t, srv.URL).Send(context.Background(), testMessage())
+			assertSendErr(t, err, tc.wantErr, tc.wantRetryable, tc.wantRetryAfter)
+		})
+	}
+
+	t.Run("fail, server unreachable", func(t *testing.T) {
+		srv := httptest.NewServer(http.NotFoundHandler())
+		srv.Close()
+
+		err := newSender(t, srv.URL).Send(context.Background(), testMessage())
+		assertSendErr(t, err, true, true, 0)
+	})
+
+	t.Run("fail, context cancelled", func(t *testing.T) {
+		srv := httptest.NewServer(http.NotFoundHandler())
+		t.Cleanup(srv.Close)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		err := newSender(t, srv.URL).Send(ctx, testMessage())
+		assertSendErr(t, err, true, false, 0)
+	})
+}
+
+func newSender(t *testing.T, apiURL string) *postmark.Sender {
+	t.Helper()
+
+	u, err := url.Parse(apiURL)
+	if err != nil {
+		t.Fatalf("failed to parse url: %v", err)
+	}
+
+	return postmark.NewSender(http.DefaultClient, postmark.Settings{
+		APIURL:        u,
+		ServerToken:   krypto.NewSecret("testToken"),
+		MessageStream: "outbound",
+	})
+}
+
+func testMessage() email.Message {
+	return email.Message{
+		From:      "alice@example.com",
+		Recipient: "bob@example.com",
+		Subject:   "Hello",
+		Body:      "Hello world",
+	}
+}
+
+func assertSendErr(t *testing.T, err error, wantErr, wantRetryable bool, wantRetryAfter time.Duration) {
+	t.Helper()
+
+	if (err != nil) != wantErr {
+		t.Fatalf("got error %v, want error: %v", err, wantErr)
+	}
+
+	var retryable *email.RetryableError
+	if errors.As(err, &retryable) != wantRetryable {
+		t.Fatalf("got error %v, want retryable: %v", err, wantRetryable)
+	}
+
+	if wantRetryable && retryable.RetryAfter != wantRetryAfter {
+		t.Errorf("got retry after %v, want %v", retryable.RetryAfter, wantRetryAfter)
+	}
+}
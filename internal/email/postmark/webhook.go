@@ -0,0 +1,61 @@
+package postmark
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"time"
+
+	"github.com/willemschots/househunt/internal/krypto"
+)
+
+// Record types of the webhook events that are handled.
+const (
+	RecordTypeBounce        = "Bounce"
+	RecordTypeSpamComplaint = "SpamComplaint"
+)
+
+// Bounce types that indicate an address permanently rejects emails. Other bounce
+// types, such as soft bounces and auto responders, are temporary.
+const (
+	BounceTypeHard            = "HardBounce"
+	BounceTypeBadEmailAddress = "BadEmailAddress"
+)
+
+// Event is a bounce or spam complaint webhook event. Only the fields that are used
+// are included, see https://postmarkapp.com/developer/webhooks/bounce-webhook.
+type Event struct {
+	RecordType string
+	Type       string
+	Email      string
+	BouncedAt  time.Time
+}
+
+// WebhookSettings contains the settings for the Postmark webhooks. Postmark is
+// configured to call the webhooks with these basic auth credentials.
+type WebhookSettings struct {
+	Username string
+	Password krypto.Secret
+}
+
+// Enabled reports whether the webhooks should be accepted, which requires a password.
+func (s WebhookSettings) Enabled() bool {
+	return len(s.Password.SecretValue()) > 0
+}
+
+// Authorized reports whether the request provides the basic auth credentials.
+// The credentials are compared in constant time.
+func (s WebhookSettings) Authorized(r *http.Request) bool {
+	if !s.Enabled() {
+		return false
+	}
+
+	username, password, ok := r.BasicAuth()
+	if !ok {
+		return false
+	}
+
+	usernameOK := subtle.ConstantTimeCompare([]byte(username), []byte(s.Username)) == 1
+	passwordOK := subtle.ConstantTimeCompare([]byte(password), s.Password.SecretValue()) == 1
+
+	return usernameOK && passwordOK
+}
@@ -0,0 +1,276 @@
+package retry
+
+import (
+	"context"
+	"errors"
+	"math/rand/v2"
+	"sync"
+	"time"
+
+	"github.com/willemschots/househunt/internal/email"
+)
+
+// ErrCircuitOpen is returned when the circuit is open and a message is not sent.
+var ErrCircuitOpen = errors.New("email sender circuit is open")
+
+// Config is the configuration for the Sender.
+type Config struct {
+	// MaxAttempts is the maximum number of attempts per message, including the first.
+	MaxAttempts int
+	// MinBackoff is the delay after the first failed attempt, it doubles for every
+	// failed attempt after that. Up to half of the delay is randomized, so that
+	// concurrent sends don't retry at the same time.
+	MinBackoff time.Duration
+	// MaxBackoff is the maximum delay between two attempts. If the server asks to
+	// wait longer, the message is not retried.
+	MaxBackoff time.Duration
+	// FailureThreshold is the number of consecutive messages that failed with a
+	// retryable error, after which the circuit opens.
+	FailureThreshold int
+	// Cooldown is how long the circuit stays open. After that a single message is
+	// let through to check whether the sender recovered.
+	Cooldown time.Duration
+}
+
+// State is the state of the circuit.
+type State string
+
+const (
+	// StateClosed is the normal state, all messages are sent.
+	StateClosed State = "closed"
+	// StateOpen indicates the sender is failing, messages are rejected without sending them.
+	StateOpen State = "open"
+	// StateHalfOpen indicates the cooldown passed, a single message is sent to check
+	// whether the sender recovered.
+	StateHalfOpen State = "half-open"
+)
+
+// Metrics describes what the Sender did since it was created.
+type Metrics struct {
+	State State
+	// Sent is the number of messages that were sent.
+	Sent int
+	// Failed is the number of messages that could not be sent after all attempts.
+	Failed int
+	// Retries is the number of attempts after the first attempt of a message.
+	Retries int
+	// Rejected is the number of messages that were not sent because the circuit was open.
+	Rejected int
+	// Opened is the number of times the circuit opened.
+	Opened int
+}
+
+// Sender is an email.Sender that retries messages on retryable errors of the
+// sender it wraps, see email.RetryableError. Other errors are returned as is.
+//
+// If too many messages in a row fail with a retryable error, the circuit opens
+// and messages are rejected with ErrCircuitOpen until the cooldown has passed.
+// This gives an overloaded server room to recover. Rejected messages are reported
+// as retryable with the remaining cooldown as RetryAfter, the outbox dispatcher
+// reschedules them without counting the rejection as an attempt.
+//
+// Sender is safe for concurrent use.
+type Sender struct {
+	next email.Sender
+	cfg  Config
+
+	mu       sync.Mutex
+	failures int
+	openedAt time.Time
+	// probing is true while the single message of the half-open state is being sent.
+	probing bool
+	metrics Metrics
+
+	// NowFunc is used to get the current time.
+	// Exposed for testing purposes.
+	NowFunc func() time.Time
+	// SleepFunc waits for d, or until ctx is done.
+	// Exposed for testing purposes.
+	SleepFunc func(ctx context.Context, d time.Duration) error
+	// JitterFunc returns a random duration in [0, d).
+	// Exposed for testing purposes.
+	JitterFunc func(d time.Duration) time.Duration
+
+	// OnStateChange is called when the state of the circuit changes, it's optional.
+	// It's called while holding a lock, so it should not call the Sender.
+	OnStateChange func(from, to State)
+}
+
+// NewSender creates a new Sender that wraps next.
+func NewSender(next email.Sender, cfg Config) *Sender {
+	return &Sender{
+		next: next,
+		cfg:  cfg,
+		metrics: Metrics{
+			State: StateClosed,
+		},
+		NowFunc:    time.Now,
+		SleepFunc:  sleep,
+		JitterFunc: jitter,
+	}
+}
+
+// Send sends the message using the wrapped sender, retrying it on retryable errors.
+func (s *Sender) Send(ctx context.Context, msg email.Message) error {
+	wait, ok := s.allow()
+	if !ok {
+		return &email.RetryableError{Err: ErrCircuitOpen, RetryAfter: wait}
+	}
+
+	err := s.send(ctx, msg)
+	s.record(ctx, err)
+
+	return err
+}
+
+// Metrics returns a snapshot of the metrics.
+func (s *Sender) Metrics() Metrics {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.metrics
+}
+
+// send attempts to send the message until it succeeds, fails with an error that is
+// not retryable or runs out of attempts.
+func (s *Sender) send(ctx context.Context, msg email.Message) error {
+	for attempt := 1; ; attempt++ {
+		err := s.next.Send(ctx, msg)
+
+		var retryable *email.RetryableError
+		if err == nil || !errors.As(err, &retryable) || attempt >= s.cfg.MaxAttempts || ctx.Err() != nil {
+			return err
+		}
+
+		delay := retryable.RetryAfter
+		if delay == 0 {
+			delay = s.backoff(attempt)
+		}
+
+		if delay > s.cfg.MaxBackoff {
+			// Don't block for longer than configured, the message can be sent later.
+			return err
+		}
+
+		sleepErr := s.SleepFunc(ctx, delay)
+		if sleepErr != nil {
+			return errors.Join(err, sleepErr)
+		}
+
+		s.mu.Lock()
+		s.metrics.Retries++
+		s.mu.Unlock()
+	}
+}
+
+// backoff returns the delay before the next attempt, after the provided
+// number of failed attempts.
+func (s *Sender) backoff(attempts int) time.Duration {
+	delay := s.cfg.MinBackoff
+	for i := 1; i < attempts && delay < s.cfg.MaxBackoff; i++ {
+		delay *= 2
+	}
+	delay = min(delay, s.cfg.MaxBackoff)
+
+	half := delay / 2
+	return delay - half + s.JitterFunc(half)
+}
+
+// allow reports whether a message may be sent. If not, it also returns how long
+// the circuit will stay open.
+func (s *Sender) allow() (time.Duration, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	switch s.metrics.State {
+	case StateOpen:
+		wait := s.cfg.Cooldown - s.NowFunc().Sub(s.openedAt)
+		if wait > 0 {
+			s.metrics.Rejected++
+			return wait, false
+		}
+
+		s.setState(StateHalfOpen)
+		s.probing = true
+		return 0, true
+	case StateHalfOpen:
+		if s.probing {
+			s.metrics.Rejected++
+			return s.cfg.Cooldown, false
+		}
+
+		s.probing = true
+		return 0, true
+	default:
+		return 0, true
+	}
+}
+
+// record updates the metrics and the state of the circuit with the outcome of a send.
+func (s *Sender) record(ctx context.Context, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.probing = false
+
+	if err == nil {
+		s.metrics.Sent++
+	} else {
+		s.metrics.Failed++
+	}
+
+	var retryable *email.RetryableError
+	switch {
+	case err != nil && ctx.Err() != nil:
+		// Interrupted sends say nothing about the health of the sender.
+	case errors.As(err, &retryable):
+		s.failures++
+		if s.metrics.State == StateHalfOpen || s.failures >= s.cfg.FailureThreshold {
+			s.open()
+		}
+	default:
+		// The sender responded, even if the message itself was rejected.
+		s.failures = 0
+		s.setState(StateClosed)
+	}
+}
+
+func (s *Sender) open() {
+	s.openedAt = s.NowFunc()
+	if s.metrics.State != StateOpen {
+		s.metrics.Opened++
+	}
+	s.setState(StateOpen)
+}
+
+func (s *Sender) setState(state State) {
+	from := s.metrics.State
+	if from == state {
+		return
+	}
+
+	s.metrics.State = state
+	if s.OnStateChange != nil {
+		s.OnStateChange(from, state)
+	}
+}
+
+func sleep(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-t.C:
+		return nil
+	}
+}
+
+func jitter(d time.Duration) time.Duration {
+	if d <= 0 {
+		return 0
+	}
+
+	return rand.N(d)
+}
@@ -0,0 +1,272 @@
+package retry_test
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/willemschots/househunt/internal/email"
+	"github.com/willemschots/househunt/internal/email/retry"
+)
+
+var (
+	errPermanent = errors.New("permanent error")
+	errTemporary = &email.RetryableError{Err: errors.New("temporary error")}
+)
+
+func Test_Sender_Send(t *testing.T) {
+	tests := map[string]struct {
+		errs        []error
+		wantErr     error
+		wantCalls   int
+		wantSleeps  []time.Duration
+		wantMetrics retry.Metrics
+	}{
+		"ok, first attempt": {
+			errs:        []error{nil},
+			wantCalls:   1,
+			wantMetrics: retry.Metrics{State: retry.StateClosed, Sent: 1},
+		},
+		"ok, after retryable errors": {
+			errs:      []error{errTemporary, errTemporary, nil},
+			wantCalls: 3,
+			// Half of the backoff is jitter, which is zero in the tests.
+			wantSleeps:  []time.Duration{500 * time.Millisecond, time.Second},
+			wantMetrics: retry.Metrics{State: retry.StateClosed, Sent: 1, Retries: 2},
+		},
+		"ok, honours retry after": {
+			errs:        []error{&email.RetryableError{Err: errTemporary, RetryAfter: 1500 * time.Millisecond}, nil},
+			wantCalls:   2,
+			wantSleeps:  []time.Duration{1500 * time.Millisecond},
+			wantMetrics: retry.Metrics{State: retry.StateClosed, Sent: 1, Retries: 1},
+		},
+		"fail, retry after longer than max backoff": {
+			errs:        []error{&email.RetryableError{Err: errTemporary, RetryAfter: time.Minute}},
+			wantErr:     errTemporary,
+			wantCalls:   1,
+			wantMetrics: retry.Metrics{State: retry.StateClosed, Failed: 1},
+		},
+		"fail, error is not retryable": {
+			errs:        []error{errPermanent},
+			wantErr:     errPermanent,
+			wantCalls:   1,
+			wantMetrics: retry.Metrics{State: retry.StateClosed, Failed: 1},
+		},
+		"fail, out of attempts": {
+			errs:        []error{errTemporary, errTemporary, errTemporary},
+			wantErr:     errTemporary,
+			wantCalls:   3,
+			wantSleeps:  []time.Duration{500 * time.Millisecond, time.Second},
+			wantMetrics: retry.Metrics{State: retry.StateClosed, Failed: 1, Retries: 2},
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			next := &testSender{errs: tc.errs}
+			st := newSenderTest(next)
+
+			err := st.sender.Send(context.Background(), email.Message{})
+			if !errors.Is(err, tc.wantErr) {
+				t.Fatalf("expected error %v, got %v (via errors.Is)", tc.wantErr, err)
+			}
+
+			if next.calls != tc.wantCalls {
+				t.Errorf("got %d calls, want %d", next.calls, tc.wantCalls)
+			}
+
+			if !reflect.DeepEqual(st.sleeps, tc.wantSleeps) {
+				t.Errorf("got sleeps %v, want %v", st.sleeps, tc.wantSleeps)
+			}
+
+			if got := st.sender.Metrics(); got != tc.wantMetrics {
+				t.Errorf("got metrics %+v, want %+v", got, tc.wantMetrics)
+			}
+		})
+	}
+
+	t.Run("fail, context is cancelled while waiting", func(t *testing.T) {
+		next := &testSender{errs: []error{errTemporary, nil}}
+		st := newSenderTest(next)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		st.sender.SleepFunc = func(ctx context.Context, d time.Duration) error {
+			cancel()
+			return ctx.Err()
+		}
+
+		err := st.sender.Send(ctx, email.Message{})
+		if !errors.Is(err, context.Canceled) {
+			t.Fatalf("expected error %v, got %v (via errors.Is)", context.Canceled, err)
+		}
+
+		if next.calls != 1 {
+			t.Errorf("got %d calls, want 1", next.calls)
+		}
+
+		// Interrupted sends don't count towards opening the circuit.
+		st.assertSend(t, nil)
+		if got := st.sender.Metrics(); got.State != retry.StateClosed || got.Sent != 1 {
+			t.Errorf("got metrics %+v, want closed circuit with 1 sent message", got)
+		}
+	})
+}
+
+func Test_Sender_Circuit(t *testing.T) {
+	t.Run("ok, opens after consecutive failures and recovers", func(t *testing.T) {
+		// Every message fails after all 3 attempts, the circuit opens after 2 messages.
+		next := &testSender{errs: repeat(errTemporary, 6)}
+		st := newSenderTest(next)
+
+		st.assertSend(t, errTemporary)
+		st.assertSend(t, errTemporary)
+
+		// The circuit is open, messages are rejected without sending them.
+		err := st.sender.Send(context.Background(), email.Message{})
+		assertCircuitOpen(t, err, 30*time.Second)
+
+		st.now = st.now.Add(10 * time.Second)
+		err = st.sender.Send(context.Background(), email.Message{})
+		assertCircuitOpen(t, err, 20*time.Second)
+
+		if next.calls != 6 {
+			t.Errorf("got %d calls, want 6", next.calls)
+		}
+
+		// After the cooldown a single message is sent, which fails and opens the circuit again.
+		next.errs = repeat(errTemporary, 3)
+		st.now = st.now.Add(20 * time.Second)
+		st.assertSend(t, errTemporary)
+
+		err = st.sender.Send(context.Background(), email.Message{})
+		assertCircuitOpen(t, err, 30*time.Second)
+
+		// The next message succeeds and closes the circuit.
+		next.errs = []error{nil, nil}
+		st.now = st.now.Add(30 * time.Second)
+		st.assertSend(t, nil)
+		st.assertSend(t, nil)
+
+		want := retry.Metrics{State: retry.StateClosed, Sent: 2, Failed: 3, Retries: 6, Rejected: 3, Opened: 2}
+		if got := st.sender.Metrics(); got != want {
+			t.Errorf("got metrics %+v, want %+v", got, want)
+		}
+
+		wantChanges := []string{
+			"closed -> open",
+			"open -> half-open",
+			"half-open -> open",
+			"open -> half-open",
+			"half-open -> closed",
+		}
+		if !reflect.DeepEqual(st.changes, wantChanges) {
+			t.Errorf("got state changes %v, want %v", st.changes, wantChanges)
+		}
+	})
+
+	t.Run("ok, errors that are not retryable don't open the circuit", func(t *testing.T) {
+		next := &testSender{errs: []error{
+			errTemporary, errTemporary, errTemporary,
+			errPermanent,
+			errTemporary, errTemporary, errTemporary,
+		}}
+		st := newSenderTest(next)
+
+		st.assertSend(t, errTemporary)
+		st.assertSend(t, errPermanent)
+		st.assertSend(t, errTemporary)
+
+		if got := st.sender.Metrics(); got.State != retry.StateClosed {
+			t.Errorf("got state %s, want %s", got.State, retry.StateClosed)
+		}
+	})
+}
+
+type senderTest struct {
+	sender  *retry.Sender
+	now     time.Time
+	sleeps  []time.Duration
+	changes []string
+}
+
+func newSenderTest(next email.Sender) *senderTest {
+	st := &senderTest{
+		now: time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC),
+	}
+
+	st.sender = retry.NewSender(next, retry.Config{
+		MaxAttempts:      3,
+		MinBackoff:       time.Second,
+		MaxBackoff:       2 * time.Second,
+		FailureThreshold: 2,
+		Cooldown:         30 * time.Second,
+	})
+	st.sender.NowFunc = func() time.Time {
+		return st.now
+	}
+	st.sender.SleepFunc = func(_ context.Context, d time.Duration) error {
+		st.sleeps = append(st.sleeps, d)
+		return nil
+	}
+	st.sender.JitterFunc = func(time.Duration) time.Duration {
+		return 0
+	}
+	st.sender.OnStateChange = func(from, to retry.State) {
+		st.changes = append(st.changes, string(from)+" -> "+string(to))
+	}
+
+	return st
+}
+
+func (st *senderTest) assertSend(t *testing.T, wantErr error) {
+	t.Helper()
+
+	err := st.sender.Send(context.Background(), email.Message{})
+	if !errors.Is(err, wantErr) {
+		t.Fatalf("expected error %v, got %v (via errors.Is)", wantErr, err)
+	}
+}
+
+func assertCircuitOpen(t *testing.T, err error, wantRetryAfter time.Duration) {
+	t.Helper()
+
+	if !errors.Is(err, retry.ErrCircuitOpen) {
+		t.Fatalf("expected error %v, got %v (via errors.Is)", retry.ErrCircuitOpen, err)
+	}
+
+	var retryable *email.RetryableError
+	if !errors.As(err, &retryable) {
+		t.Fatalf("expected error to be of type %T, got %T (via errors.As)", retryable, err)
+	}
+
+	if retryable.RetryAfter != wantRetryAfter {
+		t.Errorf("got retry after %v, want %v", retryable.RetryAfter, wantRetryAfter)
+	}
+}
+
+// testSender returns the errors in order, one per call.
+type testSender struct {
+	errs  []error
+	calls int
+}
+
+func (s *testSender) Send(ctx context.Context, msg email.Message) error {
+	s.calls++
+	if len(s.errs) == 0 {
+		return errors.New("unexpected call")
+	}
+
+	err := s.errs[0]
+	s.errs = s.errs[1:]
+	return err
+}
+
+func repeat(err error, n int) []error {
+	errs := make([]error, n)
+	for i := range errs {
+		errs[i] = err
+	}
+	return errs
+}
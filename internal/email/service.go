@@ -3,16 +3,24 @@ package email
 import (
 	"bytes"
 	"context"
+	"errors"
 	"io"
 	"net/url"
+	"time"
 )
 
+// ErrElementNotDefined is returned by a renderer if a template does not define an
+// optional element, such as the HTML body.
+var ErrElementNotDefined = errors.New("template element not defined")
+
 // TemplateElement is used by a renderer to identify the different parts of an email template.
 type TemplateElement string
 
 const (
 	ElementSubject TemplateElement = "subject"
 	ElementBody    TemplateElement = "body"
+	// ElementHTML is the optional HTML version of the body.
+	ElementHTML TemplateElement = "html"
 )
 
 // Renderer is responsible for rendering email templates.
@@ -22,7 +30,25 @@ type Renderer interface {
 
 // Sender is responsible for actually sending an email.
 type Sender interface {
-	Send(ctx context.Context, from, recipient Address, subject, body string) error
+	Send(ctx context.Context, msg Message) error
+}
+
+// RetryableError is returned by a Sender if sending failed for a reason that is likely
+// temporary, such as an overloaded or unreachable server. Sending the same message again
+// later may succeed, other errors will keep failing.
+type RetryableError struct {
+	Err error
+	// RetryAfter is how long the server asked to wait before trying again,
+	// it's zero if the server didn't say.
+	RetryAfter time.Duration
+}
+
+func (e *RetryableError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *RetryableError) Unwrap() error {
+	return e.Err
 }
 
 // ServiceConfig is the configuration for the email service.
@@ -46,10 +72,24 @@ func NewService(renderer Renderer, sender Sender, cfg ServiceConfig) *Service {
 	}
 }
 
-func (s *Service) Send(ctx context.Context, name string, recipient Address, data any) error {
+// Message is a rendered email that is ready to be sent.
+type Message struct {
+	From      Address
+	Recipient Address
+	Subject   string
+	// Body is the plain text body.
+	Body string
+	// HTMLBody is the HTML body, it's empty if the template has no HTML version.
+	HTMLBody string
+}
+
+// Render renders the email template with the provided name for the recipient,
+// without sending it.
+func (s *Service) Render(name string, recipient Address, data any) (Message, error) {
 	var (
 		sBuf bytes.Buffer
 		bBuf bytes.Buffer
+		hBuf bytes.Buffer
 	)
 
 	viewData := struct {
@@ -62,13 +102,34 @@ func (s *Service) Send(ctx context.Context, name string, recipient Address, data
 
 	err := s.renderer.Render(&sBuf, name, ElementSubject, viewData)
 	if err != nil {
-		return err
+		return Message{}, err
 	}
 
 	err = s.renderer.Render(&bBuf, name, ElementBody, viewData)
+	if err != nil {
+		return Message{}, err
+	}
+
+	err = s.renderer.Render(&hBuf, name, ElementHTML, viewData)
+	if err != nil && !errors.Is(err, ErrElementNotDefined) {
+		return Message{}, err
+	}
+
+	return Message{
+		From:      s.cfg.From,
+		Recipient: recipient,
+		Subject:   sBuf.String(),
+		Body:      bBuf.String(),
+		HTMLBody:  hBuf.String(),
+	}, nil
+}
+
+// Send renders the email template with the provided name and sends it to the recipient.
+func (s *Service) Send(ctx context.Context, name string, recipient Address, data any) error {
+	msg, err := s.Render(name, recipient, data)
 	if err != nil {
 		return err
 	}
 
-	return s.sender.Send(ctx, s.cfg.From, recipient, sBuf.String(), bBuf.String())
+	return s.sender.Send(ctx, msg)
 }
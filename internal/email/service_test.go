@@ -47,6 +47,7 @@ func Test_SendEmail(t *testing.T) {
 			`recipient=jacob@example.com`,
 			`subject="Hello Jacob!"`,
 			`body="Your message is Today is a beautiful day"`,
+			`html_body="<html><body><p>Your message is Today is a beautiful day</p></body></html>"`,
 		} {
 			if !strings.Contains(got, want) {
 				t.Fatalf("want %q to contain %q", got, want)
@@ -55,6 +56,95 @@ func Test_SendEmail(t *testing.T) {
 	})
 }
 
+func Test_RenderEmail(t *testing.T) {
+	t.Run("ok", func(t *testing.T) {
+		renderer := view.NewFSRenderer(os.DirFS("testdata"))
+		sender := email.NewMemorySender()
+
+		cfg := email.ServiceConfig{
+			From:    must(email.ParseAddress("alice@example.com")),
+			BaseURL: must(url.Parse("http://example.com")),
+		}
+
+		svc := email.NewService(renderer, sender, cfg)
+
+		data := struct {
+			Name    string
+			Message string
+		}{
+			Name:    "Jacob",
+			Message: "Today is a beautiful day",
+		}
+		got, err := svc.Render("test", email.Address("jacob@example.com"), data)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		want := email.Message{
+			From:      "alice@example.com",
+			Recipient: "jacob@example.com",
+			Subject:   "Hello Jacob!",
+			Body:      "Your message is Today is a beautiful day",
+			HTMLBody:  "<html><body><p>Your message is Today is a beautiful day</p></body></html>",
+		}
+
+		if got != want {
+			t.Errorf("got\n%#v\nwant\n%#v", got, want)
+		}
+
+		// Rendering does not send the email.
+		if len(sender.Emails) != 0 {
+			t.Fatalf("expected no emails to be sent, got %d", len(sender.Emails))
+		}
+	})
+
+	t.Run("ok, HTML is escaped", func(t *testing.T) {
+		renderer := view.NewFSRenderer(os.DirFS("testdata"))
+		svc := email.NewService(renderer, email.NewMemorySender(), email.ServiceConfig{})
+
+		data := struct {
+			Name    string
+			Message string
+		}{
+			Name:    "Jacob",
+			Message: "<script>",
+		}
+		got, err := svc.Render("test", email.Address("jacob@example.com"), data)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		if got.Body != "Your message is <script>" {
+			t.Errorf("expected plain text body to be unescaped, got %q", got.Body)
+		}
+
+		if got.HTMLBody != "<html><body><p>Your message is &lt;script&gt;</p></body></html>" {
+			t.Errorf("expected HTML body to be escaped, got %q", got.HTMLBody)
+		}
+	})
+
+	t.Run("ok, without HTML body", func(t *testing.T) {
+		renderer := view.NewFSRenderer(os.DirFS("testdata"))
+		svc := email.NewService(renderer, email.NewMemorySender(), email.ServiceConfig{})
+
+		data := struct {
+			Name    string
+			Message string
+		}{
+			Name:    "Jacob",
+			Message: "Today is a beautiful day",
+		}
+		got, err := svc.Render("text-only", email.Address("jacob@example.com"), data)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		if got.Body != "Your message is Today is a beautiful day" || got.HTMLBody != "" {
+			t.Errorf("expected only a plain text body, got %#v", got)
+		}
+	})
+}
+
 func must[T any](v T, err error) T {
 	if err != nil {
 		panic(err)
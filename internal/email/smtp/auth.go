@@ -0,0 +1,48 @@
+package smtp
+
+import (
+	"errors"
+	"fmt"
+	netsmtp "net/smtp"
+	"strings"
+)
+
+// loginAuth implements the LOGIN mechanism, which net/smtp does not provide but
+// some servers still require. Like net/smtp.PlainAuth it refuses to send the
+// credentials over an unencrypted connection, unless the server is on localhost.
+type loginAuth struct {
+	host     string
+	username string
+	password string
+}
+
+func (a *loginAuth) Start(server *netsmtp.ServerInfo) (string, []byte, error) {
+	if !server.TLS && !isLocalhost(server.Name) {
+		return "", nil, errors.New("unencrypted connection")
+	}
+
+	if server.Name != a.host {
+		return "", nil, errors.New("wrong host name")
+	}
+
+	return "LOGIN", nil, nil
+}
+
+func (a *loginAuth) Next(fromServer []byte, more bool) ([]byte, error) {
+	if !more {
+		return nil, nil
+	}
+
+	switch prompt := strings.ToLower(strings.TrimSpace(string(fromServer))); prompt {
+	case "username:":
+		return []byte(a.username), nil
+	case "password:":
+		return []byte(a.password), nil
+	default:
+		return nil, fmt.Errorf("unexpected server challenge %q", prompt)
+	}
+}
+
+func isLocalhost(name string) bool {
+	return name == "localhost" || name == "127.0.0.1" || name == "::1"
+}
@@ -0,0 +1,102 @@
+package smtp
+
+import (
+	"bytes"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/textproto"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/willemschots/househunt/internal/email"
+)
+
+// FormatMessage formats an email as a MIME message. The subject is encoded as an
+// encoded-word if needed, and the bodies as quoted-printable UTF-8 text, so that
+// the message only contains 7-bit ASCII with lines shorter than the SMTP limit.
+//
+// Messages with a HTML body are sent as multipart/alternative, with the plain text
+// body first so that clients that can't show HTML fall back to it.
+func FormatMessage(now time.Time, msg email.Message) ([]byte, error) {
+	var b bytes.Buffer
+
+	headers := [][2]string{
+		{"From", string(msg.From)},
+		{"To", string(msg.Recipient)},
+		{"Subject", mime.QEncoding.Encode("utf-8", msg.Subject)},
+		{"Date", now.Format(time.RFC1123Z)},
+		{"Message-ID", messageID(msg.From)},
+		{"MIME-Version", "1.0"},
+	}
+
+	for _, h := range headers {
+		b.WriteString(h[0] + ": " + h[1] + "\r\n")
+	}
+
+	if msg.HTMLBody == "" {
+		b.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+		b.WriteString("Content-Transfer-Encoding: quoted-printable\r\n\r\n")
+
+		err := writeQuotedPrintable(&b, msg.Body)
+		if err != nil {
+			return nil, err
+		}
+
+		// Messages end with a line break.
+		b.WriteString("\r\n")
+
+		return b.Bytes(), nil
+	}
+
+	mw := multipart.NewWriter(&b)
+	b.WriteString("Content-Type: multipart/alternative; boundary=" + mw.Boundary() + "\r\n\r\n")
+
+	parts := []struct {
+		contentType string
+		body        string
+	}{
+		{contentType: "text/plain; charset=utf-8", body: msg.Body},
+		{contentType: "text/html; charset=utf-8", body: msg.HTMLBody},
+	}
+
+	for _, p := range parts {
+		w, err := mw.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {p.contentType},
+			"Content-Transfer-Encoding": {"quoted-printable"},
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		err = writeQuotedPrintable(w, p.body)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err := mw.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	return b.Bytes(), nil
+}
+
+func writeQuotedPrintable(w io.Writer, s string) error {
+	qw := quotedprintable.NewWriter(w)
+	_, err := qw.Write([]byte(s))
+	if err != nil {
+		return err
+	}
+
+	return qw.Close()
+}
+
+// messageID returns a unique message ID in the domain of the sender.
+func messageID(from email.Address) string {
+	_, domain, _ := strings.Cut(string(from), "@")
+	return "<" + uuid.NewString() + "@" + domain + ">"
+}
@@ -0,0 +1,289 @@
+package smtp
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	netsmtp "net/smtp"
+	"net/textproto"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/willemschots/househunt/internal/email"
+	"github.com/willemschots/househunt/internal/krypto"
+)
+
+// TLSMode determines how the connection to the SMTP server is encrypted.
+type TLSMode string
+
+const (
+	// TLSModeStartTLS upgrades a plain connection using the STARTTLS command,
+	// sending fails if the server doesn't support it. Usually on port 587.
+	TLSModeStartTLS TLSMode = "starttls"
+	// TLSModeImplicit connects using TLS right away. Usually on port 465.
+	TLSModeImplicit TLSMode = "tls"
+	// TLSModeNone doesn't encrypt the connection, only meant for local development
+	// servers. Credentials are only sent over unencrypted connections to localhost.
+	TLSModeNone TLSMode = "none"
+)
+
+// TLSModes returns all TLS modes.
+func TLSModes() []TLSMode {
+	return []TLSMode{TLSModeStartTLS, TLSModeImplicit, TLSModeNone}
+}
+
+// AuthMechanism is the SASL mechanism used to authenticate with the SMTP server.
+type AuthMechanism string
+
+const (
+	AuthPlain AuthMechanism = "plain"
+	AuthLogin AuthMechanism = "login"
+)
+
+// AuthMechanisms returns all authentication mechanisms.
+func AuthMechanisms() []AuthMechanism {
+	return []AuthMechanism{AuthPlain, AuthLogin}
+}
+
+// Settings contains the settings for the SMTP server.
+type Settings struct {
+	Host string
+	Port int
+	TLS  TLSMode
+	// TLSConfig is used for encrypted connections, if nil the system root CAs
+	// are used to verify the certificate for Host.
+	TLSConfig *tls.Config
+	// Username and Password are only used if Username is not empty.
+	Username string
+	Password krypto.Secret
+	Auth     AuthMechanism
+	// LocalName is the name the sender introduces itself with, defaults to "localhost".
+	LocalName string
+	// ConnectTimeout is the maximum duration of establishing the connection.
+	ConnectTimeout time.Duration
+	// Timeout is the maximum duration of sending an email, including connecting.
+	Timeout time.Duration
+}
+
+// Validate checks if the settings can be used to send emails.
+func (s Settings) Validate() error {
+	var errs []error
+	if s.Host == "" {
+		errs = append(errs, errors.New("host is required"))
+	}
+
+	if s.Port < 1 || s.Port > 65535 {
+		errs = append(errs, fmt.Errorf("port %d not in range [1, 65535]", s.Port))
+	}
+
+	if !slices.Contains(TLSModes(), s.TLS) {
+		errs = append(errs, fmt.Errorf("unknown TLS mode %q", s.TLS))
+	}
+
+	if s.Username != "" && !slices.Contains(AuthMechanisms(), s.Auth) {
+		errs = append(errs, fmt.Errorf("unknown auth mechanism %q", s.Auth))
+	}
+
+	if s.ConnectTimeout <= 0 || s.Timeout <= 0 {
+		errs = append(errs, errors.New("timeouts should be positive"))
+	}
+
+	return errors.Join(errs...)
+}
+
+// Sender is an email sender that sends emails to an SMTP server. Every email
+// is sent over a new connection.
+type Sender struct {
+	settings Settings
+	// NowFunc is used to set the date of emails.
+	NowFunc func() time.Time
+}
+
+// NewSender creates a new sender.
+func NewSender(s Settings) (*Sender, error) {
+	err := s.Validate()
+	if err != nil {
+		return nil, fmt.Errorf("invalid SMTP settings: %w", err)
+	}
+
+	return &Sender{
+		settings: s,
+		NowFunc:  time.Now,
+	}, nil
+}
+
+// Send sends an email to the SMTP server.
+func (s *Sender) Send(ctx context.Context, msg email.Message) error {
+	data, err := FormatMessage(s.NowFunc(), msg)
+	if err != nil {
+		return fmt.Errorf("failed to build message: %w", err)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, s.settings.Timeout)
+	defer cancel()
+
+	conn, err := s.dial(ctx)
+	if err != nil {
+		return retryable(fmt.Errorf("failed to connect: %w", err))
+	}
+
+	// The net/smtp client is not context aware, the deadline makes sure that
+	// a slow server can't block the sender forever and closing the connection
+	// aborts the conversation when the context is cancelled.
+	deadline, _ := ctx.Deadline()
+	err = conn.SetDeadline(deadline)
+	if err != nil {
+		conn.Close()
+		return fmt.Errorf("failed to set deadline: %w", err)
+	}
+
+	stop := context.AfterFunc(ctx, func() {
+		conn.Close()
+	})
+	defer stop()
+
+	err = s.send(conn, msg.From, msg.Recipient, data)
+	if err != nil && ctx.Err() != nil {
+		return retryable(fmt.Errorf("%w: %w", ctx.Err(), err))
+	}
+
+	return retryable(err)
+}
+
+// retryable wraps err in an email.RetryableError if it's likely temporary: the server
+// replied with a transient (4xx) status, or it could not be reached or timed out.
+// Other replies, like an unknown recipient or failed authentication, are returned as is.
+func retryable(err error) error {
+	var (
+		replyErr *textproto.Error
+		netErr   net.Error
+	)
+
+	switch {
+	case errors.As(err, &replyErr):
+		if replyErr.Code >= 400 && replyErr.Code < 500 {
+			return &email.RetryableError{Err: err}
+		}
+		return err
+	case errors.As(err, &netErr), errors.Is(err, context.DeadlineExceeded):
+		return &email.RetryableError{Err: err}
+	default:
+		return err
+	}
+}
+
+func (s *Sender) dial(ctx context.Context) (net.Conn, error) {
+	dialer := &net.Dialer{
+		Timeout: s.settings.ConnectTimeout,
+	}
+
+	addr := net.JoinHostPort(s.settings.Host, strconv.Itoa(s.settings.Port))
+	if s.settings.TLS != TLSModeImplicit {
+		return dialer.DialContext(ctx, "tcp", addr)
+	}
+
+	tlsDialer := &tls.Dialer{
+		NetDialer: dialer,
+		Config:    s.tlsConfig(),
+	}
+
+	return tlsDialer.DialContext(ctx, "tcp", addr)
+}
+
+func (s *Sender) tlsConfig() *tls.Config {
+	if s.settings.TLSConfig != nil {
+		cfg := s.settings.TLSConfig.Clone()
+		if cfg.ServerName == "" {
+			cfg.ServerName = s.settings.Host
+		}
+		return cfg
+	}
+
+	return &tls.Config{
+		ServerName: s.settings.Host,
+		MinVersion: tls.VersionTLS12,
+	}
+}
+
+func (s *Sender) send(conn net.Conn, from, recipient email.Address, msg []byte) error {
+	c, err := netsmtp.NewClient(conn, s.settings.Host)
+	if err != nil {
+		conn.Close()
+		return fmt.Errorf("failed to start SMTP session: %w", err)
+	}
+
+	defer c.Close()
+
+	if s.settings.LocalName != "" {
+		err = c.Hello(s.settings.LocalName)
+		if err != nil {
+			return fmt.Errorf("failed to greet server: %w", err)
+		}
+	}
+
+	if s.settings.TLS == TLSModeStartTLS {
+		if ok, _ := c.Extension("STARTTLS"); !ok {
+			return errors.New("server does not support STARTTLS")
+		}
+
+		err = c.StartTLS(s.tlsConfig())
+		if err != nil {
+			return fmt.Errorf("failed to start TLS: %w", err)
+		}
+	}
+
+	if s.settings.Username != "" {
+		err = c.Auth(s.auth())
+		if err != nil {
+			return fmt.Errorf("failed to authenticate: %w", err)
+		}
+	}
+
+	err = c.Mail(string(from))
+	if err != nil {
+		return fmt.Errorf("failed to set sender: %w", err)
+	}
+
+	err = c.Rcpt(string(recipient))
+	if err != nil {
+		return fmt.Errorf("failed to set recipient: %w", err)
+	}
+
+	w, err := c.Data()
+	if err != nil {
+		return fmt.Errorf("failed to start data: %w", err)
+	}
+
+	_, err = w.Write(msg)
+	if err != nil {
+		return fmt.Errorf("failed to write message: %w", err)
+	}
+
+	err = w.Close()
+	if err != nil {
+		return fmt.Errorf("failed to send message: %w", err)
+	}
+
+	err = c.Quit()
+	if err != nil {
+		return fmt.Errorf("failed to quit: %w", err)
+	}
+
+	return nil
+}
+
+func (s *Sender) auth() netsmtp.Auth {
+	password := string(s.settings.Password.SecretValue())
+	if s.settings.Auth == AuthLogin {
+		return &loginAuth{
+			host:     s.settings.Host,
+			username: s.settings.Username,
+			password: password,
+		}
+	}
+
+	return netsmtp.PlainAuth("", s.settings.Username, password, s.settings.Host)
+}
@@ -0,0 +1,604 @@
+package smtp_test
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"errors"
+	"io"
+	"math/big"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net"
+	"net/mail"
+	"net/textproto"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/willemschots/househunt/internal/email"
+	"github.com/willemschots/househunt/internal/email/smtp"
+	"github.com/willemschots/househunt/internal/krypto"
+)
+
+func Test_Sender_Send(t *testing.T) {
+	okTests := map[string]struct {
+		server   *fakeServer
+		settings func(*smtp.Settings)
+	}{
+		"ok, starttls with plain auth": {
+			server: &fakeServer{startTLS: true, username: "user", password: "pass"},
+			settings: func(s *smtp.Settings) {
+				s.Username = "user"
+				s.Password = krypto.NewSecret("pass")
+				s.Auth = smtp.AuthPlain
+			},
+		},
+		"ok, starttls with login auth": {
+			server: &fakeServer{startTLS: true, username: "user", password: "pass"},
+			settings: func(s *smtp.Settings) {
+				s.Username = "user"
+				s.Password = krypto.NewSecret("pass")
+				s.Auth = smtp.AuthLogin
+			},
+		},
+		"ok, implicit tls": {
+			server: &fakeServer{implicitTLS: true},
+			settings: func(s *smtp.Settings) {
+				s.TLS = smtp.TLSModeImplicit
+			},
+		},
+		"ok, no tls": {
+			server: &fakeServer{},
+			settings: func(s *smtp.Settings) {
+				s.TLS = smtp.TLSModeNone
+			},
+		},
+		"ok, custom local name": {
+			server: &fakeServer{startTLS: true},
+			settings: func(s *smtp.Settings) {
+				s.LocalName = "mail.example.com"
+			},
+		},
+	}
+
+	for name, tc := range okTests {
+		t.Run(name, func(t *testing.T) {
+			srv := tc.server
+			sender := newSender(t, srv, tc.settings)
+
+			err := sender.Send(context.Background(), newTestMessage())
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			got := srv.messages()
+			if len(got) != 1 {
+				t.Fatalf("expected 1 message, got %d", len(got))
+			}
+
+			if got[0].from != "from@example.com" || got[0].to != "to@example.com" {
+				t.Errorf("unexpected envelope %+v", got[0])
+			}
+
+			wantTLS := srv.startTLS || srv.implicitTLS
+			if got[0].tls != wantTLS {
+				t.Errorf("got tls %v, want %v", got[0].tls, wantTLS)
+			}
+
+			wantAuth := srv.username != ""
+			if got[0].authenticated != wantAuth {
+				t.Errorf("got authenticated %v, want %v", got[0].authenticated, wantAuth)
+			}
+		})
+	}
+
+	failTests := map[string]struct {
+		server    *fakeServer
+		settings  func(*smtp.Settings)
+		retryable bool
+	}{
+		"fail, server does not support starttls": {
+			server: &fakeServer{},
+		},
+		"fail, wrong password": {
+			server: &fakeServer{startTLS: true, username: "user", password: "pass"},
+			settings: func(s *smtp.Settings) {
+				s.Username = "user"
+				s.Password = krypto.NewSecret("wrong")
+			},
+		},
+		"fail, recipient rejected permanently": {
+			server: &fakeServer{startTLS: true, rcptReply: "550 no such user"},
+		},
+		"fail, recipient rejected temporarily": {
+			server:    &fakeServer{startTLS: true, rcptReply: "451 try again later"},
+			retryable: true,
+		},
+		"fail, server does not respond in time": {
+			server: &fakeServer{silent: true},
+			settings: func(s *smtp.Settings) {
+				s.Timeout = 100 * time.Millisecond
+			},
+			retryable: true,
+		},
+		"fail, server can't be reached": {
+			server: &fakeServer{},
+			settings: func(s *smtp.Settings) {
+				s.Port = closedPort(t)
+			},
+			retryable: true,
+		},
+	}
+
+	for name, tc := range failTests {
+		t.Run(name, func(t *testing.T) {
+			srv := tc.server
+			sender := newSender(t, srv, tc.settings)
+
+			err := sender.Send(context.Background(), newTestMessage())
+			if err == nil {
+				t.Fatalf("expected error, got <nil>")
+			}
+
+			var retryable *email.RetryableError
+			if got := errors.As(err, &retryable); got != tc.retryable {
+				t.Fatalf("expected retryable %v, got %v (error: %v)", tc.retryable, got, err)
+			}
+
+			if got := srv.messages(); len(got) != 0 {
+				t.Fatalf("expected no messages, got %d", len(got))
+			}
+		})
+	}
+
+	t.Run("fail, context cancelled", func(t *testing.T) {
+		srv := &fakeServer{silent: true}
+		sender := newSender(t, srv, nil)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		time.AfterFunc(50*time.Millisecond, cancel)
+
+		err := sender.Send(ctx, newTestMessage())
+		if !errors.Is(err, context.Canceled) {
+			t.Fatalf("expected error %v, got %v (via errors.Is)", context.Canceled, err)
+		}
+	})
+
+	t.Run("ok, MIME encodes message", func(t *testing.T) {
+		srv := &fakeServer{startTLS: true}
+		sender := newSender(t, srv, nil)
+		now := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+		sender.NowFunc = func() time.Time { return now }
+
+		subject := "Welkom bij Househunt ✓"
+		body := "Hallo,\n\n" + strings.Repeat("Een heel lange regel met ë en ü. ", 10) + "\n.\nGroeten"
+
+		msg := newTestMessage()
+		msg.Subject = subject
+		msg.Body = body
+
+		err := sender.Send(context.Background(), msg)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		data := srv.messages()[0].data
+		for _, line := range strings.Split(data, "\r\n") {
+			if len(line) > 78 {
+				t.Errorf("line longer than 78 characters: %q", line)
+			}
+			for _, r := range line {
+				if r > 127 {
+					t.Fatalf("non ASCII character in line: %q", line)
+				}
+			}
+		}
+
+		got, err := mail.ReadMessage(strings.NewReader(data))
+		if err != nil {
+			t.Fatalf("failed to read message: %v", err)
+		}
+
+		gotSubject, err := new(mime.WordDecoder).DecodeHeader(got.Header.Get("Subject"))
+		if err != nil || gotSubject != subject {
+			t.Errorf("got subject %q, want %q (error %v)", gotSubject, subject, err)
+		}
+
+		gotDate, err := got.Header.Date()
+		if err != nil || !gotDate.Equal(now) {
+			t.Errorf("got date %v, want %v (error %v)", gotDate, now, err)
+		}
+
+		if !strings.HasSuffix(got.Header.Get("Message-ID"), "@example.com>") {
+			t.Errorf("unexpected message ID %q", got.Header.Get("Message-ID"))
+		}
+
+		gotBody, err := io.ReadAll(quotedprintable.NewReader(got.Body))
+		if err != nil {
+			t.Fatalf("failed to decode body: %v", err)
+		}
+
+		// The message ends with a line break that is not part of the body.
+		trimmed := strings.TrimSuffix(strings.ReplaceAll(string(gotBody), "\r\n", "\n"), "\n")
+		if trimmed != body {
+			t.Errorf("got body\n%q\nwant\n%q", trimmed, body)
+		}
+	})
+
+	t.Run("ok, HTML body is sent as alternative", func(t *testing.T) {
+		srv := &fakeServer{startTLS: true}
+		sender := newSender(t, srv, nil)
+
+		msg := newTestMessage()
+		msg.HTMLBody = "<p>Welkom bij <a href=\"https://example.com\">Househunt</a> ✓</p>"
+
+		err := sender.Send(context.Background(), msg)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		got, err := mail.ReadMessage(strings.NewReader(srv.messages()[0].data))
+		if err != nil {
+			t.Fatalf("failed to read message: %v", err)
+		}
+
+		mediaType, params, err := mime.ParseMediaType(got.Header.Get("Content-Type"))
+		if err != nil || mediaType != "multipart/alternative" {
+			t.Fatalf("got media type %q, want multipart/alternative (error %v)", mediaType, err)
+		}
+
+		want := []struct{ contentType, body string }{
+			{"text/plain; charset=utf-8", msg.Body},
+			{"text/html; charset=utf-8", msg.HTMLBody},
+		}
+
+		mr := multipart.NewReader(got.Body, params["boundary"])
+		for _, w := range want {
+			part, err := mr.NextRawPart()
+			if err != nil {
+				t.Fatalf("failed to read part: %v", err)
+			}
+
+			if ct := part.Header.Get("Content-Type"); ct != w.contentType {
+				t.Errorf("got content type %q, want %q", ct, w.contentType)
+			}
+
+			b, err := io.ReadAll(quotedprintable.NewReader(part))
+			if err != nil || string(b) != w.body {
+				t.Errorf("got body %q, want %q (error %v)", b, w.body, err)
+			}
+		}
+
+		_, err = mr.NextPart()
+		if !errors.Is(err, io.EOF) {
+			t.Errorf("expected no more parts, got %v", err)
+		}
+	})
+}
+
+func Test_NewSender(t *testing.T) {
+	tests := map[string]func(*smtp.Settings){
+		"fail, no host":             func(s *smtp.Settings) { s.Host = "" },
+		"fail, invalid port":        func(s *smtp.Settings) { s.Port = 0 },
+		"fail, unknown TLS mode":    func(s *smtp.Settings) { s.TLS = "ssl" },
+		"fail, unknown auth":        func(s *smtp.Settings) { s.Username = "user"; s.Auth = "cram-md5" },
+		"fail, zero connect timout": func(s *smtp.Settings) { s.ConnectTimeout = 0 },
+		"fail, zero timeout":        func(s *smtp.Settings) { s.Timeout = 0 },
+	}
+
+	for name, modFunc := range tests {
+		t.Run(name, func(t *testing.T) {
+			s := validSettings()
+			modFunc(&s)
+
+			_, err := smtp.NewSender(s)
+			if err == nil {
+				t.Fatalf("expected error, got <nil>")
+			}
+		})
+	}
+}
+
+func newTestMessage() email.Message {
+	return email.Message{
+		From:      "from@example.com",
+		Recipient: "to@example.com",
+		Subject:   "Hello",
+		Body:      "Welcome!",
+	}
+}
+
+func validSettings() smtp.Settings {
+	return smtp.Settings{
+		Host:           "127.0.0.1",
+		Port:           587,
+		TLS:            smtp.TLSModeStartTLS,
+		Auth:           smtp.AuthPlain,
+		ConnectTimeout: time.Second,
+		Timeout:        5 * time.Second,
+	}
+}
+
+// newSender starts the fake server and returns a sender that is configured to use it.
+func newSender(t *testing.T, srv *fakeServer, modFunc func(*smtp.Settings)) *smtp.Sender {
+	t.Helper()
+
+	cert, pool := newCertificate(t)
+	srv.start(t, &tls.Config{Certificates: []tls.Certificate{cert}})
+
+	s := validSettings()
+	s.Port = srv.port
+	s.TLSConfig = &tls.Config{RootCAs: pool}
+	if modFunc != nil {
+		modFunc(&s)
+	}
+
+	sender, err := smtp.NewSender(s)
+	if err != nil {
+		t.Fatalf("failed to create sender: %v", err)
+	}
+
+	return sender
+}
+
+// closedPort returns a port that nothing listens on.
+func closedPort(t *testing.T) int {
+	t.Helper()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+
+	port := l.Addr().(*net.TCPAddr).Port
+	l.Close()
+
+	return port
+}
+
+// newCertificate creates a self-signed certificate for 127.0.0.1.
+func newCertificate(t *testing.T) (tls.Certificate, *x509.CertPool) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "fake smtp server"},
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("failed to create certificate: %v", err)
+	}
+
+	leaf, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("failed to parse certificate: %v", err)
+	}
+
+	pool := x509.NewCertPool()
+	pool.AddCert(leaf)
+
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: leaf}, pool
+}
+
+// fakeServer is a minimal SMTP server that accepts messages for any recipient.
+type fakeServer struct {
+	// startTLS advertises the STARTTLS extension.
+	startTLS bool
+	// implicitTLS only accepts TLS connections.
+	implicitTLS bool
+	// username and password are required if username is not empty.
+	username string
+	password string
+	// silent servers never greet the client.
+	silent bool
+	// rcptReply replaces the reply to the RCPT command if not empty.
+	rcptReply string
+
+	port      int
+	tlsConfig *tls.Config
+
+	mu       sync.Mutex
+	received []received
+}
+
+type received struct {
+	from          string
+	to            string
+	data          string
+	tls           bool
+	authenticated bool
+}
+
+func (s *fakeServer) messages() []received {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.received
+}
+
+func (s *fakeServer) start(t *testing.T, tlsConfig *tls.Config) {
+	t.Helper()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+
+	s.port = l.Addr().(*net.TCPAddr).Port
+	s.tlsConfig = tlsConfig
+
+	var wg sync.WaitGroup
+	t.Cleanup(func() {
+		l.Close()
+		wg.Wait()
+	})
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				defer conn.Close()
+
+				s.serve(conn)
+			}()
+		}
+	}()
+}
+
+func (s *fakeServer) serve(conn net.Conn) {
+	if s.silent {
+		// Wait for the client to give up.
+		_, _ = io.Copy(io.Discard, conn)
+		return
+	}
+
+	isTLS := false
+	if s.implicitTLS {
+		conn = tls.Server(conn, s.tlsConfig)
+		isTLS = true
+	}
+
+	tp := textproto.NewConn(conn)
+	authenticated := false
+	var msg received
+
+	reply := func(lines ...string) {
+		for _, l := range lines {
+			_ = tp.PrintfLine("%s", l)
+		}
+	}
+
+	reply("220 fake ESMTP")
+	for {
+		line, err := tp.ReadLine()
+		if err != nil {
+			return
+		}
+
+		cmd, arg, _ := strings.Cut(line, " ")
+		switch strings.ToUpper(cmd) {
+		case "EHLO", "HELO":
+			exts := []string{"fake"}
+			if s.startTLS && !isTLS {
+				exts = append(exts, "STARTTLS")
+			}
+			if s.username != "" {
+				exts = append(exts, "AUTH PLAIN LOGIN")
+			}
+
+			for i, ext := range exts {
+				sep := "-"
+				if i == len(exts)-1 {
+					sep = " "
+				}
+				reply("250" + sep + ext)
+			}
+		case "STARTTLS":
+			reply("220 ready to start TLS")
+			conn = tls.Server(conn, s.tlsConfig)
+			tp = textproto.NewConn(conn)
+			isTLS = true
+		case "AUTH":
+			mechanism, initial, _ := strings.Cut(arg, " ")
+			var username, password string
+			switch mechanism {
+			case "PLAIN":
+				b, _ := base64.StdEncoding.DecodeString(initial)
+				parts := bytes.Split(b, []byte{0})
+				if len(parts) == 3 {
+					username, password = string(parts[1]), string(parts[2])
+				}
+			case "LOGIN":
+				username = s.challenge(tp, "Username:")
+				password = s.challenge(tp, "Password:")
+			}
+
+			if username == "" || username != s.username || password != s.password {
+				reply("535 authentication failed")
+				continue
+			}
+
+			authenticated = true
+			reply("235 authenticated")
+		case "MAIL":
+			if s.username != "" && !authenticated {
+				reply("530 authentication required")
+				continue
+			}
+
+			msg = received{from: strings.Trim(strings.TrimPrefix(arg, "FROM:"), "<>"), tls: isTLS, authenticated: authenticated}
+			reply("250 ok")
+		case "RCPT":
+			if s.rcptReply != "" {
+				reply(s.rcptReply)
+				continue
+			}
+
+			msg.to = strings.Trim(strings.TrimPrefix(arg, "TO:"), "<>")
+			reply("250 ok")
+		case "DATA":
+			reply("354 end data with <CR><LF>.<CR><LF>")
+			data, err := tp.ReadDotBytes()
+			if err != nil {
+				return
+			}
+
+			// ReadDotBytes converts line endings to \n, restore them.
+			msg.data = strings.ReplaceAll(string(data), "\n", "\r\n")
+
+			s.mu.Lock()
+			s.received = append(s.received, msg)
+			s.mu.Unlock()
+
+			reply("250 queued")
+		case "QUIT":
+			reply("221 bye")
+			return
+		default:
+			reply("502 command not implemented")
+		}
+	}
+}
+
+func (s *fakeServer) challenge(tp *textproto.Conn, prompt string) string {
+	_ = tp.PrintfLine("334 %s", base64.StdEncoding.EncodeToString([]byte(prompt)))
+
+	line, err := tp.ReadLine()
+	if err != nil {
+		return ""
+	}
+
+	b, _ := base64.StdEncoding.DecodeString(line)
+	return string(b)
+}
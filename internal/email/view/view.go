@@ -2,6 +2,7 @@ package view
 
 import (
 	"fmt"
+	htmltemplate "html/template"
 	"io"
 	"io/fs"
 	"text/template"
@@ -10,13 +11,23 @@ import (
 )
 
 // View is a template used to render email messages.
+//
+// A view combines the following templates:
+// - {name}.tmpl (required)
+// - partials/*.tmpl (optional)
+//
+// The subject and body elements are rendered with text/template. The optional html
+// element is rendered with html/template, so that data is escaped. Partials can be
+// used by both, for example to share a layout between the HTML versions of emails.
 type View struct {
-	tmpl *template.Template
+	text *template.Template
+	// html is nil if the view has no html element.
+	html *htmltemplate.Template
 }
 
 // Parse parses the file system and returns a view for the given name.
 // fs is expected to contain *.tmpl files in the root directory.
-func Parse(fs fs.FS, name string) (*View, error) {
+func Parse(viewFS fs.FS, name string) (*View, error) {
 	// Validate the view name, just to be sure.
 	//
 	// Generally these will be hardcoded, but if for some reason we end
@@ -27,8 +38,18 @@ func Parse(fs fs.FS, name string) (*View, error) {
 		return nil, err
 	}
 
-	filename := fmt.Sprintf("%s.tmpl", name)
-	tmpl, err := template.New(name).ParseFS(fs, filename)
+	files := []string{
+		fmt.Sprintf("%s.tmpl", name),
+	}
+
+	partials, err := fs.Glob(viewFS, "partials/*.tmpl")
+	if err != nil {
+		return nil, fmt.Errorf("failed to glob for partials: %w", err)
+	}
+
+	files = append(files, partials...)
+
+	tmpl, err := template.New(name).ParseFS(viewFS, files...)
 	if err != nil {
 		return nil, err
 	}
@@ -43,13 +64,34 @@ func Parse(fs fs.FS, name string) (*View, error) {
 		return nil, fmt.Errorf("missing %s template", email.ElementBody)
 	}
 
-	return &View{
-		tmpl: tmpl,
-	}, nil
+	v := &View{
+		text: tmpl,
+	}
+
+	// The same files are parsed again as HTML templates, html/template only escapes
+	// the templates that are executed, so the text elements are left alone.
+	if tmpl.Lookup(string(email.ElementHTML)) != nil {
+		v.html, err = htmltemplate.New(name).ParseFS(viewFS, files...)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return v, nil
 }
 
+// Render renders the element of the view. It returns email.ErrElementNotDefined
+// if the element is html and the view doesn't have it.
 func (v *View) Render(w io.Writer, element email.TemplateElement, data any) error {
-	if err := v.tmpl.ExecuteTemplate(w, string(element), data); err != nil {
+	if element == email.ElementHTML {
+		if v.html == nil {
+			return fmt.Errorf("%s: %w", element, email.ErrElementNotDefined)
+		}
+
+		return v.html.ExecuteTemplate(w, string(element), data)
+	}
+
+	if err := v.text.ExecuteTemplate(w, string(element), data); err != nil {
 		return err
 	}
 
@@ -2,6 +2,7 @@ package view_test
 
 import (
 	"bytes"
+	"errors"
 	"io/fs"
 	"os"
 	"path/filepath"
@@ -139,6 +140,83 @@ func Test_View_ParseAndRender(t *testing.T) {
 	}
 }
 
+func Test_View_RenderHTML(t *testing.T) {
+	const textOnly = `{{ block "subject" . }}Hello{{ end }} {{ block "body" . }}Message{{ end }}`
+
+	t.Run("ok, with layout partial", func(t *testing.T) {
+		fs := tempTestFS(t, map[string]string{
+			"test.tmpl":            textOnly + `{{ define "html" }}{{ template "layout" . }}{{ end }}{{ define "content" }}<p>{{ . }}</p>{{ end }}`,
+			"partials/layout.tmpl": `{{ define "layout" }}<html>{{ template "content" . }}</html>{{ end }}`,
+		})
+
+		v, err := view.Parse(fs, "test")
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		var buf bytes.Buffer
+		err = v.Render(&buf, email.ElementHTML, "<b>Hi</b>")
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		want := `<html><p>&lt;b&gt;Hi&lt;/b&gt;</p></html>`
+		if got := buf.String(); got != want {
+			t.Errorf("unexpected html: got %q, want %q", got, want)
+		}
+	})
+
+	t.Run("ok, partials can be used in text elements", func(t *testing.T) {
+		fs := tempTestFS(t, map[string]string{
+			"test.tmpl":            `{{ block "subject" . }}Hello{{ end }} {{ block "body" . }}Message{{ template "signature" }}{{ end }}`,
+			"partials/footer.tmpl": `{{ define "signature" }}, Househunt{{ end }}`,
+		})
+
+		v, err := view.Parse(fs, "test")
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		var buf bytes.Buffer
+		err = v.Render(&buf, email.ElementBody, nil)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		if got := buf.String(); got != "Message, Househunt" {
+			t.Errorf("unexpected body: got %q", got)
+		}
+	})
+
+	t.Run("fail, no html element", func(t *testing.T) {
+		fs := tempTestFS(t, map[string]string{
+			"test.tmpl": textOnly,
+		})
+
+		v, err := view.Parse(fs, "test")
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		err = v.Render(&bytes.Buffer{}, email.ElementHTML, nil)
+		if !errors.Is(err, email.ErrElementNotDefined) {
+			t.Fatalf("expected error %v, got %v (via errors.Is)", email.ErrElementNotDefined, err)
+		}
+	})
+
+	t.Run("fail, syntax error in partial", func(t *testing.T) {
+		fs := tempTestFS(t, map[string]string{
+			"test.tmpl":            textOnly,
+			"partials/layout.tmpl": `{{ define "layout" }}<html>{{ end }`,
+		})
+
+		_, err := view.Parse(fs, "test")
+		if err == nil {
+			t.Fatalf("expected error, got nil")
+		}
+	})
+}
+
 func tempTestFS(t *testing.T, files map[string]string) fs.FS {
 	t.Helper()
 
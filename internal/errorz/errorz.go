@@ -10,6 +10,7 @@ import (
 var (
 	ErrNotFound           = errors.New("not found")
 	ErrConstraintViolated = errors.New("constraint violated")
+	ErrRateLimited        = errors.New("too many attempts, try again later")
 )
 
 // MapDBErr maps database errors to appropriate errorz errors.
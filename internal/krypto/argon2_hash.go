@@ -27,6 +27,40 @@ const (
 	parallelism = 1
 )
 
+// Argon2Params are the cost parameters of the argon2id algorithm.
+type Argon2Params struct {
+	MemoryKiB   uint32
+	Iterations  uint32
+	Parallelism uint8
+}
+
+// DefaultArgon2Params returns the parameters recommended by OWASP.
+func DefaultArgon2Params() Argon2Params {
+	return Argon2Params{
+		MemoryKiB:   memoryKiB,
+		Iterations:  iterations,
+		Parallelism: parallelism,
+	}
+}
+
+// Validate checks whether the parameters can be used by the argon2id algorithm.
+func (p Argon2Params) Validate() error {
+	if p.Iterations < 1 {
+		return fmt.Errorf("iterations should be at least 1: %w", ErrInvalidInput)
+	}
+
+	if p.Parallelism < 1 {
+		return fmt.Errorf("parallelism should be at least 1: %w", ErrInvalidInput)
+	}
+
+	// argon2 requires at least 8 KiB of memory per thread.
+	if p.MemoryKiB < 8*uint32(p.Parallelism) {
+		return fmt.Errorf("memory should be at least 8 KiB per thread: %w", ErrInvalidInput)
+	}
+
+	return nil
+}
+
 // Argon2Hash is a hash generated by the Argon2 Hashing Algorithm.
 type Argon2Hash struct {
 	Variant     string
@@ -38,41 +72,52 @@ type Argon2Hash struct {
 	Hash        []byte
 }
 
-// HashArgon2 hashes a byte slice using the argon2id algorithm.
+// HashArgon2 hashes a byte slice using the argon2id algorithm with the default parameters.
 func HashArgon2(b []byte) (Argon2Hash, error) {
+	return HashArgon2WithParams(b, DefaultArgon2Params())
+}
+
+// HashArgon2WithParams hashes a byte slice using the argon2id algorithm with the provided parameters.
+func HashArgon2WithParams(b []byte, p Argon2Params) (Argon2Hash, error) {
+	err := p.Validate()
+	if err != nil {
+		return Argon2Hash{}, err
+	}
+
 	// First we generate a salt.
 	salt, err := genRandomBytes(saltLen)
 	if err != nil {
 		return Argon2Hash{}, fmt.Errorf("failed to generate salt: %w", err)
 	}
 
-	return hashArgon2WithSalt(b, salt)
+	return hashArgon2WithSalt(b, salt, p)
 }
 
 // HashArgon2WithKey hashes a byte slice using the argon2id algorithm and uses the provided key as a salt.
+// It always uses the default parameters, so that the hashes can be used as blind indexes.
 func HashArgon2WithKey(b []byte, salt Key) (Argon2Hash, error) {
 	if len(salt.value) <= saltLen {
 		return Argon2Hash{}, fmt.Errorf("salt too short: %w", ErrInvalidInput)
 	}
 
-	return hashArgon2WithSalt(b, salt.value[:saltLen])
+	return hashArgon2WithSalt(b, salt.value[:saltLen], DefaultArgon2Params())
 }
 
-// hashArgon2WithSalt hashes a byte slice using the argon2id algorithm with the provided salt.
-func hashArgon2WithSalt(b []byte, salt []byte) (Argon2Hash, error) {
+// hashArgon2WithSalt hashes a byte slice using the argon2id algorithm with the provided salt and parameters.
+func hashArgon2WithSalt(b []byte, salt []byte, p Argon2Params) (Argon2Hash, error) {
 	if len(b) == 0 {
 		return Argon2Hash{}, fmt.Errorf("empty byte slice: %w", ErrInvalidInput)
 	}
 
 	// Then we hash the bytes.
-	hash := argon2.IDKey(b, salt, iterations, memoryKiB, parallelism, keyLen)
+	hash := argon2.IDKey(b, salt, p.Iterations, p.MemoryKiB, p.Parallelism, keyLen)
 
 	return Argon2Hash{
 		Variant:     variant,
 		Version:     argon2.Version,
-		MemoryKiB:   memoryKiB,
-		Iterations:  iterations,
-		Parallelism: parallelism,
+		MemoryKiB:   p.MemoryKiB,
+		Iterations:  p.Iterations,
+		Parallelism: p.Parallelism,
 		Salt:        salt,
 		Hash:        hash,
 	}, nil
@@ -125,6 +170,15 @@ func parseComponent(i int, v string, h *Argon2Hash) bool {
 	return false
 }
 
+// Params returns the parameters the hash was created with.
+func (h Argon2Hash) Params() Argon2Params {
+	return Argon2Params{
+		MemoryKiB:   h.MemoryKiB,
+		Iterations:  h.Iterations,
+		Parallelism: h.Parallelism,
+	}
+}
+
 // MatchBytes checks if the hash matches the given byte slice.
 func (h Argon2Hash) MatchBytes(b []byte) bool {
 	hash := argon2.IDKey(b, h.Salt, h.Iterations, h.MemoryKiB, h.Parallelism, uint32(len(h.Hash)))
@@ -110,6 +110,53 @@ func Test_Argon2Hash_HashArgon2AndMatch(t *testing.T) {
 	}
 }
 
+func Test_Argon2Hash_HashArgon2WithParams(t *testing.T) {
+	t.Run("ok, hash with custom parameters", func(t *testing.T) {
+		params := krypto.Argon2Params{MemoryKiB: 16, Iterations: 2, Parallelism: 2}
+
+		got, err := krypto.HashArgon2WithParams([]byte("12345678"), params)
+		if err != nil {
+			t.Fatalf("failed to hash argon2: %v", err)
+		}
+
+		if got.Params() != params {
+			t.Errorf("got params %+v, want %+v", got.Params(), params)
+		}
+
+		if !got.MatchBytes([]byte("12345678")) {
+			t.Errorf("expected raw value to match hash, but it did not")
+		}
+	})
+
+	t.Run("ok, default parameters", func(t *testing.T) {
+		got, err := krypto.HashArgon2([]byte("12345678"))
+		if err != nil {
+			t.Fatalf("failed to hash argon2: %v", err)
+		}
+
+		if got.Params() != krypto.DefaultArgon2Params() {
+			t.Errorf("got params %+v, want %+v", got.Params(), krypto.DefaultArgon2Params())
+		}
+	})
+
+	failTests := map[string]krypto.Argon2Params{
+		"fail, zero parameters":       {},
+		"fail, zero iterations":       {MemoryKiB: 16, Iterations: 0, Parallelism: 1},
+		"fail, zero parallelism":      {MemoryKiB: 16, Iterations: 1, Parallelism: 0},
+		"fail, too little memory":     {MemoryKiB: 7, Iterations: 1, Parallelism: 1},
+		"fail, too little per thread": {MemoryKiB: 16, Iterations: 1, Parallelism: 3},
+	}
+
+	for name, params := range failTests {
+		t.Run(name, func(t *testing.T) {
+			_, err := krypto.HashArgon2WithParams([]byte("12345678"), params)
+			if !errors.Is(err, krypto.ErrInvalidInput) {
+				t.Fatalf("expected %v, but got %v (via errors.Is)", krypto.ErrInvalidInput, err)
+			}
+		})
+	}
+}
+
 func Test_Argon2Hash_ParseArgon2HashAndMatch(t *testing.T) {
 	for name, tc := range okTextToArgon2Hash() {
 		t.Run(name, func(t *testing.T) {
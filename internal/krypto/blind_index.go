@@ -0,0 +1,111 @@
+package krypto
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+)
+
+// BlindIndexer creates blind indexes: argon2 hashes of data that use a secret key as salt.
+// Blind indexes allow encrypted values to be looked up without decrypting them.
+//
+// Like the Encryptor, the blind indexer uses an append only list of keys and the last key
+// in the list is considered the latest key. New indexes are created with the latest key,
+// while lookups can use the indexes of all keys that are not retired. This allows a new key
+// to be rolled out before all existing indexes are rebuilt.
+//
+// Indexes are prefixed with the index of the used key. Indexes of the first key have no
+// prefix, so that indexes created before keys were versioned remain valid.
+//
+// The argon2 parameters are part of every index, changing them also requires the indexes
+// to be rebuilt.
+type BlindIndexer struct {
+	keys []Key
+}
+
+// NewBlindIndexer creates a new blind indexer with the provided keys.
+// Older keys may be retired, the latest key may not.
+func NewBlindIndexer(keys []Key) (*BlindIndexer, error) {
+	if len(keys) == 0 {
+		return nil, errors.New("at least one key is required")
+	}
+
+	if keys[len(keys)-1].IsRetired() {
+		return nil, errors.New("latest key can't be retired")
+	}
+
+	return &BlindIndexer{
+		keys: keys,
+	}, nil
+}
+
+// Index returns the blind index of data using the latest key.
+func (b *BlindIndexer) Index(data []byte) (string, error) {
+	return b.index(data, len(b.keys)-1)
+}
+
+// Indexes returns the blind indexes of data for all keys that are not retired,
+// starting with the latest key. Use these when looking up values.
+func (b *BlindIndexer) Indexes(data []byte) ([]string, error) {
+	out := make([]string, 0, len(b.keys))
+	for i := len(b.keys) - 1; i >= 0; i-- {
+		if b.keys[i].IsRetired() {
+			continue
+		}
+
+		index, err := b.index(data, i)
+		if err != nil {
+			return nil, err
+		}
+
+		out = append(out, index)
+	}
+
+	return out, nil
+}
+
+// LatestKeyIndex returns the index of the key that is used for new indexes.
+func (b *BlindIndexer) LatestKeyIndex() int {
+	return len(b.keys) - 1
+}
+
+// IsRetired reports whether the key at keyIndex was retired, indexes created with it
+// are no longer used for lookups. Unknown keys are reported as retired as well.
+func (b *BlindIndexer) IsRetired(keyIndex int) bool {
+	return keyIndex < 0 || keyIndex >= len(b.keys) || b.keys[keyIndex].IsRetired()
+}
+
+func (b *BlindIndexer) index(data []byte, keyIndex int) (string, error) {
+	hash, err := HashArgon2WithKey(data, b.keys[keyIndex])
+	if err != nil {
+		return "", err
+	}
+
+	// overwrite the salt because we don't want to store it.
+	hash.Salt = nil
+
+	if keyIndex == 0 {
+		return hash.String(), nil
+	}
+
+	return strconv.Itoa(keyIndex) + hash.String(), nil
+}
+
+// BlindIndexKeyIndex returns the index of the key the blind index was created with.
+func BlindIndexKeyIndex(index string) (int, error) {
+	prefix, _, ok := strings.Cut(index, "$")
+	if !ok {
+		return 0, ErrInvalidData
+	}
+
+	if prefix == "" {
+		return 0, nil
+	}
+
+	keyIndex, err := strconv.Atoi(prefix)
+	if err != nil || keyIndex < 1 {
+		return 0, ErrInvalidData
+	}
+
+	return keyIndex, nil
+}
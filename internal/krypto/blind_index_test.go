@@ -0,0 +1,112 @@
+package krypto_test
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/willemschots/househunt/internal/krypto"
+)
+
+func Test_NewBlindIndexer(t *testing.T) {
+	t.Run("fail, no keys", func(t *testing.T) {
+		_, err := krypto.NewBlindIndexer(nil)
+		if err == nil {
+			t.Fatalf("wanted error, got <nil>")
+		}
+	})
+
+	t.Run("fail, latest key retired", func(t *testing.T) {
+		_, err := krypto.NewBlindIndexer([]krypto.Key{
+			must(krypto.ParseKey("2b671594b775f371eab4050b4d58326682df6b1a6cc2e886717b1a26b4d6c45d")),
+			must(krypto.ParseEncryptionKey(krypto.RetiredKey)),
+		})
+		if err == nil {
+			t.Fatalf("wanted error, got <nil>")
+		}
+	})
+}
+
+func Test_BlindIndexer(t *testing.T) {
+	oldKey := must(krypto.ParseKey("2b671594b775f371eab4050b4d58326682df6b1a6cc2e886717b1a26b4d6c45d"))
+	newKey := must(krypto.ParseKey("90303dfed7994260ea4817a5ca8a392915cd401115b2f97495dadfcbcd14adbf"))
+	data := []byte("info@example.com")
+
+	oldIndexer := must(krypto.NewBlindIndexer([]krypto.Key{oldKey}))
+	oldIndex := must(oldIndexer.Index(data))
+
+	// Indexes of the first key are plain argon2 hashes without a salt.
+	if !strings.HasPrefix(oldIndex, "$argon2id$") || !strings.Contains(oldIndex, "$$") {
+		t.Fatalf("unexpected index format: %s", oldIndex)
+	}
+
+	t.Run("ok, index with latest key", func(t *testing.T) {
+		indexer := must(krypto.NewBlindIndexer([]krypto.Key{oldKey, newKey}))
+		if indexer.LatestKeyIndex() != 1 {
+			t.Fatalf("want latest key index 1, got %d", indexer.LatestKeyIndex())
+		}
+
+		got := must(indexer.Index(data))
+		if !strings.HasPrefix(got, "1$argon2id$") {
+			t.Fatalf("unexpected index format: %s", got)
+		}
+
+		keyIndex, err := krypto.BlindIndexKeyIndex(got)
+		if err != nil || keyIndex != 1 {
+			t.Fatalf("want key index 1, got %d and error %v", keyIndex, err)
+		}
+
+		// Indexes are deterministic.
+		if got != must(indexer.Index(data)) {
+			t.Fatalf("expected the same index twice")
+		}
+	})
+
+	t.Run("ok, indexes of all keys", func(t *testing.T) {
+		indexer := must(krypto.NewBlindIndexer([]krypto.Key{oldKey, newKey}))
+
+		got := must(indexer.Indexes(data))
+		want := []string{must(indexer.Index(data)), oldIndex}
+		if !reflect.DeepEqual(got, want) {
+			t.Fatalf("got %v, want %v", got, want)
+		}
+	})
+
+	t.Run("ok, retired keys are skipped", func(t *testing.T) {
+		retired := must(krypto.ParseEncryptionKey(krypto.RetiredKey))
+		indexer := must(krypto.NewBlindIndexer([]krypto.Key{retired, newKey}))
+
+		got := must(indexer.Indexes(data))
+		if len(got) != 1 || got[0] != must(indexer.Index(data)) {
+			t.Fatalf("expected only the index of the latest key, got %v", got)
+		}
+	})
+
+	t.Run("ok, retired keys", func(t *testing.T) {
+		retired := must(krypto.ParseEncryptionKey(krypto.RetiredKey))
+		indexer := must(krypto.NewBlindIndexer([]krypto.Key{retired, oldKey, newKey}))
+
+		for keyIndex, want := range map[int]bool{-1: true, 0: true, 1: false, 2: false, 3: true} {
+			if got := indexer.IsRetired(keyIndex); got != want {
+				t.Errorf("got retired %v for key %d, want %v", got, keyIndex, want)
+			}
+		}
+	})
+
+	t.Run("ok, key index of old index", func(t *testing.T) {
+		keyIndex, err := krypto.BlindIndexKeyIndex(oldIndex)
+		if err != nil || keyIndex != 0 {
+			t.Fatalf("want key index 0, got %d and error %v", keyIndex, err)
+		}
+	})
+
+	for _, index := range []string{"", "argon2id", "0$argon2id$", "x$argon2id$"} {
+		t.Run("fail, invalid key index "+index, func(t *testing.T) {
+			_, err := krypto.BlindIndexKeyIndex(index)
+			if !errors.Is(err, krypto.ErrInvalidData) {
+				t.Fatalf("wanted error %v, got %v (via errors.Is)", krypto.ErrInvalidData, err)
+			}
+		})
+	}
+}
@@ -32,11 +32,16 @@ type Encryptor struct {
 }
 
 // NewEncryptor creates a new encryptor with the provided keys.
+// Older keys may be retired, the latest key may not.
 func NewEncryptor(keys []Key) (*Encryptor, error) {
 	if len(keys) == 0 {
 		return nil, errors.New("at least one key is required")
 	}
 
+	if keys[len(keys)-1].IsRetired() {
+		return nil, errors.New("latest key can't be retired")
+	}
+
 	return &Encryptor{
 		keys: keys,
 	}, nil
@@ -82,7 +87,7 @@ func (s *Encryptor) Decrypt(message []byte) ([]byte, error) {
 	}
 
 	index := binary.BigEndian.Uint32(message[:indexBytes])
-	if int(index) >= len(s.keys) {
+	if int(index) >= len(s.keys) || s.keys[index].IsRetired() {
 		return nil, ErrUnknownKey
 	}
 
@@ -108,6 +113,20 @@ func (s *Encryptor) Decrypt(message []byte) ([]byte, error) {
 	return gcm.Open(nil, nonce, ciphertext, message[:4])
 }
 
+// LatestKeyIndex returns the index of the key that is used for encryption.
+func (s *Encryptor) LatestKeyIndex() int {
+	return len(s.keys) - 1
+}
+
+// KeyIndex returns the index of the key the message was encrypted with.
+func KeyIndex(message []byte) (int, error) {
+	if len(message) < indexBytes {
+		return 0, ErrInvalidData
+	}
+
+	return int(binary.BigEndian.Uint32(message[:indexBytes])), nil
+}
+
 func randBytes(n int) ([]byte, error) {
 	b := make([]byte, n)
 	_, err := rand.Read(b)
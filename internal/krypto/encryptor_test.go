@@ -15,6 +15,46 @@ func Test_NewEncryptor(t *testing.T) {
 			t.Fatalf("wanted error, got <nil>")
 		}
 	})
+
+	t.Run("fail, latest key retired", func(t *testing.T) {
+		_, err := krypto.NewEncryptor([]krypto.Key{
+			must(krypto.ParseKey("2b671594b775f371eab4050b4d58326682df6b1a6cc2e886717b1a26b4d6c45d")),
+			must(krypto.ParseEncryptionKey(krypto.RetiredKey)),
+		})
+		if err == nil {
+			t.Fatalf("wanted error, got <nil>")
+		}
+	})
+}
+
+func Test_KeyIndex(t *testing.T) {
+	keys := []krypto.Key{
+		must(krypto.ParseKey("2b671594b775f371eab4050b4d58326682df6b1a6cc2e886717b1a26b4d6c45d")),
+		must(krypto.ParseKey("90303dfed7994260ea4817a5ca8a392915cd401115b2f97495dadfcbcd14adbf")),
+	}
+
+	for i := range keys {
+		enc := must(krypto.NewEncryptor(keys[:i+1]))
+		if enc.LatestKeyIndex() != i {
+			t.Fatalf("want latest key index %d, got %d", i, enc.LatestKeyIndex())
+		}
+
+		result := must(enc.Encrypt([]byte("my secret message")))
+
+		got, err := krypto.KeyIndex(result)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		if got != i {
+			t.Fatalf("want key index %d, got %d", i, got)
+		}
+	}
+
+	_, err := krypto.KeyIndex([]byte{0, 0, 0})
+	if !errors.Is(err, krypto.ErrInvalidData) {
+		t.Fatalf("wanted error %v, got %v (via errors.Is)", krypto.ErrInvalidData, err)
+	}
 }
 
 func Test_Encryptor_EncryptAndDecrypt(t *testing.T) {
@@ -134,6 +174,29 @@ func Test_Encryptor_EncryptAndDecrypt(t *testing.T) {
 		}
 	})
 
+	t.Run("fail, key was retired", func(t *testing.T) {
+		keys := []krypto.Key{
+			must(krypto.ParseKey("2b671594b775f371eab4050b4d58326682df6b1a6cc2e886717b1a26b4d6c45d")),
+			must(krypto.ParseKey("90303dfed7994260ea4817a5ca8a392915cd401115b2f97495dadfcbcd14adbf")),
+		}
+
+		encOld := must(krypto.NewEncryptor(keys[:1]))
+		encNew := must(krypto.NewEncryptor([]krypto.Key{
+			must(krypto.ParseEncryptionKey(krypto.RetiredKey)),
+			keys[1],
+		}))
+
+		result, err := encOld.Encrypt([]byte("my secret message"))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		_, err = encNew.Decrypt(result)
+		if !errors.Is(err, krypto.ErrUnknownKey) {
+			t.Fatalf("wanted error %v, got %v (via errors.Is)", krypto.ErrUnknownKey, err)
+		}
+	})
+
 	t.Run("fail, key was changed", func(t *testing.T) {
 		keys := []krypto.Key{
 			must(krypto.ParseKey("2b671594b775f371eab4050b4d58326682df6b1a6cc2e886717b1a26b4d6c45d")),
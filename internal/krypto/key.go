@@ -12,6 +12,10 @@ const (
 	// SecretMarker is a string we can look for in logs to see if the app
 	// is accidentally exposing secrets.
 	SecretMarker = "<!SECRET_REDACTED!>"
+
+	// RetiredKey can be provided instead of an encryption key that is no longer used,
+	// see ParseEncryptionKey.
+	RetiredKey = "retired"
 )
 
 var (
@@ -40,6 +44,22 @@ func ParseKey(raw string) (Key, error) {
 	}, nil
 }
 
+// ParseEncryptionKey is like ParseKey, but also accepts RetiredKey. An Encryptor can't
+// decrypt data with a retired key, but retired keys keep their place in the list of
+// keys so that the indexes of the other keys don't change.
+func ParseEncryptionKey(raw string) (Key, error) {
+	if raw == RetiredKey {
+		return Key{}, nil
+	}
+
+	return ParseKey(raw)
+}
+
+// IsRetired reports whether the key was retired, see ParseEncryptionKey.
+func (k Key) IsRetired() bool {
+	return len(k.value) == 0
+}
+
 func (k Key) Format(f fmt.State, verb rune) {
 	f.Write([]byte(SecretMarker))
 }
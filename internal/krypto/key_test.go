@@ -35,6 +35,23 @@ func Test_ParseKey(t *testing.T) {
 	}
 }
 
+func Test_ParseEncryptionKey(t *testing.T) {
+	key, err := krypto.ParseEncryptionKey("2b671594b775f371eab4050b4d58326682df6b1a6cc2e886717b1a26b4d6c45d")
+	if err != nil || key.IsRetired() {
+		t.Fatalf("expected a key that is not retired, got retired %v and error %v", key.IsRetired(), err)
+	}
+
+	key, err = krypto.ParseEncryptionKey(krypto.RetiredKey)
+	if err != nil || !key.IsRetired() {
+		t.Fatalf("expected a retired key, got retired %v and error %v", key.IsRetired(), err)
+	}
+
+	_, err = krypto.ParseEncryptionKey("")
+	if err == nil {
+		t.Fatalf("wanted error, got <nil>")
+	}
+}
+
 func Test_Key_PreventExposure(t *testing.T) {
 	raw := "2b671594b775f371eab4050b4d58326682df6b1a6cc2e886717b1a26b4d6c45d"
 	key := must(krypto.ParseKey(raw))
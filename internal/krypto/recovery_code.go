@@ -0,0 +1,70 @@
+package krypto
+
+import (
+	"encoding/base32"
+	"errors"
+	"log/slog"
+	"strings"
+)
+
+const (
+	recoveryCodeLen = 10
+	// recoveryCodeGroup is the number of characters between dashes in the formatted code.
+	recoveryCodeGroup = 4
+)
+
+var (
+	ErrInvalidRecoveryCode = errors.New("invalid recovery code")
+
+	recoveryCodeEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+)
+
+// RecoveryCode is a random single-use code that can be used instead of a second
+// factor, in case the user lost access to it. Recovery codes are shown to the user
+// once and should only be persisted as a hash.
+type RecoveryCode [recoveryCodeLen]byte
+
+// GenerateRecoveryCode creates a new random recovery code.
+func GenerateRecoveryCode() (RecoveryCode, error) {
+	b, err := genRandomBytes(recoveryCodeLen)
+	if err != nil {
+		return RecoveryCode{}, err
+	}
+	return RecoveryCode(b), nil
+}
+
+// ParseRecoveryCode parses a recovery code. Case, dashes and spaces are ignored,
+// so that users can enter codes the way they wrote them down.
+func ParseRecoveryCode(raw string) (RecoveryCode, error) {
+	raw = strings.ToUpper(raw)
+	raw = strings.NewReplacer("-", "", " ", "").Replace(raw)
+
+	if len(raw) != recoveryCodeEncoding.EncodedLen(recoveryCodeLen) {
+		return RecoveryCode{}, ErrInvalidRecoveryCode
+	}
+
+	b, err := recoveryCodeEncoding.DecodeString(raw)
+	if err != nil {
+		return RecoveryCode{}, ErrInvalidRecoveryCode
+	}
+	return RecoveryCode(b), nil
+}
+
+// String returns the code as lowercase base32 in groups of four characters.
+func (c RecoveryCode) String() string {
+	raw := strings.ToLower(recoveryCodeEncoding.EncodeToString(c[:]))
+
+	var b strings.Builder
+	for i, r := range raw {
+		if i > 0 && i%recoveryCodeGroup == 0 {
+			b.WriteRune('-')
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// LogValue implements the slog.Valuer interface.
+func (c RecoveryCode) LogValue() slog.Value {
+	return slog.StringValue(SecretMarker)
+}
@@ -0,0 +1,223 @@
+package krypto_test
+
+import (
+	"bytes"
+	"errors"
+	"log/slog"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/willemschots/househunt/internal/krypto"
+)
+
+// rfcSecret is the SHA1 secret used by the test vectors in RFC 6238.
+var rfcSecret = krypto.TOTPSecret([]byte("12345678901234567890"))
+
+func Test_TOTPSecret_GenerateTOTPSecret(t *testing.T) {
+	s1, err := krypto.GenerateTOTPSecret()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	s2, err := krypto.GenerateTOTPSecret()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if s1 == s2 {
+		t.Fatalf("expected different secrets, got %v twice", s1)
+	}
+}
+
+func Test_TOTPSecret_ParseTOTPSecret(t *testing.T) {
+	t.Run("ok, round trip", func(t *testing.T) {
+		raw := rfcSecret.String()
+		if raw != "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ" {
+			t.Fatalf("unexpected encoding: %s", raw)
+		}
+
+		got, err := krypto.ParseTOTPSecret(raw)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		if got != rfcSecret {
+			t.Fatalf("got\n%v\nwant\n%v\n", got, rfcSecret)
+		}
+	})
+
+	tests := map[string]string{
+		"fail, empty":             "",
+		"fail, too short":         "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJ",
+		"fail, too long":          "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQGE",
+		"fail, invalid character": "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJ1",
+	}
+
+	for name, raw := range tests {
+		t.Run(name, func(t *testing.T) {
+			_, err := krypto.ParseTOTPSecret(raw)
+			if !errors.Is(err, krypto.ErrInvalidTOTPSecret) {
+				t.Fatalf("expected error %v, got %v ", krypto.ErrInvalidTOTPSecret, err)
+			}
+		})
+	}
+}
+
+func Test_TOTPSecret_Code(t *testing.T) {
+	// Test vectors from RFC 6238, truncated to 6 digits.
+	tests := map[int64]string{
+		59:          "287082",
+		1111111109:  "081804",
+		1111111111:  "050471",
+		1234567890:  "005924",
+		2000000000:  "279037",
+		20000000000: "353130",
+	}
+
+	for unix, want := range tests {
+		t.Run(want, func(t *testing.T) {
+			got := rfcSecret.Code(time.Unix(unix, 0))
+			if got != want {
+				t.Fatalf("got %s want %s", got, want)
+			}
+		})
+	}
+}
+
+func Test_TOTPSecret_MatchCode(t *testing.T) {
+	now := time.Unix(1111111111, 0)
+	step := krypto.TOTPStep(now)
+
+	tests := map[string]struct {
+		code     string
+		wantStep int64
+		wantOK   bool
+	}{
+		"ok, current step": {
+			code:     rfcSecret.Code(now),
+			wantStep: step,
+			wantOK:   true,
+		},
+		"ok, previous step": {
+			code:     rfcSecret.Code(now.Add(-30 * time.Second)),
+			wantStep: step - 1,
+			wantOK:   true,
+		},
+		"ok, next step": {
+			code:     rfcSecret.Code(now.Add(30 * time.Second)),
+			wantStep: step + 1,
+			wantOK:   true,
+		},
+		"ok, surrounding whitespace": {
+			code:     " " + rfcSecret.Code(now) + "\n",
+			wantStep: step,
+			wantOK:   true,
+		},
+		"fail, too old": {
+			code: rfcSecret.Code(now.Add(-60 * time.Second)),
+		},
+		"fail, too new": {
+			code: rfcSecret.Code(now.Add(60 * time.Second)),
+		},
+		"fail, empty": {
+			code: "",
+		},
+		"fail, too short": {
+			code: rfcSecret.Code(now)[:5],
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			gotStep, gotOK := rfcSecret.MatchCode(tc.code, now)
+			if gotStep != tc.wantStep || gotOK != tc.wantOK {
+				t.Fatalf("got (%d, %v) want (%d, %v)", gotStep, gotOK, tc.wantStep, tc.wantOK)
+			}
+		})
+	}
+}
+
+func Test_TOTPSecret_URI(t *testing.T) {
+	raw := rfcSecret.URI("Househunt", "info@example.com")
+
+	u, err := url.Parse(raw)
+	if err != nil {
+		t.Fatalf("failed to parse uri: %v", err)
+	}
+
+	if u.Scheme != "otpauth" || u.Host != "totp" || u.Path != "/Househunt:info@example.com" {
+		t.Errorf("unexpected uri: %s", raw)
+	}
+
+	q := u.Query()
+	if q.Get("secret") != rfcSecret.String() || q.Get("issuer") != "Househunt" {
+		t.Errorf("unexpected query: %s", raw)
+	}
+}
+
+func Test_TOTPSecret_PreventExposure(t *testing.T) {
+	var buf bytes.Buffer
+
+	logger := slog.New(slog.NewTextHandler(&buf, nil))
+
+	logger.Info("attempting to log a totp secret", "secret", rfcSecret)
+
+	s := buf.String()
+	if !strings.Contains(s, krypto.SecretMarker) {
+		t.Errorf("log output\n%s\ndoes not contain secret marker: %s", s, krypto.SecretMarker)
+	}
+
+	if strings.Contains(s, rfcSecret.String()) {
+		t.Errorf("log output\n%s\ncontains raw secret: %s", s, rfcSecret.String())
+	}
+}
+
+func Test_RecoveryCode_ParseRecoveryCode(t *testing.T) {
+	code, err := krypto.GenerateRecoveryCode()
+	if err != nil {
+		t.Fatalf("failed to generate recovery code: %v", err)
+	}
+
+	raw := code.String()
+	if len(raw) != 19 || strings.Count(raw, "-") != 3 {
+		t.Fatalf("unexpected format: %s", raw)
+	}
+
+	valid := map[string]string{
+		"ok, formatted":      raw,
+		"ok, uppercase":      strings.ToUpper(raw),
+		"ok, without dashes": strings.ReplaceAll(raw, "-", ""),
+		"ok, spaces":         strings.ReplaceAll(raw, "-", " "),
+	}
+
+	for name, in := range valid {
+		t.Run(name, func(t *testing.T) {
+			got, err := krypto.ParseRecoveryCode(in)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if got != code {
+				t.Fatalf("got\n%v\nwant\n%v\n", got, code)
+			}
+		})
+	}
+
+	invalid := map[string]string{
+		"fail, empty":             "",
+		"fail, too short":         raw[:18],
+		"fail, too long":          raw + "a",
+		"fail, invalid character": "1" + raw[1:],
+	}
+
+	for name, in := range invalid {
+		t.Run(name, func(t *testing.T) {
+			_, err := krypto.ParseRecoveryCode(in)
+			if !errors.Is(err, krypto.ErrInvalidRecoveryCode) {
+				t.Fatalf("expected error %v, got %v ", krypto.ErrInvalidRecoveryCode, err)
+			}
+		})
+	}
+}
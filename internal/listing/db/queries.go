@@ -0,0 +1,163 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+
+	"github.com/google/uuid"
+	"github.com/willemschots/househunt/internal/db"
+	"github.com/willemschots/househunt/internal/errorz"
+	"github.com/willemschots/househunt/internal/listing"
+)
+
+type execFunc func(query string, params ...any) (sql.Result, error)
+type queryFunc func(query string, params ...any) (*sql.Rows, error)
+
+func insertListing(q db.Query, ef execFunc, l listing.Listing) error {
+	if l.ID == uuid.Nil {
+		return fmt.Errorf("zero uuid provided: %w", errorz.ErrConstraintViolated)
+	}
+
+	q.Unsafe(`INSERT INTO listings (id, agent_id, title, address, description, price, published_at, created_at, updated_at) VALUES (`)
+	q.Params(l.ID, l.AgentID, l.Title, l.Address, l.Description, l.Price, l.PublishedAt, l.CreatedAt, l.UpdatedAt)
+	q.Unsafe(`)`)
+
+	s, params, err := q.Get()
+	if err != nil {
+		return err
+	}
+
+	_, err = ef(s, params...)
+	if err != nil {
+		return errorz.MapDBErr(err)
+	}
+
+	return nil
+}
+
+func updateListing(q db.Query, ef execFunc, l listing.Listing) error {
+	q.Unsafe(`UPDATE listings SET `)
+
+	q.Unsafe(`agent_id = `)
+	q.Param(l.AgentID)
+
+	q.Unsafe(`, title = `)
+	q.Param(l.Title)
+
+	q.Unsafe(`, address = `)
+	q.Param(l.Address)
+
+	q.Unsafe(`, description = `)
+	q.Param(l.Description)
+
+	q.Unsafe(`, price = `)
+	q.Param(l.Price)
+
+	q.Unsafe(`, published_at = `)
+	q.Param(l.PublishedAt)
+
+	q.Unsafe(`, created_at = `)
+	q.Param(l.CreatedAt)
+
+	q.Unsafe(`, updated_at = `)
+	q.Param(l.UpdatedAt)
+
+	q.Unsafe(` WHERE id = `)
+	q.Param(l.ID)
+
+	return execAffectingOne(q, ef, "listing")
+}
+
+func deleteListing(q db.Query, ef execFunc, id uuid.UUID) error {
+	q.Unsafe(`DELETE FROM listings WHERE id = `)
+	q.Param(id)
+
+	return execAffectingOne(q, ef, "listing")
+}
+
+func selectListings(q db.Query, qf queryFunc, f listing.ListingFilter) ([]listing.Listing, error) {
+	q.Unsafe(`SELECT id, agent_id, title, address, description, price, published_at, created_at, updated_at FROM listings WHERE 1=1 `)
+
+	if len(f.IDs) > 0 {
+		q.Unsafe(`AND id IN (`)
+		q.Params(anySlice(f.IDs)...)
+		q.Unsafe(`) `)
+	}
+
+	if len(f.AgentIDs) > 0 {
+		q.Unsafe(`AND agent_id IN (`)
+		q.Params(anySlice(f.AgentIDs)...)
+		q.Unsafe(`) `)
+	}
+
+	if f.IsPublished != nil {
+		q.Unsafe("AND published_at IS ")
+		if *f.IsPublished {
+			q.Unsafe("NOT ")
+		}
+		q.Unsafe("NULL ")
+	}
+
+	q.Unsafe(`ORDER BY created_at DESC, id ASC`)
+
+	s, params, err := q.Get()
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := qf(s, params...)
+	if err != nil {
+		return nil, errorz.MapDBErr(err)
+	}
+
+	defer rows.Close()
+
+	out := make([]listing.Listing, 0)
+	for rows.Next() {
+		var l listing.Listing
+		err := rows.Scan(&l.ID, &l.AgentID, &l.Title, &l.Address, &l.Description, &l.Price, &l.PublishedAt, &l.CreatedAt, &l.UpdatedAt)
+		if err != nil {
+			return nil, errorz.MapDBErr(err)
+		}
+
+		out = append(out, l)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, errorz.MapDBErr(err)
+	}
+
+	return out, nil
+}
+
+// execAffectingOne executes the query and returns errorz.ErrNotFound if no rows were affected.
+func execAffectingOne(q db.Query, ef execFunc, entity string) error {
+	s, params, err := q.Get()
+	if err != nil {
+		return err
+	}
+
+	result, err := ef(s, params...)
+	if err != nil {
+		return errorz.MapDBErr(err)
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return errorz.MapDBErr(err)
+	}
+
+	if rows == 0 {
+		return fmt.Errorf("%s not found: %w", entity, errorz.ErrNotFound)
+	}
+
+	return nil
+}
+
+func anySlice[T any](s []T) []any {
+	out := make([]any, 0, len(s))
+	for _, v := range s {
+		out = append(out, v)
+	}
+	return out
+}
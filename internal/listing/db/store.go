@@ -0,0 +1,45 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/willemschots/househunt/internal/db"
+	"github.com/willemschots/househunt/internal/listing"
+)
+
+// Store is responsible for interacting with a database.
+type Store struct {
+	writeDB *sql.DB
+	readDB  *sql.DB
+}
+
+// New creates a new Store.
+func New(writeDB, readDB *sql.DB) *Store {
+	return &Store{
+		writeDB: writeDB,
+		readDB:  readDB,
+	}
+}
+
+func (s *Store) newQuery() db.Query {
+	return db.Query{}
+}
+
+// BeginTx starts a new transaction.
+func (s *Store) BeginTx(ctx context.Context) (listing.Tx, error) {
+	tx, err := s.writeDB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	return &Tx{
+		tx:    tx,
+		store: s,
+	}, nil
+}
+
+func (s *Store) FindListings(ctx context.Context, filter listing.ListingFilter) ([]listing.Listing, error) {
+	return selectListings(s.newQuery(), func(query string, params ...any) (*sql.Rows, error) {
+		return s.readDB.QueryContext(ctx, query, params...)
+	}, filter)
+}
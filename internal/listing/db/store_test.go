@@ -0,0 +1,391 @@
+package db_test
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/willemschots/househunt/internal/db/testdb"
+	"github.com/willemschots/househunt/internal/errorz"
+	"github.com/willemschots/househunt/internal/listing"
+	"github.com/willemschots/househunt/internal/listing/db"
+)
+
+var (
+	agent1 = must(uuid.Parse("0e61a06e-bbf6-4b87-aaaa-75fee0f38cca"))
+	agent2 = must(uuid.Parse("597228ee-afde-4991-b13c-0161325e3930"))
+)
+
+func Test_Tx_CreateListing(t *testing.T) {
+	t.Run("ok, create listing", inTx(func(t *testing.T, tx listing.Tx) {
+		l := newListing(t, nil)
+
+		err := tx.CreateListing(l)
+		if err != nil {
+			t.Fatalf("failed to save listing: %v", err)
+		}
+
+		assertFindListing(t, tx, l)
+	}))
+
+	t.Run("ok, create published listing", inTx(func(t *testing.T, tx listing.Tx) {
+		l := newListing(t, func(l *listing.Listing) {
+			l.PublishedAt = ptr(now(t, 1))
+		})
+
+		err := tx.CreateListing(l)
+		if err != nil {
+			t.Fatalf("failed to save listing: %v", err)
+		}
+
+		assertFindListing(t, tx, l)
+	}))
+
+	t.Run("fail, agent foreign key does not exist", inTx(func(t *testing.T, tx listing.Tx) {
+		l := newListing(t, func(l *listing.Listing) {
+			l.AgentID = must(uuid.Parse("d622d0b0-465c-4c4d-b084-028c9787e1de"))
+		})
+
+		err := tx.CreateListing(l)
+		if !errors.Is(err, errorz.ErrConstraintViolated) {
+			t.Fatalf("expected errors to be %v got %v (via errors.Is)", errorz.ErrConstraintViolated, err)
+		}
+	}))
+
+	t.Run("fail, zero ID", inTx(func(t *testing.T, tx listing.Tx) {
+		l := newListing(t, func(l *listing.Listing) {
+			l.ID = uuid.Nil
+		})
+
+		err := tx.CreateListing(l)
+		if !errors.Is(err, errorz.ErrConstraintViolated) {
+			t.Fatalf("expected errors to be %v got %v (via errors.Is)", errorz.ErrConstraintViolated, err)
+		}
+	}))
+}
+
+func Test_Tx_UpdateListing(t *testing.T) {
+	setup := func(t *testing.T, tx listing.Tx) listing.Listing {
+		l := newListing(t, nil)
+		err := tx.CreateListing(l)
+		if err != nil {
+			t.Fatalf("failed to save listing: %v", err)
+		}
+
+		return l
+	}
+
+	t.Run("ok, update listing", inTx(func(t *testing.T, tx listing.Tx) {
+		l := setup(t, tx)
+
+		// Update all fields that can be modified.
+		l.AgentID = agent2
+		l.Title = "Spacious loft"
+		l.Address = "Keizersgracht 2, Amsterdam"
+		l.Description = "Lots of light."
+		l.Price = 750000
+		l.PublishedAt = ptr(now(t, 2))
+		l.CreatedAt = now(t, 1)
+		l.UpdatedAt = now(t, 2)
+
+		err := tx.UpdateListing(l)
+		if err != nil {
+			t.Fatalf("failed to save listing: %v", err)
+		}
+
+		assertFindListing(t, tx, l)
+	}))
+
+	t.Run("fail, not found", inTx(func(t *testing.T, tx listing.Tx) {
+		l := setup(t, tx)
+
+		l.ID = must(uuid.Parse("4516a1c0-efc3-4561-9e97-e749e008aa3f"))
+
+		err := tx.UpdateListing(l)
+		if !errors.Is(err, errorz.ErrNotFound) {
+			t.Fatalf("expected errors to be %v got %v (via errors.Is)", errorz.ErrNotFound, err)
+		}
+	}))
+}
+
+func Test_Tx_DeleteListing(t *testing.T) {
+	t.Run("ok, delete listing", inTx(func(t *testing.T, tx listing.Tx) {
+		l := newListing(t, nil)
+		err := tx.CreateListing(l)
+		if err != nil {
+			t.Fatalf("failed to save listing: %v", err)
+		}
+
+		err = tx.DeleteListing(l.ID)
+		if err != nil {
+			t.Fatalf("failed to delete listing: %v", err)
+		}
+
+		got, err := tx.FindListings(listing.ListingFilter{IDs: []uuid.UUID{l.ID}})
+		if err != nil {
+			t.Fatalf("failed to find listings: %v", err)
+		}
+
+		if len(got) != 0 {
+			t.Fatalf("expected no listings, got %d", len(got))
+		}
+	}))
+
+	t.Run("fail, not found", inTx(func(t *testing.T, tx listing.Tx) {
+		err := tx.DeleteListing(must(uuid.Parse("4516a1c0-efc3-4561-9e97-e749e008aa3f")))
+		if !errors.Is(err, errorz.ErrNotFound) {
+			t.Fatalf("expected errors to be %v got %v (via errors.Is)", errorz.ErrNotFound, err)
+		}
+	}))
+}
+
+func Test_Tx_FindListings(t *testing.T) {
+	setupListings := func(t *testing.T, tx listing.Tx) []listing.Listing {
+		// Listings are ordered by creation time, newest first.
+		listings := []listing.Listing{
+			newListing(t, func(l *listing.Listing) {
+				l.CreatedAt = now(t, 3)
+			}),
+			newListing(t, func(l *listing.Listing) {
+				l.ID = must(uuid.Parse("4516a1c0-efc3-4561-9e97-e749e008aa3f"))
+				l.PublishedAt = ptr(now(t, 4))
+				l.CreatedAt = now(t, 2)
+			}),
+			newListing(t, func(l *listing.Listing) {
+				l.ID = must(uuid.Parse("b7d2b72f-e20b-4f6d-abae-ec90ff36553a"))
+				l.AgentID = agent2
+				l.PublishedAt = ptr(now(t, 4))
+				l.CreatedAt = now(t, 1)
+			}),
+		}
+
+		for i := range listings {
+			err := tx.CreateListing(listings[i])
+			if err != nil {
+				t.Fatalf("failed to save listing: %v", err)
+			}
+		}
+
+		return listings
+	}
+
+	tests := map[string]struct {
+		filter   listing.ListingFilter
+		wantFunc func([]listing.Listing) []listing.Listing
+	}{
+		"ok, all listings, empty slices": {
+			filter: listing.ListingFilter{
+				IDs:         []uuid.UUID{},
+				AgentIDs:    []uuid.UUID{},
+				IsPublished: nil,
+			},
+			wantFunc: func(listings []listing.Listing) []listing.Listing {
+				return listings
+			},
+		},
+		"ok, published": {
+			filter: listing.ListingFilter{
+				IsPublished: ptr(true),
+			},
+			wantFunc: func(listings []listing.Listing) []listing.Listing {
+				return listings[1:3]
+			},
+		},
+		"ok, unpublished": {
+			filter: listing.ListingFilter{
+				IsPublished: ptr(false),
+			},
+			wantFunc: func(listings []listing.Listing) []listing.Listing {
+				return listings[0:1]
+			},
+		},
+		"ok, several by id": {
+			filter: listing.ListingFilter{
+				IDs: []uuid.UUID{
+					must(uuid.Parse("42bf8943-2ffc-43d9-8682-ca8fc4d7cb8e")),
+					must(uuid.Parse("b7d2b72f-e20b-4f6d-abae-ec90ff36553a")),
+				},
+			},
+			wantFunc: func(listings []listing.Listing) []listing.Listing {
+				return []listing.Listing{listings[0], listings[2]}
+			},
+		},
+		"ok, by agent id": {
+			filter: listing.ListingFilter{
+				AgentIDs: []uuid.UUID{agent1},
+			},
+			wantFunc: func(listings []listing.Listing) []listing.Listing {
+				return listings[0:2]
+			},
+		},
+		"ok, combine filters": {
+			filter: listing.ListingFilter{
+				AgentIDs:    []uuid.UUID{agent1},
+				IsPublished: ptr(true),
+			},
+			wantFunc: func(listings []listing.Listing) []listing.Listing {
+				return listings[1:2]
+			},
+		},
+		"ok, no results": {
+			filter: listing.ListingFilter{
+				IDs: []uuid.UUID{uuid.Nil},
+			},
+			wantFunc: func(listings []listing.Listing) []listing.Listing {
+				return []listing.Listing{}
+			},
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			store := storeForTest(t)
+
+			tx, err := store.BeginTx(context.Background())
+			if err != nil {
+				t.Fatalf("failed to begin tx: %v", err)
+			}
+
+			listings := setupListings(t, tx)
+			want := tc.wantFunc(listings)
+
+			// first check if FindListings works on the tx
+			got, err := tx.FindListings(tc.filter)
+			if err != nil {
+				t.Fatalf("failed to find listings: %v", err)
+			}
+
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("got\n%#v\nwant\n%#v\n", got, want)
+			}
+
+			err = tx.Commit()
+			if err != nil {
+				t.Fatalf("failed to commit tx: %v", err)
+			}
+
+			// then, check if FindListings works on the store itself.
+			got, err = store.FindListings(context.Background(), tc.filter)
+			if err != nil {
+				t.Fatalf("failed to find listings: %v", err)
+			}
+
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("got\n%#v\nwant\n%#v\n", got, want)
+			}
+		})
+	}
+}
+
+func inTx(f func(*testing.T, listing.Tx)) func(*testing.T) {
+	return func(t *testing.T) {
+		store := storeForTest(t)
+
+		tx, err := store.BeginTx(context.Background())
+		if err != nil {
+			t.Fatalf("failed to begin tx: %v", err)
+		}
+
+		f(t, tx)
+
+		err = tx.Commit()
+		if err != nil {
+			t.Fatalf("failed to commit tx: %v", err)
+		}
+	}
+}
+
+func now(t *testing.T, i int) time.Time {
+	t.Helper()
+
+	if i > 9 {
+		t.Fatalf("invalid time index: %d", i)
+	}
+
+	ts, err := time.Parse(time.RFC3339, fmt.Sprintf("2021-01-01T00:00:0%dZ", i))
+	if err != nil {
+		t.Fatalf("failed to parse time: %v", err)
+	}
+
+	return ts
+}
+
+func storeForTest(t *testing.T) *db.Store {
+	t.Helper()
+
+	testDB := testdb.RunWhile(t, true)
+	insertAgents(t, testDB, agent1, agent2)
+
+	return db.New(testDB, testDB)
+}
+
+// insertAgents inserts bare users so that listings can reference them.
+func insertAgents(t *testing.T, testDB *sql.DB, ids ...uuid.UUID) {
+	t.Helper()
+
+	for i, id := range ids {
+		_, err := testDB.Exec(`INSERT INTO users (id, email_encrypted, email_blind_index, password_hash, is_active, created_at, updated_at) VALUES (?, ?, ?, ?, ?, ?, ?)`,
+			id, "encrypted", fmt.Sprintf("index-%d", i), "hash", true, now(t, 0), now(t, 0),
+		)
+		if err != nil {
+			t.Fatalf("failed to insert agent: %v", err)
+		}
+	}
+}
+
+func newListing(t *testing.T, modFunc func(*listing.Listing)) listing.Listing {
+	t.Helper()
+
+	l := listing.Listing{
+		ID:      must(uuid.Parse("42bf8943-2ffc-43d9-8682-ca8fc4d7cb8e")),
+		AgentID: agent1,
+		Details: listing.Details{
+			Title:       "Cosy family home",
+			Address:     "Prinsengracht 1, Amsterdam",
+			Description: "A cosy family home with a garden.",
+			Price:       425000,
+		},
+		PublishedAt: nil,
+		CreatedAt:   now(t, 0),
+		UpdatedAt:   now(t, 0),
+	}
+
+	if modFunc != nil {
+		modFunc(&l)
+	}
+
+	return l
+}
+
+func assertFindListing(t *testing.T, tx listing.Tx, want listing.Listing) {
+	t.Helper()
+
+	got, err := tx.FindListings(listing.ListingFilter{IDs: []uuid.UUID{want.ID}})
+	if err != nil {
+		t.Fatalf("failed to find listing: %v", err)
+	}
+
+	if len(got) != 1 {
+		t.Fatalf("expected 1 listing, got %d", len(got))
+	}
+
+	if !reflect.DeepEqual(got[0], want) {
+		t.Errorf("got\n%#v\nwant\n%#v\n", got[0], want)
+	}
+}
+
+func ptr[T any](v T) *T {
+	return &v
+}
+
+func must[T any](v T, err error) T {
+	if err != nil {
+		panic(err)
+	}
+	return v
+}
@@ -0,0 +1,44 @@
+package db
+
+import (
+	"database/sql"
+
+	"github.com/google/uuid"
+	"github.com/willemschots/househunt/internal/listing"
+)
+
+type Tx struct {
+	tx    *sql.Tx
+	store *Store
+}
+
+func (t *Tx) Commit() error {
+	return t.tx.Commit()
+}
+
+func (t *Tx) Rollback() error {
+	return t.tx.Rollback()
+}
+
+// CreateListing creates a listing in the database.
+func (t *Tx) CreateListing(l listing.Listing) error {
+	return insertListing(t.store.newQuery(), t.tx.Exec, l)
+}
+
+// UpdateListing updates a listing in the database.
+// It returns errorz.ErrNotFound if no listing is found.
+func (t *Tx) UpdateListing(l listing.Listing) error {
+	return updateListing(t.store.newQuery(), t.tx.Exec, l)
+}
+
+// DeleteListing deletes a listing from the database.
+// It returns errorz.ErrNotFound if no listing is found.
+func (t *Tx) DeleteListing(id uuid.UUID) error {
+	return deleteListing(t.store.newQuery(), t.tx.Exec, id)
+}
+
+// FindListings queries for listings based on the provided filter.
+// It returns an empty slice if no listings are found.
+func (t *Tx) FindListings(filter listing.ListingFilter) ([]listing.Listing, error) {
+	return selectListings(t.store.newQuery(), t.tx.Query, filter)
+}
@@ -0,0 +1,76 @@
+package listing
+
+import (
+	"errors"
+	"time"
+	"unicode/utf8"
+
+	"github.com/google/uuid"
+	"github.com/willemschots/househunt/internal/errorz"
+)
+
+const (
+	maxTitleRunes       = 120
+	maxAddressRunes     = 200
+	maxDescriptionRunes = 5000
+)
+
+var (
+	ErrInvalidTitle       = errors.New("title must be between 1 and 120 characters")
+	ErrInvalidAddress     = errors.New("address must be between 1 and 200 characters")
+	ErrInvalidDescription = errors.New("description can't be longer than 5000 characters")
+	ErrInvalidPrice       = errors.New("price can't be negative")
+)
+
+// Listing is a property that is listed by an agent.
+type Listing struct {
+	ID      uuid.UUID
+	AgentID uuid.UUID
+	Details
+	// PublishedAt is nil for listings that are not (or no longer) published.
+	PublishedAt *time.Time
+	CreatedAt   time.Time
+	UpdatedAt   time.Time
+}
+
+// IsPublished reports whether the listing is visible to house hunters.
+func (l Listing) IsPublished() bool {
+	return l.PublishedAt != nil
+}
+
+// Details are the parts of a listing that can be edited by an agent.
+type Details struct {
+	Title       string
+	Address     string
+	Description string
+	// Price is the asking price in whole euros.
+	Price int64
+}
+
+// Validate checks if the details are valid. If they are not, an errorz.InvalidInput
+// is returned containing an errorz.Keyed error for every invalid field.
+func (d Details) Validate() error {
+	var invalid errorz.InvalidInput
+
+	if n := utf8.RuneCountInString(d.Title); n < 1 || n > maxTitleRunes {
+		invalid = append(invalid, errorz.Keyed{Key: "title", Err: ErrInvalidTitle})
+	}
+
+	if n := utf8.RuneCountInString(d.Address); n < 1 || n > maxAddressRunes {
+		invalid = append(invalid, errorz.Keyed{Key: "address", Err: ErrInvalidAddress})
+	}
+
+	if utf8.RuneCountInString(d.Description) > maxDescriptionRunes {
+		invalid = append(invalid, errorz.Keyed{Key: "description", Err: ErrInvalidDescription})
+	}
+
+	if d.Price < 0 {
+		invalid = append(invalid, errorz.Keyed{Key: "price", Err: ErrInvalidPrice})
+	}
+
+	if len(invalid) > 0 {
+		return invalid
+	}
+
+	return nil
+}
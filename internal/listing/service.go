@@ -0,0 +1,225 @@
+package listing
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/willemschots/househunt/internal/errorz"
+)
+
+// Service is the type that provides the main rules for
+// managing listings.
+//
+// All methods that modify a listing require the ID of the agent that
+// owns the listing. Listings owned by other agents are reported as
+// not found, this way we don't leak their existence.
+type Service struct {
+	store Store
+
+	// NowFunc is used to get the current time.
+	// Exposed for testing purposes.
+	NowFunc func() time.Time
+}
+
+// NewService creates a new Service.
+func NewService(s Store) *Service {
+	return &Service{
+		store:   s,
+		NowFunc: time.Now,
+	}
+}
+
+// CreateListing creates a new unpublished listing for the agent.
+func (s *Service) CreateListing(ctx context.Context, agentID uuid.UUID, d Details) (Listing, error) {
+	err := d.Validate()
+	if err != nil {
+		return Listing{}, err
+	}
+
+	id, err := uuid.NewRandom()
+	if err != nil {
+		return Listing{}, err
+	}
+
+	now := s.NowFunc()
+	l := Listing{
+		ID:          id,
+		AgentID:     agentID,
+		Details:     d,
+		PublishedAt: nil,
+		CreatedAt:   now,
+		UpdatedAt:   now,
+	}
+
+	err = s.inTx(ctx, func(tx Tx) error {
+		return tx.CreateListing(l)
+	})
+	if err != nil {
+		return Listing{}, err
+	}
+
+	return l, nil
+}
+
+// UpdateListing replaces the details of a listing owned by the agent.
+func (s *Service) UpdateListing(ctx context.Context, agentID, listingID uuid.UUID, d Details) (Listing, error) {
+	err := d.Validate()
+	if err != nil {
+		return Listing{}, err
+	}
+
+	return s.modify(ctx, agentID, listingID, func(l *Listing) {
+		l.Details = d
+	})
+}
+
+// PublishListing makes a listing owned by the agent visible to house hunters.
+// Publishing an already published listing is a no-op.
+func (s *Service) PublishListing(ctx context.Context, agentID, listingID uuid.UUID) (Listing, error) {
+	return s.modify(ctx, agentID, listingID, func(l *Listing) {
+		if l.PublishedAt == nil {
+			l.PublishedAt = ptr(l.UpdatedAt)
+		}
+	})
+}
+
+// UnpublishListing hides a listing owned by the agent from house hunters.
+func (s *Service) UnpublishListing(ctx context.Context, agentID, listingID uuid.UUID) (Listing, error) {
+	return s.modify(ctx, agentID, listingID, func(l *Listing) {
+		l.PublishedAt = nil
+	})
+}
+
+// DeleteListing permanently deletes a listing owned by the agent.
+func (s *Service) DeleteListing(ctx context.Context, agentID, listingID uuid.UUID) error {
+	return s.inTx(ctx, func(tx Tx) error {
+		l, err := findAgentListing(tx, agentID, listingID)
+		if err != nil {
+			return err
+		}
+
+		return tx.DeleteListing(l.ID)
+	})
+}
+
+// AgentListing finds a single listing owned by the agent.
+func (s *Service) AgentListing(ctx context.Context, agentID, listingID uuid.UUID) (Listing, error) {
+	listings, err := s.store.FindListings(ctx, ListingFilter{
+		IDs:      []uuid.UUID{listingID},
+		AgentIDs: []uuid.UUID{agentID},
+	})
+	if err != nil {
+		return Listing{}, err
+	}
+
+	if len(listings) != 1 {
+		return Listing{}, errorz.ErrNotFound
+	}
+
+	return listings[0], nil
+}
+
+// AgentListings finds all listings owned by the agent, published or not.
+func (s *Service) AgentListings(ctx context.Context, agentID uuid.UUID) ([]Listing, error) {
+	return s.store.FindListings(ctx, ListingFilter{
+		AgentIDs: []uuid.UUID{agentID},
+	})
+}
+
+// PublishedListing finds a single listing that is visible to house hunters.
+func (s *Service) PublishedListing(ctx context.Context, listingID uuid.UUID) (Listing, error) {
+	listings, err := s.store.FindListings(ctx, ListingFilter{
+		IDs:         []uuid.UUID{listingID},
+		IsPublished: ptr(true),
+	})
+	if err != nil {
+		return Listing{}, err
+	}
+
+	if len(listings) != 1 {
+		return Listing{}, errorz.ErrNotFound
+	}
+
+	return listings[0], nil
+}
+
+// PublishedListings finds all listings that are visible to house hunters.
+func (s *Service) PublishedListings(ctx context.Context) ([]Listing, error) {
+	return s.store.FindListings(ctx, ListingFilter{
+		IsPublished: ptr(true),
+	})
+}
+
+// modify finds the listing owned by the agent, applies modFunc to it and saves the result.
+func (s *Service) modify(ctx context.Context, agentID, listingID uuid.UUID, modFunc func(l *Listing)) (Listing, error) {
+	now := s.NowFunc()
+
+	var result Listing
+	err := s.inTx(ctx, func(tx Tx) error {
+		l, err := findAgentListing(tx, agentID, listingID)
+		if err != nil {
+			return err
+		}
+
+		l.UpdatedAt = now
+		modFunc(&l)
+
+		err = tx.UpdateListing(l)
+		if err != nil {
+			return err
+		}
+
+		result = l
+		return nil
+	})
+	if err != nil {
+		return Listing{}, err
+	}
+
+	return result, nil
+}
+
+func findAgentListing(tx Tx, agentID, listingID uuid.UUID) (Listing, error) {
+	listings, err := tx.FindListings(ListingFilter{
+		IDs:      []uuid.UUID{listingID},
+		AgentIDs: []uuid.UUID{agentID},
+	})
+	if err != nil {
+		return Listing{}, err
+	}
+
+	if len(listings) != 1 {
+		return Listing{}, errorz.ErrNotFound
+	}
+
+	return listings[0], nil
+}
+
+func (s *Service) inTx(ctx context.Context, f func(tx Tx) error) error {
+	tx, err := s.store.BeginTx(ctx)
+	if err != nil {
+		return err
+	}
+
+	err = f(tx)
+	if err != nil {
+		rBackErr := tx.Rollback()
+		if rBackErr != nil {
+			err = errors.Join(err, rBackErr)
+		}
+		return err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func ptr[T any](v T) *T {
+	return &v
+}
@@ -0,0 +1,457 @@
+package listing_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/willemschots/househunt/internal/db/testdb"
+	"github.com/willemschots/househunt/internal/errorz"
+	"github.com/willemschots/househunt/internal/errorz/testerr"
+	"github.com/willemschots/househunt/internal/listing"
+	"github.com/willemschots/househunt/internal/listing/db"
+)
+
+var (
+	agentID      = must(uuid.Parse("0e61a06e-bbf6-4b87-aaaa-75fee0f38cca"))
+	otherAgentID = must(uuid.Parse("597228ee-afde-4991-b13c-0161325e3930"))
+)
+
+func Test_Service_CreateListing(t *testing.T) {
+	t.Run("ok, create listing", func(t *testing.T) {
+		st := newServiceTest(t)
+
+		l, err := st.svc.CreateListing(context.Background(), agentID, validDetails())
+		if err != nil {
+			t.Fatalf("failed to create listing: %v", err)
+		}
+
+		if l.ID == uuid.Nil || l.AgentID != agentID || l.IsPublished() {
+			t.Fatalf("unexpected listing: %#v", l)
+		}
+
+		got := st.agentListing(agentID, l.ID)
+		if got.Details != validDetails() {
+			t.Fatalf("got details %#v, want %#v", got.Details, validDetails())
+		}
+	})
+
+	invalid := map[string]struct {
+		modFunc func(*listing.Details)
+		wantErr error
+	}{
+		"fail, empty title": {
+			modFunc: func(d *listing.Details) { d.Title = "" },
+			wantErr: listing.ErrInvalidTitle,
+		},
+		"fail, empty address": {
+			modFunc: func(d *listing.Details) { d.Address = "" },
+			wantErr: listing.ErrInvalidAddress,
+		},
+		"fail, negative price": {
+			modFunc: func(d *listing.Details) { d.Price = -1 },
+			wantErr: listing.ErrInvalidPrice,
+		},
+	}
+
+	for name, tc := range invalid {
+		t.Run(name, func(t *testing.T) {
+			st := newServiceTest(t)
+
+			d := validDetails()
+			tc.modFunc(&d)
+
+			_, err := st.svc.CreateListing(context.Background(), agentID, d)
+
+			var invalidInput errorz.InvalidInput
+			if !errors.As(err, &invalidInput) {
+				t.Fatalf("expected error to be of type %T, got %T (via errors.As)", invalidInput, err)
+			}
+
+			if !errors.Is(err, tc.wantErr) {
+				t.Fatalf("expected error %v, got %v (via errors.Is)", tc.wantErr, err)
+			}
+		})
+	}
+
+	for _, tracker := range testerr.NewFailingDeps(testerr.Err, 3) {
+		t.Run("fail, store fails", func(t *testing.T) {
+			st := newServiceTest(t)
+			st.store.tracker = &tracker
+
+			_, err := st.svc.CreateListing(context.Background(), agentID, validDetails())
+			if !errors.Is(err, testerr.Err) {
+				t.Fatalf("expected error %v, got %v (via errors.Is)", testerr.Err, err)
+			}
+		})
+	}
+}
+
+func Test_Service_UpdateListing(t *testing.T) {
+	t.Run("ok, update listing", func(t *testing.T) {
+		st := newServiceTest(t)
+		l := st.createListing(agentID)
+
+		d := listing.Details{
+			Title:       "Spacious loft",
+			Address:     "Keizersgracht 2, Amsterdam",
+			Description: "Lots of light.",
+			Price:       750000,
+		}
+
+		_, err := st.svc.UpdateListing(context.Background(), agentID, l.ID, d)
+		if err != nil {
+			t.Fatalf("failed to update listing: %v", err)
+		}
+
+		got := st.agentListing(agentID, l.ID)
+		if got.Details != d {
+			t.Fatalf("got details %#v, want %#v", got.Details, d)
+		}
+	})
+
+	t.Run("fail, invalid details", func(t *testing.T) {
+		st := newServiceTest(t)
+		l := st.createListing(agentID)
+
+		_, err := st.svc.UpdateListing(context.Background(), agentID, l.ID, listing.Details{})
+
+		var invalidInput errorz.InvalidInput
+		if !errors.As(err, &invalidInput) {
+			t.Fatalf("expected error to be of type %T, got %T (via errors.As)", invalidInput, err)
+		}
+	})
+
+	t.Run("fail, listing of other agent", func(t *testing.T) {
+		st := newServiceTest(t)
+		l := st.createListing(otherAgentID)
+
+		_, err := st.svc.UpdateListing(context.Background(), agentID, l.ID, validDetails())
+		if !errors.Is(err, errorz.ErrNotFound) {
+			t.Fatalf("expected error %v, got %v (via errors.Is)", errorz.ErrNotFound, err)
+		}
+	})
+
+	for _, tracker := range testerr.NewFailingDeps(testerr.Err, 4) {
+		t.Run("fail, store fails", func(t *testing.T) {
+			st := newServiceTest(t)
+			l := st.createListing(agentID)
+			st.store.tracker = &tracker
+
+			_, err := st.svc.UpdateListing(context.Background(), agentID, l.ID, validDetails())
+			if !errors.Is(err, testerr.Err) {
+				t.Fatalf("expected error %v, got %v (via errors.Is)", testerr.Err, err)
+			}
+		})
+	}
+}
+
+func Test_Service_PublishListing(t *testing.T) {
+	t.Run("ok, publish and unpublish listing", func(t *testing.T) {
+		st := newServiceTest(t)
+		l := st.createListing(agentID)
+
+		_, err := st.svc.PublishListing(context.Background(), agentID, l.ID)
+		if err != nil {
+			t.Fatalf("failed to publish listing: %v", err)
+		}
+
+		if !st.agentListing(agentID, l.ID).IsPublished() {
+			t.Fatalf("expected listing to be published")
+		}
+
+		_, err = st.svc.UnpublishListing(context.Background(), agentID, l.ID)
+		if err != nil {
+			t.Fatalf("failed to unpublish listing: %v", err)
+		}
+
+		if st.agentListing(agentID, l.ID).IsPublished() {
+			t.Fatalf("expected listing to be unpublished")
+		}
+	})
+
+	t.Run("ok, publishing twice keeps original publication time", func(t *testing.T) {
+		st := newServiceTest(t)
+		l := st.createListing(agentID)
+
+		first, err := st.svc.PublishListing(context.Background(), agentID, l.ID)
+		if err != nil {
+			t.Fatalf("failed to publish listing: %v", err)
+		}
+
+		st.svc.NowFunc = func() time.Time {
+			return time.Now().Add(time.Hour)
+		}
+
+		second, err := st.svc.PublishListing(context.Background(), agentID, l.ID)
+		if err != nil {
+			t.Fatalf("failed to publish listing: %v", err)
+		}
+
+		if !first.PublishedAt.Equal(*second.PublishedAt) {
+			t.Fatalf("expected publication time %v, got %v", first.PublishedAt, second.PublishedAt)
+		}
+	})
+
+	t.Run("fail, listing of other agent", func(t *testing.T) {
+		st := newServiceTest(t)
+		l := st.createListing(otherAgentID)
+
+		_, err := st.svc.PublishListing(context.Background(), agentID, l.ID)
+		if !errors.Is(err, errorz.ErrNotFound) {
+			t.Fatalf("expected error %v, got %v (via errors.Is)", errorz.ErrNotFound, err)
+		}
+	})
+}
+
+func Test_Service_DeleteListing(t *testing.T) {
+	t.Run("ok, delete listing", func(t *testing.T) {
+		st := newServiceTest(t)
+		l := st.createListing(agentID)
+
+		err := st.svc.DeleteListing(context.Background(), agentID, l.ID)
+		if err != nil {
+			t.Fatalf("failed to delete listing: %v", err)
+		}
+
+		_, err = st.svc.AgentListing(context.Background(), agentID, l.ID)
+		if !errors.Is(err, errorz.ErrNotFound) {
+			t.Fatalf("expected error %v, got %v (via errors.Is)", errorz.ErrNotFound, err)
+		}
+	})
+
+	t.Run("fail, listing of other agent", func(t *testing.T) {
+		st := newServiceTest(t)
+		l := st.createListing(otherAgentID)
+
+		err := st.svc.DeleteListing(context.Background(), agentID, l.ID)
+		if !errors.Is(err, errorz.ErrNotFound) {
+			t.Fatalf("expected error %v, got %v (via errors.Is)", errorz.ErrNotFound, err)
+		}
+
+		// Listing should still exist for the owning agent.
+		st.agentListing(otherAgentID, l.ID)
+	})
+
+	for _, tracker := range testerr.NewFailingDeps(testerr.Err, 4) {
+		t.Run("fail, store fails", func(t *testing.T) {
+			st := newServiceTest(t)
+			l := st.createListing(agentID)
+			st.store.tracker = &tracker
+
+			err := st.svc.DeleteListing(context.Background(), agentID, l.ID)
+			if !errors.Is(err, testerr.Err) {
+				t.Fatalf("expected error %v, got %v (via errors.Is)", testerr.Err, err)
+			}
+		})
+	}
+}
+
+func Test_Service_AgentListings(t *testing.T) {
+	t.Run("ok, only listings of agent", func(t *testing.T) {
+		st := newServiceTest(t)
+		l1 := st.createListing(agentID)
+		_ = st.createListing(otherAgentID)
+		l2 := st.createListing(agentID)
+
+		got, err := st.svc.AgentListings(context.Background(), agentID)
+		if err != nil {
+			t.Fatalf("failed to find listings: %v", err)
+		}
+
+		if len(got) != 2 {
+			t.Fatalf("expected 2 listings, got %d", len(got))
+		}
+
+		for _, l := range got {
+			if l.ID != l1.ID && l.ID != l2.ID {
+				t.Fatalf("unexpected listing %v", l.ID)
+			}
+		}
+	})
+}
+
+func Test_Service_PublishedListings(t *testing.T) {
+	t.Run("ok, only published listings", func(t *testing.T) {
+		st := newServiceTest(t)
+		_ = st.createListing(agentID)
+		l := st.publishListing(st.createListing(otherAgentID))
+
+		got, err := st.svc.PublishedListings(context.Background())
+		if err != nil {
+			t.Fatalf("failed to find listings: %v", err)
+		}
+
+		if len(got) != 1 || got[0].ID != l.ID {
+			t.Fatalf("expected only listing %v, got %#v", l.ID, got)
+		}
+	})
+}
+
+func Test_Service_PublishedListing(t *testing.T) {
+	t.Run("ok, published listing", func(t *testing.T) {
+		st := newServiceTest(t)
+		l := st.publishListing(st.createListing(agentID))
+
+		got, err := st.svc.PublishedListing(context.Background(), l.ID)
+		if err != nil {
+			t.Fatalf("failed to find listing: %v", err)
+		}
+
+		if got.ID != l.ID {
+			t.Fatalf("expected listing %v, got %v", l.ID, got.ID)
+		}
+	})
+
+	t.Run("fail, unpublished listing", func(t *testing.T) {
+		st := newServiceTest(t)
+		l := st.createListing(agentID)
+
+		_, err := st.svc.PublishedListing(context.Background(), l.ID)
+		if !errors.Is(err, errorz.ErrNotFound) {
+			t.Fatalf("expected error %v, got %v (via errors.Is)", errorz.ErrNotFound, err)
+		}
+	})
+}
+
+type svcTest struct {
+	t     *testing.T
+	svc   *listing.Service
+	store *testStore
+}
+
+func newServiceTest(t *testing.T) *svcTest {
+	testDB := testdb.RunWhile(t, true)
+
+	// Listings need to reference existing users.
+	for i, id := range []uuid.UUID{agentID, otherAgentID} {
+		_, err := testDB.Exec(`INSERT INTO users (id, email_encrypted, email_blind_index, password_hash, is_active, created_at, updated_at) VALUES (?, ?, ?, ?, ?, ?, ?)`,
+			id, "encrypted", fmt.Sprintf("index-%d", i), "hash", true, time.Now(), time.Now(),
+		)
+		if err != nil {
+			t.Fatalf("failed to insert agent: %v", err)
+		}
+	}
+
+	test := &svcTest{
+		t: t,
+		store: &testStore{
+			store:   db.New(testDB, testDB),
+			tracker: &testerr.Calltracker{}, // empty call trackers never fail.
+		},
+	}
+
+	test.svc = listing.NewService(test.store)
+
+	return test
+}
+
+func (st *svcTest) createListing(agentID uuid.UUID) listing.Listing {
+	l, err := st.svc.CreateListing(context.Background(), agentID, validDetails())
+	if err != nil {
+		st.t.Fatalf("failed to create listing: %v", err)
+	}
+
+	return l
+}
+
+func (st *svcTest) publishListing(l listing.Listing) listing.Listing {
+	l, err := st.svc.PublishListing(context.Background(), l.AgentID, l.ID)
+	if err != nil {
+		st.t.Fatalf("failed to publish listing: %v", err)
+	}
+
+	return l
+}
+
+func (st *svcTest) agentListing(agentID, listingID uuid.UUID) listing.Listing {
+	l, err := st.svc.AgentListing(context.Background(), agentID, listingID)
+	if err != nil {
+		st.t.Fatalf("failed to find listing: %v", err)
+	}
+
+	return l
+}
+
+func validDetails() listing.Details {
+	return listing.Details{
+		Title:       "Cosy family home",
+		Address:     "Prinsengracht 1, Amsterdam",
+		Description: "A cosy family home with a garden.",
+		Price:       425000,
+	}
+}
+
+// testStore wraps a real store but uses a testerr.Calltracker to
+// possibly fail on certain method calls.
+type testStore struct {
+	store   listing.Store
+	tracker *testerr.Calltracker
+}
+
+func (f *testStore) BeginTx(ctx context.Context) (listing.Tx, error) {
+	return testerr.MaybeFail(f.tracker, func() (listing.Tx, error) {
+		realTx, err := f.store.BeginTx(ctx)
+		return &testTx{
+			store: f,
+			tx:    realTx,
+		}, err
+	})
+}
+
+func (f *testStore) FindListings(ctx context.Context, filter listing.ListingFilter) ([]listing.Listing, error) {
+	return testerr.MaybeFail(f.tracker, func() ([]listing.Listing, error) {
+		return f.store.FindListings(ctx, filter)
+	})
+}
+
+type testTx struct {
+	store *testStore
+	tx    listing.Tx
+}
+
+func (tx *testTx) Commit() error {
+	return testerr.MaybeFailErrFunc(tx.store.tracker, func() error {
+		return tx.tx.Commit()
+	})
+}
+
+func (tx *testTx) Rollback() error {
+	return testerr.MaybeFailErrFunc(tx.store.tracker, func() error {
+		return tx.tx.Rollback()
+	})
+}
+
+func (tx *testTx) CreateListing(l listing.Listing) error {
+	return testerr.MaybeFailErrFunc(tx.store.tracker, func() error {
+		return tx.tx.CreateListing(l)
+	})
+}
+
+func (tx *testTx) UpdateListing(l listing.Listing) error {
+	return testerr.MaybeFailErrFunc(tx.store.tracker, func() error {
+		return tx.tx.UpdateListing(l)
+	})
+}
+
+func (tx *testTx) DeleteListing(id uuid.UUID) error {
+	return testerr.MaybeFailErrFunc(tx.store.tracker, func() error {
+		return tx.tx.DeleteListing(id)
+	})
+}
+
+func (tx *testTx) FindListings(filter listing.ListingFilter) ([]listing.Listing, error) {
+	return testerr.MaybeFail(tx.store.tracker, func() ([]listing.Listing, error) {
+		return tx.tx.FindListings(filter)
+	})
+}
+
+func must[T any](v T, err error) T {
+	if err != nil {
+		panic(err)
+	}
+	return v
+}
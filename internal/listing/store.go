@@ -0,0 +1,36 @@
+package listing
+
+import (
+	"context"
+
+	"github.com/google/uuid"
+)
+
+// ListingFilter is used to filter listings.
+// Returned listings must match all the provided fields.
+// If a field is empty or nil, it's ignored.
+type ListingFilter struct {
+	IDs         []uuid.UUID
+	AgentIDs    []uuid.UUID
+	IsPublished *bool
+}
+
+// Store provides access to the listing store.
+type Store interface {
+	BeginTx(ctx context.Context) (Tx, error)
+
+	FindListings(ctx context.Context, filter ListingFilter) ([]Listing, error)
+}
+
+// Tx is a transaction. If an error occurs on any of the Create/Update/Delete/Find methods,
+// the transaction is considered to have failed and should be rolled back.
+// Tx is not safe for concurrent use.
+type Tx interface {
+	Commit() error
+	Rollback() error
+
+	CreateListing(l Listing) error
+	UpdateListing(l Listing) error
+	DeleteListing(id uuid.UUID) error
+	FindListings(filter ListingFilter) ([]Listing, error)
+}
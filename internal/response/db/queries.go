@@ -0,0 +1,149 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+
+	"github.com/google/uuid"
+	"github.com/willemschots/househunt/internal/db"
+	"github.com/willemschots/househunt/internal/errorz"
+	"github.com/willemschots/househunt/internal/response"
+)
+
+type execFunc func(query string, params ...any) (sql.Result, error)
+type queryFunc func(query string, params ...any) (*sql.Rows, error)
+
+func insertResponse(q db.Query, ef execFunc, r response.Response) error {
+	if r.ID == uuid.Nil {
+		return fmt.Errorf("zero uuid provided: %w", errorz.ErrConstraintViolated)
+	}
+
+	q.Unsafe(`INSERT INTO responses (id, listing_id, hunter_id, message, status, created_at, updated_at) VALUES (`)
+	q.Params(r.ID, r.ListingID, r.HunterID, r.Message, r.Status, r.CreatedAt, r.UpdatedAt)
+	q.Unsafe(`)`)
+
+	s, params, err := q.Get()
+	if err != nil {
+		return err
+	}
+
+	_, err = ef(s, params...)
+	if err != nil {
+		return errorz.MapDBErr(err)
+	}
+
+	return nil
+}
+
+func updateResponse(q db.Query, ef execFunc, r response.Response) error {
+	q.Unsafe(`UPDATE responses SET `)
+
+	q.Unsafe(`listing_id = `)
+	q.Param(r.ListingID)
+
+	q.Unsafe(`, hunter_id = `)
+	q.Param(r.HunterID)
+
+	q.Unsafe(`, message = `)
+	q.Param(r.Message)
+
+	q.Unsafe(`, status = `)
+	q.Param(r.Status)
+
+	q.Unsafe(`, created_at = `)
+	q.Param(r.CreatedAt)
+
+	q.Unsafe(`, updated_at = `)
+	q.Param(r.UpdatedAt)
+
+	q.Unsafe(` WHERE id = `)
+	q.Param(r.ID)
+
+	s, params, err := q.Get()
+	if err != nil {
+		return err
+	}
+
+	result, err := ef(s, params...)
+	if err != nil {
+		return errorz.MapDBErr(err)
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return errorz.MapDBErr(err)
+	}
+
+	if rows == 0 {
+		return fmt.Errorf("response not found: %w", errorz.ErrNotFound)
+	}
+
+	return nil
+}
+
+func selectResponses(q db.Query, qf queryFunc, f response.ResponseFilter) ([]response.Response, error) {
+	q.Unsafe(`SELECT id, listing_id, hunter_id, message, status, created_at, updated_at FROM responses WHERE 1=1 `)
+
+	if len(f.IDs) > 0 {
+		q.Unsafe(`AND id IN (`)
+		q.Params(anySlice(f.IDs)...)
+		q.Unsafe(`) `)
+	}
+
+	if len(f.ListingIDs) > 0 {
+		q.Unsafe(`AND listing_id IN (`)
+		q.Params(anySlice(f.ListingIDs)...)
+		q.Unsafe(`) `)
+	}
+
+	if len(f.HunterIDs) > 0 {
+		q.Unsafe(`AND hunter_id IN (`)
+		q.Params(anySlice(f.HunterIDs)...)
+		q.Unsafe(`) `)
+	}
+
+	if len(f.Statuses) > 0 {
+		q.Unsafe(`AND status IN (`)
+		q.Params(anySlice(f.Statuses)...)
+		q.Unsafe(`) `)
+	}
+
+	q.Unsafe(`ORDER BY created_at DESC, id ASC`)
+
+	s, params, err := q.Get()
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := qf(s, params...)
+	if err != nil {
+		return nil, errorz.MapDBErr(err)
+	}
+
+	defer rows.Close()
+
+	out := make([]response.Response, 0)
+	for rows.Next() {
+		var r response.Response
+		err := rows.Scan(&r.ID, &r.ListingID, &r.HunterID, &r.Message, &r.Status, &r.CreatedAt, &r.UpdatedAt)
+		if err != nil {
+			return nil, errorz.MapDBErr(err)
+		}
+
+		out = append(out, r)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, errorz.MapDBErr(err)
+	}
+
+	return out, nil
+}
+
+func anySlice[T any](s []T) []any {
+	out := make([]any, 0, len(s))
+	for _, v := range s {
+		out = append(out, v)
+	}
+	return out
+}
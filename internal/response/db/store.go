@@ -0,0 +1,49 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/willemschots/househunt/internal/db"
+	"github.com/willemschots/househunt/internal/krypto"
+	"github.com/willemschots/househunt/internal/response"
+)
+
+// Store is responsible for interacting with a database.
+type Store struct {
+	writeDB *sql.DB
+	readDB  *sql.DB
+	// encryptor is used to encrypt the emails that are queued in the outbox.
+	encryptor *krypto.Encryptor
+}
+
+// New creates a new Store.
+func New(writeDB, readDB *sql.DB, encryptor *krypto.Encryptor) *Store {
+	return &Store{
+		writeDB:   writeDB,
+		readDB:    readDB,
+		encryptor: encryptor,
+	}
+}
+
+func (s *Store) newQuery() db.Query {
+	return db.Query{}
+}
+
+// BeginTx starts a new transaction.
+func (s *Store) BeginTx(ctx context.Context) (response.Tx, error) {
+	tx, err := s.writeDB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	return &Tx{
+		tx:    tx,
+		store: s,
+	}, nil
+}
+
+func (s *Store) FindResponses(ctx context.Context, filter response.ResponseFilter) ([]response.Response, error) {
+	return selectResponses(s.newQuery(), func(query string, params ...any) (*sql.Rows, error) {
+		return s.readDB.QueryContext(ctx, query, params...)
+	}, filter)
+}
@@ -0,0 +1,389 @@
+package db_test
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/willemschots/househunt/internal/db/testdb"
+	"github.com/willemschots/househunt/internal/errorz"
+	"github.com/willemschots/househunt/internal/krypto"
+	"github.com/willemschots/househunt/internal/response"
+	"github.com/willemschots/househunt/internal/response/db"
+)
+
+var (
+	agent    = must(uuid.Parse("0e61a06e-bbf6-4b87-aaaa-75fee0f38cca"))
+	hunter1  = must(uuid.Parse("597228ee-afde-4991-b13c-0161325e3930"))
+	hunter2  = must(uuid.Parse("d622d0b0-465c-4c4d-b084-028c9787e1de"))
+	listing1 = must(uuid.Parse("42bf8943-2ffc-43d9-8682-ca8fc4d7cb8e"))
+	listing2 = must(uuid.Parse("b7d2b72f-e20b-4f6d-abae-ec90ff36553a"))
+)
+
+func Test_Tx_CreateResponse(t *testing.T) {
+	t.Run("ok, create response", inTx(func(t *testing.T, tx response.Tx) {
+		r := newResponse(t, nil)
+
+		err := tx.CreateResponse(r)
+		if err != nil {
+			t.Fatalf("failed to save response: %v", err)
+		}
+
+		assertFindResponse(t, tx, r)
+	}))
+
+	t.Run("fail, listing foreign key does not exist", inTx(func(t *testing.T, tx response.Tx) {
+		r := newResponse(t, func(r *response.Response) {
+			r.ListingID = must(uuid.Parse("4516a1c0-efc3-4561-9e97-e749e008aa3f"))
+		})
+
+		err := tx.CreateResponse(r)
+		if !errors.Is(err, errorz.ErrConstraintViolated) {
+			t.Fatalf("expected errors to be %v got %v (via errors.Is)", errorz.ErrConstraintViolated, err)
+		}
+	}))
+
+	t.Run("fail, zero ID", inTx(func(t *testing.T, tx response.Tx) {
+		r := newResponse(t, func(r *response.Response) {
+			r.ID = uuid.Nil
+		})
+
+		err := tx.CreateResponse(r)
+		if !errors.Is(err, errorz.ErrConstraintViolated) {
+			t.Fatalf("expected errors to be %v got %v (via errors.Is)", errorz.ErrConstraintViolated, err)
+		}
+	}))
+}
+
+func Test_Tx_UpdateResponse(t *testing.T) {
+	setup := func(t *testing.T, tx response.Tx) response.Response {
+		r := newResponse(t, nil)
+		err := tx.CreateResponse(r)
+		if err != nil {
+			t.Fatalf("failed to save response: %v", err)
+		}
+
+		return r
+	}
+
+	t.Run("ok, update response", inTx(func(t *testing.T, tx response.Tx) {
+		r := setup(t, tx)
+
+		// Update all fields that can be modified.
+		r.ListingID = listing2
+		r.HunterID = hunter2
+		r.Message = "Is the garden south facing?"
+		r.Status = response.StatusShortlisted
+		r.CreatedAt = now(t, 1)
+		r.UpdatedAt = now(t, 2)
+
+		err := tx.UpdateResponse(r)
+		if err != nil {
+			t.Fatalf("failed to save response: %v", err)
+		}
+
+		assertFindResponse(t, tx, r)
+	}))
+
+	t.Run("fail, not found", inTx(func(t *testing.T, tx response.Tx) {
+		r := setup(t, tx)
+
+		r.ID = must(uuid.Parse("4516a1c0-efc3-4561-9e97-e749e008aa3f"))
+
+		err := tx.UpdateResponse(r)
+		if !errors.Is(err, errorz.ErrNotFound) {
+			t.Fatalf("expected errors to be %v got %v (via errors.Is)", errorz.ErrNotFound, err)
+		}
+	}))
+}
+
+func Test_Tx_DeleteListingCascades(t *testing.T) {
+	store, testDB := storeForTest(t)
+
+	tx, err := store.BeginTx(context.Background())
+	if err != nil {
+		t.Fatalf("failed to begin tx: %v", err)
+	}
+
+	err = tx.CreateResponse(newResponse(t, nil))
+	if err != nil {
+		t.Fatalf("failed to save response: %v", err)
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		t.Fatalf("failed to commit tx: %v", err)
+	}
+
+	_, err = testDB.Exec(`DELETE FROM listings WHERE id = ?`, listing1)
+	if err != nil {
+		t.Fatalf("failed to delete listing: %v", err)
+	}
+
+	got, err := store.FindResponses(context.Background(), response.ResponseFilter{})
+	if err != nil {
+		t.Fatalf("failed to find responses: %v", err)
+	}
+
+	if len(got) != 0 {
+		t.Fatalf("expected no responses, got %d", len(got))
+	}
+}
+
+func Test_Tx_FindResponses(t *testing.T) {
+	setupResponses := func(t *testing.T, tx response.Tx) []response.Response {
+		// Responses are ordered by creation time, newest first.
+		responses := []response.Response{
+			newResponse(t, func(r *response.Response) {
+				r.CreatedAt = now(t, 3)
+			}),
+			newResponse(t, func(r *response.Response) {
+				r.ID = must(uuid.Parse("4516a1c0-efc3-4561-9e97-e749e008aa3f"))
+				r.HunterID = hunter2
+				r.Status = response.StatusDeclined
+				r.CreatedAt = now(t, 2)
+			}),
+			newResponse(t, func(r *response.Response) {
+				r.ID = must(uuid.Parse("c4a3d7c4-8e6e-4a3b-bb8a-2f7c3e1b5f6d"))
+				r.ListingID = listing2
+				r.Status = response.StatusContacted
+				r.CreatedAt = now(t, 1)
+			}),
+		}
+
+		for i := range responses {
+			err := tx.CreateResponse(responses[i])
+			if err != nil {
+				t.Fatalf("failed to save response: %v", err)
+			}
+		}
+
+		return responses
+	}
+
+	tests := map[string]struct {
+		filter   response.ResponseFilter
+		wantFunc func([]response.Response) []response.Response
+	}{
+		"ok, all responses, empty slices": {
+			filter: response.ResponseFilter{
+				IDs:        []uuid.UUID{},
+				ListingIDs: []uuid.UUID{},
+				HunterIDs:  []uuid.UUID{},
+				Statuses:   []response.Status{},
+			},
+			wantFunc: func(responses []response.Response) []response.Response {
+				return responses
+			},
+		},
+		"ok, several by id": {
+			filter: response.ResponseFilter{
+				IDs: []uuid.UUID{
+					must(uuid.Parse("8a1b2c3d-4e5f-4a6b-8c7d-9e0f1a2b3c4d")),
+					must(uuid.Parse("c4a3d7c4-8e6e-4a3b-bb8a-2f7c3e1b5f6d")),
+				},
+			},
+			wantFunc: func(responses []response.Response) []response.Response {
+				return []response.Response{responses[0], responses[2]}
+			},
+		},
+		"ok, by listing id": {
+			filter: response.ResponseFilter{
+				ListingIDs: []uuid.UUID{listing1},
+			},
+			wantFunc: func(responses []response.Response) []response.Response {
+				return responses[0:2]
+			},
+		},
+		"ok, by hunter id": {
+			filter: response.ResponseFilter{
+				HunterIDs: []uuid.UUID{hunter2},
+			},
+			wantFunc: func(responses []response.Response) []response.Response {
+				return responses[1:2]
+			},
+		},
+		"ok, by status": {
+			filter: response.ResponseFilter{
+				Statuses: []response.Status{response.StatusNew, response.StatusContacted},
+			},
+			wantFunc: func(responses []response.Response) []response.Response {
+				return []response.Response{responses[0], responses[2]}
+			},
+		},
+		"ok, combine filters": {
+			filter: response.ResponseFilter{
+				ListingIDs: []uuid.UUID{listing1},
+				HunterIDs:  []uuid.UUID{hunter1},
+			},
+			wantFunc: func(responses []response.Response) []response.Response {
+				return responses[0:1]
+			},
+		},
+		"ok, no results": {
+			filter: response.ResponseFilter{
+				IDs: []uuid.UUID{uuid.Nil},
+			},
+			wantFunc: func(responses []response.Response) []response.Response {
+				return []response.Response{}
+			},
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			store, _ := storeForTest(t)
+
+			tx, err := store.BeginTx(context.Background())
+			if err != nil {
+				t.Fatalf("failed to begin tx: %v", err)
+			}
+
+			responses := setupResponses(t, tx)
+			want := tc.wantFunc(responses)
+
+			// first check if FindResponses works on the tx
+			got, err := tx.FindResponses(tc.filter)
+			if err != nil {
+				t.Fatalf("failed to find responses: %v", err)
+			}
+
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("got\n%#v\nwant\n%#v\n", got, want)
+			}
+
+			err = tx.Commit()
+			if err != nil {
+				t.Fatalf("failed to commit tx: %v", err)
+			}
+
+			// then, check if FindResponses works on the store itself.
+			got, err = store.FindResponses(context.Background(), tc.filter)
+			if err != nil {
+				t.Fatalf("failed to find responses: %v", err)
+			}
+
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("got\n%#v\nwant\n%#v\n", got, want)
+			}
+		})
+	}
+}
+
+func inTx(f func(*testing.T, response.Tx)) func(*testing.T) {
+	return func(t *testing.T) {
+		store, _ := storeForTest(t)
+
+		tx, err := store.BeginTx(context.Background())
+		if err != nil {
+			t.Fatalf("failed to begin tx: %v", err)
+		}
+
+		f(t, tx)
+
+		err = tx.Commit()
+		if err != nil {
+			t.Fatalf("failed to commit tx: %v", err)
+		}
+	}
+}
+
+func now(t *testing.T, i int) time.Time {
+	t.Helper()
+
+	if i > 9 {
+		t.Fatalf("invalid time index: %d", i)
+	}
+
+	ts, err := time.Parse(time.RFC3339, fmt.Sprintf("2021-01-01T00:00:0%dZ", i))
+	if err != nil {
+		t.Fatalf("failed to parse time: %v", err)
+	}
+
+	return ts
+}
+
+func storeForTest(t *testing.T) (*db.Store, *sql.DB) {
+	t.Helper()
+
+	encryptor := must(krypto.NewEncryptor([]krypto.Key{
+		must(krypto.ParseKey("2b671594b775f371eab4050b4d58326682df6b1a6cc2e886717b1a26b4d6c45d")),
+	}))
+
+	testDB := testdb.RunWhile(t, true)
+	insertListings(t, testDB)
+
+	return db.New(testDB, testDB, encryptor), testDB
+}
+
+// insertListings inserts bare users and listings so that responses can reference them.
+func insertListings(t *testing.T, testDB *sql.DB) {
+	t.Helper()
+
+	for i, id := range []uuid.UUID{agent, hunter1, hunter2} {
+		_, err := testDB.Exec(`INSERT INTO users (id, email_encrypted, email_blind_index, password_hash, is_active, created_at, updated_at) VALUES (?, ?, ?, ?, ?, ?, ?)`,
+			id, "encrypted", fmt.Sprintf("index-%d", i), "hash", true, now(t, 0), now(t, 0),
+		)
+		if err != nil {
+			t.Fatalf("failed to insert user: %v", err)
+		}
+	}
+
+	for _, id := range []uuid.UUID{listing1, listing2} {
+		_, err := testDB.Exec(`INSERT INTO listings (id, agent_id, title, address, description, price, published_at, created_at, updated_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+			id, agent, "title", "address", "description", 1, now(t, 0), now(t, 0), now(t, 0),
+		)
+		if err != nil {
+			t.Fatalf("failed to insert listing: %v", err)
+		}
+	}
+}
+
+func newResponse(t *testing.T, modFunc func(*response.Response)) response.Response {
+	t.Helper()
+
+	r := response.Response{
+		ID:        must(uuid.Parse("8a1b2c3d-4e5f-4a6b-8c7d-9e0f1a2b3c4d")),
+		ListingID: listing1,
+		HunterID:  hunter1,
+		Message:   "When can I view the house?",
+		Status:    response.StatusNew,
+		CreatedAt: now(t, 0),
+		UpdatedAt: now(t, 0),
+	}
+
+	if modFunc != nil {
+		modFunc(&r)
+	}
+
+	return r
+}
+
+func assertFindResponse(t *testing.T, tx response.Tx, want response.Response) {
+	t.Helper()
+
+	got, err := tx.FindResponses(response.ResponseFilter{IDs: []uuid.UUID{want.ID}})
+	if err != nil {
+		t.Fatalf("failed to find response: %v", err)
+	}
+
+	if len(got) != 1 {
+		t.Fatalf("expected 1 response, got %d", len(got))
+	}
+
+	if !reflect.DeepEqual(got[0], want) {
+		t.Errorf("got\n%#v\nwant\n%#v\n", got[0], want)
+	}
+}
+
+func must[T any](v T, err error) T {
+	if err != nil {
+		panic(err)
+	}
+	return v
+}
@@ -0,0 +1,44 @@
+package db
+
+import (
+	"database/sql"
+
+	"github.com/willemschots/househunt/internal/email/outbox"
+	outboxdb "github.com/willemschots/househunt/internal/email/outbox/db"
+	"github.com/willemschots/househunt/internal/response"
+)
+
+type Tx struct {
+	tx    *sql.Tx
+	store *Store
+}
+
+func (t *Tx) Commit() error {
+	return t.tx.Commit()
+}
+
+func (t *Tx) Rollback() error {
+	return t.tx.Rollback()
+}
+
+// CreateResponse creates a response in the database.
+func (t *Tx) CreateResponse(r response.Response) error {
+	return insertResponse(t.store.newQuery(), t.tx.Exec, r)
+}
+
+// UpdateResponse updates a response in the database.
+// It returns errorz.ErrNotFound if no response is found.
+func (t *Tx) UpdateResponse(r response.Response) error {
+	return updateResponse(t.store.newQuery(), t.tx.Exec, r)
+}
+
+// FindResponses queries for responses based on the provided filter.
+// It returns an empty slice if no responses are found.
+func (t *Tx) FindResponses(filter response.ResponseFilter) ([]response.Response, error) {
+	return selectResponses(t.store.newQuery(), t.tx.Query, filter)
+}
+
+// CreateOutboxMessage queues an email message in the outbox.
+func (t *Tx) CreateOutboxMessage(m outbox.Message) error {
+	return outboxdb.CreateMessage(t.tx, t.store.encryptor, m)
+}
@@ -0,0 +1,118 @@
+package response
+
+import (
+	"errors"
+	"time"
+	"unicode/utf8"
+
+	"github.com/google/uuid"
+	"github.com/willemschots/househunt/internal/errorz"
+	"github.com/willemschots/househunt/internal/listing"
+)
+
+const maxMessageRunes = 2000
+
+var (
+	ErrInvalidMessage = errors.New("message must be between 1 and 2000 characters")
+	ErrInvalidStatus  = errors.New("invalid status")
+)
+
+// Status is the status an agent assigned to a response.
+type Status string
+
+const (
+	// StatusNew is the status of responses that were not yet triaged by the agent.
+	StatusNew         Status = "new"
+	StatusShortlisted Status = "shortlisted"
+	StatusDeclined    Status = "declined"
+	StatusContacted   Status = "contacted"
+)
+
+// ParseStatus parses a status from a string.
+func ParseStatus(raw string) (Status, error) {
+	switch s := Status(raw); s {
+	case StatusNew, StatusShortlisted, StatusDeclined, StatusContacted:
+		return s, nil
+	}
+
+	return "", ErrInvalidStatus
+}
+
+// IsTriaged reports whether the status is one an agent can assign to a response.
+func (s Status) IsTriaged() bool {
+	return s == StatusShortlisted || s == StatusDeclined || s == StatusContacted
+}
+
+func (s *Status) UnmarshalText(text []byte) error {
+	status, err := ParseStatus(string(text))
+	if err != nil {
+		return err
+	}
+
+	*s = status
+
+	return nil
+}
+
+// Response is a message a house hunter sent in response to a listing.
+type Response struct {
+	ID        uuid.UUID
+	ListingID uuid.UUID
+	HunterID  uuid.UUID
+	Message   string
+	Status    Status
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+// Submission is the input of a house hunter responding to a listing.
+type Submission struct {
+	ListingID uuid.UUID
+	Message   string
+}
+
+// Validate checks if the submission is valid. If it's not, an errorz.InvalidInput
+// is returned containing an errorz.Keyed error for every invalid field.
+func (s Submission) Validate() error {
+	var invalid errorz.InvalidInput
+
+	if n := utf8.RuneCountInString(s.Message); n < 1 || n > maxMessageRunes {
+		invalid = append(invalid, errorz.Keyed{Key: "message", Err: ErrInvalidMessage})
+	}
+
+	if len(invalid) > 0 {
+		return invalid
+	}
+
+	return nil
+}
+
+// StatusUpdate is the input of an agent triaging a response.
+type StatusUpdate struct {
+	ID     uuid.UUID
+	Status Status
+}
+
+// Inbox contains all responses to a single listing.
+type Inbox struct {
+	Listing   listing.Listing
+	Responses []Response
+}
+
+// Count returns the number of responses in the inbox that have the provided status.
+func (i Inbox) Count(s Status) int {
+	n := 0
+	for _, r := range i.Responses {
+		if r.Status == s {
+			n++
+		}
+	}
+	return n
+}
+
+// StatusChange is the data that is passed to the email that notifies
+// a house hunter of a status change.
+type StatusChange struct {
+	Listing  listing.Listing
+	Response Response
+}
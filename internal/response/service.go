@@ -0,0 +1,273 @@
+package response
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/willemschots/househunt/internal/auth"
+	"github.com/willemschots/househunt/internal/email"
+	"github.com/willemschots/househunt/internal/email/outbox"
+	"github.com/willemschots/househunt/internal/errorz"
+	"github.com/willemschots/househunt/internal/listing"
+)
+
+// Listings provides access to listings, it's implemented by *listing.Service.
+type Listings interface {
+	PublishedListing(ctx context.Context, listingID uuid.UUID) (listing.Listing, error)
+	AgentListing(ctx context.Context, agentID, listingID uuid.UUID) (listing.Listing, error)
+	AgentListings(ctx context.Context, agentID uuid.UUID) ([]listing.Listing, error)
+}
+
+// Users provides access to users, it's implemented by *auth.Service.
+type Users interface {
+	ActiveUser(ctx context.Context, userID uuid.UUID) (auth.User, error)
+}
+
+// EmailRenderer is used to render templated emails. Rendered emails
+// are queued in the outbox as part of the transaction that triggered them.
+type EmailRenderer interface {
+	Render(template string, to email.Address, data any) (email.Message, error)
+}
+
+// Service is the type that provides the main rules for
+// responding to listings and triaging those responses.
+//
+// Responses are only visible to the agent that owns the listing,
+// responses to listings of other agents are reported as not found.
+type Service struct {
+	store         Store
+	listings      Listings
+	users         Users
+	emailRenderer EmailRenderer
+
+	// NowFunc is used to get the current time.
+	// Exposed for testing purposes.
+	NowFunc func() time.Time
+}
+
+// NewService creates a new Service.
+func NewService(s Store, listings Listings, users Users, emailRenderer EmailRenderer) *Service {
+	return &Service{
+		store:         s,
+		listings:      listings,
+		users:         users,
+		emailRenderer: emailRenderer,
+		NowFunc:       time.Now,
+	}
+}
+
+// Respond records the response of a house hunter to a published listing.
+func (s *Service) Respond(ctx context.Context, hunterID uuid.UUID, sub Submission) (Response, error) {
+	err := sub.Validate()
+	if err != nil {
+		return Response{}, err
+	}
+
+	// Only published listings can be responded to.
+	_, err = s.listings.PublishedListing(ctx, sub.ListingID)
+	if err != nil {
+		return Response{}, err
+	}
+
+	id, err := uuid.NewRandom()
+	if err != nil {
+		return Response{}, err
+	}
+
+	now := s.NowFunc()
+	r := Response{
+		ID:        id,
+		ListingID: sub.ListingID,
+		HunterID:  hunterID,
+		Message:   sub.Message,
+		Status:    StatusNew,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+
+	err = s.inTx(ctx, func(tx Tx) error {
+		return tx.CreateResponse(r)
+	})
+	if err != nil {
+		return Response{}, err
+	}
+
+	return r, nil
+}
+
+// HunterResponses returns all responses a house hunter sent.
+func (s *Service) HunterResponses(ctx context.Context, hunterID uuid.UUID) ([]Response, error) {
+	return s.store.FindResponses(ctx, ResponseFilter{
+		HunterIDs: []uuid.UUID{hunterID},
+	})
+}
+
+// AgentInboxes returns an inbox for every listing owned by the agent.
+func (s *Service) AgentInboxes(ctx context.Context, agentID uuid.UUID) ([]Inbox, error) {
+	listings, err := s.listings.AgentListings(ctx, agentID)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(listings) == 0 {
+		return []Inbox{}, nil
+	}
+
+	listingIDs := make([]uuid.UUID, 0, len(listings))
+	for _, l := range listings {
+		listingIDs = append(listingIDs, l.ID)
+	}
+
+	responses, err := s.store.FindResponses(ctx, ResponseFilter{
+		ListingIDs: listingIDs,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	byListing := make(map[uuid.UUID][]Response, len(listings))
+	for _, r := range responses {
+		byListing[r.ListingID] = append(byListing[r.ListingID], r)
+	}
+
+	inboxes := make([]Inbox, 0, len(listings))
+	for _, l := range listings {
+		inboxes = append(inboxes, Inbox{
+			Listing:   l,
+			Responses: byListing[l.ID],
+		})
+	}
+
+	return inboxes, nil
+}
+
+// AgentInbox returns the inbox of a single listing owned by the agent.
+func (s *Service) AgentInbox(ctx context.Context, agentID, listingID uuid.UUID) (Inbox, error) {
+	l, err := s.listings.AgentListing(ctx, agentID, listingID)
+	if err != nil {
+		return Inbox{}, err
+	}
+
+	responses, err := s.store.FindResponses(ctx, ResponseFilter{
+		ListingIDs: []uuid.UUID{l.ID},
+	})
+	if err != nil {
+		return Inbox{}, err
+	}
+
+	return Inbox{
+		Listing:   l,
+		Responses: responses,
+	}, nil
+}
+
+// UpdateStatus triages a response to a listing owned by the agent. The house
+// hunter is notified by email when the status changes.
+func (s *Service) UpdateStatus(ctx context.Context, agentID uuid.UUID, u StatusUpdate) (Response, error) {
+	if !u.Status.IsTriaged() {
+		return Response{}, errorz.InvalidInput{errorz.Keyed{Key: "status", Err: ErrInvalidStatus}}
+	}
+
+	responses, err := s.store.FindResponses(ctx, ResponseFilter{
+		IDs: []uuid.UUID{u.ID},
+	})
+	if err != nil {
+		return Response{}, err
+	}
+
+	if len(responses) != 1 {
+		return Response{}, errorz.ErrNotFound
+	}
+
+	// Verify the response belongs to a listing of this agent.
+	l, err := s.listings.AgentListing(ctx, agentID, responses[0].ListingID)
+	if err != nil {
+		return Response{}, err
+	}
+
+	hunter, err := s.users.ActiveUser(ctx, responses[0].HunterID)
+	if err != nil {
+		return Response{}, err
+	}
+
+	now := s.NowFunc()
+
+	var result Response
+	err = s.inTx(ctx, func(tx Tx) error {
+		responses, err := tx.FindResponses(ResponseFilter{
+			IDs:        []uuid.UUID{u.ID},
+			ListingIDs: []uuid.UUID{l.ID},
+		})
+		if err != nil {
+			return err
+		}
+
+		if len(responses) != 1 {
+			return errorz.ErrNotFound
+		}
+
+		result = responses[0]
+		if result.Status == u.Status {
+			return nil
+		}
+
+		result.Status = u.Status
+		result.UpdatedAt = now
+
+		err = tx.UpdateResponse(result)
+		if err != nil {
+			return err
+		}
+
+		// Queue the email, it will only be sent if the new status is stored.
+		return s.queueEmail(tx, "response-status", hunter.Email, StatusChange{
+			Listing:  l,
+			Response: result,
+		}, now)
+	})
+	if err != nil {
+		return Response{}, err
+	}
+
+	return result, nil
+}
+
+// queueEmail renders an email and adds it to the outbox as part of tx.
+func (s *Service) queueEmail(tx Tx, template string, to email.Address, data any, now time.Time) error {
+	msg, err := s.emailRenderer.Render(template, to, data)
+	if err != nil {
+		return err
+	}
+
+	m, err := outbox.NewMessage(msg, now)
+	if err != nil {
+		return err
+	}
+
+	return tx.CreateOutboxMessage(m)
+}
+
+func (s *Service) inTx(ctx context.Context, f func(tx Tx) error) error {
+	tx, err := s.store.BeginTx(ctx)
+	if err != nil {
+		return err
+	}
+
+	err = f(tx)
+	if err != nil {
+		rBackErr := tx.Rollback()
+		if rBackErr != nil {
+			err = errors.Join(err, rBackErr)
+		}
+		return err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
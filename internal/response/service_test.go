@@ -0,0 +1,591 @@
+package response_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/willemschots/househunt/internal/auth"
+	"github.com/willemschots/househunt/internal/db/testdb"
+	"github.com/willemschots/househunt/internal/email"
+	"github.com/willemschots/househunt/internal/email/outbox"
+	outboxdb "github.com/willemschots/househunt/internal/email/outbox/db"
+	"github.com/willemschots/househunt/internal/errorz"
+	"github.com/willemschots/househunt/internal/errorz/testerr"
+	"github.com/willemschots/househunt/internal/krypto"
+	"github.com/willemschots/househunt/internal/listing"
+	listingdb "github.com/willemschots/househunt/internal/listing/db"
+	"github.com/willemschots/househunt/internal/response"
+	"github.com/willemschots/househunt/internal/response/db"
+)
+
+var (
+	agentID      = must(uuid.Parse("0e61a06e-bbf6-4b87-aaaa-75fee0f38cca"))
+	otherAgentID = must(uuid.Parse("597228ee-afde-4991-b13c-0161325e3930"))
+	hunterID     = must(uuid.Parse("d622d0b0-465c-4c4d-b084-028c9787e1de"))
+	hunterEmail  = must(email.ParseAddress("hunter@example.com"))
+)
+
+func Test_Service_Respond(t *testing.T) {
+	t.Run("ok, respond to published listing", func(t *testing.T) {
+		st := newServiceTest(t)
+		l := st.createListing(agentID, true)
+
+		r, err := st.svc.Respond(context.Background(), hunterID, validSubmission(l.ID))
+		if err != nil {
+			t.Fatalf("failed to respond: %v", err)
+		}
+
+		if r.ID == uuid.Nil || r.ListingID != l.ID || r.HunterID != hunterID || r.Status != response.StatusNew {
+			t.Fatalf("unexpected response: %#v", r)
+		}
+
+		inbox := st.agentInbox(agentID, l.ID)
+		if len(inbox.Responses) != 1 || inbox.Responses[0].ID != r.ID {
+			t.Fatalf("expected response %v in inbox, got %#v", r.ID, inbox.Responses)
+		}
+	})
+
+	t.Run("fail, unpublished listing", func(t *testing.T) {
+		st := newServiceTest(t)
+		l := st.createListing(agentID, false)
+
+		_, err := st.svc.Respond(context.Background(), hunterID, validSubmission(l.ID))
+		if !errors.Is(err, errorz.ErrNotFound) {
+			t.Fatalf("expected error %v, got %v (via errors.Is)", errorz.ErrNotFound, err)
+		}
+	})
+
+	t.Run("fail, empty message", func(t *testing.T) {
+		st := newServiceTest(t)
+		l := st.createListing(agentID, true)
+
+		sub := validSubmission(l.ID)
+		sub.Message = ""
+
+		_, err := st.svc.Respond(context.Background(), hunterID, sub)
+
+		var invalidInput errorz.InvalidInput
+		if !errors.As(err, &invalidInput) {
+			t.Fatalf("expected error to be of type %T, got %T (via errors.As)", invalidInput, err)
+		}
+
+		if !errors.Is(err, response.ErrInvalidMessage) {
+			t.Fatalf("expected error %v, got %v (via errors.Is)", response.ErrInvalidMessage, err)
+		}
+	})
+
+	for _, tracker := range testerr.NewFailingDeps(testerr.Err, 3) {
+		t.Run("fail, store fails", func(t *testing.T) {
+			st := newServiceTest(t)
+			l := st.createListing(agentID, true)
+			st.store.tracker = &tracker
+
+			_, err := st.svc.Respond(context.Background(), hunterID, validSubmission(l.ID))
+			if !errors.Is(err, testerr.Err) {
+				t.Fatalf("expected error %v, got %v (via errors.Is)", testerr.Err, err)
+			}
+		})
+	}
+}
+
+func Test_Service_HunterResponses(t *testing.T) {
+	t.Run("ok, responses of hunter", func(t *testing.T) {
+		st := newServiceTest(t)
+		l1 := st.createListing(agentID, true)
+		l2 := st.createListing(otherAgentID, true)
+
+		r1 := st.respond(l1.ID)
+		r2 := st.respond(l2.ID)
+
+		responses, err := st.svc.HunterResponses(context.Background(), hunterID)
+		if err != nil {
+			t.Fatalf("failed to get responses: %v", err)
+		}
+
+		if len(responses) != 2 {
+			t.Fatalf("expected 2 responses, got %d", len(responses))
+		}
+
+		for _, r := range responses {
+			if r.ID != r1.ID && r.ID != r2.ID {
+				t.Fatalf("unexpected response %#v", r)
+			}
+		}
+	})
+
+	t.Run("ok, no responses", func(t *testing.T) {
+		st := newServiceTest(t)
+
+		responses, err := st.svc.HunterResponses(context.Background(), hunterID)
+		if err != nil {
+			t.Fatalf("failed to get responses: %v", err)
+		}
+
+		if len(responses) != 0 {
+			t.Fatalf("expected no responses, got %d", len(responses))
+		}
+	})
+}
+
+func Test_Service_AgentInboxes(t *testing.T) {
+	t.Run("ok, inbox per listing of agent", func(t *testing.T) {
+		st := newServiceTest(t)
+		l1 := st.createListing(agentID, true)
+		l2 := st.createListing(agentID, false)
+		other := st.createListing(otherAgentID, true)
+
+		r1 := st.respond(l1.ID)
+		_ = st.respond(other.ID)
+
+		inboxes, err := st.svc.AgentInboxes(context.Background(), agentID)
+		if err != nil {
+			t.Fatalf("failed to get inboxes: %v", err)
+		}
+
+		if len(inboxes) != 2 {
+			t.Fatalf("expected 2 inboxes, got %d", len(inboxes))
+		}
+
+		for _, inbox := range inboxes {
+			switch inbox.Listing.ID {
+			case l1.ID:
+				if len(inbox.Responses) != 1 || inbox.Responses[0].ID != r1.ID {
+					t.Fatalf("expected response %v in inbox, got %#v", r1.ID, inbox.Responses)
+				}
+				if inbox.Count(response.StatusNew) != 1 {
+					t.Fatalf("expected 1 new response, got %d", inbox.Count(response.StatusNew))
+				}
+			case l2.ID:
+				if len(inbox.Responses) != 0 {
+					t.Fatalf("expected no responses, got %#v", inbox.Responses)
+				}
+			default:
+				t.Fatalf("unexpected inbox for listing %v", inbox.Listing.ID)
+			}
+		}
+	})
+
+	t.Run("ok, no listings", func(t *testing.T) {
+		st := newServiceTest(t)
+
+		inboxes, err := st.svc.AgentInboxes(context.Background(), agentID)
+		if err != nil {
+			t.Fatalf("failed to get inboxes: %v", err)
+		}
+
+		if len(inboxes) != 0 {
+			t.Fatalf("expected no inboxes, got %d", len(inboxes))
+		}
+	})
+}
+
+func Test_Service_AgentInbox(t *testing.T) {
+	t.Run("fail, listing of other agent", func(t *testing.T) {
+		st := newServiceTest(t)
+		l := st.createListing(otherAgentID, true)
+		_ = st.respond(l.ID)
+
+		_, err := st.svc.AgentInbox(context.Background(), agentID, l.ID)
+		if !errors.Is(err, errorz.ErrNotFound) {
+			t.Fatalf("expected error %v, got %v (via errors.Is)", errorz.ErrNotFound, err)
+		}
+	})
+}
+
+func Test_Service_UpdateStatus(t *testing.T) {
+	t.Run("ok, update status and notify hunter", func(t *testing.T) {
+		st := newServiceTest(t)
+		l := st.createListing(agentID, true)
+		r := st.respond(l.ID)
+
+		got, err := st.svc.UpdateStatus(context.Background(), agentID, response.StatusUpdate{
+			ID:     r.ID,
+			Status: response.StatusShortlisted,
+		})
+		if err != nil {
+			t.Fatalf("failed to update status: %v", err)
+		}
+
+		if got.Status != response.StatusShortlisted {
+			t.Fatalf("expected status %v, got %v", response.StatusShortlisted, got.Status)
+		}
+
+		inbox := st.agentInbox(agentID, l.ID)
+		if inbox.Responses[0].Status != response.StatusShortlisted {
+			t.Fatalf("expected stored status %v, got %v", response.StatusShortlisted, inbox.Responses[0].Status)
+		}
+
+		emails := st.emailer.queuedEmails(t)
+		if len(emails) != 1 {
+			t.Fatalf("expected 1 email, got %d", len(emails))
+		}
+
+		e := emails[0]
+		if e.template != "response-status" || e.recipient != hunterEmail {
+			t.Fatalf("unexpected email: %#v", e)
+		}
+
+		change, ok := e.data.(response.StatusChange)
+		if !ok {
+			t.Fatalf("unexpected data type: %T", e.data)
+		}
+
+		if change.Listing.ID != l.ID || change.Response.Status != response.StatusShortlisted {
+			t.Fatalf("unexpected email data: %#v", change)
+		}
+	})
+
+	t.Run("ok, same status does not notify hunter", func(t *testing.T) {
+		st := newServiceTest(t)
+		l := st.createListing(agentID, true)
+		r := st.respond(l.ID)
+
+		for range 2 {
+			_, err := st.svc.UpdateStatus(context.Background(), agentID, response.StatusUpdate{
+				ID:     r.ID,
+				Status: response.StatusDeclined,
+			})
+			if err != nil {
+				t.Fatalf("failed to update status: %v", err)
+			}
+		}
+
+		emails := st.emailer.queuedEmails(t)
+		if len(emails) != 1 {
+			t.Fatalf("expected 1 email, got %d", len(emails))
+		}
+	})
+
+	for _, status := range []response.Status{"", response.StatusNew} {
+		t.Run("fail, status can't be assigned", func(t *testing.T) {
+			st := newServiceTest(t)
+			l := st.createListing(agentID, true)
+			r := st.respond(l.ID)
+
+			_, err := st.svc.UpdateStatus(context.Background(), agentID, response.StatusUpdate{
+				ID:     r.ID,
+				Status: status,
+			})
+			if !errors.Is(err, response.ErrInvalidStatus) {
+				t.Fatalf("expected error %v, got %v (via errors.Is)", response.ErrInvalidStatus, err)
+			}
+		})
+	}
+
+	t.Run("fail, response to listing of other agent", func(t *testing.T) {
+		st := newServiceTest(t)
+		l := st.createListing(otherAgentID, true)
+		r := st.respond(l.ID)
+
+		_, err := st.svc.UpdateStatus(context.Background(), agentID, response.StatusUpdate{
+			ID:     r.ID,
+			Status: response.StatusContacted,
+		})
+		if !errors.Is(err, errorz.ErrNotFound) {
+			t.Fatalf("expected error %v, got %v (via errors.Is)", errorz.ErrNotFound, err)
+		}
+
+		st.emailer.assertNoEmails(t)
+	})
+
+	t.Run("fail, email can't be rendered", func(t *testing.T) {
+		st := newServiceTest(t)
+		l := st.createListing(agentID, true)
+		r := st.respond(l.ID)
+		st.emailer.testErr = testerr.Err
+
+		_, err := st.svc.UpdateStatus(context.Background(), agentID, response.StatusUpdate{
+			ID:     r.ID,
+			Status: response.StatusContacted,
+		})
+		if !errors.Is(err, testerr.Err) {
+			t.Fatalf("expected error %v, got %v (via errors.Is)", testerr.Err, err)
+		}
+
+		// The status change is rolled back, so the agent can try again.
+		inbox := st.agentInbox(agentID, l.ID)
+		if inbox.Responses[0].Status != response.StatusNew {
+			t.Fatalf("expected stored status %v, got %v", response.StatusNew, inbox.Responses[0].Status)
+		}
+
+		st.emailer.assertNoEmails(t)
+	})
+
+	// FindResponses, BeginTx, FindResponses, UpdateResponse, CreateOutboxMessage, Commit
+	for _, tracker := range testerr.NewFailingDeps(testerr.Err, 6) {
+		t.Run("fail, store fails", func(t *testing.T) {
+			st := newServiceTest(t)
+			l := st.createListing(agentID, true)
+			r := st.respond(l.ID)
+			st.store.tracker = &tracker
+
+			_, err := st.svc.UpdateStatus(context.Background(), agentID, response.StatusUpdate{
+				ID:     r.ID,
+				Status: response.StatusContacted,
+			})
+			if !errors.Is(err, testerr.Err) {
+				t.Fatalf("expected error %v, got %v (via errors.Is)", testerr.Err, err)
+			}
+
+			st.emailer.assertNoEmails(t)
+		})
+	}
+}
+
+type svcTest struct {
+	t        *testing.T
+	svc      *response.Service
+	listings *listing.Service
+	store    *testStore
+	users    *testUsers
+	emailer  *testEmailer
+}
+
+func newServiceTest(t *testing.T) *svcTest {
+	encryptor := must(krypto.NewEncryptor([]krypto.Key{
+		must(krypto.ParseKey("2b671594b775f371eab4050b4d58326682df6b1a6cc2e886717b1a26b4d6c45d")),
+	}))
+
+	testDB := testdb.RunWhile(t, true)
+
+	// Listings and responses need to reference existing users.
+	for i, id := range []uuid.UUID{agentID, otherAgentID, hunterID} {
+		_, err := testDB.Exec(`INSERT INTO users (id, email_encrypted, email_blind_index, password_hash, is_active, created_at, updated_at) VALUES (?, ?, ?, ?, ?, ?, ?)`,
+			id, "encrypted", fmt.Sprintf("index-%d", i), "hash", true, time.Now(), time.Now(),
+		)
+		if err != nil {
+			t.Fatalf("failed to insert user: %v", err)
+		}
+	}
+
+	test := &svcTest{
+		t:        t,
+		listings: listing.NewService(listingdb.New(testDB, testDB)),
+		store: &testStore{
+			store:   db.New(testDB, testDB, encryptor),
+			tracker: &testerr.Calltracker{}, // empty call trackers never fail.
+		},
+		users: &testUsers{
+			users: map[uuid.UUID]auth.User{
+				hunterID: {ID: hunterID, Email: hunterEmail, Role: auth.RoleHunter, IsActive: true},
+			},
+		},
+		emailer: &testEmailer{
+			outbox: outboxdb.New(testDB, testDB, encryptor),
+		},
+	}
+
+	test.svc = response.NewService(test.store, test.listings, test.users, test.emailer)
+
+	return test
+}
+
+func (st *svcTest) createListing(agentID uuid.UUID, publish bool) listing.Listing {
+	l, err := st.listings.CreateListing(context.Background(), agentID, listing.Details{
+		Title:   "Cosy family home",
+		Address: "Prinsengracht 1, Amsterdam",
+		Price:   425000,
+	})
+	if err != nil {
+		st.t.Fatalf("failed to create listing: %v", err)
+	}
+
+	if publish {
+		l, err = st.listings.PublishListing(context.Background(), agentID, l.ID)
+		if err != nil {
+			st.t.Fatalf("failed to publish listing: %v", err)
+		}
+	}
+
+	return l
+}
+
+func (st *svcTest) respond(listingID uuid.UUID) response.Response {
+	r, err := st.svc.Respond(context.Background(), hunterID, validSubmission(listingID))
+	if err != nil {
+		st.t.Fatalf("failed to respond: %v", err)
+	}
+
+	return r
+}
+
+func (st *svcTest) agentInbox(agentID, listingID uuid.UUID) response.Inbox {
+	inbox, err := st.svc.AgentInbox(context.Background(), agentID, listingID)
+	if err != nil {
+		st.t.Fatalf("failed to get inbox: %v", err)
+	}
+
+	return inbox
+}
+
+func validSubmission(listingID uuid.UUID) response.Submission {
+	return response.Submission{
+		ListingID: listingID,
+		Message:   "When can I view the house?",
+	}
+}
+
+type testUsers struct {
+	users map[uuid.UUID]auth.User
+}
+
+func (u *testUsers) ActiveUser(_ context.Context, userID uuid.UUID) (auth.User, error) {
+	user, ok := u.users[userID]
+	if !ok {
+		return auth.User{}, errorz.ErrNotFound
+	}
+
+	return user, nil
+}
+
+type sendEmail struct {
+	template  string
+	recipient email.Address
+	data      any
+}
+
+// testEmailer keeps track of the rendered emails, the rendered messages
+// only contain a reference to this list. This way the tests can check which emails
+// ended up in the outbox, and with which data.
+type testEmailer struct {
+	outbox   outbox.Store
+	rendered []sendEmail
+	testErr  error
+}
+
+func (e *testEmailer) Render(template string, to email.Address, data any) (email.Message, error) {
+	e.rendered = append(e.rendered, sendEmail{
+		template:  template,
+		recipient: to,
+		data:      data,
+	})
+
+	return email.Message{
+		From:      "noreply@example.com",
+		Recipient: to,
+		Subject:   template,
+		Body:      strconv.Itoa(len(e.rendered) - 1),
+	}, e.testErr
+}
+
+// queuedEmails returns the emails in the outbox.
+func (e *testEmailer) queuedEmails(t *testing.T) []sendEmail {
+	t.Helper()
+
+	msgs, err := e.outbox.FindMessages(context.Background(), outbox.MessageFilter{})
+	if err != nil {
+		t.Fatalf("failed to find outbox messages: %v", err)
+	}
+
+	indices := make([]int, 0, len(msgs))
+	for _, m := range msgs {
+		i, err := strconv.Atoi(m.Body)
+		if err != nil || i >= len(e.rendered) {
+			t.Fatalf("unexpected message body in outbox: %q", m.Body)
+		}
+		indices = append(indices, i)
+	}
+	slices.Sort(indices)
+
+	out := make([]sendEmail, 0, len(indices))
+	for _, i := range indices {
+		out = append(out, e.rendered[i])
+	}
+
+	return out
+}
+
+func (e *testEmailer) assertNoEmails(t *testing.T) {
+	t.Helper()
+
+	if emails := e.queuedEmails(t); len(emails) != 0 {
+		t.Fatalf("expected no emails, got %d", len(emails))
+	}
+}
+
+// testStore wraps a real store but uses a testerr.Calltracker to
+// possibly fail on certain method calls.
+type testStore struct {
+	store   response.Store
+	tracker *testerr.Calltracker
+}
+
+func (f *testStore) BeginTx(ctx context.Context) (response.Tx, error) {
+	return testerr.MaybeFail(f.tracker, func() (response.Tx, error) {
+		realTx, err := f.store.BeginTx(ctx)
+		return &testTx{
+			store: f,
+			tx:    realTx,
+		}, err
+	})
+}
+
+func (f *testStore) FindResponses(ctx context.Context, filter response.ResponseFilter) ([]response.Response, error) {
+	return testerr.MaybeFail(f.tracker, func() ([]response.Response, error) {
+		return f.store.FindResponses(ctx, filter)
+	})
+}
+
+type testTx struct {
+	store *testStore
+	tx    response.Tx
+}
+
+// Commit and Rollback end the real transaction even if a failure is simulated,
+// otherwise the test database can't be queried for queued emails afterwards.
+func (tx *testTx) Commit() error {
+	err := testerr.MaybeFailErrFunc(tx.store.tracker, func() error {
+		return tx.tx.Commit()
+	})
+	if err != nil {
+		_ = tx.tx.Rollback()
+	}
+	return err
+}
+
+func (tx *testTx) Rollback() error {
+	err := testerr.MaybeFailErrFunc(tx.store.tracker, func() error {
+		return tx.tx.Rollback()
+	})
+	if err != nil {
+		_ = tx.tx.Rollback()
+	}
+	return err
+}
+
+func (tx *testTx) CreateResponse(r response.Response) error {
+	return testerr.MaybeFailErrFunc(tx.store.tracker, func() error {
+		return tx.tx.CreateResponse(r)
+	})
+}
+
+func (tx *testTx) UpdateResponse(r response.Response) error {
+	return testerr.MaybeFailErrFunc(tx.store.tracker, func() error {
+		return tx.tx.UpdateResponse(r)
+	})
+}
+
+func (tx *testTx) FindResponses(filter response.ResponseFilter) ([]response.Response, error) {
+	return testerr.MaybeFail(tx.store.tracker, func() ([]response.Response, error) {
+		return tx.tx.FindResponses(filter)
+	})
+}
+
+func (tx *testTx) CreateOutboxMessage(m outbox.Message) error {
+	return testerr.MaybeFailErrFunc(tx.store.tracker, func() error {
+		return tx.tx.CreateOutboxMessage(m)
+	})
+}
+
+func must[T any](v T, err error) T {
+	if err != nil {
+		panic(err)
+	}
+	return v
+}
@@ -0,0 +1,39 @@
+package response
+
+import (
+	"context"
+
+	"github.com/google/uuid"
+	"github.com/willemschots/househunt/internal/email/outbox"
+)
+
+// ResponseFilter is used to filter responses.
+// Returned responses must match all the provided fields.
+// If a field is empty or nil, it's ignored.
+type ResponseFilter struct {
+	IDs        []uuid.UUID
+	ListingIDs []uuid.UUID
+	HunterIDs  []uuid.UUID
+	Statuses   []Status
+}
+
+// Store provides access to the response store.
+type Store interface {
+	BeginTx(ctx context.Context) (Tx, error)
+
+	FindResponses(ctx context.Context, filter ResponseFilter) ([]Response, error)
+}
+
+// Tx is a transaction. If an error occurs on any of the Create/Update/Find methods,
+// the transaction is considered to have failed and should be rolled back.
+// Tx is not safe for concurrent use.
+type Tx interface {
+	Commit() error
+	Rollback() error
+
+	CreateResponse(r Response) error
+	UpdateResponse(r Response) error
+	FindResponses(filter ResponseFilter) ([]Response, error)
+
+	CreateOutboxMessage(m outbox.Message) error
+}
@@ -0,0 +1,247 @@
+package web
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/willemschots/househunt/internal/auth"
+	"github.com/willemschots/househunt/internal/errorz"
+	"github.com/willemschots/househunt/internal/listing"
+	"github.com/willemschots/househunt/internal/response"
+)
+
+const (
+	// apiPrefix is the path prefix of the versioned JSON API.
+	apiPrefix = "/api/v1"
+
+	// maxAPIBodyBytes limits the size of JSON request bodies.
+	maxAPIBodyBytes = 1 << 20
+
+	problemContentType = "application/problem+json"
+)
+
+// registerAPI sets up the endpoints of the JSON API. The endpoints call the same target
+// functions as their HTML counterparts, but decode JSON request bodies and write JSON
+// responses. Errors are written as problem details (RFC 9457).
+//
+// Requests are authenticated with an API token in the Authorization header, or with the
+// session cookie. Unsafe requests that use the session cookie need to provide the CSRF
+// token in the X-CSRF-Token header. API tokens are limited to their scopes.
+func (s *Server) registerAPI() {
+	// Listing endpoints for agents.
+	s.apiRole("GET /agent/listings", newAPIHandler(s, s.agentListings), auth.ScopeListingsRead, auth.RoleAgent)
+	{
+		h := newAPIHandler(s, s.createListing)
+		h.onSuccess = func(r result[listing.Details, listing.Listing]) error {
+			s.writeJSONStatus(r.w, r.r, http.StatusCreated, r.out)
+			return nil
+		}
+
+		s.apiRole("POST /agent/listings", h, auth.ScopeListingsWrite, auth.RoleAgent)
+	}
+	s.apiRole("GET /agent/listings/{id}", newAPIHandler(s, s.agentListing), auth.ScopeListingsRead, auth.RoleAgent)
+	s.apiRole("PUT /agent/listings/{id}", newAPIHandler(s, s.updateListing), auth.ScopeListingsWrite, auth.RoleAgent)
+	s.apiRole("DELETE /agent/listings/{id}", newAPIInputHandler(s, s.deleteListing), auth.ScopeListingsWrite, auth.RoleAgent)
+	s.apiRole("POST /agent/listings/{id}/publish", newAPIHandler(s, s.publishListing), auth.ScopeListingsWrite, auth.RoleAgent)
+	s.apiRole("POST /agent/listings/{id}/unpublish", newAPIHandler(s, s.unpublishListing), auth.ScopeListingsWrite, auth.RoleAgent)
+
+	// Response endpoints for agents.
+	s.apiRole("GET /agent/listings/{id}/responses", newAPIHandler(s, s.agentInbox), auth.ScopeResponsesRead, auth.RoleAgent)
+	s.apiRole("PUT /agent/responses/{id}/status", newAPIHandler(s, s.updateResponseStatus), auth.ScopeResponsesWrite, auth.RoleAgent)
+
+	// Listing and response endpoints for house hunters.
+	s.apiRole("GET /listings", newAPIHandler(s, s.publishedListings), auth.ScopeListingsRead, auth.RoleHunter)
+	s.apiRole("GET /listings/{id}", newAPIHandler(s, s.publishedListing), auth.ScopeListingsRead, auth.RoleHunter)
+	s.apiRole("GET /responses", newAPIHandler(s, s.hunterResponses), auth.ScopeResponsesRead, auth.RoleHunter)
+	{
+		h := newAPIHandler(s, s.respond)
+		h.onSuccess = func(r result[response.Submission, response.Response]) error {
+			s.writeJSONStatus(r.w, r.r, http.StatusCreated, r.out)
+			return nil
+		}
+
+		s.apiRole("POST /responses", h, auth.ScopeResponsesWrite, auth.RoleHunter)
+	}
+}
+
+// apiRole registers an API handler that is only accessible to logged in users that
+// have one of the provided roles. Requests authenticated by an API token also need
+// the provided scope. The pattern is relative to the API prefix.
+func (s *Server) apiRole(pattern string, handler http.Handler, scope auth.Scope, roles ...auth.Role) {
+	method, path, _ := strings.Cut(pattern, " ")
+	s.mux.Handle(method+" "+apiPrefix+path, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		err := checkRole(r.Context(), roles)
+		if err != nil {
+			s.writeProblem(w, r, err)
+			return
+		}
+
+		err = checkScope(r.Context(), scope)
+		if err != nil {
+			s.writeProblem(w, r, err)
+			return
+		}
+
+		handler.ServeHTTP(w, r)
+	}))
+}
+
+// newAPIHandler creates a HTTP Handler that:
+// 1. Maps the JSON request body, query and path to a value of input type IN.
+// 2. Calls the target func with that value.
+// 3. Writes the output of type OUT as JSON to the response with status 200.
+//
+// Errors are written as problem details.
+func newAPIHandler[IN, OUT any](srv *Server, targetFunc func(context.Context, IN) (OUT, error)) *mapper[IN, OUT] {
+	return &mapper[IN, OUT]{
+		reqToInFunc: func(s shared) (IN, error) {
+			return apiReqToIn[IN](srv, s)
+		},
+		targetFunc: targetFunc,
+		onSuccess: func(c result[IN, OUT]) error {
+			return defaultSuccess(srv, c)
+		},
+		onFail: func(s shared, err error) {
+			srv.writeProblem(s.w, s.r, err)
+		},
+	}
+}
+
+// newAPIInputHandler creates a HTTP Handler that:
+// 1. Maps the JSON request body, query and path to a value of input type IN.
+// 2. Calls the target func with that value.
+// 3. Writes a status 204 response to the client if target func was successful.
+//
+// Errors are written as problem details.
+func newAPIInputHandler[IN any](srv *Server, targetFunc func(context.Context, IN) error) *mapper[IN, struct{}] {
+	return newAPIHandler(srv, func(ctx context.Context, in IN) (struct{}, error) {
+		return struct{}{}, targetFunc(ctx, in)
+	})
+}
+
+// apiReqToIn maps an API request to a struct. The JSON body is decoded first, after
+// which the query parameters and the "id" path value are decoded in the same way
+// as form values.
+func apiReqToIn[IN any](srv *Server, s shared) (IN, error) {
+	var in IN
+
+	if s.r.Body != nil {
+		dec := json.NewDecoder(http.MaxBytesReader(s.w, s.r.Body, maxAPIBodyBytes))
+		dec.DisallowUnknownFields()
+
+		err := dec.Decode(&in)
+		if err != nil && !errors.Is(err, io.EOF) {
+			return in, jsonDecodeError(err)
+		}
+	}
+
+	values := url.Values{}
+	for key, v := range s.r.URL.Query() {
+		values[key] = v
+	}
+
+	if id := s.r.PathValue("id"); id != "" {
+		values.Set("id", id)
+	}
+
+	err := srv.decoder.Decode(&in, values)
+	return in, decodeError(err)
+}
+
+// jsonDecodeError converts errors of the JSON decoder to invalid input errors.
+func jsonDecodeError(err error) error {
+	var maxBytesErr *http.MaxBytesError
+	if errors.As(err, &maxBytesErr) {
+		return errorz.InvalidInput{fmt.Errorf("request body can't be larger than %d bytes", maxBytesErr.Limit)}
+	}
+
+	var typeErr *json.UnmarshalTypeError
+	if errors.As(err, &typeErr) && typeErr.Field != "" {
+		return errorz.InvalidInput{errorz.Keyed{
+			Key: typeErr.Field,
+			Err: fmt.Errorf("can't be a JSON %s", typeErr.Value),
+		}}
+	}
+
+	return errorz.InvalidInput{fmt.Errorf("invalid JSON body: %w", err)}
+}
+
+// problem is a problem details object as described in RFC 9457.
+type problem struct {
+	Type   string `json:"type"`
+	Title  string `json:"title"`
+	Status int    `json:"status"`
+	Detail string `json:"detail,omitempty"`
+	// Errors contains the reasons why the input was invalid.
+	Errors []problemError `json:"errors,omitempty"`
+}
+
+type problemError struct {
+	Key    string `json:"key,omitempty"`
+	Detail string `json:"detail"`
+}
+
+func newProblemError(err error) problemError {
+	var keyed errorz.Keyed
+	if errors.As(err, &keyed) {
+		return problemError{Key: keyed.Key, Detail: keyed.Err.Error()}
+	}
+
+	return problemError{Detail: err.Error()}
+}
+
+// writeProblem writes err as a problem details response. The status code is derived
+// from err in the same way as writeErrorView does for HTML responses.
+func (s *Server) writeProblem(w http.ResponseWriter, r *http.Request, err error) {
+	p := problem{
+		Type: "about:blank",
+	}
+
+	var invalidInput errorz.InvalidInput
+	switch {
+	case errors.Is(err, auth.ErrInvalidAPIToken):
+		p.Status = http.StatusUnauthorized
+		p.Detail = auth.ErrInvalidAPIToken.Error()
+		w.Header().Set("WWW-Authenticate", `Bearer realm="api"`)
+	case errors.Is(err, errWebhookUnauthorized):
+		p.Status = http.StatusUnauthorized
+		p.Detail = errWebhookUnauthorized.Error()
+	case errors.Is(err, auth.ErrMissingScope):
+		p.Status = http.StatusForbidden
+		p.Detail = auth.ErrMissingScope.Error()
+	case errors.Is(err, errorz.ErrNotFound):
+		p.Status = http.StatusNotFound
+	case errors.Is(err, errorz.ErrRateLimited):
+		p.Status = http.StatusTooManyRequests
+		p.Detail = errorz.ErrRateLimited.Error()
+	case errors.Is(err, errorz.ErrConstraintViolated):
+		p.Status = http.StatusConflict
+	case errors.As(err, &invalidInput):
+		p.Status = http.StatusBadRequest
+		for _, e := range invalidInput {
+			p.Errors = append(p.Errors, newProblemError(e))
+		}
+	default:
+		s.deps.Logger.Error("internal server error", "url", r.URL.String(), "error", err)
+		p.Status = http.StatusInternalServerError
+	}
+
+	p.Title = http.StatusText(p.Status)
+
+	if !s.preWrite(w, r) {
+		return
+	}
+
+	w.Header().Set("Content-Type", problemContentType)
+	w.WriteHeader(p.Status)
+	err = json.NewEncoder(w).Encode(p)
+	if err != nil {
+		s.deps.Logger.Error("failed to encode json", "error", err)
+	}
+}
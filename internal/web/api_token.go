@@ -0,0 +1,122 @@
+package web
+
+import (
+	"context"
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/csrf"
+	"github.com/willemschots/househunt/internal/auth"
+	"github.com/willemschots/househunt/internal/web/sessions"
+)
+
+const (
+	apiTokenCtxKey ctxKey = "_apiToken"
+
+	bearerPrefix = "Bearer "
+)
+
+// bearerMiddleware authenticates API requests that provide an API token in the
+// Authorization header. These requests get a stateless session of the token owner,
+// other requests are left to the session middleware.
+//
+// Bearer requests don't carry cookies that a browser attaches automatically, so they
+// are not vulnerable to CSRF and skip the CSRF check.
+func bearerMiddleware(srv *Server) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			header := r.Header.Get("Authorization")
+			if !strings.HasPrefix(r.URL.Path, apiPrefix+"/") || !strings.HasPrefix(header, bearerPrefix) {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			r = csrf.UnsafeSkipCheck(r)
+			sess := sessions.NewStateless()
+			ctx := ctxWithSession(r.Context(), sess)
+
+			user, token, err := srv.authenticateBearer(ctx, strings.TrimPrefix(header, bearerPrefix))
+			if err != nil {
+				srv.writeProblem(w, r.WithContext(ctx), err)
+				return
+			}
+
+			sess.SetUserID(user.ID)
+			sess.SetRole(string(user.Role))
+
+			ctx = ctxWithAPIToken(ctx, token)
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+func (s *Server) authenticateBearer(ctx context.Context, header string) (auth.User, auth.APIToken, error) {
+	raw, err := auth.ParseAPITokenRaw(strings.TrimSpace(header))
+	if err != nil {
+		return auth.User{}, auth.APIToken{}, err
+	}
+
+	return s.deps.AuthService.AuthenticateAPIToken(ctx, raw)
+}
+
+func ctxWithAPIToken(ctx context.Context, token auth.APIToken) context.Context {
+	return context.WithValue(ctx, apiTokenCtxKey, token)
+}
+
+// apiTokenFromCtx returns the API token the request was authenticated with, ok is
+// false if the request was authenticated by a session cookie.
+func apiTokenFromCtx(ctx context.Context) (auth.APIToken, bool) {
+	token, ok := ctx.Value(apiTokenCtxKey).(auth.APIToken)
+	return token, ok
+}
+
+// checkScope returns auth.ErrMissingScope if the request was authenticated with an API
+// token that lacks the scope. Requests authenticated by a session have all scopes.
+func checkScope(ctx context.Context, scope auth.Scope) error {
+	token, ok := apiTokenFromCtx(ctx)
+	if ok && !token.HasScope(scope) {
+		return auth.ErrMissingScope
+	}
+
+	return nil
+}
+
+// apiTokensPage is the data of the API tokens settings page.
+type apiTokensPage struct {
+	Tokens []auth.APIToken
+	Scopes []auth.Scope
+}
+
+// createdAPIToken is the data of the page that shows a new API token once.
+type createdAPIToken struct {
+	Token auth.APIToken
+	Raw   string
+}
+
+func (s *Server) apiTokens(ctx context.Context, _ struct{}) (apiTokensPage, error) {
+	userID, err := userIDFromCtx(ctx)
+	if err != nil {
+		return apiTokensPage{}, err
+	}
+
+	tokens, err := s.deps.AuthService.APITokens(ctx, userID)
+	if err != nil {
+		return apiTokensPage{}, err
+	}
+
+	return apiTokensPage{Tokens: tokens, Scopes: auth.Scopes()}, nil
+}
+
+func (s *Server) createAPIToken(ctx context.Context, in auth.NewAPIToken) (createdAPIToken, error) {
+	userID, err := userIDFromCtx(ctx)
+	if err != nil {
+		return createdAPIToken{}, err
+	}
+
+	token, raw, err := s.deps.AuthService.CreateAPIToken(ctx, userID, in)
+	if err != nil {
+		return createdAPIToken{}, err
+	}
+
+	return createdAPIToken{Token: token, Raw: raw.String()}, nil
+}
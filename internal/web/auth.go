@@ -1,15 +1,30 @@
 package web
 
 import (
+	"context"
+	"html/template"
 	"net/http"
+	"slices"
+	"time"
 
+	"github.com/google/uuid"
+	"github.com/willemschots/househunt/internal/auth"
 	"github.com/willemschots/househunt/internal/errorz"
+	"github.com/willemschots/househunt/internal/web/sessions"
+	"github.com/willemschots/househunt/internal/webauthn"
 )
 
 const (
 	authCookieName      = "hh-auth"
 	csrfTokenCookieName = "csrf"
 	csrfTokenField      = "csrfToken"
+
+	// secondFactorTimeout is how long a user has to provide a second factor
+	// after providing valid credentials.
+	secondFactorTimeout = 5 * time.Minute
+
+	// passkeyTimeout is how long a user has to complete a passkey ceremony.
+	passkeyTimeout = 5 * time.Minute
 )
 
 func (s *Server) public(pattern string, handler http.Handler) {
@@ -51,3 +66,122 @@ func (s *Server) loggedIn(pattern string, handler http.Handler) {
 		handler.ServeHTTP(w, r)
 	}))
 }
+
+// role registers a handler that is only accessible to logged in users
+// that have one of the provided roles.
+func (s *Server) role(pattern string, handler http.Handler, roles ...auth.Role) {
+	s.mux.Handle(pattern, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		err := checkRole(r.Context(), roles)
+		if err != nil {
+			s.writeError(w, r, err)
+			return
+		}
+
+		handler.ServeHTTP(w, r)
+	}))
+}
+
+// checkRole returns errorz.ErrNotFound unless a user with one of the roles is logged in.
+// A role without a user is a leftover of a session that was only partially cleared, so
+// a user ID is required as well.
+func checkRole(ctx context.Context, roles []auth.Role) error {
+	_, err := userIDFromCtx(ctx)
+	if err != nil {
+		return err
+	}
+
+	role, err := roleFromCtx(ctx)
+	if err != nil {
+		return err
+	}
+
+	if !slices.Contains(roles, role) {
+		return errorz.ErrNotFound
+	}
+
+	return nil
+}
+
+// logIn logs the user in on the session of the request.
+func (s *Server) logIn(r shared, user auth.User) {
+	// We clear the CSRF token to provide defense in depth against fixation attacks.
+	// If an attacker somehow gains access to the CSRF token before the user logged in, it will
+	// be worthless after the user logs in.
+	// See this link for more information:
+	// https://security.stackexchange.com/questions/209993/csrf-token-unique-per-user-session-why
+	//
+	// A new CSRF token will be generated on the next GET request after the redirect.
+	http.SetCookie(r.w, &http.Cookie{
+		Name:   csrfTokenCookieName,
+		MaxAge: -1,
+	})
+
+	// A new session ID prevents session fixation.
+	r.sess.Renew()
+	r.sess.DeletePendingUserID()
+	r.sess.SetUserID(user.ID)
+	r.sess.SetRole(string(user.Role))
+
+	// Emails to a bounced address are no longer sent, the user needs to know why.
+	// Failing to check this should not prevent the user from logging in.
+	bounced, err := s.deps.AuthService.EmailBounced(r.r.Context(), user.ID)
+	if err != nil {
+		s.deps.Logger.Error("failed to check for email bounces", "error", err)
+	} else if bounced {
+		r.sess.AddFlash("Emails to your address bounced, we no longer send you emails. Please change your email address.")
+	}
+}
+
+// pendingUserID returns the user that still needs to provide a second factor,
+// if they provided their credentials recently enough.
+func pendingUserID(sess *sessions.Session) (uuid.UUID, bool) {
+	userID, since, ok := sess.PendingUserID()
+	if !ok || time.Since(since) > secondFactorTimeout {
+		return uuid.Nil, false
+	}
+
+	return userID, true
+}
+
+// startPasskeyCeremony generates a challenge for a passkey ceremony and stores it in the session.
+func startPasskeyCeremony(sess *sessions.Session) ([]byte, error) {
+	challenge, err := webauthn.GenerateChallenge()
+	if err != nil {
+		return nil, err
+	}
+
+	sess.SetWebAuthnChallenge(challenge, time.Now())
+	return challenge, nil
+}
+
+// finishPasskeyCeremony returns the challenge of the passkey ceremony started on the session.
+// The challenge is removed from the session, so that it can only be used once.
+func finishPasskeyCeremony(sess *sessions.Session) ([]byte, error) {
+	challenge, since, ok := sess.WebAuthnChallenge()
+	sess.DeleteWebAuthnChallenge()
+
+	if !ok || time.Since(since) > passkeyTimeout {
+		return nil, errorz.InvalidInput{errorz.Keyed{Key: "passkey", Err: auth.ErrInvalidPasskey}}
+	}
+
+	return challenge, nil
+}
+
+// secondFactor is a TOTP or recovery code provided by a user.
+type secondFactor struct {
+	Code string
+}
+
+// totpEnrollment is the data for the view to enable TOTP.
+type totpEnrollment struct {
+	Secret string
+	// URI is marked as safe, otherwise the template escapes the otpauth scheme.
+	URI template.URL
+}
+
+func newTOTPEnrollment(e auth.TOTPEnrollment) totpEnrollment {
+	return totpEnrollment{
+		Secret: e.Secret.String(),
+		URI:    template.URL(e.URI),
+	}
+}
@@ -0,0 +1,107 @@
+package web
+
+import (
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/willemschots/househunt/internal/auth"
+	"github.com/willemschots/househunt/internal/web/sessions"
+)
+
+func Test_Server_role(t *testing.T) {
+	guards := map[string]struct {
+		register func(s *Server, h http.Handler)
+		path     string
+	}{
+		"role": {
+			register: func(s *Server, h http.Handler) {
+				s.role("GET /agents-only", h, auth.RoleAgent)
+			},
+			path: "/agents-only",
+		},
+		"apiRole": {
+			register: func(s *Server, h http.Handler) {
+				s.apiRole("GET /agents-only", h, auth.ScopeListingsRead, auth.RoleAgent)
+			},
+			path: apiPrefix + "/agents-only",
+		},
+	}
+
+	tests := map[string]struct {
+		userID *uuid.UUID
+		role   *auth.Role
+		want   int
+	}{
+		"ok, user with role": {
+			userID: ptr(uuid.MustParse("0e61a06e-bbf6-4b87-aaaa-75fee0f38cca")),
+			role:   ptr(auth.RoleAgent),
+			want:   http.StatusOK,
+		},
+		"fail, user with other role": {
+			userID: ptr(uuid.MustParse("0e61a06e-bbf6-4b87-aaaa-75fee0f38cca")),
+			role:   ptr(auth.RoleHunter),
+			want:   http.StatusNotFound,
+		},
+		"fail, user without role": {
+			userID: ptr(uuid.MustParse("0e61a06e-bbf6-4b87-aaaa-75fee0f38cca")),
+			want:   http.StatusNotFound,
+		},
+		"fail, role without user": {
+			role: ptr(auth.RoleAgent),
+			want: http.StatusNotFound,
+		},
+		"fail, anonymous": {
+			want: http.StatusNotFound,
+		},
+	}
+
+	for guard, g := range guards {
+		for name, tc := range tests {
+			t.Run(guard+", "+name, func(t *testing.T) {
+				s := &Server{
+					deps: &ServerDeps{
+						Logger:       slog.New(slog.NewTextHandler(io.Discard, nil)),
+						ViewRenderer: nopRenderer{},
+					},
+					mux: http.NewServeMux(),
+				}
+
+				g.register(s, http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+					w.WriteHeader(http.StatusOK)
+				}))
+
+				sess := sessions.NewStateless()
+				if tc.userID != nil {
+					sess.SetUserID(*tc.userID)
+				}
+				if tc.role != nil {
+					sess.SetRole(string(*tc.role))
+				}
+
+				r := httptest.NewRequest(http.MethodGet, g.path, nil)
+				r = r.WithContext(ctxWithSession(r.Context(), sess))
+				w := httptest.NewRecorder()
+
+				s.mux.ServeHTTP(w, r)
+
+				if w.Code != tc.want {
+					t.Errorf("got status %d, want %d", w.Code, tc.want)
+				}
+			})
+		}
+	}
+}
+
+type nopRenderer struct{}
+
+func (nopRenderer) Render(io.Writer, string, any) error {
+	return nil
+}
+
+func ptr[T any](v T) *T {
+	return &v
+}
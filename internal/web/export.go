@@ -0,0 +1,98 @@
+package web
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/willemschots/househunt/internal/auth"
+	"github.com/willemschots/househunt/internal/listing"
+	"github.com/willemschots/househunt/internal/response"
+	"github.com/willemschots/househunt/internal/web/sessions"
+)
+
+// accountExport is the personal data of the logged in user, as it can be downloaded
+// from the account page.
+type accountExport struct {
+	Account   auth.Export
+	Sessions  []sessionExport
+	Listings  []listing.Listing
+	Responses []response.Response
+}
+
+// sessionExport is a session without its values and ID, the ID can be used to
+// take over the session.
+type sessionExport struct {
+	UserAgent  string
+	IP         string
+	CreatedAt  time.Time
+	LastSeenAt time.Time
+	ExpiresAt  time.Time
+}
+
+func newSessionExports(records []sessions.Record) []sessionExport {
+	out := make([]sessionExport, 0, len(records))
+	for _, r := range records {
+		out = append(out, sessionExport{
+			UserAgent:  r.UserAgent,
+			IP:         r.IP,
+			CreatedAt:  r.CreatedAt,
+			LastSeenAt: r.LastSeenAt,
+			ExpiresAt:  r.ExpiresAt,
+		})
+	}
+
+	return out
+}
+
+// writeExport writes the export as a ZIP archive with a JSON file per kind of data.
+func (s *Server) writeExport(w http.ResponseWriter, r *http.Request, exp accountExport) {
+	files := []struct {
+		name string
+		data any
+	}{
+		{name: "account.json", data: exp.Account},
+		{name: "sessions.json", data: exp.Sessions},
+		{name: "listings.json", data: exp.Listings},
+		{name: "responses.json", data: exp.Responses},
+	}
+
+	// The archive is written to a buffer first, so that an error can
+	// still be reported instead of sending a broken archive.
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for _, f := range files {
+		fw, err := zw.Create(f.name)
+		if err != nil {
+			s.writeError(w, r, err)
+			return
+		}
+
+		enc := json.NewEncoder(fw)
+		enc.SetIndent("", "  ")
+		err = enc.Encode(f.data)
+		if err != nil {
+			s.writeError(w, r, err)
+			return
+		}
+	}
+
+	err := zw.Close()
+	if err != nil {
+		s.writeError(w, r, err)
+		return
+	}
+
+	if !s.preWrite(w, r) {
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", `attachment; filename="househunt-export.zip"`)
+	_, err = w.Write(buf.Bytes())
+	if err != nil {
+		s.deps.Logger.Error("failed to write export", "error", err)
+	}
+}
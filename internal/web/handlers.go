@@ -107,8 +107,16 @@ func decodeError(err error) error {
 	return err
 }
 
-// defaultSuccess is the default way to write a response to the client.
-func defaultSuccess[IN, OUT any](_ *Server, _ result[IN, OUT]) error {
-	// TODO: Implement.
+// defaultSuccess is the default way to write a response to the client. The output
+// is written as JSON, handlers without output respond with status 204.
+func defaultSuccess[IN, OUT any](srv *Server, c result[IN, OUT]) error {
+	if _, ok := any(c.out).(struct{}); ok {
+		if srv.preWrite(c.w, c.r) {
+			c.w.WriteHeader(http.StatusNoContent)
+		}
+		return nil
+	}
+
+	srv.writeJSON(c.w, c.r, c.out)
 	return nil
 }
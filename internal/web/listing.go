@@ -0,0 +1,109 @@
+package web
+
+import (
+	"context"
+	"net/url"
+
+	"github.com/google/uuid"
+	"github.com/willemschots/househunt/internal/listing"
+)
+
+// listingRef is used to refer to a single listing in a request.
+type listingRef struct {
+	ID uuid.UUID
+}
+
+// editListingURL returns the URL of the page where an agent can edit the listing.
+func editListingURL(id uuid.UUID) string {
+	return "/listings/edit?" + url.Values{"id": []string{id.String()}}.Encode()
+}
+
+// viewListingURL returns the URL of the page where a house hunter can view the listing.
+func viewListingURL(id uuid.UUID) string {
+	return "/listings/view?" + url.Values{"id": []string{id.String()}}.Encode()
+}
+
+// listingResponsesURL returns the URL of the page where an agent can triage the responses to the listing.
+func listingResponsesURL(id uuid.UUID) string {
+	return "/listings/responses?" + url.Values{"id": []string{id.String()}}.Encode()
+}
+
+// listingEdit is the input of an agent editing a listing.
+type listingEdit struct {
+	ID uuid.UUID
+	listing.Details
+}
+
+// The methods below are the target functions of the listing endpoints, they are shared
+// by the HTML and API handlers.
+
+func (s *Server) createListing(ctx context.Context, d listing.Details) (listing.Listing, error) {
+	agentID, err := userIDFromCtx(ctx)
+	if err != nil {
+		return listing.Listing{}, err
+	}
+
+	return s.deps.ListingService.CreateListing(ctx, agentID, d)
+}
+
+func (s *Server) agentListing(ctx context.Context, ref listingRef) (listing.Listing, error) {
+	agentID, err := userIDFromCtx(ctx)
+	if err != nil {
+		return listing.Listing{}, err
+	}
+
+	return s.deps.ListingService.AgentListing(ctx, agentID, ref.ID)
+}
+
+func (s *Server) agentListings(ctx context.Context, _ struct{}) ([]listing.Listing, error) {
+	agentID, err := userIDFromCtx(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.deps.ListingService.AgentListings(ctx, agentID)
+}
+
+func (s *Server) updateListing(ctx context.Context, e listingEdit) (listing.Listing, error) {
+	agentID, err := userIDFromCtx(ctx)
+	if err != nil {
+		return listing.Listing{}, err
+	}
+
+	return s.deps.ListingService.UpdateListing(ctx, agentID, e.ID, e.Details)
+}
+
+func (s *Server) publishListing(ctx context.Context, ref listingRef) (listing.Listing, error) {
+	agentID, err := userIDFromCtx(ctx)
+	if err != nil {
+		return listing.Listing{}, err
+	}
+
+	return s.deps.ListingService.PublishListing(ctx, agentID, ref.ID)
+}
+
+func (s *Server) unpublishListing(ctx context.Context, ref listingRef) (listing.Listing, error) {
+	agentID, err := userIDFromCtx(ctx)
+	if err != nil {
+		return listing.Listing{}, err
+	}
+
+	return s.deps.ListingService.UnpublishListing(ctx, agentID, ref.ID)
+}
+
+func (s *Server) deleteListing(ctx context.Context, ref listingRef) error {
+	agentID, err := userIDFromCtx(ctx)
+	if err != nil {
+		return err
+	}
+
+	return s.deps.ListingService.DeleteListing(ctx, agentID, ref.ID)
+}
+
+func (s *Server) publishedListings(ctx context.Context, _ struct{}) ([]listing.Listing, error) {
+	return s.deps.ListingService.PublishedListings(ctx)
+}
+
+func (s *Server) publishedListing(ctx context.Context, ref listingRef) (listing.Listing, error) {
+	return s.deps.ListingService.PublishedListing(ctx, ref.ID)
+}
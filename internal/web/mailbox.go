@@ -0,0 +1,47 @@
+package web
+
+import (
+	"context"
+
+	"github.com/willemschots/househunt/internal/email/mailbox"
+)
+
+const mailboxPrefix = "/_dev/mailbox"
+
+// mailboxRef is used to refer to a single captured email in a request.
+type mailboxRef struct {
+	ID string
+}
+
+// registerMailbox sets up the endpoints to browse the emails captured by the file email
+// driver, so that links in emails can be followed during local development.
+//
+// Anyone can read the captured emails, which contain tokens to take over accounts. These
+// endpoints are only registered if the server has a mailbox, which should never be the
+// case in production.
+func (s *Server) registerMailbox() {
+	{
+		const route = "GET " + mailboxPrefix
+		h := newHandler(s, func(ctx context.Context, _ struct{}) ([]mailbox.Summary, error) {
+			return s.deps.Mailbox.List()
+		})
+		h.onSuccess = func(r result[struct{}, []mailbox.Summary]) error {
+			s.writeView(r.w, r.r, "dev-mailbox", r.out)
+			return nil
+		}
+
+		s.public(route, h)
+	}
+	{
+		const route = "GET " + mailboxPrefix + "/view"
+		h := newHandler(s, func(ctx context.Context, ref mailboxRef) (mailbox.Email, error) {
+			return s.deps.Mailbox.Get(ref.ID)
+		})
+		h.onSuccess = func(r result[mailboxRef, mailbox.Email]) error {
+			s.writeView(r.w, r.r, "dev-mailbox-email", r.out)
+			return nil
+		}
+
+		s.public(route, h)
+	}
+}
@@ -0,0 +1,36 @@
+package web
+
+import (
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+
+	"github.com/willemschots/househunt/internal/errorz"
+)
+
+// limited wraps handler so that clients making too many attempts on route are refused.
+// When a client is refused the named view is rendered with a 429 status code.
+func (s *Server) limited(route, view string, handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ok, retryAfter := s.limiter.Allow(route + " " + clientIP(r))
+		if !ok {
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+			s.writeErrorView(w, r, view, errorz.ErrRateLimited)
+			return
+		}
+
+		handler.ServeHTTP(w, r)
+	})
+}
+
+// clientIP returns the IP address of the client. Headers like X-Forwarded-For
+// are ignored, because they can be set by anyone.
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+
+	return host
+}
@@ -0,0 +1,104 @@
+package ratelimit
+
+import (
+	"sync"
+	"time"
+)
+
+// Config is the configuration for a Limiter.
+type Config struct {
+	// MaxAttempts is the number of attempts allowed per key within Window.
+	// Zero disables the limiter.
+	MaxAttempts int
+	Window      time.Duration
+	// Lockout is the duration a key is refused after exceeding MaxAttempts.
+	Lockout time.Duration
+}
+
+// Limiter limits the number of attempts per key, keys that exceed the limit
+// are locked out for a while. State is kept in memory, so it's lost on restart
+// and not shared between instances.
+//
+// Limiter is safe for concurrent use.
+type Limiter struct {
+	cfg       Config
+	mu        *sync.Mutex
+	entries   map[string]*entry
+	lastSweep time.Time
+
+	// NowFunc is used to get the current time.
+	// Exposed for testing purposes.
+	NowFunc func() time.Time
+}
+
+type entry struct {
+	windowStart time.Time
+	attempts    int
+	lockedUntil time.Time
+}
+
+// New creates a new Limiter.
+func New(cfg Config) *Limiter {
+	return &Limiter{
+		cfg:     cfg,
+		mu:      &sync.Mutex{},
+		entries: make(map[string]*entry),
+		NowFunc: time.Now,
+	}
+}
+
+// Allow records an attempt for key and reports whether it is allowed. If it's not,
+// the returned duration indicates when the next attempt will be allowed.
+func (l *Limiter) Allow(key string) (bool, time.Duration) {
+	if l.cfg.MaxAttempts <= 0 {
+		return true, 0
+	}
+
+	now := l.NowFunc()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.sweep(now)
+
+	e, ok := l.entries[key]
+	if !ok {
+		e = &entry{windowStart: now}
+		l.entries[key] = e
+	}
+
+	if now.Before(e.lockedUntil) {
+		return false, e.lockedUntil.Sub(now)
+	}
+
+	if now.Sub(e.windowStart) >= l.cfg.Window {
+		e.windowStart = now
+		e.attempts = 0
+	}
+
+	e.attempts++
+	if e.attempts > l.cfg.MaxAttempts {
+		e.lockedUntil = now.Add(l.cfg.Lockout)
+		e.windowStart = e.lockedUntil
+		e.attempts = 0
+		return false, l.cfg.Lockout
+	}
+
+	return true, 0
+}
+
+// sweep removes expired entries, so that memory usage doesn't grow unbounded.
+// It runs at most once per window.
+func (l *Limiter) sweep(now time.Time) {
+	if now.Sub(l.lastSweep) < l.cfg.Window {
+		return
+	}
+
+	for key, e := range l.entries {
+		if !now.Before(e.lockedUntil) && now.Sub(e.windowStart) >= l.cfg.Window {
+			delete(l.entries, key)
+		}
+	}
+
+	l.lastSweep = now
+}
@@ -0,0 +1,104 @@
+package ratelimit_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/willemschots/househunt/internal/web/ratelimit"
+)
+
+func Test_Limiter_Allow(t *testing.T) {
+	cfg := ratelimit.Config{
+		MaxAttempts: 3,
+		Window:      time.Minute,
+		Lockout:     10 * time.Minute,
+	}
+
+	setup := func() (*ratelimit.Limiter, *time.Time) {
+		now := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+		l := ratelimit.New(cfg)
+		l.NowFunc = func() time.Time { return now }
+		return l, &now
+	}
+
+	t.Run("ok, attempts within limit", func(t *testing.T) {
+		l, _ := setup()
+
+		for i := 0; i < cfg.MaxAttempts; i++ {
+			assertAllowed(t, l, "a")
+		}
+	})
+
+	t.Run("ok, keys are limited separately", func(t *testing.T) {
+		l, _ := setup()
+
+		for i := 0; i < cfg.MaxAttempts; i++ {
+			assertAllowed(t, l, "a")
+		}
+
+		assertRefused(t, l, "a", cfg.Lockout)
+		assertAllowed(t, l, "b")
+	})
+
+	t.Run("ok, attempts reset after window", func(t *testing.T) {
+		l, now := setup()
+
+		for i := 0; i < cfg.MaxAttempts; i++ {
+			assertAllowed(t, l, "a")
+		}
+
+		*now = now.Add(cfg.Window)
+
+		for i := 0; i < cfg.MaxAttempts; i++ {
+			assertAllowed(t, l, "a")
+		}
+	})
+
+	t.Run("ok, locked out after too many attempts", func(t *testing.T) {
+		l, now := setup()
+
+		for i := 0; i < cfg.MaxAttempts; i++ {
+			assertAllowed(t, l, "a")
+		}
+
+		assertRefused(t, l, "a", cfg.Lockout)
+
+		// Still locked out after the window has passed.
+		*now = now.Add(cfg.Window)
+		assertRefused(t, l, "a", cfg.Lockout-cfg.Window)
+
+		// Allowed again after the lockout.
+		*now = now.Add(cfg.Lockout - cfg.Window)
+		for i := 0; i < cfg.MaxAttempts; i++ {
+			assertAllowed(t, l, "a")
+		}
+
+		assertRefused(t, l, "a", cfg.Lockout)
+	})
+
+	t.Run("ok, zero max attempts disables limiter", func(t *testing.T) {
+		l := ratelimit.New(ratelimit.Config{})
+
+		for i := 0; i < 100; i++ {
+			assertAllowed(t, l, "a")
+		}
+	})
+}
+
+func assertAllowed(t *testing.T, l *ratelimit.Limiter, key string) {
+	t.Helper()
+
+	ok, retryAfter := l.Allow(key)
+	if !ok || retryAfter != 0 {
+		t.Fatalf("expected attempt to be allowed, got %v and %s", ok, retryAfter)
+	}
+}
+
+func assertRefused(t *testing.T, l *ratelimit.Limiter, key string, wantRetryAfter time.Duration) {
+	t.Helper()
+
+	ok, retryAfter := l.Allow(key)
+	if ok || retryAfter != wantRetryAfter {
+		t.Fatalf("expected attempt to be refused with retry after %s, got %v and %s", wantRetryAfter, ok, retryAfter)
+	}
+}
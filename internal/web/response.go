@@ -0,0 +1,46 @@
+package web
+
+import (
+	"context"
+
+	"github.com/willemschots/househunt/internal/response"
+)
+
+// The methods below are the target functions of the response endpoints, they are shared
+// by the HTML and API handlers.
+
+func (s *Server) respond(ctx context.Context, sub response.Submission) (response.Response, error) {
+	hunterID, err := userIDFromCtx(ctx)
+	if err != nil {
+		return response.Response{}, err
+	}
+
+	return s.deps.ResponseService.Respond(ctx, hunterID, sub)
+}
+
+func (s *Server) hunterResponses(ctx context.Context, _ struct{}) ([]response.Response, error) {
+	hunterID, err := userIDFromCtx(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.deps.ResponseService.HunterResponses(ctx, hunterID)
+}
+
+func (s *Server) agentInbox(ctx context.Context, ref listingRef) (response.Inbox, error) {
+	agentID, err := userIDFromCtx(ctx)
+	if err != nil {
+		return response.Inbox{}, err
+	}
+
+	return s.deps.ResponseService.AgentInbox(ctx, agentID, ref.ID)
+}
+
+func (s *Server) updateResponseStatus(ctx context.Context, u response.StatusUpdate) (response.Response, error) {
+	agentID, err := userIDFromCtx(ctx)
+	if err != nil {
+		return response.Response{}, err
+	}
+
+	return s.deps.ResponseService.UpdateStatus(ctx, agentID, u)
+}
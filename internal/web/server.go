@@ -2,18 +2,27 @@ package web
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"io"
 	"log/slog"
 	"net/http"
+	"time"
 
+	"github.com/google/uuid"
 	"github.com/gorilla/csrf"
 	"github.com/gorilla/schema"
 	"github.com/willemschots/househunt/internal/auth"
 	"github.com/willemschots/househunt/internal/email"
+	"github.com/willemschots/househunt/internal/email/mailbox"
+	"github.com/willemschots/househunt/internal/email/postmark"
 	"github.com/willemschots/househunt/internal/errorz"
 	"github.com/willemschots/househunt/internal/krypto"
+	"github.com/willemschots/househunt/internal/listing"
+	"github.com/willemschots/househunt/internal/response"
+	"github.com/willemschots/househunt/internal/web/ratelimit"
 	"github.com/willemschots/househunt/internal/web/sessions"
+	"github.com/willemschots/househunt/internal/webauthn"
 )
 
 // ViewRenderer renders named views with the given data.
@@ -23,17 +32,27 @@ type ViewRenderer interface {
 
 // ServerDeps are the dependencies for the server.
 type ServerDeps struct {
-	Logger       *slog.Logger
-	ViewRenderer ViewRenderer
-	AuthService  *auth.Service
-	SessionStore *sessions.Store
-	DistFS       http.FileSystem
+	Logger          *slog.Logger
+	ViewRenderer    ViewRenderer
+	AuthService     *auth.Service
+	ListingService  *listing.Service
+	ResponseService *response.Service
+	SessionStore    *sessions.Store
+	DistFS          http.FileSystem
+	// Mailbox contains the emails captured by the file email driver, it's optional
+	// and only meant for local development. See registerMailbox.
+	Mailbox *mailbox.Mailbox
 }
 
 // ServerConfig is the configuration for the server.
 type ServerConfig struct {
 	CSRFKey      krypto.Key
 	SecureCookie bool
+	// RateLimit limits the attempts per client IP on the authentication endpoints.
+	RateLimit ratelimit.Config
+	// PostmarkWebhook is used to authenticate the Postmark webhook, which is only
+	// registered if it's enabled.
+	PostmarkWebhook postmark.WebhookSettings
 }
 
 // Server implements the server for the application.
@@ -44,6 +63,7 @@ type Server struct {
 	deps    *ServerDeps
 	mux     *http.ServeMux
 	decoder *schema.Decoder
+	limiter *ratelimit.Limiter
 	handler http.Handler
 }
 
@@ -52,6 +72,7 @@ func NewServer(deps *ServerDeps, cfg ServerConfig) *Server {
 		deps:    deps,
 		mux:     http.NewServeMux(),
 		decoder: schema.NewDecoder(),
+		limiter: ratelimit.New(cfg.RateLimit),
 	}
 
 	// Below we set up all the endpoints of the server.
@@ -73,13 +94,13 @@ func NewServer(deps *ServerDeps, cfg ServerConfig) *Server {
 		h.onFail = func(r shared, err error) {
 			s.writeErrorView(r.w, r.r, "register-user", err)
 		}
-		h.onSuccess = func(r result[auth.Credentials, struct{}]) error {
+		h.onSuccess = func(r result[auth.Registration, struct{}]) error {
 			r.sess.AddFlash("Thank you for your registration. Please follow the instructions that have arrived in your inbox.")
 			s.writeRedirect(r.w, r.r, "/register", http.StatusFound)
 			return nil
 		}
 
-		s.publicOnly(route, h)
+		s.publicOnly(route, s.limited(route, "register-user", h))
 	}
 
 	// Activate user endpoints.
@@ -108,7 +129,7 @@ func NewServer(deps *ServerDeps, cfg ServerConfig) *Server {
 			return nil
 		}
 
-		s.publicOnly(route, h)
+		s.publicOnly(route, s.limited(route, "activate-user", h))
 	}
 
 	// Login user endpoints
@@ -123,26 +144,133 @@ func NewServer(deps *ServerDeps, cfg ServerConfig) *Server {
 		}
 		h.onSuccess = func(r result[auth.Credentials, auth.User]) error {
 			// If we get here, the user has been authenticated.
+			totpEnabled, err := deps.AuthService.TOTPEnabled(r.r.Context(), r.out.ID)
+			if err != nil {
+				return err
+			}
+
+			if totpEnabled {
+				// The user is only logged in after they provide a second factor.
+				// Until then the session remembers who they are, renewed for the
+				// same reason as when logging in.
+				r.sess.Renew()
+				r.sess.SetPendingUserID(r.out.ID, time.Now())
+				s.writeRedirect(r.w, r.r, "/login/verify", http.StatusFound)
+				return nil
+			}
+
+			s.logIn(r.shared, r.out)
+			s.writeRedirect(r.w, r.r, "/dashboard", http.StatusFound)
+			return nil
+		}
+
+		s.publicOnly(route, s.limited(route, "login-user", h))
+	}
+
+	// Second factor login endpoints
+	{
+		const route = "GET /login/verify"
+		h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			sess, err := sessionFromCtx(r.Context())
+			if err != nil {
+				s.writeError(w, r, err)
+				return
+			}
+
+			if _, ok := pendingUserID(sess); !ok {
+				s.writeRedirect(w, r, "/login", http.StatusFound)
+				return
+			}
+
+			s.writeView(w, r, "verify-login", nil)
+		})
+
+		s.publicOnly(route, h)
+	}
+	{
+		const route = "POST /login/verify"
+		h := newHandler(s, func(ctx context.Context, in secondFactor) (auth.User, error) {
+			sess, err := sessionFromCtx(ctx)
+			if err != nil {
+				return auth.User{}, err
+			}
 
-			// We clear the CSRF token to provide defense in depth against fixation attacks.
-			// If an attacker somehow gains access to the CSRF token before the user logged in, it will
-			// be worthless after the user logs in.
-			// See this link for more information:
-			// https://security.stackexchange.com/questions/209993/csrf-token-unique-per-user-session-why
-			//
-			// A new CSRF token will be generated on the next GET request after the redirect.
-			http.SetCookie(r.w, &http.Cookie{
-				Name:   csrfTokenCookieName,
-				MaxAge: -1,
-			})
-
-			r.sess.SetUserID(r.out.ID)
+			userID, ok := pendingUserID(sess)
+			if !ok {
+				return auth.User{}, errorz.ErrNotFound
+			}
+
+			return deps.AuthService.VerifySecondFactor(ctx, userID, in.Code)
+		})
+		h.onFail = func(r shared, err error) {
+			if errors.Is(err, errorz.ErrNotFound) {
+				r.sess.DeletePendingUserID()
+				r.sess.AddFlash("Your login has expired, please login again.")
+				s.writeRedirect(r.w, r.r, "/login", http.StatusFound)
+				return
+			}
+
+			s.writeErrorView(r.w, r.r, "verify-login", err)
+		}
+		h.onSuccess = func(r result[secondFactor, auth.User]) error {
+			s.logIn(r.shared, r.out)
 			s.writeRedirect(r.w, r.r, "/dashboard", http.StatusFound)
 			return nil
 		}
 
+		s.publicOnly(route, s.limited(route, "verify-login", h))
+	}
+
+	// Passkey login endpoints
+	{
+		const route = "GET /login/passkey/options"
+		h := newHandler(s, func(ctx context.Context, _ struct{}) (webauthn.RequestOptions, error) {
+			sess, err := sessionFromCtx(ctx)
+			if err != nil {
+				return webauthn.RequestOptions{}, err
+			}
+
+			challenge, err := startPasskeyCeremony(sess)
+			if err != nil {
+				return webauthn.RequestOptions{}, err
+			}
+
+			return deps.AuthService.PasskeyRequestOptions(challenge), nil
+		})
+		h.onSuccess = func(r result[struct{}, webauthn.RequestOptions]) error {
+			s.writeJSON(r.w, r.r, r.out)
+			return nil
+		}
+
 		s.publicOnly(route, h)
 	}
+	{
+		const route = "POST /login/passkey"
+		h := newHandler(s, func(ctx context.Context, in webauthn.AssertionResponse) (auth.User, error) {
+			sess, err := sessionFromCtx(ctx)
+			if err != nil {
+				return auth.User{}, err
+			}
+
+			challenge, err := finishPasskeyCeremony(sess)
+			if err != nil {
+				return auth.User{}, err
+			}
+
+			return deps.AuthService.AuthenticatePasskey(ctx, challenge, in)
+		})
+		h.onFail = func(r shared, err error) {
+			s.writeErrorView(r.w, r.r, "login-user", err)
+		}
+		h.onSuccess = func(r result[webauthn.AssertionResponse, auth.User]) error {
+			// Passkeys are verified by the authenticator, no second factor is required.
+			s.logIn(r.shared, r.out)
+			s.writeRedirect(r.w, r.r, "/dashboard", http.StatusFound)
+			return nil
+		}
+
+		s.publicOnly(route, s.limited(route, "login-user", h))
+	}
 
 	// Logout user endpoint
 	{
@@ -154,7 +282,9 @@ func NewServer(deps *ServerDeps, cfg ServerConfig) *Server {
 				return
 			}
 
-			sess.DeleteUserID()
+			// Revoking the session makes sure it can't be used again,
+			// even if the cookie was stolen.
+			sess.Revoke()
 			s.writeRedirect(w, r, "/", http.StatusFound)
 		})
 
@@ -186,7 +316,7 @@ func NewServer(deps *ServerDeps, cfg ServerConfig) *Server {
 			return nil
 		}
 
-		s.publicOnly(route, h)
+		s.publicOnly(route, s.limited(route, "forgot-password", h))
 	}
 
 	// Reset password endpoints
@@ -216,11 +346,735 @@ func NewServer(deps *ServerDeps, cfg ServerConfig) *Server {
 			return nil
 		}
 
-		s.publicOnly(route, h)
+		s.publicOnly(route, s.limited(route, "reset-password", h))
 	}
 
 	// Dashboard endpoints
-	s.loggedIn("GET /dashboard", newViewHandler(s, "dashboard"))
+	{
+		const route = "GET /dashboard"
+
+		type dashboard struct {
+			Inboxes []response.Inbox
+		}
+
+		h := newHandler(s, func(ctx context.Context, _ struct{}) (dashboard, error) {
+			userID, err := userIDFromCtx(ctx)
+			if err != nil {
+				return dashboard{}, err
+			}
+
+			role, err := roleFromCtx(ctx)
+			if err != nil {
+				return dashboard{}, err
+			}
+
+			var d dashboard
+			if role == auth.RoleAgent {
+				d.Inboxes, err = deps.ResponseService.AgentInboxes(ctx, userID)
+				if err != nil {
+					return dashboard{}, err
+				}
+			}
+
+			return d, nil
+		})
+		h.onSuccess = func(r result[struct{}, dashboard]) error {
+			s.writeView(r.w, r.r, "dashboard", r.out)
+			return nil
+		}
+
+		s.loggedIn(route, h)
+	}
+
+	// Active sessions endpoints
+	{
+		const route = "GET /sessions"
+		h := newHandler(s, func(ctx context.Context, _ struct{}) ([]activeSession, error) {
+			userID, err := userIDFromCtx(ctx)
+			if err != nil {
+				return nil, err
+			}
+
+			sess, err := sessionFromCtx(ctx)
+			if err != nil {
+				return nil, err
+			}
+
+			records, err := deps.SessionStore.UserSessions(ctx, userID)
+			if err != nil {
+				return nil, err
+			}
+
+			return newActiveSessions(records, sess.ID()), nil
+		})
+		h.onSuccess = func(r result[struct{}, []activeSession]) error {
+			s.writeView(r.w, r.r, "sessions", r.out)
+			return nil
+		}
+
+		s.loggedIn(route, h)
+	}
+	{
+		const route = "POST /sessions/revoke"
+
+		type sessionRef struct {
+			ID uuid.UUID
+		}
+
+		h := newInputHandler(s, func(ctx context.Context, ref sessionRef) error {
+			userID, err := userIDFromCtx(ctx)
+			if err != nil {
+				return err
+			}
+
+			return deps.SessionStore.RevokeUserSession(ctx, userID, ref.ID)
+		})
+		h.onSuccess = func(r result[sessionRef, struct{}]) error {
+			if r.in.ID == r.sess.ID() {
+				// Revoking the current session is the same as logging out.
+				r.sess.Revoke()
+				s.writeRedirect(r.w, r.r, "/", http.StatusFound)
+				return nil
+			}
+
+			r.sess.AddFlash("The session was signed out.")
+			s.writeRedirect(r.w, r.r, "/sessions", http.StatusFound)
+			return nil
+		}
+
+		s.loggedIn(route, h)
+	}
+
+	// Change email endpoints
+	{
+		const route = "GET /email-change"
+
+		type emailChange struct {
+			Email email.Address
+		}
+
+		h := newHandler(s, func(ctx context.Context, _ struct{}) (emailChange, error) {
+			userID, err := userIDFromCtx(ctx)
+			if err != nil {
+				return emailChange{}, err
+			}
+
+			user, err := deps.AuthService.ActiveUser(ctx, userID)
+			if err != nil {
+				return emailChange{}, err
+			}
+
+			return emailChange{Email: user.Email}, nil
+		})
+		h.onSuccess = func(r result[struct{}, emailChange]) error {
+			s.writeView(r.w, r.r, "change-email", r.out)
+			return nil
+		}
+
+		s.loggedIn(route, h)
+	}
+	{
+		const route = "POST /email-change"
+
+		type emailChange struct {
+			Email email.Address
+		}
+
+		h := newInputHandler(s, func(ctx context.Context, in emailChange) error {
+			userID, err := userIDFromCtx(ctx)
+			if err != nil {
+				return err
+			}
+
+			return deps.AuthService.RequestEmailChange(ctx, userID, in.Email)
+		})
+		h.onFail = func(r shared, err error) {
+			s.writeFlashOrError(r, err, "/email-change")
+		}
+		h.onSuccess = func(r result[emailChange, struct{}]) error {
+			r.sess.AddFlash("Check the inbox of your new email address to confirm the change.")
+			s.writeRedirect(r.w, r.r, "/email-change", http.StatusFound)
+			return nil
+		}
+
+		s.loggedIn(route, h)
+	}
+
+	// Change password endpoints
+	{
+		s.loggedIn("GET /password-change", newViewHandler(s, "change-password"))
+	}
+	{
+		const route = "POST /password-change"
+
+		type passwordChange struct {
+			Current auth.Password
+			New     auth.Password
+		}
+
+		h := newInputHandler(s, func(ctx context.Context, in passwordChange) error {
+			userID, err := userIDFromCtx(ctx)
+			if err != nil {
+				return err
+			}
+
+			return deps.AuthService.ChangePassword(ctx, userID, in.Current, in.New)
+		})
+		h.onFail = func(r shared, err error) {
+			s.writeErrorView(r.w, r.r, "change-password", err)
+		}
+		h.onSuccess = func(r result[passwordChange, struct{}]) error {
+			// All sessions of the user were revoked, a new session keeps
+			// the user logged in on this device.
+			r.sess.Renew()
+			r.sess.AddFlash("Your password was changed, other devices were signed out.")
+			s.writeRedirect(r.w, r.r, "/password-change", http.StatusFound)
+			return nil
+		}
+
+		s.loggedIn(route, s.limited(route, "change-password", h))
+	}
+
+	// Account endpoints
+	{
+		s.loggedIn("GET /account", newViewHandler(s, "account"))
+	}
+	{
+		const route = "GET /account/export"
+		h := newHandler(s, func(ctx context.Context, _ struct{}) (accountExport, error) {
+			userID, err := userIDFromCtx(ctx)
+			if err != nil {
+				return accountExport{}, err
+			}
+
+			var exp accountExport
+			exp.Account, err = deps.AuthService.Export(ctx, userID)
+			if err != nil {
+				return accountExport{}, err
+			}
+
+			records, err := deps.SessionStore.UserSessions(ctx, userID)
+			if err != nil {
+				return accountExport{}, err
+			}
+			exp.Sessions = newSessionExports(records)
+
+			exp.Listings, err = deps.ListingService.AgentListings(ctx, userID)
+			if err != nil {
+				return accountExport{}, err
+			}
+
+			exp.Responses, err = deps.ResponseService.HunterResponses(ctx, userID)
+			if err != nil {
+				return accountExport{}, err
+			}
+
+			return exp, nil
+		})
+		h.onSuccess = func(r result[struct{}, accountExport]) error {
+			s.writeExport(r.w, r.r, r.out)
+			return nil
+		}
+
+		s.loggedIn(route, h)
+	}
+	{
+		const route = "POST /account/delete"
+		h := newInputHandler(s, func(ctx context.Context, _ struct{}) error {
+			userID, err := userIDFromCtx(ctx)
+			if err != nil {
+				return err
+			}
+
+			return deps.AuthService.RequestAccountDeletion(ctx, userID)
+		})
+		h.onSuccess = func(r result[struct{}, struct{}]) error {
+			r.sess.AddFlash("Check your inbox to confirm the deletion of your account.")
+			s.writeRedirect(r.w, r.r, "/account", http.StatusFound)
+			return nil
+		}
+
+		s.loggedIn(route, h)
+	}
+
+	// Confirm account deletion endpoints, these are public because the link
+	// may be opened in a browser where the user is not logged in.
+	{
+		const route = "GET /account-deletions"
+		h := newHandler(s, func(ctx context.Context, token auth.EmailTokenRaw) (auth.EmailTokenRaw, error) {
+			// this target function ensures the input is validated before it's forwared to the view.
+			return token, nil
+		})
+		h.onSuccess = func(r result[auth.EmailTokenRaw, auth.EmailTokenRaw]) error {
+			s.writeView(r.w, r.r, "confirm-account-deletion", r.out)
+			return nil
+		}
+
+		s.public(route, h)
+	}
+	{
+		const route = "POST /account-deletions"
+		h := newInputHandler(s, deps.AuthService.DeleteAccount)
+		h.onFail = func(r shared, err error) {
+			s.writeErrorView(r.w, r.r, "confirm-account-deletion", err)
+		}
+		h.onSuccess = func(r result[auth.EmailTokenRaw, struct{}]) error {
+			if userID, ok := r.sess.UserID(); ok {
+				_, err := deps.AuthService.ActiveUser(r.r.Context(), userID)
+				if errors.Is(err, errorz.ErrNotFound) {
+					// The deleted user was logged in, their sessions no longer exist.
+					// A new session is needed to show the flash message.
+					r.sess.Renew()
+					r.sess.DeleteUserID()
+					r.sess.DeleteRole()
+				} else if err != nil {
+					return err
+				}
+			}
+
+			r.sess.AddFlash("Your account and all of your data were deleted.")
+			s.writeRedirect(r.w, r.r, "/", http.StatusFound)
+			return nil
+		}
+
+		s.public(route, s.limited(route, "confirm-account-deletion", h))
+	}
+
+	// Confirm email change endpoints, these are public because the link
+	// may be opened in a browser where the user is not logged in.
+	{
+		const route = "GET /email-changes"
+		h := newHandler(s, func(ctx context.Context, token auth.EmailTokenRaw) (auth.EmailTokenRaw, error) {
+			// this target function ensures the input is validated before it's forwared to the view.
+			return token, nil
+		})
+		h.onSuccess = func(r result[auth.EmailTokenRaw, auth.EmailTokenRaw]) error {
+			s.writeView(r.w, r.r, "confirm-email-change", r.out)
+			return nil
+		}
+
+		s.public(route, h)
+	}
+	{
+		const route = "POST /email-changes"
+		h := newInputHandler(s, deps.AuthService.ConfirmEmailChange)
+		h.onFail = func(r shared, err error) {
+			s.writeErrorView(r.w, r.r, "confirm-email-change", err)
+		}
+		h.onSuccess = func(r result[auth.EmailTokenRaw, struct{}]) error {
+			r.sess.AddFlash("Your email address was changed.")
+
+			if _, ok := r.sess.UserID(); ok {
+				s.writeRedirect(r.w, r.r, "/email-change", http.StatusFound)
+				return nil
+			}
+
+			s.writeRedirect(r.w, r.r, "/login", http.StatusFound)
+			return nil
+		}
+
+		s.public(route, s.limited(route, "confirm-email-change", h))
+	}
+
+	// Two-factor authentication endpoints
+	{
+		const route = "GET /two-factor"
+
+		type twoFactor struct {
+			Enabled bool
+		}
+
+		h := newHandler(s, func(ctx context.Context, _ struct{}) (twoFactor, error) {
+			userID, err := userIDFromCtx(ctx)
+			if err != nil {
+				return twoFactor{}, err
+			}
+
+			enabled, err := deps.AuthService.TOTPEnabled(ctx, userID)
+			if err != nil {
+				return twoFactor{}, err
+			}
+
+			return twoFactor{Enabled: enabled}, nil
+		})
+		h.onSuccess = func(r result[struct{}, twoFactor]) error {
+			s.writeView(r.w, r.r, "two-factor", r.out)
+			return nil
+		}
+
+		s.loggedIn(route, h)
+	}
+	{
+		const route = "GET /two-factor/enroll"
+		h := newHandler(s, func(ctx context.Context, _ struct{}) (auth.TOTPEnrollment, error) {
+			userID, err := userIDFromCtx(ctx)
+			if err != nil {
+				return auth.TOTPEnrollment{}, err
+			}
+
+			return deps.AuthService.EnrollTOTP(ctx, userID)
+		})
+		h.onFail = func(r shared, err error) {
+			if errors.Is(err, auth.ErrTOTPEnabled) {
+				s.writeRedirect(r.w, r.r, "/two-factor", http.StatusFound)
+				return
+			}
+
+			s.writeError(r.w, r.r, err)
+		}
+		h.onSuccess = func(r result[struct{}, auth.TOTPEnrollment]) error {
+			s.writeView(r.w, r.r, "enroll-two-factor", newTOTPEnrollment(r.out))
+			return nil
+		}
+
+		s.loggedIn(route, h)
+	}
+	{
+		const route = "POST /two-factor/confirm"
+		h := newHandler(s, func(ctx context.Context, in secondFactor) ([]krypto.RecoveryCode, error) {
+			userID, err := userIDFromCtx(ctx)
+			if err != nil {
+				return nil, err
+			}
+
+			return deps.AuthService.ConfirmTOTP(ctx, userID, in.Code)
+		})
+		h.onFail = func(r shared, err error) {
+			s.writeFlashOrError(r, err, "/two-factor/enroll")
+		}
+		h.onSuccess = func(r result[secondFactor, []krypto.RecoveryCode]) error {
+			// The recovery codes are shown once, they can't be retrieved later.
+			s.writeView(r.w, r.r, "recovery-codes", r.out)
+			return nil
+		}
+
+		s.loggedIn(route, h)
+	}
+	{
+		const route = "POST /two-factor/disable"
+		h := newInputHandler(s, func(ctx context.Context, in secondFactor) error {
+			userID, err := userIDFromCtx(ctx)
+			if err != nil {
+				return err
+			}
+
+			return deps.AuthService.DisableTOTP(ctx, userID, in.Code)
+		})
+		h.onFail = func(r shared, err error) {
+			s.writeFlashOrError(r, err, "/two-factor")
+		}
+		h.onSuccess = func(r result[secondFactor, struct{}]) error {
+			r.sess.AddFlash("Two-factor authentication was disabled.")
+			s.writeRedirect(r.w, r.r, "/two-factor", http.StatusFound)
+			return nil
+		}
+
+		s.loggedIn(route, h)
+	}
+
+	// Passkey endpoints
+	{
+		const route = "GET /passkeys"
+		h := newHandler(s, func(ctx context.Context, _ struct{}) ([]auth.Passkey, error) {
+			userID, err := userIDFromCtx(ctx)
+			if err != nil {
+				return nil, err
+			}
+
+			return deps.AuthService.Passkeys(ctx, userID)
+		})
+		h.onSuccess = func(r result[struct{}, []auth.Passkey]) error {
+			s.writeView(r.w, r.r, "passkeys", r.out)
+			return nil
+		}
+
+		s.loggedIn(route, h)
+	}
+	{
+		const route = "GET /passkeys/options"
+		h := newHandler(s, func(ctx context.Context, _ struct{}) (webauthn.CreationOptions, error) {
+			userID, err := userIDFromCtx(ctx)
+			if err != nil {
+				return webauthn.CreationOptions{}, err
+			}
+
+			sess, err := sessionFromCtx(ctx)
+			if err != nil {
+				return webauthn.CreationOptions{}, err
+			}
+
+			challenge, err := startPasskeyCeremony(sess)
+			if err != nil {
+				return webauthn.CreationOptions{}, err
+			}
+
+			return deps.AuthService.PasskeyCreationOptions(ctx, userID, challenge)
+		})
+		h.onSuccess = func(r result[struct{}, webauthn.CreationOptions]) error {
+			s.writeJSON(r.w, r.r, r.out)
+			return nil
+		}
+
+		s.loggedIn(route, h)
+	}
+	{
+		const route = "POST /passkeys"
+		h := newInputHandler(s, func(ctx context.Context, in webauthn.RegistrationResponse) error {
+			userID, err := userIDFromCtx(ctx)
+			if err != nil {
+				return err
+			}
+
+			sess, err := sessionFromCtx(ctx)
+			if err != nil {
+				return err
+			}
+
+			challenge, err := finishPasskeyCeremony(sess)
+			if err != nil {
+				return err
+			}
+
+			_, err = deps.AuthService.RegisterPasskey(ctx, userID, challenge, in)
+			return err
+		})
+		h.onFail = func(r shared, err error) {
+			s.writeFlashOrError(r, err, "/passkeys")
+		}
+		h.onSuccess = func(r result[webauthn.RegistrationResponse, struct{}]) error {
+			r.sess.AddFlash("The passkey was added, you can use it to login.")
+			s.writeRedirect(r.w, r.r, "/passkeys", http.StatusFound)
+			return nil
+		}
+
+		s.loggedIn(route, h)
+	}
+	{
+		const route = "POST /passkeys/delete"
+
+		type passkeyRef struct {
+			ID uuid.UUID
+		}
+
+		h := newInputHandler(s, func(ctx context.Context, ref passkeyRef) error {
+			userID, err := userIDFromCtx(ctx)
+			if err != nil {
+				return err
+			}
+
+			return deps.AuthService.DeletePasskey(ctx, userID, ref.ID)
+		})
+		h.onSuccess = func(r result[passkeyRef, struct{}]) error {
+			r.sess.AddFlash("The passkey was removed.")
+			s.writeRedirect(r.w, r.r, "/passkeys", http.StatusFound)
+			return nil
+		}
+
+		s.loggedIn(route, h)
+	}
+
+	// API token endpoints
+	{
+		const route = "GET /api-tokens"
+		h := newHandler(s, s.apiTokens)
+		h.onSuccess = func(r result[struct{}, apiTokensPage]) error {
+			s.writeView(r.w, r.r, "api-tokens", r.out)
+			return nil
+		}
+
+		s.loggedIn(route, h)
+	}
+	{
+		const route = "POST /api-tokens"
+		h := newHandler(s, s.createAPIToken)
+		h.onFail = func(r shared, err error) {
+			s.writeFlashOrError(r, err, "/api-tokens")
+		}
+		h.onSuccess = func(r result[auth.NewAPIToken, createdAPIToken]) error {
+			// The token is shown once, only its hash is stored.
+			s.writeView(r.w, r.r, "api-token-created", r.out)
+			return nil
+		}
+
+		s.loggedIn(route, h)
+	}
+	{
+		const route = "POST /api-tokens/delete"
+
+		type apiTokenRef struct {
+			ID uuid.UUID
+		}
+
+		h := newInputHandler(s, func(ctx context.Context, ref apiTokenRef) error {
+			userID, err := userIDFromCtx(ctx)
+			if err != nil {
+				return err
+			}
+
+			return deps.AuthService.DeleteAPIToken(ctx, userID, ref.ID)
+		})
+		h.onSuccess = func(r result[apiTokenRef, struct{}]) error {
+			r.sess.AddFlash("The API token was deleted, it can no longer be used.")
+			s.writeRedirect(r.w, r.r, "/api-tokens", http.StatusFound)
+			return nil
+		}
+
+		s.loggedIn(route, h)
+	}
+
+	// Create listing endpoints
+	{
+		s.role("GET /listings/new", newViewHandler(s, "create-listing"), auth.RoleAgent)
+	}
+	{
+		const route = "POST /listings"
+		h := newHandler(s, s.createListing)
+		h.onFail = func(r shared, err error) {
+			s.writeErrorView(r.w, r.r, "create-listing", err)
+		}
+		h.onSuccess = func(r result[listing.Details, listing.Listing]) error {
+			r.sess.AddFlash("Your listing was created. House hunters can't see it until you publish it.")
+			s.writeRedirect(r.w, r.r, editListingURL(r.out.ID), http.StatusFound)
+			return nil
+		}
+
+		s.role(route, h, auth.RoleAgent)
+	}
+
+	// Edit listing endpoints
+	{
+		const route = "GET /listings/edit"
+		h := newHandler(s, s.agentListing)
+		h.onSuccess = func(r result[listingRef, listing.Listing]) error {
+			s.writeView(r.w, r.r, "edit-listing", r.out)
+			return nil
+		}
+
+		s.role(route, h, auth.RoleAgent)
+	}
+	{
+		const route = "POST /listings/edit"
+		h := newHandler(s, s.updateListing)
+		h.onFail = func(r shared, err error) {
+			s.writeErrorView(r.w, r.r, "edit-listing", err)
+		}
+		h.onSuccess = func(r result[listingEdit, listing.Listing]) error {
+			r.sess.AddFlash("Your listing was saved.")
+			s.writeRedirect(r.w, r.r, editListingURL(r.out.ID), http.StatusFound)
+			return nil
+		}
+
+		s.role(route, h, auth.RoleAgent)
+	}
+
+	// Publish and unpublish listing endpoints
+	{
+		const route = "POST /listings/publish"
+		h := newHandler(s, s.publishListing)
+		h.onSuccess = func(r result[listingRef, listing.Listing]) error {
+			r.sess.AddFlash("Your listing was published.")
+			s.writeRedirect(r.w, r.r, editListingURL(r.out.ID), http.StatusFound)
+			return nil
+		}
+
+		s.role(route, h, auth.RoleAgent)
+	}
+	{
+		const route = "POST /listings/unpublish"
+		h := newHandler(s, s.unpublishListing)
+		h.onSuccess = func(r result[listingRef, listing.Listing]) error {
+			r.sess.AddFlash("Your listing was unpublished.")
+			s.writeRedirect(r.w, r.r, editListingURL(r.out.ID), http.StatusFound)
+			return nil
+		}
+
+		s.role(route, h, auth.RoleAgent)
+	}
+
+	// Delete listing endpoint
+	{
+		const route = "POST /listings/delete"
+		h := newInputHandler(s, s.deleteListing)
+		h.onSuccess = func(r result[listingRef, struct{}]) error {
+			r.sess.AddFlash("Your listing was deleted.")
+			s.writeRedirect(r.w, r.r, "/dashboard", http.StatusFound)
+			return nil
+		}
+
+		s.role(route, h, auth.RoleAgent)
+	}
+
+	// Browse listings endpoints
+	{
+		const route = "GET /listings"
+		h := newHandler(s, s.publishedListings)
+		h.onSuccess = func(r result[struct{}, []listing.Listing]) error {
+			s.writeView(r.w, r.r, "listings", r.out)
+			return nil
+		}
+
+		s.role(route, h, auth.RoleHunter)
+	}
+	{
+		const route = "GET /listings/view"
+		h := newHandler(s, s.publishedListing)
+		h.onSuccess = func(r result[listingRef, listing.Listing]) error {
+			s.writeView(r.w, r.r, "view-listing", r.out)
+			return nil
+		}
+
+		s.role(route, h, auth.RoleHunter)
+	}
+
+	// Respond to listing endpoint
+	{
+		const route = "POST /responses"
+		h := newHandler(s, s.respond)
+		h.onFail = func(r shared, err error) {
+			s.writeErrorView(r.w, r.r, "view-listing", err)
+		}
+		h.onSuccess = func(r result[response.Submission, response.Response]) error {
+			r.sess.AddFlash("Your response was sent to the agent.")
+			s.writeRedirect(r.w, r.r, viewListingURL(r.out.ListingID), http.StatusFound)
+			return nil
+		}
+
+		s.role(route, h, auth.RoleHunter)
+	}
+
+	// Listing responses endpoints
+	{
+		const route = "GET /listings/responses"
+		h := newHandler(s, s.agentInbox)
+		h.onSuccess = func(r result[listingRef, response.Inbox]) error {
+			s.writeView(r.w, r.r, "listing-responses", r.out)
+			return nil
+		}
+
+		s.role(route, h, auth.RoleAgent)
+	}
+	{
+		const route = "POST /responses/status"
+		h := newHandler(s, s.updateResponseStatus)
+		h.onSuccess = func(r result[response.StatusUpdate, response.Response]) error {
+			r.sess.AddFlash("The response was marked as " + string(r.out.Status) + ".")
+			s.writeRedirect(r.w, r.r, listingResponsesURL(r.out.ListingID), http.StatusFound)
+			return nil
+		}
+
+		s.role(route, h, auth.RoleAgent)
+	}
+
+	// JSON API endpoints.
+	s.registerAPI()
+
+	// Webhook endpoints.
+	s.registerWebhooks(cfg)
+
+	// Development mailbox endpoints.
+	if deps.Mailbox != nil {
+		s.registerMailbox()
+	}
 
 	// Static frontend files endpoint.
 	s.mux.Handle("/static/", http.StripPrefix("/static/", http.FileServer(s.deps.DistFS)))
@@ -231,11 +1085,16 @@ func NewServer(deps *ServerDeps, cfg ServerConfig) *Server {
 	csrfMW := csrf.Protect(
 		cfg.CSRFKey.SecretValue(),
 		csrf.CookieName(csrfTokenCookieName),
+		// Without an explicit path the cookie is scoped to the path it was issued from,
+		// which breaks forms that post to a different part of the site.
+		csrf.Path("/"),
 		csrf.FieldName(csrfTokenField),
 		csrf.Secure(cfg.SecureCookie),
 	)
 
 	middlewares := []func(http.Handler) http.Handler{
+		bearerMiddleware(s),
+		webhookMiddleware,
 		csrfMW,
 		sessionMiddleware(s),
 	}
@@ -281,6 +1140,23 @@ func (s *Server) writeRedirect(w http.ResponseWriter, r *http.Request, url strin
 	http.Redirect(w, r, url, code)
 }
 
+func (s *Server) writeJSON(w http.ResponseWriter, r *http.Request, data any) {
+	s.writeJSONStatus(w, r, http.StatusOK, data)
+}
+
+func (s *Server) writeJSONStatus(w http.ResponseWriter, r *http.Request, code int, data any) {
+	if !s.preWrite(w, r) {
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	err := json.NewEncoder(w).Encode(data)
+	if err != nil {
+		s.deps.Logger.Error("failed to encode json", "error", err)
+	}
+}
+
 func (s *Server) writeView(w http.ResponseWriter, r *http.Request, name string, data any) {
 	vd := s.prepViewData(r, w, data)
 	if vd == nil {
@@ -310,6 +1186,13 @@ func (s *Server) writeErrorView(w http.ResponseWriter, r *http.Request, name str
 		return
 	}
 
+	if errors.Is(err, errorz.ErrRateLimited) {
+		vd.InputErrors = errorz.InvalidInput{errorz.ErrRateLimited}
+		w.WriteHeader(http.StatusTooManyRequests)
+		s.renderView(w, name, vd)
+		return
+	}
+
 	var invalidInput errorz.InvalidInput
 	if errors.As(err, &invalidInput) {
 		vd.InputErrors = invalidInput
@@ -327,6 +1210,23 @@ func (s *Server) writeError(w http.ResponseWriter, r *http.Request, err error) {
 	s.writeErrorView(w, r, "error", err)
 }
 
+// writeFlashOrError adds invalid input errors as flashes and redirects to url, which
+// is useful for forms on pages that can't be rendered without their data. Other errors
+// are written using writeError.
+func (s *Server) writeFlashOrError(r shared, err error, url string) {
+	var invalidInput errorz.InvalidInput
+	if !errors.As(err, &invalidInput) {
+		s.writeError(r.w, r.r, err)
+		return
+	}
+
+	for _, e := range invalidInput {
+		r.sess.AddFlash(e.Error())
+	}
+
+	s.writeRedirect(r.w, r.r, url, http.StatusFound)
+}
+
 func (s *Server) renderView(w http.ResponseWriter, name string, vd *viewData) {
 	w.Header().Set("Content-Type", "text/html; charset=utf-8")
 	err := s.deps.ViewRenderer.Render(w, name, vd)
@@ -4,14 +4,25 @@ import (
 	"context"
 	"fmt"
 	"net/http"
+	"time"
 
+	"github.com/google/uuid"
+	"github.com/willemschots/househunt/internal/auth"
+	"github.com/willemschots/househunt/internal/errorz"
 	"github.com/willemschots/househunt/internal/web/sessions"
 )
 
 // session is a middleware that creates a session and injects it in the context.
+// Requests that already have a session, such as requests authenticated by an API token,
+// are passed on as is.
 func sessionMiddleware(srv *Server) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if _, err := sessionFromCtx(r.Context()); err == nil {
+				next.ServeHTTP(w, r)
+				return
+			}
+
 			sess, err := srv.deps.SessionStore.Get(r)
 			if err != nil {
 				srv.writeError(w, r, err)
@@ -40,3 +51,61 @@ func sessionFromCtx(ctx context.Context) (*sessions.Session, error) {
 
 	return sess, nil
 }
+
+// userIDFromCtx returns the ID of the logged in user. It returns errorz.ErrNotFound
+// if no user is logged in.
+func userIDFromCtx(ctx context.Context) (uuid.UUID, error) {
+	sess, err := sessionFromCtx(ctx)
+	if err != nil {
+		return uuid.Nil, err
+	}
+
+	userID, ok := sess.UserID()
+	if !ok {
+		return uuid.Nil, errorz.ErrNotFound
+	}
+
+	return userID, nil
+}
+
+// roleFromCtx returns the role of the logged in user. It returns errorz.ErrNotFound
+// if no user is logged in.
+func roleFromCtx(ctx context.Context) (auth.Role, error) {
+	sess, err := sessionFromCtx(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	raw, ok := sess.Role()
+	if !ok {
+		return "", errorz.ErrNotFound
+	}
+
+	return auth.ParseRole(raw)
+}
+
+// activeSession is a session of the logged in user as shown on the sessions page.
+type activeSession struct {
+	ID         uuid.UUID
+	UserAgent  string
+	IP         string
+	CreatedAt  time.Time
+	LastSeenAt time.Time
+	IsCurrent  bool
+}
+
+func newActiveSessions(records []sessions.Record, currentID uuid.UUID) []activeSession {
+	out := make([]activeSession, 0, len(records))
+	for _, r := range records {
+		out = append(out, activeSession{
+			ID:         r.ID,
+			UserAgent:  r.UserAgent,
+			IP:         r.IP,
+			CreatedAt:  r.CreatedAt,
+			LastSeenAt: r.LastSeenAt,
+			IsCurrent:  r.ID == currentID,
+		})
+	}
+
+	return out
+}
@@ -0,0 +1,174 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+
+	"github.com/google/uuid"
+	"github.com/willemschots/househunt/internal/db"
+	"github.com/willemschots/househunt/internal/errorz"
+	"github.com/willemschots/househunt/internal/web/sessions"
+)
+
+type execFunc func(query string, params ...any) (sql.Result, error)
+type queryFunc func(query string, params ...any) (*sql.Rows, error)
+
+func insertRecord(q db.Query, ef execFunc, r sessions.Record) error {
+	if r.ID == uuid.Nil {
+		return fmt.Errorf("zero uuid provided: %w", errorz.ErrConstraintViolated)
+	}
+
+	q.Unsafe(`INSERT INTO sessions (id, user_id, data, user_agent, ip, created_at, updated_at, last_seen_at, expires_at) VALUES (`)
+	q.Params(r.ID, r.UserID, r.Data, r.UserAgent, r.IP, r.CreatedAt, r.UpdatedAt, r.LastSeenAt, r.ExpiresAt.UTC())
+	q.Unsafe(`)`)
+
+	s, params, err := q.Get()
+	if err != nil {
+		return err
+	}
+
+	_, err = ef(s, params...)
+	if err != nil {
+		return errorz.MapDBErr(err)
+	}
+
+	return nil
+}
+
+// updateRecord never updates the created_at column.
+func updateRecord(q db.Query, ef execFunc, r sessions.Record) error {
+	q.Unsafe(`UPDATE sessions SET `)
+
+	q.Unsafe(`user_id = `)
+	q.Param(r.UserID)
+
+	q.Unsafe(`, data = `)
+	q.Param(r.Data)
+
+	q.Unsafe(`, user_agent = `)
+	q.Param(r.UserAgent)
+
+	q.Unsafe(`, ip = `)
+	q.Param(r.IP)
+
+	q.Unsafe(`, updated_at = `)
+	q.Param(r.UpdatedAt)
+
+	q.Unsafe(`, last_seen_at = `)
+	q.Param(r.LastSeenAt)
+
+	q.Unsafe(`, expires_at = `)
+	q.Param(r.ExpiresAt.UTC())
+
+	q.Unsafe(` WHERE id = `)
+	q.Param(r.ID)
+
+	s, params, err := q.Get()
+	if err != nil {
+		return err
+	}
+
+	result, err := ef(s, params...)
+	if err != nil {
+		return errorz.MapDBErr(err)
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return errorz.MapDBErr(err)
+	}
+
+	if rows == 0 {
+		return fmt.Errorf("session not found: %w", errorz.ErrNotFound)
+	}
+
+	return nil
+}
+
+func deleteRecords(q db.Query, ef execFunc, f sessions.RecordFilter) error {
+	q.Unsafe(`DELETE FROM sessions WHERE 1=1 `)
+	whereRecords(&q, f)
+
+	s, params, err := q.Get()
+	if err != nil {
+		return err
+	}
+
+	_, err = ef(s, params...)
+	if err != nil {
+		return errorz.MapDBErr(err)
+	}
+
+	return nil
+}
+
+func selectRecords(q db.Query, qf queryFunc, f sessions.RecordFilter) ([]sessions.Record, error) {
+	q.Unsafe(`SELECT id, user_id, data, user_agent, ip, created_at, updated_at, last_seen_at, expires_at FROM sessions WHERE 1=1 `)
+	whereRecords(&q, f)
+	q.Unsafe(`ORDER BY created_at ASC, id ASC`)
+
+	s, params, err := q.Get()
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := qf(s, params...)
+	if err != nil {
+		return nil, errorz.MapDBErr(err)
+	}
+
+	defer rows.Close()
+
+	out := make([]sessions.Record, 0)
+	for rows.Next() {
+		var r sessions.Record
+		err := rows.Scan(&r.ID, &r.UserID, &r.Data, &r.UserAgent, &r.IP, &r.CreatedAt, &r.UpdatedAt, &r.LastSeenAt, &r.ExpiresAt)
+		if err != nil {
+			return nil, errorz.MapDBErr(err)
+		}
+
+		out = append(out, r)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, errorz.MapDBErr(err)
+	}
+
+	return out, nil
+}
+
+func whereRecords(q *db.Query, f sessions.RecordFilter) {
+	if len(f.IDs) > 0 {
+		q.Unsafe(`AND id IN (`)
+		q.Params(anySlice(f.IDs)...)
+		q.Unsafe(`) `)
+	}
+
+	if len(f.UserIDs) > 0 {
+		q.Unsafe(`AND user_id IN (`)
+		q.Params(anySlice(f.UserIDs)...)
+		q.Unsafe(`) `)
+	}
+
+	if f.ExpiresAfter != nil {
+		// expires_at is always stored in UTC, so that it can be compared as text.
+		q.Unsafe(`AND expires_at > `)
+		q.Param(f.ExpiresAfter.UTC())
+		q.Unsafe(` `)
+	}
+
+	if f.ExpiresBefore != nil {
+		// expires_at is always stored in UTC, so that it can be compared as text.
+		q.Unsafe(`AND expires_at <= `)
+		q.Param(f.ExpiresBefore.UTC())
+		q.Unsafe(` `)
+	}
+}
+
+func anySlice[T any](s []T) []any {
+	out := make([]any, 0, len(s))
+	for _, v := range s {
+		out = append(out, v)
+	}
+	return out
+}
@@ -0,0 +1,73 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/willemschots/househunt/internal/db"
+	"github.com/willemschots/househunt/internal/web/sessions"
+)
+
+// Store is responsible for interacting with a database.
+type Store struct {
+	writeDB *sql.DB
+	readDB  *sql.DB
+}
+
+// New creates a new Store.
+func New(writeDB, readDB *sql.DB) *Store {
+	return &Store{
+		writeDB: writeDB,
+		readDB:  readDB,
+	}
+}
+
+// CreateRecord creates a session record in the database.
+func (s *Store) CreateRecord(ctx context.Context, r sessions.Record) error {
+	return insertRecord(db.Query{}, func(query string, params ...any) (sql.Result, error) {
+		return s.writeDB.ExecContext(ctx, query, params...)
+	}, r)
+}
+
+// UpdateRecord updates a session record in the database.
+// It returns errorz.ErrNotFound if no record is found.
+func (s *Store) UpdateRecord(ctx context.Context, r sessions.Record) error {
+	return updateRecord(db.Query{}, func(query string, params ...any) (sql.Result, error) {
+		return s.writeDB.ExecContext(ctx, query, params...)
+	}, r)
+}
+
+// DeleteRecords deletes the session records matching the provided filter.
+func (s *Store) DeleteRecords(ctx context.Context, filter sessions.RecordFilter) error {
+	return deleteRecords(db.Query{}, func(query string, params ...any) (sql.Result, error) {
+		return s.writeDB.ExecContext(ctx, query, params...)
+	}, filter)
+}
+
+// FindRecords queries for session records based on the provided filter.
+// It returns an empty slice if no records are found.
+func (s *Store) FindRecords(ctx context.Context, filter sessions.RecordFilter) ([]sessions.Record, error) {
+	return selectRecords(db.Query{}, func(query string, params ...any) (*sql.Rows, error) {
+		return s.readDB.QueryContext(ctx, query, params...)
+	}, filter)
+}
+
+// DeleteUserRecords deletes all session records of a user as part of an existing
+// transaction. This allows other stores to revoke sessions in the same transaction
+// as the changes that caused them.
+func DeleteUserRecords(tx *sql.Tx, userID uuid.UUID) error {
+	return deleteRecords(db.Query{}, tx.Exec, sessions.RecordFilter{
+		UserIDs: []uuid.UUID{userID},
+	})
+}
+
+// DeleteExpiredRecords deletes all session records that expired at or before now as
+// part of an existing transaction. This allows other stores to clean up sessions
+// together with the other data they clean up.
+func DeleteExpiredRecords(tx *sql.Tx, now time.Time) error {
+	return deleteRecords(db.Query{}, tx.Exec, sessions.RecordFilter{
+		ExpiresBefore: &now,
+	})
+}
@@ -0,0 +1,454 @@
+package db_test
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/willemschots/househunt/internal/db/testdb"
+	"github.com/willemschots/househunt/internal/errorz"
+	"github.com/willemschots/househunt/internal/web/sessions"
+	"github.com/willemschots/househunt/internal/web/sessions/db"
+)
+
+var (
+	user1 = must(uuid.Parse("0e61a06e-bbf6-4b87-aaaa-75fee0f38cca"))
+	user2 = must(uuid.Parse("8a1b2c3d-4e5f-4a6b-8c7d-9e0f1a2b3c4d"))
+)
+
+func Test_Store_CreateRecord(t *testing.T) {
+	t.Run("ok, create record", func(t *testing.T) {
+		store, _ := storeForTest(t)
+		r := newRecord(t, nil)
+
+		err := store.CreateRecord(context.Background(), r)
+		if err != nil {
+			t.Fatalf("failed to save record: %v", err)
+		}
+
+		assertFindRecord(t, store, r)
+	})
+
+	t.Run("ok, create record without user", func(t *testing.T) {
+		store, _ := storeForTest(t)
+		r := newRecord(t, func(r *sessions.Record) {
+			r.UserID = nil
+		})
+
+		err := store.CreateRecord(context.Background(), r)
+		if err != nil {
+			t.Fatalf("failed to save record: %v", err)
+		}
+
+		assertFindRecord(t, store, r)
+	})
+
+	t.Run("fail, duplicate ID", func(t *testing.T) {
+		store, _ := storeForTest(t)
+		r := newRecord(t, nil)
+
+		err := store.CreateRecord(context.Background(), r)
+		if err != nil {
+			t.Fatalf("failed to save record: %v", err)
+		}
+
+		err = store.CreateRecord(context.Background(), r)
+		if !errors.Is(err, errorz.ErrConstraintViolated) {
+			t.Fatalf("expected errors to be %v got %v (via errors.Is)", errorz.ErrConstraintViolated, err)
+		}
+	})
+
+	t.Run("fail, zero ID", func(t *testing.T) {
+		store, _ := storeForTest(t)
+		r := newRecord(t, func(r *sessions.Record) {
+			r.ID = uuid.Nil
+		})
+
+		err := store.CreateRecord(context.Background(), r)
+		if !errors.Is(err, errorz.ErrConstraintViolated) {
+			t.Fatalf("expected errors to be %v got %v (via errors.Is)", errorz.ErrConstraintViolated, err)
+		}
+	})
+
+	t.Run("fail, unknown user", func(t *testing.T) {
+		store, _ := storeForTest(t)
+		r := newRecord(t, func(r *sessions.Record) {
+			r.UserID = ptr(must(uuid.Parse("4516a1c0-efc3-4561-9e97-e749e008aa3f")))
+		})
+
+		err := store.CreateRecord(context.Background(), r)
+		if !errors.Is(err, errorz.ErrConstraintViolated) {
+			t.Fatalf("expected errors to be %v got %v (via errors.Is)", errorz.ErrConstraintViolated, err)
+		}
+	})
+}
+
+func Test_Store_UpdateRecord(t *testing.T) {
+	setup := func(t *testing.T) (*db.Store, sessions.Record) {
+		store, _ := storeForTest(t)
+		r := newRecord(t, func(r *sessions.Record) {
+			r.UserID = nil
+		})
+
+		err := store.CreateRecord(context.Background(), r)
+		if err != nil {
+			t.Fatalf("failed to save record: %v", err)
+		}
+
+		return store, r
+	}
+
+	t.Run("ok, update record", func(t *testing.T) {
+		store, r := setup(t)
+
+		// Update all fields that can be modified.
+		r.UserID = ptr(user1)
+		r.Data = []byte("other data")
+		r.UserAgent = "curl/8.5.0"
+		r.IP = "2001:db8::/48"
+		r.UpdatedAt = now(t, 1)
+		r.LastSeenAt = now(t, 2)
+		r.ExpiresAt = now(t, 9)
+
+		err := store.UpdateRecord(context.Background(), r)
+		if err != nil {
+			t.Fatalf("failed to update record: %v", err)
+		}
+
+		assertFindRecord(t, store, r)
+	})
+
+	t.Run("ok, created at is not updated", func(t *testing.T) {
+		store, r := setup(t)
+		want := r
+
+		r.CreatedAt = now(t, 1)
+
+		err := store.UpdateRecord(context.Background(), r)
+		if err != nil {
+			t.Fatalf("failed to update record: %v", err)
+		}
+
+		assertFindRecord(t, store, want)
+	})
+
+	t.Run("fail, not found", func(t *testing.T) {
+		store, r := setup(t)
+
+		r.ID = must(uuid.Parse("4516a1c0-efc3-4561-9e97-e749e008aa3f"))
+
+		err := store.UpdateRecord(context.Background(), r)
+		if !errors.Is(err, errorz.ErrNotFound) {
+			t.Fatalf("expected errors to be %v got %v (via errors.Is)", errorz.ErrNotFound, err)
+		}
+	})
+}
+
+func Test_Store_FindAndDeleteRecords(t *testing.T) {
+	setupRecords := func(t *testing.T, store *db.Store) []sessions.Record {
+		// Records are ordered by their creation time.
+		records := []sessions.Record{
+			newRecord(t, nil),
+			newRecord(t, func(r *sessions.Record) {
+				r.ID = must(uuid.Parse("4516a1c0-efc3-4561-9e97-e749e008aa3f"))
+				r.UserID = ptr(user2)
+				r.CreatedAt = now(t, 1)
+				r.ExpiresAt = now(t, 2)
+			}),
+			newRecord(t, func(r *sessions.Record) {
+				r.ID = must(uuid.Parse("c4a3d7c4-8e6e-4a3b-bb8a-2f7c3e1b5f6d"))
+				r.UserID = nil
+				r.CreatedAt = now(t, 2)
+				// Times in other timezones are compared correctly.
+				r.ExpiresAt = now(t, 3).In(time.FixedZone("UTC+2", 2*60*60))
+			}),
+		}
+
+		for i := range records {
+			err := store.CreateRecord(context.Background(), records[i])
+			if err != nil {
+				t.Fatalf("failed to save record: %v", err)
+			}
+
+			// expires_at is returned in UTC.
+			records[i].ExpiresAt = records[i].ExpiresAt.UTC()
+		}
+
+		return records
+	}
+
+	tests := map[string]struct {
+		filter   sessions.RecordFilter
+		wantFunc func([]sessions.Record) []sessions.Record
+	}{
+		"ok, all records, empty slices": {
+			filter: sessions.RecordFilter{
+				IDs:     []uuid.UUID{},
+				UserIDs: []uuid.UUID{},
+			},
+			wantFunc: func(records []sessions.Record) []sessions.Record {
+				return records
+			},
+		},
+		"ok, several by id": {
+			filter: sessions.RecordFilter{
+				IDs: []uuid.UUID{
+					must(uuid.Parse("42bf8943-2ffc-43d9-8682-ca8fc4d7cb8e")),
+					must(uuid.Parse("c4a3d7c4-8e6e-4a3b-bb8a-2f7c3e1b5f6d")),
+				},
+			},
+			wantFunc: func(records []sessions.Record) []sessions.Record {
+				return []sessions.Record{records[0], records[2]}
+			},
+		},
+		"ok, by user id": {
+			filter: sessions.RecordFilter{
+				UserIDs: []uuid.UUID{user2},
+			},
+			wantFunc: func(records []sessions.Record) []sessions.Record {
+				return records[1:2]
+			},
+		},
+		"ok, expires after": {
+			filter: sessions.RecordFilter{
+				ExpiresAfter: ptr(now(t, 2).In(time.FixedZone("UTC-2", -2*60*60))),
+			},
+			wantFunc: func(records []sessions.Record) []sessions.Record {
+				return []sessions.Record{records[0], records[2]}
+			},
+		},
+		"ok, expires before": {
+			filter: sessions.RecordFilter{
+				ExpiresBefore: ptr(now(t, 3).In(time.FixedZone("UTC-2", -2*60*60))),
+			},
+			wantFunc: func(records []sessions.Record) []sessions.Record {
+				return records[1:3]
+			},
+		},
+		"ok, combine filters": {
+			filter: sessions.RecordFilter{
+				UserIDs:      []uuid.UUID{user1, user2},
+				ExpiresAfter: ptr(now(t, 2)),
+			},
+			wantFunc: func(records []sessions.Record) []sessions.Record {
+				return records[0:1]
+			},
+		},
+		"ok, no results": {
+			filter: sessions.RecordFilter{
+				IDs: []uuid.UUID{uuid.Nil},
+			},
+			wantFunc: func(records []sessions.Record) []sessions.Record {
+				return []sessions.Record{}
+			},
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			store, _ := storeForTest(t)
+
+			records := setupRecords(t, store)
+			want := tc.wantFunc(records)
+
+			got, err := store.FindRecords(context.Background(), tc.filter)
+			if err != nil {
+				t.Fatalf("failed to find records: %v", err)
+			}
+
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("got\n%#v\nwant\n%#v\n", got, want)
+			}
+
+			// deleting with the same filter should delete the found records.
+			err = store.DeleteRecords(context.Background(), tc.filter)
+			if err != nil {
+				t.Fatalf("failed to delete records: %v", err)
+			}
+
+			remaining, err := store.FindRecords(context.Background(), sessions.RecordFilter{})
+			if err != nil {
+				t.Fatalf("failed to find records: %v", err)
+			}
+
+			if len(remaining) != len(records)-len(want) {
+				t.Errorf("expected %d remaining records, got %d", len(records)-len(want), len(remaining))
+			}
+		})
+	}
+}
+
+func Test_DeleteUserRecords(t *testing.T) {
+	store, testDB := storeForTest(t)
+
+	for _, r := range []sessions.Record{
+		newRecord(t, nil),
+		newRecord(t, func(r *sessions.Record) {
+			r.ID = must(uuid.Parse("4516a1c0-efc3-4561-9e97-e749e008aa3f"))
+			r.UserID = ptr(user2)
+		}),
+	} {
+		err := store.CreateRecord(context.Background(), r)
+		if err != nil {
+			t.Fatalf("failed to save record: %v", err)
+		}
+	}
+
+	tx, err := testDB.Begin()
+	if err != nil {
+		t.Fatalf("failed to begin tx: %v", err)
+	}
+
+	err = db.DeleteUserRecords(tx, user1)
+	if err != nil {
+		t.Fatalf("failed to delete records: %v", err)
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		t.Fatalf("failed to commit tx: %v", err)
+	}
+
+	got, err := store.FindRecords(context.Background(), sessions.RecordFilter{})
+	if err != nil {
+		t.Fatalf("failed to find records: %v", err)
+	}
+
+	if len(got) != 1 || *got[0].UserID != user2 {
+		t.Fatalf("expected only the record of user 2 to remain, got %#v", got)
+	}
+}
+
+func Test_DeleteExpiredRecords(t *testing.T) {
+	store, testDB := storeForTest(t)
+
+	for _, r := range []sessions.Record{
+		newRecord(t, nil),
+		newRecord(t, func(r *sessions.Record) {
+			r.ID = must(uuid.Parse("4516a1c0-efc3-4561-9e97-e749e008aa3f"))
+			r.ExpiresAt = now(t, 2)
+		}),
+	} {
+		err := store.CreateRecord(context.Background(), r)
+		if err != nil {
+			t.Fatalf("failed to save record: %v", err)
+		}
+	}
+
+	tx, err := testDB.Begin()
+	if err != nil {
+		t.Fatalf("failed to begin tx: %v", err)
+	}
+
+	err = db.DeleteExpiredRecords(tx, now(t, 2))
+	if err != nil {
+		t.Fatalf("failed to delete records: %v", err)
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		t.Fatalf("failed to commit tx: %v", err)
+	}
+
+	got, err := store.FindRecords(context.Background(), sessions.RecordFilter{})
+	if err != nil {
+		t.Fatalf("failed to find records: %v", err)
+	}
+
+	if len(got) != 1 || got[0].ID != newRecord(t, nil).ID {
+		t.Fatalf("expected only the record that did not expire to remain, got %#v", got)
+	}
+}
+
+func now(t *testing.T, i int) time.Time {
+	t.Helper()
+
+	if i > 9 {
+		t.Fatalf("invalid time index: %d", i)
+	}
+
+	ts, err := time.Parse(time.RFC3339, fmt.Sprintf("2021-01-01T00:00:0%dZ", i))
+	if err != nil {
+		t.Fatalf("failed to parse time: %v", err)
+	}
+
+	return ts
+}
+
+func storeForTest(t *testing.T) (*db.Store, *sql.DB) {
+	t.Helper()
+
+	testDB := testdb.RunWhile(t, true)
+	insertUsers(t, testDB)
+
+	return db.New(testDB, testDB), testDB
+}
+
+// insertUsers inserts bare users so that records can reference them.
+func insertUsers(t *testing.T, testDB *sql.DB) {
+	t.Helper()
+
+	for i, id := range []uuid.UUID{user1, user2} {
+		_, err := testDB.Exec(`INSERT INTO users (id, email_encrypted, email_blind_index, password_hash, is_active, created_at, updated_at) VALUES (?, ?, ?, ?, ?, ?, ?)`,
+			id, "encrypted", fmt.Sprintf("index-%d", i), "hash", true, now(t, 0), now(t, 0),
+		)
+		if err != nil {
+			t.Fatalf("failed to insert user: %v", err)
+		}
+	}
+}
+
+func newRecord(t *testing.T, modFunc func(*sessions.Record)) sessions.Record {
+	t.Helper()
+
+	r := sessions.Record{
+		ID:         must(uuid.Parse("42bf8943-2ffc-43d9-8682-ca8fc4d7cb8e")),
+		UserID:     ptr(user1),
+		Data:       []byte("data"),
+		UserAgent:  "Mozilla/5.0 (X11; Linux x86_64; rv:125.0) Gecko/20100101 Firefox/125.0",
+		IP:         "192.0.2.0/24",
+		CreatedAt:  now(t, 0),
+		UpdatedAt:  now(t, 0),
+		LastSeenAt: now(t, 0),
+		ExpiresAt:  now(t, 5),
+	}
+
+	if modFunc != nil {
+		modFunc(&r)
+	}
+
+	return r
+}
+
+func assertFindRecord(t *testing.T, store *db.Store, want sessions.Record) {
+	t.Helper()
+
+	got, err := store.FindRecords(context.Background(), sessions.RecordFilter{IDs: []uuid.UUID{want.ID}})
+	if err != nil {
+		t.Fatalf("failed to find record: %v", err)
+	}
+
+	if len(got) != 1 {
+		t.Fatalf("expected 1 record, got %d", len(got))
+	}
+
+	if !reflect.DeepEqual(got[0], want) {
+		t.Errorf("got\n%#v\nwant\n%#v\n", got[0], want)
+	}
+}
+
+func ptr[T any](v T) *T {
+	return &v
+}
+
+func must[T any](v T, err error) T {
+	if err != nil {
+		panic(err)
+	}
+	return v
+}
@@ -0,0 +1,282 @@
+package sessions
+
+import (
+	"bytes"
+	"context"
+	"encoding/gob"
+	"errors"
+	"net"
+	"net/http"
+	"net/netip"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/securecookie"
+	"github.com/gorilla/sessions"
+	"github.com/willemschots/househunt/internal/errorz"
+)
+
+// lastSeenInterval is how often the last seen time of a session is updated,
+// so that not every request results in a write.
+const lastSeenInterval = time.Minute
+
+// DBStore is a sessions.Store that keeps the session values in a RecordStore.
+// The cookie only holds the ID of the session, which allows sessions to be revoked.
+type DBStore struct {
+	records RecordStore
+	codecs  []securecookie.Codec
+	options sessions.Options
+
+	// NowFunc is used to get the current time.
+	// Exposed for testing purposes.
+	NowFunc func() time.Time
+}
+
+// NewDBStore creates a new DBStore. The key pairs are used to sign and encrypt
+// the cookies, see securecookie.CodecsFromPairs. options.MaxAge determines how
+// long a session is valid and should be positive.
+func NewDBStore(records RecordStore, options sessions.Options, keyPairs ...[]byte) *DBStore {
+	codecs := securecookie.CodecsFromPairs(keyPairs...)
+	for _, codec := range codecs {
+		if sc, ok := codec.(*securecookie.SecureCookie); ok {
+			sc.MaxAge(options.MaxAge)
+		}
+	}
+
+	return &DBStore{
+		records: records,
+		codecs:  codecs,
+		options: options,
+		NowFunc: time.Now,
+	}
+}
+
+// Get returns a session for the given name after adding it to the registry.
+func (s *DBStore) Get(r *http.Request, name string) (*sessions.Session, error) {
+	return sessions.GetRegistry(r).Get(s, name)
+}
+
+// New returns a session for the given name without adding it to the registry.
+// Invalid cookies and cookies of revoked or expired sessions result in a new session.
+func (s *DBStore) New(r *http.Request, name string) (*sessions.Session, error) {
+	session := sessions.NewSession(s, name)
+	opts := s.options
+	session.Options = &opts
+	session.IsNew = true
+
+	c, err := r.Cookie(name)
+	if err != nil {
+		return session, nil
+	}
+
+	var rawID string
+	err = securecookie.DecodeMulti(name, c.Value, &rawID, s.codecs...)
+	if err != nil {
+		return session, nil
+	}
+
+	id, err := uuid.Parse(rawID)
+	if err != nil {
+		return session, nil
+	}
+
+	now := s.NowFunc()
+	records, err := s.records.FindRecords(r.Context(), RecordFilter{
+		IDs:          []uuid.UUID{id},
+		ExpiresAfter: &now,
+	})
+	if err != nil {
+		return session, err
+	}
+
+	if len(records) == 0 {
+		return session, nil
+	}
+
+	record := records[0]
+	if now.Sub(record.LastSeenAt) >= lastSeenInterval {
+		record.UserAgent = r.UserAgent()
+		record.IP = approximateIP(r)
+		record.LastSeenAt = now
+
+		err = s.records.UpdateRecord(r.Context(), record)
+		if errors.Is(err, errorz.ErrNotFound) {
+			// Revoked in the meantime.
+			return session, nil
+		}
+		if err != nil {
+			return session, err
+		}
+	}
+
+	err = gob.NewDecoder(bytes.NewReader(record.Data)).Decode(&session.Values)
+	if err != nil {
+		return session, err
+	}
+
+	session.ID = rawID
+	session.IsNew = false
+	return session, nil
+}
+
+// Save saves the session record and writes the cookie to the response.
+// If the sessions MaxAge is <= 0 the session is revoked and the cookie is deleted.
+func (s *DBStore) Save(r *http.Request, w http.ResponseWriter, session *sessions.Session) error {
+	if session.Options.MaxAge <= 0 {
+		if session.ID != "" {
+			err := s.revoke(r.Context(), session.ID)
+			if err != nil {
+				return err
+			}
+		}
+
+		http.SetCookie(w, sessions.NewCookie(session.Name(), "", session.Options))
+		return nil
+	}
+
+	var data bytes.Buffer
+	err := gob.NewEncoder(&data).Encode(session.Values)
+	if err != nil {
+		return err
+	}
+
+	now := s.NowFunc()
+	record := Record{
+		Data:       data.Bytes(),
+		UserAgent:  r.UserAgent(),
+		IP:         approximateIP(r),
+		CreatedAt:  now,
+		UpdatedAt:  now,
+		LastSeenAt: now,
+		ExpiresAt:  now.Add(time.Duration(session.Options.MaxAge) * time.Second),
+	}
+
+	if userID, ok := session.Values[userIDKey].(uuid.UUID); ok {
+		record.UserID = &userID
+	}
+
+	if session.ID == "" {
+		record.ID, err = uuid.NewRandom()
+		if err != nil {
+			return err
+		}
+
+		err = s.records.CreateRecord(r.Context(), record)
+		if err != nil {
+			return err
+		}
+
+		session.ID = record.ID.String()
+	} else {
+		record.ID, err = uuid.Parse(session.ID)
+		if err != nil {
+			return err
+		}
+
+		err = s.records.UpdateRecord(r.Context(), record)
+		if errors.Is(err, errorz.ErrNotFound) {
+			// The session was revoked while the request was being handled,
+			// it should not be brought back to life.
+			http.SetCookie(w, sessions.NewCookie(session.Name(), "", &sessions.Options{
+				Path:     session.Options.Path,
+				Domain:   session.Options.Domain,
+				MaxAge:   -1,
+				Secure:   session.Options.Secure,
+				HttpOnly: session.Options.HttpOnly,
+				SameSite: session.Options.SameSite,
+			}))
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	encoded, err := securecookie.EncodeMulti(session.Name(), session.ID, s.codecs...)
+	if err != nil {
+		return err
+	}
+
+	http.SetCookie(w, sessions.NewCookie(session.Name(), encoded, session.Options))
+	return nil
+}
+
+// RevokeSession revokes a single session.
+func (s *DBStore) RevokeSession(ctx context.Context, id uuid.UUID) error {
+	return s.records.DeleteRecords(ctx, RecordFilter{
+		IDs: []uuid.UUID{id},
+	})
+}
+
+// RevokeUserSessions revokes all sessions of a user.
+func (s *DBStore) RevokeUserSessions(ctx context.Context, userID uuid.UUID) error {
+	return s.records.DeleteRecords(ctx, RecordFilter{
+		UserIDs: []uuid.UUID{userID},
+	})
+}
+
+// RevokeUserSession revokes a single session of a user.
+// It returns errorz.ErrNotFound if the user has no such session.
+func (s *DBStore) RevokeUserSession(ctx context.Context, userID, id uuid.UUID) error {
+	filter := RecordFilter{
+		IDs:     []uuid.UUID{id},
+		UserIDs: []uuid.UUID{userID},
+	}
+
+	records, err := s.records.FindRecords(ctx, filter)
+	if err != nil {
+		return err
+	}
+
+	if len(records) == 0 {
+		return errorz.ErrNotFound
+	}
+
+	return s.records.DeleteRecords(ctx, filter)
+}
+
+// UserSessions returns the sessions of a user that have not expired yet.
+func (s *DBStore) UserSessions(ctx context.Context, userID uuid.UUID) ([]Record, error) {
+	now := s.NowFunc()
+	return s.records.FindRecords(ctx, RecordFilter{
+		UserIDs:      []uuid.UUID{userID},
+		ExpiresAfter: &now,
+	})
+}
+
+func (s *DBStore) revoke(ctx context.Context, rawID string) error {
+	id, err := uuid.Parse(rawID)
+	if err != nil {
+		return err
+	}
+
+	return s.RevokeSession(ctx, id)
+}
+
+// approximateIP returns the network of the client address: a /24 for IPv4 and
+// a /48 for IPv6. This is enough for users to recognize their sessions, without
+// storing the full address.
+func approximateIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+
+	addr, err := netip.ParseAddr(host)
+	if err != nil {
+		return ""
+	}
+
+	addr = addr.Unmap()
+	bits := 48
+	if addr.Is4() {
+		bits = 24
+	}
+
+	prefix, err := addr.Prefix(bits)
+	if err != nil {
+		return ""
+	}
+
+	return prefix.String()
+}
@@ -0,0 +1,346 @@
+package sessions_test
+
+import (
+	"context"
+	"encoding/gob"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	gorillaSess "github.com/gorilla/sessions"
+	"github.com/willemschots/househunt/internal/db/testdb"
+	"github.com/willemschots/househunt/internal/errorz"
+	"github.com/willemschots/househunt/internal/web/sessions"
+	"github.com/willemschots/househunt/internal/web/sessions/db"
+)
+
+var userID = must(uuid.Parse("0e61a06e-bbf6-4b87-aaaa-75fee0f38cca"))
+
+func init() {
+	gob.Register(uuid.UUID{})
+}
+
+func Test_DBStore(t *testing.T) {
+	t.Run("ok, new session without cookie", func(t *testing.T) {
+		st := newStoreTest(t)
+
+		sess := st.get(t, nil)
+		if !sess.IsNew || sess.ID != "" {
+			t.Fatalf("expected a new session, got %+v", sess)
+		}
+	})
+
+	t.Run("ok, values are kept on the server", func(t *testing.T) {
+		st := newStoreTest(t)
+
+		sess := st.get(t, nil)
+		sess.Values["userID"] = userID
+		sess.Values["role"] = "agent"
+		cookie := st.save(t, sess)
+
+		got := st.get(t, cookie)
+		if got.IsNew || got.ID != sess.ID {
+			t.Fatalf("expected existing session %s, got %+v", sess.ID, got)
+		}
+
+		if got.Values["userID"] != userID || got.Values["role"] != "agent" {
+			t.Fatalf("unexpected values: %v", got.Values)
+		}
+
+		// The cookie only contains the session ID.
+		records := st.records(t, sessions.RecordFilter{UserIDs: []uuid.UUID{userID}})
+		if len(records) != 1 || records[0].ID.String() != sess.ID {
+			t.Fatalf("expected a single record for the user, got %#v", records)
+		}
+	})
+
+	t.Run("ok, revoked session is replaced by a new session", func(t *testing.T) {
+		st := newStoreTest(t)
+
+		sess := st.get(t, nil)
+		sess.Values["userID"] = userID
+		cookie := st.save(t, sess)
+
+		err := st.store.RevokeSession(context.Background(), must(uuid.Parse(sess.ID)))
+		if err != nil {
+			t.Fatalf("failed to revoke session: %v", err)
+		}
+
+		got := st.get(t, cookie)
+		if !got.IsNew || len(got.Values) != 0 {
+			t.Fatalf("expected a new session, got %+v", got)
+		}
+	})
+
+	t.Run("ok, all sessions of a user are revoked", func(t *testing.T) {
+		st := newStoreTest(t)
+
+		var cookies []*http.Cookie
+		for i := 0; i < 2; i++ {
+			sess := st.get(t, nil)
+			sess.Values["userID"] = userID
+			cookies = append(cookies, st.save(t, sess))
+		}
+
+		err := st.store.RevokeUserSessions(context.Background(), userID)
+		if err != nil {
+			t.Fatalf("failed to revoke sessions: %v", err)
+		}
+
+		for _, cookie := range cookies {
+			got := st.get(t, cookie)
+			if !got.IsNew {
+				t.Fatalf("expected a new session, got %+v", got)
+			}
+		}
+	})
+
+	t.Run("ok, device of the session is recorded", func(t *testing.T) {
+		st := newStoreTest(t)
+		start := st.now
+
+		sess := st.get(t, nil)
+		cookie := st.save(t, sess)
+
+		assertDevice := func(t *testing.T, wantUA string, wantLastSeen time.Time) {
+			t.Helper()
+
+			records := st.records(t, sessions.RecordFilter{})
+			if len(records) != 1 {
+				t.Fatalf("expected 1 record, got %d", len(records))
+			}
+
+			r := records[0]
+			// httptest requests come from 192.0.2.1.
+			if r.UserAgent != wantUA || r.IP != "192.0.2.0/24" || !r.LastSeenAt.Equal(wantLastSeen) || !r.CreatedAt.Equal(start) {
+				t.Fatalf("unexpected record: %+v", r)
+			}
+		}
+
+		assertDevice(t, "", start)
+
+		// Last seen is only updated once per minute.
+		st.userAgent = "test-agent"
+		st.now = start.Add(30 * time.Second)
+		st.get(t, cookie)
+		assertDevice(t, "", start)
+
+		st.now = start.Add(time.Minute)
+		st.get(t, cookie)
+		assertDevice(t, "test-agent", st.now)
+	})
+
+	t.Run("ok, user sessions can be listed and revoked", func(t *testing.T) {
+		st := newStoreTest(t)
+
+		var ids []uuid.UUID
+		for i := 0; i < 2; i++ {
+			sess := st.get(t, nil)
+			sess.Values["userID"] = userID
+			st.save(t, sess)
+			ids = append(ids, must(uuid.Parse(sess.ID)))
+		}
+
+		// Sessions without a user are not listed.
+		st.save(t, st.get(t, nil))
+
+		got, err := st.store.UserSessions(context.Background(), userID)
+		if err != nil {
+			t.Fatalf("failed to get user sessions: %v", err)
+		}
+
+		if len(got) != 2 {
+			t.Fatalf("expected 2 sessions, got %d", len(got))
+		}
+
+		err = st.store.RevokeUserSession(context.Background(), userID, ids[0])
+		if err != nil {
+			t.Fatalf("failed to revoke session: %v", err)
+		}
+
+		// Revoking it again fails.
+		err = st.store.RevokeUserSession(context.Background(), userID, ids[0])
+		if !errors.Is(err, errorz.ErrNotFound) {
+			t.Fatalf("expected error %v, got %v (via errors.Is)", errorz.ErrNotFound, err)
+		}
+
+		// Other users can't revoke the session.
+		err = st.store.RevokeUserSession(context.Background(), uuid.New(), ids[1])
+		if !errors.Is(err, errorz.ErrNotFound) {
+			t.Fatalf("expected error %v, got %v (via errors.Is)", errorz.ErrNotFound, err)
+		}
+
+		got, err = st.store.UserSessions(context.Background(), userID)
+		if err != nil {
+			t.Fatalf("failed to get user sessions: %v", err)
+		}
+
+		if len(got) != 1 || got[0].ID != ids[1] {
+			t.Fatalf("expected only session %s, got %+v", ids[1], got)
+		}
+	})
+
+	t.Run("ok, expired session is replaced by a new session", func(t *testing.T) {
+		st := newStoreTest(t)
+
+		sess := st.get(t, nil)
+		cookie := st.save(t, sess)
+
+		st.now = st.now.Add(time.Hour)
+
+		got := st.get(t, cookie)
+		if !got.IsNew {
+			t.Fatalf("expected a new session, got %+v", got)
+		}
+	})
+
+	t.Run("ok, invalid cookie is replaced by a new session", func(t *testing.T) {
+		st := newStoreTest(t)
+
+		got := st.get(t, &http.Cookie{Name: sessions.CookieName, Value: "invalid"})
+		if !got.IsNew {
+			t.Fatalf("expected a new session, got %+v", got)
+		}
+	})
+
+	t.Run("ok, negative max age revokes session and deletes cookie", func(t *testing.T) {
+		st := newStoreTest(t)
+
+		sess := st.get(t, nil)
+		cookie := st.save(t, sess)
+
+		sess.Options.MaxAge = -1
+		deleted := st.save(t, sess)
+		if deleted.MaxAge >= 0 {
+			t.Fatalf("expected cookie to be deleted, got %+v", deleted)
+		}
+
+		records := st.records(t, sessions.RecordFilter{})
+		if len(records) != 0 {
+			t.Fatalf("expected no records, got %#v", records)
+		}
+
+		got := st.get(t, cookie)
+		if !got.IsNew {
+			t.Fatalf("expected a new session, got %+v", got)
+		}
+	})
+
+	t.Run("ok, saving a revoked session does not restore it", func(t *testing.T) {
+		st := newStoreTest(t)
+
+		sess := st.get(t, nil)
+		sess.Values["userID"] = userID
+		cookie := st.save(t, sess)
+
+		// Simulate a revocation while a request is being handled.
+		sess = st.get(t, cookie)
+
+		err := st.store.RevokeUserSessions(context.Background(), userID)
+		if err != nil {
+			t.Fatalf("failed to revoke sessions: %v", err)
+		}
+
+		deleted := st.save(t, sess)
+		if deleted.MaxAge >= 0 {
+			t.Fatalf("expected cookie to be deleted, got %+v", deleted)
+		}
+
+		records := st.records(t, sessions.RecordFilter{})
+		if len(records) != 0 {
+			t.Fatalf("expected no records, got %#v", records)
+		}
+	})
+}
+
+type storeTest struct {
+	now       time.Time
+	userAgent string
+	records   func(t *testing.T, f sessions.RecordFilter) []sessions.Record
+	store     *sessions.DBStore
+}
+
+func newStoreTest(t *testing.T) *storeTest {
+	t.Helper()
+
+	testDB := testdb.RunWhile(t, true)
+
+	_, err := testDB.Exec(`INSERT INTO users (id, email_encrypted, email_blind_index, password_hash, is_active, created_at, updated_at) VALUES (?, ?, ?, ?, ?, ?, ?)`,
+		userID, "encrypted", "index", "hash", true, time.Now(), time.Now(),
+	)
+	if err != nil {
+		t.Fatalf("failed to insert user: %v", err)
+	}
+
+	recordStore := db.New(testDB, testDB)
+
+	st := &storeTest{
+		now: must(time.Parse(time.RFC3339, "2021-01-01T00:00:00Z")),
+		records: func(t *testing.T, f sessions.RecordFilter) []sessions.Record {
+			t.Helper()
+
+			records, err := recordStore.FindRecords(context.Background(), f)
+			if err != nil {
+				t.Fatalf("failed to find records: %v", err)
+			}
+
+			return records
+		},
+	}
+
+	st.store = sessions.NewDBStore(recordStore, gorillaSess.Options{
+		Path:   "/",
+		MaxAge: 60 * 60, // 1 hour.
+	}, []byte("0123456789abcdef0123456789abcdef"))
+	st.store.NowFunc = func() time.Time { return st.now }
+
+	return st
+}
+
+// get gets a session as part of a new request with the provided cookie.
+func (st *storeTest) get(t *testing.T, cookie *http.Cookie) *gorillaSess.Session {
+	t.Helper()
+
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Set("User-Agent", st.userAgent)
+	if cookie != nil {
+		r.AddCookie(cookie)
+	}
+
+	sess, err := st.store.Get(r, sessions.CookieName)
+	if err != nil {
+		t.Fatalf("failed to get session: %v", err)
+	}
+
+	return sess
+}
+
+// save saves the session and returns the cookie that was written.
+func (st *storeTest) save(t *testing.T, sess *gorillaSess.Session) *http.Cookie {
+	t.Helper()
+
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	w := httptest.NewRecorder()
+
+	err := st.store.Save(r, w, sess)
+	if err != nil {
+		t.Fatalf("failed to save session: %v", err)
+	}
+
+	cookies := w.Result().Cookies()
+	if len(cookies) != 1 {
+		t.Fatalf("expected 1 cookie, got %d", len(cookies))
+	}
+
+	return cookies[0]
+}
+
+func must[T any](v T, err error) T {
+	if err != nil {
+		panic(err)
+	}
+	return v
+}
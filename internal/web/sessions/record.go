@@ -0,0 +1,49 @@
+package sessions
+
+import (
+	"context"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Record is a session as it is kept on the server. The cookie only contains
+// the (signed and encrypted) ID of the record, so that a session can be revoked
+// by deleting its record.
+type Record struct {
+	ID uuid.UUID
+	// UserID is the ID of the logged in user, nil if nobody is logged in.
+	UserID *uuid.UUID
+	// Data contains the encoded session values.
+	Data []byte
+	// UserAgent and IP describe the device that last used the session. IP is
+	// the network of the client address, full addresses are not stored.
+	UserAgent  string
+	IP         string
+	CreatedAt  time.Time
+	UpdatedAt  time.Time
+	LastSeenAt time.Time
+	ExpiresAt  time.Time
+}
+
+// RecordFilter is used to filter records.
+// Records must match all the provided fields.
+// If a field is empty or nil, it's ignored.
+type RecordFilter struct {
+	IDs          []uuid.UUID
+	UserIDs      []uuid.UUID
+	ExpiresAfter *time.Time
+	// ExpiresBefore only matches records that expire at or before this time.
+	ExpiresBefore *time.Time
+}
+
+// RecordStore persists session records. Every method is a single
+// operation, so unlike the domain stores no transactions are required.
+type RecordStore interface {
+	CreateRecord(ctx context.Context, r Record) error
+	// UpdateRecord updates all fields of a record, except for its CreatedAt.
+	// It returns errorz.ErrNotFound if the record doesn't exist.
+	UpdateRecord(ctx context.Context, r Record) error
+	DeleteRecords(ctx context.Context, filter RecordFilter) error
+	FindRecords(ctx context.Context, filter RecordFilter) ([]Record, error)
+}
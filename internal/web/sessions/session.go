@@ -1,32 +1,153 @@
 package sessions
 
 import (
+	"time"
+
 	"github.com/google/uuid"
 	"github.com/gorilla/sessions"
 )
 
+const (
+	userIDKey         = "userID"
+	pendingUserIDKey  = "pendingUserID"
+	pendingSinceKey   = "pendingSince"
+	challengeKey      = "webauthnChallenge"
+	challengeSinceKey = "webauthnChallengeSince"
+	roleKey           = "role"
+)
+
 type Session struct {
 	base      *sessions.Session
 	needsSave bool
+	// renewedFrom is the ID the session had before it was renewed.
+	renewedFrom string
+	// stateless sessions only live for the duration of a request.
+	stateless bool
+}
+
+// NewStateless returns a session that is never saved, for requests that are
+// authenticated without a cookie, such as API requests that provide a bearer token.
+func NewStateless() *Session {
+	base := sessions.NewSession(nil, CookieName)
+	base.Options = &sessions.Options{}
+	return &Session{base: base, stateless: true}
 }
 
 func (s *Session) NeedsSave() bool {
-	return s.needsSave
+	return s.needsSave && !s.stateless
+}
+
+// ID returns the ID of the session, it's uuid.Nil if the session was never saved.
+func (s *Session) ID() uuid.UUID {
+	id, err := uuid.Parse(s.base.ID)
+	if err != nil {
+		return uuid.Nil
+	}
+	return id
+}
+
+// Renew gives the session a new ID when it's saved, the old ID is revoked.
+// Sessions should be renewed when a user logs in, to prevent session fixation.
+func (s *Session) Renew() {
+	s.needsSave = true
+	if s.renewedFrom == "" {
+		s.renewedFrom = s.base.ID
+	}
+	s.base.ID = ""
+}
+
+// Revoke revokes the session when it's saved, which also deletes the cookie.
+func (s *Session) Revoke() {
+	s.needsSave = true
+	s.base.Options.MaxAge = -1
 }
 
 func (s *Session) UserID() (uuid.UUID, bool) {
-	userID, ok := s.base.Values["userID"].(uuid.UUID)
+	userID, ok := s.base.Values[userIDKey].(uuid.UUID)
 	return userID, ok
 }
 
 func (s *Session) SetUserID(userID uuid.UUID) {
 	s.needsSave = true
-	s.base.Values["userID"] = userID
+	s.base.Values[userIDKey] = userID
 }
 
 func (s *Session) DeleteUserID() {
 	s.needsSave = true
-	delete(s.base.Values, "userID")
+	delete(s.base.Values, userIDKey)
+}
+
+// PendingUserID returns the ID of a user that provided valid credentials, but still
+// needs to provide a second factor to log in. since is the time the credentials were provided.
+func (s *Session) PendingUserID() (userID uuid.UUID, since time.Time, ok bool) {
+	userID, ok = s.base.Values[pendingUserIDKey].(uuid.UUID)
+	if !ok {
+		return uuid.Nil, time.Time{}, false
+	}
+
+	unix, ok := s.base.Values[pendingSinceKey].(int64)
+	if !ok {
+		return uuid.Nil, time.Time{}, false
+	}
+
+	return userID, time.Unix(unix, 0), true
+}
+
+func (s *Session) SetPendingUserID(userID uuid.UUID, since time.Time) {
+	s.needsSave = true
+	s.base.Values[pendingUserIDKey] = userID
+	s.base.Values[pendingSinceKey] = since.Unix()
+}
+
+func (s *Session) DeletePendingUserID() {
+	s.needsSave = true
+	delete(s.base.Values, pendingUserIDKey)
+	delete(s.base.Values, pendingSinceKey)
+}
+
+// WebAuthnChallenge returns the challenge of a WebAuthn ceremony, such as registering
+// or logging in with a passkey, and the time the ceremony was started.
+func (s *Session) WebAuthnChallenge() (challenge []byte, since time.Time, ok bool) {
+	challenge, ok = s.base.Values[challengeKey].([]byte)
+	if !ok {
+		return nil, time.Time{}, false
+	}
+
+	unix, ok := s.base.Values[challengeSinceKey].(int64)
+	if !ok {
+		return nil, time.Time{}, false
+	}
+
+	return challenge, time.Unix(unix, 0), true
+}
+
+func (s *Session) SetWebAuthnChallenge(challenge []byte, since time.Time) {
+	s.needsSave = true
+	s.base.Values[challengeKey] = challenge
+	s.base.Values[challengeSinceKey] = since.Unix()
+}
+
+func (s *Session) DeleteWebAuthnChallenge() {
+	s.needsSave = true
+	delete(s.base.Values, challengeKey)
+	delete(s.base.Values, challengeSinceKey)
+}
+
+// Role returns the role of the logged in user. The role is stored as a plain
+// string so that the session doesn't depend on the auth package.
+func (s *Session) Role() (string, bool) {
+	role, ok := s.base.Values[roleKey].(string)
+	return role, ok
+}
+
+func (s *Session) SetRole(role string) {
+	s.needsSave = true
+	s.base.Values[roleKey] = role
+}
+
+func (s *Session) DeleteRole() {
+	s.needsSave = true
+	delete(s.base.Values, roleKey)
 }
 
 func (s *Session) AddFlash(flash any, vars ...string) {
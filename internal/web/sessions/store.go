@@ -1,18 +1,19 @@
 package sessions
 
 import (
+	"context"
 	"net/http"
 
-	"github.com/gorilla/sessions"
+	"github.com/google/uuid"
 )
 
 const CookieName = "hh-session"
 
 type Store struct {
-	store sessions.Store
+	store *DBStore
 }
 
-func NewStore(store sessions.Store) *Store {
+func NewStore(store *DBStore) *Store {
 	return &Store{store: store}
 }
 
@@ -26,6 +27,15 @@ func (s *Store) Get(r *http.Request) (*Session, error) {
 }
 
 func (s *Store) Save(r *http.Request, w http.ResponseWriter, sess *Session) error {
+	if sess.renewedFrom != "" {
+		err := s.store.revoke(r.Context(), sess.renewedFrom)
+		if err != nil {
+			return err
+		}
+
+		sess.renewedFrom = ""
+	}
+
 	err := s.store.Save(r, w, sess.base)
 	if err != nil {
 		return err
@@ -34,3 +44,14 @@ func (s *Store) Save(r *http.Request, w http.ResponseWriter, sess *Session) erro
 	sess.needsSave = false
 	return nil
 }
+
+// UserSessions returns the active sessions of a user.
+func (s *Store) UserSessions(ctx context.Context, userID uuid.UUID) ([]Record, error) {
+	return s.store.UserSessions(ctx, userID)
+}
+
+// RevokeUserSession revokes a session of a user.
+// It returns errorz.ErrNotFound if the user has no such session.
+func (s *Store) RevokeUserSession(ctx context.Context, userID, id uuid.UUID) error {
+	return s.store.RevokeUserSession(ctx, userID, id)
+}
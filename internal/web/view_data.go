@@ -7,6 +7,7 @@ import (
 	"github.com/google/uuid"
 	"github.com/gorilla/csrf"
 	"github.com/willemschots/househunt/internal"
+	"github.com/willemschots/househunt/internal/auth"
 	"github.com/willemschots/househunt/internal/errorz"
 )
 
@@ -15,6 +16,7 @@ type viewData struct {
 	CSRFToken   string
 	IsLoggedIn  bool
 	UserID      uuid.UUID
+	Role        auth.Role
 	Flashes     []any
 	InputForm   url.Values
 	InputErrors errorz.InvalidInput
@@ -32,12 +34,14 @@ func (s *Server) prepViewData(r *http.Request, w http.ResponseWriter, data any)
 	}
 
 	userID, loggedIn := sess.UserID()
+	role, _ := sess.Role()
 
 	return &viewData{
 		Version:     internal.BuildRevision,
 		CSRFToken:   csrf.Token(r),
 		IsLoggedIn:  loggedIn,
 		UserID:      userID,
+		Role:        auth.Role(role),
 		Flashes:     sess.ConsumeFlashes(),
 		InputForm:   r.Form,
 		InputErrors: nil,
@@ -0,0 +1,98 @@
+package web
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/csrf"
+	"github.com/willemschots/househunt/internal/auth"
+	"github.com/willemschots/househunt/internal/email"
+	"github.com/willemschots/househunt/internal/email/postmark"
+	"github.com/willemschots/househunt/internal/web/sessions"
+)
+
+// webhookPrefix is the path prefix of endpoints that are called by other services.
+const webhookPrefix = "/webhooks"
+
+var errWebhookUnauthorized = errors.New("invalid webhook credentials")
+
+// webhookMiddleware gives webhook requests a stateless session. Webhooks are called by
+// other services that authenticate every request themselves. They don't carry cookies
+// that a browser attaches automatically, so they skip the CSRF check.
+func webhookMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasPrefix(r.URL.Path, webhookPrefix+"/") {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		r = csrf.UnsafeSkipCheck(r)
+		ctx := ctxWithSession(r.Context(), sessions.NewStateless())
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// registerWebhooks sets up the webhook endpoints that are enabled in the config.
+func (s *Server) registerWebhooks(cfg ServerConfig) {
+	if cfg.PostmarkWebhook.Enabled() {
+		h := newAPIInputHandler(s, s.recordPostmarkEvent)
+		h.reqToInFunc = func(r shared) (postmark.Event, error) {
+			// Postmark sends many fields that are not used, so unknown fields are allowed.
+			var ev postmark.Event
+			err := json.NewDecoder(http.MaxBytesReader(r.w, r.r.Body, maxAPIBodyBytes)).Decode(&ev)
+			if err != nil && !errors.Is(err, io.EOF) {
+				return ev, jsonDecodeError(err)
+			}
+			return ev, nil
+		}
+		h.onSuccess = func(r result[postmark.Event, struct{}]) error {
+			// Postmark retries the webhook unless it gets a 200 response.
+			if s.preWrite(r.w, r.r) {
+				r.w.WriteHeader(http.StatusOK)
+			}
+			return nil
+		}
+
+		s.mux.Handle("POST "+webhookPrefix+"/postmark", s.basicAuth(cfg.PostmarkWebhook, h))
+	}
+}
+
+// basicAuth only passes on requests that provide the basic auth credentials of the webhook.
+func (s *Server) basicAuth(settings postmark.WebhookSettings, handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !settings.Authorized(r) {
+			w.Header().Set("WWW-Authenticate", `Basic realm="webhooks"`)
+			s.writeProblem(w, r, errWebhookUnauthorized)
+			return
+		}
+
+		handler.ServeHTTP(w, r)
+	})
+}
+
+// recordPostmarkEvent records hard bounces and spam complaints, other events are ignored.
+func (s *Server) recordPostmarkEvent(ctx context.Context, ev postmark.Event) error {
+	var kind auth.BounceKind
+	switch {
+	case ev.RecordType == postmark.RecordTypeSpamComplaint:
+		kind = auth.BounceKindSpamComplaint
+	case ev.RecordType == postmark.RecordTypeBounce &&
+		(ev.Type == postmark.BounceTypeHard || ev.Type == postmark.BounceTypeBadEmailAddress):
+		kind = auth.BounceKindHard
+	default:
+		return nil
+	}
+
+	addr, err := email.ParseAddress(ev.Email)
+	if err != nil {
+		// We never send emails to invalid addresses, so there is nothing to record.
+		s.deps.Logger.Warn("ignoring postmark event with invalid email address", "record_type", ev.RecordType)
+		return nil
+	}
+
+	return s.deps.AuthService.RecordEmailBounce(ctx, addr, kind, ev.BouncedAt)
+}
@@ -0,0 +1,163 @@
+package webauthn
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+)
+
+// ErrInvalidCBOR indicates data could not be decoded as CBOR.
+var ErrInvalidCBOR = errors.New("invalid cbor")
+
+// maxCBORDepth limits the nesting of arrays and maps, authenticator data is never deeply nested.
+const maxCBORDepth = 8
+
+// decodeCBOR decodes the first CBOR (RFC 8949) data item in b and returns the remaining bytes.
+//
+// Only the subset used by WebAuthn is supported: integers, byte and text strings, arrays,
+// maps and the simple values false, true and null. Decoded values are int64, []byte, string,
+// []any, map[any]any, bool or nil. Map keys are either int64 or string.
+func decodeCBOR(b []byte) (any, []byte, error) {
+	return decodeCBORItem(b, 0)
+}
+
+func decodeCBORItem(b []byte, depth int) (any, []byte, error) {
+	if depth > maxCBORDepth {
+		return nil, nil, fmt.Errorf("%w: nested too deep", ErrInvalidCBOR)
+	}
+
+	if len(b) == 0 {
+		return nil, nil, fmt.Errorf("%w: unexpected end of data", ErrInvalidCBOR)
+	}
+
+	major := b[0] >> 5
+	info := b[0] & 0x1f
+	b = b[1:]
+
+	if major == 7 {
+		switch info {
+		case 20:
+			return false, b, nil
+		case 21:
+			return true, b, nil
+		case 22:
+			return nil, b, nil
+		default:
+			return nil, nil, fmt.Errorf("%w: unsupported simple value %d", ErrInvalidCBOR, info)
+		}
+	}
+
+	arg, b, err := decodeCBORArgument(info, b)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	switch major {
+	case 0:
+		if arg > math.MaxInt64 {
+			return nil, nil, fmt.Errorf("%w: integer overflow", ErrInvalidCBOR)
+		}
+		return int64(arg), b, nil
+	case 1:
+		if arg > math.MaxInt64 {
+			return nil, nil, fmt.Errorf("%w: integer overflow", ErrInvalidCBOR)
+		}
+		return -1 - int64(arg), b, nil
+	case 2, 3:
+		if arg > uint64(len(b)) {
+			return nil, nil, fmt.Errorf("%w: unexpected end of data", ErrInvalidCBOR)
+		}
+
+		data := b[:arg]
+		if major == 3 {
+			return string(data), b[arg:], nil
+		}
+		return append([]byte(nil), data...), b[arg:], nil
+	case 4:
+		// Every item takes at least a byte, so larger lengths are invalid.
+		if arg > uint64(len(b)) {
+			return nil, nil, fmt.Errorf("%w: unexpected end of data", ErrInvalidCBOR)
+		}
+
+		out := make([]any, 0, arg)
+		for i := uint64(0); i < arg; i++ {
+			var v any
+			v, b, err = decodeCBORItem(b, depth+1)
+			if err != nil {
+				return nil, nil, err
+			}
+			out = append(out, v)
+		}
+		return out, b, nil
+	case 5:
+		if arg > uint64(len(b)) {
+			return nil, nil, fmt.Errorf("%w: unexpected end of data", ErrInvalidCBOR)
+		}
+
+		out := make(map[any]any, arg)
+		for i := uint64(0); i < arg; i++ {
+			var k, v any
+			k, b, err = decodeCBORItem(b, depth+1)
+			if err != nil {
+				return nil, nil, err
+			}
+
+			switch k.(type) {
+			case int64, string:
+			default:
+				return nil, nil, fmt.Errorf("%w: unsupported map key type %T", ErrInvalidCBOR, k)
+			}
+
+			if _, ok := out[k]; ok {
+				return nil, nil, fmt.Errorf("%w: duplicate map key %v", ErrInvalidCBOR, k)
+			}
+
+			v, b, err = decodeCBORItem(b, depth+1)
+			if err != nil {
+				return nil, nil, err
+			}
+			out[k] = v
+		}
+		return out, b, nil
+	default:
+		return nil, nil, fmt.Errorf("%w: unsupported major type %d", ErrInvalidCBOR, major)
+	}
+}
+
+// decodeCBORArgument decodes the argument of a data item, indefinite lengths are not supported.
+func decodeCBORArgument(info byte, b []byte) (uint64, []byte, error) {
+	size := 0
+	switch {
+	case info < 24:
+		return uint64(info), b, nil
+	case info == 24:
+		size = 1
+	case info == 25:
+		size = 2
+	case info == 26:
+		size = 4
+	case info == 27:
+		size = 8
+	default:
+		return 0, nil, fmt.Errorf("%w: unsupported additional information %d", ErrInvalidCBOR, info)
+	}
+
+	if len(b) < size {
+		return 0, nil, fmt.Errorf("%w: unexpected end of data", ErrInvalidCBOR)
+	}
+
+	var arg uint64
+	switch size {
+	case 1:
+		arg = uint64(b[0])
+	case 2:
+		arg = uint64(binary.BigEndian.Uint16(b))
+	case 4:
+		arg = uint64(binary.BigEndian.Uint32(b))
+	case 8:
+		arg = binary.BigEndian.Uint64(b)
+	}
+
+	return arg, b[size:], nil
+}
@@ -0,0 +1,415 @@
+// Package webauthn implements the relying party side of the WebAuthn registration
+// and authentication ceremonies, as used by passkeys.
+//
+// Only what passkeys need is supported: ES256 credentials, attestation is not
+// verified ("none" conveyance) and user verification is always required.
+package webauthn
+
+import (
+	"bytes"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/url"
+
+	"github.com/willemschots/househunt/internal/krypto"
+)
+
+var (
+	// ErrInvalidResponse indicates the response of an authenticator could not be verified.
+	ErrInvalidResponse = errors.New("invalid webauthn response")
+	// ErrSignCount indicates the signature counter of a credential did not increase,
+	// which could mean the authenticator was cloned.
+	ErrSignCount = errors.New("signature counter did not increase")
+)
+
+const (
+	// algES256 is the COSE algorithm identifier for ECDSA with SHA-256 on P-256.
+	algES256 = -7
+	// timeoutMillis is the time the browser gives the user to complete a ceremony.
+	timeoutMillis = 5 * 60 * 1000
+	// maxCredentialIDLen is the maximum length of a credential ID according to the spec.
+	maxCredentialIDLen = 1023
+
+	flagUserPresent      = 0x01
+	flagUserVerified     = 0x04
+	flagAttestedCredData = 0x40
+)
+
+// Config identifies the relying party, the website passkeys are registered with.
+type Config struct {
+	// RPID is the domain the passkeys are scoped to.
+	RPID string
+	// RPName is the name shown to users by their authenticator.
+	RPName string
+	// Origin is the origin of the website, responses from other origins are rejected.
+	Origin string
+}
+
+// ConfigFromURL creates a config for the website at u.
+func ConfigFromURL(name string, u *url.URL) Config {
+	return Config{
+		RPID:   u.Hostname(),
+		RPName: name,
+		Origin: u.Scheme + "://" + u.Host,
+	}
+}
+
+// Bytes is binary data that is encoded as unpadded base64url, the encoding
+// WebAuthn uses for binary data in JSON.
+type Bytes []byte
+
+// MarshalText implements the encoding.TextMarshaler interface.
+func (b Bytes) MarshalText() ([]byte, error) {
+	return []byte(base64.RawURLEncoding.EncodeToString(b)), nil
+}
+
+// UnmarshalText implements the encoding.TextUnmarshaler interface.
+func (b *Bytes) UnmarshalText(text []byte) error {
+	d, err := base64.RawURLEncoding.DecodeString(string(text))
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrInvalidResponse, err)
+	}
+	*b = d
+	return nil
+}
+
+// GenerateChallenge generates a random challenge for a ceremony. Challenges
+// should be used only once.
+func GenerateChallenge() ([]byte, error) {
+	tok, err := krypto.GenerateToken()
+	if err != nil {
+		return nil, err
+	}
+	return tok[:], nil
+}
+
+// CreationOptions are the options passed to navigator.credentials.create()
+// in the browser to register a passkey.
+type CreationOptions struct {
+	Challenge              Bytes                  `json:"challenge"`
+	RP                     RelyingParty           `json:"rp"`
+	User                   UserEntity             `json:"user"`
+	PubKeyCredParams       []CredentialParameter  `json:"pubKeyCredParams"`
+	Timeout                int                    `json:"timeout"`
+	ExcludeCredentials     []CredentialDescriptor `json:"excludeCredentials"`
+	AuthenticatorSelection AuthenticatorSelection `json:"authenticatorSelection"`
+	Attestation            string                 `json:"attestation"`
+}
+
+// RequestOptions are the options passed to navigator.credentials.get()
+// in the browser to login with a passkey.
+type RequestOptions struct {
+	Challenge        Bytes  `json:"challenge"`
+	RPID             string `json:"rpId"`
+	Timeout          int    `json:"timeout"`
+	UserVerification string `json:"userVerification"`
+}
+
+type RelyingParty struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+type UserEntity struct {
+	ID          Bytes  `json:"id"`
+	Name        string `json:"name"`
+	DisplayName string `json:"displayName"`
+}
+
+type CredentialParameter struct {
+	Type string `json:"type"`
+	Alg  int    `json:"alg"`
+}
+
+type CredentialDescriptor struct {
+	Type string `json:"type"`
+	ID   Bytes  `json:"id"`
+}
+
+type AuthenticatorSelection struct {
+	ResidentKey      string `json:"residentKey"`
+	UserVerification string `json:"userVerification"`
+}
+
+// CreationOptions returns the options to register a passkey for a user. The
+// credentials in exclude are already registered and won't be registered again.
+func (c Config) CreationOptions(challenge, userID []byte, userName string, exclude [][]byte) CreationOptions {
+	descriptors := make([]CredentialDescriptor, 0, len(exclude))
+	for _, id := range exclude {
+		descriptors = append(descriptors, CredentialDescriptor{Type: "public-key", ID: id})
+	}
+
+	return CreationOptions{
+		Challenge: challenge,
+		RP: RelyingParty{
+			ID:   c.RPID,
+			Name: c.RPName,
+		},
+		User: UserEntity{
+			ID:          userID,
+			Name:        userName,
+			DisplayName: userName,
+		},
+		PubKeyCredParams: []CredentialParameter{
+			{Type: "public-key", Alg: algES256},
+		},
+		Timeout:            timeoutMillis,
+		ExcludeCredentials: descriptors,
+		// Passkeys are discoverable, so users don't need to enter their email
+		// address to login.
+		AuthenticatorSelection: AuthenticatorSelection{
+			ResidentKey:      "required",
+			UserVerification: "required",
+		},
+		Attestation: "none",
+	}
+}
+
+// RequestOptions returns the options to login with a passkey. No credentials
+// are listed, the user picks one of their passkeys for this website.
+func (c Config) RequestOptions(challenge []byte) RequestOptions {
+	return RequestOptions{
+		Challenge:        challenge,
+		RPID:             c.RPID,
+		Timeout:          timeoutMillis,
+		UserVerification: "required",
+	}
+}
+
+// RegistrationResponse is the response of an authenticator to CreationOptions.
+type RegistrationResponse struct {
+	ClientDataJSON    Bytes
+	AttestationObject Bytes
+}
+
+// AssertionResponse is the response of an authenticator to RequestOptions.
+type AssertionResponse struct {
+	CredentialID      Bytes
+	ClientDataJSON    Bytes
+	AuthenticatorData Bytes
+	Signature         Bytes
+	// UserHandle is the user ID the credential was registered for.
+	UserHandle Bytes
+}
+
+// Credential is a registered public key credential.
+type Credential struct {
+	ID []byte
+	// PublicKey is the COSE encoded public key of the credential.
+	PublicKey []byte
+	SignCount uint32
+}
+
+// VerifyRegistration verifies the response to CreationOptions with the provided challenge.
+func (c Config) VerifyRegistration(challenge []byte, r RegistrationResponse) (Credential, error) {
+	err := c.verifyClientData(r.ClientDataJSON, "webauthn.create", challenge)
+	if err != nil {
+		return Credential{}, err
+	}
+
+	v, _, err := decodeCBOR(r.AttestationObject)
+	if err != nil {
+		return Credential{}, fmt.Errorf("%w: %w", ErrInvalidResponse, err)
+	}
+
+	attestation, ok := v.(map[any]any)
+	if !ok {
+		return Credential{}, fmt.Errorf("%w: attestation object is not a map", ErrInvalidResponse)
+	}
+
+	// The attestation statement is not verified, there is no need to know which
+	// kind of authenticator is used.
+	rawAuthData, ok := attestation["authData"].([]byte)
+	if !ok {
+		return Credential{}, fmt.Errorf("%w: missing authenticator data", ErrInvalidResponse)
+	}
+
+	authData, err := c.parseAuthData(rawAuthData)
+	if err != nil {
+		return Credential{}, err
+	}
+
+	if authData.flags&flagAttestedCredData == 0 {
+		return Credential{}, fmt.Errorf("%w: missing attested credential data", ErrInvalidResponse)
+	}
+
+	_, err = parsePublicKey(authData.publicKey)
+	if err != nil {
+		return Credential{}, err
+	}
+
+	return Credential{
+		ID:        authData.credentialID,
+		PublicKey: authData.publicKey,
+		SignCount: authData.signCount,
+	}, nil
+}
+
+// VerifyAssertion verifies the response to RequestOptions with the provided challenge,
+// for the credential that was registered earlier. It returns the new signature counter
+// of the credential, which should be stored.
+func (c Config) VerifyAssertion(challenge []byte, cred Credential, r AssertionResponse) (uint32, error) {
+	if !bytes.Equal(r.CredentialID, cred.ID) {
+		return 0, fmt.Errorf("%w: credential mismatch", ErrInvalidResponse)
+	}
+
+	err := c.verifyClientData(r.ClientDataJSON, "webauthn.get", challenge)
+	if err != nil {
+		return 0, err
+	}
+
+	authData, err := c.parseAuthData(r.AuthenticatorData)
+	if err != nil {
+		return 0, err
+	}
+
+	pub, err := parsePublicKey(cred.PublicKey)
+	if err != nil {
+		return 0, err
+	}
+
+	clientDataHash := sha256.Sum256(r.ClientDataJSON)
+	signed := sha256.Sum256(append(append([]byte(nil), r.AuthenticatorData...), clientDataHash[:]...))
+	if !ecdsa.VerifyASN1(pub, signed[:], r.Signature) {
+		return 0, fmt.Errorf("%w: invalid signature", ErrInvalidResponse)
+	}
+
+	// Authenticators that don't implement a counter always report zero.
+	if (authData.signCount != 0 || cred.SignCount != 0) && authData.signCount <= cred.SignCount {
+		return 0, ErrSignCount
+	}
+
+	return authData.signCount, nil
+}
+
+type clientData struct {
+	Type      string `json:"type"`
+	Challenge string `json:"challenge"`
+	Origin    string `json:"origin"`
+}
+
+func (c Config) verifyClientData(raw []byte, typ string, challenge []byte) error {
+	var cd clientData
+	err := json.Unmarshal(raw, &cd)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrInvalidResponse, err)
+	}
+
+	if cd.Type != typ {
+		return fmt.Errorf("%w: unexpected type %q", ErrInvalidResponse, cd.Type)
+	}
+
+	got, err := base64.RawURLEncoding.DecodeString(cd.Challenge)
+	if err != nil || len(challenge) == 0 || subtle.ConstantTimeCompare(got, challenge) != 1 {
+		return fmt.Errorf("%w: challenge mismatch", ErrInvalidResponse)
+	}
+
+	if cd.Origin != c.Origin {
+		return fmt.Errorf("%w: unexpected origin %q", ErrInvalidResponse, cd.Origin)
+	}
+
+	return nil
+}
+
+type authData struct {
+	flags        byte
+	signCount    uint32
+	credentialID []byte
+	publicKey    []byte
+}
+
+// parseAuthData parses and checks authenticator data.
+func (c Config) parseAuthData(b []byte) (authData, error) {
+	// rpIdHash (32) + flags (1) + signCount (4)
+	if len(b) < 37 {
+		return authData{}, fmt.Errorf("%w: authenticator data too short", ErrInvalidResponse)
+	}
+
+	rpIDHash := sha256.Sum256([]byte(c.RPID))
+	if subtle.ConstantTimeCompare(b[:32], rpIDHash[:]) != 1 {
+		return authData{}, fmt.Errorf("%w: relying party mismatch", ErrInvalidResponse)
+	}
+
+	out := authData{
+		flags:     b[32],
+		signCount: binary.BigEndian.Uint32(b[33:37]),
+	}
+
+	if out.flags&flagUserPresent == 0 || out.flags&flagUserVerified == 0 {
+		return authData{}, fmt.Errorf("%w: user not present or verified", ErrInvalidResponse)
+	}
+
+	if out.flags&flagAttestedCredData == 0 {
+		return out, nil
+	}
+
+	// aaguid (16) + credentialIdLength (2)
+	rest := b[37:]
+	if len(rest) < 18 {
+		return authData{}, fmt.Errorf("%w: attested credential data too short", ErrInvalidResponse)
+	}
+
+	idLen := int(binary.BigEndian.Uint16(rest[16:18]))
+	rest = rest[18:]
+	if idLen == 0 || idLen > maxCredentialIDLen || len(rest) < idLen {
+		return authData{}, fmt.Errorf("%w: invalid credential id", ErrInvalidResponse)
+	}
+
+	out.credentialID = append([]byte(nil), rest[:idLen]...)
+	rest = rest[idLen:]
+
+	// The public key is followed by extensions if the ED flag is set, these are ignored.
+	_, after, err := decodeCBOR(rest)
+	if err != nil {
+		return authData{}, fmt.Errorf("%w: %w", ErrInvalidResponse, err)
+	}
+
+	out.publicKey = append([]byte(nil), rest[:len(rest)-len(after)]...)
+	return out, nil
+}
+
+// parsePublicKey parses a COSE encoded ES256 public key.
+func parsePublicKey(b []byte) (*ecdsa.PublicKey, error) {
+	v, _, err := decodeCBOR(b)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidResponse, err)
+	}
+
+	key, ok := v.(map[any]any)
+	if !ok {
+		return nil, fmt.Errorf("%w: public key is not a map", ErrInvalidResponse)
+	}
+
+	// See RFC 9053: kty (1) is EC2 (2), alg (3) is ES256 and crv (-1) is P-256 (1).
+	if key[int64(1)] != int64(2) || key[int64(3)] != int64(algES256) || key[int64(-1)] != int64(1) {
+		return nil, fmt.Errorf("%w: unsupported public key", ErrInvalidResponse)
+	}
+
+	x, okX := key[int64(-2)].([]byte)
+	y, okY := key[int64(-3)].([]byte)
+	if !okX || !okY || len(x) != 32 || len(y) != 32 {
+		return nil, fmt.Errorf("%w: invalid public key coordinates", ErrInvalidResponse)
+	}
+
+	// Let crypto/ecdh check that the point is on the curve.
+	uncompressed := append(append([]byte{0x04}, x...), y...)
+	_, err = ecdh.P256().NewPublicKey(uncompressed)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidResponse, err)
+	}
+
+	return &ecdsa.PublicKey{
+		Curve: elliptic.P256(),
+		X:     new(big.Int).SetBytes(x),
+		Y:     new(big.Int).SetBytes(y),
+	}, nil
+}
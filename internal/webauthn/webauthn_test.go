@@ -0,0 +1,271 @@
+package webauthn_test
+
+import (
+	"bytes"
+	"errors"
+	"net/url"
+	"testing"
+
+	"github.com/willemschots/househunt/internal/webauthn"
+	"github.com/willemschots/househunt/internal/webauthn/webauthntest"
+)
+
+var cfg = webauthn.ConfigFromURL("Househunt", must(url.Parse("https://example.com:8443/path")))
+
+func Test_ConfigFromURL(t *testing.T) {
+	want := webauthn.Config{
+		RPID:   "example.com",
+		RPName: "Househunt",
+		Origin: "https://example.com:8443",
+	}
+
+	if cfg != want {
+		t.Errorf("got %+v, want %+v", cfg, want)
+	}
+}
+
+func Test_Bytes(t *testing.T) {
+	in := webauthn.Bytes{0xfb, 0xff, 0x01}
+
+	text, err := in.MarshalText()
+	if err != nil {
+		t.Fatalf("failed to marshal: %v", err)
+	}
+
+	if string(text) != "-_8B" {
+		t.Errorf("expected unpadded base64url, got %q", text)
+	}
+
+	var out webauthn.Bytes
+	err = out.UnmarshalText(text)
+	if err != nil {
+		t.Fatalf("failed to unmarshal: %v", err)
+	}
+
+	if !bytes.Equal(in, out) {
+		t.Errorf("got %x, want %x", out, in)
+	}
+
+	err = out.UnmarshalText([]byte("-_8B=="))
+	if !errors.Is(err, webauthn.ErrInvalidResponse) {
+		t.Errorf("expected error %v, got %v (via errors.Is)", webauthn.ErrInvalidResponse, err)
+	}
+}
+
+func Test_Config_VerifyRegistration(t *testing.T) {
+	t.Run("ok", func(t *testing.T) {
+		a, challenge, resp := register(t, cfg.Origin)
+
+		cred, err := cfg.VerifyRegistration(challenge, resp)
+		if err != nil {
+			t.Fatalf("failed to verify: %v", err)
+		}
+
+		if !bytes.Equal(cred.ID, a.CredentialID()) || len(cred.PublicKey) == 0 || cred.SignCount != 0 {
+			t.Errorf("unexpected credential: %+v", cred)
+		}
+	})
+
+	failTests := map[string]func(challenge []byte, resp *webauthn.RegistrationResponse) (webauthn.Config, []byte){
+		"fail, other challenge": func(_ []byte, _ *webauthn.RegistrationResponse) (webauthn.Config, []byte) {
+			return cfg, must(webauthn.GenerateChallenge())
+		},
+		"fail, empty challenge": func(_ []byte, _ *webauthn.RegistrationResponse) (webauthn.Config, []byte) {
+			return cfg, nil
+		},
+		"fail, other origin": func(challenge []byte, _ *webauthn.RegistrationResponse) (webauthn.Config, []byte) {
+			c := cfg
+			c.Origin = "https://evil.example.com"
+			return c, challenge
+		},
+		"fail, other relying party": func(challenge []byte, _ *webauthn.RegistrationResponse) (webauthn.Config, []byte) {
+			c := cfg
+			c.RPID = "evil.example.com"
+			return c, challenge
+		},
+		"fail, invalid client data": func(challenge []byte, resp *webauthn.RegistrationResponse) (webauthn.Config, []byte) {
+			resp.ClientDataJSON = []byte("{")
+			return cfg, challenge
+		},
+		"fail, invalid attestation object": func(challenge []byte, resp *webauthn.RegistrationResponse) (webauthn.Config, []byte) {
+			resp.AttestationObject = resp.AttestationObject[:len(resp.AttestationObject)-1]
+			return cfg, challenge
+		},
+		"fail, empty attestation object": func(challenge []byte, resp *webauthn.RegistrationResponse) (webauthn.Config, []byte) {
+			resp.AttestationObject = nil
+			return cfg, challenge
+		},
+	}
+
+	for name, modify := range failTests {
+		t.Run(name, func(t *testing.T) {
+			_, challenge, resp := register(t, cfg.Origin)
+
+			c, challenge := modify(challenge, &resp)
+
+			_, err := c.VerifyRegistration(challenge, resp)
+			if !errors.Is(err, webauthn.ErrInvalidResponse) {
+				t.Errorf("expected error %v, got %v (via errors.Is)", webauthn.ErrInvalidResponse, err)
+			}
+		})
+	}
+}
+
+func Test_Config_VerifyAssertion(t *testing.T) {
+	t.Run("ok, counter increases", func(t *testing.T) {
+		a, cred := registered(t)
+
+		for want := uint32(1); want <= 3; want++ {
+			got := assertLogin(t, a, cred)
+			if got != want {
+				t.Fatalf("expected sign count %d, got %d", want, got)
+			}
+			cred.SignCount = got
+		}
+	})
+
+	t.Run("ok, authenticator without counter", func(t *testing.T) {
+		a, cred := registered(t)
+
+		for i := 0; i < 2; i++ {
+			// Login increments the counter before signing, so this results in zero.
+			a.SignCount = ^uint32(0)
+
+			got := assertLogin(t, a, cred)
+			if got != 0 {
+				t.Fatalf("expected sign count 0, got %d", got)
+			}
+		}
+	})
+
+	t.Run("fail, counter did not increase", func(t *testing.T) {
+		a, cred := registered(t)
+		cred.SignCount = 5
+		a.SignCount = 4
+
+		challenge := must(webauthn.GenerateChallenge())
+		resp := must(a.Login(cfg.RequestOptions(challenge)))
+
+		_, err := cfg.VerifyAssertion(challenge, cred, resp)
+		if !errors.Is(err, webauthn.ErrSignCount) {
+			t.Errorf("expected error %v, got %v (via errors.Is)", webauthn.ErrSignCount, err)
+		}
+	})
+
+	failTests := map[string]func(challenge []byte, resp *webauthn.AssertionResponse) (webauthn.Config, []byte){
+		"fail, other challenge": func(_ []byte, _ *webauthn.AssertionResponse) (webauthn.Config, []byte) {
+			return cfg, must(webauthn.GenerateChallenge())
+		},
+		"fail, other origin": func(challenge []byte, _ *webauthn.AssertionResponse) (webauthn.Config, []byte) {
+			c := cfg
+			c.Origin = "https://evil.example.com"
+			return c, challenge
+		},
+		"fail, other relying party": func(challenge []byte, _ *webauthn.AssertionResponse) (webauthn.Config, []byte) {
+			c := cfg
+			c.RPID = "evil.example.com"
+			return c, challenge
+		},
+		"fail, other credential": func(challenge []byte, resp *webauthn.AssertionResponse) (webauthn.Config, []byte) {
+			resp.CredentialID = []byte("other")
+			return cfg, challenge
+		},
+		"fail, tampered authenticator data": func(challenge []byte, resp *webauthn.AssertionResponse) (webauthn.Config, []byte) {
+			resp.AuthenticatorData[len(resp.AuthenticatorData)-1]++
+			return cfg, challenge
+		},
+		"fail, short authenticator data": func(challenge []byte, resp *webauthn.AssertionResponse) (webauthn.Config, []byte) {
+			resp.AuthenticatorData = resp.AuthenticatorData[:36]
+			return cfg, challenge
+		},
+		"fail, user not verified": func(challenge []byte, resp *webauthn.AssertionResponse) (webauthn.Config, []byte) {
+			resp.AuthenticatorData[32] &^= 0x04
+			return cfg, challenge
+		},
+		"fail, invalid signature": func(challenge []byte, resp *webauthn.AssertionResponse) (webauthn.Config, []byte) {
+			resp.Signature = []byte("invalid")
+			return cfg, challenge
+		},
+		"fail, wrong type": func(challenge []byte, resp *webauthn.AssertionResponse) (webauthn.Config, []byte) {
+			resp.ClientDataJSON = bytes.Replace(resp.ClientDataJSON, []byte("webauthn.get"), []byte("webauthn.create"), 1)
+			return cfg, challenge
+		},
+	}
+
+	for name, modify := range failTests {
+		t.Run(name, func(t *testing.T) {
+			a, cred := registered(t)
+
+			challenge := must(webauthn.GenerateChallenge())
+			resp := must(a.Login(cfg.RequestOptions(challenge)))
+
+			c, challenge := modify(challenge, &resp)
+
+			_, err := c.VerifyAssertion(challenge, cred, resp)
+			if !errors.Is(err, webauthn.ErrInvalidResponse) {
+				t.Errorf("expected error %v, got %v (via errors.Is)", webauthn.ErrInvalidResponse, err)
+			}
+		})
+	}
+}
+
+func register(t *testing.T, origin string) (*webauthntest.Authenticator, []byte, webauthn.RegistrationResponse) {
+	t.Helper()
+
+	a, err := webauthntest.New(origin)
+	if err != nil {
+		t.Fatalf("failed to create authenticator: %v", err)
+	}
+
+	challenge := must(webauthn.GenerateChallenge())
+	opts := cfg.CreationOptions(challenge, []byte("user-id"), "info@example.com", nil)
+
+	resp, err := a.Register(opts)
+	if err != nil {
+		t.Fatalf("failed to register: %v", err)
+	}
+
+	return a, challenge, resp
+}
+
+func registered(t *testing.T) (*webauthntest.Authenticator, webauthn.Credential) {
+	t.Helper()
+
+	a, challenge, resp := register(t, cfg.Origin)
+
+	cred, err := cfg.VerifyRegistration(challenge, resp)
+	if err != nil {
+		t.Fatalf("failed to verify registration: %v", err)
+	}
+
+	return a, cred
+}
+
+func assertLogin(t *testing.T, a *webauthntest.Authenticator, cred webauthn.Credential) uint32 {
+	t.Helper()
+
+	challenge := must(webauthn.GenerateChallenge())
+
+	resp, err := a.Login(cfg.RequestOptions(challenge))
+	if err != nil {
+		t.Fatalf("failed to login: %v", err)
+	}
+
+	if !bytes.Equal(resp.UserHandle, []byte("user-id")) {
+		t.Fatalf("unexpected user handle %q", resp.UserHandle)
+	}
+
+	got, err := cfg.VerifyAssertion(challenge, cred, resp)
+	if err != nil {
+		t.Fatalf("failed to verify assertion: %v", err)
+	}
+
+	return got
+}
+
+func must[T any](v T, err error) T {
+	if err != nil {
+		panic(err)
+	}
+	return v
+}
@@ -0,0 +1,185 @@
+// Package webauthntest provides a software authenticator, so that the WebAuthn
+// ceremonies can be tested without a browser.
+package webauthntest
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"sort"
+
+	"github.com/willemschots/househunt/internal/webauthn"
+)
+
+// Authenticator is a software authenticator holding a single ES256 credential.
+// It always reports that the user is present and verified.
+type Authenticator struct {
+	origin       string
+	key          *ecdsa.PrivateKey
+	credentialID []byte
+	userHandle   []byte
+
+	// SignCount is the signature counter, it is incremented before each signature.
+	// Exposed for testing purposes.
+	SignCount uint32
+}
+
+// New creates a new authenticator that acts like a browser on origin.
+func New(origin string) (*Authenticator, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+
+	id := make([]byte, 16)
+	_, err = rand.Read(id)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Authenticator{
+		origin:       origin,
+		key:          key,
+		credentialID: id,
+	}, nil
+}
+
+// CredentialID returns the ID of the credential held by the authenticator.
+func (a *Authenticator) CredentialID() []byte {
+	return a.credentialID
+}
+
+// Register creates the response to opts, like navigator.credentials.create() would.
+func (a *Authenticator) Register(opts webauthn.CreationOptions) (webauthn.RegistrationResponse, error) {
+	clientDataJSON, err := a.clientData("webauthn.create", opts.Challenge)
+	if err != nil {
+		return webauthn.RegistrationResponse{}, err
+	}
+
+	a.userHandle = opts.User.ID
+
+	// COSE key, see RFC 9053.
+	publicKey := encodeMap(map[int64]any{
+		1:  int64(2),  // kty: EC2
+		3:  int64(-7), // alg: ES256
+		-1: int64(1),  // crv: P-256
+		-2: a.key.X.FillBytes(make([]byte, 32)),
+		-3: a.key.Y.FillBytes(make([]byte, 32)),
+	})
+
+	authData := a.authData(opts.RP.ID, 0x40)
+	authData = append(authData, make([]byte, 16)...) // aaguid
+	authData = binary.BigEndian.AppendUint16(authData, uint16(len(a.credentialID)))
+	authData = append(authData, a.credentialID...)
+	authData = append(authData, publicKey...)
+
+	attestationObject := encodeMap(map[string]any{
+		"fmt":      "none",
+		"attStmt":  map[string]any{},
+		"authData": authData,
+	})
+
+	return webauthn.RegistrationResponse{
+		ClientDataJSON:    clientDataJSON,
+		AttestationObject: attestationObject,
+	}, nil
+}
+
+// Login creates the response to opts, like navigator.credentials.get() would.
+func (a *Authenticator) Login(opts webauthn.RequestOptions) (webauthn.AssertionResponse, error) {
+	clientDataJSON, err := a.clientData("webauthn.get", opts.Challenge)
+	if err != nil {
+		return webauthn.AssertionResponse{}, err
+	}
+
+	a.SignCount++
+	authData := a.authData(opts.RPID, 0)
+
+	clientDataHash := sha256.Sum256(clientDataJSON)
+	signed := sha256.Sum256(append(append([]byte(nil), authData...), clientDataHash[:]...))
+
+	sig, err := ecdsa.SignASN1(rand.Reader, a.key, signed[:])
+	if err != nil {
+		return webauthn.AssertionResponse{}, err
+	}
+
+	return webauthn.AssertionResponse{
+		CredentialID:      a.credentialID,
+		ClientDataJSON:    clientDataJSON,
+		AuthenticatorData: authData,
+		Signature:         sig,
+		UserHandle:        a.userHandle,
+	}, nil
+}
+
+func (a *Authenticator) clientData(typ string, challenge []byte) ([]byte, error) {
+	return json.Marshal(map[string]any{
+		"type":        typ,
+		"challenge":   base64.RawURLEncoding.EncodeToString(challenge),
+		"origin":      a.origin,
+		"crossOrigin": false,
+	})
+}
+
+func (a *Authenticator) authData(rpID string, flags byte) []byte {
+	rpIDHash := sha256.Sum256([]byte(rpID))
+
+	out := append([]byte(nil), rpIDHash[:]...)
+	out = append(out, flags|0x01|0x04) // user present and verified
+	return binary.BigEndian.AppendUint32(out, a.SignCount)
+}
+
+// encodeMap encodes a map in CBOR, only the types used by the authenticator are supported.
+func encodeMap[K int64 | string](m map[K]any) []byte {
+	keys := make([]K, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i] < keys[j] })
+
+	out := encodeHead(5, uint64(len(m)))
+	for _, k := range keys {
+		out = append(out, encodeValue(k)...)
+		out = append(out, encodeValue(m[k])...)
+	}
+	return out
+}
+
+func encodeValue(v any) []byte {
+	switch v := v.(type) {
+	case int64:
+		if v < 0 {
+			return encodeHead(1, uint64(-1-v))
+		}
+		return encodeHead(0, uint64(v))
+	case []byte:
+		return append(encodeHead(2, uint64(len(v))), v...)
+	case string:
+		return append(encodeHead(3, uint64(len(v))), v...)
+	case map[string]any:
+		return encodeMap(v)
+	default:
+		panic(fmt.Sprintf("unsupported cbor type %T", v))
+	}
+}
+
+func encodeHead(major byte, n uint64) []byte {
+	major <<= 5
+	switch {
+	case n < 24:
+		return []byte{major | byte(n)}
+	case n <= 0xff:
+		return []byte{major | 24, byte(n)}
+	case n <= 0xffff:
+		return binary.BigEndian.AppendUint16([]byte{major | 25}, uint16(n))
+	case n <= 0xffffffff:
+		return binary.BigEndian.AppendUint32([]byte{major | 26}, uint32(n))
+	default:
+		return binary.BigEndian.AppendUint64([]byte{major | 27}, n)
+	}
+}
@@ -0,0 +1,14 @@
+CREATE TABLE listings (
+    id           TEXT PRIMARY KEY,
+    agent_id     TEXT NOT NULL,
+    title        TEXT NOT NULL,
+    address      TEXT NOT NULL,
+    description  TEXT NOT NULL,
+    price        INTEGER NOT NULL,
+    published_at TIMESTAMP,
+    created_at   TIMESTAMP NOT NULL,
+    updated_at   TIMESTAMP NOT NULL,
+    FOREIGN KEY(agent_id) REFERENCES users(id)
+);
+
+CREATE INDEX listings_agent_id ON listings(agent_id);
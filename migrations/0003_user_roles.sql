@@ -0,0 +1,2 @@
+-- Up until now only agents could register.
+ALTER TABLE users ADD COLUMN role TEXT NOT NULL DEFAULT 'agent';
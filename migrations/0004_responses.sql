@@ -0,0 +1,14 @@
+CREATE TABLE responses (
+    id         TEXT PRIMARY KEY,
+    listing_id TEXT NOT NULL,
+    hunter_id  TEXT NOT NULL,
+    message    TEXT NOT NULL,
+    status     TEXT NOT NULL,
+    created_at TIMESTAMP NOT NULL,
+    updated_at TIMESTAMP NOT NULL,
+    FOREIGN KEY(listing_id) REFERENCES listings(id) ON DELETE CASCADE,
+    FOREIGN KEY(hunter_id) REFERENCES users(id)
+);
+
+CREATE INDEX responses_listing_id ON responses(listing_id);
+CREATE INDEX responses_hunter_id ON responses(hunter_id);
@@ -0,0 +1,15 @@
+CREATE TABLE email_outbox (
+    id                  TEXT PRIMARY KEY,
+    from_address        TEXT NOT NULL,
+    recipient_encrypted TEXT NOT NULL,
+    subject_encrypted   TEXT NOT NULL,
+    body_encrypted      TEXT NOT NULL,
+    status              TEXT NOT NULL,
+    attempts            INTEGER NOT NULL,
+    next_attempt_at     TIMESTAMP NOT NULL,
+    last_error          TEXT NOT NULL,
+    created_at          TIMESTAMP NOT NULL,
+    updated_at          TIMESTAMP NOT NULL
+);
+
+CREATE INDEX email_outbox_status_next_attempt_at ON email_outbox(status, next_attempt_at);
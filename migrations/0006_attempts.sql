@@ -0,0 +1,8 @@
+CREATE TABLE attempts (
+    id                TEXT PRIMARY KEY,
+    email_blind_index TEXT NOT NULL,
+    purpose           TEXT NOT NULL,
+    created_at        TIMESTAMP NOT NULL
+);
+
+CREATE INDEX attempts_email_blind_index_purpose ON attempts(email_blind_index, purpose, created_at);
@@ -0,0 +1,11 @@
+CREATE TABLE sessions (
+    id         TEXT PRIMARY KEY,
+    user_id    TEXT,
+    data       BLOB NOT NULL,
+    created_at TIMESTAMP NOT NULL,
+    updated_at TIMESTAMP NOT NULL,
+    expires_at TIMESTAMP NOT NULL,
+    FOREIGN KEY(user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE INDEX sessions_user_id ON sessions(user_id);
@@ -0,0 +1,20 @@
+CREATE TABLE totp_credentials (
+    user_id          TEXT PRIMARY KEY,
+    secret_encrypted TEXT NOT NULL,
+    last_used_step   INTEGER NOT NULL,
+    confirmed_at     TIMESTAMP,
+    created_at       TIMESTAMP NOT NULL,
+    updated_at       TIMESTAMP NOT NULL,
+    FOREIGN KEY(user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE TABLE recovery_codes (
+    id         TEXT PRIMARY KEY,
+    user_id    TEXT NOT NULL,
+    code_hash  TEXT NOT NULL,
+    created_at TIMESTAMP NOT NULL,
+    used_at    TIMESTAMP,
+    FOREIGN KEY(user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE INDEX recovery_codes_user_id ON recovery_codes(user_id);
@@ -0,0 +1,3 @@
+-- NULL for messages without an HTML body, including all messages queued before
+-- emails could have one.
+ALTER TABLE email_outbox ADD COLUMN html_body_encrypted TEXT;
@@ -0,0 +1,14 @@
+-- The encrypted email allows rebuilding the blind index when the blind index key is rotated.
+CREATE TABLE email_bounces (
+    id                TEXT PRIMARY KEY,
+    user_id           TEXT NOT NULL,
+    email_encrypted   TEXT NOT NULL,
+    email_blind_index TEXT NOT NULL,
+    kind              TEXT NOT NULL,
+    bounced_at        TIMESTAMP NOT NULL,
+    created_at        TIMESTAMP NOT NULL,
+    FOREIGN KEY(user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE INDEX email_bounces_email_blind_index ON email_bounces(email_blind_index);
+CREATE INDEX email_bounces_user_id ON email_bounces(user_id);
@@ -0,0 +1,42 @@
+-- Listings and responses are deleted together with the user they belong to.
+-- SQLite can't alter foreign keys, so both tables are recreated. The old tables
+-- are renamed first, which also updates the foreign key of responses to point to
+-- the renamed listings. Dropping them in this order doesn't cascade into the new
+-- tables.
+ALTER TABLE listings RENAME TO listings_old;
+ALTER TABLE responses RENAME TO responses_old;
+
+CREATE TABLE listings (
+    id           TEXT PRIMARY KEY,
+    agent_id     TEXT NOT NULL,
+    title        TEXT NOT NULL,
+    address      TEXT NOT NULL,
+    description  TEXT NOT NULL,
+    price        INTEGER NOT NULL,
+    published_at TIMESTAMP,
+    created_at   TIMESTAMP NOT NULL,
+    updated_at   TIMESTAMP NOT NULL,
+    FOREIGN KEY(agent_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE TABLE responses (
+    id         TEXT PRIMARY KEY,
+    listing_id TEXT NOT NULL,
+    hunter_id  TEXT NOT NULL,
+    message    TEXT NOT NULL,
+    status     TEXT NOT NULL,
+    created_at TIMESTAMP NOT NULL,
+    updated_at TIMESTAMP NOT NULL,
+    FOREIGN KEY(listing_id) REFERENCES listings(id) ON DELETE CASCADE,
+    FOREIGN KEY(hunter_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+INSERT INTO listings SELECT id, agent_id, title, address, description, price, published_at, created_at, updated_at FROM listings_old;
+INSERT INTO responses SELECT id, listing_id, hunter_id, message, status, created_at, updated_at FROM responses_old;
+
+DROP TABLE responses_old;
+DROP TABLE listings_old;
+
+CREATE INDEX listings_agent_id ON listings(agent_id);
+CREATE INDEX responses_listing_id ON responses(listing_id);
+CREATE INDEX responses_hunter_id ON responses(hunter_id);
@@ -0,0 +1,6 @@
+-- The last error of a message can contain the recipient, for example when the email
+-- server quotes it in a rejection, so it's encrypted like the other columns. NULL if
+-- there is no error. Errors of earlier attempts are dropped, they were only kept to
+-- debug delivery.
+ALTER TABLE email_outbox ADD COLUMN last_error_encrypted TEXT;
+ALTER TABLE email_outbox DROP COLUMN last_error;
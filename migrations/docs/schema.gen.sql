@@ -12,7 +12,7 @@ CREATE TABLE users(
     is_active         INTEGER NOT NULL,
     created_at        TIMESTAMP NOT NULL,
     updated_at        TIMESTAMP NOT NULL
-);
+, role TEXT NOT NULL DEFAULT 'agent');
 CREATE TABLE email_tokens (
     id              TEXT PRIMARY KEY,
     token_hash      TEXT NOT NULL,
@@ -23,3 +23,112 @@ CREATE TABLE email_tokens (
     consumed_at     TIMESTAMP,
     FOREIGN KEY(user_id) REFERENCES users(id)
 );
+CREATE TABLE email_outbox (
+    id                  TEXT PRIMARY KEY,
+    from_address        TEXT NOT NULL,
+    recipient_encrypted TEXT NOT NULL,
+    subject_encrypted   TEXT NOT NULL,
+    body_encrypted      TEXT NOT NULL,
+    status              TEXT NOT NULL,
+    attempts            INTEGER NOT NULL,
+    next_attempt_at     TIMESTAMP NOT NULL,
+    created_at          TIMESTAMP NOT NULL,
+    updated_at          TIMESTAMP NOT NULL
+, html_body_encrypted TEXT, last_error_encrypted TEXT);
+CREATE INDEX email_outbox_status_next_attempt_at ON email_outbox(status, next_attempt_at);
+CREATE TABLE attempts (
+    id                TEXT PRIMARY KEY,
+    email_blind_index TEXT NOT NULL,
+    purpose           TEXT NOT NULL,
+    created_at        TIMESTAMP NOT NULL
+);
+CREATE INDEX attempts_email_blind_index_purpose ON attempts(email_blind_index, purpose, created_at);
+CREATE TABLE sessions (
+    id         TEXT PRIMARY KEY,
+    user_id    TEXT,
+    data       BLOB NOT NULL,
+    created_at TIMESTAMP NOT NULL,
+    updated_at TIMESTAMP NOT NULL,
+    expires_at TIMESTAMP NOT NULL, user_agent TEXT NOT NULL DEFAULT '', ip TEXT NOT NULL DEFAULT '', last_seen_at TIMESTAMP,
+    FOREIGN KEY(user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+CREATE INDEX sessions_user_id ON sessions(user_id);
+CREATE TABLE totp_credentials (
+    user_id          TEXT PRIMARY KEY,
+    secret_encrypted TEXT NOT NULL,
+    last_used_step   INTEGER NOT NULL,
+    confirmed_at     TIMESTAMP,
+    created_at       TIMESTAMP NOT NULL,
+    updated_at       TIMESTAMP NOT NULL,
+    FOREIGN KEY(user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+CREATE TABLE recovery_codes (
+    id         TEXT PRIMARY KEY,
+    user_id    TEXT NOT NULL,
+    code_hash  TEXT NOT NULL,
+    created_at TIMESTAMP NOT NULL,
+    used_at    TIMESTAMP,
+    FOREIGN KEY(user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+CREATE INDEX recovery_codes_user_id ON recovery_codes(user_id);
+CREATE TABLE passkeys (
+    id            TEXT PRIMARY KEY,
+    user_id       TEXT NOT NULL,
+    credential_id BLOB NOT NULL UNIQUE,
+    public_key    BLOB NOT NULL,
+    sign_count    INTEGER NOT NULL,
+    created_at    TIMESTAMP NOT NULL,
+    last_used_at  TIMESTAMP,
+    FOREIGN KEY(user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+CREATE INDEX passkeys_user_id ON passkeys(user_id);
+CREATE TABLE api_tokens (
+    id           TEXT PRIMARY KEY,
+    user_id      TEXT NOT NULL,
+    name         TEXT NOT NULL,
+    scopes       TEXT NOT NULL,
+    token_hash   TEXT NOT NULL,
+    expires_at   TIMESTAMP NOT NULL,
+    last_used_at TIMESTAMP,
+    created_at   TIMESTAMP NOT NULL,
+    FOREIGN KEY(user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+CREATE INDEX api_tokens_user_id ON api_tokens(user_id);
+CREATE TABLE email_bounces (
+    id                TEXT PRIMARY KEY,
+    user_id           TEXT NOT NULL,
+    email_encrypted   TEXT NOT NULL,
+    email_blind_index TEXT NOT NULL,
+    kind              TEXT NOT NULL,
+    bounced_at        TIMESTAMP NOT NULL,
+    created_at        TIMESTAMP NOT NULL,
+    FOREIGN KEY(user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+CREATE INDEX email_bounces_email_blind_index ON email_bounces(email_blind_index);
+CREATE INDEX email_bounces_user_id ON email_bounces(user_id);
+CREATE TABLE listings (
+    id           TEXT PRIMARY KEY,
+    agent_id     TEXT NOT NULL,
+    title        TEXT NOT NULL,
+    address      TEXT NOT NULL,
+    description  TEXT NOT NULL,
+    price        INTEGER NOT NULL,
+    published_at TIMESTAMP,
+    created_at   TIMESTAMP NOT NULL,
+    updated_at   TIMESTAMP NOT NULL,
+    FOREIGN KEY(agent_id) REFERENCES users(id) ON DELETE CASCADE
+);
+CREATE TABLE responses (
+    id         TEXT PRIMARY KEY,
+    listing_id TEXT NOT NULL,
+    hunter_id  TEXT NOT NULL,
+    message    TEXT NOT NULL,
+    status     TEXT NOT NULL,
+    created_at TIMESTAMP NOT NULL,
+    updated_at TIMESTAMP NOT NULL,
+    FOREIGN KEY(listing_id) REFERENCES listings(id) ON DELETE CASCADE,
+    FOREIGN KEY(hunter_id) REFERENCES users(id) ON DELETE CASCADE
+);
+CREATE INDEX listings_agent_id ON listings(agent_id);
+CREATE INDEX responses_listing_id ON responses(listing_id);
+CREATE INDEX responses_hunter_id ON responses(hunter_id);